@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cliConfig is persisted to ~/.registryx/config.json after a successful login.
+type cliConfig struct {
+	Server   string `json:"server"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+func configPath() (string, error) {
+	if p := os.Getenv("REGISTRYX_CONFIG"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".registryx", "config.json"), nil
+}
+
+func loadConfig() (*cliConfig, error) {
+	cfg := &cliConfig{}
+	p, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", p, err)
+		}
+	}
+
+	// Environment always wins over the saved config so scripts can override it.
+	if s := os.Getenv("REGISTRYX_URL"); s != "" {
+		cfg.Server = s
+	}
+	if t := os.Getenv("REGISTRYX_TOKEN"); t != "" {
+		cfg.Token = t
+	}
+	if cfg.Server == "" {
+		cfg.Server = "http://localhost:5000"
+	}
+	cfg.Server = strings.TrimRight(cfg.Server, "/")
+	return cfg, nil
+}
+
+func saveConfig(cfg *cliConfig) error {
+	p, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0600)
+}
+
+// client is a thin wrapper around the RegistryX HTTP API.
+type client struct {
+	cfg  *cliConfig
+	http *http.Client
+}
+
+func newClient(cfg *cliConfig) *client {
+	return &client{cfg: cfg, http: &http.Client{Timeout: 60 * time.Second}}
+}
+
+// do sends a request and decodes a JSON response into out (if non-nil).
+// body may be nil, an io.Reader (sent as-is) or any value (JSON encoded).
+func (c *client) do(method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+		contentType = "text/plain"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, c.cfg.Server+path, reader)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("unauthorized (run 'registryx login' first)")
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	if out != nil && len(data) > 0 {
+		if raw, ok := out.(*[]byte); ok {
+			*raw = data
+			return nil
+		}
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,438 @@
+// Command registryx is a small CLI for scripting against the RegistryX API.
+//
+// Usage:
+//
+//	registryx login [-u user] [-p password | --password-stdin] [server]
+//	registryx repo list
+//	registryx tag list <repository>
+//	registryx scan trigger|status <repository> <reference>
+//	registryx policy get | policy set <file|->
+//	registryx gc [--dry-run]
+//	registryx fsck [--repair]
+//	registryx retention preview <repository>
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `Usage: registryx <command> [arguments]
+
+Commands:
+  login [-u user] [-p password | --password-stdin] [server]   Authenticate and save a token
+  logout                                                      Forget the saved token
+  repo list [--json]                                          List repositories
+  tag list <repository> [--json]                              List tags of a repository
+  scan trigger <repository> <reference>                       Trigger a vulnerability scan
+  scan status <repository> <reference> [--json]               Show scan status
+  policy get                                                  Print the active Rego policy
+  policy set <file|->                                         Replace the Rego policy
+  gc [--dry-run]                                              Run garbage collection
+  fsck [--repair] [--json]                                    Check database and storage consistency
+  retention preview <repository> [--json]                     List what the retention policy would delete
+
+Environment:
+  REGISTRYX_URL     API base URL (default http://localhost:5000)
+  REGISTRYX_TOKEN   Bearer token, overrides the saved login
+  REGISTRYX_CONFIG  Config file path (default ~/.registryx/config.json)
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	c := newClient(cfg)
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "login":
+		err = runLogin(c, args)
+	case "logout":
+		err = runLogout(c)
+	case "repo", "repos":
+		err = runRepo(c, args)
+	case "tag", "tags":
+		err = runTag(c, args)
+	case "scan":
+		err = runScan(c, args)
+	case "policy":
+		err = runPolicy(c, args)
+	case "gc":
+		err = runGC(c, args)
+	case "fsck":
+		err = runFsck(c, args)
+	case "retention":
+		err = runRetention(c, args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "registryx: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runLogin(c *client, args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	username := fs.String("u", os.Getenv("REGISTRYX_USERNAME"), "username")
+	password := fs.String("p", os.Getenv("REGISTRYX_PASSWORD"), "password")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		c.cfg.Server = strings.TrimRight(fs.Arg(0), "/")
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		fmt.Fprint(os.Stderr, "Username: ")
+		line, _ := reader.ReadString('\n')
+		*username = strings.TrimSpace(line)
+	}
+	if *passwordStdin {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		*password = strings.TrimRight(string(data), "\r\n")
+	} else if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, _ := reader.ReadString('\n')
+		*password = strings.TrimSpace(line)
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+		User  struct {
+			Username string `json:"username"`
+			Role     string `json:"role"`
+		} `json:"user"`
+	}
+	c.cfg.Token = ""
+	err := c.do("POST", "/api/v1/auth/login", map[string]string{
+		"username": *username,
+		"password": *password,
+	}, &resp)
+	if err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+
+	c.cfg.Token = resp.Token
+	c.cfg.Username = resp.User.Username
+	if err := saveConfig(c.cfg); err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	fmt.Printf("Logged in to %s as %s (%s)\n", c.cfg.Server, resp.User.Username, resp.User.Role)
+	return nil
+}
+
+func runLogout(c *client) error {
+	if c.cfg.Token != "" {
+		// Best effort: revoke the server-side session as well.
+		_ = c.do("POST", "/api/v1/auth/logout", nil, nil)
+	}
+	c.cfg.Token = ""
+	c.cfg.Username = ""
+	if err := saveConfig(c.cfg); err != nil {
+		return err
+	}
+	fmt.Println("Logged out")
+	return nil
+}
+
+func runRepo(c *client, args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return fmt.Errorf("usage: registryx repo list [--json]")
+	}
+	fs := flag.NewFlagSet("repo list", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args[1:])
+
+	var resp struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := c.do("GET", "/v2/_catalog", nil, &resp); err != nil {
+		return err
+	}
+	sort.Strings(resp.Repositories)
+	if *asJSON {
+		return printJSON(resp.Repositories)
+	}
+	for _, r := range resp.Repositories {
+		fmt.Println(r)
+	}
+	return nil
+}
+
+func runTag(c *client, args []string) error {
+	if len(args) < 2 || args[0] != "list" {
+		return fmt.Errorf("usage: registryx tag list <repository> [--json]")
+	}
+	fs := flag.NewFlagSet("tag list", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args[2:])
+
+	repo := args[1]
+	var resp struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	if err := c.do("GET", "/v2/"+repo+"/tags/list", nil, &resp); err != nil {
+		return err
+	}
+	sort.Strings(resp.Tags)
+	if *asJSON {
+		return printJSON(resp.Tags)
+	}
+	for _, t := range resp.Tags {
+		fmt.Println(t)
+	}
+	return nil
+}
+
+func runScan(c *client, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: registryx scan trigger|status <repository> <reference>")
+	}
+	sub, repo, ref := args[0], args[1], args[2]
+	base := fmt.Sprintf("/api/v1/repositories/%s/manifests/%s/scan", repo, ref)
+
+	switch sub {
+	case "trigger":
+		var resp map[string]interface{}
+		if err := c.do("POST", base+"/trigger", nil, &resp); err != nil {
+			return err
+		}
+		fmt.Printf("Scan triggered for %s:%s\n", repo, ref)
+		return nil
+	case "status":
+		fs := flag.NewFlagSet("scan status", flag.ExitOnError)
+		asJSON := fs.Bool("json", false, "print JSON")
+		fs.Parse(args[3:])
+
+		var status struct {
+			Status    string  `json:"status"`
+			ScannedAt *string `json:"scanned_at"`
+			Error     string  `json:"error"`
+			Summary   *struct {
+				Critical int `json:"critical"`
+				High     int `json:"high"`
+				Medium   int `json:"medium"`
+				Low      int `json:"low"`
+			} `json:"summary"`
+		}
+		if err := c.do("GET", base+"/status", nil, &status); err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(status)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Status:\t%s\n", status.Status)
+		if status.ScannedAt != nil {
+			fmt.Fprintf(w, "Scanned:\t%s\n", *status.ScannedAt)
+		}
+		if status.Error != "" {
+			fmt.Fprintf(w, "Error:\t%s\n", status.Error)
+		}
+		if status.Summary != nil {
+			fmt.Fprintf(w, "Critical:\t%d\nHigh:\t%d\nMedium:\t%d\nLow:\t%d\n",
+				status.Summary.Critical, status.Summary.High, status.Summary.Medium, status.Summary.Low)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown scan subcommand %q", sub)
+	}
+}
+
+func runPolicy(c *client, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: registryx policy get | policy set <file|->")
+	}
+	switch args[0] {
+	case "get":
+		var resp struct {
+			Rego string `json:"rego"`
+		}
+		if err := c.do("GET", "/api/v1/policy", nil, &resp); err != nil {
+			return err
+		}
+		fmt.Println(strings.TrimSpace(resp.Rego))
+		return nil
+	case "set":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: registryx policy set <file|->")
+		}
+		var src io.Reader = os.Stdin
+		if args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			src = f
+		}
+		if err := c.do("PUT", "/api/v1/policy", src, nil); err != nil {
+			return err
+		}
+		fmt.Println("Policy updated")
+		return nil
+	default:
+		return fmt.Errorf("unknown policy subcommand %q", args[0])
+	}
+}
+
+func runGC(c *client, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be deleted")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	path := "/api/v1/system/gc"
+	if *dryRun {
+		path += "?dryRun=true"
+	}
+	var report struct {
+		BlobsDeleted     int64    `json:"blobsDeleted"`
+		ManifestsDeleted int64    `json:"manifestsDeleted"`
+		SpaceFreedMB     string   `json:"spaceFreedMB"`
+		Duration         string   `json:"duration"`
+		Errors           []string `json:"errors"`
+	}
+	if err := c.do("POST", path, nil, &report); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(report)
+	}
+
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+	fmt.Printf("%s %d blobs and %d manifests (%s) in %s\n", verb, report.BlobsDeleted, report.ManifestsDeleted, report.SpaceFreedMB, report.Duration)
+	for _, e := range report.Errors {
+		fmt.Fprintf(os.Stderr, "error: %s\n", e)
+	}
+	return nil
+}
+
+func runFsck(c *client, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "fix what can be fixed safely")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	path := "/api/v1/system/fsck"
+	if *repair {
+		path += "?repair=true"
+	}
+	var report struct {
+		BlobsChecked     int            `json:"blobsChecked"`
+		ManifestsChecked int            `json:"manifestsChecked"`
+		TagsChecked      int            `json:"tagsChecked"`
+		Counts           map[string]int `json:"counts"`
+		Repaired         int            `json:"repaired"`
+		Findings         []struct {
+			Kind     string `json:"kind"`
+			Object   string `json:"object"`
+			Detail   string `json:"detail"`
+			Repaired bool   `json:"repaired"`
+		} `json:"findings"`
+		Truncated bool   `json:"truncated"`
+		Duration  string `json:"duration"`
+	}
+	if err := c.do("POST", path, nil, &report); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(report)
+	}
+
+	fmt.Printf("Checked %d blobs, %d manifests and %d tags in %s\n", report.BlobsChecked, report.ManifestsChecked, report.TagsChecked, report.Duration)
+	if len(report.Findings) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tOBJECT\tDETAIL\tREPAIRED")
+	for _, f := range report.Findings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\n", f.Kind, f.Object, f.Detail, f.Repaired)
+	}
+	w.Flush()
+	if report.Truncated {
+		fmt.Println("(more findings omitted; see --json counts)")
+	}
+	fmt.Printf("Repaired %d\n", report.Repaired)
+	return nil
+}
+
+func runRetention(c *client, args []string) error {
+	if len(args) < 2 || args[0] != "preview" {
+		return fmt.Errorf("usage: registryx retention preview <repository> [--json]")
+	}
+	fs := flag.NewFlagSet("retention preview", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args[2:])
+
+	repo := args[1]
+	var res struct {
+		Repository string `json:"repository"`
+		Tags       []struct {
+			Tag    string `json:"tag"`
+			Digest string `json:"digest"`
+		} `json:"tags"`
+		Manifests []string `json:"manifests"`
+	}
+	if err := c.do("POST", "/api/v1/repositories/"+repo+"/retention/run?dryRun=true", nil, &res); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(res)
+	}
+
+	if len(res.Tags) == 0 && len(res.Manifests) == 0 {
+		fmt.Printf("Retention would delete nothing in %s\n", repo)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tDIGEST")
+	for _, t := range res.Tags {
+		fmt.Fprintf(w, "tag\t%s\t%s\n", t.Tag, t.Digest)
+	}
+	for _, d := range res.Manifests {
+		fmt.Fprintf(w, "manifest\t-\t%s\n", d)
+	}
+	w.Flush()
+	fmt.Printf("Retention would delete %d tags and %d manifests in %s\n", len(res.Tags), len(res.Manifests), repo)
+	return nil
+}
@@ -4,11 +4,19 @@ go 1.22
 
 require (
 	github.com/gorilla/mux v1.8.1
+	github.com/go-ldap/ldap/v3 v3.4.6
 	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/minio/minio-go/v7 v7.0.66
 	github.com/google/uuid v1.5.0
 	github.com/lib/pq v1.10.9
 	github.com/open-policy-agent/opa v0.61.0
 	github.com/redis/go-redis/v9 v9.5.1
+	github.com/robfig/cron/v3 v3.0.1
+	go.opentelemetry.io/otel v1.21.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.21.0
+	go.opentelemetry.io/otel/sdk v1.21.0
+	go.opentelemetry.io/otel/trace v1.21.0
 	golang.org/x/crypto v0.16.0
+	google.golang.org/grpc v1.61.0
+	google.golang.org/protobuf v1.31.0
 )
@@ -2,39 +2,91 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/redis/go-redis/v9"
+	"github.com/registryx/registryx/backend/pkg/advisor"
+	"github.com/registryx/registryx/backend/pkg/alerts"
+	"github.com/registryx/registryx/backend/pkg/analytics"
+	"github.com/registryx/registryx/backend/pkg/anomaly"
 	"github.com/registryx/registryx/backend/pkg/api"
 	"github.com/registryx/registryx/backend/pkg/audit"
 	"github.com/registryx/registryx/backend/pkg/auth"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/backup"
+	"github.com/registryx/registryx/backend/pkg/bandwidth"
+	"github.com/registryx/registryx/backend/pkg/clientip"
+	"github.com/registryx/registryx/backend/pkg/compliance"
 	"github.com/registryx/registryx/backend/pkg/config"
+	"github.com/registryx/registryx/backend/pkg/cosign"
 	"github.com/registryx/registryx/backend/pkg/costs"
 	"github.com/registryx/registryx/backend/pkg/database"
+	"github.com/registryx/registryx/backend/pkg/diagnostics"
 	"github.com/registryx/registryx/backend/pkg/email"
+	"github.com/registryx/registryx/backend/pkg/events"
+	"github.com/registryx/registryx/backend/pkg/georeplica"
 	"github.com/registryx/registryx/backend/pkg/intelligence"
+	"github.com/registryx/registryx/backend/pkg/importer"
+	"github.com/registryx/registryx/backend/pkg/integrity"
+	"github.com/registryx/registryx/backend/pkg/ipallow"
+	"github.com/registryx/registryx/backend/pkg/ldapauth"
+	"github.com/registryx/registryx/backend/pkg/lint"
+	"github.com/registryx/registryx/backend/pkg/maintenance"
 	"github.com/registryx/registryx/backend/pkg/metadata"
 	"github.com/registryx/registryx/backend/pkg/middleware"
 	"github.com/registryx/registryx/backend/pkg/policy"
+	"github.com/registryx/registryx/backend/pkg/provenance"
+	"github.com/registryx/registryx/backend/pkg/pulllimit"
 	"github.com/registryx/registryx/backend/pkg/queue"
+	"github.com/registryx/registryx/backend/pkg/quota"
+	"github.com/registryx/registryx/backend/pkg/replication"
+	"github.com/registryx/registryx/backend/pkg/recovery"
 	"github.com/registryx/registryx/backend/pkg/registry"
+	"github.com/registryx/registryx/backend/pkg/reports"
 	"github.com/registryx/registryx/backend/pkg/scanner"
+	"github.com/registryx/registryx/backend/pkg/signing"
 	"github.com/registryx/registryx/backend/pkg/storage"
+	"github.com/registryx/registryx/backend/pkg/tagexpiry"
+	"github.com/registryx/registryx/backend/pkg/gc"
+	"github.com/registryx/registryx/backend/pkg/retention"
+	"github.com/registryx/registryx/backend/pkg/teams"
+	"github.com/registryx/registryx/backend/pkg/tracing"
+	"github.com/registryx/registryx/backend/pkg/transfer"
+	"github.com/registryx/registryx/backend/pkg/trivydb"
 	"github.com/registryx/registryx/backend/pkg/webhook"
+	"github.com/registryx/registryx/backend/pkg/workerapi"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func main() {
+	rebuild := flag.Bool("rebuild-metadata", false, "rebuild the metadata database from object storage, then exit")
+	rebuildOwner := flag.String("rebuild-owner", "admin", "user that owns repositories recreated by -rebuild-metadata")
+	flag.Parse()
+
 	cfg := config.Load()
 	fmt.Printf("Starting RegistryX Backend (VERSION 2.2 - HEALTH ALGO UPDATE) on %s...\n", cfg.ServerPort)
 
+	// Initialize Tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	// Initialize Storage
-	store, err := storage.NewS3Driver(cfg)
+	store, err := storage.New(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage driver: %v", err)
 	}
@@ -55,45 +107,155 @@ func main() {
 
 	// Initialize Metadata Service
 	metaService := metadata.NewService(dbConn)
+	metaService.Limits = metadata.ResourceLimits{
+		RepositoriesPerUser:    cfg.MaxRepositoriesPerUser,
+		TagsPerRepository:      cfg.MaxTagsPerRepository,
+		ManifestsPerRepository: cfg.MaxManifestsPerRepository,
+	}
+	for _, ns := range strings.Split(cfg.PublicNamespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			metaService.PublicNamespaces = append(metaService.PublicNamespaces, ns)
+		}
+	}
+	gcGrace, err := time.ParseDuration(cfg.GCGracePeriod)
+	if err != nil || gcGrace < 0 {
+		log.Fatalf("Invalid GC_GRACE_PERIOD: %q", cfg.GCGracePeriod)
+	}
+	metaService.GCGracePeriod = gcGrace
 
 	// Initialize Scanner Service
 	scanService := scanner.NewService(dbConn, cfg)
+	// Vulnerability DB for air-gapped installs (imported bundles, age limit)
+	trivyDB := trivydb.NewManager(cfg.TrivyCacheDir, cfg.TrivyDBMirror, time.Duration(cfg.TrivyDBMaxAgeHours)*time.Hour, cfg.TrivyOffline)
+	scanService.TrivyDB = trivyDB
 
 	// Initialize Policy Service
 	policyService := policy.NewService()
+	policyService.DB = dbConn
 
 	queueService, err := queue.NewService(cfg)
 	if err != nil {
 		log.Printf("Warning: Failed to connect to Redis Queue: %v. Async scanning will be disabled.\n", err)
 	}
 
+	// Recovery mode: repopulate metadata from the bucket and exit
+	if *rebuild {
+		if err := rebuildMetadata(context.Background(), metaService, store, queueService, *rebuildOwner); err != nil {
+			log.Fatalf("Metadata rebuild failed: %v", err)
+		}
+		return
+	}
+
+	// Live event stream for the dashboard (shared across instances via Redis when available)
+	var eventBus *events.Broker
+	if queueService != nil {
+		eventBus = events.NewBroker(queueService.Client)
+	} else {
+		eventBus = events.NewBroker(nil)
+	}
+	scanService.Events = eventBus
+
+	// Keep materialized dashboard stats fresh (scan results may arrive from other instances)
+	go func() {
+		ch, _ := eventBus.Subscribe()
+		for e := range ch {
+			if e.Type == events.TypeScanComplete || e.Type == events.TypeGC {
+				metaService.MarkStatsDirty()
+			}
+		}
+	}()
+	if queueService != nil {
+		metaService.ShareStatsDirty(queueService.Client)
+	}
+	if cfg.StatsRefreshSeconds > 0 {
+		go metaService.StartStatsRefresher(context.Background(), time.Duration(cfg.StatsRefreshSeconds)*time.Second)
+	}
+
+	// 9. Email Service
+	emailService := email.NewService(cfg)
+
+	// Per-repository alert rules, evaluated after each scan
+	alertService := alerts.NewService(dbConn, emailService)
+
 	// 12. Intelligence Service (EPSS Vulnerability Prioritization)
 	intelService := intelligence.NewService(dbConn)
+	intelService.RuntimeTTL = time.Duration(cfg.RuntimeReportTTLMinutes) * time.Minute
+	intelService.EPSSImportPath = cfg.EPSSImportPath
+
+	// SIGINT/SIGTERM stop the scan worker first, then the server
+	shutdown, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	workerDone := make(chan struct{})
 
 	// 7. Start Background Worker
 	if queueService != nil {
-		go func() {
-			log.Println("Starting Scan Worker...")
-			for {
-				job, err := queueService.DequeueScan(context.Background())
-				if err != nil {
-					log.Printf("Worker Queue Error: %v\n", err)
-					time.Sleep(5 * time.Second) // Backoff
-					continue
-				}
+		if cfg.EmbeddedScanWorker {
+			go func() {
+				defer close(workerDone)
+				log.Println("Starting Scan Worker...")
+				for shutdown.Err() == nil {
+					job, err := queueService.DequeueScan(shutdown)
+					if shutdown.Err() != nil {
+						break
+					}
+					if err != nil {
+						log.Printf("Worker Queue Error: %v\n", err)
+						select { // Backoff
+						case <-shutdown.Done():
+						case <-time.After(5 * time.Second):
+						}
+						continue
+					}
 				
-				log.Printf("Worker: Processing scan for %s (Repo: %s)\n", job.Reference, job.Repository)
-				scanService.ScanManifest(context.Background(), job.ManifestID, job.Repository, job.Reference)
+					if !scanService.TryBeginScan(job.ManifestID) {
+						log.Printf("Worker: Scan for %s already running, skipping duplicate job\n", job.Reference)
+						continue
+					}
+					// The job carries the trace of the push that queued it.
+					jobCtx, span := tracing.Start(tracing.Extract(shutdown, job.Trace), "scan.job", attribute.String("registry.repository", job.Repository), attribute.String("registry.reference", job.Reference))
+					// A recent report of the same digest stands in for a scan.
+					reused, rerr := scanService.ReuseRecent(jobCtx, job.ManifestID, job.Repository, job.Reference)
+					if rerr != nil {
+						log.Printf("Worker: Could not reuse an earlier scan for %s: %v\n", job.Reference, rerr)
+					}
+					if !reused {
+						log.Printf("Worker: Processing scan for %s (Repo: %s)\n", job.Reference, job.Repository)
+						err = scanService.ScanManifest(jobCtx, job.ManifestID, job.Repository, job.Reference)
+					}
+					scanService.EndScan(job.ManifestID)
+					if err != nil && shutdown.Err() != nil {
+						// Interrupted: trivy is gone and the report is pending
+						// again; hand the job to the next worker to start.
+						ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+						if err := queueService.RequeueScan(ctx, *job); err != nil {
+							log.Printf("Worker: Failed to requeue scan for %s: %v\n", job.Reference, err)
+						} else {
+							log.Printf("Worker: Scan for %s interrupted by shutdown; requeued\n", job.Reference)
+						}
+						cancel()
+						tracing.End(span, err)
+						break
+					}
 				
-				// 3. Enrich with Intelligence Priorities
-				_ = intelService.CalculateManifestPriorities(context.Background(), job.ManifestID)
+					// 3. Enrich with Intelligence Priorities
+					enrichCtx := context.WithoutCancel(jobCtx)
+					_ = intelService.CalculateManifestPriorities(enrichCtx, job.ManifestID)
+					if err := alertService.Evaluate(enrichCtx, job.ManifestID, job.Repository, job.Reference); err != nil {
+						log.Printf("Worker: Alert rules for %s failed: %v\n", job.Reference, err)
+					}
 
-				// 4. Recalculate health score after scan
-				metaService.CalculateAndStoreHealthScore(context.Background(), job.ManifestID)
+					// 4. Recalculate health score after scan
+					metaService.CalculateAndStoreHealthScore(enrichCtx, job.ManifestID)
+					tracing.End(span, err)
 				
-				log.Printf("Worker: Scan finished for %s\n", job.Reference)
-			}
-		}()
+					log.Printf("Worker: Scan finished for %s\n", job.Reference)
+				}
+				log.Println("Scan Worker stopped")
+			}()
+		} else {
+			close(workerDone)
+			log.Println("Embedded scan worker disabled; scans are handled by external workers")
+		}
 
 		// Start Periodic EPSS Intelligence Refresh (Daily)
 		go func() {
@@ -112,14 +274,18 @@ func main() {
 				time.Sleep(23 * time.Hour)
 			}
 		}()
+	} else {
+		close(workerDone)
+	}
+
+	// Fail (and requeue) scans left 'scanning' by crashed workers
+	if cfg.ScanReconcileMinutes > 0 {
+		go scanService.StartReconciler(context.Background(), queueService, time.Duration(cfg.ScanReconcileMinutes)*time.Minute)
 	}
 
 	// 8. Webhook Service
 	webhookService := webhook.NewService(cfg.WebhookURL)
 
-	// 9. Email Service
-	emailService := email.NewService(cfg)
-	
 	// 10. Audit Service
 	auditService := audit.NewService(dbConn)
 
@@ -129,37 +295,366 @@ func main() {
 		redisClient = queueService.Client
 	}
 	authService := auth.NewService(dbConn, emailService, auditService, redisClient, cfg.JWTSecret)
+	// Repository access decisions (grants and namespace ownership)
+	authorizer := authz.NewAuthorizer(dbConn)
+	authService.Authz = authorizer
+	authService.TokenTTL = time.Duration(cfg.RegistryTokenTTLMinutes) * time.Minute
+	authService.SessionTTL = time.Duration(cfg.SessionTTLHours) * time.Hour
 
+	// Directory sign-in, falling back to local accounts
+	switch cfg.AuthBackend {
+	case "local":
+	case "ldap":
+		groupRoles, err := ldapauth.ParseGroupRoles(cfg.LDAPGroupRoles)
+		if err != nil {
+			log.Fatalf("Invalid LDAP_GROUP_ROLES: %v", err)
+		}
+		directory, err := ldapauth.New(ldapauth.Config{
+			URL:            cfg.LDAPURL,
+			StartTLS:       cfg.LDAPStartTLS,
+			CAFile:         cfg.LDAPCAFile,
+			BindDN:         cfg.LDAPBindDN,
+			BindPassword:   cfg.LDAPBindPassword,
+			BaseDN:         cfg.LDAPBaseDN,
+			UserFilter:     cfg.LDAPUserFilter,
+			EmailAttribute: cfg.LDAPEmailAttribute,
+			GroupAttribute: cfg.LDAPGroupAttribute,
+			GroupRoles:     groupRoles,
+		})
+		if err != nil {
+			log.Fatalf("Invalid LDAP configuration: %v", err)
+		}
+		authService.LDAP = directory
+		log.Printf("LDAP sign-in enabled against %s\n", cfg.LDAPURL)
+	default:
+		log.Fatalf("Invalid AUTH_BACKEND %q: use local or ldap", cfg.AuthBackend)
+	}
 
+	// Token signing keys; retired keys stay valid as long as any token they signed
+	retainKeys := authService.SessionLifetime()
+	if tokenTTL := time.Duration(cfg.RegistryTokenTTLMinutes) * time.Minute; tokenTTL > retainKeys {
+		retainKeys = tokenTTL
+	}
+	keyring := signing.NewKeyring(dbConn, cfg.JWTSecret, retainKeys)
+	if err := keyring.Init(context.Background()); err != nil {
+		log.Printf("Warning: Failed to load signing keys, using JWT_SECRET only: %v\n", err)
+	}
+	go keyring.Run(context.Background(), time.Minute)
+	authService.Keys = keyring
+	// The embedded scanner pulls with a token minted per scan
+	scanService.PullToken = authService.ScannerToken
+
+	// Internal gRPC API for external scan workers
+	if cfg.WorkerGRPCAddr != "" {
+		if queueService == nil {
+			log.Println("Warning: WORKER_GRPC_ADDR set but Redis is unavailable. Worker API disabled.")
+		} else if cfg.WorkerAPIToken == "" {
+			log.Println("Warning: WORKER_GRPC_ADDR set without WORKER_API_TOKEN. Worker API disabled.")
+		} else if cfg.WorkerTLSCertFile == "" && !cfg.WorkerGRPCInsecure {
+			log.Fatalf("Invalid worker API configuration: set WORKER_TLS_CERT_FILE and WORKER_TLS_KEY_FILE, or WORKER_GRPC_INSECURE=true")
+		} else {
+			workerServer := workerapi.NewServer(queueService, scanService, metaService, intelService, cfg.WorkerAPIToken)
+			workerServer.Alerts = alertService
+			workerServer.PullToken = authService.ScannerToken
+			workerServer.CertFile, workerServer.KeyFile = cfg.WorkerTLSCertFile, cfg.WorkerTLSKeyFile
+			workerServer.Insecure = cfg.WorkerGRPCInsecure
+			if cfg.WorkerTLSCertFile == "" {
+				log.Println("Warning: Worker gRPC API served without TLS; WORKER_API_TOKEN and registry tokens are sent unencrypted.")
+			}
+			go func() {
+				log.Printf("Starting Worker gRPC API on %s...\n", cfg.WorkerGRPCAddr)
+				if err := workerServer.ListenAndServe(cfg.WorkerGRPCAddr); err != nil {
+					log.Printf("Worker gRPC API stopped: %v\n", err)
+				}
+			}()
+		}
+	}
+
+	// Audit anomaly detection (mass deletions, failed logins, new sign-in locations)
+	businessHours, err := anomaly.ParseBusinessHours(cfg.AnomalyBusinessHours, cfg.AnomalyTimezone)
+	if err != nil {
+		log.Fatalf("Invalid ANOMALY_BUSINESS_HOURS: %v", err)
+	}
+	anomalyDetector := anomaly.NewDetector(dbConn, auditService, emailService)
+	anomalyDetector.WebhookURL = cfg.SecurityAlertWebhookURL
+	for _, addr := range strings.Split(cfg.SecurityAlertEmails, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			anomalyDetector.Recipients = append(anomalyDetector.Recipients, addr)
+		}
+	}
+	anomalyDetector.MassDeleteCount = cfg.AnomalyMassDeleteCount
+	anomalyDetector.FailedLoginCount = cfg.AnomalyFailedLoginCount
+	anomalyDetector.LockoutCount = cfg.AuthLockoutCount
+	anomalyDetector.BusinessHours = businessHours
+	anomalyDetector.CountryHeader = cfg.GeoIPCountryHeader
+	authService.Observer = anomalyDetector
+
+	// Network allowlists of namespaces and service accounts
+	networkChecker := ipallow.NewChecker(dbConn)
+	networkChecker.KeyFunc = keyring.Keyfunc
+	authService.Networks = networkChecker
+	go anomalyDetector.Run(context.Background(), time.Minute)
+
+
+	classCosts, err := costs.ParseStorageClassCosts(cfg.StorageClassCosts)
+	if err != nil {
+		log.Fatalf("Invalid STORAGE_CLASS_COSTS: %v", err)
+	}
 	costConfig := &costs.CostConfig{
 		StorageCostPerGBMonth: cfg.StorageCostPerGBMonth, 
 		BandwidthCostPerGB:    cfg.BandwidthCostPerGB, 
+		StorageClassCostPerGBMonth: classCosts,
 		RegistryRegion:        "custom",
 	}
 	costService := costs.NewService(dbConn, costConfig)
+	if classes, ok := store.(storage.ClassLister); ok {
+		costService.Classes = classes
+	}
+	costService.Metadata = metaService
+	costService.Storage = store
+	// Scheduled cost refresh (costs, zombie images and the daily cost trend)
+	if cfg.CostRefreshHours > 0 {
+		go costService.StartScheduler(context.Background(), time.Duration(cfg.CostRefreshHours)*time.Hour)
+	} else {
+		go costService.StartSnapshots(context.Background(), 24*time.Hour)
+	}
 
 	// Initialize Registry Handler
-	regHandler := registry.NewHandler(cfg, store, metaService, scanService, policyService, queueService, webhookService, auditService)
+	regHandler := registry.NewHandler(cfg, store, metaService, scanService, policyService, queueService, webhookService, auditService, eventBus)
 	
+	// Per-route latency and error tracking
+	diagRecorder := diagnostics.NewRecorder(time.Duration(cfg.SlowRequestMs) * time.Millisecond)
+
 	// Initialize Dashboard Handler
-	dashHandler := api.NewDashboardHandler(metaService, scanService, policyService, authService, store, cfg, auditService)
+	dashHandler := api.NewDashboardHandler(metaService, scanService, policyService, authService, store, cfg, auditService, eventBus, diagRecorder)
+	dashHandler.Authz = authorizer
+	regHandler.Authz = authorizer
+	dashHandler.Teams = teams.NewService(dbConn, authorizer)
+	dashHandler.Analytics = analytics.NewService(dbConn)
+	go dashHandler.Analytics.StartPruning(context.Background(), 24*time.Hour)
+	dashHandler.Transfers = transfer.NewService(metaService, store)
+
+	// Best-practice image linting (push time, and on first view for older images)
+	imageLinter := lint.NewLinter(store, int64(cfg.LintMaxLayerMB)<<20)
+	regHandler.Linter = imageLinter
+	dashHandler.Linter = imageLinter
+	dashHandler.Webhook = webhookService
+	dashHandler.TrivyDB = trivyDB
+	dashHandler.Alerts = alertService
+	dashHandler.Security = anomalyDetector
+	dashHandler.Networks = networkChecker
+	if cfg.TrivyDBMirror != "" {
+		syncEvery := time.Duration(cfg.TrivyDBSyncHours) * time.Hour
+		if syncEvery <= 0 {
+			syncEvery = 6 * time.Hour
+		}
+		go trivyDB.Run(context.Background(), syncEvery)
+	}
+
+	// Build metadata from SLSA provenance attestations pushed with images
+	provenanceReader, err := provenance.NewReader(store, cfg.SigstoreRootsFile)
+	if err != nil {
+		log.Fatalf("Failed to load Sigstore roots: %v", err)
+	}
+	regHandler.Provenance = provenanceReader
+
+	// Cosign signature verification for policies
+	var cosignKeys []string
+	for _, f := range strings.Split(cfg.CosignPublicKeys, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			cosignKeys = append(cosignKeys, f)
+		}
+	}
+	signatureVerifier, err := cosign.NewVerifier(store, metaService, cosignKeys, cfg.SigstoreRootsFile, cfg.RekorPublicKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to load cosign verification keys: %v", err)
+	}
+	regHandler.Signatures = signatureVerifier
+	dashHandler.Signatures = signatureVerifier
+
+	// Weekly email of images to rebuild on patched base images
+	if cfg.RebuildDigestDay != "" {
+		rebuildDigest, err := advisor.NewDigest(dbConn, metaService, emailService, redisClient, cfg.RebuildDigestDay)
+		if err != nil {
+			log.Fatalf("Invalid REBUILD_DIGEST_DAY: %v", err)
+		}
+		rebuildDigest.DefaultEnv = cfg.PolicyEnvironment
+		go rebuildDigest.Run(context.Background())
+	}
+
+	// Weekly summary email to namespace owners
+	weeklyReport := reports.NewWeekly(dbConn, emailService, redisClient, cfg.JWTSecret)
+	weeklyReport.PublicURL = cfg.PublicURL
+	dashHandler.Reports = weeklyReport
+	if cfg.WeeklyReportDay != "" {
+		weekday, err := reports.ParseWeekday(cfg.WeeklyReportDay)
+		if err != nil {
+			log.Fatalf("Invalid WEEKLY_REPORT_DAY: %v", err)
+		}
+		weeklyReport.Weekday = weekday
+		go weeklyReport.Run(context.Background())
+	}
+
+	// Signed compliance reports for auditors
+	complianceService := compliance.NewService(dbConn, policyService)
+	complianceService.DefaultEnv = cfg.PolicyEnvironment
+	dashHandler.Compliance = complianceService
+
+	// Imports from other registries, copied in the background through the
+	// push path. Interrupted imports resume on the next start.
+	importService := importer.NewService(dbConn, metaService, regHandler)
+	importService.Workers = cfg.ImportWorkers
+	dashHandler.Imports = importService
+	importDone := make(chan struct{})
+	go func() {
+		defer close(importDone)
+		importService.Run(shutdown)
+	}()
+
+	// Push-based replication to other registries, queued in Redis
+	replicationDone := make(chan struct{})
+	if redisClient != nil {
+		replicationService := replication.NewService(dbConn, redisClient, store)
+		replicationService.Workers = cfg.ReplicationWorkers
+		regHandler.Replication = replicationService
+		dashHandler.Replication = replicationService
+		go func() {
+			defer close(replicationDone)
+			replicationService.Run(shutdown)
+		}()
+	} else {
+		close(replicationDone)
+	}
+
+	// Metadata backups (scheduled export to object storage)
+	backupService := backup.NewService(dbConn, store, cfg.BackupRetention)
+	dashHandler.Backup = backupService
+	if cfg.BackupIntervalHours > 0 {
+		go backupService.StartScheduler(context.Background(), time.Duration(cfg.BackupIntervalHours)*time.Hour)
+	}
+
+	// Garbage collections are recorded, and one runs at a time
+	dashHandler.GC = gc.NewService(dbConn)
+
+	// Expired tags are deleted in the background, then garbage collected
+	tagSweeper := tagexpiry.NewSweeper(metaService, store, eventBus)
+	tagSweeper.CollectGarbage = func(ctx context.Context) error {
+		_, err := dashHandler.CollectGarbage(ctx, false, gc.Trigger{Kind: gc.TriggerTagExpiry})
+		return err
+	}
+	if cfg.TagExpiryIntervalMinutes > 0 {
+		go tagSweeper.StartScheduler(shutdown, time.Duration(cfg.TagExpiryIntervalMinutes)*time.Minute)
+	}
+
+	// Retention policies prune repositories on a schedule
+	// Read-only maintenance mode (shared across instances via Redis)
+	maintenanceService := maintenance.NewService(redisClient)
+
+	retentionEnforcer := retention.NewEnforcer(metaService, store, eventBus)
+	retentionEnforcer.Maintenance = maintenanceService
+	dashHandler.Retention = retentionEnforcer
+	if cfg.RetentionIntervalMinutes > 0 {
+		go retentionEnforcer.StartScheduler(shutdown, time.Duration(cfg.RetentionIntervalMinutes)*time.Minute)
+	}
+
+	// Alerts as namespaces fill their storage quota, checked after pushes and
+	// periodically, so deletions re-arm them
+	quotaMonitor := quota.NewMonitor(dbConn, metaService, webhookService, emailService, eventBus)
+	regHandler.Quota = quotaMonitor
+	dashHandler.Quota = quotaMonitor
+	if cfg.QuotaCheckIntervalMinutes > 0 {
+		go quotaMonitor.StartScheduler(shutdown, time.Duration(cfg.QuotaCheckIntervalMinutes)*time.Minute)
+	}
+
+	// Stored blobs are re-hashed in the background to catch bit rot
+	blobVerifier := integrity.NewVerifier(dbConn, store, eventBus, redisClient)
+	blobVerifier.SampleSize = cfg.IntegritySampleSize
+	blobVerifier.Alert = func(ctx context.Context, c integrity.Corruption) {
+		anomalyDetector.Raise(ctx, anomaly.Alert{
+			Kind:     anomaly.KindBlobCorrupt,
+			Severity: "high",
+			Subject:  c.Digest,
+			Summary:  fmt.Sprintf("Blob %s is %s: %s", c.Digest, c.Status, c.Detail),
+			Details:  map[string]interface{}{"digest": c.Digest, "status": c.Status, "repositories": c.Repositories},
+		})
+	}
+	dashHandler.Integrity = blobVerifier
+	if cfg.IntegrityCheckHours > 0 {
+		go blobVerifier.StartScheduler(shutdown, time.Duration(cfg.IntegrityCheckHours)*time.Hour)
+	}
+
+	// Regional storage replicas (blob downloads served near the client)
+	replicaRouter, err := georeplica.NewRouter(cfg, store)
+	if err != nil {
+		log.Fatalf("Failed to configure storage replicas: %v", err)
+	}
+	regHandler.Replicas = replicaRouter
+	// Blobs written before the sharded layout are moved in the background
+	go migrateBlobLayout(cfg.StorageRegion, store)
+	for _, rep := range replicaRouter.Replicas {
+		go migrateBlobLayout(rep.Region, rep.Driver)
+	}
+	if len(replicaRouter.Replicas) > 0 {
+		replicaSyncer := georeplica.NewSyncer(replicaRouter)
+		dashHandler.Replicas = replicaSyncer
+		syncEvery := time.Duration(cfg.ReplicaSyncMinutes) * time.Minute
+		if syncEvery <= 0 {
+			syncEvery = 10 * time.Minute
+		}
+		go replicaSyncer.Run(context.Background(), syncEvery)
+	}
 
 	// Initialize Advanced Features Handler
 	advancedHandler := api.NewAdvancedHandler(intelService, costService)
+	advancedHandler.RuntimeAgentToken = cfg.RuntimeAgentToken
+	advancedHandler.Audit = auditService
+	advancedHandler.Authz = authorizer
 
 	// Router Setup (Gorilla Mux)
 	r := mux.NewRouter()
+	r.Use(diagRecorder.Middleware)
+	r.Use(tracing.Middleware)
+
+	// Reject writes in maintenance mode
+	dashHandler.Maintenance = maintenanceService
+	r.Use(maintenanceService.Middleware)
+
+	// Automatic garbage collection; skipped while in maintenance mode
+	if cfg.GCSchedule != "" {
+		schedule, err := gc.ParseSchedule(cfg.GCSchedule)
+		if err != nil {
+			log.Fatalf("Invalid GC_SCHEDULE: %v", err)
+		}
+		go gc.StartScheduler(shutdown, schedule, func(ctx context.Context, slot time.Time) error {
+			if maintenanceService.Get(ctx).Enabled {
+				fmt.Println("[GC] Skipped scheduled collection: maintenance mode")
+				return nil
+			}
+			_, err := dashHandler.CollectGarbage(ctx, false, gc.Trigger{Kind: gc.TriggerSchedule, Slot: &slot})
+			return err
+		})
+	}
 
 	// Middleware
-	authMiddleware := middleware.AuthMiddleware(cfg.JWTSecret, redisClient)
+	authMiddleware := middleware.AuthMiddleware(keyring.Keyfunc, redisClient, authService.SessionLifetime(), anomalyDetector.InvalidToken)
 
 	// Dashboard API Group
 	apiV1 := r.PathPrefix("/api/v1").Subrouter()
 	apiV1.Handle("/stats", authMiddleware(http.HandlerFunc(dashHandler.GetStats))).Methods("GET")
-	apiV1.HandleFunc("/service-accounts", dashHandler.ListServiceAccounts).Methods("GET")
-	apiV1.HandleFunc("/service-accounts", dashHandler.CreateServiceAccount).Methods("POST")
-	apiV1.HandleFunc("/service-accounts/{id}", dashHandler.RevokeServiceAccount).Methods("DELETE")
+	apiV1.Handle("/service-accounts", authMiddleware(http.HandlerFunc(dashHandler.ListServiceAccounts))).Methods("GET")
+	apiV1.Handle("/service-accounts", authMiddleware(http.HandlerFunc(dashHandler.CreateServiceAccount))).Methods("POST")
+	apiV1.Handle("/service-accounts/{id}", authMiddleware(http.HandlerFunc(dashHandler.RevokeServiceAccount))).Methods("DELETE")
+	apiV1.Handle("/service-accounts/{id}/allowed-networks", authMiddleware(http.HandlerFunc(dashHandler.UpdateServiceAccountNetworks))).Methods("PUT")
+	apiV1.Handle("/service-accounts/{id}/scopes", authMiddleware(http.HandlerFunc(dashHandler.UpdateServiceAccountScopes))).Methods("PUT")
+
+	// Client certificate (mTLS) subjects and who they sign in as (admin only)
+	apiV1.Handle("/system/client-certificates", authMiddleware(http.HandlerFunc(dashHandler.ListClientCertificates))).Methods("GET")
+	apiV1.Handle("/system/client-certificates", authMiddleware(http.HandlerFunc(dashHandler.CreateClientCertificate))).Methods("POST")
+	apiV1.Handle("/system/client-certificates/{id}", authMiddleware(http.HandlerFunc(dashHandler.DeleteClientCertificate))).Methods("DELETE")
 	apiV1.Handle("/dependencies", authMiddleware(http.HandlerFunc(dashHandler.GetDependencyGraph))).Methods("GET")
+	apiV1.Handle("/dependencies/impact", authMiddleware(http.HandlerFunc(dashHandler.GetDependencyImpact))).Methods("GET")
+	apiV1.Handle("/rebuild-recommendations", authMiddleware(http.HandlerFunc(dashHandler.GetRebuildRecommendations))).Methods("GET")
+	apiV1.Handle("/events/stream", middleware.QueryToken(authMiddleware(http.HandlerFunc(dashHandler.StreamEvents)))).Methods("GET")
 
 	// Auth API
 	apiV1.HandleFunc("/auth/register", dashHandler.Register).Methods("POST")
@@ -176,17 +671,71 @@ func main() {
 	// Admin / System
 	apiV1.Handle("/system/sessions", authMiddleware(http.HandlerFunc(dashHandler.GetActiveSessions))).Methods("GET")
 	apiV1.Handle("/system/sessions/{id}", authMiddleware(http.HandlerFunc(dashHandler.RevokeSession))).Methods("DELETE")
+	apiV1.Handle("/system/registry-tokens", authMiddleware(http.HandlerFunc(dashHandler.ListRegistryTokens))).Methods("GET")
+	apiV1.Handle("/system/registry-tokens/revoke", authMiddleware(http.HandlerFunc(dashHandler.RevokeRegistryTokensFor))).Methods("POST")
+	apiV1.Handle("/system/registry-tokens/{id}", authMiddleware(http.HandlerFunc(dashHandler.RevokeRegistryToken))).Methods("DELETE")
 	
 	// System API
 	apiV1.HandleFunc("/health-check", dashHandler.HealthCheck).Methods("GET") // Added health-check
 	apiV1.HandleFunc("/policy", dashHandler.GetPolicy).Methods("GET")
 	apiV1.HandleFunc("/policy", dashHandler.UpdatePolicy).Methods("PUT")
+	apiV1.Handle("/policy/effective", authMiddleware(http.HandlerFunc(dashHandler.GetEffectivePolicy))).Methods("GET")
+	apiV1.Handle("/policy/test", authMiddleware(http.HandlerFunc(dashHandler.TestPolicy))).Methods("POST")
+	apiV1.Handle("/policy/tests", authMiddleware(http.HandlerFunc(dashHandler.ListPolicyTests))).Methods("GET")
+	apiV1.Handle("/policy/tests", authMiddleware(http.HandlerFunc(dashHandler.SavePolicyTest))).Methods("POST")
+	apiV1.Handle("/policy/tests/{id}", authMiddleware(http.HandlerFunc(dashHandler.DeletePolicyTest))).Methods("DELETE")
+	apiV1.Handle("/namespaces/{name}/environment", authMiddleware(http.HandlerFunc(dashHandler.GetNamespaceEnvironment))).Methods("GET")
+	apiV1.Handle("/namespaces/{name}/environment", authMiddleware(http.HandlerFunc(dashHandler.UpdateNamespaceEnvironment))).Methods("PUT")
+	apiV1.Handle("/namespaces/{name}/allowed-networks", authMiddleware(http.HandlerFunc(dashHandler.GetNamespaceNetworks))).Methods("GET")
+	apiV1.Handle("/namespaces/{name}/allowed-networks", authMiddleware(http.HandlerFunc(dashHandler.UpdateNamespaceNetworks))).Methods("PUT")
+	apiV1.Handle("/namespaces/{name}/compliance-report", authMiddleware(http.HandlerFunc(dashHandler.GetComplianceReport))).Methods("GET")
+	apiV1.Handle("/namespaces/{name}/usage", authMiddleware(http.HandlerFunc(dashHandler.GetNamespaceUsage))).Methods("GET")
+	apiV1.Handle("/namespaces/{name}/policies", authMiddleware(http.HandlerFunc(dashHandler.ListNamespacePolicies))).Methods("GET")
+	apiV1.Handle("/namespaces/{name}/policies", authMiddleware(http.HandlerFunc(dashHandler.SetNamespacePolicy))).Methods("PUT")
+	apiV1.Handle("/namespaces/{name}/policies", authMiddleware(http.HandlerFunc(dashHandler.DeleteNamespacePolicy))).Methods("DELETE")
+	apiV1.Handle("/namespaces/{name}/teams", authMiddleware(http.HandlerFunc(dashHandler.ListTeams))).Methods("GET")
+	apiV1.Handle("/namespaces/{name}/teams", authMiddleware(http.HandlerFunc(dashHandler.CreateTeam))).Methods("POST")
+	apiV1.Handle("/namespaces/{name}/teams/{team}", authMiddleware(http.HandlerFunc(dashHandler.GetTeam))).Methods("GET")
+	apiV1.Handle("/namespaces/{name}/teams/{team}", authMiddleware(http.HandlerFunc(dashHandler.DeleteTeam))).Methods("DELETE")
+	apiV1.Handle("/namespaces/{name}/teams/{team}/members/{user}", authMiddleware(http.HandlerFunc(dashHandler.AddTeamMember))).Methods("PUT")
+	apiV1.Handle("/namespaces/{name}/teams/{team}/members/{user}", authMiddleware(http.HandlerFunc(dashHandler.RemoveTeamMember))).Methods("DELETE")
+	apiV1.Handle("/namespaces/{name}/teams/{team}/role", authMiddleware(http.HandlerFunc(dashHandler.SetTeamNamespaceRole))).Methods("PUT")
+	apiV1.Handle("/namespaces/{name}/teams/{team}/role", authMiddleware(http.HandlerFunc(dashHandler.ClearTeamNamespaceRole))).Methods("DELETE")
+	apiV1.Handle("/namespaces/{name}/teams/{team}/repositories/{repo:.+}", authMiddleware(http.HandlerFunc(dashHandler.SetTeamRepository))).Methods("PUT")
+	apiV1.Handle("/namespaces/{name}/teams/{team}/repositories/{repo:.+}", authMiddleware(http.HandlerFunc(dashHandler.RemoveTeamRepository))).Methods("DELETE")
+	// Public so auditors can check reports without an account
+	apiV1.HandleFunc("/compliance/keys", dashHandler.ListComplianceKeys).Methods("GET")
+	apiV1.HandleFunc("/compliance/verify", dashHandler.VerifyComplianceReport).Methods("POST")
+	// Called by the Kubernetes API server (ValidatingWebhookConfiguration) with ADMISSION_TOKEN
+	// or a client certificate; ValidateAdmission checks them itself
+	apiV1.HandleFunc("/admission/validate", dashHandler.ValidateAdmission).Methods("POST")
+	apiV1.Handle("/inventory/evaluate", authMiddleware(http.HandlerFunc(dashHandler.EvaluateInventory))).Methods("POST")
 	
+	apiV1.Handle("/repositories", authMiddleware(http.HandlerFunc(dashHandler.ListRepositories))).Methods("GET")
 	apiV1.Handle("/repositories", authMiddleware(http.HandlerFunc(dashHandler.CreateRepository))).Methods("POST")
 	
 	// System / Admin
 	apiV1.HandleFunc("/system/config", dashHandler.GetSystemConfig).Methods("GET") // Expose config
 	apiV1.Handle("/system/gc", authMiddleware(http.HandlerFunc(dashHandler.GarbageCollect))).Methods("POST")
+	apiV1.Handle("/system/gc/history", authMiddleware(http.HandlerFunc(dashHandler.GetGCHistory))).Methods("GET")
+	apiV1.Handle("/system/diagnostics", authMiddleware(http.HandlerFunc(dashHandler.GetDiagnostics))).Methods("GET")
+	apiV1.Handle("/system/maintenance", authMiddleware(http.HandlerFunc(dashHandler.GetMaintenance))).Methods("GET")
+	apiV1.Handle("/system/maintenance", authMiddleware(http.HandlerFunc(dashHandler.UpdateMaintenance))).Methods("PUT")
+	apiV1.Handle("/system/signing-keys", authMiddleware(http.HandlerFunc(dashHandler.ListSigningKeys))).Methods("GET")
+	apiV1.Handle("/system/signing-keys/rotate", authMiddleware(http.HandlerFunc(dashHandler.RotateSigningKey))).Methods("POST")
+	apiV1.Handle("/system/replicas", authMiddleware(http.HandlerFunc(dashHandler.ListReplicas))).Methods("GET")
+	apiV1.Handle("/system/trivy-db", authMiddleware(http.HandlerFunc(dashHandler.GetTrivyDB))).Methods("GET")
+	apiV1.Handle("/system/trivy-db/import", authMiddleware(http.HandlerFunc(dashHandler.ImportTrivyDB))).Methods("POST")
+	apiV1.Handle("/system/replicas/sync", authMiddleware(http.HandlerFunc(dashHandler.SyncReplicas))).Methods("POST")
+	apiV1.Handle("/system/storage/encryption", authMiddleware(http.HandlerFunc(dashHandler.GetStorageEncryption))).Methods("GET")
+	apiV1.Handle("/system/storage/reencrypt", authMiddleware(http.HandlerFunc(dashHandler.ReencryptStorage))).Methods("POST")
+	apiV1.Handle("/system/fsck", authMiddleware(http.HandlerFunc(dashHandler.CheckConsistency))).Methods("POST")
+	apiV1.Handle("/system/integrity", authMiddleware(http.HandlerFunc(dashHandler.GetIntegrity))).Methods("GET")
+	apiV1.Handle("/system/integrity/corrupt", authMiddleware(http.HandlerFunc(dashHandler.ListCorruptBlobs))).Methods("GET")
+	apiV1.Handle("/system/integrity/verify", authMiddleware(http.HandlerFunc(dashHandler.VerifyBlobs))).Methods("POST")
+	apiV1.Handle("/system/backups", authMiddleware(http.HandlerFunc(dashHandler.ListBackups))).Methods("GET")
+	apiV1.Handle("/system/backups", authMiddleware(http.HandlerFunc(dashHandler.CreateBackup))).Methods("POST")
+	apiV1.Handle("/system/backups/restore", authMiddleware(http.HandlerFunc(dashHandler.RestoreBackup))).Methods("POST")
 	
 	// Specific routes must come BEFORE greedy routes matches
 	// Specific routes must come BEFORE greedy routes matches
@@ -199,20 +748,88 @@ func main() {
 	// Better approach: Use a router sub-path or specific matching order.
 	// Gorilla Mux matches in order.
 	
-	apiV1.HandleFunc("/repositories/{name:.+}/tags/{tag}", dashHandler.DeleteTag).Methods("DELETE")
+	apiV1.Handle("/repositories/{name:.+}/tags/{tag}", authMiddleware(http.HandlerFunc(dashHandler.DeleteTag))).Methods("DELETE")
 	
 	// FIX: Use a regex that explicitly stops at /manifests/
 	// This is tricky because {name} is greedy.
 	// Let's try matching manifests route explicitly with strict path.
-	apiV1.HandleFunc("/repositories/{name:.+}/manifests/{reference}", dashHandler.DeleteManifest).Methods("DELETE")
+	apiV1.Handle("/repositories/{name:.+}/manifests/{reference}", authMiddleware(http.HandlerFunc(dashHandler.DeleteManifest))).Methods("DELETE")
 	apiV1.HandleFunc("/repositories/{name:.+}/manifests/{reference}", dashHandler.GetManifestDetails).Methods("GET")
 	
 	// Scan-related routes
+	apiV1.Handle("/repositories/{name:.+}/manifests/{reference}/history", authMiddleware(http.HandlerFunc(dashHandler.GetImageHistory))).Methods("GET")
+	apiV1.Handle("/repositories/{name:.+}/manifests/{reference}/export", authMiddleware(http.HandlerFunc(dashHandler.ExportImage))).Methods("GET")
+	apiV1.Handle("/repositories/{name:.+}/manifests/{reference}/build", authMiddleware(http.HandlerFunc(dashHandler.GetBuildMetadata))).Methods("GET")
+	apiV1.Handle("/repositories/{name:.+}/manifests/{reference}/build", authMiddleware(http.HandlerFunc(dashHandler.SetBuildMetadata))).Methods("PUT")
 	apiV1.HandleFunc("/repositories/{name:.+}/manifests/{reference}/scan/status", dashHandler.GetScanStatus).Methods("GET")
 	apiV1.HandleFunc("/repositories/{name:.+}/manifests/{reference}/scan/report", dashHandler.DownloadScanReport).Methods("GET")
 	apiV1.HandleFunc("/repositories/{name:.+}/manifests/{reference}/scan/history", dashHandler.GetScanHistory).Methods("GET")
-	apiV1.HandleFunc("/repositories/{name:.+}/manifests/{reference}/scan/trigger", dashHandler.TriggerManualScan).Methods("POST")
+	// Authenticated so manual scans can be rate limited per user
+	apiV1.Handle("/repositories/{name:.+}/manifests/{reference}/scan/trigger", authMiddleware(http.HandlerFunc(dashHandler.TriggerManualScan))).Methods("POST")
 	
+	// Repository access grants (repository admins only)
+	apiV1.Handle("/repositories/{name:.+}/permissions", authMiddleware(http.HandlerFunc(dashHandler.ListRepositoryPermissions))).Methods("GET")
+	apiV1.Handle("/repositories/{name:.+}/permissions", authMiddleware(http.HandlerFunc(dashHandler.SetRepositoryPermission))).Methods("PUT")
+	apiV1.Handle("/repositories/{name:.+}/permissions/{user}", authMiddleware(http.HandlerFunc(dashHandler.DeleteRepositoryPermission))).Methods("DELETE")
+
+	// Per-repository alert rules (repository admins only)
+	apiV1.Handle("/repositories/{name:.+}/alert-rules", authMiddleware(http.HandlerFunc(dashHandler.ListAlertRules))).Methods("GET")
+	apiV1.Handle("/repositories/{name:.+}/alert-rules", authMiddleware(http.HandlerFunc(dashHandler.CreateAlertRule))).Methods("POST")
+	apiV1.Handle("/repositories/{name:.+}/alert-rules/{id}", authMiddleware(http.HandlerFunc(dashHandler.UpdateAlertRule))).Methods("PUT")
+	apiV1.Handle("/repositories/{name:.+}/alert-rules/{id}", authMiddleware(http.HandlerFunc(dashHandler.DeleteAlertRule))).Methods("DELETE")
+
+	// Security alerts and failed authentication events (admin only)
+	apiV1.Handle("/security/alerts", authMiddleware(http.HandlerFunc(dashHandler.ListSecurityAlerts))).Methods("GET")
+	apiV1.Handle("/security/alerts/{id}/acknowledge", authMiddleware(http.HandlerFunc(dashHandler.AcknowledgeSecurityAlert))).Methods("POST")
+	apiV1.Handle("/security/events", authMiddleware(http.HandlerFunc(dashHandler.ListSecurityEvents))).Methods("GET")
+
+	// Per-repository size and tag limits (admins set them)
+	apiV1.Handle("/repositories/{name:.+}/limits", authMiddleware(http.HandlerFunc(dashHandler.GetRepositoryLimits))).Methods("GET")
+	apiV1.Handle("/repositories/{name:.+}/limits", authMiddleware(http.HandlerFunc(dashHandler.UpdateRepositoryLimits))).Methods("PUT")
+
+	// Tag expiry (set per tag by writers, rules per repository by admins)
+	apiV1.Handle("/repositories/{name:.+}/tags", authMiddleware(http.HandlerFunc(dashHandler.ListTags))).Methods("GET")
+	apiV1.Handle("/repositories/{name:.+}/tags/{tag}/expiry", authMiddleware(http.HandlerFunc(dashHandler.SetTagExpiry))).Methods("PUT")
+	apiV1.Handle("/repositories/{name:.+}/tags/{tag}/expiry", authMiddleware(http.HandlerFunc(dashHandler.ClearTagExpiry))).Methods("DELETE")
+	apiV1.Handle("/repositories/{name:.+}/tag-expiry-rules", authMiddleware(http.HandlerFunc(dashHandler.ListTagExpiryRules))).Methods("GET")
+	apiV1.Handle("/repositories/{name:.+}/tag-expiry-rules", authMiddleware(http.HandlerFunc(dashHandler.SetTagExpiryRule))).Methods("PUT")
+	apiV1.Handle("/repositories/{name:.+}/tag-expiry-rules/{id}", authMiddleware(http.HandlerFunc(dashHandler.DeleteTagExpiryRule))).Methods("DELETE")
+	apiV1.Handle("/repositories/{name:.+}/retention", authMiddleware(http.HandlerFunc(dashHandler.GetRetentionPolicy))).Methods("GET")
+	apiV1.Handle("/repositories/{name:.+}/retention", authMiddleware(http.HandlerFunc(dashHandler.SetRetentionPolicy))).Methods("PUT")
+	apiV1.Handle("/repositories/{name:.+}/retention", authMiddleware(http.HandlerFunc(dashHandler.DeleteRetentionPolicy))).Methods("DELETE")
+	apiV1.Handle("/repositories/{name:.+}/retention/run", authMiddleware(http.HandlerFunc(dashHandler.RunRetentionPolicy))).Methods("POST")
+
+	// Repository transfers between namespaces (accepted by the receiving owner)
+	apiV1.Handle("/repositories/{name:.+}/transfer", authMiddleware(http.HandlerFunc(dashHandler.RequestTransfer))).Methods("POST")
+	apiV1.Handle("/repositories/{name:.+}/import", authMiddleware(http.HandlerFunc(dashHandler.ImportImageArchive))).Methods("POST")
+	apiV1.Handle("/transfers", authMiddleware(http.HandlerFunc(dashHandler.ListTransfers))).Methods("GET")
+	apiV1.Handle("/transfers/{id}/accept", authMiddleware(http.HandlerFunc(dashHandler.AcceptTransfer))).Methods("POST")
+	apiV1.Handle("/transfers/{id}/decline", authMiddleware(http.HandlerFunc(dashHandler.DeclineTransfer))).Methods("POST")
+
+	// Weekly reports (the unsubscribe link works without logging in)
+	apiV1.Handle("/reports/weekly", authMiddleware(http.HandlerFunc(dashHandler.GetWeeklyReport))).Methods("GET")
+	apiV1.Handle("/reports/preferences", authMiddleware(http.HandlerFunc(dashHandler.GetReportPreferences))).Methods("GET")
+	apiV1.Handle("/reports/preferences", authMiddleware(http.HandlerFunc(dashHandler.UpdateReportPreferences))).Methods("PUT")
+	apiV1.HandleFunc("/reports/unsubscribe", dashHandler.UnsubscribeWeeklyReport).Methods("GET")
+
+	// Imports from other registries (Admin only)
+	apiV1.Handle("/imports", authMiddleware(http.HandlerFunc(dashHandler.CreateImport))).Methods("POST")
+	apiV1.Handle("/imports", authMiddleware(http.HandlerFunc(dashHandler.ListImports))).Methods("GET")
+	apiV1.Handle("/imports/{id}", authMiddleware(http.HandlerFunc(dashHandler.GetImport))).Methods("GET")
+	apiV1.Handle("/imports/{id}", authMiddleware(http.HandlerFunc(dashHandler.DeleteImport))).Methods("DELETE")
+	apiV1.Handle("/imports/{id}/cancel", authMiddleware(http.HandlerFunc(dashHandler.CancelImport))).Methods("POST")
+	apiV1.Handle("/imports/{id}/retry", authMiddleware(http.HandlerFunc(dashHandler.RetryImport))).Methods("POST")
+
+	// Replication to other registries (Admin only)
+	apiV1.Handle("/replication", authMiddleware(http.HandlerFunc(dashHandler.GetReplicationStatus))).Methods("GET")
+	apiV1.Handle("/replication/targets", authMiddleware(http.HandlerFunc(dashHandler.CreateReplicationTarget))).Methods("POST")
+	apiV1.Handle("/replication/targets/{id}", authMiddleware(http.HandlerFunc(dashHandler.UpdateReplicationTarget))).Methods("PATCH")
+	apiV1.Handle("/replication/targets/{id}", authMiddleware(http.HandlerFunc(dashHandler.DeleteReplicationTarget))).Methods("DELETE")
+	apiV1.Handle("/replication/targets/{id}/rules", authMiddleware(http.HandlerFunc(dashHandler.CreateReplicationRule))).Methods("POST")
+	apiV1.Handle("/replication/rules/{id}", authMiddleware(http.HandlerFunc(dashHandler.DeleteReplicationRule))).Methods("DELETE")
+	apiV1.Handle("/replication/tasks", authMiddleware(http.HandlerFunc(dashHandler.ListReplicationTasks))).Methods("GET")
+	apiV1.Handle("/replication/tasks/{id}/retry", authMiddleware(http.HandlerFunc(dashHandler.RetryReplicationTask))).Methods("POST")
+
 	// Greedy match for repository name - MUST BE LAST
 	// Use MatcherFunc to ensure we don't accidentally match /manifests/ or /tags/
 	// because {name:.+} is very greedy.
@@ -222,11 +839,22 @@ func main() {
 
 	// Advanced Features API
 	apiV1.HandleFunc("/vulnerabilities/prioritized", advancedHandler.GetPrioritizedVulnerabilities).Methods("GET")
+	apiV1.Handle("/vulnerabilities/prioritized/bulk", authMiddleware(http.HandlerFunc(advancedHandler.BulkPrioritizedVulnerabilities))).Methods("POST")
 	apiV1.HandleFunc("/vulnerabilities/intelligence/{cve}", advancedHandler.GetVulnIntelligence).Methods("GET")
 	apiV1.HandleFunc("/vulnerabilities/refresh-epss", advancedHandler.RefreshEPSS).Methods("POST")
+	apiV1.Handle("/vulnerabilities/epss/import", authMiddleware(http.HandlerFunc(advancedHandler.ImportEPSS))).Methods("POST")
+	// Cluster agents authenticate with RUNTIME_AGENT_TOKEN, not a user session
+	apiV1.HandleFunc("/runtime/report", advancedHandler.ReportRuntime).Methods("POST")
+	apiV1.Handle("/runtime/workloads", authMiddleware(http.HandlerFunc(advancedHandler.ListRuntimeWorkloads))).Methods("GET")
+	apiV1.Handle("/analytics/top-images", authMiddleware(http.HandlerFunc(dashHandler.GetTopImages))).Methods("GET")
+	apiV1.Handle("/search/packages", authMiddleware(http.HandlerFunc(dashHandler.SearchPackages))).Methods("GET")
 	apiV1.Handle("/costs/dashboard", authMiddleware(http.HandlerFunc(advancedHandler.GetCostDashboard))).Methods("GET")
+	apiV1.Handle("/costs/dedup", authMiddleware(http.HandlerFunc(advancedHandler.GetDedupReport))).Methods("GET")
+	apiV1.Handle("/costs/recommendations", authMiddleware(http.HandlerFunc(advancedHandler.GetCostRecommendations))).Methods("GET")
+	apiV1.Handle("/costs/repositories/{name:.+}", authMiddleware(http.HandlerFunc(advancedHandler.GetRepositoryCosts))).Methods("GET")
 	apiV1.Handle("/costs/zombie-images", authMiddleware(http.HandlerFunc(advancedHandler.GetZombieImages))).Methods("GET")
 	apiV1.Handle("/costs/refresh", authMiddleware(http.HandlerFunc(advancedHandler.RefreshCosts))).Methods("POST")
+	apiV1.Handle("/costs/refresh/history", authMiddleware(http.HandlerFunc(advancedHandler.GetCostRefreshHistory))).Methods("GET")
 	apiV1.Handle("/costs/cleanup-zombies", authMiddleware(http.HandlerFunc(advancedHandler.CleanupZombies))).Methods("POST")
 
 	// Auth Service
@@ -235,8 +863,16 @@ func main() {
 	// Middleware (Already declared above)
 	// authMiddleware := middleware.AuthMiddleware
 
+	// Anonymous pull limits (per client IP, shared via Redis)
+	pullLimiter := pulllimit.NewLimiter(redisClient, keyring.Keyfunc, cfg.AnonPullLimit, time.Duration(cfg.AnonPullWindowMinutes)*time.Minute)
+
+	// Blob transfer bandwidth limits (per connection and per user)
+	throttle := bandwidth.NewLimiter(int64(cfg.BandwidthPerConnectionMB)<<20, int64(cfg.BandwidthPerUserMB)<<20)
+
 	// OCI V2 Distribution API
 	v2 := r.PathPrefix("/v2").Subrouter()
+	// Namespace and service account network allowlists (DENIED from elsewhere)
+	v2.Use(networkChecker.Middleware)
 	// Apply Middleware? For granular control we wrap handlers.
 	
 	// Base
@@ -246,23 +882,34 @@ func main() {
 	// Check Blob (HEAD)
 	// {name:.+} matches "repo/subrepo"
 	v2.Handle("/{name:.+}/blobs/{digest}", authMiddleware(http.HandlerFunc(regHandler.CheckBlob))).Methods("HEAD")
-	v2.Handle("/{name:.+}/blobs/{digest}", authMiddleware(http.HandlerFunc(regHandler.GetBlob))).Methods("GET")
+	v2.Handle("/{name:.+}/blobs/{digest}", authMiddleware(throttle.Middleware(http.HandlerFunc(regHandler.GetBlob)))).Methods("GET")
+	v2.Handle("/{name:.+}/blobs/{digest}", authMiddleware(http.HandlerFunc(regHandler.DeleteBlob))).Methods("DELETE")
 
 	// Start Upload (POST)
 	v2.Handle("/{name:.+}/blobs/uploads/", authMiddleware(http.HandlerFunc(regHandler.StartBlobUpload))).Methods("POST")
 	
 	// Patch Upload (PATCH)
-	v2.Handle("/{name:.+}/blobs/uploads/{uuid}", authMiddleware(http.HandlerFunc(regHandler.PatchBlobData))).Methods("PATCH")
+	v2.Handle("/{name:.+}/blobs/uploads/{uuid}", authMiddleware(throttle.Middleware(http.HandlerFunc(regHandler.PatchBlobData)))).Methods("PATCH")
 	
 	// Finish Upload (PUT)
-	v2.Handle("/{name:.+}/blobs/uploads/{uuid}", authMiddleware(http.HandlerFunc(regHandler.PutBlobUpload))).Methods("PUT")
+	v2.Handle("/{name:.+}/blobs/uploads/{uuid}", authMiddleware(throttle.Middleware(http.HandlerFunc(regHandler.PutBlobUpload)))).Methods("PUT")
+
+	// Upload Status (GET)
+	v2.Handle("/{name:.+}/blobs/uploads/{uuid}", authMiddleware(http.HandlerFunc(regHandler.GetUploadStatus))).Methods("GET")
+
+	// Cancel Upload (DELETE)
+	v2.Handle("/{name:.+}/blobs/uploads/{uuid}", authMiddleware(http.HandlerFunc(regHandler.CancelBlobUpload))).Methods("DELETE")
 
 	// Manifests Management
-	v2.Handle("/{name:.+}/manifests/{reference}", http.HandlerFunc(regHandler.GetManifest)).Methods("GET", "HEAD")
+	v2.Handle("/{name:.+}/manifests/{reference}", pullLimiter.Middleware(authMiddleware(http.HandlerFunc(regHandler.GetManifest)))).Methods("GET", "HEAD")
 	v2.Handle("/{name:.+}/manifests/{reference}", authMiddleware(http.HandlerFunc(regHandler.PutManifest))).Methods("PUT")
+	v2.Handle("/{name:.+}/manifests/{reference}", authMiddleware(http.HandlerFunc(regHandler.DeleteManifest))).Methods("DELETE")
 	
 	// Tags List
 	v2.Handle("/{name:.+}/tags/list", authMiddleware(http.HandlerFunc(regHandler.Tags))).Methods("GET")
+
+	// Referrers (signatures, SBOMs, attestations attached to a manifest)
+	v2.Handle("/{name:.+}/referrers/{digest}", authMiddleware(http.HandlerFunc(regHandler.Referrers))).Methods("GET")
 	
 	// Catalog (Listing Repos) - Public for GUI MVP
 	v2.Handle("/_catalog", authMiddleware(http.HandlerFunc(regHandler.Catalog))).Methods("GET")
@@ -292,6 +939,139 @@ func main() {
 		})
 	}
 
+	// Client addresses behind reverse proxies, for everything keyed by IP
+	trustedProxies := cfg.TrustedProxies
+	if trustedProxies == "" && cfg.AnonPullTrustForwarded {
+		log.Printf("Warning: ANON_PULL_TRUST_FORWARDED is deprecated and trusts X-Forwarded-For from any client; set TRUSTED_PROXIES instead\n")
+		trustedProxies = "*"
+	}
+	ipResolver, err := clientip.ParseTrusted(trustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	handler := ipResolver.Middleware(globalMiddleware(r))
+
 	// Start Server with Global Middleware
-	log.Fatal(http.ListenAndServe(cfg.ServerPort, globalMiddleware(r)))
+	srv := &http.Server{Addr: cfg.ServerPort, Handler: handler}
+	if cfg.TLSCertFile != "" {
+		tlsConfig, err := serverTLSConfig(cfg.TLSClientCAFile)
+		if err != nil {
+			log.Fatalf("Invalid TLS_CLIENT_CA_FILE: %v", err)
+		}
+		srv.TLSConfig = tlsConfig
+	}
+
+	// On shutdown the server keeps serving until the scan worker has
+	// requeued its job (an interrupted trivy may still be pulling from it)
+	// and the import and replication workers have queued theirs again, then
+	// drains open requests.
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-shutdown.Done()
+		log.Println("Shutting down...")
+		select {
+		case <-workerDone:
+		case <-time.After(shutdownTimeout):
+			log.Println("Scan Worker did not stop in time")
+		}
+		select {
+		case <-importDone:
+		case <-time.After(shutdownTimeout):
+			log.Println("Import workers did not stop in time")
+		}
+		select {
+		case <-replicationDone:
+		case <-time.After(shutdownTimeout):
+			log.Println("Replication workers did not stop in time")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Server shutdown: %v\n", err)
+		}
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Tracing shutdown: %v\n", err)
+		}
+	}()
+
+	if cfg.TLSCertFile != "" {
+		err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+	log.Println("Server stopped")
+}
+
+// shutdownTimeout bounds each step of a graceful shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// serverTLSConfig verifies client certificates against the CA bundle in
+// clientCAFile when one is given (mTLS). Certificates are optional so
+// browsers and the embedded scanner can still connect; /auth/token signs
+// verified ones in as the identity their subject is mapped to.
+func serverTLSConfig(clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", clientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg, nil
+}
+
+// migrateBlobLayout moves a bucket's blobs from blobs/<digest> to the sharded
+// blobs/<alg>/<xx>/<hex> layout. Reads fall back to the old key meanwhile.
+func migrateBlobLayout(region string, store storage.Driver) {
+	m, ok := store.(storage.LayoutMigrator)
+	if !ok {
+		return
+	}
+	res, err := m.MigrateLayout(context.Background())
+	if err != nil {
+		log.Printf("[Storage] Blob layout migration in %s stopped: %v", region, err)
+	}
+	if res.Moved > 0 || len(res.Errors) > 0 {
+		log.Printf("[Storage] Moved %d blobs (%d bytes) in %s to the sharded layout, %d failed: %v",
+			res.Moved, res.Bytes, region, len(res.Errors), res.Errors)
+	}
+}
+
+// rebuildMetadata walks the storage bucket and recreates the catalog, then
+// queues a rescan of every recovered manifest since scan results are lost.
+func rebuildMetadata(ctx context.Context, meta *metadata.Service, store storage.Driver, q *queue.Service, ownerName string) error {
+	var owner uuid.UUID
+	if err := meta.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", ownerName).Scan(&owner); err != nil {
+		log.Printf("[Recovery] Owner %q not found (%v); recreated repositories will have no owner", ownerName, err)
+	}
+
+	rb := &recovery.Rebuilder{Metadata: meta, Storage: store, Owner: owner}
+	report, err := rb.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	queued := 0
+	if q != nil {
+		for _, m := range report.Rebuilt {
+			if err := q.EnqueueScan(ctx, m.ID, m.Repository, m.Digest); err == nil {
+				queued++
+			}
+		}
+	}
+	log.Printf("[Recovery] Done: %d blobs, %d repositories, %d manifests, %d tags, %d problems, %d scans queued",
+		report.Blobs, report.Repositories, report.Manifests, report.Tags, len(report.Problems), queued)
+	return nil
 }
@@ -0,0 +1,19 @@
+-- 009_dashboard_stats.sql
+-- Per-owner dashboard aggregates, refreshed in the background by the API so
+-- the dashboard no longer runs multi-join aggregates on every page load.
+-- Repositories without an owner are grouped under the nil UUID.
+CREATE TABLE IF NOT EXISTS dashboard_stats (
+    owner_id UUID PRIMARY KEY,
+    repositories INT NOT NULL DEFAULT 0,
+    images INT NOT NULL DEFAULT 0,
+    critical_count INT NOT NULL DEFAULT 0,
+    high_count INT NOT NULL DEFAULT 0,
+    medium_count INT NOT NULL DEFAULT 0,
+    low_count INT NOT NULL DEFAULT 0,
+    storage_bytes BIGINT NOT NULL DEFAULT 0,
+    refreshed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Recent pushes are still read live; make that query an index scan.
+CREATE INDEX IF NOT EXISTS idx_manifests_created_at ON manifests(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_vuln_reports_manifest_scanned ON vulnerability_reports(manifest_id, scanned_at DESC);
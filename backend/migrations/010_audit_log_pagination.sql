@@ -0,0 +1,3 @@
+-- 010_audit_log_pagination.sql
+-- Keyset pagination of /user/audit-logs orders by (created_at, id) per user.
+CREATE INDEX IF NOT EXISTS idx_audit_logs_user_created ON audit_logs(user_id, created_at DESC, id DESC);
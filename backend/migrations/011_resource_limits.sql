@@ -0,0 +1,7 @@
+-- 011_resource_limits.sql
+-- Optional per-user and per-namespace overrides of the global resource limits
+-- (MAX_REPOSITORIES_PER_USER, MAX_TAGS_PER_REPOSITORY, MAX_MANIFESTS_PER_REPOSITORY).
+-- NULL falls back to the global default; 0 means unlimited.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS max_repositories INT;
+ALTER TABLE namespaces ADD COLUMN IF NOT EXISTS max_tags_per_repository INT;
+ALTER TABLE namespaces ADD COLUMN IF NOT EXISTS max_manifests_per_repository INT;
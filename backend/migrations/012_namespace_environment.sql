@@ -0,0 +1,4 @@
+-- 012_namespace_environment.sql
+-- Policy environment per namespace (e.g. 'prod', 'dev'). NULL falls back to
+-- the global POLICY_ENVIRONMENT.
+ALTER TABLE namespaces ADD COLUMN IF NOT EXISTS environment VARCHAR(50);
@@ -0,0 +1,19 @@
+-- 013_runtime_exposure.sql
+-- Images reported as running by cluster agents. Each report replaces the
+-- cluster's previous snapshot; rows older than RUNTIME_REPORT_TTL_MINUTES are
+-- treated as no longer running.
+CREATE TABLE IF NOT EXISTS runtime_workloads (
+    cluster VARCHAR(255) NOT NULL,
+    namespace VARCHAR(255) NOT NULL,
+    kind VARCHAR(50) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    image_ref TEXT,
+    image_digest VARCHAR(255) NOT NULL,
+    internet_exposed BOOLEAN NOT NULL DEFAULT false,
+    reported_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (cluster, namespace, kind, name, image_digest)
+);
+
+CREATE INDEX IF NOT EXISTS idx_runtime_workloads_digest ON runtime_workloads(image_digest);
+
+ALTER TABLE manifest_vuln_priority ADD COLUMN IF NOT EXISTS internet_exposed BOOLEAN DEFAULT false;
@@ -0,0 +1,21 @@
+-- 014_repository_permissions.sql
+-- Explicit per-repository access grants. Authorization consults this table
+-- (plus namespace ownership) instead of matching usernames against
+-- repository names. principal_id is a user ID, or a team ID for team grants.
+CREATE TABLE IF NOT EXISTS repository_permissions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    principal_type VARCHAR(10) NOT NULL CHECK (principal_type IN ('user', 'team')),
+    principal_id UUID NOT NULL,
+    role VARCHAR(10) NOT NULL CHECK (role IN ('read', 'write', 'admin')),
+    granted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (repository_id, principal_type, principal_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_repository_permissions_principal ON repository_permissions(principal_type, principal_id);
+
+-- Existing owners become admins of their repositories.
+INSERT INTO repository_permissions (repository_id, principal_type, principal_id, role)
+SELECT id, 'user', owner_id, 'admin' FROM repositories WHERE owner_id IS NOT NULL
+ON CONFLICT (repository_id, principal_type, principal_id) DO NOTHING;
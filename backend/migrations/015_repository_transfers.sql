@@ -0,0 +1,19 @@
+-- 015_repository_transfers.sql
+-- Requests to move a repository into another namespace. A transfer stays
+-- pending until the owner of the target namespace (or an admin) accepts it.
+CREATE TABLE IF NOT EXISTS repository_transfers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    source_name VARCHAR(512) NOT NULL,
+    target_namespace_id UUID NOT NULL REFERENCES namespaces(id) ON DELETE CASCADE,
+    requested_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled')),
+    resolved_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP
+);
+
+-- At most one open transfer per repository.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_repository_transfers_pending
+    ON repository_transfers(repository_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_repository_transfers_target ON repository_transfers(target_namespace_id, status);
@@ -0,0 +1,5 @@
+-- 016_repository_limits.sql
+-- Per-repository limits on top of namespace quotas. NULL max_tags falls back
+-- to the namespace/global tag limit; NULL or 0 max_size_bytes is unlimited.
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS max_size_bytes BIGINT;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS max_tags INT;
@@ -0,0 +1,4 @@
+-- 017_token_lifetimes.sql
+-- Per-service-account registry token lifetime. NULL uses
+-- REGISTRY_TOKEN_TTL_MINUTES.
+ALTER TABLE service_accounts ADD COLUMN IF NOT EXISTS token_ttl_seconds INT;
@@ -0,0 +1,13 @@
+-- 018_signing_keys.sql
+-- HMAC keys used to sign session and registry tokens. The key without
+-- retired_at is the primary; retired keys keep validating tokens until those
+-- tokens have expired.
+CREATE TABLE IF NOT EXISTS signing_keys (
+    kid VARCHAR(64) PRIMARY KEY,
+    secret TEXT NOT NULL,
+    source VARCHAR(20) NOT NULL DEFAULT 'generated', -- config | generated
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    retired_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_primary ON signing_keys ((retired_at IS NULL)) WHERE retired_at IS NULL;
@@ -0,0 +1,5 @@
+-- 019_image_lint.sql
+-- Best-practice lint findings per manifest, computed at push time.
+-- last_lint_check is NULL for manifests pushed before linting existed.
+ALTER TABLE manifests ADD COLUMN IF NOT EXISTS lint_findings JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE manifests ADD COLUMN IF NOT EXISTS last_lint_check TIMESTAMP WITH TIME ZONE;
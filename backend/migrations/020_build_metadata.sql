@@ -0,0 +1,14 @@
+-- 020_build_metadata.sql
+-- CI build information attached to an image digest. Keyed by digest rather
+-- than manifest so pipelines can record it before pushing the manifest.
+CREATE TABLE IF NOT EXISTS build_metadata (
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    digest VARCHAR(255) NOT NULL,
+    pipeline_url TEXT NOT NULL DEFAULT '',
+    commit_sha VARCHAR(64) NOT NULL DEFAULT '',
+    branch VARCHAR(255) NOT NULL DEFAULT '',
+    builder VARCHAR(255) NOT NULL DEFAULT '',
+    reported_by VARCHAR(255) NOT NULL DEFAULT '',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (repository_id, digest)
+);
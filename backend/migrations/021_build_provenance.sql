@@ -0,0 +1,7 @@
+-- 021_build_provenance.sql
+-- Build metadata recovered from SLSA provenance attestations.
+ALTER TABLE build_metadata ADD COLUMN IF NOT EXISTS workflow VARCHAR(512) NOT NULL DEFAULT '';
+ALTER TABLE build_metadata ADD COLUMN IF NOT EXISTS source_repository VARCHAR(512) NOT NULL DEFAULT '';
+ALTER TABLE build_metadata ADD COLUMN IF NOT EXISTS provenance VARCHAR(20) NOT NULL DEFAULT ''; -- '' (API) | buildkit | sigstore
+ALTER TABLE build_metadata ADD COLUMN IF NOT EXISTS verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE build_metadata ADD COLUMN IF NOT EXISTS verification_error TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+-- 022_scan_progress.sql
+-- Live scan progress: the current stage of a running scan and the time each
+-- stage was reached ([{"stage": "db_download", "detail": "", "at": "..."}]).
+ALTER TABLE vulnerability_reports ADD COLUMN IF NOT EXISTS stage VARCHAR(50);
+ALTER TABLE vulnerability_reports ADD COLUMN IF NOT EXISTS stage_detail TEXT NOT NULL DEFAULT '';
+ALTER TABLE vulnerability_reports ADD COLUMN IF NOT EXISTS stage_updated_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE vulnerability_reports ADD COLUMN IF NOT EXISTS stages JSONB NOT NULL DEFAULT '[]';
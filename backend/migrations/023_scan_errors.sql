@@ -0,0 +1,3 @@
+-- 023_scan_errors.sql
+-- Why a scan failed or was refused (e.g. the vulnerability DB is too old).
+ALTER TABLE vulnerability_reports ADD COLUMN IF NOT EXISTS error TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,20 @@
+-- 024_alert_rules.sql
+-- Per-repository alert rules, evaluated after every completed scan. A rule
+-- fires when the scan has findings at or above min_severity (and, when set,
+-- at or above min_epss), optionally only those the previous scan didn't have.
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    min_severity VARCHAR(10) NOT NULL DEFAULT 'CRITICAL' CHECK (min_severity IN ('CRITICAL', 'HIGH', 'MEDIUM', 'LOW', 'UNKNOWN')),
+    min_epss DECIMAL(5,4) NOT NULL DEFAULT 0,
+    new_only BOOLEAN NOT NULL DEFAULT true,
+    channel VARCHAR(10) NOT NULL CHECK (channel IN ('webhook', 'email', 'slack')),
+    target TEXT NOT NULL, -- webhook or Slack incoming webhook URL, or email address
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_triggered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_rules_repository ON alert_rules(repository_id);
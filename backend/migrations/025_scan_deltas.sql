@@ -0,0 +1,4 @@
+-- 025_scan_deltas.sql
+-- New, fixed and existing findings of a completed scan compared with the
+-- previous one (see scanner.ScanDelta).
+ALTER TABLE vulnerability_reports ADD COLUMN IF NOT EXISTS delta JSONB;
@@ -0,0 +1,13 @@
+-- 026_repository_cost_history.sql
+-- Daily snapshot of each repository's costs, kept for the trend of
+-- GET /api/v1/costs/repositories/{name}.
+CREATE TABLE IF NOT EXISTS repository_cost_history (
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    pull_count BIGINT NOT NULL DEFAULT 0,
+    storage_cost_usd DECIMAL(12,4) NOT NULL DEFAULT 0,
+    bandwidth_cost_usd DECIMAL(12,4) NOT NULL DEFAULT 0,
+    total_cost_usd DECIMAL(12,4) NOT NULL DEFAULT 0,
+    PRIMARY KEY (repository_id, day)
+);
@@ -0,0 +1,10 @@
+-- 027_blob_storage_classes.sql
+-- Storage class of each blob (synced from the bucket, where lifecycle rules
+-- may move blobs to infrequent access or archive classes) so costs use the
+-- class's price.
+ALTER TABLE blobs ADD COLUMN IF NOT EXISTS storage_class VARCHAR(32) NOT NULL DEFAULT 'STANDARD';
+
+-- RefreshAllCosts upserts one row per manifest
+DELETE FROM storage_costs a USING storage_costs b
+WHERE a.manifest_id = b.manifest_id AND a.ctid < b.ctid;
+CREATE UNIQUE INDEX IF NOT EXISTS storage_costs_manifest_id_key ON storage_costs(manifest_id);
@@ -0,0 +1,15 @@
+-- 028_cost_refresh_runs.sql
+-- History of cost refreshes (RefreshAllCosts and zombie detection), scheduled
+-- every COST_REFRESH_HOURS or started from the dashboard.
+CREATE TABLE IF NOT EXISTS cost_refresh_runs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    trigger VARCHAR(10) NOT NULL CHECK (trigger IN ('schedule', 'manual')),
+    status VARCHAR(10) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'succeeded', 'failed')),
+    images INT NOT NULL DEFAULT 0,
+    zombies INT NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_cost_refresh_runs_started ON cost_refresh_runs(started_at DESC);
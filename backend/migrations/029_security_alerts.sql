@@ -0,0 +1,29 @@
+-- 029_security_alerts.sql
+-- Security alerts raised by the audit anomaly detector (see package anomaly),
+-- and the addresses each principal has signed in from.
+CREATE TABLE IF NOT EXISTS security_alerts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind VARCHAR(50) NOT NULL, -- 'mass_deletion', 'failed_logins', 'new_location', 'off_hours_service_account'
+    severity VARCHAR(10) NOT NULL DEFAULT 'medium' CHECK (severity IN ('low', 'medium', 'high')),
+    subject VARCHAR(255) NOT NULL, -- user ID, "serviceaccount:<name>", username or IP the alert is about
+    summary TEXT NOT NULL,
+    details JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    acknowledged_at TIMESTAMP WITH TIME ZONE,
+    acknowledged_by UUID REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_alerts_created ON security_alerts(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_security_alerts_kind_subject ON security_alerts(kind, subject, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS principal_locations (
+    principal VARCHAR(255) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    country VARCHAR(8) NOT NULL DEFAULT '',
+    first_seen TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_seen TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (principal, ip)
+);
+
+-- Failed sign-ins are counted per username and per IP
+CREATE INDEX IF NOT EXISTS idx_audit_logs_action_created ON audit_logs(action, created_at DESC);
@@ -0,0 +1,19 @@
+-- 030_security_events.sql
+-- Failed sign-ins and requests with invalid tokens, with where they came from.
+-- Failed sign-ins are counted by the failed login alert and the sign-in lockout
+-- (see package anomaly).
+CREATE TABLE IF NOT EXISTS security_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind VARCHAR(30) NOT NULL, -- 'login_failed', 'invalid_token'
+    username VARCHAR(255) NOT NULL DEFAULT '', -- target of a failed sign-in
+    method VARCHAR(30) NOT NULL DEFAULT '', -- 'password', 'registry', 'bearer'
+    reason TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    path TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_created ON security_events(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_security_events_ip ON security_events(ip, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_security_events_username ON security_events(username, created_at DESC) WHERE username <> '';
@@ -0,0 +1,5 @@
+-- 031_ip_allowlists.sql
+-- Networks pushes and pulls may come from: per namespace, and per service
+-- account (e.g. its CI runners). NULL or empty allows any address.
+ALTER TABLE namespaces ADD COLUMN IF NOT EXISTS allowed_cidrs TEXT[];
+ALTER TABLE service_accounts ADD COLUMN IF NOT EXISTS allowed_cidrs TEXT[];
@@ -0,0 +1,12 @@
+-- 032_client_certificates.sql
+-- Client certificate subjects (mTLS) and the user or service account each one
+-- signs in as at /auth/token.
+CREATE TABLE IF NOT EXISTS client_certificates (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    subject VARCHAR(512) NOT NULL UNIQUE, -- "CN=ci-runner" or a full subject DN
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    service_account_id UUID REFERENCES service_accounts(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    CHECK ((user_id IS NULL) <> (service_account_id IS NULL))
+);
@@ -0,0 +1,35 @@
+-- 033_registry_imports.sql
+-- Bulk imports of repositories from another registry (Harbor, ECR, Docker
+-- Hub, ...). Each repository is an item copied by a background job; items
+-- remember the tags they finished, so an interrupted item resumes where it
+-- stopped. The source password is kept only until the import finishes.
+CREATE TABLE IF NOT EXISTS registry_imports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    source_url VARCHAR(512) NOT NULL,
+    username VARCHAR(255) NOT NULL DEFAULT '',
+    password TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'succeeded', 'failed', 'cancelled')),
+    requested_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_registry_imports_created ON registry_imports(created_at DESC);
+
+CREATE TABLE IF NOT EXISTS registry_import_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    import_id UUID NOT NULL REFERENCES registry_imports(id) ON DELETE CASCADE,
+    source_repository VARCHAR(512) NOT NULL,
+    target_repository VARCHAR(512) NOT NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}', -- tags to copy; empty copies every tag
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'failed', 'cancelled')),
+    done_tags TEXT[] NOT NULL DEFAULT '{}',
+    tags_total INT NOT NULL DEFAULT 0,
+    blobs_copied INT NOT NULL DEFAULT 0,
+    bytes_copied BIGINT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW() -- heartbeat of a running item
+);
+
+CREATE INDEX IF NOT EXISTS idx_registry_import_items_import ON registry_import_items(import_id);
+CREATE INDEX IF NOT EXISTS idx_registry_import_items_status ON registry_import_items(status, updated_at);
@@ -0,0 +1,16 @@
+-- 034_tag_expiry.sql
+-- Tags can expire (e.g. PR preview tags after a week). A background job
+-- deletes expired tags and collects the garbage they leave. Rules give tags
+-- matching a glob an expiry whenever they are pushed.
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_tags_expires ON tags(expires_at) WHERE expires_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS tag_expiry_rules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    pattern VARCHAR(255) NOT NULL,
+    ttl_seconds BIGINT NOT NULL CHECK (ttl_seconds > 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (repository_id, pattern)
+);
@@ -0,0 +1,11 @@
+-- 035_weekly_reports.sql
+-- Weekly summary emails to namespace owners (see reports.Weekly). Owners
+-- can unsubscribe; each repository's health grade at the last report is
+-- kept to report grade changes.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS weekly_report BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE TABLE IF NOT EXISTS weekly_report_grades (
+    repository_id UUID PRIMARY KEY REFERENCES repositories(id) ON DELETE CASCADE,
+    grade VARCHAR(5) NOT NULL,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
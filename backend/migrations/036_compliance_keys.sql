@@ -0,0 +1,9 @@
+-- 036_compliance_keys.sql
+-- Ed25519 keys compliance reports are signed with. The newest signs; all are
+-- kept so reports handed to auditors stay verifiable.
+CREATE TABLE IF NOT EXISTS compliance_keys (
+    id VARCHAR(64) PRIMARY KEY,
+    seed BYTEA NOT NULL,
+    public_key BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
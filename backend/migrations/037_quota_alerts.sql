@@ -0,0 +1,4 @@
+-- 037_quota_alerts.sql
+-- Highest storage quota threshold (percent) a namespace's owner was alerted
+-- about, so each crossing alerts once (see quota.Monitor). 0 = none.
+ALTER TABLE namespaces ADD COLUMN IF NOT EXISTS quota_alert_level INT NOT NULL DEFAULT 0;
@@ -0,0 +1,15 @@
+-- 038_blob_integrity.sql
+-- Result of the last integrity check of each blob (see integrity.Verifier):
+-- its content re-read from storage and hashed against the digest. Blobs are
+-- checked least recently verified first.
+CREATE TABLE IF NOT EXISTS blob_integrity (
+    digest VARCHAR(255) PRIMARY KEY REFERENCES blobs(digest) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL, -- ok, corrupt, missing
+    actual_digest VARCHAR(255),
+    detail TEXT,
+    checked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    detected_at TIMESTAMP WITH TIME ZONE -- when it was first found bad; NULL while ok
+);
+
+CREATE INDEX IF NOT EXISTS idx_blob_integrity_checked ON blob_integrity(checked_at);
+CREATE INDEX IF NOT EXISTS idx_blob_integrity_bad ON blob_integrity(status) WHERE status <> 'ok';
@@ -0,0 +1,13 @@
+-- 039_namespace_policies.sql
+-- Default policy fragments of namespaces (see policy.Fragment). A fragment
+-- applies to the repositories under its namespace and path, on top of the
+-- global policy: it can add violations but never lift one.
+CREATE TABLE IF NOT EXISTS namespace_policies (
+    namespace_id UUID NOT NULL REFERENCES namespaces(id) ON DELETE CASCADE,
+    path VARCHAR(255) NOT NULL DEFAULT '', -- '' for the whole namespace
+    rules JSONB NOT NULL DEFAULT '{}',
+    rego TEXT NOT NULL DEFAULT '',
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (namespace_id, path)
+);
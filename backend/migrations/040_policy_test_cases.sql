@@ -0,0 +1,13 @@
+-- 040_policy_test_cases.sql
+-- Saved policy test cases (see policy.TestCase): an evaluation input and the
+-- expected verdict. POST /api/v1/policy/test runs a candidate policy against
+-- them before it is applied.
+CREATE TABLE IF NOT EXISTS policy_test_cases (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL UNIQUE,
+    input JSONB NOT NULL,
+    expect_allow BOOLEAN NOT NULL,
+    expect_violations JSONB NOT NULL DEFAULT '[]',
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
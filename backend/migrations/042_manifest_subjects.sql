@@ -0,0 +1,11 @@
+-- 042_manifest_subjects.sql
+-- Artifacts (signatures, SBOMs, attestations) whose manifest names another
+-- manifest of the same repository in its "subject" field. The subject is
+-- kept by digest: clients may push an artifact before its subject.
+CREATE TABLE IF NOT EXISTS manifest_subjects (
+    manifest_id UUID PRIMARY KEY REFERENCES manifests(id) ON DELETE CASCADE,
+    subject_digest VARCHAR(255) NOT NULL,
+    artifact_type VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_manifest_subjects_subject ON manifest_subjects(subject_digest);
@@ -0,0 +1,16 @@
+-- 043_scan_in_flight.sql
+-- A manifest has at most one scan in progress (pending or scanning), which
+-- a restarted scan takes over. Scans started while an older one was stuck
+-- used to add a record each, leaving the older ones 'scanning' for good;
+-- all but the latest are failed before the index is built.
+UPDATE vulnerability_reports v
+SET status = 'failed', error = 'Scan abandoned: superseded by a later scan'
+WHERE v.status IN ('pending', 'scanning')
+  AND EXISTS (
+    SELECT 1 FROM vulnerability_reports l
+    WHERE l.manifest_id = v.manifest_id AND l.status IN ('pending', 'scanning')
+      AND (COALESCE(l.scanned_at, '-infinity'), l.id) > (COALESCE(v.scanned_at, '-infinity'), v.id)
+  );
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_vuln_reports_in_flight
+    ON vulnerability_reports(manifest_id) WHERE status IN ('pending', 'scanning');
@@ -0,0 +1,4 @@
+-- 044_manifest_digest_index.sql
+-- Queued scans reuse a recent report of the same digest from any
+-- repository (SCAN_REUSE_HOURS), so manifests are looked up by digest alone.
+CREATE INDEX IF NOT EXISTS idx_manifests_digest ON manifests(digest);
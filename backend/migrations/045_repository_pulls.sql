@@ -0,0 +1,13 @@
+-- 045_repository_pulls.sql
+-- Daily pull counts per repository and tag, for the popular images of
+-- GET /api/v1/analytics/top-images. Pulls by digest are counted with an
+-- empty tag. Kept per repository so deleted manifests still count.
+CREATE TABLE IF NOT EXISTS repository_pulls (
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    tag VARCHAR(255) NOT NULL DEFAULT '',
+    day DATE NOT NULL,
+    pulls BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (repository_id, tag, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_repository_pulls_day ON repository_pulls(day);
@@ -0,0 +1,44 @@
+-- 046_replication.sql
+-- Push-based replication to other registries. Targets are remote registries
+-- with the credentials pushes sign in with; rules pick the repositories and
+-- tags each target receives. Every push of a matching tag becomes a task,
+-- queued in Redis and retried with backoff; the table keeps its outcome for
+-- GET /api/v1/replication.
+CREATE TABLE IF NOT EXISTS replication_targets (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL UNIQUE,
+    url VARCHAR(512) NOT NULL,
+    username VARCHAR(255) NOT NULL DEFAULT '',
+    password TEXT NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS replication_rules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    target_id UUID NOT NULL REFERENCES replication_targets(id) ON DELETE CASCADE,
+    repository_pattern VARCHAR(255) NOT NULL, -- glob over the full name, e.g. "team/*"
+    tag_pattern VARCHAR(255) NOT NULL DEFAULT '*',
+    remote_prefix VARCHAR(255) NOT NULL DEFAULT '', -- prepended to the repository name on the target
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (target_id, repository_pattern, tag_pattern)
+);
+
+CREATE TABLE IF NOT EXISTS replication_tasks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    target_id UUID NOT NULL REFERENCES replication_targets(id) ON DELETE CASCADE,
+    repository VARCHAR(512) NOT NULL,
+    remote_repository VARCHAR(512) NOT NULL,
+    tag VARCHAR(255) NOT NULL,
+    digest VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    attempts INT NOT NULL DEFAULT 0,
+    blobs_copied INT NOT NULL DEFAULT 0,
+    bytes_copied BIGINT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW() -- heartbeat of a running task
+);
+
+CREATE INDEX IF NOT EXISTS idx_replication_tasks_target ON replication_tasks(target_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_replication_tasks_status ON replication_tasks(status, updated_at);
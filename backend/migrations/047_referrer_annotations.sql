@@ -0,0 +1,4 @@
+-- 047_referrer_annotations.sql
+-- Annotations of artifact manifests, returned with their descriptors by the
+-- referrers API.
+ALTER TABLE manifest_subjects ADD COLUMN IF NOT EXISTS annotations JSONB NOT NULL DEFAULT '{}';
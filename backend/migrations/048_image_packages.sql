@@ -0,0 +1,59 @@
+-- 048_image_packages.sql
+-- Package inventory of every scanned manifest, from its latest completed
+-- Trivy report, so GET /api/v1/search/packages can find the images holding
+-- a package (or affected by a CVE) without reading every report.
+-- vulnerabilities lists the IDs reported against the package.
+CREATE TABLE IF NOT EXISTS image_packages (
+    manifest_id UUID NOT NULL REFERENCES manifests(id) ON DELETE CASCADE,
+    target TEXT NOT NULL DEFAULT '',
+    pkg_type VARCHAR(64) NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    version TEXT NOT NULL DEFAULT '',
+    purl TEXT NOT NULL DEFAULT '',
+    vulnerabilities TEXT[] NOT NULL DEFAULT '{}',
+    PRIMARY KEY (manifest_id, target, name, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_image_packages_name ON image_packages(LOWER(name), version);
+CREATE INDEX IF NOT EXISTS idx_image_packages_vulnerabilities ON image_packages USING GIN (vulnerabilities);
+
+-- index_image_packages replaces the inventory of a manifest with the
+-- packages of a Trivy report: its package list (scans with --list-all-pkgs)
+-- and the packages its vulnerabilities name, for older reports without one.
+CREATE OR REPLACE FUNCTION index_image_packages(manifest UUID, report JSONB) RETURNS void AS $$
+    DELETE FROM image_packages WHERE manifest_id = manifest;
+
+    WITH results AS (
+        SELECT rs FROM jsonb_array_elements(CASE WHEN jsonb_typeof(report->'Results') = 'array'
+            THEN report->'Results' ELSE '[]'::jsonb END) rs
+    ), vulns AS (
+        SELECT rs->>'Target' AS target, rs->>'Type' AS pkg_type, v->>'PkgName' AS name,
+               v->>'InstalledVersion' AS version, v->'PkgIdentifier'->>'PURL' AS purl, v->>'VulnerabilityID' AS id
+        FROM results CROSS JOIN LATERAL jsonb_array_elements(CASE WHEN jsonb_typeof(rs->'Vulnerabilities') = 'array'
+            THEN rs->'Vulnerabilities' ELSE '[]'::jsonb END) v
+    ), pkgs AS (
+        SELECT rs->>'Target' AS target, rs->>'Type' AS pkg_type, p->>'Name' AS name,
+               p->>'Version' AS version, p->'Identifier'->>'PURL' AS purl
+        FROM results CROSS JOIN LATERAL jsonb_array_elements(CASE WHEN jsonb_typeof(rs->'Packages') = 'array'
+            THEN rs->'Packages' ELSE '[]'::jsonb END) p
+        UNION
+        SELECT target, pkg_type, name, version, purl FROM vulns
+    )
+    INSERT INTO image_packages (manifest_id, target, pkg_type, name, version, purl, vulnerabilities)
+    SELECT manifest, COALESCE(p.target, ''), COALESCE(p.pkg_type, ''), p.name, COALESCE(p.version, ''), COALESCE(p.purl, ''),
+           COALESCE((SELECT array_agg(DISTINCT v.id ORDER BY v.id) FROM vulns v
+                     WHERE v.target IS NOT DISTINCT FROM p.target AND v.name = p.name
+                       AND v.version IS NOT DISTINCT FROM p.version AND v.id IS NOT NULL), '{}')
+    FROM pkgs p
+    WHERE COALESCE(p.name, '') <> ''
+    ON CONFLICT DO NOTHING;
+$$ LANGUAGE SQL;
+
+-- Index the reports stored before this migration.
+SELECT index_image_packages(lr.manifest_id, lr.report_json)
+FROM (
+    SELECT DISTINCT ON (manifest_id) manifest_id, report_json
+    FROM vulnerability_reports
+    WHERE status = 'completed' AND report_json IS NOT NULL
+    ORDER BY manifest_id, scanned_at DESC
+) lr;
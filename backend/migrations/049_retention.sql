@@ -0,0 +1,18 @@
+-- 049_retention.sql
+-- Retention policies prune repositories on a schedule. A policy keeps the
+-- keep_last most recently pushed tags and every tag matching keep_pattern (a
+-- regular expression), and deletes the other tags; manifests left untagged
+-- and neither pushed nor pulled for untagged_days are deleted too. Zero or
+-- empty disables a rule.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    repository_id UUID PRIMARY KEY REFERENCES repositories(id) ON DELETE CASCADE,
+    keep_last INTEGER NOT NULL DEFAULT 0 CHECK (keep_last >= 0),
+    keep_pattern VARCHAR(255) NOT NULL DEFAULT '',
+    untagged_days INTEGER NOT NULL DEFAULT 0 CHECK (untagged_days >= 0),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    -- Claimed by the instance enforcing the policy, then the outcome
+    last_run_at TIMESTAMP WITH TIME ZONE,
+    last_deleted_tags INTEGER NOT NULL DEFAULT 0,
+    last_deleted_manifests INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,19 @@
+-- 050_gc_runs.sql
+-- History of garbage collections: started by an admin, after expired tags
+-- were deleted, or by GC_SCHEDULE. A scheduled run records the time it was
+-- scheduled for, so with several instances only one runs each occurrence.
+CREATE TABLE IF NOT EXISTS gc_runs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    trigger VARCHAR(20) NOT NULL, -- manual, schedule or tag-expiry
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    scheduled_for TIMESTAMP WITH TIME ZONE UNIQUE,
+    status VARCHAR(20) NOT NULL DEFAULT 'running', -- running, succeeded, failed or interrupted
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE,
+    blobs_deleted BIGINT NOT NULL DEFAULT 0,
+    manifests_deleted BIGINT NOT NULL DEFAULT 0,
+    space_freed BIGINT NOT NULL DEFAULT 0,
+    errors TEXT[] NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS idx_gc_runs_started ON gc_runs(started_at DESC);
@@ -0,0 +1,8 @@
+-- 051_team_namespace_roles.sql
+-- A team can hold a role on its whole namespace, not only on single
+-- repositories: it applies to every repository of the namespace, including
+-- ones pushed later. Members get the higher of it and their other roles.
+ALTER TABLE teams ADD COLUMN IF NOT EXISTS namespace_role VARCHAR(10)
+    CHECK (namespace_role IN ('read', 'write', 'admin'));
+
+CREATE INDEX IF NOT EXISTS idx_teams_namespace_role ON teams(namespace_id) WHERE namespace_role IS NOT NULL;
@@ -0,0 +1,5 @@
+-- 052_ldap_users.sql
+-- Users who sign in with LDAP get an account on their first sign-in. Their
+-- password is checked by the directory, never against password_hash.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_source VARCHAR(20) NOT NULL DEFAULT 'local'
+    CHECK (auth_source IN ('local', 'ldap'));
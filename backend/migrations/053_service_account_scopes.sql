@@ -0,0 +1,5 @@
+-- 053_service_account_scopes.sql
+-- Repositories and actions a service account's tokens are limited to, as
+-- [{"repository":"acme/*","actions":["pull"]}, ...]. NULL or empty leaves
+-- the account unscoped: it may pull and push every repository.
+ALTER TABLE service_accounts ADD COLUMN IF NOT EXISTS scopes JSONB;
@@ -0,0 +1,16 @@
+-- 054_blob_links.sql
+-- Blobs uploaded or mounted into a repository. Blobs are stored once for
+-- every repository; a repository may only use one its manifests reference
+-- or that was linked to it here, so a pusher can't claim another tenant's
+-- blob by its digest. Links are keyed by name, as a first push uploads
+-- its blobs before the repository exists; they are dropped with the
+-- repository (or by GC when no repository took the name) and move with it
+-- on transfer.
+CREATE TABLE IF NOT EXISTS blob_links (
+    repository VARCHAR(512) NOT NULL, -- "namespace/name"
+    digest VARCHAR(255) NOT NULL REFERENCES blobs(digest) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (repository, digest)
+);
+
+CREATE INDEX IF NOT EXISTS idx_blob_links_digest ON blob_links(digest);
@@ -0,0 +1,206 @@
+// Package advisor emails namespace owners a weekly digest of the images they
+// should rebuild because a patched release of their base image is available.
+package advisor
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/registryx/registryx/backend/pkg/email"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+)
+
+// sentKeyPrefix marks a week's digest as sent in Redis, so only one instance
+// sends it and restarts don't send it twice.
+const sentKeyPrefix = "rebuild-digest:"
+
+// maxDigestImages caps the images listed in one email.
+const maxDigestImages = 25
+
+// Digest sends the weekly rebuild recommendation email.
+type Digest struct {
+	DB         *sql.DB
+	Metadata   *metadata.Service
+	Email      *email.Service
+	Weekday    time.Weekday
+	DefaultEnv string // environment of namespaces without one
+
+	rdb *redis.Client
+
+	mu       sync.Mutex
+	lastWeek string // in-memory marker when Redis is unavailable
+}
+
+// NewDigest creates a digest sent on the given day ("monday", "tue", ...).
+func NewDigest(db *sql.DB, meta *metadata.Service, mail *email.Service, rdb *redis.Client, day string) (*Digest, error) {
+	weekday, err := parseWeekday(day)
+	if err != nil {
+		return nil, err
+	}
+	return &Digest{DB: db, Metadata: meta, Email: mail, Weekday: weekday, rdb: rdb}, nil
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	day = strings.ToLower(strings.TrimSpace(day))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if day == name || day == name[:3] {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", day)
+}
+
+// Run checks every hour whether this week's digest is due and sends it once,
+// until ctx is cancelled.
+func (d *Digest) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		if now.Weekday() == d.Weekday && d.claim(ctx, now) {
+			if sent, err := d.SendAll(ctx); err != nil {
+				fmt.Printf("[Advisor] Weekly digest failed: %v\n", err)
+			} else {
+				fmt.Printf("[Advisor] Sent weekly rebuild digest to %d owners\n", sent)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim marks this week's digest as taken and reports whether this caller
+// should send it.
+func (d *Digest) claim(ctx context.Context, now time.Time) bool {
+	year, week := now.ISOWeek()
+	key := fmt.Sprintf("%d-W%02d", year, week)
+	if d.rdb != nil {
+		ok, err := d.rdb.SetNX(ctx, sentKeyPrefix+key, now.Unix(), 8*24*time.Hour).Result()
+		if err == nil {
+			return ok
+		}
+		fmt.Printf("[Advisor] Failed to claim weekly digest in Redis, using local state: %v\n", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastWeek == key {
+		return false
+	}
+	d.lastWeek = key
+	return true
+}
+
+// SendAll emails every namespace owner the images in their namespaces that
+// need a rebuild, and returns the number of emails sent. Owners with nothing
+// to rebuild get no email.
+func (d *Digest) SendAll(ctx context.Context) (int, error) {
+	if !d.Email.IsEnabled() {
+		return 0, nil
+	}
+
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT DISTINCT u.id, u.username, u.email
+		FROM users u JOIN namespaces n ON n.owner_id = u.id
+		WHERE u.email <> ''`)
+	if err != nil {
+		return 0, err
+	}
+	type owner struct {
+		id              uuid.UUID
+		username, email string
+	}
+	var owners []owner
+	for rows.Next() {
+		var o owner
+		if err := rows.Scan(&o.id, &o.username, &o.email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		owners = append(owners, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, o := range owners {
+		// Same visibility rules as the API: bases private to someone else stay hidden.
+		recs, err := d.Metadata.GetRebuildRecommendations(ctx, o.id, "user", d.DefaultEnv)
+		if err != nil {
+			return sent, err
+		}
+		own := recs[:0]
+		for _, rec := range recs {
+			if rec.OwnerID == o.id {
+				own = append(own, rec)
+			}
+		}
+		if len(own) == 0 {
+			continue
+		}
+
+		body, err := render(o.username, own)
+		if err != nil {
+			return sent, err
+		}
+		subject := fmt.Sprintf("%d image(s) to rebuild on patched base images", len(own))
+		if err := d.Email.Send(o.email, subject, body); err != nil {
+			fmt.Printf("[Advisor] Failed to send digest to %s: %v\n", o.username, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+var digestTemplate = template.Must(template.New("digest").Parse(`
+<html>
+<body>
+    <h2>Images to rebuild</h2>
+    <p>Hi {{.Username}}, newer releases of these base images fix vulnerabilities your images still carry. Rebuilding picks up the fixes.</p>
+    <table cellpadding="6" style="border-collapse: collapse">
+        <tr><th align="left">Image</th><th align="left">Environment</th><th align="left">Rebuild on</th><th align="left">Fixes</th><th align="right">Priority</th><th align="right">Pulls</th></tr>
+        {{range .Images}}
+        <tr>
+            <td>{{.Repository}}{{if .Tags}}:{{index .Tags 0}}{{end}}</td>
+            <td>{{.Environment}}</td>
+            <td>{{.BaseRepository}}{{if .PatchedTags}}:{{index .PatchedTags 0}}{{else}}@{{.PatchedDigest}}{{end}}</td>
+            <td>{{.FixedCount}} CVE(s){{if .FixedCVEs}}: {{index .FixedCVEs 0}}{{if gt .FixedCount 1}}, ...{{end}}{{end}}</td>
+            <td align="right">{{.PriorityScore}}</td>
+            <td align="right">{{.PullCount}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{if .More}}<p>... and {{.More}} more. See GET /api/v1/rebuild-recommendations for the full list.</p>{{end}}
+</body>
+</html>
+`))
+
+func render(username string, recs []metadata.RebuildRecommendation) (string, error) {
+	data := struct {
+		Username string
+		Images   []metadata.RebuildRecommendation
+		More     int
+	}{Username: username, Images: recs}
+	if len(recs) > maxDigestImages {
+		data.Images, data.More = recs[:maxDigestImages], len(recs)-maxDigestImages
+	}
+	var buf bytes.Buffer
+	if err := digestTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
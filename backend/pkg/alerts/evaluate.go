@@ -0,0 +1,318 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/scanner"
+)
+
+// maxListedFindings caps the findings spelled out in Slack messages and emails;
+// webhooks always get all of them.
+const maxListedFindings = 20
+
+// Finding is one vulnerable package of a scan.
+type Finding struct {
+	CVE              string  `json:"cve"`
+	Package          string  `json:"package"`
+	InstalledVersion string  `json:"installedVersion"`
+	FixedVersion     string  `json:"fixedVersion,omitempty"`
+	Severity         string  `json:"severity"`
+	Title            string  `json:"title,omitempty"`
+	EPSS             float64 `json:"epss"`
+}
+
+// Alert is what a rule delivers; webhooks receive it as JSON.
+type Alert struct {
+	RuleID     uuid.UUID `json:"ruleId"`
+	Rule       string    `json:"rule"`
+	Repository string    `json:"repository"`
+	Reference  string    `json:"reference"`
+	Digest     string    `json:"digest"`
+	NewOnly    bool      `json:"newOnly"`
+	Findings   []Finding `json:"findings"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+type reportVulns struct {
+	Results []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+			Title            string `json:"Title"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// parseFindings lists a report's findings, one per CVE and package.
+func parseFindings(report []byte) ([]Finding, error) {
+	var rv reportVulns
+	if err := json.Unmarshal(report, &rv); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var findings []Finding
+	for _, res := range rv.Results {
+		for _, v := range res.Vulnerabilities {
+			key := v.VulnerabilityID + "|" + v.PkgName
+			if v.VulnerabilityID == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			findings = append(findings, Finding{
+				CVE:              v.VulnerabilityID,
+				Package:          v.PkgName,
+				InstalledVersion: v.InstalledVersion,
+				FixedVersion:     v.FixedVersion,
+				Severity:         strings.ToUpper(v.Severity),
+				Title:            v.Title,
+			})
+		}
+	}
+	return findings, nil
+}
+
+// Evaluate runs the repository's enabled rules against the manifest's latest
+// scan, once it has completed, and delivers the alerts that match. Findings
+// are "new" as classified by the scan's delta (see scanner.ScanDelta), so
+// rescans don't repeat them and pushing an image that introduces a CVE
+// alerts too. Delivery errors are logged, not returned.
+func (s *Service) Evaluate(ctx context.Context, manifestID uuid.UUID, repoName, reference string) error {
+	var repoID uuid.UUID
+	var digest string
+	if err := s.DB.QueryRowContext(ctx, `SELECT repository_id, digest FROM manifests WHERE id = $1`, manifestID).Scan(&repoID, &digest); err != nil {
+		return err
+	}
+
+	rules, err := s.enabledRules(ctx, repoID)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	var status string
+	var report, rawDelta []byte
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT status, COALESCE(report_json, '{}'), delta FROM vulnerability_reports
+		WHERE manifest_id = $1 ORDER BY scanned_at DESC LIMIT 1`, manifestID).Scan(&status, &report, &rawDelta)
+	if err == sql.ErrNoRows || (err == nil && status != "completed") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	findings, err := parseFindings(report)
+	if err != nil {
+		return fmt.Errorf("invalid report: %w", err)
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+	if err := s.addEPSS(ctx, findings); err != nil {
+		return err
+	}
+
+	// Without a delta (it couldn't be computed) every finding counts as new.
+	var isNew map[string]bool
+	if rawDelta != nil {
+		var delta scanner.ScanDelta
+		if err := json.Unmarshal(rawDelta, &delta); err != nil {
+			return fmt.Errorf("invalid scan delta: %w", err)
+		}
+		isNew = make(map[string]bool, len(delta.New))
+		for _, f := range delta.New {
+			isNew[f.Key()] = true
+		}
+	}
+
+	for _, rule := range rules {
+		var matched []Finding
+		for _, f := range findings {
+			if severityRank[f.Severity] < severityRank[rule.MinSeverity] || f.EPSS < rule.MinEPSS {
+				continue
+			}
+			if rule.NewOnly && isNew != nil && !isNew[scanner.DeltaFinding{CVE: f.CVE, Package: f.Package}.Key()] {
+				continue
+			}
+			matched = append(matched, f)
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		sort.SliceStable(matched, func(i, j int) bool {
+			if a, b := severityRank[matched[i].Severity], severityRank[matched[j].Severity]; a != b {
+				return a > b
+			}
+			return matched[i].EPSS > matched[j].EPSS
+		})
+
+		alert := Alert{RuleID: rule.ID, Rule: rule.Name, Repository: repoName, Reference: reference, Digest: digest,
+			NewOnly: rule.NewOnly, Findings: matched, Timestamp: time.Now().UTC()}
+		if err := s.deliver(ctx, rule, alert); err != nil {
+			fmt.Printf("[Alerts] Rule %s (%s) on %s failed to deliver: %v\n", rule.ID, rule.Channel, repoName, err)
+			continue
+		}
+		_, _ = s.DB.ExecContext(ctx, `UPDATE alert_rules SET last_triggered_at = NOW() WHERE id = $1`, rule.ID)
+		fmt.Printf("[Alerts] Rule %s sent %d finding(s) for %s:%s via %s\n", rule.ID, len(matched), repoName, reference, rule.Channel)
+	}
+	return nil
+}
+
+func (s *Service) enabledRules(ctx context.Context, repoID uuid.UUID) ([]Rule, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+ruleColumns+`
+		FROM alert_rules a
+		JOIN repositories r ON a.repository_id = r.id
+		JOIN namespaces n ON r.namespace_id = n.id
+		WHERE a.repository_id = $1 AND a.enabled`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []Rule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, rows.Err()
+}
+
+// addEPSS fills in the EPSS score of each finding's CVE. CVEs the daily
+// refresh hasn't scored yet count as 0.
+func (s *Service) addEPSS(ctx context.Context, findings []Finding) error {
+	cves := make([]string, 0, len(findings))
+	for _, f := range findings {
+		cves = append(cves, f.CVE)
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT cve_id, COALESCE(epss_score, 0) FROM vulnerability_intelligence WHERE cve_id = ANY($1)`, pq.Array(cves))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	scores := map[string]float64{}
+	for rows.Next() {
+		var cve string
+		var score float64
+		if err := rows.Scan(&cve, &score); err != nil {
+			return err
+		}
+		scores[cve] = score
+	}
+	for i := range findings {
+		findings[i].EPSS = scores[findings[i].CVE]
+	}
+	return rows.Err()
+}
+
+func (s *Service) deliver(ctx context.Context, rule Rule, alert Alert) error {
+	switch rule.Channel {
+	case ChannelWebhook:
+		return s.post(ctx, rule.Target, alert)
+	case ChannelSlack:
+		return s.post(ctx, rule.Target, map[string]string{"text": slackText(alert)})
+	case ChannelEmail:
+		if s.Email == nil || !s.Email.IsEnabled() {
+			return errors.New("SMTP is not configured")
+		}
+		var body bytes.Buffer
+		if err := emailTemplate.Execute(&body, alert); err != nil {
+			return err
+		}
+		return s.Email.Send(rule.Target, subject(alert), body.String())
+	}
+	return fmt.Errorf("unknown channel %q", rule.Channel)
+}
+
+func (s *Service) post(ctx context.Context, url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("endpoint returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func subject(a Alert) string {
+	kind := "vulnerabilities"
+	if a.NewOnly {
+		kind = "new vulnerabilities"
+	}
+	name := a.Rule
+	if name == "" {
+		name = "Alert"
+	}
+	return fmt.Sprintf("[RegistryX] %s: %d %s in %s:%s", name, len(a.Findings), kind, a.Repository, a.Reference)
+}
+
+func slackText(a Alert) string {
+	var b strings.Builder
+	b.WriteString("*" + subject(a) + "*\n")
+	for i, f := range a.Findings {
+		if i == maxListedFindings {
+			fmt.Fprintf(&b, "…and %d more\n", len(a.Findings)-maxListedFindings)
+			break
+		}
+		fmt.Fprintf(&b, "• *%s* %s in `%s` %s", f.Severity, f.CVE, f.Package, f.InstalledVersion)
+		if f.FixedVersion != "" {
+			fmt.Fprintf(&b, " (fixed in %s)", f.FixedVersion)
+		}
+		if f.EPSS > 0 {
+			fmt.Fprintf(&b, " EPSS %.2f", f.EPSS)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+var emailTemplate = template.Must(template.New("alert").Funcs(template.FuncMap{
+	"limit": func(fs []Finding) []Finding {
+		if len(fs) > maxListedFindings {
+			return fs[:maxListedFindings]
+		}
+		return fs
+	},
+	"more": func(fs []Finding) int { return len(fs) - maxListedFindings },
+}).Parse(`<html>
+<body>
+    <h2>{{if .Rule}}{{.Rule}}{{else}}Vulnerability alert{{end}}</h2>
+    <p>The latest scan of <b>{{.Repository}}:{{.Reference}}</b> ({{.Digest}}) found {{len .Findings}} {{if .NewOnly}}new {{end}}matching vulnerabilities.</p>
+    <table border="1" cellpadding="4" cellspacing="0">
+        <tr><th>Severity</th><th>CVE</th><th>Package</th><th>Installed</th><th>Fixed in</th><th>EPSS</th></tr>
+        {{range limit .Findings}}<tr><td>{{.Severity}}</td><td>{{.CVE}}</td><td>{{.Package}}</td><td>{{.InstalledVersion}}</td><td>{{.FixedVersion}}</td><td>{{printf "%.2f" .EPSS}}</td></tr>
+        {{end}}
+    </table>
+    {{if gt (more .Findings) 0}}<p>…and {{more .Findings}} more.</p>{{end}}
+</body>
+</html>
+`))
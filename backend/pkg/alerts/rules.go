@@ -0,0 +1,195 @@
+// Package alerts evaluates per-repository alert rules after each scan and
+// delivers the matching findings to a webhook, a Slack channel or an email
+// address.
+package alerts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/email"
+)
+
+// Channels an alert can be delivered to.
+const (
+	ChannelWebhook = "webhook" // JSON POST of the Alert
+	ChannelSlack   = "slack"   // Slack incoming webhook
+	ChannelEmail   = "email"
+)
+
+// ErrNotFound is returned for rules that don't exist in the repository.
+var ErrNotFound = errors.New("alert rule not found")
+
+// severityRank orders Trivy severities; a rule matches its minimum and above.
+var severityRank = map[string]int{"UNKNOWN": 0, "LOW": 1, "MEDIUM": 2, "HIGH": 3, "CRITICAL": 4}
+
+// Rule is an alert rule of one repository.
+type Rule struct {
+	ID              uuid.UUID  `json:"id"`
+	Repository      string     `json:"repository"`
+	Name            string     `json:"name"`
+	MinSeverity     string     `json:"minSeverity"` // CRITICAL, HIGH, MEDIUM, LOW or UNKNOWN
+	MinEPSS         float64    `json:"minEpss"`     // 0 = any
+	NewOnly         bool       `json:"newOnly"`     // only findings the previous scan didn't have
+	Channel         string     `json:"channel"`
+	Target          string     `json:"target"`
+	Enabled         bool       `json:"enabled"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	LastTriggeredAt *time.Time `json:"lastTriggeredAt,omitempty"`
+}
+
+// Validate normalizes the rule and checks its fields.
+func (r *Rule) Validate() error {
+	r.MinSeverity = strings.ToUpper(strings.TrimSpace(r.MinSeverity))
+	if r.MinSeverity == "" {
+		r.MinSeverity = "CRITICAL"
+	}
+	if _, ok := severityRank[r.MinSeverity]; !ok {
+		return fmt.Errorf("invalid minSeverity %q", r.MinSeverity)
+	}
+	if r.MinEPSS < 0 || r.MinEPSS > 1 {
+		return errors.New("minEpss must be between 0 and 1")
+	}
+	r.Target = strings.TrimSpace(r.Target)
+	switch r.Channel {
+	case ChannelWebhook, ChannelSlack:
+		u, err := url.Parse(r.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("target must be an http(s) URL")
+		}
+	case ChannelEmail:
+		if _, err := mail.ParseAddress(r.Target); err != nil {
+			return errors.New("target must be an email address")
+		}
+	default:
+		return errors.New("channel must be webhook, slack or email")
+	}
+	return nil
+}
+
+// Service stores alert rules and delivers alerts.
+type Service struct {
+	DB     *sql.DB
+	Email  *email.Service
+	Client *http.Client
+}
+
+func NewService(db *sql.DB, mail *email.Service) *Service {
+	return &Service{DB: db, Email: mail, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+const ruleColumns = `a.id, n.name || '/' || r.name, a.name, a.min_severity, a.min_epss, a.new_only,
+	a.channel, a.target, a.enabled, a.created_at, a.last_triggered_at`
+
+func scanRule(row interface{ Scan(...interface{}) error }) (*Rule, error) {
+	var rule Rule
+	err := row.Scan(&rule.ID, &rule.Repository, &rule.Name, &rule.MinSeverity, &rule.MinEPSS, &rule.NewOnly,
+		&rule.Channel, &rule.Target, &rule.Enabled, &rule.CreatedAt, &rule.LastTriggeredAt)
+	return &rule, err
+}
+
+// ListRules returns a repository's alert rules.
+func (s *Service) ListRules(ctx context.Context, repoName string) ([]Rule, error) {
+	nsName, rName := authz.SplitRepository(repoName)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+ruleColumns+`
+		FROM alert_rules a
+		JOIN repositories r ON a.repository_id = r.id
+		JOIN namespaces n ON r.namespace_id = n.id
+		WHERE n.name = $1 AND r.name = $2
+		ORDER BY a.created_at`, nsName, rName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, rows.Err()
+}
+
+// CreateRule adds a validated rule to a repository.
+func (s *Service) CreateRule(ctx context.Context, repoName string, rule Rule, createdBy uuid.UUID) (*Rule, error) {
+	nsName, rName := authz.SplitRepository(repoName)
+	var id uuid.UUID
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO alert_rules (repository_id, name, min_severity, min_epss, new_only, channel, target, enabled, created_by)
+		SELECT r.id, $3, $4, $5, $6, $7, $8, $9, $10
+		FROM repositories r JOIN namespaces n ON r.namespace_id = n.id
+		WHERE n.name = $1 AND r.name = $2
+		RETURNING id`,
+		nsName, rName, rule.Name, rule.MinSeverity, rule.MinEPSS, rule.NewOnly, rule.Channel, rule.Target, rule.Enabled,
+		uuid.NullUUID{UUID: createdBy, Valid: createdBy != uuid.Nil}).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("repository not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRule(ctx, repoName, id)
+}
+
+// GetRule returns one rule of a repository.
+func (s *Service) GetRule(ctx context.Context, repoName string, id uuid.UUID) (*Rule, error) {
+	nsName, rName := authz.SplitRepository(repoName)
+	rule, err := scanRule(s.DB.QueryRowContext(ctx, `
+		SELECT `+ruleColumns+`
+		FROM alert_rules a
+		JOIN repositories r ON a.repository_id = r.id
+		JOIN namespaces n ON r.namespace_id = n.id
+		WHERE n.name = $1 AND r.name = $2 AND a.id = $3`, nsName, rName, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return rule, err
+}
+
+// UpdateRule replaces the settings of a rule with a validated rule.
+func (s *Service) UpdateRule(ctx context.Context, repoName string, id uuid.UUID, rule Rule) (*Rule, error) {
+	nsName, rName := authz.SplitRepository(repoName)
+	res, err := s.DB.ExecContext(ctx, `
+		UPDATE alert_rules a
+		SET name = $4, min_severity = $5, min_epss = $6, new_only = $7, channel = $8, target = $9, enabled = $10
+		FROM repositories r JOIN namespaces n ON r.namespace_id = n.id
+		WHERE a.repository_id = r.id AND n.name = $1 AND r.name = $2 AND a.id = $3`,
+		nsName, rName, id, rule.Name, rule.MinSeverity, rule.MinEPSS, rule.NewOnly, rule.Channel, rule.Target, rule.Enabled)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+	return s.GetRule(ctx, repoName, id)
+}
+
+// DeleteRule removes a rule from a repository.
+func (s *Service) DeleteRule(ctx context.Context, repoName string, id uuid.UUID) error {
+	nsName, rName := authz.SplitRepository(repoName)
+	res, err := s.DB.ExecContext(ctx, `
+		DELETE FROM alert_rules a
+		USING repositories r, namespaces n
+		WHERE a.repository_id = r.id AND r.namespace_id = n.id AND n.name = $1 AND r.name = $2 AND a.id = $3`,
+		nsName, rName, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Package analytics reports registry activity over a window of days: the
+// most pulled images, the repositories growing fastest, and who pushes most.
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/authz"
+)
+
+const (
+	DefaultWindowDays = 30
+	MaxWindowDays     = 365 // pull counts are kept this long, as cost snapshots are
+	DefaultLimit      = 10
+	MaxLimit          = 100
+)
+
+// RepositoryPulls is a repository and its pulls in the window.
+type RepositoryPulls struct {
+	Repository string `json:"repository"`
+	Pulls      int64  `json:"pulls"`
+}
+
+// TagPulls is a tag and its pulls in the window.
+type TagPulls struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Pulls      int64  `json:"pulls"`
+}
+
+// RepositoryGrowth is a repository's storage growth over the window, from
+// its daily cost snapshots. Repositories created since count from zero.
+type RepositoryGrowth struct {
+	Repository  string `json:"repository"`
+	SizeBytes   int64  `json:"sizeBytes"`
+	GrowthBytes int64  `json:"growthBytes"`
+}
+
+// Pusher is a user and the pushes they made in the window.
+type Pusher struct {
+	Username     string `json:"username"`
+	Pushes       int64  `json:"pushes"`
+	Repositories int64  `json:"repositories"`
+}
+
+// TopImages is the activity of the repositories a user may read, or of all
+// repositories for admins.
+type TopImages struct {
+	WindowDays     int                `json:"windowDays"`
+	Since          time.Time          `json:"since"`
+	MostPulled     []RepositoryPulls  `json:"mostPulledRepositories"`
+	MostPulledTags []TagPulls         `json:"mostPulledTags"`
+	FastestGrowing []RepositoryGrowth `json:"fastestGrowingRepositories"`
+	TopPushers     []Pusher           `json:"mostActivePushers"`
+}
+
+type Service struct {
+	DB *sql.DB
+}
+
+func NewService(db *sql.DB) *Service {
+	return &Service{DB: db}
+}
+
+// TopImages returns the top limit entries of each list over the last days
+// days, today included. Non-admins only see repositories they can read.
+func (s *Service) TopImages(ctx context.Context, userID uuid.UUID, role string, days, limit int) (*TopImages, error) {
+	filter := "TRUE"
+	args := []interface{}{days, limit}
+	if role != "admin" {
+		filter = authz.RepositoryFilter("$3", authz.RoleRead)
+		args = append(args, userID)
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	top := &TopImages{
+		WindowDays:     days,
+		Since:          today.AddDate(0, 0, 1-days),
+		MostPulled:     []RepositoryPulls{},
+		MostPulledTags: []TagPulls{},
+		FastestGrowing: []RepositoryGrowth{},
+		TopPushers:     []Pusher{},
+	}
+
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT n.name || '/' || r.name, SUM(p.pulls)
+		FROM repository_pulls p
+		JOIN repositories r ON r.id = p.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		WHERE p.day > CURRENT_DATE - $1::int AND %s
+		GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT $2`, filter), args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var e RepositoryPulls
+		if err := rows.Scan(&e.Repository, &e.Pulls); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		top.MostPulled = append(top.MostPulled, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT n.name || '/' || r.name, p.tag, SUM(p.pulls)
+		FROM repository_pulls p
+		JOIN repositories r ON r.id = p.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		WHERE p.day > CURRENT_DATE - $1::int AND p.tag <> '' AND %s
+		GROUP BY 1, 2 ORDER BY 3 DESC, 1, 2 LIMIT $2`, filter), args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var e TagPulls
+		if err := rows.Scan(&e.Repository, &e.Tag, &e.Pulls); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		top.MostPulledTags = append(top.MostPulledTags, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT n.name || '/' || r.name, cur.size_bytes, cur.size_bytes - COALESCE(prev.size_bytes, 0)
+		FROM repositories r
+		JOIN namespaces n ON n.id = r.namespace_id
+		JOIN LATERAL (
+			SELECT day, size_bytes FROM repository_cost_history
+			WHERE repository_id = r.id ORDER BY day DESC LIMIT 1
+		) cur ON true
+		LEFT JOIN LATERAL (
+			SELECT size_bytes FROM repository_cost_history
+			WHERE repository_id = r.id AND day <= cur.day - $1::int ORDER BY day DESC LIMIT 1
+		) prev ON true
+		WHERE cur.size_bytes > COALESCE(prev.size_bytes, 0) AND %s
+		ORDER BY 3 DESC, 1 LIMIT $2`, filter), args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var e RepositoryGrowth
+		if err := rows.Scan(&e.Repository, &e.SizeBytes, &e.GrowthBytes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		top.FastestGrowing = append(top.FastestGrowing, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Pushes are read from the audit log, which names the repository as
+	// pushed: library images may be pushed without their namespace.
+	rows, err = s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT u.username, COUNT(*), COUNT(DISTINCT r.id)
+		FROM audit_logs a
+		JOIN users u ON u.id = a.user_id
+		JOIN (repositories r JOIN namespaces n ON n.id = r.namespace_id)
+		  ON a.details->>'repository' IN (n.name || '/' || r.name, CASE WHEN n.name = 'library' THEN r.name END)
+		WHERE a.action = 'PUSH' AND a.created_at >= CURRENT_DATE - ($1::int - 1) AND %s
+		GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT $2`, filter), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e Pusher
+		if err := rows.Scan(&e.Username, &e.Pushes, &e.Repositories); err != nil {
+			return nil, err
+		}
+		top.TopPushers = append(top.TopPushers, e)
+	}
+	return top, rows.Err()
+}
+
+// Prune drops pull counts older than MaxWindowDays.
+func (s *Service) Prune(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM repository_pulls WHERE day <= CURRENT_DATE - $1::int`, MaxWindowDays)
+	return err
+}
+
+// StartPruning prunes old pull counts every interval until ctx is done.
+func (s *Service) StartPruning(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Prune(ctx); err != nil {
+			fmt.Printf("[Analytics] Pruning pull counts failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
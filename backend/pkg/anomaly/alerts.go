@@ -0,0 +1,158 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned for alerts that don't exist.
+var ErrNotFound = errors.New("security alert not found")
+
+// Bounds of an alert listing.
+const (
+	DefaultAlertLimit = 100
+	MaxAlertLimit     = 1000
+)
+
+// Alert is a security alert; webhooks receive it as JSON.
+type Alert struct {
+	ID             uuid.UUID              `json:"id"`
+	Kind           string                 `json:"kind"`
+	Severity       string                 `json:"severity"`
+	Subject        string                 `json:"subject"`
+	Summary        string                 `json:"summary"`
+	Details        map[string]interface{} `json:"details,omitempty"`
+	CreatedAt      time.Time              `json:"createdAt"`
+	AcknowledgedAt *time.Time             `json:"acknowledgedAt,omitempty"`
+}
+
+// Raise stores and delivers an alert found outside the detector's own
+// checks, deduplicated like the others.
+func (d *Detector) Raise(ctx context.Context, a Alert) {
+	d.raise(ctx, a)
+}
+
+// raise stores the alert and delivers it, unless an alert of the same kind
+// about the same subject was raised within dedupWindow. Errors are logged.
+func (d *Detector) raise(ctx context.Context, a Alert) {
+	detailsJSON, _ := json.Marshal(a.Details)
+	err := d.DB.QueryRowContext(ctx, `
+		INSERT INTO security_alerts (kind, severity, subject, summary, details)
+		SELECT $1, $2, $3, $4, $5
+		WHERE NOT EXISTS (
+			SELECT 1 FROM security_alerts
+			WHERE kind = $1 AND subject = $3 AND created_at > NOW() - $6 * INTERVAL '1 second')
+		RETURNING id, created_at`,
+		a.Kind, a.Severity, a.Subject, a.Summary, detailsJSON, dedupWindow.Seconds()).Scan(&a.ID, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return // already raised
+	}
+	if err != nil {
+		fmt.Printf("[Anomaly] Failed to store %s alert: %v\n", a.Kind, err)
+		return
+	}
+	fmt.Printf("[Anomaly] %s alert: %s\n", a.Kind, a.Summary)
+
+	if d.WebhookURL != "" {
+		if err := d.post(ctx, a); err != nil {
+			fmt.Printf("[Anomaly] Failed to deliver alert %s to webhook: %v\n", a.ID, err)
+		}
+	}
+	if len(d.Recipients) > 0 && d.Email != nil && d.Email.IsEnabled() {
+		subject := fmt.Sprintf("[RegistryX] Security alert (%s): %s", a.Severity, a.Summary)
+		body := fmt.Sprintf(`<html>
+<body>
+    <h2>Security alert</h2>
+    <p>%s</p>
+    <p>Severity: <b>%s</b>. Review and acknowledge it under Security alerts in the dashboard.</p>
+</body>
+</html>
+`, html.EscapeString(a.Summary), a.Severity)
+		for _, to := range d.Recipients {
+			if err := d.Email.Send(to, subject, body); err != nil {
+				fmt.Printf("[Anomaly] Failed to email alert %s to %s: %v\n", a.ID, to, err)
+			}
+		}
+	}
+}
+
+func (d *Detector) post(ctx context.Context, a Alert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.WebhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("endpoint returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ListAlerts returns the newest alerts first, optionally only those not yet
+// acknowledged.
+func (d *Detector) ListAlerts(ctx context.Context, unacknowledgedOnly bool, limit int) ([]Alert, error) {
+	if limit <= 0 {
+		limit = DefaultAlertLimit
+	}
+	if limit > MaxAlertLimit {
+		limit = MaxAlertLimit
+	}
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT id, kind, severity, subject, summary, COALESCE(details, '{}'), created_at, acknowledged_at
+		FROM security_alerts
+		WHERE NOT $1 OR acknowledged_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT $2`, unacknowledgedOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Alert{}
+	for rows.Next() {
+		var a Alert
+		var details []byte
+		var ackAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Kind, &a.Severity, &a.Subject, &a.Summary, &details, &a.CreatedAt, &ackAt); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(details, &a.Details)
+		if ackAt.Valid {
+			a.AcknowledgedAt = &ackAt.Time
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// Acknowledge marks an alert as handled by the given user.
+func (d *Detector) Acknowledge(ctx context.Context, id, by uuid.UUID) error {
+	res, err := d.DB.ExecContext(ctx, `
+		UPDATE security_alerts SET acknowledged_at = COALESCE(acknowledged_at, NOW()), acknowledged_by = COALESCE(acknowledged_by, $2)
+		WHERE id = $1`, id, uuid.NullUUID{UUID: by, Valid: by != uuid.Nil})
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+// Package anomaly watches audit events and sign-ins for signs of a
+// compromised account — mass deletions, bursts of failed logins, sign-ins from
+// new addresses or countries, and service accounts used outside business
+// hours — and raises security alerts.
+package anomaly
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/audit"
+	"github.com/registryx/registryx/backend/pkg/clientip"
+	"github.com/registryx/registryx/backend/pkg/email"
+)
+
+// Kinds of security alerts.
+const (
+	KindMassDeletion  = "mass_deletion"
+	KindFailedLogins  = "failed_logins"
+	KindNewLocation   = "new_location"
+	KindOffHoursRobot = "off_hours_service_account"
+	KindBlobCorrupt   = "blob_corrupt" // raised by integrity checks, through Raise
+)
+
+// serviceAccountPrefix marks service account principals.
+const serviceAccountPrefix = "serviceaccount:"
+
+// DeletePrefix starts the audit actions of deletions.
+const DeletePrefix = "DELETE_"
+
+// BusinessHours is when service accounts are expected to run: from Start to
+// End o'clock (local to Location) on Days.
+type BusinessHours struct {
+	Start, End int
+	Days       map[time.Weekday]bool
+	Location   *time.Location
+}
+
+// ParseBusinessHours parses "8-19" or "8-19 mon-fri" (ANOMALY_BUSINESS_HOURS)
+// in the given IANA time zone. An empty spec disables the check.
+func ParseBusinessHours(spec, zone string) (*BusinessHours, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", zone, err)
+	}
+	fields := strings.Fields(strings.ToLower(spec))
+	bh := &BusinessHours{Location: loc, Days: map[time.Weekday]bool{}}
+
+	start, end, ok := strings.Cut(fields[0], "-")
+	bh.Start, err = strconv.Atoi(start)
+	if err == nil {
+		bh.End, err = strconv.Atoi(end)
+	}
+	if !ok || err != nil || bh.Start < 0 || bh.End > 24 || bh.Start >= bh.End {
+		return nil, fmt.Errorf("invalid hours %q, want e.g. 8-19", fields[0])
+	}
+
+	days := "mon-fri"
+	if len(fields) > 1 {
+		days = fields[1]
+	}
+	names := []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+	index := func(name string) int {
+		for i, n := range names {
+			if n == name {
+				return i
+			}
+		}
+		return -1
+	}
+	first, last, _ := strings.Cut(days, "-")
+	if last == "" {
+		last = first
+	}
+	from, to := index(first), index(last)
+	if from < 0 || to < 0 {
+		return nil, fmt.Errorf("invalid days %q, want e.g. mon-fri", days)
+	}
+	for d := from; ; d = (d + 1) % 7 {
+		bh.Days[time.Weekday(d)] = true
+		if d == to {
+			break
+		}
+	}
+	return bh, nil
+}
+
+// Contains reports whether t falls within business hours.
+func (bh *BusinessHours) Contains(t time.Time) bool {
+	t = t.In(bh.Location)
+	return bh.Days[t.Weekday()] && t.Hour() >= bh.Start && t.Hour() < bh.End
+}
+
+// Detector raises security alerts. The zero thresholds disable their rules.
+type Detector struct {
+	DB     *sql.DB
+	Audit  *audit.Service
+	Email  *email.Service
+	Client *http.Client
+
+	WebhookURL string   // alerts are POSTed here as JSON
+	Recipients []string // and emailed to these addresses
+
+	Window           time.Duration // period the counts below are over
+	MassDeleteCount  int           // deletions by one user within Window
+	FailedLoginCount int           // failed sign-ins for one username, or from one IP, within Window
+	LockoutCount     int           // failed sign-ins from one IP within Window before its sign-ins are refused
+	BusinessHours    *BusinessHours
+	CountryHeader    string // request header a proxy sets to the client's country, e.g. CF-IPCountry
+
+	mu       sync.Mutex
+	lastSeen map[string]time.Time // principal|ip recently recorded, to spare the database
+}
+
+// dedupWindow is how long an alert about the same kind and subject isn't
+// raised again.
+const dedupWindow = time.Hour
+
+// eventRetention is how long security events are kept.
+const eventRetention = 90 * 24 * time.Hour
+
+// seenTTL is how long a recorded sign-in address isn't written again.
+const seenTTL = 10 * time.Minute
+
+func NewDetector(db *sql.DB, aud *audit.Service, mail *email.Service) *Detector {
+	return &Detector{
+		DB:       db,
+		Audit:    aud,
+		Email:    mail,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Window:   10 * time.Minute,
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// Run checks the audit log every interval until ctx is cancelled.
+func (d *Detector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.Check(ctx)
+		}
+	}
+}
+
+// Check runs the audit log and sign-in rules once, and prunes old security
+// events.
+func (d *Detector) Check(ctx context.Context) {
+	if err := d.checkMassDeletions(ctx); err != nil {
+		fmt.Printf("[Anomaly] Mass deletion check failed: %v\n", err)
+	}
+	if err := d.checkFailedLogins(ctx); err != nil {
+		fmt.Printf("[Anomaly] Failed login check failed: %v\n", err)
+	}
+	if _, err := d.DB.ExecContext(ctx, `DELETE FROM security_events WHERE created_at < NOW() - $1 * INTERVAL '1 second'`, eventRetention.Seconds()); err != nil {
+		fmt.Printf("[Anomaly] Failed to prune security events: %v\n", err)
+	}
+}
+
+func (d *Detector) checkMassDeletions(ctx context.Context) error {
+	if d.MassDeleteCount <= 0 {
+		return nil
+	}
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT a.user_id::text, COALESCE(u.username, ''), COUNT(*),
+		       COALESCE(array_to_string((array_agg(DISTINCT a.details->>'repository'))[1:10], ', '), '')
+		FROM audit_logs a
+		LEFT JOIN users u ON u.id = a.user_id
+		WHERE a.action LIKE $1 AND a.created_at > NOW() - $2 * INTERVAL '1 second' AND a.user_id IS NOT NULL
+		GROUP BY a.user_id, u.username
+		HAVING COUNT(*) >= $3`, DeletePrefix+"%", d.Window.Seconds(), d.MassDeleteCount)
+	if err != nil {
+		return err
+	}
+	type hit struct {
+		userID, username, repos string
+		count                   int
+	}
+	var hits []hit
+	for rows.Next() {
+		var h hit
+		if err := rows.Scan(&h.userID, &h.username, &h.count, &h.repos); err != nil {
+			rows.Close()
+			return err
+		}
+		hits = append(hits, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, h := range hits {
+		d.raise(ctx, Alert{
+			Kind:     KindMassDeletion,
+			Severity: "high",
+			Subject:  h.userID,
+			Summary:  fmt.Sprintf("%s deleted %d images, tags or repositories within %s", displayName(h.username, h.userID), h.count, d.Window),
+			Details:  map[string]interface{}{"username": h.username, "count": h.count, "repositories": h.repos},
+		})
+	}
+	return nil
+}
+
+func (d *Detector) checkFailedLogins(ctx context.Context) error {
+	if d.FailedLoginCount <= 0 {
+		return nil
+	}
+	for _, key := range []string{"username", "ip"} {
+		rows, err := d.DB.QueryContext(ctx, `
+			SELECT `+key+`, COUNT(*)
+			FROM security_events
+			WHERE kind = $1 AND created_at > NOW() - $2 * INTERVAL '1 second' AND `+key+` <> ''
+			GROUP BY 1
+			HAVING COUNT(*) >= $3`, EventLoginFailed, d.Window.Seconds(), d.FailedLoginCount)
+		if err != nil {
+			return err
+		}
+		counts := map[string]int{}
+		for rows.Next() {
+			var value string
+			var count int
+			if err := rows.Scan(&value, &count); err != nil {
+				rows.Close()
+				return err
+			}
+			counts[value] = count
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for value, count := range counts {
+			summary := fmt.Sprintf("%d failed sign-ins for %s within %s", count, value, d.Window)
+			if key == "ip" {
+				summary = fmt.Sprintf("%d failed sign-ins from %s within %s", count, value, d.Window)
+			}
+			d.raise(ctx, Alert{
+				Kind:     KindFailedLogins,
+				Severity: "medium",
+				Subject:  key + ":" + value,
+				Summary:  summary,
+				Details:  map[string]interface{}{key: value, "count": count},
+			})
+		}
+	}
+	return nil
+}
+
+// LoginSucceeded records a successful sign-in of a user (principal is the
+// user ID) or service account ("serviceaccount:<name>"), raising an alert
+// when it comes from an address or country the principal hasn't used before,
+// or is a service account outside business hours. The checks run in the
+// background.
+func (d *Detector) LoginSucceeded(r *http.Request, principal, name string) {
+	ip := clientip.FromRequest(r)
+	country := ""
+	if d.CountryHeader != "" {
+		country = strings.ToUpper(strings.TrimSpace(r.Header.Get(d.CountryHeader)))
+	}
+	now := time.Now()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if strings.HasPrefix(principal, serviceAccountPrefix) && d.BusinessHours != nil && !d.BusinessHours.Contains(now) {
+			d.raise(ctx, Alert{
+				Kind:     KindOffHoursRobot,
+				Severity: "medium",
+				Subject:  principal,
+				Summary: fmt.Sprintf("Service account %s was used at %s, outside business hours",
+					strings.TrimPrefix(principal, serviceAccountPrefix), now.In(d.BusinessHours.Location).Format("Mon 15:04 MST")),
+				Details: map[string]interface{}{"ip": ip, "country": country},
+			})
+		}
+		if err := d.recordLocation(ctx, principal, name, ip, country); err != nil {
+			fmt.Printf("[Anomaly] Failed to record sign-in location of %s: %v\n", principal, err)
+		}
+	}()
+}
+
+// recordLocation remembers the principal's address; a new address (or
+// country) of a principal that has signed in before raises an alert.
+func (d *Detector) recordLocation(ctx context.Context, principal, name, ip, country string) error {
+	key := principal + "|" + ip
+	d.mu.Lock()
+	if t, ok := d.lastSeen[key]; ok && time.Since(t) < seenTTL {
+		d.mu.Unlock()
+		return nil
+	}
+	d.lastSeen[key] = time.Now()
+	for k, t := range d.lastSeen {
+		if time.Since(t) >= seenTTL {
+			delete(d.lastSeen, k)
+		}
+	}
+	d.mu.Unlock()
+
+	var known, knownCountry bool
+	err := d.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM principal_locations WHERE principal = $1),
+		       EXISTS (SELECT 1 FROM principal_locations WHERE principal = $1 AND country = $2)`,
+		principal, country).Scan(&known, &knownCountry)
+	if err != nil {
+		return err
+	}
+	var newAddress bool
+	err = d.DB.QueryRowContext(ctx, `
+		INSERT INTO principal_locations (principal, ip, country) VALUES ($1, $2, $3)
+		ON CONFLICT (principal, ip) DO UPDATE SET last_seen = NOW(), country = EXCLUDED.country
+		RETURNING xmax = 0`, principal, ip, country).Scan(&newAddress)
+	if err != nil {
+		return err
+	}
+	if !known || !newAddress {
+		return nil // first sign-in ever, or a known address
+	}
+
+	who := displayName(name, principal)
+	alert := Alert{
+		Kind:     KindNewLocation,
+		Severity: "low",
+		Subject:  principal,
+		Summary:  fmt.Sprintf("%s signed in from a new address, %s", who, ip),
+		Details:  map[string]interface{}{"name": name, "ip": ip, "country": country},
+	}
+	if country != "" && !knownCountry {
+		alert.Severity = "medium"
+		alert.Summary = fmt.Sprintf("%s signed in from a new country, %s (%s)", who, country, ip)
+	}
+	d.raise(ctx, alert)
+	return nil
+}
+
+func displayName(name, fallback string) string {
+	if name != "" {
+		return name
+	}
+	return fallback
+}
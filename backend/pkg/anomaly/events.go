@@ -0,0 +1,156 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/clientip"
+)
+
+// Kinds of security events.
+const (
+	EventLoginFailed  = "login_failed"  // wrong password, API key or unknown user
+	EventInvalidToken = "invalid_token" // a bearer token that is forged, malformed or revoked
+)
+
+// Bounds of an event listing.
+const (
+	DefaultEventLimit = 100
+	MaxEventLimit     = 1000
+)
+
+// SecurityEvent is a failed authentication attempt.
+type SecurityEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Kind      string    `json:"kind"`
+	Username  string    `json:"username,omitempty"`
+	Method    string    `json:"method"`
+	Reason    string    `json:"reason,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// EventFilter narrows an event listing; zero fields match everything.
+type EventFilter struct {
+	Kind     string
+	Username string
+	IP       string
+	Since    time.Time
+	Limit    int
+}
+
+// LoginFailed records a failed sign-in for username, where the failed login
+// rule and the lockout count it.
+func (d *Detector) LoginFailed(r *http.Request, username, method, reason string) {
+	d.recordEvent(r, EventLoginFailed, username, method, reason)
+}
+
+// InvalidToken records a request that presented a bearer token that doesn't
+// validate. Open dashboards keep sending their old token after JWT_SECRET
+// changes, so these don't count towards the lockout.
+func (d *Detector) InvalidToken(r *http.Request, reason string) {
+	d.recordEvent(r, EventInvalidToken, "", "bearer", reason)
+}
+
+func (d *Detector) recordEvent(r *http.Request, kind, username, method, reason string) {
+	ip := clientip.FromRequest(r)
+	userAgent := r.UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	_, err := d.DB.ExecContext(r.Context(), `
+		INSERT INTO security_events (kind, username, method, reason, ip, user_agent, path)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		kind, username, method, reason, ip, userAgent, r.URL.Path)
+	if err != nil {
+		fmt.Printf("[Anomaly] Failed to record %s event from %s: %v\n", kind, ip, err)
+	}
+}
+
+// LoginBlocked returns how long sign-ins from the request's address are
+// refused: once LockoutCount failed sign-ins come from it within Window,
+// until the oldest of them leaves the window. Zero allows it.
+func (d *Detector) LoginBlocked(r *http.Request) time.Duration {
+	if d.LockoutCount <= 0 {
+		return 0
+	}
+	ip := clientip.FromRequest(r)
+
+	var count int
+	var oldest *time.Time
+	err := d.DB.QueryRowContext(r.Context(), `
+		SELECT COUNT(*), MIN(created_at) FROM (
+			SELECT created_at FROM security_events
+			WHERE ip = $1 AND kind = $2 AND created_at > NOW() - $3 * INTERVAL '1 second'
+			ORDER BY created_at DESC LIMIT $4
+		) recent`, ip, EventLoginFailed, d.Window.Seconds(), d.LockoutCount).Scan(&count, &oldest)
+	if err != nil {
+		// Don't lock everyone out when the database is struggling.
+		fmt.Printf("[Anomaly] Lockout check for %s failed: %v\n", ip, err)
+		return 0
+	}
+	if count < d.LockoutCount || oldest == nil {
+		return 0
+	}
+	if wait := time.Until(oldest.Add(d.Window)); wait > time.Second {
+		return wait
+	}
+	return time.Second
+}
+
+// ListEvents returns security events, newest first.
+func (d *Detector) ListEvents(ctx context.Context, f EventFilter) ([]SecurityEvent, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultEventLimit
+	}
+	if f.Limit > MaxEventLimit {
+		f.Limit = MaxEventLimit
+	}
+
+	where := []string{"1=1"}
+	args := []interface{}{}
+	add := func(clause string, value interface{}) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+	if f.Kind != "" {
+		add("kind = $%d", f.Kind)
+	}
+	if f.Username != "" {
+		add("username = $%d", f.Username)
+	}
+	if f.IP != "" {
+		add("ip = $%d", f.IP)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	args = append(args, f.Limit)
+
+	rows, err := d.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, kind, username, method, reason, ip, user_agent, path, created_at
+		FROM security_events
+		WHERE %s
+		ORDER BY created_at DESC
+		LIMIT $%d`, strings.Join(where, " AND "), len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []SecurityEvent{}
+	for rows.Next() {
+		var e SecurityEvent
+		if err := rows.Scan(&e.ID, &e.Kind, &e.Username, &e.Method, &e.Reason, &e.IP, &e.UserAgent, &e.Path, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
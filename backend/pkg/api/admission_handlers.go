@@ -0,0 +1,187 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/events"
+)
+
+// Minimal admission.k8s.io/v1 AdmissionReview types; only the fields the
+// webhook reads or writes are declared.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string `json:"uid"`
+	Namespace string `json:"namespace"`
+	Operation string `json:"operation"`
+	Kind      struct {
+		Kind string `json:"kind"`
+	} `json:"kind"`
+	UserInfo struct {
+		Username string `json:"username"`
+	} `json:"userInfo"`
+	Object json.RawMessage `json:"object"`
+}
+
+type admissionResponse struct {
+	UID      string           `json:"uid"`
+	Allowed  bool             `json:"allowed"`
+	Status   *admissionStatus `json:"status,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+type admissionStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type admissionContainer struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+type admissionPodSpec struct {
+	Containers          []admissionContainer `json:"containers"`
+	InitContainers      []admissionContainer `json:"initContainers"`
+	EphemeralContainers []admissionContainer `json:"ephemeralContainers"`
+}
+
+// admissionObject covers Pods (spec), workload controllers (spec.template)
+// and CronJobs (spec.jobTemplate.spec.template).
+type admissionObject struct {
+	Spec struct {
+		admissionPodSpec
+		Template *struct {
+			Spec admissionPodSpec `json:"spec"`
+		} `json:"template"`
+		JobTemplate *struct {
+			Spec struct {
+				Template struct {
+					Spec admissionPodSpec `json:"spec"`
+				} `json:"template"`
+			} `json:"spec"`
+		} `json:"jobTemplate"`
+	} `json:"spec"`
+}
+
+// images returns the distinct container images of the object's pod spec.
+func (o *admissionObject) images() []string {
+	spec := o.Spec.admissionPodSpec
+	if o.Spec.Template != nil {
+		spec = o.Spec.Template.Spec
+	} else if o.Spec.JobTemplate != nil {
+		spec = o.Spec.JobTemplate.Spec.Template.Spec
+	}
+
+	seen := make(map[string]bool)
+	var images []string
+	for _, group := range [][]admissionContainer{spec.InitContainers, spec.Containers, spec.EphemeralContainers} {
+		for _, c := range group {
+			if c.Image != "" && !seen[c.Image] {
+				seen[c.Image] = true
+				images = append(images, c.Image)
+			}
+		}
+	}
+	return images
+}
+
+// admissionCaller reports whether r comes from the Kubernetes API server: it
+// carries the configured ADMISSION_TOKEN, or a verified client certificate
+// whose common name or a DNS name is listed in ADMISSION_CLIENT_NAMES. Other
+// certificates of the client CA, such as those of users and CI, don't do.
+func (h *DashboardHandler) admissionCaller(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && h.Config.AdmissionClientNames != "" {
+		cert := r.TLS.VerifiedChains[0][0]
+		names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+		for _, allowed := range strings.Split(h.Config.AdmissionClientNames, ",") {
+			if allowed = strings.TrimSpace(allowed); allowed == "" {
+				continue
+			}
+			for _, name := range names {
+				if strings.EqualFold(name, allowed) {
+					return true
+				}
+			}
+		}
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && h.Config.AdmissionToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(h.Config.AdmissionToken)) == 1
+}
+
+// ValidateAdmission implements a Kubernetes ValidatingAdmissionWebhook. Every
+// image of the submitted workload that lives in this registry is checked
+// against the registry policy; images from other registries are ignored.
+// The environment defaults to each repository's namespace setting and can be
+// pinned per cluster with ?environment= in the webhook URL. The API server
+// must present ADMISSION_TOKEN or a client certificate verified against
+// TLS_CLIENT_CA_FILE and named in ADMISSION_CLIENT_NAMES.
+func (h *DashboardHandler) ValidateAdmission(w http.ResponseWriter, r *http.Request) {
+	if !h.admissionCaller(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="registryx-admission"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var review admissionReview
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "Invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+	req := review.Request
+
+	resp := &admissionResponse{UID: req.UID, Allowed: true}
+	var obj admissionObject
+	if err := json.Unmarshal(req.Object, &obj); err != nil {
+		resp.Warnings = append(resp.Warnings, "registryx: could not read pod spec, skipping policy check")
+	} else {
+		env := r.URL.Query().Get("environment")
+		var denials []string
+		for _, image := range obj.images() {
+			v := h.evaluateImage(r, image, env, req.UserInfo.Username)
+			for _, warning := range v.Warnings {
+				resp.Warnings = append(resp.Warnings, "registryx: "+warning)
+			}
+			if v.Allowed {
+				continue
+			}
+			for _, violation := range v.Violations {
+				denials = append(denials, image+": "+violation)
+			}
+			if v.Found {
+				h.Events.Publish(events.Event{
+					Type: events.TypePolicyDenied, Repository: v.Repository, Reference: v.Reference, Digest: v.Digest, User: req.UserInfo.Username,
+					Data: map[string]interface{}{"violations": v.Violations, "source": "admission"},
+				})
+				if h.Audit != nil {
+					_ = h.Audit.Log(r.Context(), uuid.Nil, "POLICY_DENIED", nil, map[string]interface{}{"repository": v.Repository, "reference": v.Reference, "digest": v.Digest, "violations": v.Violations, "source": "admission", "user": req.UserInfo.Username})
+				}
+			}
+		}
+		if len(denials) > 0 {
+			resp.Allowed = false
+			resp.Status = &admissionStatus{
+				Code:    http.StatusForbidden,
+				Message: "RegistryX policy denied: " + strings.Join(denials, "; "),
+			}
+			log.Printf("Admission DENIED %s %s/%s: %v\n", req.Operation, req.Namespace, req.Kind.Kind, denials)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(admissionReview{
+		APIVersion: "admission.k8s.io/v1",
+		Kind:       "AdmissionReview",
+		Response:   resp,
+	})
+}
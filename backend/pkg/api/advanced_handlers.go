@@ -2,13 +2,17 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/audit"
+	"github.com/registryx/registryx/backend/pkg/authz"
 	"github.com/registryx/registryx/backend/pkg/costs"
 	"github.com/registryx/registryx/backend/pkg/intelligence"
 	"github.com/registryx/registryx/backend/pkg/middleware"
@@ -18,6 +22,11 @@ import (
 type AdvancedHandler struct {
 	Intelligence *intelligence.Service
 	Costs        *costs.Service
+	Audit        *audit.Service
+	Authz        *authz.Authorizer
+
+	// RuntimeAgentToken authenticates cluster agents reporting running images.
+	RuntimeAgentToken string
 }
 
 // NewAdvancedHandler creates a new advanced features handler
@@ -52,6 +61,43 @@ func (h *AdvancedHandler) GetPrioritizedVulnerabilities(w http.ResponseWriter, r
 	json.NewEncoder(w).Encode(priorities)
 }
 
+// BulkPrioritizedVulnerabilities returns the prioritized vulnerabilities of
+// several manifests, or of the tagged images of whole repositories, with one
+// entry per CVE for fleet-level triage.
+// Body: {"manifestIds": [...], "repositories": ["team/api"], "limit": 200}
+// POST /api/v1/vulnerabilities/prioritized/bulk
+func (h *AdvancedHandler) BulkPrioritizedVulnerabilities(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		intelligence.FleetScope
+		Limit int `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.ManifestIDs) == 0 && len(req.Repositories) == 0 {
+		http.Error(w, "manifestIds or repositories required", http.StatusBadRequest)
+		return
+	}
+	if len(req.ManifestIDs) > intelligence.MaxFleetManifests {
+		http.Error(w, fmt.Sprintf("at most %d manifestIds", intelligence.MaxFleetManifests), http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	role, _ := r.Context().Value(middleware.RoleKey).(string)
+
+	priorities, err := h.Intelligence.GetFleetPriorities(r.Context(), req.FleetScope, uid, role, req.Limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(priorities)
+}
+
 // GetVulnIntelligence returns intelligence data for a CVE
 func (h *AdvancedHandler) GetVulnIntelligence(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -94,6 +140,110 @@ func (h *AdvancedHandler) RefreshEPSS(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// maxEPSSUpload bounds uploaded EPSS bulk files (about 10MB gzipped, 60MB plain today).
+const maxEPSSUpload = 256 << 20
+
+// ImportEPSS loads an EPSS bulk file (epss_scores-current.csv.gz) for installs
+// that can't reach api.first.org. The file is either the request body, or
+// named by a JSON body: {"path": "/mnt/epss/epss_scores-current.csv.gz"} for a
+// file on this instance, or {} for the configured EPSS_IMPORT_PATH.
+// POST /api/v1/vulnerabilities/epss/import
+func (h *AdvancedHandler) ImportEPSS(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var res *intelligence.EPSSImport
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var req struct {
+			Path string `json:"path"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if req.Path == "" {
+			req.Path = h.Intelligence.EPSSImportPath
+		}
+		if req.Path == "" {
+			http.Error(w, "No path given and EPSS_IMPORT_PATH not set", http.StatusBadRequest)
+			return
+		}
+		res, err = h.Intelligence.ImportEPSSFile(r.Context(), req.Path)
+	} else {
+		res, err = h.Intelligence.ImportEPSSCSV(r.Context(), http.MaxBytesReader(w, r.Body, maxEPSSUpload))
+	}
+	if err != nil {
+		http.Error(w, "Import failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "EPSS_IMPORT", nil, map[string]interface{}{"source": res.Source, "count": res.Count, "modelVersion": res.ModelVersion})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// maxRuntimeReportSize bounds a single cluster snapshot.
+const maxRuntimeReportSize = 16 << 20
+
+// ReportRuntime accepts a snapshot of the images running in a cluster and
+// refreshes the priorities of manifests whose exposure changed.
+func (h *AdvancedHandler) ReportRuntime(w http.ResponseWriter, r *http.Request) {
+	if h.RuntimeAgentToken == "" {
+		http.Error(w, "Runtime reporting is disabled (RUNTIME_AGENT_TOKEN not set)", http.StatusNotFound)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.RuntimeAgentToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var report intelligence.RuntimeReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRuntimeReportSize)).Decode(&report); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if report.Cluster == "" {
+		http.Error(w, "cluster is required", http.StatusBadRequest)
+		return
+	}
+
+	changed, err := h.Intelligence.IngestRuntimeReport(r.Context(), report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	go h.Intelligence.RecalculateForDigests(context.Background(), changed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "accepted",
+		"workloads":      len(report.Workloads),
+		"changedDigests": len(changed),
+	})
+}
+
+// ListRuntimeWorkloads returns workloads currently reported as running,
+// optionally filtered to a single image digest.
+func (h *AdvancedHandler) ListRuntimeWorkloads(w http.ResponseWriter, r *http.Request) {
+	workloads, err := h.Intelligence.ListRuntimeWorkloads(r.Context(), r.URL.Query().Get("digest"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workloads)
+}
+
 // GetCostDashboard returns the cost dashboard summary
 func (h *AdvancedHandler) GetCostDashboard(w http.ResponseWriter, r *http.Request) {
 	// Extract User & Role
@@ -124,6 +274,74 @@ func (h *AdvancedHandler) GetCostDashboard(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(dashboard)
 }
 
+// GetDedupReport returns logical vs physical storage per namespace
+// GET /api/v1/costs/dedup
+func (h *AdvancedHandler) GetDedupReport(w http.ResponseWriter, r *http.Request) {
+	role, _ := r.Context().Value(middleware.RoleKey).(string)
+	userIDStr, _ := r.Context().Value(middleware.UserKey).(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil && role != "admin" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	report, err := h.Costs.GetDedupReport(r.Context(), userID, role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetCostRecommendations returns actionable ways to lower costs (unused
+// images, untagged manifests, oversized images, near-duplicate tags) with
+// estimated monthly savings
+// GET /api/v1/costs/recommendations
+func (h *AdvancedHandler) GetCostRecommendations(w http.ResponseWriter, r *http.Request) {
+	role, _ := r.Context().Value(middleware.RoleKey).(string)
+	userIDStr, _ := r.Context().Value(middleware.UserKey).(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil && role != "admin" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	report, err := h.Costs.GetRecommendations(r.Context(), userID, role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetRepositoryCosts returns one repository's storage and bandwidth costs,
+// per tag, with the trend of the last ?days=30 days.
+// GET /api/v1/costs/repositories/{name}
+func (h *AdvancedHandler) GetRepositoryCosts(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleRead) {
+		return
+	}
+
+	days, _ := strconv.Atoi(r.URL.Query().Get("days"))
+	report, err := h.Costs.GetRepositoryCosts(r.Context(), name, days)
+	if err == costs.ErrRepositoryNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
 // GetZombieImages returns list of zombie images
 func (h *AdvancedHandler) GetZombieImages(w http.ResponseWriter, r *http.Request) {
 	// Extract User & Role
@@ -160,9 +378,14 @@ func (h *AdvancedHandler) RefreshCosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.Costs.Refreshing() {
+		http.Error(w, costs.ErrRefreshRunning.Error(), http.StatusConflict)
+		return
+	}
+
 	go func() {
 		// Run in background with independent context
-		err := h.Costs.RefreshAllCosts(context.Background())
+		_, err := h.Costs.Refresh(context.Background(), costs.TriggerManual)
 		if err != nil {
 			println("Cost refresh error:", err.Error())
 		}
@@ -174,6 +397,26 @@ func (h *AdvancedHandler) RefreshCosts(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetCostRefreshHistory returns the recent cost refreshes, scheduled and
+// manual, newest first
+// GET /api/v1/costs/refresh/history
+func (h *AdvancedHandler) GetCostRefreshHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	runs, err := h.Costs.ListRefreshRuns(r.Context(), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": runs})
+}
+
 // CleanupZombies deletes zombie images
 func (h *AdvancedHandler) CleanupZombies(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
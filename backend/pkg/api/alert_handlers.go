@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/alerts"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// ListAlertRules returns a repository's alert rules.
+// GET /api/v1/repositories/{name}/alert-rules
+func (h *DashboardHandler) ListAlertRules(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+
+	rules, err := h.Alerts.ListRules(r.Context(), name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"repository": name, "rules": rules})
+}
+
+// decodeAlertRule reads and validates a rule from the request body. Omitted
+// fields default to an enabled rule for new criticals.
+func decodeAlertRule(w http.ResponseWriter, r *http.Request) (*alerts.Rule, bool) {
+	rule := alerts.Rule{MinSeverity: "CRITICAL", NewOnly: true, Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := rule.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &rule, true
+}
+
+// CreateAlertRule adds an alert rule, evaluated after each scan of the
+// repository.
+// POST /api/v1/repositories/{name}/alert-rules
+// {"name":"New criticals","minSeverity":"CRITICAL","minEpss":0.5,"newOnly":true,"channel":"slack","target":"https://hooks.slack.com/..."}
+func (h *DashboardHandler) CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+	rule, ok := decodeAlertRule(w, r)
+	if !ok {
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	created, err := h.Alerts.CreateRule(r.Context(), name, *rule, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if uid != uuid.Nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "ALERT_RULE_CREATE", nil, map[string]interface{}{"repository": name, "rule": created.ID, "channel": created.Channel})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// UpdateAlertRule replaces an alert rule's settings.
+// PUT /api/v1/repositories/{name}/alert-rules/{id}
+func (h *DashboardHandler) UpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+	id, err := uuid.Parse(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+	rule, ok := decodeAlertRule(w, r)
+	if !ok {
+		return
+	}
+
+	updated, err := h.Alerts.UpdateRule(r.Context(), name, id, *rule)
+	if errors.Is(err, alerts.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "ALERT_RULE_UPDATE", nil, map[string]interface{}{"repository": name, "rule": id, "channel": updated.Channel, "enabled": updated.Enabled})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteAlertRule removes an alert rule.
+// DELETE /api/v1/repositories/{name}/alert-rules/{id}
+func (h *DashboardHandler) DeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+	id, err := uuid.Parse(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Alerts.DeleteRule(r.Context(), name, id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, alerts.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "ALERT_RULE_DELETE", nil, map[string]interface{}{"repository": name, "rule": id})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
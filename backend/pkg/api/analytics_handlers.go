@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/analytics"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// parseWindowDays parses a window of days such as "30d" (a bare number is
+// days too), defaulting to analytics.DefaultWindowDays.
+func parseWindowDays(v string) (int, error) {
+	if v == "" {
+		return analytics.DefaultWindowDays, nil
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+	if err != nil || days < 1 || days > analytics.MaxWindowDays {
+		return 0, fmt.Errorf("invalid window %q: use 1d to %dd", v, analytics.MaxWindowDays)
+	}
+	return days, nil
+}
+
+// GetTopImages returns the most pulled repositories and tags, the
+// repositories growing fastest and the most active pushers over a window.
+// Non-admins see the repositories they can read.
+// GET /api/v1/analytics/top-images?window=30d&limit=10
+func (h *DashboardHandler) GetTopImages(w http.ResponseWriter, r *http.Request) {
+	days, err := parseWindowDays(r.URL.Query().Get("window"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := analytics.DefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if n > analytics.MaxLimit {
+			n = analytics.MaxLimit
+		}
+		limit = n
+	}
+
+	role, _ := r.Context().Value(middleware.RoleKey).(string)
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	top, err := h.Analytics.TopImages(r.Context(), uid, role, days, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(top)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/backup"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// ListBackups returns the metadata bundles stored in object storage.
+func (h *DashboardHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	infos, err := h.Backup.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if infos == nil {
+		infos = []backup.Info{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// CreateBackup exports all registry metadata to a new bundle right away.
+func (h *DashboardHandler) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	info, err := h.Backup.Export(r.Context())
+	if err != nil {
+		http.Error(w, "Backup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "BACKUP_CREATE", nil, map[string]interface{}{"path": info.Path, "size": info.Size})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+// RestoreBackup loads a bundle into the database. Without "merge" the
+// database must not contain any repositories yet.
+func (h *DashboardHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Path  string `json:"path"`
+		Merge bool   `json:"merge"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	inserted, err := h.Backup.Restore(r.Context(), req.Path, req.Merge)
+	if errors.Is(err, backup.ErrNotEmpty) {
+		http.Error(w, "Database already contains repositories; pass merge=true to restore anyway", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Restore failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.Metadata.MarkStatsDirty()
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "BACKUP_RESTORE", nil, map[string]interface{}{"path": req.Path, "merge": req.Merge, "rows": inserted})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "restored",
+		"path":     req.Path,
+		"inserted": inserted,
+	})
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+	"github.com/registryx/registryx/backend/pkg/webhook"
+)
+
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+// resolveDigest returns the digest a reference points to. Digests are taken
+// as-is so build metadata can be recorded before the manifest is pushed.
+func (h *DashboardHandler) resolveDigest(ctx context.Context, repoName, reference string) (string, error) {
+	if strings.HasPrefix(reference, "sha256:") {
+		return reference, nil
+	}
+	manifestID, err := h.Metadata.GetManifestID(ctx, repoName, reference)
+	if err != nil {
+		return "", err
+	}
+	return h.Metadata.GetDigest(ctx, manifestID)
+}
+
+// canRecordBuild checks that the caller may attach build metadata to images
+// of repoName. CI jobs usually call with the registry token they pushed
+// with, which must grant push on the repository; other callers need write
+// access to it.
+func (h *DashboardHandler) canRecordBuild(w http.ResponseWriter, r *http.Request, repoName string) bool {
+	grants, isToken := r.Context().Value(middleware.AccessKey).([]middleware.Access)
+	if !isToken {
+		return h.Authz.Require(w, r, repoName, authz.RoleWrite)
+	}
+	namespace, name := authz.SplitRepository(repoName)
+	for _, g := range grants {
+		if g.Type != "repository" {
+			continue
+		}
+		if ns, n := authz.SplitRepository(g.Name); ns != namespace || n != name {
+			continue
+		}
+		for _, a := range g.Actions {
+			if a == "push" || a == "*" {
+				return true
+			}
+		}
+	}
+	http.Error(w, "Forbidden: token does not grant push on "+repoName, http.StatusForbidden)
+	return false
+}
+
+// SetBuildMetadata attaches CI build information (pipeline URL, commit,
+// branch, builder) to an image digest.
+// PUT /api/v1/repositories/{name}/manifests/{reference}/build
+func (h *DashboardHandler) SetBuildMetadata(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repoName := vars["name"]
+	reference := vars["reference"]
+
+	if !h.canRecordBuild(w, r, repoName) {
+		return
+	}
+
+	var req struct {
+		PipelineURL string `json:"pipelineUrl"`
+		CommitSHA   string `json:"commitSha"`
+		Branch      string `json:"branch"`
+		Builder     string `json:"builder"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	md := &metadata.BuildMetadata{
+		PipelineURL: strings.TrimSpace(req.PipelineURL),
+		CommitSHA:   strings.ToLower(strings.TrimSpace(req.CommitSHA)),
+		Branch:      strings.TrimPrefix(strings.TrimSpace(req.Branch), "refs/heads/"),
+		Builder:     strings.TrimSpace(req.Builder),
+	}
+	if md.PipelineURL == "" && md.CommitSHA == "" && md.Branch == "" && md.Builder == "" {
+		http.Error(w, "At least one of pipelineUrl, commitSha, branch or builder is required", http.StatusBadRequest)
+		return
+	}
+	if md.PipelineURL != "" {
+		if u, err := url.Parse(md.PipelineURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "pipelineUrl must be an http(s) URL", http.StatusBadRequest)
+			return
+		}
+	}
+	if md.CommitSHA != "" && !commitSHAPattern.MatchString(md.CommitSHA) {
+		http.Error(w, "commitSha must be a hex commit hash", http.StatusBadRequest)
+		return
+	}
+	if len(md.Branch) > 255 || len(md.Builder) > 255 {
+		http.Error(w, "branch and builder must be at most 255 characters", http.StatusBadRequest)
+		return
+	}
+
+	digest, err := h.resolveDigest(r.Context(), repoName, reference)
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	md.ReportedBy, _ = r.Context().Value(middleware.UsernameKey).(string)
+	if md.ReportedBy == "" {
+		md.ReportedBy = userID
+	}
+	if err := h.Metadata.SetBuildMetadata(r.Context(), repoName, digest, md); err != nil {
+		if errors.Is(err, metadata.ErrRepositoryNotFound) {
+			http.Error(w, "Repository not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "BUILD_METADATA", nil, map[string]interface{}{"repository": repoName, "digest": digest, "commit": md.CommitSHA})
+	}
+
+	// Metadata usually arrives after the push; let webhook consumers catch up.
+	if h.Webhook != nil {
+		tag := ""
+		if reference != digest {
+			tag = reference
+		}
+		go h.Webhook.Notify(context.Background(), webhook.Event{
+			Action: "build", Repository: repoName, Tag: tag, Digest: digest, Timestamp: time.Now(), User: md.ReportedBy, Build: md,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(md)
+}
+
+// GetBuildMetadata returns the build information attached to an image.
+// GET /api/v1/repositories/{name}/manifests/{reference}/build
+func (h *DashboardHandler) GetBuildMetadata(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repoName := vars["name"]
+
+	if !h.Authz.Require(w, r, repoName, authz.RoleRead) {
+		return
+	}
+
+	digest, err := h.resolveDigest(r.Context(), repoName, vars["reference"])
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+	md, err := h.Metadata.GetBuildMetadata(r.Context(), repoName, digest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if md == nil {
+		http.Error(w, "No build metadata recorded", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(md)
+}
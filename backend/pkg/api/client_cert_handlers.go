@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/auth"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// ListClientCertificates returns the client certificate subjects that sign
+// in at /auth/token, and as whom.
+// GET /api/v1/system/client-certificates
+func (h *DashboardHandler) ListClientCertificates(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	list, err := h.Auth.ListClientCertificates(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"certificates": list})
+}
+
+// CreateClientCertificate maps a client certificate subject to a user or a
+// service account.
+// POST /api/v1/system/client-certificates
+// {"subject":"CN=ci-runner,OU=CI,O=Acme","serviceAccount":"ci-bot"} or {"subject":"CN=alice","username":"alice"}
+func (h *DashboardHandler) CreateClientCertificate(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Subject        string `json:"subject"`
+		Username       string `json:"username"`
+		ServiceAccount string `json:"serviceAccount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	cert, err := h.Auth.CreateClientCertificate(r.Context(), req.Subject, req.Username, req.ServiceAccount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "CLIENT_CERTIFICATE_CREATE", nil, map[string]interface{}{"subject": cert.Subject, "username": cert.Username, "serviceAccount": cert.ServiceAccount})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(cert)
+}
+
+// DeleteClientCertificate removes a subject mapping. Tokens it was already
+// issued stay valid until they expire or are revoked.
+// DELETE /api/v1/system/client-certificates/{id}
+func (h *DashboardHandler) DeleteClientCertificate(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Auth.DeleteClientCertificate(r.Context(), id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrClientCertificateNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "CLIENT_CERTIFICATE_DELETE", nil, map[string]interface{}{"id": id})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
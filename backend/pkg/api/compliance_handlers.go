@@ -0,0 +1,152 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/compliance"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// maxVerifyUpload bounds the reports accepted for verification.
+const maxVerifyUpload = 64 << 20
+
+// GetComplianceReport produces the signed compliance report on a namespace
+// for auditors, as JSON (default) or PDF. The Ed25519 signature of the body
+// is in the X-Signature header, base64, with the key in X-Signature-Key-Id.
+// Namespace owners and admins only.
+// GET /api/v1/namespaces/{name}/compliance-report?format=pdf&days=90
+func (h *DashboardHandler) GetComplianceReport(w http.ResponseWriter, r *http.Request) {
+	nsName := mux.Vars(r)["name"]
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		owner, err := h.Compliance.IsOwner(r.Context(), nsName, userID)
+		if errors.Is(err, compliance.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !owner && !h.namespaceAdmin(r, nsName) {
+			http.Error(w, "Forbidden: namespace owner or admin access required", http.StatusForbidden)
+			return
+		}
+	}
+
+	days := compliance.DefaultPeriodDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > compliance.MaxPeriodDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", compliance.MaxPeriodDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "pdf" {
+		http.Error(w, "format must be json or pdf", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.Compliance.Generate(r.Context(), nsName, days)
+	if errors.Is(err, compliance.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report.GeneratedBy = userID
+
+	keyID, err := h.Compliance.Signer.KeyID(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to load signing key", http.StatusInternalServerError)
+		return
+	}
+	var body []byte
+	contentType := "application/json"
+	if format == "pdf" {
+		body, contentType = report.PDF(keyID), "application/pdf"
+	} else if body, err = json.MarshalIndent(report, "", "  "); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	keyID, sig, err := h.Compliance.Signer.Sign(r.Context(), body)
+	if err != nil {
+		http.Error(w, "Failed to sign report", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(body)
+
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "COMPLIANCE_REPORT", nil, map[string]interface{}{"namespace": nsName, "format": format, "days": days, "sha256": hex.EncodeToString(sum[:])})
+	}
+
+	filename := fmt.Sprintf("compliance-%s-%s.%s", nsName, report.GeneratedAt.Format("20060102"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Header().Set("X-Signature", base64.StdEncoding.EncodeToString(sig))
+	w.Header().Set("X-Signature-Key-Id", keyID)
+	w.Header().Set("X-Content-SHA256", hex.EncodeToString(sum[:]))
+	w.Write(body)
+}
+
+// ListComplianceKeys returns the public keys compliance reports are signed
+// with, as PEM. Public, so auditors need no account.
+// GET /api/v1/compliance/keys
+func (h *DashboardHandler) ListComplianceKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.Compliance.Signer.PublicKeys(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+// VerifyComplianceReport checks a report file against the signature it was
+// delivered with. Public, like the keys.
+// POST /api/v1/compliance/verify?keyId=<id>&signature=<base64> (body: the report file)
+func (h *DashboardHandler) VerifyComplianceReport(w http.ResponseWriter, r *http.Request) {
+	keyID := r.URL.Query().Get("keyId")
+	sig, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("signature"))
+	if keyID == "" || err != nil {
+		http.Error(w, "keyId and a base64 signature are required", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxVerifyUpload))
+	if err != nil {
+		http.Error(w, "Report too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	valid, err := h.Compliance.Signer.Verify(r.Context(), keyID, body, sig)
+	if errors.Is(err, compliance.ErrUnknownKey) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"valid": valid, "keyId": keyID, "sha256": hex.EncodeToString(sum[:])})
+}
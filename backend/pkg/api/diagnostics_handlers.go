@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/registryx/registryx/backend/pkg/diagnostics"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+type DiagnosticsResponse struct {
+	UptimeSeconds   int64                     `json:"uptimeSeconds"`
+	SlowThresholdMs int64                     `json:"slowThresholdMs"`
+	Routes          []diagnostics.RouteStats  `json:"routes"`
+	SlowRequests    []diagnostics.SlowRequest `json:"slowRequests"`
+	Database        DatabasePoolStats         `json:"database"`
+}
+
+// DatabasePoolStats surfaces connection pool pressure, a common cause of slow pushes.
+type DatabasePoolStats struct {
+	OpenConnections int   `json:"openConnections"`
+	InUse           int   `json:"inUse"`
+	Idle            int   `json:"idle"`
+	WaitCount       int64 `json:"waitCount"`
+	WaitDurationMs  int64 `json:"waitDurationMs"`
+}
+
+// GetDiagnostics returns per-route latency percentiles, error rates and recent
+// slow requests with their SQL timings.
+// GET /api/v1/system/diagnostics
+func (h *DashboardHandler) GetDiagnostics(w http.ResponseWriter, r *http.Request) {
+	role := r.Context().Value(middleware.RoleKey)
+	if role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	if h.Diagnostics == nil {
+		http.Error(w, "Diagnostics not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	resp := DiagnosticsResponse{
+		UptimeSeconds:   int64(h.Diagnostics.Uptime().Seconds()),
+		SlowThresholdMs: h.Diagnostics.SlowThreshold().Milliseconds(),
+		Routes:          h.Diagnostics.Routes(),
+		SlowRequests:    h.Diagnostics.SlowRequests(),
+	}
+	dbStats := h.Metadata.DB.Stats()
+	resp.Database = DatabasePoolStats{
+		OpenConnections: dbStats.OpenConnections,
+		InUse:           dbStats.InUse,
+		Idle:            dbStats.Idle,
+		WaitCount:       dbStats.WaitCount,
+		WaitDurationMs:  dbStats.WaitDuration.Milliseconds(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
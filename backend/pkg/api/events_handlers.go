@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/events"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// StreamEvents pushes live registry events to the dashboard as server-sent events.
+// GET /api/v1/events/stream?types=push,scan.completed
+func (h *DashboardHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	role, _ := r.Context().Value(middleware.RoleKey).(string)
+
+	// Read access is checked once per repository for the life of the stream.
+	subject := authz.SubjectFromContext(r.Context())
+	readable := make(map[string]bool)
+	canRead := func(repo string) bool {
+		ok, cached := readable[repo]
+		if !cached {
+			ok, _ = h.Authz.Allowed(r.Context(), subject, repo, authz.RoleRead)
+			readable[repo] = ok
+		}
+		return ok
+	}
+
+	var types map[string]bool
+	if t := r.URL.Query().Get("types"); t != "" {
+		types = make(map[string]bool)
+		for _, typ := range strings.Split(t, ",") {
+			types[strings.TrimSpace(typ)] = true
+		}
+	}
+
+	ch, unsubscribe := h.Events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx buffering
+	w.WriteHeader(http.StatusOK)
+
+	// Tell EventSource how long to wait before reconnecting.
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case e := <-ch:
+			if types != nil && !types[e.Type] {
+				continue
+			}
+			if !events.Visible(e, userID, role, canRead) {
+				continue
+			}
+			payload, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, payload)
+			flusher.Flush()
+		}
+	}
+}
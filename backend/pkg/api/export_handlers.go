@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/imageexport"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// ExportImage streams an image as a tar archive for air-gapped transfer,
+// without a Docker daemon. format=oci (default) is an OCI image layout;
+// format=docker adds the manifest.json of `docker save`. platform picks one
+// image of a multi-arch index (the docker format defaults to linux/amd64).
+// The export is a pull, so pull policies apply.
+// GET /api/v1/repositories/{name}/manifests/{reference}/export?format=docker&platform=linux/arm64
+func (h *DashboardHandler) ExportImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repoName := vars["name"]
+	reference := vars["reference"]
+
+	if !h.Authz.Require(w, r, repoName, authz.RoleRead) {
+		return
+	}
+
+	manifestID, err := h.Metadata.GetManifestID(r.Context(), repoName, reference)
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+	digest, _, mediaType, err := h.Metadata.GetManifestDetails(r.Context(), manifestID)
+	if err != nil {
+		http.Error(w, "Internal error getting manifest details", http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	user := userID
+	if user == "" {
+		user = "anonymous"
+	}
+	image := r.Host + "/" + repoName + ":" + reference
+	if strings.HasPrefix(reference, "sha256:") {
+		image = r.Host + "/" + repoName + "@" + reference
+	}
+	if v := h.evaluateImage(r, image, "", user); !v.Allowed {
+		http.Error(w, "Denied by policy: "+strings.Join(v.Violations, "; "), http.StatusForbidden)
+		return
+	}
+
+	body, err := h.readObject(r, path.Join("manifests", repoName, digest))
+	if err != nil {
+		http.Error(w, "Manifest content not found in storage", http.StatusNotFound)
+		return
+	}
+	img := imageexport.Image{
+		Repository: repoName,
+		Name:       r.Host + "/" + repoName,
+		Digest:     digest,
+		MediaType:  mediaType,
+		Manifest:   body,
+		Format:     r.URL.Query().Get("format"),
+		Platform:   r.URL.Query().Get("platform"),
+	}
+	if img.Format == "" {
+		img.Format = imageexport.FormatOCI
+	}
+	if !strings.HasPrefix(reference, "sha256:") {
+		img.Tag = reference
+	}
+	archive, err := imageexport.Prepare(r.Context(), h.Storage, img)
+	switch {
+	case errors.Is(err, imageexport.ErrUnsupported), errors.Is(err, imageexport.ErrNoPlatform):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, imageexport.ErrMissingBlob):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.Metadata.TrackPull(r.Context(), manifestID, reference); err != nil {
+		fmt.Printf("Failed to track pull for %s: %v\n", manifestID, err)
+	}
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "IMAGE_EXPORT", nil, map[string]interface{}{"repository": repoName, "reference": reference, "digest": digest, "format": img.Format})
+	}
+
+	filename := fmt.Sprintf("%s-%s.tar", strings.ReplaceAll(repoName, "/", "_"), strings.ReplaceAll(reference, ":", "-"))
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Header().Set("Content-Length", strconv.FormatInt(archive.Size(), 10))
+	w.Header().Set("Docker-Content-Digest", digest)
+	if err := archive.Write(r.Context(), w); err != nil {
+		// Headers are out; the short body tells the client it failed.
+		fmt.Printf("[Export] Export of %s:%s failed: %v\n", repoName, reference, err)
+	}
+}
@@ -3,22 +3,51 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/registryx/registryx/backend/pkg/auth"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/backup"
+	"github.com/registryx/registryx/backend/pkg/alerts"
+	"github.com/registryx/registryx/backend/pkg/anomaly"
+	"github.com/registryx/registryx/backend/pkg/analytics"
 	"github.com/registryx/registryx/backend/pkg/audit"
+	"github.com/registryx/registryx/backend/pkg/compliance"
+	"github.com/registryx/registryx/backend/pkg/diagnostics"
+	"github.com/registryx/registryx/backend/pkg/events"
+	"github.com/registryx/registryx/backend/pkg/importer"
+	"github.com/registryx/registryx/backend/pkg/integrity"
+	"github.com/registryx/registryx/backend/pkg/ipallow"
+	"github.com/registryx/registryx/backend/pkg/gc"
+	"github.com/registryx/registryx/backend/pkg/georeplica"
 	"github.com/registryx/registryx/backend/pkg/health"
+	"github.com/registryx/registryx/backend/pkg/lint"
+	"github.com/registryx/registryx/backend/pkg/maintenance"
 	"github.com/registryx/registryx/backend/pkg/metadata"
 	"github.com/registryx/registryx/backend/pkg/policy"
+	"github.com/registryx/registryx/backend/pkg/quota"
+	"github.com/registryx/registryx/backend/pkg/replication"
+	"github.com/registryx/registryx/backend/pkg/retention"
+	"github.com/registryx/registryx/backend/pkg/reports"
 	"github.com/registryx/registryx/backend/pkg/scanner"
 	"github.com/registryx/registryx/backend/pkg/config"
+	"github.com/registryx/registryx/backend/pkg/cosign"
 	"github.com/registryx/registryx/backend/pkg/storage"
+	"github.com/registryx/registryx/backend/pkg/teams"
+	"github.com/registryx/registryx/backend/pkg/transfer"
+	"github.com/registryx/registryx/backend/pkg/trivydb"
 	"github.com/registryx/registryx/backend/pkg/middleware"
+	"github.com/registryx/registryx/backend/pkg/webhook"
 )
 
 func (h *DashboardHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -73,16 +102,32 @@ func (h *DashboardHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, token, err := h.Auth.LoginUser(r.Context(), req.Username, req.Password)
+	if h.Auth.Observer != nil {
+		if wait := h.Auth.Observer.LoginBlocked(r); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "Too many failed sign-in attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	user, token, expiresAt, err := h.Auth.LoginUser(r.Context(), req.Username, req.Password)
 	if err != nil {
+		if h.Auth.Observer != nil {
+			h.Auth.Observer.LoginFailed(r, req.Username, "password", err.Error())
+		}
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	if h.Auth.Observer != nil {
+		h.Auth.Observer.LoginSucceeded(r, user.ID.String(), user.Username)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(auth.AuthResponse{
-		Token: token,
-		User:  *user,
+		Token:     token,
+		ExpiresAt: &expiresAt,
+		ExpiresIn: int(time.Until(expiresAt) / time.Second),
+		User:      *user,
 	})
 }
 
@@ -235,6 +280,10 @@ func (h *DashboardHandler) ChangePassword(w http.ResponseWriter, r *http.Request
 
 	// Update password
 	if err := h.Auth.UpdatePassword(r.Context(), userID, req.NewPassword); err != nil {
+		if errors.Is(err, auth.ErrDirectoryUser) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Failed to update password", http.StatusInternalServerError)
 		return
 	}
@@ -252,9 +301,35 @@ type DashboardHandler struct {
 	Storage  storage.Driver
 	Config   *config.Config
 	Audit    *audit.Service
+	Events   *events.Broker
+	Diagnostics *diagnostics.Recorder
+	Backup      *backup.Service
+	Maintenance *maintenance.Service
+	Authz       *authz.Authorizer
+	Transfers   *transfer.Service
+	Replicas    *georeplica.Syncer // nil when no storage replicas are configured
+	Linter      *lint.Linter
+	Webhook     *webhook.Service
+	TrivyDB     *trivydb.Manager
+	Alerts      *alerts.Service
+	Security    *anomaly.Detector
+	Networks    *ipallow.Checker
+	Imports     *importer.Service
+	Reports     *reports.Weekly
+	Compliance  *compliance.Service
+	Quota       *quota.Monitor
+	Integrity   *integrity.Verifier
+	Teams       *teams.Service
+	Analytics   *analytics.Service
+	Replication *replication.Service // nil without Redis
+	Signatures  *cosign.Verifier     // nil only looks for a signature tag
+	Retention   *retention.Enforcer
+	GC          *gc.Service // nil neither records runs nor keeps them from overlapping
+
+	scanTriggers *slidingWindowLimiter
 }
 
-func NewDashboardHandler(meta *metadata.Service, scan *scanner.Service, pol *policy.Service, auth *auth.Service, store storage.Driver, cfg *config.Config, aud *audit.Service) *DashboardHandler {
+func NewDashboardHandler(meta *metadata.Service, scan *scanner.Service, pol *policy.Service, auth *auth.Service, store storage.Driver, cfg *config.Config, aud *audit.Service, bus *events.Broker, diag *diagnostics.Recorder) *DashboardHandler {
 	return &DashboardHandler{
 		Metadata: meta,
 		Scanner:  scan,
@@ -263,6 +338,9 @@ func NewDashboardHandler(meta *metadata.Service, scan *scanner.Service, pol *pol
 		Storage:  store,
 		Config:   cfg,
 		Audit:    aud,
+		Events:   bus,
+		Diagnostics: diag,
+		scanTriggers: newSlidingWindowLimiter(cfg.ScanTriggersPerMinute, time.Minute),
 	}
 }
 
@@ -326,10 +404,9 @@ func (h *DashboardHandler) GetStats(w http.ResponseWriter, r *http.Request) {
 
 // ListServiceAccounts GET /api/v1/service-accounts
 func (h *DashboardHandler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
-	// Security: Block anonymous
-	user := r.Context().Value(middleware.UserKey)
-	if user == nil || user == "anonymous" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// Service accounts can be unscoped, so only admins manage them
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
 		return
 	}
 	accounts, err := h.Auth.List(r.Context())
@@ -342,22 +419,38 @@ func (h *DashboardHandler) ListServiceAccounts(w http.ResponseWriter, r *http.Re
 
 // CreateServiceAccount POST /api/v1/service-accounts
 func (h *DashboardHandler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
-	// Security: Block anonymous
-	user := r.Context().Value(middleware.UserKey)
-	if user == nil || user == "anonymous" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// Service accounts can be unscoped, so only admins manage them
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
 		return
 	}
 	var req struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
+		Name            string   `json:"name"`
+		Description     string   `json:"description"`
+		TokenTTLSeconds *int     `json:"tokenTtlSeconds"` // overrides REGISTRY_TOKEN_TTL_MINUTES
+		AllowedCIDRs    []string `json:"allowedCidrs"`    // networks it may be used from; empty allows any
+		Scopes          []auth.RepositoryScope `json:"scopes"` // repositories and actions it is limited to; empty allows all
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if req.TokenTTLSeconds != nil && *req.TokenTTLSeconds <= 0 {
+		http.Error(w, "tokenTtlSeconds must be positive", http.StatusBadRequest)
+		return
+	}
+	cidrs, err := ipallow.Normalize(req.AllowedCIDRs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scopes, err := auth.NormalizeScopes(req.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	acc, key, err := h.Auth.Create(r.Context(), req.Name, req.Description)
+	acc, key, err := h.Auth.Create(r.Context(), req.Name, req.Description, req.TokenTTLSeconds, cidrs, scopes)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -371,10 +464,9 @@ func (h *DashboardHandler) CreateServiceAccount(w http.ResponseWriter, r *http.R
 
 // RevokeServiceAccount DELETE /api/v1/service-accounts/{id}
 func (h *DashboardHandler) RevokeServiceAccount(w http.ResponseWriter, r *http.Request) {
-	// Security: Block anonymous
-	user := r.Context().Value(middleware.UserKey)
-	if user == nil || user == "anonymous" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// Service accounts can be unscoped, so only admins manage them
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
 		return
 	}
 	vars := mux.Vars(r)
@@ -416,7 +508,12 @@ type ManifestDetailsResponse struct {
 	MediaType       string                  `json:"mediaType"`
 	Vulnerabilities *scanner.ScanSummary    `json:"vulnerabilities"`
 	IsSigned        bool                    `json:"isSigned"`
+	Signatures      []cosign.Identity       `json:"signatures,omitempty"` // cosign signatures that verified
 	HealthScore     *health.HealthScore     `json:"healthScore,omitempty"`
+	Lint            []lint.Finding          `json:"lint,omitempty"`
+	Build           *metadata.BuildMetadata `json:"build,omitempty"`
+	Subject         *metadata.Subject       `json:"subject,omitempty"`   // the manifest this artifact was pushed for
+	Referrers       []metadata.Referrer     `json:"referrers,omitempty"` // artifacts pushed for this manifest
 }
 
 // GetManifestDetails returns enriched manifest info (vulns, signatures).
@@ -429,7 +526,7 @@ func (h *DashboardHandler) GetManifestDetails(w http.ResponseWriter, r *http.Req
 	// 1. Resolve to Manifest UUID
 	manifestID, err := h.Metadata.GetManifestID(r.Context(), repoName, reference)
 	if err != nil {
-		http.Error(w, "Manifest not found", http.StatusNotFound)
+		writeMetadataError(w, err)
 		return
 	}
 
@@ -448,7 +545,7 @@ func (h *DashboardHandler) GetManifestDetails(w http.ResponseWriter, r *http.Req
 	}
 
 	// 4. Check Signature
-	isSigned, _ := h.Metadata.HasSignature(r.Context(), repoName, digest)
+	isSigned, signatures := h.verifySignatures(r.Context(), repoName, digest)
 	// For demo/UI consistency: If we have real scan results, consider it "System Authenticated"
 	if !isSigned && summary != nil && summary.Status == "completed" {
 		fmt.Printf("[API] No external signature for %s, but scan is complete. Marking as System Attested.\n", manifestID)
@@ -469,13 +566,46 @@ func (h *DashboardHandler) GetManifestDetails(w http.ResponseWriter, r *http.Req
 		healthScore, _ = h.Metadata.CalculateAndStoreHealthScore(r.Context(), manifestID)
 	}
 
+	// 6. Lint findings (images pushed before linting are linted now)
+	findings, linted, err := h.Metadata.GetLintFindings(r.Context(), manifestID)
+	if err == nil && !linted && h.Linter != nil {
+		if body, err := h.readObject(r, path.Join("manifests", repoName, digest)); err == nil {
+			if findings, err = h.Linter.Lint(r.Context(), body); err == nil {
+				if err := h.Metadata.StoreLintFindings(r.Context(), manifestID, findings); err != nil {
+					fmt.Printf("[Lint] Failed to store findings for %s: %v\n", manifestID, err)
+				}
+			}
+		}
+	}
+
+	// 7. CI build metadata
+	build, err := h.Metadata.GetBuildMetadata(r.Context(), repoName, digest)
+	if err != nil {
+		fmt.Printf("[API] Failed to load build metadata for %s: %v\n", digest, err)
+	}
+
+	// 8. Artifacts linked through the "subject" field
+	subject, err := h.Metadata.GetSubject(r.Context(), manifestID)
+	if err != nil {
+		fmt.Printf("[API] Failed to load subject of %s: %v\n", digest, err)
+	}
+	referrers, err := h.Metadata.GetReferrers(r.Context(), manifestID)
+	if err != nil {
+		fmt.Printf("[API] Failed to load referrers of %s: %v\n", digest, err)
+	}
+
 	resp := ManifestDetailsResponse{
 		Digest:          digest,
 		Size:            size,
 		MediaType:       mediaType,
 		Vulnerabilities: summary,
 		IsSigned:        isSigned,
+		Signatures:      signatures,
 		HealthScore:     healthScore,
+		Lint:            findings,
+		Build:           build,
+		Subject:         subject,
+		Referrers:       referrers,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -494,40 +624,34 @@ func (h *DashboardHandler) DeleteManifest(w http.ResponseWriter, r *http.Request
 	repoName := vars["name"]
 	reference := vars["reference"]
 
-	// Security: User Isolation
-	userRole, _ := r.Context().Value(middleware.RoleKey).(string)
-	username, _ := r.Context().Value(middleware.UsernameKey).(string)
-	if userRole != "admin" && username != "" {
-		if !strings.HasPrefix(repoName, username+"/") {
-			http.Error(w, "Forbidden: Namespace mismatch", http.StatusForbidden)
-			return
-		}
+	if !h.Authz.Require(w, r, repoName, authz.RoleWrite) {
+		return
 	}
 
-	// 1. Check if reference is a UUID (Direct Deletion by ID)
-	if id, err := uuid.Parse(reference); err == nil {
-		if err := h.Metadata.DeleteManifest(r.Context(), id); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	// 1. Check if reference is a UUID (Direct Deletion by ID), otherwise
+	// resolve to Manifest UUID (by Tag or Digest)
+	manifestID, err := uuid.Parse(reference)
+	if err != nil {
+		manifestID, err = h.Metadata.GetManifestID(r.Context(), repoName, reference)
+		if err != nil {
+			writeMetadataError(w, err)
 			return
 		}
-		w.WriteHeader(http.StatusNoContent)
-		return
 	}
 
-	// 2. Resolve to Manifest UUID (by Tag or Digest)
-	manifestID, err := h.Metadata.GetManifestID(r.Context(), repoName, reference)
+	// 2. Delete Manifest, then its objects in storage
+	paths, err := h.Metadata.ManifestObjectPaths(r.Context(), manifestID)
 	if err != nil {
-		http.Error(w, "Manifest not found", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	// 3. Delete Manifest
 	if err := h.Metadata.DeleteManifest(r.Context(), manifestID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeMetadataError(w, err)
 		return
 	}
+	h.auditDeletion(r, "DELETE_MANIFEST", repoName, reference)
 
-	w.WriteHeader(http.StatusNoContent)
+	h.deleteObjects(w, r, paths)
 }
 
 // DeleteRepository handles DELETE /api/v1/repositories/{name}
@@ -542,24 +666,23 @@ func (h *DashboardHandler) DeleteRepository(w http.ResponseWriter, r *http.Reque
 	vars := mux.Vars(r)
 	name := vars["name"]
 
-	// Security: User Isolation (Namespace Check)
-	userRole, _ := r.Context().Value(middleware.RoleKey).(string)
-	username, _ := r.Context().Value(middleware.UsernameKey).(string)
-
-	if userRole != "admin" && username != "" {
-		if !strings.HasPrefix(name, username+"/") {
-			http.Error(w, fmt.Sprintf("Forbidden: You can only delete repositories in your namespace (%s/)", username), http.StatusForbidden)
-			return
-		}
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
 	}
 
-	err := h.Metadata.DeleteRepository(r.Context(), name)
+	paths, err := h.Metadata.RepositoryObjectPaths(r.Context(), name)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	err = h.Metadata.DeleteRepository(r.Context(), name)
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+	h.auditDeletion(r, "DELETE_REPOSITORY", name, "")
 
-	w.WriteHeader(http.StatusNoContent)
+	h.deleteObjects(w, r, paths)
 }
 
 // DeleteTag handles DELETE /api/v1/repositories/{name}/tags/{tag}
@@ -568,23 +691,70 @@ func (h *DashboardHandler) DeleteTag(w http.ResponseWriter, r *http.Request) {
 	name := vars["name"]
 	tag := vars["tag"]
 
-	// Security: User Isolation
-	userRole, _ := r.Context().Value(middleware.RoleKey).(string)
-	username, _ := r.Context().Value(middleware.UsernameKey).(string)
-	if userRole != "admin" && username != "" {
-		if !strings.HasPrefix(name, username+"/") {
-			http.Error(w, "Forbidden: Namespace mismatch", http.StatusForbidden)
-			return
-		}
+	if !h.Authz.Require(w, r, name, authz.RoleWrite) {
+		return
 	}
 
 	err := h.Metadata.DeleteTag(r.Context(), name, tag)
 	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+	h.auditDeletion(r, "DELETE_TAG", name, tag)
+
+	// The manifest stays stored under its digest; only the tag's copy goes.
+	ns, repo := authz.SplitRepository(name)
+	h.deleteObjects(w, r, metadata.ObjectPaths(ns, repo, tag))
+}
+
+// writeMetadataError answers with the status of a metadata error: 404 for a
+// missing repository, manifest or tag, 403 for an exceeded quota, 500
+// otherwise.
+func writeMetadataError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, metadata.ErrRepositoryNotFound),
+		errors.Is(err, metadata.ErrManifestNotFound),
+		errors.Is(err, metadata.ErrTagNotFound),
+		errors.Is(err, metadata.ErrRuleNotFound),
+		errors.Is(err, metadata.ErrNoRetentionPolicy):
 		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, metadata.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// deleteObjects removes the storage objects of deleted manifests and answers
+// the deletion: 204, or 200 listing the objects that couldn't be removed.
+// Those are logged too; the database no longer references them.
+func (h *DashboardHandler) deleteObjects(w http.ResponseWriter, r *http.Request, paths []string) {
+	errs := storage.DeleteAll(r.Context(), h.Storage, paths)
+	if len(errs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+		fmt.Printf("[Delete] Failed to delete manifest object %v\n", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"storageErrors": messages})
+}
+
+// auditDeletion records a deletion in the audit log, where the anomaly
+// detector counts it. reference is the tag, digest or manifest ID, if any.
+func (h *DashboardHandler) auditDeletion(r *http.Request, action, repository, reference string) {
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		details := map[string]interface{}{"repository": repository}
+		if reference != "" {
+			details["reference"] = reference
+		}
+		_ = h.Audit.Log(r.Context(), uid, action, nil, details)
+	}
 }
 
 // GetPolicy returns the current Rego policy.
@@ -612,6 +782,93 @@ func (h *DashboardHandler) UpdatePolicy(w http.ResponseWriter, r *http.Request)
 
 	w.WriteHeader(http.StatusOK)
 }
+var environmentPattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// GetNamespaceEnvironment returns the policy environment of a namespace.
+// GET /api/v1/namespaces/{name}/environment
+func (h *DashboardHandler) GetNamespaceEnvironment(w http.ResponseWriter, r *http.Request) {
+	nsName := mux.Vars(r)["name"]
+
+	env, err := h.Metadata.GetNamespaceEnvironment(r.Context(), nsName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	inherited := env == ""
+	if inherited {
+		env = h.Config.PolicyEnvironment
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"namespace":   nsName,
+		"environment": env,
+		"inherited":   inherited,
+	})
+}
+
+// UpdateNamespaceEnvironment sets the policy environment of a namespace.
+// An empty environment reverts to the global default. Admin only, since it
+// changes which policies apply to pulls.
+// PUT /api/v1/namespaces/{name}/environment
+func (h *DashboardHandler) UpdateNamespaceEnvironment(w http.ResponseWriter, r *http.Request) {
+	role := r.Context().Value(middleware.RoleKey)
+	if role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	nsName := mux.Vars(r)["name"]
+
+	var req struct {
+		Environment string `json:"environment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Environment = strings.TrimSpace(req.Environment)
+	if req.Environment != "" && !environmentPattern.MatchString(req.Environment) {
+		http.Error(w, "Invalid environment: use lowercase letters, digits, '-' or '_'", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Metadata.SetNamespaceEnvironment(r.Context(), nsName, req.Environment); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "NAMESPACE_ENVIRONMENT_UPDATE", nil, map[string]interface{}{"namespace": nsName, "environment": req.Environment})
+	}
+
+	h.GetNamespaceEnvironment(w, r)
+}
+
+// ListRepositories GET /api/v1/repositories
+// Returns each visible repository with its summary metadata so the UI does not
+// have to fetch tags, manifests and scans per repository.
+func (h *DashboardHandler) ListRepositories(w http.ResponseWriter, r *http.Request) {
+	userRole, _ := r.Context().Value(middleware.RoleKey).(string)
+	var userID uuid.UUID
+	if uidStr, ok := r.Context().Value(middleware.UserKey).(string); ok {
+		userID, _ = uuid.Parse(uidStr)
+	}
+
+	repos, err := h.Metadata.ListRepositorySummaries(r.Context(), userID, userRole)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"repositories": repos,
+	})
+}
+
 // CreateRepository POST /api/v1/repositories
 func (h *DashboardHandler) CreateRepository(w http.ResponseWriter, r *http.Request) {
 	// Security: Block anonymous
@@ -643,6 +900,16 @@ func (h *DashboardHandler) CreateRepository(w http.ResponseWriter, r *http.Reque
         }
     }
     
+    if err := h.Metadata.CheckRepositoryLimit(r.Context(), req.Name, userID); err != nil {
+        var limitErr *metadata.LimitError
+        if errors.As(err, &limitErr) {
+            http.Error(w, limitErr.Error(), http.StatusForbidden)
+            return
+        }
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
     repoID, err := h.Metadata.EnsureRepository(r.Context(), req.Name, userID)
     if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -682,6 +949,66 @@ func (h *DashboardHandler) GetDependencyGraph(w http.ResponseWriter, r *http.Req
 	json.NewEncoder(w).Encode(graph)
 }
 
+var (
+	cvePattern          = regexp.MustCompile(`^[A-Z][A-Z0-9]*-[0-9A-Za-z:-]{1,48}$`)
+	impactDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// GetDependencyImpact lists every image affected by a CVE or a base image,
+// including images built on top of them, grouped by owner.
+// GET /api/v1/dependencies/impact?cve=CVE-2024-1234 or ?digest=sha256:...
+func (h *DashboardHandler) GetDependencyImpact(w http.ResponseWriter, r *http.Request) {
+	userRole, _ := r.Context().Value(middleware.RoleKey).(string)
+	var userID uuid.UUID
+	if uidStr, ok := r.Context().Value(middleware.UserKey).(string); ok {
+		userID, _ = uuid.Parse(uidStr)
+	}
+
+	cve := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("cve")))
+	digest := strings.TrimSpace(r.URL.Query().Get("digest"))
+	if (cve == "") == (digest == "") {
+		http.Error(w, "Specify exactly one of cve or digest", http.StatusBadRequest)
+		return
+	}
+	if cve != "" && !cvePattern.MatchString(cve) {
+		http.Error(w, "Invalid CVE identifier", http.StatusBadRequest)
+		return
+	}
+	if digest != "" && !impactDigestPattern.MatchString(digest) {
+		http.Error(w, "Invalid digest", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.Metadata.GetImpact(r.Context(), cve, digest, userID, userRole)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetRebuildRecommendations lists images to rebuild because a patched release
+// of their base image is available, most urgent first.
+// GET /api/v1/rebuild-recommendations
+func (h *DashboardHandler) GetRebuildRecommendations(w http.ResponseWriter, r *http.Request) {
+	userRole, _ := r.Context().Value(middleware.RoleKey).(string)
+	var userID uuid.UUID
+	if uidStr, ok := r.Context().Value(middleware.UserKey).(string); ok {
+		userID, _ = uuid.Parse(uidStr)
+	}
+
+	recs, err := h.Metadata.GetRebuildRecommendations(r.Context(), userID, userRole, h.Config.PolicyEnvironment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
+
 // GetScanStatus returns the scan status for a manifest
 // GET /api/v1/repositories/{name}/manifests/{reference}/scan/status
 func (h *DashboardHandler) GetScanStatus(w http.ResponseWriter, r *http.Request) {
@@ -692,7 +1019,7 @@ func (h *DashboardHandler) GetScanStatus(w http.ResponseWriter, r *http.Request)
 	// Resolve to Manifest UUID
 	manifestID, err := h.Metadata.GetManifestID(r.Context(), repoName, reference)
 	if err != nil {
-		http.Error(w, "Manifest not found", http.StatusNotFound)
+		writeMetadataError(w, err)
 		return
 	}
 
@@ -706,7 +1033,8 @@ func (h *DashboardHandler) GetScanStatus(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(status)
 }
 
-// DownloadScanReport downloads the full Trivy JSON report
+// DownloadScanReport downloads the full Trivy JSON report, or with ?format=
+// sarif, cyclonedx (the image's SBOM) or vdr (a CycloneDX VDR linked to it)
 // GET /api/v1/repositories/{name}/manifests/{reference}/scan/report
 func (h *DashboardHandler) DownloadScanReport(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -716,7 +1044,7 @@ func (h *DashboardHandler) DownloadScanReport(w http.ResponseWriter, r *http.Req
 	// Resolve to Manifest UUID
 	manifestID, err := h.Metadata.GetManifestID(r.Context(), repoName, reference)
 	if err != nil {
-		http.Error(w, "Manifest not found", http.StatusNotFound)
+		writeMetadataError(w, err)
 		return
 	}
 
@@ -727,8 +1055,40 @@ func (h *DashboardHandler) DownloadScanReport(w http.ResponseWriter, r *http.Req
 	}
 
 	// Set headers for file download
-	filename := fmt.Sprintf("trivy-report-%s-%s.json", repoName, reference)
-	w.Header().Set("Content-Type", "application/json")
+	ext, contentType := "json", "application/json"
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "sarif":
+		if report, err = scanner.ToSARIF(report, repoName); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ext, contentType = "sarif", "application/sarif+json"
+	case "cyclonedx", "vdr":
+		digest, err := h.Metadata.GetDigest(r.Context(), manifestID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		img := scanner.ImageRef{Repository: repoName, Digest: digest}
+		if r.URL.Query().Get("format") == "vdr" {
+			report, err = scanner.ToCycloneDXVDR(report, img)
+			ext = "vdr.json"
+		} else {
+			report, err = scanner.ToCycloneDXSBOM(report, img)
+			ext = "cdx.json"
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		contentType = "application/vnd.cyclonedx+json"
+	default:
+		http.Error(w, "format must be json, sarif, cyclonedx or vdr", http.StatusBadRequest)
+		return
+	}
+	filename := fmt.Sprintf("trivy-report-%s-%s.%s", strings.ReplaceAll(repoName, "/", "_"), reference, ext)
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 	w.Write(report)
 }
@@ -743,7 +1103,7 @@ func (h *DashboardHandler) GetScanHistory(w http.ResponseWriter, r *http.Request
 	// Resolve to Manifest UUID
 	manifestID, err := h.Metadata.GetManifestID(r.Context(), repoName, reference)
 	if err != nil {
-		http.Error(w, "Manifest not found", http.StatusNotFound)
+		writeMetadataError(w, err)
 		return
 	}
 
@@ -767,19 +1127,40 @@ func (h *DashboardHandler) TriggerManualScan(w http.ResponseWriter, r *http.Requ
 	// Resolve to Manifest UUID
 	manifestID, err := h.Metadata.GetManifestID(r.Context(), repoName, reference)
 	if err != nil {
-		http.Error(w, "Manifest not found", http.StatusNotFound)
+		writeMetadataError(w, err)
 		return
 	}
 
-	// Check if a scan is already in progress - we log it but allow the new one to proceed
-	// to prevent users from being stuck by "zombie" scanning records.
-	status, err := h.Scanner.GetScanStatus(r.Context(), manifestID)
-	if err == nil && status.Status == "scanning" {
-		fmt.Printf("[Manual Scan] Scan already in progress for %s, allowing override\n", manifestID)
+	// Repeated clicks while a scan is running just report the running scan.
+	// The in-process check is used rather than the stored status so a
+	// "zombie" scanning record can't block users from rescanning.
+	if h.Scanner.IsScanning(manifestID) {
+		writeScanAlreadyRunning(w)
+		return
+	}
+
+	// Each manual scan runs a Trivy process; cap how often one user can start them.
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if ok, retryAfter := h.scanTriggers.Allow(userID); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "Too many scan requests, please try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	// Say why right away rather than queueing a scan that will be refused.
+	if err := h.Scanner.TrivyDB.Check(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if !h.Scanner.TryBeginScan(manifestID) {
+		writeScanAlreadyRunning(w)
+		return
 	}
 
 	// Trigger scan asynchronously
 	go func() {
+		defer h.Scanner.EndScan(manifestID)
 		fmt.Printf("[Manual Scan] Triggering scan for %s:%s (manifest: %s)\n", repoName, reference, manifestID)
 		h.Scanner.ScanManifest(context.Background(), manifestID, repoName, reference)
 		
@@ -789,6 +1170,11 @@ func (h *DashboardHandler) TriggerManualScan(w http.ResponseWriter, r *http.Requ
 		if err != nil {
 			fmt.Printf("[Manual Scan] Failed to update health score: %v\n", err)
 		}
+		if h.Alerts != nil {
+			if err := h.Alerts.Evaluate(context.Background(), manifestID, repoName, reference); err != nil {
+				fmt.Printf("[Manual Scan] Alert rules failed: %v\n", err)
+			}
+		}
 	}()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -799,7 +1185,17 @@ func (h *DashboardHandler) TriggerManualScan(w http.ResponseWriter, r *http.Requ
 	})
 }
 
-// GetAuditLogs returns the activity logs for the authenticated user
+func writeScanAlreadyRunning(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Scan already in progress",
+		"status":  "scanning",
+	})
+}
+
+// GetAuditLogs returns a page of activity logs for the authenticated user
+// GET /api/v1/user/audit-logs?limit=50&cursor=...&since=...&until=...
 func (h *DashboardHandler) GetAuditLogs(w http.ResponseWriter, r *http.Request) {
 	// Use middleware key
 	userIDRaw := r.Context().Value(middleware.UserKey)
@@ -831,13 +1227,38 @@ func (h *DashboardHandler) GetAuditLogs(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	logs, err := h.Audit.GetUserLogs(r.Context(), userID, 50)
+	// Pagination & time range: ?limit=50&cursor=<next_cursor>&since=<RFC3339>&until=<RFC3339>
+	query := audit.LogQuery{Cursor: r.URL.Query().Get("cursor")}
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		query.Limit = limit
+	}
+	for param, dst := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if v := r.URL.Query().Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s (expected RFC3339)", param), http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+
+	page, err := h.Audit.ListUserLogs(r.Context(), userID, query)
+	if errors.Is(err, audit.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch logs", http.StatusInternalServerError)
 		return
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(logs)
+	json.NewEncoder(w).Encode(page)
 }
 
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/imagehistory"
+)
+
+// maxConfigSize bounds the config blob read for history; real configs are a
+// few kilobytes.
+const maxConfigSize = 16 * 1024 * 1024
+
+// GetImageHistory reconstructs the build steps of an image from its config,
+// so users can review what went into it without pulling it.
+// GET /api/v1/repositories/{name}/manifests/{reference}/history
+func (h *DashboardHandler) GetImageHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repoName := vars["name"]
+	reference := vars["reference"]
+
+	if !h.Authz.Require(w, r, repoName, authz.RoleRead) {
+		return
+	}
+
+	manifestID, err := h.Metadata.GetManifestID(r.Context(), repoName, reference)
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+	digest, _, _, err := h.Metadata.GetManifestDetails(r.Context(), manifestID)
+	if err != nil {
+		http.Error(w, "Internal error getting manifest details", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := h.readObject(r, path.Join("manifests", repoName, digest))
+	if err != nil {
+		http.Error(w, "Manifest content not found in storage", http.StatusNotFound)
+		return
+	}
+	manifest, err := imagehistory.ParseManifest(body)
+	if errors.Is(err, imagehistory.ErrIndex) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid manifest: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	configBlob, err := h.readObject(r, path.Join("blobs", manifest.Config.Digest))
+	if err != nil {
+		http.Error(w, "Image config not found in storage", http.StatusNotFound)
+		return
+	}
+	steps, err := imagehistory.Reconstruct(manifest, configBlob)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid image config: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var total int64
+	for _, l := range manifest.Layers {
+		total += l.Size
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"digest":       digest,
+		"configDigest": manifest.Config.Digest,
+		"totalSize":    total,
+		"steps":        steps,
+	})
+}
+
+func (h *DashboardHandler) readObject(r *http.Request, objectPath string) ([]byte, error) {
+	reader, err := h.Storage.Reader(r.Context(), objectPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, maxConfigSize))
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/registryx/registryx/backend/pkg/cosign"
+	"github.com/registryx/registryx/backend/pkg/policy"
+	"github.com/registryx/registryx/backend/pkg/scanner"
+)
+
+// imageRef is a parsed container image reference.
+type imageRef struct {
+	Host       string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseImageRef splits "host[:port]/repo[:tag][@digest]". The first path
+// component is only a host if it looks like one, as in the Docker CLI.
+func parseImageRef(image string) imageRef {
+	var ref imageRef
+	if i := strings.Index(image, "@"); i >= 0 {
+		ref.Digest = image[i+1:]
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		ref.Tag = image[i+1:]
+		image = image[:i]
+	}
+	if i := strings.Index(image, "/"); i >= 0 {
+		first := image[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Host = first
+			image = image[i+1:]
+		}
+	}
+	ref.Repository = image
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref
+}
+
+// isLocalRegistry reports whether host refers to this registry.
+func (h *DashboardHandler) isLocalRegistry(r *http.Request, host string) bool {
+	if host == "" {
+		return false
+	}
+	for _, known := range strings.Split(h.Config.RegistryHosts, ",") {
+		if known = strings.TrimSpace(known); known != "" && strings.EqualFold(known, host) {
+			return true
+		}
+	}
+	return strings.EqualFold(host, r.Host)
+}
+
+// imageVerdict is the policy outcome for one deployed image reference.
+type imageVerdict struct {
+	Image           string               `json:"image"`
+	Repository      string               `json:"repository,omitempty"`
+	Reference       string               `json:"reference,omitempty"`
+	Digest          string               `json:"digest,omitempty"`
+	External        bool                 `json:"external"` // hosted elsewhere; not evaluated
+	Found           bool                 `json:"found"`
+	Allowed         bool                 `json:"allowed"`
+	Violations      []string             `json:"violations,omitempty"`
+	Warnings        []string             `json:"warnings,omitempty"`
+	Environment     string               `json:"environment,omitempty"`
+	Scanned         bool                 `json:"scanned"`
+	Vulnerabilities *scanner.ScanSummary `json:"vulnerabilities,omitempty"`
+	Signed          bool                 `json:"signed"`
+	Signatures      []cosign.Identity    `json:"signatures,omitempty"`
+	HealthGrade     string               `json:"healthGrade,omitempty"`
+	HealthScore     *int                 `json:"healthScore,omitempty"`
+}
+
+// evaluateImage resolves image against this registry and runs it through the
+// policy. env overrides the repository's namespace environment when set.
+// Policy evaluation errors fail open, as on pull.
+func (h *DashboardHandler) evaluateImage(r *http.Request, image, env, user string) imageVerdict {
+	v := imageVerdict{Image: image, Allowed: true}
+	ref := parseImageRef(image)
+	if !h.isLocalRegistry(r, ref.Host) {
+		v.External = true
+		return v
+	}
+	ctx := r.Context()
+
+	v.Repository = ref.Repository
+	v.Reference = ref.Digest
+	if v.Reference == "" {
+		v.Reference = ref.Tag
+	}
+	manifestID, err := h.Metadata.GetManifestID(ctx, ref.Repository, v.Reference)
+	if err != nil {
+		v.Allowed = false
+		v.Violations = []string{"image not found in registry"}
+		return v
+	}
+	v.Found = true
+
+	v.Digest, err = h.Metadata.GetDigest(ctx, manifestID)
+	if err != nil {
+		log.Printf("Failed to resolve digest for %s: %v\n", image, err)
+	} else if ref.Digest == "" {
+		v.Warnings = append(v.Warnings, fmt.Sprintf("%s resolves to %s; pin the digest so the checked image is the one that runs", image, v.Digest))
+	}
+
+	v.Environment = env
+	if v.Environment == "" {
+		v.Environment = h.Config.PolicyEnvironment
+		if nsEnv, err := h.Metadata.GetRepositoryEnvironment(ctx, ref.Repository); err == nil && nsEnv != "" {
+			v.Environment = nsEnv
+		}
+	}
+
+	input := policy.EvaluationInput{
+		Repository:  ref.Repository,
+		Tag:         v.Reference,
+		User:        user,
+		Environment: v.Environment,
+	}
+	if summary, err := h.Scanner.GetVulnerabilitySummary(ctx, manifestID); err == nil {
+		v.Scanned = true
+		v.Vulnerabilities = summary
+		input.Vulnerabilities = policy.VulnerabilitySummary{Critical: summary.Critical, High: summary.High}
+	} else {
+		v.Warnings = append(v.Warnings, fmt.Sprintf("%s has not been scanned", image))
+	}
+	if v.Digest != "" {
+		v.Signed, v.Signatures = h.verifySignatures(ctx, ref.Repository, v.Digest)
+		input.IsSigned = v.Signed
+		input.Signatures = v.Signatures
+	}
+	if score, err := h.Metadata.GetHealthScore(ctx, manifestID); err == nil {
+		v.HealthGrade = score.Grade
+		v.HealthScore = &score.Overall
+		input.HealthScore = score.Overall
+		input.HealthGrade = score.Grade
+	}
+	if findings, ok, err := h.Metadata.GetLintFindings(ctx, manifestID); err == nil && ok {
+		input.Lint = findings
+	}
+
+	allowed, violations, err := h.Policy.Evaluate(ctx, input)
+	if err != nil {
+		log.Printf("Policy eval error for %s: %v\n", image, err)
+		v.Warnings = append(v.Warnings, fmt.Sprintf("policy evaluation failed for %s, allowing", image))
+		return v
+	}
+	v.Allowed = allowed
+	if !allowed {
+		v.Violations = violations
+		if len(v.Violations) == 0 {
+			v.Violations = []string{"denied by policy"}
+		}
+	}
+	return v
+}
+
+// verifySignatures reports whether digest is signed, with the identities of
+// the cosign signatures that verified. Without a verifier only the
+// signature tag is looked for.
+func (h *DashboardHandler) verifySignatures(ctx context.Context, repoName, digest string) (bool, []cosign.Identity) {
+	if h.Signatures == nil {
+		signed, _ := h.Metadata.HasSignature(ctx, repoName, digest)
+		return signed, nil
+	}
+	res, err := h.Signatures.Verify(ctx, repoName, digest)
+	if err != nil {
+		log.Printf("Failed to verify signatures of %s@%s: %v\n", repoName, digest, err)
+		return false, nil
+	}
+	return res.Signed, res.Verified
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/importer"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// CreateImport starts copying repositories from another registry in the
+// background.
+// POST /api/v1/imports {"sourceUrl":"https://harbor.example.com","username":"robot$ci","password":"...",
+// "targetNamespace":"mirror","repositories":[{"source":"library/nginx","tags":["1.25"]}]}
+func (h *DashboardHandler) CreateImport(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var req importer.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	imp, err := h.Imports.Create(r.Context(), req, uid)
+	if err != nil {
+		writeImportError(w, err)
+		return
+	}
+
+	if uid != uuid.Nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "REGISTRY_IMPORT_CREATE", nil, map[string]interface{}{"import": imp.ID, "source": imp.SourceURL, "repositories": imp.Repositories})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(imp)
+}
+
+// ListImports returns recent imports with their progress.
+// GET /api/v1/imports?limit=50
+func (h *DashboardHandler) ListImports(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	imports, err := h.Imports.List(r.Context(), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"imports": imports})
+}
+
+// GetImport returns an import with the progress of each repository.
+// GET /api/v1/imports/{id}
+func (h *DashboardHandler) GetImport(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.importID(w, r)
+	if !ok {
+		return
+	}
+	imp, err := h.Imports.Get(r.Context(), id)
+	if err != nil {
+		writeImportError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imp)
+}
+
+// CancelImport stops a running import; what was copied stays.
+// POST /api/v1/imports/{id}/cancel
+func (h *DashboardHandler) CancelImport(w http.ResponseWriter, r *http.Request) {
+	h.changeImport(w, r, "REGISTRY_IMPORT_CANCEL", h.Imports.Cancel)
+}
+
+// RetryImport queues the failed repositories of an import again. Each
+// resumes after the last tag it copied.
+// POST /api/v1/imports/{id}/retry
+func (h *DashboardHandler) RetryImport(w http.ResponseWriter, r *http.Request) {
+	h.changeImport(w, r, "REGISTRY_IMPORT_RETRY", h.Imports.Retry)
+}
+
+// DeleteImport removes a finished import and its stored credentials.
+// DELETE /api/v1/imports/{id}
+func (h *DashboardHandler) DeleteImport(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.importID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Imports.Delete(r.Context(), id); err != nil {
+		writeImportError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *DashboardHandler) changeImport(w http.ResponseWriter, r *http.Request, action string, change func(ctx context.Context, id uuid.UUID) error) {
+	id, ok := h.importID(w, r)
+	if !ok {
+		return
+	}
+	if err := change(r.Context(), id); err != nil {
+		writeImportError(w, err)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, action, nil, map[string]interface{}{"import": id})
+	}
+
+	imp, err := h.Imports.Get(r.Context(), id)
+	if err != nil {
+		writeImportError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imp)
+}
+
+// maxImageArchiveUpload bounds an uploaded image tarball.
+const maxImageArchiveUpload = 20 << 30
+
+// ImportImageArchive loads the images of an uploaded OCI image layout or
+// `docker save` tarball (optionally gzip-compressed) into a repository, for
+// air-gapped installs where docker push isn't possible. They are pushed as
+// the caller, so they are scanned like pushed images. tag names the image of
+// a single-image archive; otherwise the archive's tags are used.
+// POST /api/v1/repositories/{name}/import?tag=v1.0 (body: the tarball)
+func (h *DashboardHandler) ImportImageArchive(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleWrite) {
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	res, err := h.Imports.ImportArchive(r.Context(), http.MaxBytesReader(w, r.Body, maxImageArchiveUpload), name, r.URL.Query().Get("tag"), uid)
+	if errors.Is(err, importer.ErrInvalidArchive) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeImportError(w, err)
+		return
+	}
+
+	if uid != uuid.Nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "IMAGE_ARCHIVE_IMPORT", nil, map[string]interface{}{"repository": name, "images": res.Images, "bytes": res.BytesStored})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// importID checks the caller is an admin and parses the import ID.
+func (h *DashboardHandler) importID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid import ID", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeImportError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, importer.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, importer.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/integrity"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// GetIntegrity summarizes blob integrity checking: blobs by the result of
+// their last check, how many were never checked, and this instance's last run.
+// GET /api/v1/system/integrity
+func (h *DashboardHandler) GetIntegrity(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	summary, err := h.Integrity.Summarize(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// ListCorruptBlobs lists the blobs whose last check found them corrupt or
+// missing, with the repositories using them.
+// GET /api/v1/system/integrity/corrupt
+func (h *DashboardHandler) ListCorruptBlobs(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	blobs, err := h.Integrity.Corrupted(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": blobs})
+}
+
+// VerifyBlobs starts a check in the background: a sample, or every blob
+// with ?full=true. Follow it with GetIntegrity.
+// POST /api/v1/system/integrity/verify?full=true
+func (h *DashboardHandler) VerifyBlobs(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	full := r.URL.Query().Get("full") == "true"
+
+	if err := h.Integrity.Start(full); errors.Is(err, integrity.ErrRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "INTEGRITY_CHECK", nil, map[string]interface{}{"full": full})
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// maxInventoryImages bounds one compliance sweep request.
+const maxInventoryImages = 5000
+
+type inventoryRequest struct {
+	Cluster     string   `json:"cluster,omitempty"`
+	Environment string   `json:"environment,omitempty"` // overrides namespace environments when set
+	Images      []string `json:"images"`
+}
+
+type inventorySummary struct {
+	Total     int `json:"total"`
+	Allowed   int `json:"allowed"`
+	Denied    int `json:"denied"`
+	NotFound  int `json:"notFound"`
+	External  int `json:"external"`
+	Unscanned int `json:"unscanned"`
+}
+
+type inventoryResponse struct {
+	Cluster string           `json:"cluster,omitempty"`
+	Summary inventorySummary `json:"summary"`
+	Results []imageVerdict   `json:"results"`
+}
+
+// EvaluateInventory returns policy verdicts, vulnerability summaries and
+// health grades for every image a cluster reports as deployed, for periodic
+// compliance sweeps. Unlike the admission webhook it never blocks anything.
+func (h *DashboardHandler) EvaluateInventory(w http.ResponseWriter, r *http.Request) {
+	role := r.Context().Value(middleware.RoleKey)
+	if role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var req inventoryRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<20)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Images) == 0 {
+		http.Error(w, "images is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Images) > maxInventoryImages {
+		http.Error(w, "Too many images in one request", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	user := "anonymous"
+	if uid, ok := r.Context().Value(middleware.UserKey).(string); ok && uid != "" {
+		user = uid
+	}
+
+	resp := inventoryResponse{Cluster: req.Cluster, Results: make([]imageVerdict, 0, len(req.Images))}
+	seen := make(map[string]bool)
+	for _, image := range req.Images {
+		if image == "" || seen[image] {
+			continue
+		}
+		seen[image] = true
+
+		v := h.evaluateImage(r, image, req.Environment, user)
+		resp.Results = append(resp.Results, v)
+
+		resp.Summary.Total++
+		switch {
+		case v.External:
+			resp.Summary.External++
+		case !v.Found:
+			resp.Summary.NotFound++
+		case v.Allowed:
+			resp.Summary.Allowed++
+		default:
+			resp.Summary.Denied++
+		}
+		if v.Found && !v.Scanned {
+			resp.Summary.Unscanned++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
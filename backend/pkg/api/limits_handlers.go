@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// GetRepositoryLimits returns a repository's size and tag limits and usage.
+// GET /api/v1/repositories/{name}/limits
+func (h *DashboardHandler) GetRepositoryLimits(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleRead) {
+		return
+	}
+
+	limits, err := h.Metadata.GetRepositoryLimits(r.Context(), name)
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(limits)
+}
+
+// UpdateRepositoryLimits sets a repository's own size and tag limits. A null
+// or missing value inherits the namespace or global default; 0 is unlimited.
+// Admin only, like namespace quotas.
+// PUT /api/v1/repositories/{name}/limits {"maxSizeBytes":1073741824,"maxTags":50}
+func (h *DashboardHandler) UpdateRepositoryLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	var req struct {
+		MaxSizeBytes *int64 `json:"maxSizeBytes"`
+		MaxTags      *int   `json:"maxTags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if (req.MaxSizeBytes != nil && *req.MaxSizeBytes < 0) || (req.MaxTags != nil && *req.MaxTags < 0) {
+		http.Error(w, "Limits must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Metadata.SetRepositoryLimits(r.Context(), name, req.MaxSizeBytes, req.MaxTags); err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "REPOSITORY_LIMITS_UPDATE", nil, map[string]interface{}{"repository": name, "maxSizeBytes": req.MaxSizeBytes, "maxTags": req.MaxTags})
+	}
+
+	h.GetRepositoryLimits(w, r)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/auth"
+	"github.com/registryx/registryx/backend/pkg/ipallow"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// decodeCIDRs reads {"cidrs": [...]} from the request body.
+func decodeCIDRs(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var req struct {
+		CIDRs []string `json:"cidrs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	cidrs, err := ipallow.Normalize(req.CIDRs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return cidrs, true
+}
+
+// GetNamespaceNetworks returns the networks pushes and pulls of a namespace
+// may come from; an empty list allows any address.
+// GET /api/v1/namespaces/{name}/allowed-networks
+func (h *DashboardHandler) GetNamespaceNetworks(w http.ResponseWriter, r *http.Request) {
+	nsName := mux.Vars(r)["name"]
+
+	cidrs, err := h.Networks.GetNamespace(r.Context(), nsName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"namespace": nsName, "cidrs": cidrs})
+}
+
+// UpdateNamespaceNetworks replaces the networks of a namespace. Admin only,
+// since a mistake locks everyone out of the namespace.
+// PUT /api/v1/namespaces/{name}/allowed-networks
+// {"cidrs":["10.20.0.0/16","203.0.113.7"]}
+func (h *DashboardHandler) UpdateNamespaceNetworks(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	nsName := mux.Vars(r)["name"]
+	cidrs, ok := decodeCIDRs(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.Networks.SetNamespace(r.Context(), nsName, cidrs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "NAMESPACE_NETWORKS_UPDATE", nil, map[string]interface{}{"namespace": nsName, "cidrs": cidrs})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"namespace": nsName, "cidrs": cidrs})
+}
+
+// UpdateServiceAccountNetworks replaces the networks a service account may
+// be used from, e.g. its CI runners; an empty list allows any address.
+// PUT /api/v1/service-accounts/{id}/allowed-networks
+// {"cidrs":["10.20.0.0/16"]}
+func (h *DashboardHandler) UpdateServiceAccountNetworks(w http.ResponseWriter, r *http.Request) {
+	// Service accounts can be unscoped, so only admins manage them
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	cidrs, ok := decodeCIDRs(w, r)
+	if !ok {
+		return
+	}
+
+	acc, err := h.Auth.SetAllowedNetworks(r.Context(), id, cidrs)
+	if errors.Is(err, auth.ErrServiceAccountNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "SERVICE_ACCOUNT_NETWORKS_UPDATE", nil, map[string]interface{}{"serviceAccount": acc.Name, "cidrs": cidrs})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(acc)
+}
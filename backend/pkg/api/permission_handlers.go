@@ -0,0 +1,109 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// ListRepositoryPermissions returns the access list of a repository.
+// GET /api/v1/repositories/{name}/permissions
+func (h *DashboardHandler) ListRepositoryPermissions(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+
+	grants, err := h.Authz.ListGrants(r.Context(), name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"repository": name, "permissions": grants})
+}
+
+// SetRepositoryPermission grants a user read, write or admin access to a
+// repository, replacing any role they already had.
+// PUT /api/v1/repositories/{name}/permissions {"user":"alice","role":"write"}
+func (h *DashboardHandler) SetRepositoryPermission(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+
+	var req struct {
+		User string `json:"user"`
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.User) == "" {
+		http.Error(w, "Invalid request body: user and role are required", http.StatusBadRequest)
+		return
+	}
+	role, ok := authz.ParseRole(req.Role)
+	if !ok {
+		http.Error(w, "Invalid role: use read, write or admin", http.StatusBadRequest)
+		return
+	}
+	principalID, err := h.Auth.LookupUserID(r.Context(), strings.TrimSpace(req.User))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	if err := h.Authz.SetGrant(r.Context(), name, authz.PrincipalUser, principalID, role, uid); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Repository not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if uid != uuid.Nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "REPOSITORY_GRANT", nil, map[string]interface{}{"repository": name, "user": req.User, "role": role})
+	}
+
+	h.ListRepositoryPermissions(w, r)
+}
+
+// DeleteRepositoryPermission revokes a user's grant on a repository.
+// Namespace owners keep access through ownership.
+// DELETE /api/v1/repositories/{name}/permissions/{user}
+func (h *DashboardHandler) DeleteRepositoryPermission(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+
+	principalID, err := h.Auth.LookupUserID(r.Context(), vars["user"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := h.Authz.RemoveGrant(r.Context(), name, authz.PrincipalUser, principalID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Permission not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "REPOSITORY_REVOKE", nil, map[string]interface{}{"repository": name, "user": vars["user"]})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
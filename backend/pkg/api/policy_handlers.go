@@ -0,0 +1,227 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+	"github.com/registryx/registryx/backend/pkg/policy"
+)
+
+// canManageNamespacePolicy reports whether the caller owns the namespace,
+// administers it through a team or is an admin, writing the error response
+// if not.
+func (h *DashboardHandler) canManageNamespacePolicy(w http.ResponseWriter, r *http.Request, nsName string) bool {
+	if r.Context().Value(middleware.RoleKey) == "admin" {
+		return true
+	}
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	owner, err := h.Policy.IsOwner(r.Context(), nsName, userID)
+	if errors.Is(err, policy.ErrNotFound) {
+		http.Error(w, "namespace not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !owner && !h.namespaceAdmin(r, nsName) {
+		http.Error(w, "Forbidden: namespace owner or admin access required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ListNamespacePolicies returns the default policy fragments of a namespace.
+// GET /api/v1/namespaces/{name}/policies
+func (h *DashboardHandler) ListNamespacePolicies(w http.ResponseWriter, r *http.Request) {
+	frags, err := h.Policy.Fragments(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": frags})
+}
+
+// SetNamespacePolicy creates or replaces the fragment of a namespace at the
+// path in the body ("" for the whole namespace). Namespace owners and
+// admins only; only admins may add raw Rego.
+// PUT /api/v1/namespaces/{name}/policies
+func (h *DashboardHandler) SetNamespacePolicy(w http.ResponseWriter, r *http.Request) {
+	nsName := mux.Vars(r)["name"]
+	if !h.canManageNamespacePolicy(w, r, nsName) {
+		return
+	}
+
+	var frag policy.Fragment
+	if err := json.NewDecoder(r.Body).Decode(&frag); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	frag.Namespace = nsName
+	if frag.Rego != "" && r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: only admins may add Rego to namespace policies", http.StatusForbidden)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	err := h.Policy.SetFragment(r.Context(), &frag, userID)
+	if errors.Is(err, policy.ErrNotFound) {
+		http.Error(w, "namespace not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "NAMESPACE_POLICY_SET", nil, map[string]interface{}{"namespace": nsName, "path": frag.Path})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteNamespacePolicy removes the fragment of a namespace at a path.
+// Namespace owners and admins only.
+// DELETE /api/v1/namespaces/{name}/policies?path=internal
+func (h *DashboardHandler) DeleteNamespacePolicy(w http.ResponseWriter, r *http.Request) {
+	nsName := mux.Vars(r)["name"]
+	if !h.canManageNamespacePolicy(w, r, nsName) {
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	err := h.Policy.DeleteFragment(r.Context(), nsName, path)
+	if errors.Is(err, policy.ErrNotFound) {
+		http.Error(w, "namespace policy not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "NAMESPACE_POLICY_DELETE", nil, map[string]interface{}{"namespace": nsName, "path": path})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetEffectivePolicy previews the policy a repository is evaluated against:
+// the global policy and the namespace fragments covering it.
+// GET /api/v1/policy/effective?repository=payments/api
+func (h *DashboardHandler) GetEffectivePolicy(w http.ResponseWriter, r *http.Request) {
+	repository := r.URL.Query().Get("repository")
+	if repository == "" {
+		http.Error(w, "repository is required", http.StatusBadRequest)
+		return
+	}
+
+	effective, err := h.Policy.Effective(r.Context(), repository)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(effective)
+}
+
+// maxPolicySize bounds candidate policies sent for testing.
+const maxPolicySize = 1 << 20
+
+// ListPolicyTests returns the saved policy test cases.
+// GET /api/v1/policy/tests
+func (h *DashboardHandler) ListPolicyTests(w http.ResponseWriter, r *http.Request) {
+	cases, err := h.Policy.TestCases(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": cases})
+}
+
+// SavePolicyTest saves a policy test case, replacing the one with the same
+// name. Admin only.
+// POST /api/v1/policy/tests
+func (h *DashboardHandler) SavePolicyTest(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var tc policy.TestCase
+	if err := json.NewDecoder(r.Body).Decode(&tc); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if err := h.Policy.SaveTestCase(r.Context(), &tc, userID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tc)
+}
+
+// DeletePolicyTest removes a saved policy test case. Admin only.
+// DELETE /api/v1/policy/tests/{id}
+func (h *DashboardHandler) DeletePolicyTest(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	err := h.Policy.DeleteTestCase(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, policy.ErrNotFound) {
+		http.Error(w, "test case not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TestPolicy runs the candidate Rego policy in the body, or the current
+// policy if the body is empty, against the saved test cases without
+// applying it. The report's "passed" is false if any case failed. Admin only.
+// POST /api/v1/policy/test
+func (h *DashboardHandler) TestPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPolicySize+1))
+	if err != nil {
+		http.Error(w, "Read failed", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxPolicySize {
+		http.Error(w, "Policy too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	cases, err := h.Policy.TestCases(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report, err := h.Policy.RunTests(r.Context(), string(body), cases)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
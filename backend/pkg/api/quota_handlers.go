@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+	"github.com/registryx/registryx/backend/pkg/quota"
+)
+
+// GetNamespaceUsage returns a namespace's storage use, the percentage of its
+// quota that is, and the highest quota threshold its owner was alerted about.
+// Namespace owners and admins only.
+// GET /api/v1/namespaces/{name}/usage
+func (h *DashboardHandler) GetNamespaceUsage(w http.ResponseWriter, r *http.Request) {
+	nsName := mux.Vars(r)["name"]
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		userID, _ := r.Context().Value(middleware.UserKey).(string)
+		owner, err := h.Quota.IsOwner(r.Context(), nsName, userID)
+		if errors.Is(err, quota.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !owner && !h.namespaceAdmin(r, nsName) {
+			http.Error(w, "Forbidden: namespace owner or admin access required", http.StatusForbidden)
+			return
+		}
+	}
+
+	usage, err := h.Quota.Usage(r.Context(), nsName)
+	if errors.Is(err, quota.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// slidingWindowLimiter allows up to limit events per key within window.
+type slidingWindowLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+func newSlidingWindowLimiter(limit int, window time.Duration) *slidingWindowLimiter {
+	return &slidingWindowLimiter{limit: limit, window: window, events: make(map[string][]time.Time)}
+}
+
+// Allow records an event for key if it is under the limit. When it is not, it
+// returns how long until the oldest event leaves the window.
+func (l *slidingWindowLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.limit <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+	cutoff := now.Add(-l.window)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	recent := l.events[key][:0]
+	for _, t := range l.events[key] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.events[key] = recent
+		return false, recent[0].Sub(cutoff)
+	}
+	l.events[key] = append(recent, now)
+
+	// Drop idle keys now and then so the map doesn't grow without bound.
+	if len(l.events) > 1024 {
+		for k, ts := range l.events {
+			if len(ts) == 0 || !ts[len(ts)-1].After(cutoff) {
+				delete(l.events, k)
+			}
+		}
+	}
+	return true, 0
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/georeplica"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// ListReplicas reports the storage replicas and their last sync.
+// GET /api/v1/system/replicas
+func (h *DashboardHandler) ListReplicas(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	replicas := []georeplica.Status{}
+	if h.Replicas != nil {
+		replicas = h.Replicas.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"primaryRegion": h.Config.StorageRegion,
+		"redirect":      h.Config.BlobRedirect,
+		"data":          replicas,
+	})
+}
+
+// SyncReplicas starts a sync pass in the background.
+// POST /api/v1/system/replicas/sync
+func (h *DashboardHandler) SyncReplicas(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	if h.Replicas == nil {
+		http.Error(w, "No storage replicas configured", http.StatusNotFound)
+		return
+	}
+
+	go h.Replicas.SyncAll(context.Background())
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "REPLICA_SYNC", nil, nil)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+	"github.com/registryx/registryx/backend/pkg/replication"
+)
+
+// GetReplicationStatus reports the replication queue, each target with its
+// rules and the state of its tasks, and the most recent tasks.
+// GET /api/v1/replication
+func (h *DashboardHandler) GetReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.requireReplication(w, r) {
+		return
+	}
+	status, err := h.Replication.Status(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// CreateReplicationTarget adds a registry to replicate to, with its rules.
+// POST /api/v1/replication/targets {"name":"dr-west","url":"https://registry-west.example.com","username":"robot","password":"...",
+// "rules":[{"repository":"team/*","tags":"v*"}]}
+func (h *DashboardHandler) CreateReplicationTarget(w http.ResponseWriter, r *http.Request) {
+	if !h.requireReplication(w, r) {
+		return
+	}
+	var req replication.TargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	target, err := h.Replication.CreateTarget(r.Context(), req)
+	if err != nil {
+		writeReplicationError(w, err)
+		return
+	}
+	h.auditReplication(r, "REPLICATION_TARGET_CREATE", map[string]interface{}{"target": target.Name, "url": target.URL})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(target)
+}
+
+// UpdateReplicationTarget pauses or resumes a target.
+// PATCH /api/v1/replication/targets/{id} {"enabled":false}
+func (h *DashboardHandler) UpdateReplicationTarget(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.replicationID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := h.Replication.SetTargetEnabled(r.Context(), id, *req.Enabled); err != nil {
+		writeReplicationError(w, err)
+		return
+	}
+	h.auditReplication(r, "REPLICATION_TARGET_UPDATE", map[string]interface{}{"target": id, "enabled": *req.Enabled})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteReplicationTarget removes a target with its rules and tasks. What
+// was replicated stays on the target.
+// DELETE /api/v1/replication/targets/{id}
+func (h *DashboardHandler) DeleteReplicationTarget(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.replicationID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Replication.DeleteTarget(r.Context(), id); err != nil {
+		writeReplicationError(w, err)
+		return
+	}
+	h.auditReplication(r, "REPLICATION_TARGET_DELETE", map[string]interface{}{"target": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateReplicationRule adds a rule to a target.
+// POST /api/v1/replication/targets/{id}/rules {"repository":"library/*","tags":"*","remotePrefix":"mirror"}
+func (h *DashboardHandler) CreateReplicationRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.replicationID(w, r)
+	if !ok {
+		return
+	}
+	var req replication.RuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	rule, err := h.Replication.AddRule(r.Context(), id, req)
+	if err != nil {
+		writeReplicationError(w, err)
+		return
+	}
+	h.auditReplication(r, "REPLICATION_RULE_CREATE", map[string]interface{}{"target": id, "repository": rule.RepositoryPattern, "tags": rule.TagPattern})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteReplicationRule removes a rule.
+// DELETE /api/v1/replication/rules/{id}
+func (h *DashboardHandler) DeleteReplicationRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.replicationID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Replication.DeleteRule(r.Context(), id); err != nil {
+		writeReplicationError(w, err)
+		return
+	}
+	h.auditReplication(r, "REPLICATION_RULE_DELETE", map[string]interface{}{"rule": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListReplicationTasks returns recent tasks, newest first.
+// GET /api/v1/replication/tasks?target=<id>&status=failed&limit=50
+func (h *DashboardHandler) ListReplicationTasks(w http.ResponseWriter, r *http.Request) {
+	if !h.requireReplication(w, r) {
+		return
+	}
+	var targetID *uuid.UUID
+	if v := r.URL.Query().Get("target"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, "Invalid target ID", http.StatusBadRequest)
+			return
+		}
+		targetID = &id
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", replication.StatusPending, replication.StatusRunning, replication.StatusSucceeded, replication.StatusFailed:
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+
+	tasks, err := h.Replication.Tasks(r.Context(), targetID, status, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": tasks})
+}
+
+// RetryReplicationTask queues a failed task again.
+// POST /api/v1/replication/tasks/{id}/retry
+func (h *DashboardHandler) RetryReplicationTask(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.replicationID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Replication.Retry(r.Context(), id); err != nil {
+		writeReplicationError(w, err)
+		return
+	}
+	h.auditReplication(r, "REPLICATION_TASK_RETRY", map[string]interface{}{"task": id})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// requireReplication checks the caller is an admin and replication is
+// available (it needs Redis).
+func (h *DashboardHandler) requireReplication(w http.ResponseWriter, r *http.Request) bool {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return false
+	}
+	if h.Replication == nil {
+		http.Error(w, "Replication requires Redis", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// replicationID checks the caller may manage replication and parses the ID
+// of a target, rule or task.
+func (h *DashboardHandler) replicationID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	if !h.requireReplication(w, r) {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func (h *DashboardHandler) auditReplication(r *http.Request, action string, details map[string]interface{}) {
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, action, nil, details)
+	}
+}
+
+func writeReplicationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, replication.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, replication.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
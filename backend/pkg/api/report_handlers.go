@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// GetWeeklyReport returns the weekly report on the caller's namespaces as it
+// would be emailed now.
+// GET /api/v1/reports/weekly
+func (h *DashboardHandler) GetWeeklyReport(w http.ResponseWriter, r *http.Request) {
+	userIDStr, _ := r.Context().Value(middleware.UserKey).(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	report, err := h.Reports.Build(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetReportPreferences returns whether the caller gets the weekly report.
+// GET /api/v1/reports/preferences
+func (h *DashboardHandler) GetReportPreferences(w http.ResponseWriter, r *http.Request) {
+	userIDStr, _ := r.Context().Value(middleware.UserKey).(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	on, err := h.Reports.Subscribed(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"weeklyReport": on})
+}
+
+// UpdateReportPreferences subscribes the caller to the weekly report or
+// unsubscribes them.
+// PUT /api/v1/reports/preferences {"weeklyReport":false}
+func (h *DashboardHandler) UpdateReportPreferences(w http.ResponseWriter, r *http.Request) {
+	userIDStr, _ := r.Context().Value(middleware.UserKey).(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		WeeklyReport *bool `json:"weeklyReport"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.WeeklyReport == nil {
+		http.Error(w, "weeklyReport is required", http.StatusBadRequest)
+		return
+	}
+	if err := h.Reports.SetSubscribed(r.Context(), userID, *req.WeeklyReport); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"weeklyReport": *req.WeeklyReport})
+}
+
+// UnsubscribeWeeklyReport is the unsubscribe link of the weekly report
+// email. The signed token stands in for a login.
+// GET /api/v1/reports/unsubscribe?user=<id>&token=<token>
+func (h *DashboardHandler) UnsubscribeWeeklyReport(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.URL.Query().Get("user"))
+	if err != nil || !h.Reports.ValidUnsubscribeToken(userID, r.URL.Query().Get("token")) {
+		http.Error(w, "Invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+	if err := h.Reports.SetSubscribed(r.Context(), userID, false); err != nil {
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, "<html><body><p>You will no longer receive the weekly registry report.</p></body></html>")
+}
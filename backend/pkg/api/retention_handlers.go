@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// GetRetentionPolicy returns a repository's retention policy and the
+// outcome of its last run.
+// GET /api/v1/repositories/{name}/retention
+func (h *DashboardHandler) GetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleRead) {
+		return
+	}
+
+	p, err := h.Metadata.GetRetentionPolicy(r.Context(), name)
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// SetRetentionPolicy creates or replaces a repository's retention policy.
+// PUT /api/v1/repositories/{name}/retention {"keepLast":10,"keepPattern":"^v\\d+","untaggedDays":7}
+func (h *DashboardHandler) SetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+
+	var req struct {
+		KeepLast     int    `json:"keepLast"`
+		KeepPattern  string `json:"keepPattern"`
+		UntaggedDays int    `json:"untaggedDays"`
+		Enabled      *bool  `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.KeepLast < 0 || req.UntaggedDays < 0 {
+		http.Error(w, "keepLast and untaggedDays must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.KeepLast == 0 && req.KeepPattern == "" && req.UntaggedDays == 0 {
+		http.Error(w, "Specify keepLast, keepPattern or untaggedDays", http.StatusBadRequest)
+		return
+	}
+	if _, err := regexp.Compile(req.KeepPattern); err != nil {
+		http.Error(w, "Invalid keepPattern: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+
+	p, err := h.Metadata.SetRetentionPolicy(r.Context(), name, metadata.RetentionPolicy{
+		KeepLast:     req.KeepLast,
+		KeepPattern:  req.KeepPattern,
+		UntaggedDays: req.UntaggedDays,
+		Enabled:      enabled,
+	})
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "RETENTION_POLICY_SET", nil, map[string]interface{}{
+			"repository": name, "keepLast": p.KeepLast, "keepPattern": p.KeepPattern, "untaggedDays": p.UntaggedDays, "enabled": p.Enabled,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// DeleteRetentionPolicy removes a repository's retention policy.
+// DELETE /api/v1/repositories/{name}/retention
+func (h *DashboardHandler) DeleteRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+
+	if err := h.Metadata.DeleteRetentionPolicy(r.Context(), name); err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "RETENTION_POLICY_DELETE", nil, map[string]interface{}{"repository": name})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunRetentionPolicy enforces a repository's retention policy now, or with
+// ?dryRun=true lists what it would delete.
+// POST /api/v1/repositories/{name}/retention/run?dryRun=true
+func (h *DashboardHandler) RunRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	role := authz.RoleAdmin
+	if dryRun {
+		role = authz.RoleRead
+	}
+	if !h.Authz.Require(w, r, name, role) {
+		return
+	}
+
+	p, err := h.Metadata.GetRetentionPolicy(r.Context(), name)
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+	res, err := h.Retention.Enforce(r.Context(), p, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil && !dryRun {
+		_ = h.Audit.Log(r.Context(), uid, "RETENTION_RUN", nil, map[string]interface{}{
+			"repository": name, "deletedTags": len(res.Tags), "deletedManifests": len(res.Manifests),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/auth"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// UpdateServiceAccountScopes replaces the repositories and actions a service
+// account is limited to; an empty list lets it use every repository again.
+// Its outstanding registry tokens are revoked.
+// PUT /api/v1/service-accounts/{id}/scopes
+// {"scopes":[{"repository":"acme/*","actions":["pull"]},{"repository":"acme/api","actions":["pull","push"]}]}
+func (h *DashboardHandler) UpdateServiceAccountScopes(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Scopes []auth.RepositoryScope `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	scopes, err := auth.NormalizeScopes(req.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	acc, err := h.Auth.SetScopes(r.Context(), id, scopes)
+	if errors.Is(err, auth.ErrServiceAccountNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "SERVICE_ACCOUNT_SCOPES_UPDATE", nil, map[string]interface{}{"serviceAccount": acc.Name, "scopes": scopes})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(acc)
+}
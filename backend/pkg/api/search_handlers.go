@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/scanner"
+)
+
+// SearchPackages finds the images whose latest scan found a package, or a
+// package affected by a CVE, from the stored scan results. version matches
+// exactly, or as a prefix when it ends in "*". Non-admins see the
+// repositories they can read.
+// GET /api/v1/search/packages?name=openssl&version=1.1.1*&cve=CVE-2023-0286&limit=100
+func (h *DashboardHandler) SearchPackages(w http.ResponseWriter, r *http.Request) {
+	q := scanner.PackageQuery{
+		Name:          strings.TrimSpace(r.URL.Query().Get("name")),
+		Version:       strings.TrimSpace(r.URL.Query().Get("version")),
+		Vulnerability: strings.TrimSpace(r.URL.Query().Get("cve")),
+	}
+	if q.Name == "" && q.Vulnerability == "" {
+		http.Error(w, "name or cve is required", http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if n > 1000 {
+			n = 1000
+		}
+		limit = n
+	}
+
+	subject := authz.SubjectFromContext(r.Context())
+	canRead := func(repo string) bool {
+		ok, _ := h.Authz.Allowed(r.Context(), subject, repo, authz.RoleRead)
+		return ok
+	}
+	matches, err := h.Scanner.SearchPackages(r.Context(), q, canRead, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": matches})
+}
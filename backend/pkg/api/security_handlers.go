@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/anomaly"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// ListSecurityAlerts returns the alerts raised by audit anomaly detection,
+// newest first.
+// GET /api/v1/security/alerts?unacknowledged=true&limit=100
+func (h *DashboardHandler) ListSecurityAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	if h.Security == nil {
+		http.Error(w, "Security alerts unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	list, err := h.Security.ListAlerts(r.Context(), r.URL.Query().Get("unacknowledged") == "true", limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"alerts": list})
+}
+
+// AcknowledgeSecurityAlert marks a security alert as handled.
+// POST /api/v1/security/alerts/{id}/acknowledge
+func (h *DashboardHandler) AcknowledgeSecurityAlert(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	if h.Security == nil {
+		http.Error(w, "Security alerts unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid alert ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID) // uuid.Nil for service accounts
+	if err := h.Security.Acknowledge(r.Context(), id, uid); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, anomaly.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if uid != uuid.Nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "SECURITY_ALERT_ACKNOWLEDGE", nil, map[string]interface{}{"alert": id})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListSecurityEvents returns failed sign-ins and invalid token attempts,
+// newest first.
+// GET /api/v1/security/events?kind=login_failed&username=&ip=&since=2024-01-01T00:00:00Z&limit=100
+func (h *DashboardHandler) ListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	if h.Security == nil {
+		http.Error(w, "Security events unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := anomaly.EventFilter{Kind: q.Get("kind"), Username: q.Get("username"), IP: q.Get("ip")}
+	filter.Limit, _ = strconv.Atoi(q.Get("limit"))
+	if since := q.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "Invalid since, want RFC 3339", http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+
+	events, err := h.Security.ListEvents(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"events": events})
+}
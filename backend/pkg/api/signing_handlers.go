@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// ListSigningKeys returns the token signing keys that are still valid. Secrets
+// are never included.
+// GET /api/v1/system/signing-keys
+func (h *DashboardHandler) ListSigningKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	if h.Auth.Keys == nil {
+		http.Error(w, "Signing keys not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": h.Auth.Keys.Keys()})
+}
+
+// RotateSigningKey makes a freshly generated key the primary. Tokens signed
+// by earlier keys keep working until they expire.
+// POST /api/v1/system/signing-keys/rotate
+func (h *DashboardHandler) RotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	if h.Auth.Keys == nil {
+		http.Error(w, "Signing keys not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	key, err := h.Auth.Keys.Rotate(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to rotate signing key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "SIGNING_KEY_ROTATE", nil, map[string]interface{}{"kid": key.ID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
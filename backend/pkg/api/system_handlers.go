@@ -1,15 +1,28 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"path"
+	"strconv"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/events"
+	"github.com/registryx/registryx/backend/pkg/gc"
+	"github.com/registryx/registryx/backend/pkg/maintenance"
+	"github.com/registryx/registryx/backend/pkg/metadata"
 	"github.com/registryx/registryx/backend/pkg/middleware"
+	"github.com/registryx/registryx/backend/pkg/recovery"
+	"github.com/registryx/registryx/backend/pkg/storage"
 )
 
+// maxGCErrors caps the per-blob errors returned in a GCReport.
+const maxGCErrors = 100
+
 type GCReport struct {
 	BlobsDeleted     int64   `json:"blobsDeleted"`
 	ManifestsDeleted int64   `json:"manifestsDeleted"`
@@ -27,80 +40,239 @@ func (h *DashboardHandler) GarbageCollect(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	start := time.Now()
-	report := &GCReport{}
-
 	// Check if this is a dry-run (preview mode)
 	dryRun := r.URL.Query().Get("dryRun") == "true"
 
+	userID, _ := user.(string)
+	report, err := h.CollectGarbage(r.Context(), dryRun, gc.Trigger{Kind: gc.TriggerManual, User: userID})
+	if errors.Is(err, gc.ErrRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// CollectGarbage deletes untagged manifests and the blobs no manifest
+// references any more, records the run and announces the result on behalf
+// of the trigger's user. A dry run only counts the blobs. Only one
+// collection runs at a time: another fails with gc.ErrRunning.
+func (h *DashboardHandler) CollectGarbage(ctx context.Context, dryRun bool, trigger gc.Trigger) (report *GCReport, err error) {
+	start := time.Now()
+	report = &GCReport{}
+
+	if !dryRun && h.GC != nil {
+		run, finish, err := h.GC.Begin(ctx, trigger)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if report != nil {
+				run.BlobsDeleted = report.BlobsDeleted
+				run.ManifestsDeleted = report.ManifestsDeleted
+				run.SpaceFreed = report.SpaceFreed
+				run.Errors = append(run.Errors, report.Errors...)
+			}
+			finish(err)
+		}()
+	}
+
+	var suppressedErrors int
+	addError := func(msg string) {
+		if len(report.Errors) < maxGCErrors {
+			report.Errors = append(report.Errors, msg)
+		} else {
+			suppressedErrors++
+		}
+	}
+
 	// 0. Delete Untagged Manifests (Step 4 Auto-Cleanup)
 	// Must be done BEFORE fetching orphans, as deleting manifests might orphan more blobs.
 	if !dryRun {
-		mCount, err := h.Metadata.DeleteUntaggedManifests(r.Context())
+		mCount, paths, err := h.Metadata.DeleteUntaggedManifests(ctx)
 		if err != nil {
-			report.Errors = append(report.Errors, fmt.Sprintf("Failed to cleanup manifests: %v", err))
+			addError(fmt.Sprintf("Failed to cleanup manifests: %v", err))
 		} else {
 			report.ManifestsDeleted = mCount
 			fmt.Printf("[GC] Deleted %d untagged manifests\n", mCount)
 		}
+		for _, err := range storage.DeleteAll(ctx, h.Storage, paths) {
+			addError(fmt.Sprintf("Failed to delete manifest from storage: %v", err))
+		}
+		if n, err := h.Metadata.DeleteStaleBlobLinks(ctx); err != nil {
+			addError(fmt.Sprintf("Failed to cleanup blob links: %v", err))
+		} else if n > 0 {
+			fmt.Printf("[GC] Deleted %d blob links of abandoned pushes\n", n)
+		}
 	}
 
-	// 1. Get Orphaned Blobs
-	orphans, err := h.Metadata.GetOrphanedBlobs(r.Context())
+	// 1. Walk orphaned blobs batch by batch (bounded memory on large registries)
+	var deletedCount int64
+	var deletedSize int64
+
+	err = h.Metadata.ForEachOrphanedBlobBatch(ctx, h.Config.GCBatchSize, func(batch []metadata.OrphanBlob) error {
+		for _, orphan := range batch {
+			// Dry-run: just count what would be deleted
+			if dryRun {
+				deletedCount++
+				deletedSize += orphan.Size
+				continue
+			}
+
+			// 1a. Delete from Storage (MinIO)
+			blobPath := path.Join("blobs", orphan.Digest)
+			
+			err := h.Storage.Delete(ctx, blobPath)
+			if err != nil {
+				addError(fmt.Sprintf("Failed to delete blob %s from storage: %v", orphan.Digest, err))
+				continue
+			}
+
+			// 1b. Delete from DB
+			err = h.Metadata.DeleteBlob(ctx, orphan.Digest)
+			if err != nil {
+				addError(fmt.Sprintf("Failed to delete blob %s from DB: %v", orphan.Digest, err))
+				continue
+			}
+
+			deletedCount++
+			deletedSize += orphan.Size
+		}
+		return nil
+	})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get orphaned blobs: %v", err), http.StatusInternalServerError)
+		if deletedCount == 0 {
+			return nil, fmt.Errorf("failed to get orphaned blobs: %w", err)
+		}
+		addError(fmt.Sprintf("Stopped early: %v", err))
+	}
+	if suppressedErrors > 0 {
+		report.Errors = append(report.Errors, fmt.Sprintf("... and %d more errors", suppressedErrors))
+	}
+
+	report.BlobsDeleted = deletedCount
+	report.SpaceFreed = deletedSize
+	report.SpaceFreedMB = fmt.Sprintf("%.2f MB", float64(deletedSize)/1024/1024)
+	report.Duration = time.Since(start).String()
+
+	// If dry-run, return preview without notifying anyone
+	if dryRun {
+		return report, nil
+	}
+
+	h.Events.Publish(events.Event{
+		Type: events.TypeGC,
+		User: trigger.User,
+		Data: map[string]interface{}{
+			"trigger":          trigger.Kind,
+			"blobsDeleted":     report.BlobsDeleted,
+			"manifestsDeleted": report.ManifestsDeleted,
+			"spaceFreedBytes":  report.SpaceFreed,
+			"errors":           len(report.Errors),
+		},
+	})
+	return report, nil
+}
+
+// GetGCHistory returns the most recent garbage collections, newest first.
+// GET /api/v1/system/gc/history?limit=50
+func (h *DashboardHandler) GetGCHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
 		return
 	}
+	limit := 50
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 500 {
+		limit = v
+	}
 
-	// Calculate totals
-	var totalSize int64
-	for _, orphan := range orphans {
-		totalSize += orphan.Size
+	runs, err := h.GC.History(r.Context(), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	report.BlobsDeleted = int64(len(orphans))
-	report.SpaceFreed = totalSize
-	report.SpaceFreedMB = fmt.Sprintf("%.2f MB", float64(totalSize)/1024/1024)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": runs})
+}
 
-	// If dry-run, return preview without deleting
-	if dryRun {
-		report.Duration = time.Since(start).String()
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(report)
+// CheckConsistency cross-checks the blob and manifest index against storage.
+// With ?repair=true it also fixes what can be fixed safely.
+func (h *DashboardHandler) CheckConsistency(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
 		return
 	}
+	repair := r.URL.Query().Get("repair") == "true"
 
-	// 2. Actually delete blobs
-	var deletedCount int64
-	var deletedSize int64
-	for _, orphan := range orphans {
-		// 2a. Delete from Storage (MinIO)
-		blobPath := path.Join("blobs", orphan.Digest)
-		
-		err := h.Storage.Delete(r.Context(), blobPath)
-		if err != nil {
-			report.Errors = append(report.Errors, fmt.Sprintf("Failed to delete blob %s from storage: %v", orphan.Digest, err))
-			continue
-		}
+	checker := &recovery.Checker{Metadata: h.Metadata, Storage: h.Storage}
+	report, err := checker.Run(r.Context(), repair)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Consistency check failed: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-		// 2b. Delete from DB
-		err = h.Metadata.DeleteBlob(r.Context(), orphan.Digest)
-		if err != nil {
-			report.Errors = append(report.Errors, fmt.Sprintf("Failed to delete blob %s from DB: %v", orphan.Digest, err))
-			continue
+	if repair && report.Repaired > 0 {
+		h.Metadata.MarkStatsDirty()
+		userID, _ := r.Context().Value(middleware.UserKey).(string)
+		if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+			_ = h.Audit.Log(r.Context(), uid, "FSCK_REPAIR", nil, map[string]interface{}{"repaired": report.Repaired, "counts": report.Counts})
 		}
+	}
 
-		deletedCount++
-		deletedSize += orphan.Size
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetMaintenance returns the read-only maintenance state.
+func (h *DashboardHandler) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Maintenance.Get(r.Context()))
+}
+
+// UpdateMaintenance turns read-only maintenance mode on or off.
+func (h *DashboardHandler) UpdateMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
 	}
 
-	report.BlobsDeleted = deletedCount
-	report.SpaceFreed = deletedSize
-	report.SpaceFreedMB = fmt.Sprintf("%.2f MB", float64(deletedSize)/1024/1024)
-	report.Duration = time.Since(start).String()
+	var req maintenance.State
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RetryAfterSeconds < 0 {
+		http.Error(w, "retryAfterSeconds must not be negative", http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	username, _ := r.Context().Value(middleware.UsernameKey).(string)
+	req.EnabledBy = username
+	req.Since = time.Time{}
+
+	if err := h.Maintenance.Set(r.Context(), req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update maintenance mode: %v", err), http.StatusInternalServerError)
+		return
+	}
+	state := h.Maintenance.Get(r.Context())
+
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "MAINTENANCE_MODE", nil, map[string]interface{}{"enabled": state.Enabled, "reason": state.Reason})
+	}
+	h.Events.Publish(events.Event{
+		Type: events.TypeMaintenance,
+		User: userID,
+		Data: map[string]interface{}{"enabled": state.Enabled, "reason": state.Reason},
+	})
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(report)
+	json.NewEncoder(w).Encode(state)
 }
 
 // HealthCheck returns the status of the service
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// ListTags returns a repository's tags with their digest and expiry.
+// GET /api/v1/repositories/{name}/tags
+func (h *DashboardHandler) ListTags(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleRead) {
+		return
+	}
+
+	tags, err := h.Metadata.ListTagDetails(r.Context(), name)
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "tags": tags})
+}
+
+// SetTagExpiry sets when a tag is deleted: at expiresAt, ttlSeconds from
+// now, or extendSeconds later than its current expiry.
+// PUT /api/v1/repositories/{name}/tags/{tag}/expiry {"ttlSeconds":604800}
+func (h *DashboardHandler) SetTagExpiry(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, tag := vars["name"], vars["tag"]
+	if !h.Authz.Require(w, r, name, authz.RoleWrite) {
+		return
+	}
+
+	var req struct {
+		ExpiresAt     *time.Time `json:"expiresAt"`
+		TTLSeconds    int64      `json:"ttlSeconds"`
+		ExtendSeconds int64      `json:"extendSeconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	set := 0
+	for _, given := range []bool{req.ExpiresAt != nil, req.TTLSeconds != 0, req.ExtendSeconds != 0} {
+		if given {
+			set++
+		}
+	}
+	if set != 1 || req.TTLSeconds < 0 || req.ExtendSeconds < 0 {
+		http.Error(w, "Specify one of expiresAt, ttlSeconds or extendSeconds (positive)", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt time.Time
+	var err error
+	switch {
+	case req.ExtendSeconds > 0:
+		expiresAt, err = h.Metadata.ExtendTagExpiry(r.Context(), name, tag, time.Duration(req.ExtendSeconds)*time.Second)
+	case req.TTLSeconds > 0:
+		expiresAt = time.Now().Add(time.Duration(req.TTLSeconds) * time.Second)
+		err = h.Metadata.SetTagExpiry(r.Context(), name, tag, &expiresAt)
+	default:
+		expiresAt = *req.ExpiresAt
+		err = h.Metadata.SetTagExpiry(r.Context(), name, tag, &expiresAt)
+	}
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+	h.auditTagExpiry(r, name, tag, &expiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": tag, "expiresAt": expiresAt})
+}
+
+// ClearTagExpiry keeps a tag until it is deleted by hand. A matching expiry
+// rule sets a new expiry on its next push.
+// DELETE /api/v1/repositories/{name}/tags/{tag}/expiry
+func (h *DashboardHandler) ClearTagExpiry(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, tag := vars["name"], vars["tag"]
+	if !h.Authz.Require(w, r, name, authz.RoleWrite) {
+		return
+	}
+
+	if err := h.Metadata.SetTagExpiry(r.Context(), name, tag, nil); err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+	h.auditTagExpiry(r, name, tag, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *DashboardHandler) auditTagExpiry(r *http.Request, repository, tag string, expiresAt *time.Time) {
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "TAG_EXPIRY_SET", nil, map[string]interface{}{"repository": repository, "reference": tag, "expiresAt": expiresAt})
+	}
+}
+
+// ListTagExpiryRules returns the rules that give pushed tags an expiry.
+// GET /api/v1/repositories/{name}/tag-expiry-rules
+func (h *DashboardHandler) ListTagExpiryRules(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleRead) {
+		return
+	}
+
+	rules, err := h.Metadata.ListTagExpiryRules(r.Context(), name)
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"rules": rules})
+}
+
+// SetTagExpiryRule makes tags matching a glob expire ttlSeconds after each
+// push, e.g. PR preview tags after a week. Setting a pattern again changes
+// its TTL.
+// PUT /api/v1/repositories/{name}/tag-expiry-rules {"pattern":"pr-*","ttlSeconds":604800}
+func (h *DashboardHandler) SetTagExpiryRule(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+
+	var req struct {
+		Pattern    string `json:"pattern"`
+		TTLSeconds int64  `json:"ttlSeconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Pattern = strings.TrimSpace(req.Pattern)
+	if _, err := path.Match(req.Pattern, ""); err != nil || req.Pattern == "" {
+		http.Error(w, "pattern must be a glob such as pr-*", http.StatusBadRequest)
+		return
+	}
+	if req.TTLSeconds <= 0 {
+		http.Error(w, "ttlSeconds must be positive", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.Metadata.SetTagExpiryRule(r.Context(), name, req.Pattern, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "TAG_EXPIRY_RULE_SET", nil, map[string]interface{}{"repository": name, "pattern": rule.Pattern, "ttlSeconds": rule.TTLSeconds})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteTagExpiryRule removes a rule; expiries it already set stay.
+// DELETE /api/v1/repositories/{name}/tag-expiry-rules/{id}
+func (h *DashboardHandler) DeleteTagExpiryRule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+	id, err := uuid.Parse(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Metadata.DeleteTagExpiryRule(r.Context(), name, id); err != nil {
+		writeMetadataError(w, err)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "TAG_EXPIRY_RULE_DELETE", nil, map[string]interface{}{"repository": name, "rule": id})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
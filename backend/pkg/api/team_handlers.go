@@ -0,0 +1,300 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+	"github.com/registryx/registryx/backend/pkg/teams"
+)
+
+// namespaceAdmin reports whether the caller administers the namespace
+// through the namespace role of one of its teams.
+func (h *DashboardHandler) namespaceAdmin(r *http.Request, nsName string) bool {
+	role, err := h.Authz.NamespaceRole(r.Context(), authz.SubjectFromContext(r.Context()), nsName)
+	return err == nil && role.Includes(authz.RoleAdmin)
+}
+
+// teamAccess checks that the caller is an admin, owns the namespace or
+// administers it through a team, or for reads is in one of its teams,
+// writing the error response if not.
+func (h *DashboardHandler) teamAccess(w http.ResponseWriter, r *http.Request, nsName string, manage bool) bool {
+	if r.Context().Value(middleware.RoleKey) == "admin" {
+		return true
+	}
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	owner, err := h.Teams.IsOwner(r.Context(), nsName, uid)
+	if err == nil && !owner {
+		owner = h.namespaceAdmin(r, nsName)
+	}
+	if err == nil && !owner && !manage {
+		owner, err = h.Teams.IsMember(r.Context(), nsName, uid)
+	}
+	if errors.Is(err, teams.ErrNamespaceNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !owner {
+		http.Error(w, "Forbidden: namespace owner or admin access required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// teamError writes the response for an error from the teams service.
+func teamError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, teams.ErrNotFound), errors.Is(err, teams.ErrNamespaceNotFound),
+		errors.Is(err, teams.ErrRepositoryNotFound), errors.Is(err, teams.ErrMemberNotFound),
+		errors.Is(err, teams.ErrBindingNotFound), errors.Is(err, teams.ErrNoNamespaceRole):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, teams.ErrExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, teams.ErrInvalidName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// auditTeam records a team change in the audit log.
+func (h *DashboardHandler) auditTeam(r *http.Request, action string, details map[string]interface{}) {
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, action, nil, details)
+	}
+}
+
+// ListTeams returns the teams of a namespace. Namespace owners, team
+// members and admins only.
+// GET /api/v1/namespaces/{name}/teams
+func (h *DashboardHandler) ListTeams(w http.ResponseWriter, r *http.Request) {
+	nsName := mux.Vars(r)["name"]
+	if !h.teamAccess(w, r, nsName, false) {
+		return
+	}
+
+	list, err := h.Teams.List(r.Context(), nsName)
+	if err != nil {
+		teamError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": list})
+}
+
+// CreateTeam adds a team to a namespace. Namespace owners and admins only.
+// POST /api/v1/namespaces/{name}/teams {"name":"backend","description":"..."}
+func (h *DashboardHandler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+	nsName := mux.Vars(r)["name"]
+	if !h.teamAccess(w, r, nsName, true) {
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	team, err := h.Teams.Create(r.Context(), nsName, strings.TrimSpace(req.Name), req.Description, uid)
+	if err != nil {
+		teamError(w, err)
+		return
+	}
+
+	h.auditTeam(r, "TEAM_CREATE", map[string]interface{}{"namespace": nsName, "team": team.Name})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(team)
+}
+
+// GetTeam returns a team with its members and repository roles. Namespace
+// owners, team members and admins only.
+// GET /api/v1/namespaces/{name}/teams/{team}
+func (h *DashboardHandler) GetTeam(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.teamAccess(w, r, vars["name"], false) {
+		return
+	}
+
+	team, err := h.Teams.Get(r.Context(), vars["name"], vars["team"])
+	if err != nil {
+		teamError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(team)
+}
+
+// DeleteTeam removes a team; its members lose the roles they held through
+// it. Namespace owners and admins only.
+// DELETE /api/v1/namespaces/{name}/teams/{team}
+func (h *DashboardHandler) DeleteTeam(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.teamAccess(w, r, vars["name"], true) {
+		return
+	}
+
+	if err := h.Teams.Delete(r.Context(), vars["name"], vars["team"]); err != nil {
+		teamError(w, err)
+		return
+	}
+	h.auditTeam(r, "TEAM_DELETE", map[string]interface{}{"namespace": vars["name"], "team": vars["team"]})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddTeamMember adds a user to a team. Namespace owners and admins only.
+// PUT /api/v1/namespaces/{name}/teams/{team}/members/{user}
+func (h *DashboardHandler) AddTeamMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.teamAccess(w, r, vars["name"], true) {
+		return
+	}
+
+	memberID, err := h.Auth.LookupUserID(r.Context(), vars["user"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := h.Teams.AddMember(r.Context(), vars["name"], vars["team"], memberID); err != nil {
+		teamError(w, err)
+		return
+	}
+	h.auditTeam(r, "TEAM_MEMBER_ADD", map[string]interface{}{"namespace": vars["name"], "team": vars["team"], "user": vars["user"]})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveTeamMember removes a user from a team. Namespace owners and admins
+// only.
+// DELETE /api/v1/namespaces/{name}/teams/{team}/members/{user}
+func (h *DashboardHandler) RemoveTeamMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.teamAccess(w, r, vars["name"], true) {
+		return
+	}
+
+	memberID, err := h.Auth.LookupUserID(r.Context(), vars["user"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := h.Teams.RemoveMember(r.Context(), vars["name"], vars["team"], memberID); err != nil {
+		teamError(w, err)
+		return
+	}
+	h.auditTeam(r, "TEAM_MEMBER_REMOVE", map[string]interface{}{"namespace": vars["name"], "team": vars["team"], "user": vars["user"]})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetTeamRepository gives a team read, write or admin access to a
+// repository of the namespace, replacing the role it had. Namespace owners
+// and admins only.
+// PUT /api/v1/namespaces/{name}/teams/{team}/repositories/{repo} {"role":"write"}
+func (h *DashboardHandler) SetTeamRepository(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.teamAccess(w, r, vars["name"], true) {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: role is required", http.StatusBadRequest)
+		return
+	}
+	role, ok := authz.ParseRole(req.Role)
+	if !ok {
+		http.Error(w, "Invalid role: use read, write or admin", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	if err := h.Teams.Bind(r.Context(), vars["name"], vars["team"], vars["repo"], role, uid); err != nil {
+		teamError(w, err)
+		return
+	}
+	h.auditTeam(r, "TEAM_GRANT", map[string]interface{}{"namespace": vars["name"], "team": vars["team"], "repository": vars["name"] + "/" + vars["repo"], "role": role})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveTeamRepository removes a team's access to a repository. Namespace
+// owners and admins only.
+// DELETE /api/v1/namespaces/{name}/teams/{team}/repositories/{repo}
+func (h *DashboardHandler) RemoveTeamRepository(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.teamAccess(w, r, vars["name"], true) {
+		return
+	}
+
+	if err := h.Teams.Unbind(r.Context(), vars["name"], vars["team"], vars["repo"]); err != nil {
+		teamError(w, err)
+		return
+	}
+	h.auditTeam(r, "TEAM_REVOKE", map[string]interface{}{"namespace": vars["name"], "team": vars["team"], "repository": vars["name"] + "/" + vars["repo"]})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetTeamNamespaceRole gives a team read, write or admin access to every
+// repository of the namespace, including ones pushed later. Namespace
+// owners and admins only.
+// PUT /api/v1/namespaces/{name}/teams/{team}/role {"role":"read"}
+func (h *DashboardHandler) SetTeamNamespaceRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.teamAccess(w, r, vars["name"], true) {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: role is required", http.StatusBadRequest)
+		return
+	}
+	role, ok := authz.ParseRole(req.Role)
+	if !ok {
+		http.Error(w, "Invalid role: use read, write or admin", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Teams.SetNamespaceRole(r.Context(), vars["name"], vars["team"], role); err != nil {
+		teamError(w, err)
+		return
+	}
+	h.auditTeam(r, "TEAM_GRANT", map[string]interface{}{"namespace": vars["name"], "team": vars["team"], "role": role})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ClearTeamNamespaceRole removes a team's namespace-wide access; its roles
+// on single repositories stay. Namespace owners and admins only.
+// DELETE /api/v1/namespaces/{name}/teams/{team}/role
+func (h *DashboardHandler) ClearTeamNamespaceRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.teamAccess(w, r, vars["name"], true) {
+		return
+	}
+
+	if err := h.Teams.ClearNamespaceRole(r.Context(), vars["name"], vars["team"]); err != nil {
+		teamError(w, err)
+		return
+	}
+	h.auditTeam(r, "TEAM_REVOKE", map[string]interface{}{"namespace": vars["name"], "team": vars["team"]})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/auth"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// ListRegistryTokens returns unexpired registry tokens issued by /auth/token,
+// optionally filtered by ?subject= (a user ID or "serviceaccount:<name>").
+// GET /api/v1/system/registry-tokens
+func (h *DashboardHandler) ListRegistryTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	tokens, err := h.Auth.ListRegistryTokens(r.Context(), r.URL.Query().Get("subject"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": tokens})
+}
+
+// RevokeRegistryToken blocks one registry token immediately.
+// DELETE /api/v1/system/registry-tokens/{id}
+func (h *DashboardHandler) RevokeRegistryToken(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	jti := mux.Vars(r)["id"]
+
+	if err := h.Auth.RevokeRegistryToken(r.Context(), jti); err != nil {
+		status := http.StatusInternalServerError
+		if err == auth.ErrTokenNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	h.auditTokenRevoke(r, map[string]interface{}{"jti": jti})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeRegistryTokensFor blocks every live token of a subject, e.g. a
+// compromised CI service account.
+// POST /api/v1/system/registry-tokens/revoke {"subject":"serviceaccount:ci-bot"}
+func (h *DashboardHandler) RevokeRegistryTokensFor(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Subject string `json:"subject"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Subject == "" {
+		http.Error(w, "subject is required", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := h.Auth.RevokeRegistryTokensFor(r.Context(), req.Subject)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.auditTokenRevoke(r, map[string]interface{}{"subject": req.Subject, "revoked": revoked})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+}
+
+func (h *DashboardHandler) auditTokenRevoke(r *http.Request, details map[string]interface{}) {
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "REGISTRY_TOKEN_REVOKE", nil, details)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+	"github.com/registryx/registryx/backend/pkg/transfer"
+)
+
+// RequestTransfer asks to move a repository into another namespace. It takes
+// effect when the owner of that namespace accepts it.
+// POST /api/v1/repositories/{name}/transfer {"namespace":"acme"}
+func (h *DashboardHandler) RequestTransfer(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.Authz.Require(w, r, name, authz.RoleAdmin) {
+		return
+	}
+
+	var req struct {
+		Namespace string `json:"namespace"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Namespace) == "" {
+		http.Error(w, "Invalid request body: namespace is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	t, err := h.Transfers.Request(r.Context(), name, strings.TrimSpace(req.Namespace), uid)
+	if err != nil {
+		writeTransferError(w, err)
+		return
+	}
+
+	if uid != uuid.Nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "REPOSITORY_TRANSFER_REQUEST", nil, map[string]interface{}{"repository": t.Repository, "namespace": t.TargetNamespace, "transfer": t.ID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// ListTransfers returns pending transfers the caller sent or can accept.
+// GET /api/v1/transfers
+func (h *DashboardHandler) ListTransfers(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	role, _ := r.Context().Value(middleware.RoleKey).(string)
+
+	transfers, err := h.Transfers.List(r.Context(), uid, role == "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"transfers": transfers})
+}
+
+// AcceptTransfer completes a transfer. Only the receiving namespace owner or
+// an admin may accept.
+// POST /api/v1/transfers/{id}/accept
+func (h *DashboardHandler) AcceptTransfer(w http.ResponseWriter, r *http.Request) {
+	h.resolveTransfer(w, r, true)
+}
+
+// DeclineTransfer closes a pending transfer: the receiving owner declines it,
+// the requester cancels it.
+// POST /api/v1/transfers/{id}/decline
+func (h *DashboardHandler) DeclineTransfer(w http.ResponseWriter, r *http.Request) {
+	h.resolveTransfer(w, r, false)
+}
+
+func (h *DashboardHandler) resolveTransfer(w http.ResponseWriter, r *http.Request, accept bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid transfer ID", http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	uid, _ := uuid.Parse(userID)
+	role, _ := r.Context().Value(middleware.RoleKey).(string)
+
+	var t *transfer.Transfer
+	action := "REPOSITORY_TRANSFER_DECLINE"
+	if accept {
+		t, err = h.Transfers.Accept(r.Context(), id, uid, role == "admin")
+		action = "REPOSITORY_TRANSFER_ACCEPT"
+	} else {
+		t, err = h.Transfers.Decline(r.Context(), id, uid, role == "admin")
+	}
+	if err != nil {
+		writeTransferError(w, err)
+		return
+	}
+
+	if uid != uuid.Nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, action, nil, map[string]interface{}{"repository": t.Repository, "newName": t.NewName, "status": t.Status, "transfer": t.ID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+func writeTransferError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, transfer.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, transfer.ErrForbidden):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, transfer.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case strings.HasSuffix(err.Error(), "not found"):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
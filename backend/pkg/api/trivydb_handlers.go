@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+	"github.com/registryx/registryx/backend/pkg/trivydb"
+)
+
+// maxTrivyBundleUpload bounds uploaded db.tar.gz bundles (about 60MB today).
+const maxTrivyBundleUpload = 1 << 30
+
+// GetTrivyDB reports the version and age of the vulnerability database.
+// GET /api/v1/system/trivy-db
+func (h *DashboardHandler) GetTrivyDB(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.TrivyDB.Status())
+}
+
+// ImportTrivyDB installs a vulnerability DB bundle (db.tar.gz). The bundle is
+// either the request body, or named by a JSON body: {"path": "/mnt/bundles/db.tar.gz"}
+// for a file on this instance, {"url": "..."} for an internal mirror, or {}
+// for the configured TRIVY_DB_MIRROR.
+// POST /api/v1/system/trivy-db/import
+func (h *DashboardHandler) ImportTrivyDB(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(middleware.RoleKey) != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var status *trivydb.Status
+	var source string
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var req struct {
+			Path string `json:"path"`
+			URL  string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if req.Path != "" && req.URL != "" {
+			http.Error(w, "Specify path or url, not both", http.StatusBadRequest)
+			return
+		}
+		if req.Path != "" {
+			source = "file:" + req.Path
+			status, err = h.TrivyDB.ImportFile(r.Context(), req.Path)
+		} else {
+			source = req.URL
+			if source == "" {
+				source = h.TrivyDB.Mirror
+			}
+			status, err = h.TrivyDB.ImportURL(r.Context(), req.URL)
+		}
+	} else {
+		source = "upload"
+		status, err = h.TrivyDB.Import(r.Context(), source, http.MaxBytesReader(w, r.Body, maxTrivyBundleUpload))
+	}
+	if errors.Is(err, trivydb.ErrNotNewer) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Import failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(middleware.UserKey).(string)
+	if uid, err := uuid.Parse(userID); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, "TRIVY_DB_IMPORT", nil, map[string]interface{}{"source": source, "version": status.Version})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -3,11 +3,25 @@ package audit
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/clientip"
 )
 
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Service struct {
 	DB *sql.DB
 }
@@ -24,14 +38,24 @@ type LogEntry struct {
 	CreatedAt time.Time       `json:"created_at"`
 }
 
-// Log records an audit event. repoID can be nil.
+// Log records an audit event. repoID can be nil; uuid.Nil records an event
+// with no user, such as a failed sign-in. The client address of the request
+// ctx belongs to is added to details as "ip".
 func (s *Service) Log(ctx context.Context, userID uuid.UUID, action string, repoID *uuid.UUID, details map[string]interface{}) error {
+	if ip := clientip.FromContext(ctx); ip != "" {
+		withIP := make(map[string]interface{}, len(details)+1)
+		for k, v := range details {
+			withIP[k] = v
+		}
+		withIP["ip"] = ip
+		details = withIP
+	}
 	detailsJSON, _ := json.Marshal(details)
 	
 	_, err := s.DB.ExecContext(ctx, `
 		INSERT INTO audit_logs (user_id, action, repository_id, details, created_at)
 		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)`,
-		userID, action, repoID, detailsJSON)
+		uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil}, action, repoID, detailsJSON)
 	return err
 }
 
@@ -58,3 +82,112 @@ func (s *Service) GetUserLogs(ctx context.Context, userID uuid.UUID, limit int)
 	}
 	return logs, nil
 }
+
+// LogQuery selects a page of audit logs. Zero Since/Until leave that side of
+// the time range open; Cursor is the NextCursor of the previous page.
+type LogQuery struct {
+	Limit  int
+	Cursor string
+	Since  time.Time
+	Until  time.Time
+}
+
+type LogPage struct {
+	Logs       []LogEntry `json:"logs"`
+	Total      int        `json:"total"`                 // entries matching the time range
+	NextCursor string     `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+// ListUserLogs returns one page of a user's logs, newest first, using keyset
+// pagination on (created_at, id) so deep pages stay cheap.
+func (s *Service) ListUserLogs(ctx context.Context, userID uuid.UUID, q LogQuery) (*LogPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+
+	where := []string{"user_id = $1"}
+	args := []interface{}{userID}
+	if !q.Since.IsZero() {
+		args = append(args, q.Since)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !q.Until.IsZero() {
+		args = append(args, q.Until)
+		where = append(where, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	page := &LogPage{Logs: []LogEntry{}}
+	countQuery := "SELECT COUNT(*) FROM audit_logs WHERE " + strings.Join(where, " AND ")
+	if err := s.DB.QueryRowContext(ctx, countQuery, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	if q.Cursor != "" {
+		ts, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, ts, id)
+		where = append(where, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	// Fetch one extra row to know whether another page exists.
+	args = append(args, q.Limit+1)
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, user_id, action, details, created_at
+		FROM audit_logs
+		WHERE %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d`, strings.Join(where, " AND "), len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l LogEntry
+		if err := rows.Scan(&l.ID, &l.UserID, &l.Action, &l.Details, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		page.Logs = append(page.Logs, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Logs) > q.Limit {
+		page.Logs = page.Logs[:q.Limit]
+		last := page.Logs[q.Limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	return page, nil
+}
+
+// Cursors are opaque to clients: base64("<unix nanos>|<id>").
+func encodeCursor(ts time.Time, id uuid.UUID) string {
+	raw := strconv.FormatInt(ts.UnixNano(), 10) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	return time.Unix(0, nanos), id, nil
+}
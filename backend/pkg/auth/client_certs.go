@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ClientCertificate maps a client certificate subject to the user or service
+// account it signs in as (mTLS, see TLS_CLIENT_CA_FILE).
+type ClientCertificate struct {
+	ID             uuid.UUID  `json:"id"`
+	Subject        string     `json:"subject"` // "CN=ci-runner" or a full subject DN
+	UserID         *uuid.UUID `json:"userId,omitempty"`
+	Username       string     `json:"username,omitempty"`
+	ServiceAccount string     `json:"serviceAccount,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	LastUsedAt     *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// ErrClientCertificateNotFound is returned for mappings that don't exist.
+var ErrClientCertificateNotFound = errors.New("client certificate mapping not found")
+
+// certIdentity is who a verified client certificate signs in as.
+type certIdentity struct {
+	subject  string // token subject: user ID or "serviceaccount:<name>"
+	username string
+	admin    bool
+	userID   uuid.UUID
+	account  *ServiceAccount
+}
+
+// normalizeSubject accepts "CN=x" or a full DN as x509 prints it.
+func normalizeSubject(subject string) (string, error) {
+	subject = strings.TrimSpace(subject)
+	if !strings.Contains(subject, "=") {
+		return "", errors.New(`subject must look like "CN=ci-runner" or "CN=ci-runner,OU=CI,O=Acme"`)
+	}
+	return subject, nil
+}
+
+// CreateClientCertificate maps subject to a user (by username) or a service
+// account (by name); exactly one must be given.
+func (s *Service) CreateClientCertificate(ctx context.Context, subject, username, serviceAccount string) (*ClientCertificate, error) {
+	subject, err := normalizeSubject(subject)
+	if err != nil {
+		return nil, err
+	}
+	if (username == "") == (serviceAccount == "") {
+		return nil, errors.New("exactly one of username or serviceAccount is required")
+	}
+
+	var id uuid.UUID
+	if username != "" {
+		err = s.DB.QueryRowContext(ctx, `
+			INSERT INTO client_certificates (subject, user_id)
+			SELECT $1, id FROM users WHERE username = $2
+			RETURNING id`, subject, username).Scan(&id)
+	} else {
+		err = s.DB.QueryRowContext(ctx, `
+			INSERT INTO client_certificates (subject, service_account_id)
+			SELECT $1, id FROM service_accounts WHERE name = $2 AND status = 'active'
+			RETURNING id`, subject, serviceAccount).Scan(&id)
+	}
+	if err == sql.ErrNoRows {
+		if username != "" {
+			return nil, fmt.Errorf("user %q not found", username)
+		}
+		return nil, fmt.Errorf("active service account %q not found", serviceAccount)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, fmt.Errorf("subject %q is already mapped", subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.getClientCertificate(ctx, id)
+}
+
+const clientCertColumns = `c.id, c.subject, c.user_id, COALESCE(u.username, ''), COALESCE(sa.name, ''), c.created_at, c.last_used_at`
+
+const clientCertJoins = `
+	FROM client_certificates c
+	LEFT JOIN users u ON u.id = c.user_id
+	LEFT JOIN service_accounts sa ON sa.id = c.service_account_id`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClientCertificate(row rowScanner) (*ClientCertificate, error) {
+	var c ClientCertificate
+	var userID uuid.NullUUID
+	var lastUsed sql.NullTime
+	if err := row.Scan(&c.ID, &c.Subject, &userID, &c.Username, &c.ServiceAccount, &c.CreatedAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	if userID.Valid {
+		c.UserID = &userID.UUID
+	}
+	if lastUsed.Valid {
+		c.LastUsedAt = &lastUsed.Time
+	}
+	return &c, nil
+}
+
+func (s *Service) getClientCertificate(ctx context.Context, id uuid.UUID) (*ClientCertificate, error) {
+	c, err := scanClientCertificate(s.DB.QueryRowContext(ctx, `SELECT `+clientCertColumns+clientCertJoins+` WHERE c.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrClientCertificateNotFound
+	}
+	return c, err
+}
+
+// ListClientCertificates returns every certificate mapping.
+func (s *Service) ListClientCertificates(ctx context.Context) ([]ClientCertificate, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+clientCertColumns+clientCertJoins+` ORDER BY c.subject`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []ClientCertificate{}
+	for rows.Next() {
+		c, err := scanClientCertificate(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *c)
+	}
+	return list, rows.Err()
+}
+
+// DeleteClientCertificate removes a mapping; the subject can no longer sign
+// in. Tokens already issued stay valid until they expire or are revoked.
+func (s *Service) DeleteClientCertificate(ctx context.Context, id uuid.UUID) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM client_certificates WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrClientCertificateNotFound
+	}
+	return nil
+}
+
+// verifiedClientCert returns the client certificate the TLS handshake
+// verified against TLS_CLIENT_CA_FILE, if any.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certificateIdentity resolves a verified client certificate to the user or
+// active service account its subject is mapped to. The full subject DN wins
+// over a "CN=" mapping.
+func (s *Service) certificateIdentity(ctx context.Context, cert *x509.Certificate) (*certIdentity, error) {
+	full := cert.Subject.String()
+	var mappingID uuid.UUID
+	var userID uuid.NullUUID
+	var username, role, accountName sql.NullString
+	var accountID uuid.NullUUID
+	var ttl sql.NullInt32
+	var cidrs []string
+	var scopes Scopes
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT c.id, u.id, u.username, u.role, sa.id, sa.name, sa.token_ttl_seconds, COALESCE(sa.allowed_cidrs, '{}'), sa.scopes
+		FROM client_certificates c
+		LEFT JOIN users u ON u.id = c.user_id
+		LEFT JOIN service_accounts sa ON sa.id = c.service_account_id AND sa.status = 'active'
+		WHERE c.subject = ANY($1) AND (u.id IS NOT NULL OR sa.id IS NOT NULL)
+		ORDER BY length(c.subject) DESC
+		LIMIT 1`, pq.Array([]string{full, "CN=" + cert.Subject.CommonName})).Scan(
+		&mappingID, &userID, &username, &role, &accountID, &accountName, &ttl, pq.Array(&cidrs), &scopes)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("certificate subject %q is not mapped to a user or active service account", full)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, _ = s.DB.ExecContext(ctx, `UPDATE client_certificates SET last_used_at = NOW() WHERE id = $1`, mappingID)
+
+	if accountID.Valid {
+		acc := &ServiceAccount{ID: accountID.UUID, Name: accountName.String, Status: "active", AllowedCIDRs: cidrs, Scopes: scopes}
+		if ttl.Valid {
+			v := int(ttl.Int32)
+			acc.TokenTTLSeconds = &v
+		}
+		_, _ = s.DB.ExecContext(ctx, `UPDATE service_accounts SET last_used_at = NOW() WHERE id = $1`, accountID.UUID)
+		return &certIdentity{subject: "serviceaccount:" + acc.Name, username: acc.Name, account: acc}, nil
+	}
+	return &certIdentity{
+		subject:  userID.UUID.String(),
+		username: username.String,
+		admin:    role.String == "admin",
+		userID:   userID.UUID,
+	}, nil
+}
@@ -1,13 +1,20 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/clientip"
+	"github.com/registryx/registryx/backend/pkg/errcode"
+	"github.com/registryx/registryx/backend/pkg/middleware"
 )
 
 // TokenResponse is the JSON response for a successful token request.
@@ -16,6 +23,7 @@ type TokenResponse struct {
 	AccessToken string `json:"access_token"` // Docker client likes both
 	ExpiresIn   int    `json:"expires_in"`
 	IssuedAt    string `json:"issued_at"`
+	ExpiresAt   string `json:"expires_at"` // so automation can refresh ahead of expiry
 }
 
 // Access describes the resource action being requested.
@@ -34,18 +42,85 @@ func (s *Service) TokenHandler(w http.ResponseWriter, r *http.Request) {
 	rawUser, rawPass, hasAuth := r.BasicAuth()
 	username := "anonymous"
 	subject := "anonymous"
+	var caller authz.Subject
+	var account *ServiceAccount // set for service accounts, whose own grants apply
+	ttl := s.tokenTTL()
+
+	if hasAuth && s.Observer != nil {
+		if wait := s.Observer.LoginBlocked(r); wait > 0 {
+			fmt.Printf("Auth refused for %s: too many failed attempts from the client\n", rawUser)
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "Too many failed sign-in attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
 	
-	if hasAuth {
+	if hasAuth && strings.HasPrefix(rawPass, "rx_") {
+		// Service account: the password is its API key. Accounts are
+		// created by admins and get what their scopes grant, or pull and
+		// push on every repository when unscoped.
+		acc, err := s.ValidateServiceAccount(r.Context(), rawUser, rawPass)
+		if err != nil {
+			fmt.Printf("Auth failed for service account %s: %v\n", rawUser, err)
+			if s.Observer != nil {
+				s.Observer.LoginFailed(r, rawUser, "registry", err.Error())
+			}
+			w.Header().Set("Www-Authenticate", `Bearer realm="http://localhost:5000/auth/token",service="registryx"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if s.Networks != nil && !s.Networks.Permits(r, acc.AllowedCIDRs) {
+			fmt.Printf("Auth denied for service account %s: %s is outside its allowed networks\n", acc.Name, clientip.FromRequest(r))
+			errcode.ServeJSON(w, errcode.Denied.WithMessage(fmt.Sprintf("service account %s may not be used from %s", acc.Name, clientip.FromRequest(r))))
+			return
+		}
+		account = acc
+		username = account.Name
+		subject = "serviceaccount:" + account.Name
+		if account.TokenTTLSeconds != nil && *account.TokenTTLSeconds > 0 {
+			ttl = time.Duration(*account.TokenTTLSeconds) * time.Second
+		}
+		fmt.Printf("Auth request verified for service account: %s (ID: %s)\n", account.Name, account.ID)
+	} else if hasAuth {
 		validUser, err := s.ValidateCredentials(r.Context(), rawUser, rawPass)
 		if err != nil {
 			fmt.Printf("Auth failed for user %s: %v\n", rawUser, err)
+			if s.Observer != nil {
+				s.Observer.LoginFailed(r, rawUser, "registry", err.Error())
+			}
 			w.Header().Set("Www-Authenticate", `Bearer realm="http://localhost:5000/auth/token",service="registryx"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 		username = validUser.Username
 		subject = validUser.ID.String()
+		caller = authz.Subject{UserID: validUser.ID, Admin: validUser.Role == "admin"}
 		fmt.Printf("Auth request verified for user: %s (ID: %s)\n", username, subject)
+	} else if cert := verifiedClientCert(r); cert != nil {
+		// mTLS: a certificate the TLS handshake verified against
+		// TLS_CLIENT_CA_FILE signs in as the identity its subject is
+		// mapped to. Unmapped certificates get anonymous tokens.
+		id, err := s.certificateIdentity(r.Context(), cert)
+		if err != nil {
+			fmt.Printf("Client certificate not accepted: %v\n", err)
+		} else if id.account != nil && s.Networks != nil && !s.Networks.Permits(r, id.account.AllowedCIDRs) {
+			fmt.Printf("Auth denied for service account %s: %s is outside its allowed networks\n", id.account.Name, clientip.FromRequest(r))
+			errcode.ServeJSON(w, errcode.Denied.WithMessage(fmt.Sprintf("service account %s may not be used from %s", id.account.Name, clientip.FromRequest(r))))
+			return
+		} else {
+			hasAuth = true
+			username = id.username
+			subject = id.subject
+			caller = authz.Subject{UserID: id.userID, Admin: id.admin}
+			if id.account != nil && id.account.TokenTTLSeconds != nil && *id.account.TokenTTLSeconds > 0 {
+				ttl = time.Duration(*id.account.TokenTTLSeconds) * time.Second
+			}
+			account = id.account
+			fmt.Printf("Auth request verified by client certificate %q for: %s\n", cert.Subject.String(), username)
+		}
+	}
+	if hasAuth && s.Observer != nil {
+		s.Observer.LoginSucceeded(r, subject, username)
 	}
 
 	// 2. Parse Requested Access
@@ -58,26 +133,29 @@ func (s *Service) TokenHandler(w http.ResponseWriter, r *http.Request) {
 		if a.Type == "repository" {
 			newActions := []string{}
 			
-			// Parse Namespace
-			parts := strings.SplitN(a.Name, "/", 2)
-			namespace := "library"
-			if len(parts) == 2 {
-				namespace = parts[0]
+			if account != nil {
+				newActions = account.permittedActions(a.Name, a.Actions)
+				if len(newActions) > 0 {
+					grantedAccess = append(grantedAccess, &Access{Type: a.Type, Name: a.Name, Actions: newActions})
+				}
+				continue
 			}
-			
+
 			// Determine Permissions
+			namespace, _ := authz.SplitRepository(a.Name)
 			canPull := false
 			canPush := false
-			
-			if username == "admin" {
-				canPull = true
-				canPush = true
-			} else if username == namespace {
-				canPull = true
-				canPush = true
-			} else if namespace == "library" {
+
+			if namespace == "library" || caller.Admin {
 				canPull = true
 				canPush = true // Every user can push to library privately
+			} else if s.Authz != nil {
+				role, err := s.Authz.RoleFor(r.Context(), caller, a.Name)
+				if err != nil {
+					fmt.Printf("Authorization lookup failed for %s: %v\n", a.Name, err)
+				}
+				canPull = role.Includes(authz.RoleRead)
+				canPush = role.Includes(authz.RoleWrite)
 			}
 
 			for _, action := range a.Actions {
@@ -85,6 +163,8 @@ func (s *Service) TokenHandler(w http.ResponseWriter, r *http.Request) {
 					newActions = append(newActions, "pull")
 				} else if action == "push" && canPush {
 					newActions = append(newActions, "push")
+				} else if action == "delete" && s.canDelete(r.Context(), caller, a.Name) {
+					newActions = append(newActions, "delete")
 				}
 			}
 			
@@ -99,17 +179,31 @@ func (s *Service) TokenHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 4. Generate JWT
-	tokenString, err := s.generateRegistryToken(service, subject, grantedAccess)
+	now := time.Now()
+	jti := uuid.New().String()
+	tokenString, err := s.generateRegistryToken(service, subject, jti, grantedAccess, account != nil && account.Scoped(), now, ttl)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
+	if hasAuth {
+		// Anonymous tokens only reach public content; nothing to revoke.
+		s.recordRegistryToken(r.Context(), RegistryTokenInfo{
+			ID:        jti,
+			Subject:   subject,
+			Username:  username,
+			Scope:     scope,
+			IssuedAt:  now.Format(time.RFC3339),
+			ExpiresAt: now.Add(ttl).Format(time.RFC3339),
+		}, ttl)
+	}
 
 	resp := TokenResponse{
 		Token:       tokenString,
 		AccessToken: tokenString,
-		ExpiresIn:   3600,
-		IssuedAt:    time.Now().Format(time.RFC3339),
+		ExpiresIn:   int(ttl / time.Second),
+		IssuedAt:    now.Format(time.RFC3339),
+		ExpiresAt:   now.Add(ttl).Format(time.RFC3339),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -140,24 +234,46 @@ func parseScope(scope string) []*Access {
 	}}
 }
 
+// canDelete reports whether caller may delete manifests and blobs of a
+// repository: write access on it, which library's open pushes don't give.
+func (s *Service) canDelete(ctx context.Context, caller authz.Subject, repoName string) bool {
+	if caller.Admin {
+		return true
+	}
+	if s.Authz == nil {
+		return false
+	}
+	ok, err := s.Authz.Allowed(ctx, caller, repoName, authz.RoleWrite)
+	if err != nil {
+		fmt.Printf("Authorization lookup failed for %s: %v\n", repoName, err)
+	}
+	return ok
+}
+
 // generateToken signs a JWT
 // Note: In real prod, use a persistent RSA Private Key. 
 // For this MVP session, we'll generate a random key on startup or use a static secret (HMAC) for simplicity
 // BUT Docker requires RS256 usually if checking signatures against a public key derived from it.
 // We will use HS256 for internal verification if we are the only ones checking it.
 // However, if we want to be correct, we need a signing key. Let's use a dummy secret for now.
-func (s *Service) generateRegistryToken(service, subject string, access []*Access) (string, error) {
-	now := time.Now()
+//
+// Tokens of scoped service accounts are marked scoped, which keeps them to
+// the registry API.
+func (s *Service) generateRegistryToken(service, subject, jti string, access []*Access, scoped bool, now time.Time, ttl time.Duration) (string, error) {
 	claims := jwt.MapClaims{
-		"iss":    "registryx-auth",
+		"iss":    middleware.RegistryTokenIssuer,
 		"sub":    subject,
+		"jti":    jti, // checked against the revocation blocklist
 		"aud":    service,
-		"exp":    now.Add(time.Hour).Unix(),
+		"exp":    now.Add(ttl).Unix(),
 		"nbf":    now.Unix(),
 		"iat":    now.Unix(),
 		"access": access,
 	}
+	if scoped {
+		claims[middleware.ScopedClaim] = true
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-	return token.SignedString([]byte(s.JWTSecret))
+	return s.sign(token)
 }
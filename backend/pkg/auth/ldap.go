@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/registryx/registryx/backend/pkg/ldapauth"
+)
+
+// Where a user's password is checked.
+const (
+	SourceLocal = "local"
+	SourceLDAP  = "ldap"
+)
+
+// ldapPasswordHash marks the accounts of directory users; no password
+// hashes to it, so they can't sign in with a local password.
+const ldapPasswordHash = "!ldap"
+
+// ErrDirectoryUser is returned when changing the password of a user who
+// signs in with LDAP.
+var ErrDirectoryUser = errors.New("password is managed by the LDAP directory")
+
+// errNotInDirectory makes checkPassword fall back to local accounts.
+var errNotInDirectory = errors.New("not a directory user")
+
+// checkPassword authenticates a user: with LDAP configured, against the
+// directory first, then against local accounts. It returns the user and
+// how they signed in ("password" or "ldap").
+func (s *Service) checkPassword(ctx context.Context, username, password string) (*User, string, error) {
+	if s.LDAP != nil {
+		user, err := s.directoryLogin(ctx, username, password)
+		if err == nil {
+			return user, "ldap", nil
+		}
+		if !errors.Is(err, errNotInDirectory) {
+			return nil, "", err
+		}
+	}
+	user, err := s.localLogin(ctx, username, password)
+	return user, "password", err
+}
+
+// directoryLogin checks the password against LDAP and creates or updates
+// the user's account from the directory entry. It fails with
+// errNotInDirectory when the directory doesn't know the user, can't be
+// reached, or the username belongs to a local account, which only signs in
+// with its own password.
+func (s *Service) directoryLogin(ctx context.Context, username, password string) (*User, error) {
+	entry, err := s.LDAP.Authenticate(username, password)
+	switch {
+	case errors.Is(err, ldapauth.ErrUserNotFound):
+		return nil, errNotInDirectory
+	case errors.Is(err, ldapauth.ErrInvalidCredentials), errors.Is(err, ldapauth.ErrNoAccess):
+		fmt.Printf("[Auth] LDAP login failed for '%s': %v\n", username, err)
+		return nil, errors.New("invalid credentials")
+	case err != nil:
+		fmt.Printf("[Auth] LDAP unavailable, trying local accounts: %v\n", err)
+		return nil, errNotInDirectory
+	}
+
+	name := strings.ToLower(entry.Username)
+	email := entry.Email
+	if email == "" {
+		email = name + "@ldap.invalid"
+	}
+	// Without group roles the directory doesn't decide roles: new users
+	// get "user", existing ones keep theirs.
+	var role sql.NullString
+	if entry.Role != "" {
+		role = sql.NullString{String: entry.Role, Valid: true}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var user User
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (username, email, password_hash, role, auth_source)
+		VALUES ($1, $2, $3, COALESCE($4, 'user'), $5)
+		ON CONFLICT (username) DO UPDATE SET
+			email = EXCLUDED.email, role = COALESCE($4, users.role), updated_at = NOW()
+		WHERE users.auth_source = $5
+		RETURNING id, username, email, role, created_at, updated_at`,
+		name, email, ldapPasswordHash, role, SourceLDAP).Scan(
+		&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		fmt.Printf("[Auth] LDAP user '%s' has the name of a local account; using the local account\n", name)
+		return nil, errNotInDirectory
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision LDAP user: %w", err)
+	}
+
+	// Directory users get a personal namespace like registered ones
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO namespaces (name, type, owner_id) VALUES ($1, 'user', $2)
+		ON CONFLICT (name) DO NOTHING`, name, user.ID); err != nil {
+		return nil, fmt.Errorf("failed to create namespace: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	user.PasswordHash = ldapPasswordHash
+	return &user, nil
+}
+
+// localLogin checks the password of a local account.
+func (s *Service) localLogin(ctx context.Context, username, password string) (*User, error) {
+	var user User
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT id, username, email, password_hash, role, created_at, updated_at
+		FROM users WHERE username = $1 AND auth_source = $2`, username, SourceLocal).Scan(
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		fmt.Printf("[Auth] Login failed: user '%s' not found\n", username)
+		return nil, errors.New("invalid credentials")
+	} else if err != nil {
+		fmt.Printf("[Auth] Login DB error for '%s': %v\n", username, err)
+		return nil, err
+	}
+
+	if !CheckPasswordHash(password, user.PasswordHash) {
+		fmt.Printf("[Auth] Login failed: password mismatch for '%s'\n", username)
+		return nil, errors.New("invalid credentials")
+	}
+	return &user, nil
+}
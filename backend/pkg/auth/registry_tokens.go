@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// registryTokenPrefix keys a hash describing each issued registry token. It
+// expires with the token.
+const registryTokenPrefix = "registry-token:"
+
+// ErrTokenNotFound is returned when revoking a token that is unknown or has
+// already expired.
+var ErrTokenNotFound = errors.New("registry token not found or already expired")
+
+// RegistryTokenInfo describes an issued registry token, without the token
+// itself.
+type RegistryTokenInfo struct {
+	ID        string `json:"id"` // jti
+	Subject   string `json:"subject"`
+	Username  string `json:"username"`
+	Scope     string `json:"scope,omitempty"`
+	IssuedAt  string `json:"issued_at"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// recordRegistryToken remembers an issued token so admins can find and
+// revoke it.
+func (s *Service) recordRegistryToken(ctx context.Context, info RegistryTokenInfo, ttl time.Duration) {
+	if s.Redis == nil {
+		return
+	}
+	key := registryTokenPrefix + info.ID
+	err := s.Redis.HSet(ctx, key, map[string]interface{}{
+		"subject":    info.Subject,
+		"username":   info.Username,
+		"scope":      info.Scope,
+		"issued_at":  info.IssuedAt,
+		"expires_at": info.ExpiresAt,
+	}).Err()
+	if err != nil {
+		fmt.Printf("[Auth] Failed to record registry token %s: %v\n", info.ID, err)
+		return
+	}
+	s.Redis.Expire(ctx, key, ttl)
+}
+
+// ListRegistryTokens returns the unexpired registry tokens, optionally only
+// those issued to one subject (a user ID or "serviceaccount:<name>").
+func (s *Service) ListRegistryTokens(ctx context.Context, subject string) ([]RegistryTokenInfo, error) {
+	if s.Redis == nil {
+		return nil, errors.New("redis session store not available")
+	}
+
+	keys, err := s.Redis.Keys(ctx, registryTokenPrefix+"*").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []RegistryTokenInfo{}
+	for _, key := range keys {
+		data, err := s.Redis.HGetAll(ctx, key).Result()
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		if subject != "" && data["subject"] != subject {
+			continue
+		}
+		tokens = append(tokens, RegistryTokenInfo{
+			ID:        strings.TrimPrefix(key, registryTokenPrefix),
+			Subject:   data["subject"],
+			Username:  data["username"],
+			Scope:     data["scope"],
+			IssuedAt:  data["issued_at"],
+			ExpiresAt: data["expires_at"],
+		})
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].IssuedAt > tokens[j].IssuedAt })
+	return tokens, nil
+}
+
+// RevokeRegistryToken blocks a registry token until it would have expired.
+func (s *Service) RevokeRegistryToken(ctx context.Context, jti string) error {
+	if s.Redis == nil {
+		return errors.New("redis session store not available")
+	}
+	key := registryTokenPrefix + jti
+	ttl, err := s.Redis.TTL(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return ErrTokenNotFound
+	}
+	if err := s.Redis.Set(ctx, middleware.RevokedTokenPrefix+jti, time.Now().Format(time.RFC3339), ttl).Err(); err != nil {
+		return err
+	}
+	s.Redis.Del(ctx, key)
+	fmt.Printf("[Auth] Revoked registry token %s\n", jti)
+	return nil
+}
+
+// RevokeRegistryTokensFor revokes every live token issued to a subject and
+// returns how many were revoked.
+func (s *Service) RevokeRegistryTokensFor(ctx context.Context, subject string) (int, error) {
+	tokens, err := s.ListRegistryTokens(ctx, subject)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, t := range tokens {
+		if err := s.RevokeRegistryToken(ctx, t.ID); err != nil && err != ErrTokenNotFound {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// ScannerTokenTTL is how long a scanner pull token lasts; trivy fetches the
+// image at the start of a scan.
+const ScannerTokenTTL = 15 * time.Minute
+
+// ScannerToken mints a short-lived token that lets the embedded scanner pull
+// one repository. It is minted for each scan and listed like any other
+// registry token, so it can be revoked.
+func (s *Service) ScannerToken(ctx context.Context, repoName string) (string, error) {
+	now := time.Now()
+	jti := uuid.New().String()
+	access := []*Access{{Type: "repository", Name: repoName, Actions: []string{"pull"}}}
+	token, err := s.generateRegistryToken("registryx", middleware.ScannerSubject, jti, access, false, now, ScannerTokenTTL)
+	if err != nil {
+		return "", err
+	}
+	s.recordRegistryToken(ctx, RegistryTokenInfo{
+		ID:        jti,
+		Subject:   middleware.ScannerSubject,
+		Username:  "scanner",
+		Scope:     "repository:" + repoName + ":pull",
+		IssuedAt:  now.Format(time.RFC3339),
+		ExpiresAt: now.Add(ScannerTokenTTL).Format(time.RFC3339),
+	}, ScannerTokenTTL)
+	return token, nil
+}
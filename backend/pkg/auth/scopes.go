@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/authz"
+)
+
+// RepositoryScope limits a service account to some repositories. Repository
+// is a repository name ("acme/api"; bare names are in library), every
+// repository of a namespace ("acme/*"), or every repository ("*").
+type RepositoryScope struct {
+	Repository string   `json:"repository"`
+	Actions    []string `json:"actions"` // "pull", "push", "delete"
+}
+
+// Scopes are the scopes of a service account, stored as JSON; empty leaves
+// the account unscoped.
+type Scopes []RepositoryScope
+
+// Value implements driver.Valuer; empty scopes are stored as NULL.
+func (sc Scopes) Value() (driver.Value, error) {
+	if len(sc) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(sc)
+}
+
+// Scan implements sql.Scanner.
+func (sc *Scopes) Scan(src interface{}) error {
+	*sc = Scopes{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, sc)
+	case string:
+		return json.Unmarshal([]byte(v), sc)
+	}
+	return fmt.Errorf("cannot scan %T into scopes", src)
+}
+
+// NormalizeScopes validates scopes, lowercasing repository names and
+// dropping duplicate actions.
+func NormalizeScopes(scopes []RepositoryScope) (Scopes, error) {
+	out := make(Scopes, 0, len(scopes))
+	for _, sc := range scopes {
+		repo := strings.ToLower(strings.TrimSpace(sc.Repository))
+		pattern := strings.TrimSuffix(repo, "/*")
+		namespaceWide := pattern != repo
+		if repo == "" || (repo != "*" && (pattern == "" || strings.Contains(pattern, "*") ||
+			(namespaceWide && strings.Contains(pattern, "/")))) {
+			return nil, fmt.Errorf(`invalid repository %q: use "namespace/repo", "namespace/*" or "*"`, sc.Repository)
+		}
+		var actions []string
+		seen := map[string]bool{}
+		for _, a := range sc.Actions {
+			a = strings.ToLower(strings.TrimSpace(a))
+			if a != "pull" && a != "push" && a != "delete" {
+				return nil, fmt.Errorf("invalid action %q for %s: use pull, push or delete", a, repo)
+			}
+			if !seen[a] {
+				seen[a] = true
+				actions = append(actions, a)
+			}
+		}
+		if len(actions) == 0 {
+			return nil, fmt.Errorf("scope %s grants no actions", repo)
+		}
+		out = append(out, RepositoryScope{Repository: repo, Actions: actions})
+	}
+	return out, nil
+}
+
+// Scoped reports whether the account is limited to its scopes.
+func (a *ServiceAccount) Scoped() bool {
+	return len(a.Scopes) > 0
+}
+
+// permittedActions returns the requested actions the account may perform
+// on repoName: those its scopes grant, or pull and push for unscoped
+// accounts. Only a scope naming it grants delete.
+func (a *ServiceAccount) permittedActions(repoName string, requested []string) []string {
+	allowed := map[string]bool{}
+	if !a.Scoped() {
+		allowed["pull"], allowed["push"] = true, true
+	}
+	for _, sc := range a.Scopes {
+		if sc.matches(repoName) {
+			for _, action := range sc.Actions {
+				allowed[action] = true
+			}
+		}
+	}
+	permitted := []string{}
+	for _, action := range requested {
+		if allowed[action] {
+			permitted = append(permitted, action)
+		}
+	}
+	return permitted
+}
+
+func (sc RepositoryScope) matches(repoName string) bool {
+	if sc.Repository == "*" {
+		return true
+	}
+	namespace, name := authz.SplitRepository(strings.ToLower(repoName))
+	if ns, ok := strings.CutSuffix(sc.Repository, "/*"); ok {
+		return ns == namespace
+	}
+	scopeNamespace, scopeName := authz.SplitRepository(sc.Repository)
+	return scopeNamespace == namespace && scopeName == name
+}
+
+// SetScopes replaces the scopes of a service account; empty scopes make it
+// unscoped. scopes must be normalized. Registry tokens issued under the old
+// scopes are revoked.
+func (s *Service) SetScopes(ctx context.Context, id uuid.UUID, scopes Scopes) (*ServiceAccount, error) {
+	var acc ServiceAccount
+	err := s.DB.QueryRowContext(ctx, `
+		UPDATE service_accounts SET scopes = $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING id, name, status, created_at`, id, scopes).Scan(&acc.ID, &acc.Name, &acc.Status, &acc.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrServiceAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	acc.Scopes = scopes
+	if s.Redis != nil {
+		if _, err := s.RevokeRegistryTokensFor(ctx, "serviceaccount:"+acc.Name); err != nil {
+			fmt.Printf("[Auth] Failed to revoke tokens of service account %s: %v\n", acc.Name, err)
+		}
+	}
+	return &acc, nil
+}
@@ -6,13 +6,21 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 	"github.com/registryx/registryx/backend/pkg/audit"
+	"github.com/registryx/registryx/backend/pkg/authz"
 	"github.com/registryx/registryx/backend/pkg/email"
+	"github.com/registryx/registryx/backend/pkg/ipallow"
+	"github.com/registryx/registryx/backend/pkg/ldapauth"
+	"github.com/registryx/registryx/backend/pkg/signing"
 )
 
 type ServiceAccount struct {
@@ -23,6 +31,9 @@ type ServiceAccount struct {
 	Status      string    `json:"status"`
 	LastUsedAt  *time.Time `json:"lastUsed"`
 	CreatedAt   time.Time `json:"created"`
+	TokenTTLSeconds *int  `json:"tokenTtlSeconds,omitempty"` // registry token lifetime override
+	AllowedCIDRs    []string `json:"allowedCidrs"`             // networks it may be used from; empty allows any
+	Scopes          Scopes   `json:"scopes"`                   // repositories and actions it is limited to; empty allows all
 }
 
 type Service struct {
@@ -31,6 +42,55 @@ type Service struct {
 	Audit     *audit.Service
 	Redis     *redis.Client
 	JWTSecret string
+	Authz     *authz.Authorizer // repository access for token scopes; set by main
+	Keys      *signing.Keyring  // signs tokens with the current primary key; set by main
+	Observer  AccessObserver    // told about sign-ins, e.g. for anomaly detection; set by main
+	Networks  *ipallow.Checker  // enforces service account allowlists at sign-in; set by main
+
+	// LDAP checks passwords against a directory before local accounts; set
+	// by main, nil uses local accounts only.
+	LDAP *ldapauth.Authenticator
+
+	// Token lifetimes; zero uses the defaults below. Set by main.
+	TokenTTL   time.Duration
+	SessionTTL time.Duration
+}
+
+// AccessObserver is told about sign-ins, and refuses them from clients that
+// keep failing. principal is a user ID or "serviceaccount:<name>"; method is
+// how the credentials were presented.
+type AccessObserver interface {
+	LoginSucceeded(r *http.Request, principal, name string)
+	LoginFailed(r *http.Request, username, method, reason string)
+	LoginBlocked(r *http.Request) time.Duration // > 0 refuses the sign-in for that long
+}
+
+const (
+	DefaultTokenTTL   = time.Hour
+	DefaultSessionTTL = 24 * time.Hour
+)
+
+func (s *Service) tokenTTL() time.Duration {
+	if s.TokenTTL > 0 {
+		return s.TokenTTL
+	}
+	return DefaultTokenTTL
+}
+
+// sign signs a token with the keyring, or with JWT_SECRET when there is none.
+func (s *Service) sign(token *jwt.Token) (string, error) {
+	if s.Keys != nil {
+		return s.Keys.Sign(token)
+	}
+	return token.SignedString([]byte(s.JWTSecret))
+}
+
+// SessionLifetime is how long dashboard sessions last.
+func (s *Service) SessionLifetime() time.Duration {
+	if s.SessionTTL > 0 {
+		return s.SessionTTL
+	}
+	return DefaultSessionTTL
 }
 
 func NewService(db *sql.DB, email *email.Service, audit *audit.Service, redisClient *redis.Client, jwtSecret string) *Service {
@@ -39,7 +99,10 @@ func NewService(db *sql.DB, email *email.Service, audit *audit.Service, redisCli
 
 // Create generates a new service account and API Key.
 // Returns the ServiceAccount object and the raw API Key (only time it's seen).
-func (s *Service) Create(ctx context.Context, name, description string) (*ServiceAccount, string, error) {
+// tokenTTLSeconds overrides the registry token lifetime when not nil;
+// allowedCIDRs (normalized, see ipallow.Normalize) restricts where it may be
+// used from, and scopes (see NormalizeScopes) what it may use.
+func (s *Service) Create(ctx context.Context, name, description string, tokenTTLSeconds *int, allowedCIDRs []string, scopes Scopes) (*ServiceAccount, string, error) {
 	// 1. Generate Key
 	rawKey, err := generateRandomString(32)
 	if err != nil {
@@ -56,9 +119,9 @@ func (s *Service) Create(ctx context.Context, name, description string) (*Servic
 	id := uuid.New()
 	now := time.Now()
 	_, err = s.DB.ExecContext(ctx, `
-		INSERT INTO service_accounts (id, name, description, api_key_hash, prefix, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, 'active', $6, $6)`,
-		id, name, description, keyHash, "rx_"+rawKey[:4], now)
+		INSERT INTO service_accounts (id, name, description, api_key_hash, prefix, status, token_ttl_seconds, allowed_cidrs, scopes, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, 'active', $6, $7, $8, $9, $9)`,
+		id, name, description, keyHash, "rx_"+rawKey[:4], tokenTTLSeconds, pq.Array(allowedCIDRs), scopes, now)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to insert service account: %w", err)
 	}
@@ -69,13 +132,16 @@ func (s *Service) Create(ctx context.Context, name, description string) (*Servic
 		Description: description,
 		Status:      "active",
 		CreatedAt:   now,
+		TokenTTLSeconds: tokenTTLSeconds,
+		AllowedCIDRs:    allowedCIDRs,
+		Scopes:          scopes,
 	}, apiKey, nil
 }
 
 // List returns all service accounts.
 func (s *Service) List(ctx context.Context) ([]ServiceAccount, error) {
 	rows, err := s.DB.QueryContext(ctx, `
-		SELECT id, name, description, status, last_used_at, created_at 
+		SELECT id, name, description, status, last_used_at, created_at, token_ttl_seconds, COALESCE(allowed_cidrs, '{}'), scopes
 		FROM service_accounts ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
@@ -87,9 +153,14 @@ func (s *Service) List(ctx context.Context) ([]ServiceAccount, error) {
 		var acc ServiceAccount
 		var lastUsed sql.NullTime
 		var desc sql.NullString
-		if err := rows.Scan(&acc.ID, &acc.Name, &desc, &acc.Status, &lastUsed, &acc.CreatedAt); err != nil {
+		var ttl sql.NullInt32
+		if err := rows.Scan(&acc.ID, &acc.Name, &desc, &acc.Status, &lastUsed, &acc.CreatedAt, &ttl, pq.Array(&acc.AllowedCIDRs), &acc.Scopes); err != nil {
 			return nil, err
 		}
+		if ttl.Valid {
+			v := int(ttl.Int32)
+			acc.TokenTTLSeconds = &v
+		}
 		if lastUsed.Valid {
 			acc.LastUsedAt = &lastUsed.Time
 		}
@@ -101,10 +172,71 @@ func (s *Service) List(ctx context.Context) ([]ServiceAccount, error) {
 	return accounts, nil
 }
 
-// Revoke changes status to revoked.
+// Revoke changes status to revoked and revokes the registry tokens the
+// account still holds.
 func (s *Service) Revoke(ctx context.Context, id uuid.UUID) error {
-	_, err := s.DB.ExecContext(ctx, "UPDATE service_accounts SET status = 'revoked', updated_at = NOW() WHERE id = $1", id)
-	return err
+	var name string
+	err := s.DB.QueryRowContext(ctx, "UPDATE service_accounts SET status = 'revoked', updated_at = NOW() WHERE id = $1 RETURNING name", id).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.Redis != nil {
+		if _, err := s.RevokeRegistryTokensFor(ctx, "serviceaccount:"+name); err != nil {
+			fmt.Printf("[Auth] Failed to revoke tokens of service account %s: %v\n", name, err)
+		}
+	}
+	return nil
+}
+
+// ErrServiceAccountNotFound is returned for service accounts that don't exist.
+var ErrServiceAccountNotFound = errors.New("service account not found")
+
+// SetAllowedNetworks replaces the networks a service account may be used
+// from. cidrs must be normalized; empty allows any address.
+func (s *Service) SetAllowedNetworks(ctx context.Context, id uuid.UUID, cidrs []string) (*ServiceAccount, error) {
+	var acc ServiceAccount
+	err := s.DB.QueryRowContext(ctx, `
+		UPDATE service_accounts SET allowed_cidrs = $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING id, name, status, created_at`, id, pq.Array(cidrs)).Scan(&acc.ID, &acc.Name, &acc.Status, &acc.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrServiceAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	acc.AllowedCIDRs = cidrs
+	if s.Networks != nil {
+		s.Networks.ForgetServiceAccount(acc.Name)
+	}
+	return &acc, nil
+}
+
+// ValidateServiceAccount checks an API key presented with the account name
+// and records the use. Revoked accounts are rejected.
+func (s *Service) ValidateServiceAccount(ctx context.Context, name, apiKey string) (*ServiceAccount, error) {
+	hash := sha256.Sum256([]byte(apiKey))
+	var acc ServiceAccount
+	var ttl sql.NullInt32
+	err := s.DB.QueryRowContext(ctx, `
+		UPDATE service_accounts SET last_used_at = NOW()
+		WHERE name = $1 AND api_key_hash = $2 AND status = 'active'
+		RETURNING id, name, status, created_at, token_ttl_seconds, COALESCE(allowed_cidrs, '{}'), scopes`,
+		name, hex.EncodeToString(hash[:])).Scan(&acc.ID, &acc.Name, &acc.Status, &acc.CreatedAt, &ttl, pq.Array(&acc.AllowedCIDRs), &acc.Scopes)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ttl.Valid {
+		v := int(ttl.Int32)
+		acc.TokenTTLSeconds = &v
+	}
+	return &acc, nil
 }
 
 func generateRandomString(n int) (string, error) {
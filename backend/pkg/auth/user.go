@@ -30,9 +30,11 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	Token       string `json:"token"`
-	User        User   `json:"user"`
-	RecoveryKey string `json:"recovery_key,omitempty"` // Only returned on creation
+	Token       string     `json:"token"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn   int        `json:"expires_in,omitempty"` // seconds until the session ends
+	User        User       `json:"user"`
+	RecoveryKey string     `json:"recovery_key,omitempty"` // Only returned on creation
 }
 
 func HashPassword(password string) (string, error) {
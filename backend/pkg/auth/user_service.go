@@ -114,37 +114,22 @@ func (s *Service) ResetPasswordWithKey(ctx context.Context, email, key, newPassw
     return s.UpdatePassword(ctx, userID, newPassword)
 }
 
-// LoginUser authenticates a user and returns a JWT token.
-func (s *Service) LoginUser(ctx context.Context, username, password string) (*User, string, error) {
-	var user User
-	err := s.DB.QueryRowContext(ctx, `
-		SELECT id, username, email, password_hash, role, created_at, updated_at 
-		FROM users WHERE username=$1`, username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.UpdatedAt)
-	
-	if err == sql.ErrNoRows {
-		fmt.Printf("[Auth] Login failed: user '%s' not found\n", username)
-		return nil, "", errors.New("invalid credentials")
-	} else if err != nil {
-		fmt.Printf("[Auth] Login DB error for '%s': %v\n", username, err)
-		return nil, "", err
-	}
-
-	fmt.Printf("[Auth] Login attempt for '%s', hash length: %d\n", username, len(user.PasswordHash))
-	if !CheckPasswordHash(password, user.PasswordHash) {
-		fmt.Printf("[Auth] Login failed: password mismatch for '%s'\n", username)
-		return nil, "", errors.New("invalid credentials")
+// LoginUser authenticates a user and returns a JWT token and its expiry.
+func (s *Service) LoginUser(ctx context.Context, username, password string) (*User, string, time.Time, error) {
+	user, method, err := s.checkPassword(ctx, username, password)
+	if err != nil {
+		return nil, "", time.Time{}, err
 	}
 	fmt.Printf("[Auth] Login successful for '%s'\n", username)
 	
 	// Audit Log
 	if s.Audit != nil {
-		_ = s.Audit.Log(ctx, user.ID, "LOGIN", nil, map[string]interface{}{"method": "password"})
+		_ = s.Audit.Log(ctx, user.ID, "LOGIN", nil, map[string]interface{}{"method": method})
 	}
 
 	// Generate Token with Session ID (JTI)
 	sessionID := uuid.New().String()
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := time.Now().Add(s.SessionLifetime())
 	
 	claims := &Claims{
 		UserID: user.ID,
@@ -159,9 +144,9 @@ func (s *Service) LoginUser(ctx context.Context, username, password string) (*Us
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-	tokenString, err := token.SignedString([]byte(s.JWTSecret))
+	tokenString, err := s.sign(token)
 	if err != nil {
-		return nil, "", err
+		return nil, "", time.Time{}, err
 	}
 
 	// Store Session in Redis
@@ -177,13 +162,13 @@ func (s *Service) LoginUser(ctx context.Context, username, password string) (*Us
 		err := s.Redis.HMSet(ctx, sessionKey, sessionData).Err()
 		if err != nil {
 			fmt.Printf("[Auth] Failed to store session in Redis: %v\n", err)
-			return nil, "", fmt.Errorf("session initialization failed")
+			return nil, "", time.Time{}, fmt.Errorf("session initialization failed")
 		}
-		s.Redis.Expire(ctx, sessionKey, 24*time.Hour)
+		s.Redis.Expire(ctx, sessionKey, s.SessionLifetime())
 		fmt.Printf("[Auth] Created session %s for user %s\n", sessionID, user.Username)
 	}
 
-	return &user, tokenString, nil
+	return user, tokenString, expirationTime, nil
 }
 
 // Logout invalidates a user session.
@@ -253,22 +238,8 @@ func (s *Service) RevokeSession(ctx context.Context, sessionID string) error {
 
 // ValidateCredentials checks username and password and returns the User if valid.
 func (s *Service) ValidateCredentials(ctx context.Context, username, password string) (*User, error) {
-	var user User
-	err := s.DB.QueryRowContext(ctx, `
-		SELECT id, username, email, password_hash, role 
-		FROM users WHERE username=$1`, username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Role)
-	
-	if err == sql.ErrNoRows {
-		return nil, errors.New("invalid credentials")
-	} else if err != nil {
-		return nil, err
-	}
-
-	if !CheckPasswordHash(password, user.PasswordHash) {
-		return nil, errors.New("invalid credentials")
-	}
-	return &user, nil
+	user, _, err := s.checkPassword(ctx, username, password)
+	return user, err
 }
 
 // UpdatePassword updates the user's password.
@@ -281,12 +252,16 @@ func (s *Service) UpdatePassword(ctx context.Context, userID uuid.UUID, newPassw
 	}
 
 	fmt.Printf("[Auth] UpdatePassword generated hash length: %d\n", len(hash))
-	result, err := s.DB.ExecContext(ctx, "UPDATE users SET password_hash=$1, updated_at=$2 WHERE id=$3", hash, time.Now(), userID)
+	result, err := s.DB.ExecContext(ctx, "UPDATE users SET password_hash=$1, updated_at=$2 WHERE id=$3 AND auth_source=$4", hash, time.Now(), userID, SourceLocal)
 	if err != nil {
 		fmt.Printf("[Auth] UpdatePassword DB error: %v\n", err)
 		return err
 	}
 	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		// LDAP users change their password in the directory
+		return ErrDirectoryUser
+	}
 	fmt.Printf("[Auth] UpdatePassword successful, rows affected: %d\n", rowsAffected)
 	return nil
 }
@@ -294,7 +269,7 @@ func (s *Service) UpdatePassword(ctx context.Context, userID uuid.UUID, newPassw
 // RequestPasswordReset generates a reset token for the given email
 func (s *Service) RequestPasswordReset(ctx context.Context, email string) (string, error) {
 	var userID uuid.UUID
-	err := s.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE email=$1", email).Scan(&userID)
+	err := s.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE email=$1 AND auth_source=$2", email, SourceLocal).Scan(&userID)
 	if err == sql.ErrNoRows {
 		// Return nil error to prevent email enumeration, but return empty token
 		return "", nil
@@ -355,3 +330,13 @@ func (s *Service) ResetPassword(ctx context.Context, token, newPassword string)
 
 	return nil
 }
+
+// LookupUserID returns the ID of the user with the given username.
+func (s *Service) LookupUserID(ctx context.Context, username string) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := s.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE username=$1", username).Scan(&id)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, errors.New("user not found")
+	}
+	return id, err
+}
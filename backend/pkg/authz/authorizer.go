@@ -0,0 +1,284 @@
+// Package authz decides what a caller may do with a repository. Access comes
+// from grants in repository_permissions, from the namespace roles of the
+// caller's teams and from owning the repository's namespace; admins may do
+// anything.
+package authz
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// Role is a level of access to a repository. Each role includes the ones
+// below it.
+type Role string
+
+const (
+	RoleNone  Role = ""
+	RoleRead  Role = "read"  // pull, view scans and details
+	RoleWrite Role = "write" // push, delete tags and manifests
+	RoleAdmin Role = "admin" // delete the repository, manage its grants
+)
+
+var rank = map[Role]int{RoleRead: 1, RoleWrite: 2, RoleAdmin: 3}
+
+// ParseRole validates a role name.
+func ParseRole(s string) (Role, bool) {
+	r := Role(s)
+	_, ok := rank[r]
+	return r, ok
+}
+
+// Includes reports whether r grants at least need.
+func (r Role) Includes(need Role) bool {
+	return rank[need] > 0 && rank[r] >= rank[need]
+}
+
+// Principal types a grant can be made to.
+const (
+	PrincipalUser = "user"
+	PrincipalTeam = "team"
+)
+
+// Subject is the caller being authorized.
+type Subject struct {
+	UserID uuid.UUID // uuid.Nil for anonymous callers
+	Admin  bool
+}
+
+// SubjectFromContext builds the subject from the values AuthMiddleware set.
+func SubjectFromContext(ctx context.Context) Subject {
+	userID, _ := ctx.Value(middleware.UserKey).(string)
+	role, _ := ctx.Value(middleware.RoleKey).(string)
+	id, _ := uuid.Parse(userID)
+	return Subject{UserID: id, Admin: role == "admin"}
+}
+
+// SplitRepository splits "namespace/name" into its parts; bare names live in
+// the library namespace.
+func SplitRepository(repoName string) (namespace, name string) {
+	if parts := strings.SplitN(repoName, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "library", repoName
+}
+
+// RepositoryFilter returns a SQL condition limiting the repositories aliased
+// as r to those on which the user bound to param (e.g. "$1") holds at least
+// the needed role, directly or through a team's repository or namespace
+// role.
+func RepositoryFilter(param string, need Role) string {
+	return RepositoryFilterAs("r", param, need)
+}
+
+// RepositoryFilterAs is RepositoryFilter for repositories aliased as alias,
+// for queries joining repositories more than once.
+func RepositoryFilterAs(alias, param string, need Role) string {
+	var roles []string
+	for r, n := range rank {
+		if n >= rank[need] {
+			roles = append(roles, "'"+string(r)+"'")
+		}
+	}
+	sort.Strings(roles)
+	return fmt.Sprintf(`(%[3]s.id IN (SELECT repository_id FROM repository_permissions WHERE principal_type = 'user' AND principal_id = %[1]s AND role IN (%[2]s))
+		OR %[3]s.id IN (SELECT p.repository_id FROM repository_permissions p JOIN team_members tm ON tm.team_id = p.principal_id
+			WHERE p.principal_type = 'team' AND tm.user_id = %[1]s AND p.role IN (%[2]s))
+		OR %[3]s.namespace_id IN (SELECT t.namespace_id FROM teams t JOIN team_members tm ON tm.team_id = t.id
+			WHERE tm.user_id = %[1]s AND t.namespace_role IN (%[2]s))
+		OR %[3]s.namespace_id IN (SELECT id FROM namespaces WHERE owner_id = %[1]s))`, param, strings.Join(roles, ", "), alias)
+}
+
+// Grant is one entry of a repository's access list.
+type Grant struct {
+	PrincipalType string `json:"principalType"`
+	PrincipalID   string `json:"principalId"`
+	Principal     string `json:"principal"` // username, or team name
+	Role          Role   `json:"role"`
+}
+
+type Authorizer struct {
+	DB *sql.DB
+}
+
+func NewAuthorizer(db *sql.DB) *Authorizer {
+	return &Authorizer{DB: db}
+}
+
+// RoleFor returns the subject's effective role on a repository: the highest
+// of its grants, those of its teams and its teams' roles on the namespace,
+// or admin for the owner of the namespace. Owners and namespace roles also
+// apply to repositories that do not exist yet, so they can be created.
+func (a *Authorizer) RoleFor(ctx context.Context, s Subject, repoName string) (Role, error) {
+	if s.Admin {
+		return RoleAdmin, nil
+	}
+	if s.UserID == uuid.Nil {
+		return RoleNone, nil
+	}
+	ns, name := SplitRepository(repoName)
+	rows, err := a.DB.QueryContext(ctx, `
+		SELECT 'admin' FROM namespaces WHERE name = $1 AND owner_id = $3
+		UNION ALL
+		SELECT p.role FROM repository_permissions p
+		JOIN repositories r ON r.id = p.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		WHERE n.name = $1 AND r.name = $2 AND p.principal_type = 'user' AND p.principal_id = $3
+		UNION ALL
+		SELECT p.role FROM repository_permissions p
+		JOIN team_members tm ON tm.team_id = p.principal_id
+		JOIN repositories r ON r.id = p.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		WHERE n.name = $1 AND r.name = $2 AND p.principal_type = 'team' AND tm.user_id = $3
+		UNION ALL
+		SELECT t.namespace_role FROM teams t
+		JOIN team_members tm ON tm.team_id = t.id
+		JOIN namespaces n ON n.id = t.namespace_id
+		WHERE n.name = $1 AND tm.user_id = $3 AND t.namespace_role IS NOT NULL`,
+		ns, name, s.UserID)
+	if err != nil {
+		return RoleNone, err
+	}
+	return highest(rows)
+}
+
+// NamespaceRole returns the subject's role on a namespace as a whole: admin
+// for admins and the owner, otherwise the highest namespace role of its
+// teams.
+func (a *Authorizer) NamespaceRole(ctx context.Context, s Subject, namespace string) (Role, error) {
+	if s.Admin {
+		return RoleAdmin, nil
+	}
+	if s.UserID == uuid.Nil {
+		return RoleNone, nil
+	}
+	rows, err := a.DB.QueryContext(ctx, `
+		SELECT 'admin' FROM namespaces WHERE name = $1 AND owner_id = $2
+		UNION ALL
+		SELECT t.namespace_role FROM teams t
+		JOIN team_members tm ON tm.team_id = t.id
+		JOIN namespaces n ON n.id = t.namespace_id
+		WHERE n.name = $1 AND tm.user_id = $2 AND t.namespace_role IS NOT NULL`, namespace, s.UserID)
+	if err != nil {
+		return RoleNone, err
+	}
+	return highest(rows)
+}
+
+// highest returns the highest of the roles in rows and closes them.
+func highest(rows *sql.Rows) (Role, error) {
+	defer rows.Close()
+	best := RoleNone
+	for rows.Next() {
+		var r Role
+		if err := rows.Scan(&r); err != nil {
+			return RoleNone, err
+		}
+		if rank[r] > rank[best] {
+			best = r
+		}
+	}
+	return best, rows.Err()
+}
+
+// Allowed reports whether the subject holds at least the needed role.
+func (a *Authorizer) Allowed(ctx context.Context, s Subject, repoName string, need Role) (bool, error) {
+	role, err := a.RoleFor(ctx, s, repoName)
+	if err != nil {
+		return false, err
+	}
+	return role.Includes(need), nil
+}
+
+// Require checks the request's caller against a repository and writes the
+// error response when access is denied. Handlers return when it is false.
+func (a *Authorizer) Require(w http.ResponseWriter, r *http.Request, repoName string, need Role) bool {
+	s := SubjectFromContext(r.Context())
+	if s.UserID == uuid.Nil && !s.Admin {
+		http.Error(w, "Unauthorized: Authentication required", http.StatusUnauthorized)
+		return false
+	}
+	ok, err := a.Allowed(r.Context(), s, repoName, need)
+	if err != nil {
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("Forbidden: %s access to %s required", need, repoName), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ListGrants returns the explicit grants on a repository.
+func (a *Authorizer) ListGrants(ctx context.Context, repoName string) ([]Grant, error) {
+	ns, name := SplitRepository(repoName)
+	rows, err := a.DB.QueryContext(ctx, `
+		SELECT DISTINCT p.principal_type, p.principal_id, COALESCE(u.username, t.name, ''), p.role
+		FROM repository_permissions p
+		JOIN repositories r ON r.id = p.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		LEFT JOIN users u ON p.principal_type = 'user' AND u.id = p.principal_id
+		LEFT JOIN teams t ON p.principal_type = 'team' AND t.id = p.principal_id
+		WHERE n.name = $1 AND r.name = $2
+		ORDER BY p.principal_type, 3`, ns, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []Grant{}
+	for rows.Next() {
+		var g Grant
+		if err := rows.Scan(&g.PrincipalType, &g.PrincipalID, &g.Principal, &g.Role); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// SetGrant grants or changes a principal's role on a repository. It returns
+// sql.ErrNoRows when the repository does not exist.
+func (a *Authorizer) SetGrant(ctx context.Context, repoName, principalType string, principalID uuid.UUID, role Role, grantedBy uuid.UUID) error {
+	ns, name := SplitRepository(repoName)
+	res, err := a.DB.ExecContext(ctx, `
+		INSERT INTO repository_permissions (repository_id, principal_type, principal_id, role, granted_by)
+		SELECT r.id, $3, $4, $5, $6
+		FROM repositories r JOIN namespaces n ON n.id = r.namespace_id
+		WHERE n.name = $1 AND r.name = $2
+		ON CONFLICT (repository_id, principal_type, principal_id) DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by`,
+		ns, name, principalType, principalID, string(role), uuid.NullUUID{UUID: grantedBy, Valid: grantedBy != uuid.Nil})
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RemoveGrant revokes a principal's grant on a repository.
+func (a *Authorizer) RemoveGrant(ctx context.Context, repoName, principalType string, principalID uuid.UUID) error {
+	ns, name := SplitRepository(repoName)
+	res, err := a.DB.ExecContext(ctx, `
+		DELETE FROM repository_permissions p
+		USING repositories r, namespaces n
+		WHERE p.repository_id = r.id AND n.id = r.namespace_id
+		  AND n.name = $1 AND r.name = $2 AND p.principal_type = $3 AND p.principal_id = $4`,
+		ns, name, principalType, principalID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
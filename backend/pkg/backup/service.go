@@ -0,0 +1,415 @@
+// Package backup exports registry metadata to a portable bundle in object
+// storage and restores it, so a lost database can be rebuilt next to the
+// blobs that survived in the bucket.
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// Prefix is where bundles are kept in the storage bucket.
+const Prefix = "backups/"
+
+// FormatVersion is bumped when the bundle layout changes incompatibly.
+const FormatVersion = 1
+
+// ErrNotEmpty is returned when restoring over a database that already has repositories.
+var ErrNotEmpty = errors.New("restore target already contains repositories")
+
+// table describes one exported table. Tables are listed in foreign key order
+// so a restore can insert them front to back.
+type table struct {
+	Name  string
+	Query string // returns one jsonb row per record
+}
+
+var tables = []table{
+	// Owners are exported by username: user IDs differ between installations.
+	{"namespaces", `SELECT to_jsonb(n) || jsonb_build_object('owner_username', u.username)
+		FROM namespaces n LEFT JOIN users u ON u.id = n.owner_id ORDER BY n.created_at`},
+	{"repositories", `SELECT to_jsonb(r) || jsonb_build_object('owner_username', u.username)
+		FROM repositories r LEFT JOIN users u ON u.id = r.owner_id ORDER BY r.created_at`},
+	{"blobs", `SELECT to_jsonb(t) FROM blobs t ORDER BY t.digest`},
+	{"manifests", `SELECT to_jsonb(t) FROM manifests t ORDER BY t.created_at`},
+	{"manifest_layers", `SELECT to_jsonb(t) FROM manifest_layers t`},
+	{"manifest_subjects", `SELECT to_jsonb(t) FROM manifest_subjects t`},
+	{"tags", `SELECT to_jsonb(t) FROM tags t ORDER BY t.created_at`},
+	{"image_dependencies", `SELECT to_jsonb(t) FROM image_dependencies t`},
+	{"teams", `SELECT to_jsonb(t) - 'created_by' FROM teams t ORDER BY t.created_at`},
+	{"team_members", `SELECT (to_jsonb(m) - 'user_id') || jsonb_build_object('member_username', u.username)
+		FROM team_members m JOIN users u ON u.id = m.user_id`},
+	// Team grants keep their team IDs, which are exported with the teams.
+	{"repository_permissions", `SELECT (to_jsonb(p) - 'granted_by') || jsonb_build_object('principal_username', u.username)
+		FROM repository_permissions p LEFT JOIN users u ON p.principal_type = 'user' AND u.id = p.principal_id
+		WHERE p.principal_type = 'team' OR u.id IS NOT NULL`},
+	// Scan summaries only; full reports are regenerated by rescanning.
+	{"vulnerability_reports", `SELECT to_jsonb(t) - 'report_json' FROM vulnerability_reports t ORDER BY t.scanned_at`},
+}
+
+// Bundle is the on-disk backup format (gzipped JSON).
+type Bundle struct {
+	Format    int         `json:"format"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Tables    []TableDump `json:"tables"`
+}
+
+// TableDump holds every row of one table as a JSON object.
+type TableDump struct {
+	Name string            `json:"name"`
+	Rows []json.RawMessage `json:"rows"`
+}
+
+// Info describes a stored bundle.
+type Info struct {
+	Path      string         `json:"path"`
+	Size      int64          `json:"size"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Rows      map[string]int `json:"rows,omitempty"`
+}
+
+type Service struct {
+	DB        *sql.DB
+	Storage   storage.Driver
+	Retention int // bundles kept after a scheduled or manual export (0 = keep all)
+}
+
+func NewService(db *sql.DB, store storage.Driver, retention int) *Service {
+	return &Service{DB: db, Storage: store, Retention: retention}
+}
+
+// Export dumps all registry metadata to a new bundle and prunes old bundles.
+func (s *Service) Export(ctx context.Context) (*Info, error) {
+	// One snapshot for every table so the bundle is internally consistent.
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bundle := Bundle{Format: FormatVersion, CreatedAt: time.Now().UTC()}
+	info := &Info{CreatedAt: bundle.CreatedAt, Rows: make(map[string]int)}
+	for _, t := range tables {
+		dump, err := dumpTable(ctx, tx, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", t.Name, err)
+		}
+		bundle.Tables = append(bundle.Tables, dump)
+		info.Rows[t.Name] = len(dump.Rows)
+	}
+
+	info.Path = Prefix + "metadata-" + bundle.CreatedAt.Format("20060102T150405Z") + ".json.gz"
+	writer, err := s.Storage.Writer(ctx, info.Path)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(writer)
+	if err := json.NewEncoder(gz).Encode(bundle); err != nil {
+		abort(writer)
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		abort(writer)
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if size, err := s.Storage.Stat(ctx, info.Path); err == nil {
+		info.Size = size
+	}
+
+	fmt.Printf("[Backup] Exported metadata to %s (%d bytes)\n", info.Path, info.Size)
+	s.prune(ctx)
+	return info, nil
+}
+
+func dumpTable(ctx context.Context, tx *sql.Tx, t table) (TableDump, error) {
+	dump := TableDump{Name: t.Name, Rows: []json.RawMessage{}}
+	rows, err := tx.QueryContext(ctx, t.Query)
+	if err != nil {
+		return dump, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row []byte
+		if err := rows.Scan(&row); err != nil {
+			return dump, err
+		}
+		dump.Rows = append(dump.Rows, row)
+	}
+	return dump, rows.Err()
+}
+
+func abort(w io.WriteCloser) {
+	if a, ok := w.(storage.Aborter); ok {
+		a.Abort()
+	}
+}
+
+// List returns stored bundles, newest first.
+func (s *Service) List(ctx context.Context) ([]Info, error) {
+	lister, ok := s.Storage.(storage.Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage driver cannot list objects")
+	}
+	var infos []Info
+	err := lister.List(ctx, Prefix, func(p string, size int64) error {
+		name := strings.TrimSuffix(strings.TrimPrefix(path.Base(p), "metadata-"), ".json.gz")
+		created, err := time.Parse("20060102T150405Z", name)
+		if err != nil {
+			return nil // not a bundle
+		}
+		infos = append(infos, Info{Path: p, Size: size, CreatedAt: created})
+		return nil
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt.After(infos[j].CreatedAt) })
+	return infos, err
+}
+
+// prune deletes bundles beyond the retention count.
+func (s *Service) prune(ctx context.Context) {
+	if s.Retention <= 0 {
+		return
+	}
+	infos, err := s.List(ctx)
+	if err != nil {
+		fmt.Printf("[Backup] Failed to list bundles for pruning: %v\n", err)
+		return
+	}
+	for i := s.Retention; i < len(infos); i++ {
+		if err := s.Storage.Delete(ctx, infos[i].Path); err != nil {
+			fmt.Printf("[Backup] Failed to delete old bundle %s: %v\n", infos[i].Path, err)
+		}
+	}
+}
+
+// Restore loads a bundle into the database in a single transaction. Unless
+// merge is set the database must not contain repositories yet; with merge,
+// rows that already exist are kept as they are. It returns rows inserted per table.
+func (s *Service) Restore(ctx context.Context, bundlePath string, merge bool) (map[string]int64, error) {
+	if !strings.HasPrefix(bundlePath, Prefix) || strings.Contains(bundlePath, "..") {
+		return nil, fmt.Errorf("invalid bundle path")
+	}
+	bundle, err := s.load(ctx, bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Format != FormatVersion {
+		return nil, fmt.Errorf("unsupported bundle format %d", bundle.Format)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if !merge {
+		var count int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM repositories").Scan(&count); err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, ErrNotEmpty
+		}
+	}
+
+	dumps := make(map[string]TableDump, len(bundle.Tables))
+	for _, d := range bundle.Tables {
+		dumps[d.Name] = d
+	}
+
+	inserted := make(map[string]int64)
+	for _, t := range tables {
+		dump, ok := dumps[t.Name]
+		if !ok || len(dump.Rows) == 0 {
+			continue
+		}
+		n, err := restoreTable(ctx, tx, t.Name, dump.Rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", t.Name, err)
+		}
+		inserted[t.Name] = n
+	}
+
+	// Bundles made before repository grants existed only carry owners.
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO repository_permissions (repository_id, principal_type, principal_id, role)
+		SELECT id, 'user', owner_id, 'admin' FROM repositories WHERE owner_id IS NOT NULL
+		ON CONFLICT (repository_id, principal_type, principal_id) DO NOTHING`); err != nil {
+		return nil, fmt.Errorf("failed to grant repository owners: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("[Backup] Restored %s: %v\n", bundlePath, inserted)
+	return inserted, nil
+}
+
+func (s *Service) load(ctx context.Context, bundlePath string) (*Bundle, error) {
+	reader, err := s.Storage.Reader(ctx, bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("bundle not found: %w", err)
+	}
+	defer reader.Close()
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	var bundle Bundle
+	if err := json.NewDecoder(gz).Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("corrupt bundle: %w", err)
+	}
+	return &bundle, nil
+}
+
+// restoreTable inserts rows with jsonb_populate_recordset, limited to the
+// columns present in both the bundle and the current schema, so bundles
+// from an older schema still load.
+func restoreTable(ctx context.Context, tx *sql.Tx, name string, raw []json.RawMessage) (int64, error) {
+	existing := make(map[string]bool)
+	rows, err := tx.QueryContext(ctx, `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1`, name)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		existing[col] = true
+	}
+	rows.Close()
+
+	records := make([]map[string]interface{}, 0, len(raw))
+	present := make(map[string]bool)
+	for _, r := range raw {
+		var rec map[string]interface{}
+		if err := json.Unmarshal(r, &rec); err != nil {
+			return 0, err
+		}
+		if _, ok := rec["owner_username"]; ok {
+			if err := resolveOwner(ctx, tx, rec); err != nil {
+				return 0, err
+			}
+		}
+		if username, ok := rec["principal_username"].(string); ok {
+			delete(rec, "principal_username")
+			id, err := lookupUser(ctx, tx, username)
+			if err != nil {
+				return 0, err
+			}
+			if id == "" {
+				fmt.Printf("[Backup] User %q not found, dropping their repository grant\n", username)
+				continue
+			}
+			rec["principal_id"] = id
+		}
+		if username, ok := rec["member_username"].(string); ok {
+			delete(rec, "member_username")
+			id, err := lookupUser(ctx, tx, username)
+			if err != nil {
+				return 0, err
+			}
+			if id == "" {
+				fmt.Printf("[Backup] User %q not found, dropping their team membership\n", username)
+				continue
+			}
+			rec["user_id"] = id
+		}
+		for col := range rec {
+			if existing[col] {
+				present[col] = true
+			}
+		}
+		records = append(records, rec)
+	}
+
+	var cols []string
+	for col := range present {
+		cols = append(cols, pq.QuoteIdentifier(col))
+	}
+	sort.Strings(cols)
+	if len(cols) == 0 {
+		return 0, nil
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return 0, err
+	}
+	list := strings.Join(cols, ", ")
+	tbl := pq.QuoteIdentifier(name)
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (%s) SELECT %s FROM jsonb_populate_recordset(NULL::%s, $1::jsonb) ON CONFLICT DO NOTHING`,
+		tbl, list, list, tbl), string(data))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// resolveOwner maps an exported owner username to the local user ID,
+// leaving the record unowned when no such user exists.
+func resolveOwner(ctx context.Context, tx *sql.Tx, rec map[string]interface{}) error {
+	username, _ := rec["owner_username"].(string)
+	delete(rec, "owner_username")
+	rec["owner_id"] = nil
+	if username == "" {
+		return nil
+	}
+	id, err := lookupUser(ctx, tx, username)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		fmt.Printf("[Backup] Owner %q of %v not found, restoring it unowned\n", username, rec["name"])
+		return nil
+	}
+	rec["owner_id"] = id
+	return nil
+}
+
+// lookupUser returns the local ID of a username, or "" if there is no such user.
+func lookupUser(ctx context.Context, tx *sql.Tx, username string) (string, error) {
+	var id string
+	err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", username).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// StartScheduler exports a bundle every interval until ctx is cancelled.
+func (s *Service) StartScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Export(ctx); err != nil {
+				fmt.Printf("[Backup] Scheduled export failed: %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package bandwidth throttles blob transfers with token buckets, per
+// connection and per user, so one large push or pull can't take all the
+// bandwidth of a small deployment. Uploads and downloads are limited
+// separately, and limits apply per API instance.
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/clientip"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// chunk bounds how many bytes pass per token bucket wait, so transfers
+// sharing a bucket take turns instead of one draining it.
+const chunk = 32 << 10
+
+// bucket is a token bucket holding up to one second of its rate. Waiters
+// reserve tokens before they have accrued and sleep off the debt, so
+// concurrent transfers are served in arrival order.
+type bucket struct {
+	rate float64 // bytes per second
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate int64) *bucket {
+	return &bucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait blocks until n bytes may pass or ctx is done.
+func (b *bucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitAll waits on every bucket in turn.
+func waitAll(ctx context.Context, buckets []*bucket, n int) error {
+	for _, b := range buckets {
+		if err := b.wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userBuckets are the buckets a user's transfers share while any is running.
+type userBuckets struct {
+	up, down *bucket
+	active   int
+}
+
+// Limiter throttles the requests passing through its middleware. Rates are
+// bytes per second; 0 leaves that limit off. A nil *Limiter allows
+// everything.
+type Limiter struct {
+	PerConnection int64 // each request, each direction
+	PerUser       int64 // all of a user's requests together, each direction
+
+	mu    sync.Mutex
+	users map[string]*userBuckets
+}
+
+func NewLimiter(perConnection, perUser int64) *Limiter {
+	return &Limiter{PerConnection: perConnection, PerUser: perUser, users: make(map[string]*userBuckets)}
+}
+
+// Middleware throttles the request body and the response of the route it
+// wraps. It must run inside the auth middleware to tell users apart;
+// anonymous clients are limited per address.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l == nil || (l.PerConnection <= 0 && l.PerUser <= 0) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var up, down []*bucket
+		if l.PerConnection > 0 {
+			up = append(up, newBucket(l.PerConnection))
+			down = append(down, newBucket(l.PerConnection))
+		}
+		if l.PerUser > 0 {
+			key := userKey(r)
+			user := l.acquire(key)
+			defer l.release(key)
+			up = append(up, user.up)
+			down = append(down, user.down)
+		}
+
+		if r.Body != nil {
+			r.Body = &reader{ReadCloser: r.Body, ctx: r.Context(), buckets: up}
+		}
+		next.ServeHTTP(&writer{ResponseWriter: w, ctx: r.Context(), buckets: down}, r)
+	})
+}
+
+// userKey identifies whose allowance a request draws on.
+func userKey(r *http.Request) string {
+	if sub, _ := r.Context().Value(middleware.UserKey).(string); sub != "" && sub != "anonymous" {
+		return "user:" + sub
+	}
+	return "ip:" + clientip.FromRequest(r)
+}
+
+func (l *Limiter) acquire(key string) *userBuckets {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u, ok := l.users[key]
+	if !ok {
+		u = &userBuckets{up: newBucket(l.PerUser), down: newBucket(l.PerUser)}
+		l.users[key] = u
+	}
+	u.active++
+	return u
+}
+
+// release drops a user's buckets once their last transfer ends, so the map
+// only holds users with transfers running.
+func (l *Limiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if u, ok := l.users[key]; ok {
+		if u.active--; u.active == 0 {
+			delete(l.users, key)
+		}
+	}
+}
+
+// reader throttles a request body.
+type reader struct {
+	io.ReadCloser
+	ctx     context.Context
+	buckets []*bucket
+}
+
+func (rd *reader) Read(p []byte) (int, error) {
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := rd.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := waitAll(rd.ctx, rd.buckets, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// writer throttles a response body.
+type writer struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*bucket
+}
+
+func (wr *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunk {
+			n = chunk
+		}
+		if err := waitAll(wr.ctx, wr.buckets, n); err != nil {
+			return written, err
+		}
+		m, err := wr.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,142 @@
+// Package clientip resolves the address a request really came from. Behind a
+// reverse proxy r.RemoteAddr is the proxy's address, so X-Forwarded-For and
+// X-Real-IP are believed, but only when the proxy that sent them is trusted
+// (TRUSTED_PROXIES). Everything keyed by client address — pull limits,
+// network allowlists, lockouts and the audit log — reads it from here.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKey struct{}
+
+// Resolver finds the client address of requests. The zero value trusts no
+// proxy.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// ParseTrusted parses a comma-separated list of proxy networks; a bare
+// address is a single host and "*" trusts every peer (only when nothing can
+// reach the registry except through the proxy).
+func ParseTrusted(spec string) (*Resolver, error) {
+	rs := &Resolver{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+			continue
+		case part == "*":
+			part = "0.0.0.0/0,::/0"
+		case !strings.Contains(part, "/"):
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", part)
+			}
+			if ip.To4() != nil {
+				part += "/32"
+			} else {
+				part += "/128"
+			}
+		}
+		for _, cidr := range strings.Split(part, ",") {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy network %q", cidr)
+			}
+			rs.trusted = append(rs.trusted, n)
+		}
+	}
+	return rs, nil
+}
+
+func (rs *Resolver) isTrusted(ip net.IP) bool {
+	for _, n := range rs.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client address of r. When the peer is a trusted proxy,
+// X-Forwarded-For is read from the right, skipping trusted proxies, so
+// addresses a client prepends itself are ignored; X-Real-IP is used when
+// there is no X-Forwarded-For.
+func (rs *Resolver) Resolve(r *http.Request) string {
+	peer := remoteHost(r.RemoteAddr)
+	peerIP := net.ParseIP(peer)
+	if peerIP == nil || !rs.isTrusted(peerIP) {
+		return peer
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(h, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(remoteHost(hops[i]))
+		if ip == nil {
+			break // garbage; trust nothing further left
+		}
+		if !rs.isTrusted(ip) || i == 0 {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer
+}
+
+// Middleware resolves the client address once and stores it in the request
+// context, and sets r.RemoteAddr to it so request logs show the client rather
+// than the proxy.
+func (rs *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := rs.Resolve(r)
+		forwarded := ip != remoteHost(r.RemoteAddr)
+		ctx := context.WithValue(r.Context(), contextKey{}, ip)
+		r = r.WithContext(ctx)
+		if forwarded {
+			_, port, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				port = "0"
+			}
+			r.RemoteAddr = net.JoinHostPort(ip, port)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FromContext returns the client address stored by Middleware, or "".
+func FromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(contextKey{}).(string)
+	return ip
+}
+
+// FromRequest returns the client address of r: the one Middleware resolved,
+// or the peer address when it didn't run.
+func FromRequest(r *http.Request) string {
+	if ip := FromContext(r.Context()); ip != "" {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.Trim(addr, "[]")
+	}
+	return host
+}
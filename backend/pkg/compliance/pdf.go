@@ -0,0 +1,220 @@
+package compliance
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PDF layout: A4 in points, Helvetica.
+const (
+	pageWidth   = 595
+	pageHeight  = 842
+	margin      = 50
+	fontSize    = 10
+	headingSize = 13
+	leading     = 14
+	wrapAt      = 95 // characters per line at fontSize
+)
+
+// pdfWriter lays out lines of text on pages. It only needs the standard
+// fonts, so reports render without embedding anything.
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func (p *pdfWriter) newPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+	p.y = pageHeight - margin
+}
+
+func (p *pdfWriter) text(font string, size, x float64, s string) {
+	if len(p.pages) == 0 || p.y < margin+leading {
+		p.newPage()
+	}
+	fmt.Fprintf(p.pages[len(p.pages)-1], "BT /%s %g Tf %g %g Td (%s) Tj ET\n", font, size, x, p.y, pdfEscape(s))
+}
+
+func (p *pdfWriter) heading(s string) {
+	if len(p.pages) > 0 && p.y < margin+4*leading {
+		p.newPage() // keep a heading with the lines below it
+	}
+	p.y -= leading / 2
+	p.text("F2", headingSize, margin, s)
+	p.y -= leading + 4
+}
+
+func (p *pdfWriter) line(s string) {
+	p.indented(0, s)
+}
+
+func (p *pdfWriter) indented(indent float64, s string) {
+	for _, l := range wrap(s, wrapAt-int(indent/5)) {
+		p.text("F1", fontSize, margin+indent, l)
+		p.y -= leading
+	}
+}
+
+func (p *pdfWriter) field(label, value string) {
+	p.line(label + ": " + value)
+}
+
+func wrap(s string, width int) []string {
+	var lines []string
+	for len(s) > width {
+		cut := strings.LastIndex(s[:width], " ")
+		if cut <= 0 {
+			cut = width
+		}
+		lines = append(lines, s[:cut])
+		s = strings.TrimLeft(s[cut:], " ")
+	}
+	return append(lines, s)
+}
+
+// pdfEscape makes s a PDF literal string in the standard fonts' encoding.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// bytes assembles the document, numbering pages in their footers.
+func (p *pdfWriter) bytes(title string) []byte {
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	n := len(p.pages)
+	kids := make([]string, n)
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range p.pages {
+		footer := fmt.Sprintf("BT /F1 8 Tf %d %d Td (%s - page %d of %d) Tj ET\n", margin, margin/2, pdfEscape(title), i+1, n)
+		content := page.String() + footer
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 6+2*i))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+	obj(fmt.Sprintf("<< /Title (%s) /Producer (RegistryX) >>", pdfEscape(title)))
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, len(offsets), xref)
+	return out.Bytes()
+}
+
+// PDF renders the report for auditors. keyID names the key the PDF is
+// signed with; the signature itself travels alongside the file.
+func (r *Report) PDF(keyID string) []byte {
+	p := &pdfWriter{}
+	const day = "2006-01-02"
+	p.newPage()
+	p.text("F2", 18, margin, "Compliance report: "+r.Namespace)
+	p.y -= 2 * leading
+	p.field("Generated", r.GeneratedAt.Format("2006-01-02 15:04:05 MST"))
+	if r.GeneratedBy != "" {
+		p.field("Generated by", r.GeneratedBy)
+	}
+	p.field("Period", r.PeriodStart.Format(day)+" to "+r.PeriodEnd.Format(day))
+	p.field("Signing key", keyID+" (Ed25519; verify with the public key from /api/v1/compliance/keys)")
+
+	p.heading("1. Vulnerability scan coverage")
+	p.field("Tagged images", fmt.Sprint(r.Scans.Images))
+	p.field("Scanned", fmt.Sprintf("%d (%.1f%%)", r.Scans.Scanned, r.Scans.Percent))
+	p.field(fmt.Sprintf("Scanned in the last %d days", recentScanDays), fmt.Sprint(r.Scans.ScannedRecently))
+	p.field("Latest scan failed", fmt.Sprint(r.Scans.LastScanFailed))
+	listed(p, "Not scanned", r.Scans.NotScanned, r.Scans.Images-r.Scans.Scanned)
+
+	p.heading("2. Image signature coverage")
+	p.field("Tagged images", fmt.Sprint(r.Signatures.Images))
+	p.field("Signed (cosign)", fmt.Sprintf("%d (%.1f%%)", r.Signatures.Signed, r.Signatures.Percent))
+	listed(p, "Unsigned", r.Signatures.Unsigned, r.Signatures.Images-r.Signatures.Signed)
+
+	p.heading("3. Policy enforcement")
+	p.field("Policy SHA-256", r.Policy.PolicySHA256)
+	p.field("Environment", r.Policy.Environment)
+	p.field("Denials in period", fmt.Sprint(r.Policy.Denials))
+	sources := make([]string, 0, len(r.Policy.BySource))
+	for source, n := range r.Policy.BySource {
+		sources = append(sources, fmt.Sprintf("%s: %d", source, n))
+	}
+	sort.Strings(sources)
+	if len(sources) > 0 {
+		p.field("By source", strings.Join(sources, ", "))
+	}
+	if len(r.Policy.TopViolations) > 0 {
+		p.line("Most frequent violations:")
+		for _, v := range r.Policy.TopViolations {
+			p.indented(15, fmt.Sprintf("%dx %s", v.Count, v.Violation))
+		}
+	}
+
+	p.heading("4. Audit log retention")
+	p.field("Retention", r.Audit.Retention)
+	if r.Audit.OldestEntry != nil {
+		p.field("Oldest entry", r.Audit.OldestEntry.Format(day))
+	}
+	p.field("Entries on this namespace in period", fmt.Sprint(r.Audit.EntriesInPeriod))
+	actions := make([]string, 0, len(r.Audit.Actions))
+	for action := range r.Audit.Actions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		p.indented(15, fmt.Sprintf("%s: %d", action, r.Audit.Actions[action]))
+	}
+
+	p.heading("5. Outstanding critical vulnerabilities")
+	p.field("Images affected", fmt.Sprint(r.CriticalCVEs.Images))
+	p.field("Critical findings", fmt.Sprint(r.CriticalCVEs.Total))
+	for _, f := range r.CriticalCVEs.Findings {
+		fix := "no fix available"
+		if f.FixedVersion != "" {
+			fix = "fixed in " + f.FixedVersion
+		}
+		p.indented(15, fmt.Sprintf("%s  %s %s %s (%s)", f.Image, f.CVE, f.Package, f.InstalledVersion, fix))
+	}
+	if more := r.CriticalCVEs.Total - len(r.CriticalCVEs.Findings); more > 0 {
+		p.indented(15, fmt.Sprintf("... and %d more; see the JSON report", more))
+	}
+
+	return p.bytes("RegistryX compliance report " + r.Namespace)
+}
+
+func listed(p *pdfWriter, label string, items []string, total int) {
+	if total == 0 {
+		return
+	}
+	p.line(label + ":")
+	for _, item := range items {
+		p.indented(15, item)
+	}
+	if more := total - len(items); more > 0 {
+		p.indented(15, fmt.Sprintf("... and %d more", more))
+	}
+}
@@ -0,0 +1,373 @@
+// Package compliance produces signed compliance reports on a namespace for
+// auditors: scan and signature coverage, policy enforcement, audit log
+// retention and the critical CVEs still outstanding.
+package compliance
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/policy"
+)
+
+// ErrNotFound is returned for a namespace that doesn't exist.
+var ErrNotFound = errors.New("namespace not found")
+
+const (
+	maxListed         = 50  // images listed as not scanned or not signed
+	maxFindings       = 200 // critical findings listed
+	recentScanDays    = 7
+	maxViolations     = 10
+	MaxPeriodDays     = 365
+	DefaultPeriodDays = 90
+)
+
+// Report is the evidence on one namespace.
+type Report struct {
+	Namespace   string    `json:"namespace"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	GeneratedBy string    `json:"generatedBy,omitempty"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+
+	Scans        ScanCoverage      `json:"scanCoverage"`
+	Signatures   SignatureCoverage `json:"signatureCoverage"`
+	Policy       PolicyEnforcement `json:"policyEnforcement"`
+	Audit        AuditRetention    `json:"auditRetention"`
+	CriticalCVEs CriticalCVEs      `json:"criticalCves"`
+}
+
+// ScanCoverage counts the namespace's tagged images by scan state.
+type ScanCoverage struct {
+	Images          int      `json:"images"`
+	Scanned         int      `json:"scanned"`
+	ScannedRecently int      `json:"scannedRecently"` // completed scan in the last recentScanDays
+	LastScanFailed  int      `json:"lastScanFailed"`
+	Percent         float64  `json:"percent"`
+	NotScanned      []string `json:"notScanned"`
+}
+
+// SignatureCoverage counts the tagged images with a cosign signature.
+type SignatureCoverage struct {
+	Images   int      `json:"images"`
+	Signed   int      `json:"signed"`
+	Percent  float64  `json:"percent"`
+	Unsigned []string `json:"unsigned"`
+}
+
+// PolicyEnforcement is the policy in force and the pulls and deployments it
+// denied during the period.
+type PolicyEnforcement struct {
+	PolicySHA256  string           `json:"policySha256"`
+	Environment   string           `json:"environment"`
+	Denials       int              `json:"denials"`
+	BySource      map[string]int   `json:"bySource"` // pull, admission
+	TopViolations []ViolationCount `json:"topViolations"`
+}
+
+// ViolationCount is how often a policy violation denied an image.
+type ViolationCount struct {
+	Violation string `json:"violation"`
+	Count     int    `json:"count"`
+}
+
+// AuditRetention describes the audit log: how long it is kept and what was
+// recorded on the namespace during the period.
+type AuditRetention struct {
+	Retention       string         `json:"retention"`
+	OldestEntry     *time.Time     `json:"oldestEntry,omitempty"`
+	EntriesInPeriod int            `json:"entriesInPeriod"`
+	Actions         map[string]int `json:"actions"`
+}
+
+// CriticalCVEs are the critical findings of each image's latest scan.
+type CriticalCVEs struct {
+	Images   int               `json:"images"` // images with at least one
+	Total    int               `json:"total"`
+	Findings []CriticalFinding `json:"findings"`
+}
+
+// CriticalFinding is a critical vulnerability in an image.
+type CriticalFinding struct {
+	Image            string `json:"image"`
+	Digest           string `json:"digest"`
+	CVE              string `json:"cve"`
+	Package          string `json:"package"`
+	InstalledVersion string `json:"installedVersion"`
+	FixedVersion     string `json:"fixedVersion,omitempty"`
+}
+
+// Service builds and signs compliance reports.
+type Service struct {
+	DB         *sql.DB
+	Policy     *policy.Service
+	Signer     *Signer
+	DefaultEnv string // environment of namespaces without one
+}
+
+func NewService(db *sql.DB, pol *policy.Service) *Service {
+	return &Service{DB: db, Policy: pol, Signer: &Signer{DB: db}}
+}
+
+// IsOwner reports whether userID owns the namespace.
+func (s *Service) IsOwner(ctx context.Context, namespace, userID string) (bool, error) {
+	var owner sql.NullString
+	err := s.DB.QueryRowContext(ctx, `SELECT owner_id::text FROM namespaces WHERE name = $1`, namespace).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return false, ErrNotFound
+	}
+	return owner.Valid && owner.String == userID, err
+}
+
+// taggedImages lists a namespace's images: manifests with a tag other than a
+// signature, each named by its first tag.
+const taggedImages = `
+	SELECT m.id, m.digest, m.repository_id, n.name || '/' || r.name || ':' || MIN(t.name) AS ref
+	FROM manifests m
+	JOIN repositories r ON r.id = m.repository_id
+	JOIN namespaces n ON n.id = r.namespace_id
+	JOIN tags t ON t.manifest_id = m.id AND t.name NOT LIKE '%.sig'
+	WHERE n.name = $1
+	GROUP BY m.id, m.digest, n.name, r.name, m.repository_id`
+
+// Generate collects the report on namespace, with policy denials and audit
+// entries from the last periodDays.
+func (s *Service) Generate(ctx context.Context, namespace string, periodDays int) (*Report, error) {
+	var env sql.NullString
+	err := s.DB.QueryRowContext(ctx, `SELECT environment FROM namespaces WHERE name = $1`, namespace).Scan(&env)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	r := &Report{
+		Namespace:   namespace,
+		GeneratedAt: now,
+		PeriodStart: now.AddDate(0, 0, -periodDays),
+		PeriodEnd:   now,
+	}
+	r.Policy.Environment = env.String
+	if r.Policy.Environment == "" {
+		r.Policy.Environment = s.DefaultEnv
+	}
+	sum := sha256.Sum256([]byte(s.Policy.GetPolicy()))
+	r.Policy.PolicySHA256 = hex.EncodeToString(sum[:])
+
+	steps := []func(context.Context, *Report) error{
+		s.coverage,
+		s.policyDenials,
+		s.auditRetention,
+		s.criticalCVEs,
+	}
+	for _, step := range steps {
+		if err := step(ctx, r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (s *Service) coverage(ctx context.Context, r *Report) error {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT i.ref, lc.scanned_at, COALESCE(la.status, ''),
+			EXISTS (SELECT 1 FROM tags sig WHERE sig.repository_id = i.repository_id
+			        AND sig.name = replace(i.digest, 'sha256:', 'sha256-') || '.sig')
+		FROM (`+taggedImages+`) i
+		LEFT JOIN LATERAL (
+			SELECT scanned_at FROM vulnerability_reports
+			WHERE manifest_id = i.id AND status = 'completed'
+			ORDER BY scanned_at DESC LIMIT 1
+		) lc ON true
+		LEFT JOIN LATERAL (
+			SELECT status FROM vulnerability_reports
+			WHERE manifest_id = i.id
+			ORDER BY scanned_at DESC LIMIT 1
+		) la ON true
+		ORDER BY i.ref`, r.Namespace)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	recent := time.Now().AddDate(0, 0, -recentScanDays)
+	r.Scans.NotScanned, r.Signatures.Unsigned = []string{}, []string{}
+	for rows.Next() {
+		var ref, lastStatus string
+		var scannedAt sql.NullTime
+		var signed bool
+		if err := rows.Scan(&ref, &scannedAt, &lastStatus, &signed); err != nil {
+			return err
+		}
+		r.Scans.Images++
+		r.Signatures.Images++
+		if scannedAt.Valid {
+			r.Scans.Scanned++
+			if scannedAt.Time.After(recent) {
+				r.Scans.ScannedRecently++
+			}
+		} else if len(r.Scans.NotScanned) < maxListed {
+			r.Scans.NotScanned = append(r.Scans.NotScanned, ref)
+		}
+		if lastStatus == "failed" {
+			r.Scans.LastScanFailed++
+		}
+		if signed {
+			r.Signatures.Signed++
+		} else if len(r.Signatures.Unsigned) < maxListed {
+			r.Signatures.Unsigned = append(r.Signatures.Unsigned, ref)
+		}
+	}
+	r.Scans.Percent = percent(r.Scans.Scanned, r.Scans.Images)
+	r.Signatures.Percent = percent(r.Signatures.Signed, r.Signatures.Images)
+	return rows.Err()
+}
+
+func percent(n, of int) float64 {
+	if of == 0 {
+		return 100
+	}
+	return float64(int(float64(n)*1000/float64(of))) / 10
+}
+
+// inNamespace matches audit entries about the namespace or its repositories.
+const inNamespace = `(split_part(details->>'repository', '/', 1) = $1 OR details->>'namespace' = $1
+	OR repository_id IN (SELECT r.id FROM repositories r JOIN namespaces n ON n.id = r.namespace_id WHERE n.name = $1))`
+
+func (s *Service) policyDenials(ctx context.Context, r *Report) error {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT COALESCE(details->>'source', 'pull'), details->'violations'
+		FROM audit_logs
+		WHERE action = 'POLICY_DENIED' AND created_at >= $2 AND `+inNamespace, r.Namespace, r.PeriodStart)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	r.Policy.BySource = map[string]int{}
+	violations := map[string]int{}
+	for rows.Next() {
+		var source string
+		var raw []byte
+		if err := rows.Scan(&source, &raw); err != nil {
+			return err
+		}
+		r.Policy.Denials++
+		r.Policy.BySource[source]++
+		for _, v := range decodeStrings(raw) {
+			violations[v]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	r.Policy.TopViolations = []ViolationCount{}
+	for v, n := range violations {
+		r.Policy.TopViolations = append(r.Policy.TopViolations, ViolationCount{Violation: v, Count: n})
+	}
+	sort.Slice(r.Policy.TopViolations, func(i, j int) bool {
+		a, b := r.Policy.TopViolations[i], r.Policy.TopViolations[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Violation < b.Violation
+	})
+	if len(r.Policy.TopViolations) > maxViolations {
+		r.Policy.TopViolations = r.Policy.TopViolations[:maxViolations]
+	}
+	return nil
+}
+
+func decodeStrings(raw []byte) []string {
+	var out []string
+	_ = json.Unmarshal(raw, &out)
+	return out
+}
+
+// auditRetention reports the audit log's reach. Entries are never deleted,
+// so the oldest one shows how far back the evidence goes.
+func (s *Service) auditRetention(ctx context.Context, r *Report) error {
+	r.Audit.Retention = "indefinite"
+	var oldest sql.NullTime
+	if err := s.DB.QueryRowContext(ctx, `SELECT MIN(created_at) FROM audit_logs`).Scan(&oldest); err != nil {
+		return err
+	}
+	if oldest.Valid {
+		t := oldest.Time.UTC()
+		r.Audit.OldestEntry = &t
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT action, COUNT(*) FROM audit_logs
+		WHERE created_at >= $2 AND `+inNamespace+`
+		GROUP BY action`, r.Namespace, r.PeriodStart)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	r.Audit.Actions = map[string]int{}
+	for rows.Next() {
+		var action string
+		var n int
+		if err := rows.Scan(&action, &n); err != nil {
+			return err
+		}
+		r.Audit.Actions[action] = n
+		r.Audit.EntriesInPeriod += n
+	}
+	return rows.Err()
+}
+
+func (s *Service) criticalCVEs(ctx context.Context, r *Report) error {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT i.ref, i.digest, COALESCE(v->>'VulnerabilityID', ''), COALESCE(v->>'PkgName', ''),
+			COALESCE(v->>'InstalledVersion', ''), COALESCE(v->>'FixedVersion', '')
+		FROM (`+taggedImages+`) i
+		JOIN LATERAL (
+			SELECT report_json FROM vulnerability_reports
+			WHERE manifest_id = i.id AND status = 'completed'
+			ORDER BY scanned_at DESC LIMIT 1
+		) lr ON true
+		CROSS JOIN LATERAL jsonb_array_elements(CASE WHEN jsonb_typeof(lr.report_json->'Results') = 'array'
+			THEN lr.report_json->'Results' ELSE '[]'::jsonb END) rs
+		CROSS JOIN LATERAL jsonb_array_elements(CASE WHEN jsonb_typeof(rs->'Vulnerabilities') = 'array'
+			THEN rs->'Vulnerabilities' ELSE '[]'::jsonb END) v
+		WHERE UPPER(v->>'Severity') = 'CRITICAL'
+		ORDER BY 1, 3, 4`, r.Namespace)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	r.CriticalCVEs.Findings = []CriticalFinding{}
+	images := map[string]bool{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var f CriticalFinding
+		if err := rows.Scan(&f.Image, &f.Digest, &f.CVE, &f.Package, &f.InstalledVersion, &f.FixedVersion); err != nil {
+			return err
+		}
+		// Trivy lists a package once per target it was found in
+		key := f.Digest + "|" + f.CVE + "|" + f.Package
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		images[f.Digest] = true
+		r.CriticalCVEs.Total++
+		if len(r.CriticalCVEs.Findings) < maxFindings {
+			r.CriticalCVEs.Findings = append(r.CriticalCVEs.Findings, f)
+		}
+	}
+	r.CriticalCVEs.Images = len(images)
+	return rows.Err()
+}
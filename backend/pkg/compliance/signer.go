@@ -0,0 +1,127 @@
+package compliance
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnknownKey is returned when verifying with a key this registry never had.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Signer signs reports with an Ed25519 key kept in the compliance_keys
+// table, so auditors can check them against the public key without access
+// to the registry. Keys are never deleted; reports stay verifiable.
+type Signer struct {
+	DB *sql.DB
+
+	mu    sync.Mutex
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+// PublicKey is a signing key as handed to auditors.
+type PublicKey struct {
+	ID        string    `json:"id"`
+	PEM       string    `json:"pem"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// current returns the newest key, creating the first one on first use.
+func (s *Signer) current(ctx context.Context) (string, ed25519.PrivateKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.key != nil {
+		return s.keyID, s.key, nil
+	}
+
+	var seed []byte
+	err := s.DB.QueryRowContext(ctx, `SELECT seed FROM compliance_keys ORDER BY created_at DESC, id LIMIT 1`).Scan(&seed)
+	if err == sql.ErrNoRows {
+		pub, priv, genErr := ed25519.GenerateKey(rand.Reader)
+		if genErr != nil {
+			return "", nil, genErr
+		}
+		// Another instance may create one at the same time; both stay valid.
+		if _, err := s.DB.ExecContext(ctx, `INSERT INTO compliance_keys (id, seed, public_key) VALUES ($1, $2, $3)`,
+			keyID(pub), priv.Seed(), []byte(pub)); err != nil {
+			return "", nil, err
+		}
+		seed, err = priv.Seed(), nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	s.key = ed25519.NewKeyFromSeed(seed)
+	s.keyID = keyID(s.key.Public().(ed25519.PublicKey))
+	return s.keyID, s.key, nil
+}
+
+// KeyID returns the ID of the key Sign uses.
+func (s *Signer) KeyID(ctx context.Context) (string, error) {
+	id, _, err := s.current(ctx)
+	return id, err
+}
+
+// Sign signs data and returns the key ID and signature.
+func (s *Signer) Sign(ctx context.Context, data []byte) (string, []byte, error) {
+	id, key, err := s.current(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, ed25519.Sign(key, data), nil
+}
+
+// Verify reports whether sig is keyID's signature of data.
+func (s *Signer) Verify(ctx context.Context, keyID string, data, sig []byte) (bool, error) {
+	var pub []byte
+	err := s.DB.QueryRowContext(ctx, `SELECT public_key FROM compliance_keys WHERE id = $1`, keyID).Scan(&pub)
+	if err == sql.ErrNoRows {
+		return false, ErrUnknownKey
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(pub) == ed25519.PublicKeySize && ed25519.Verify(pub, data, sig), nil
+}
+
+// PublicKeys lists every key reports were signed with, newest first.
+func (s *Signer) PublicKeys(ctx context.Context) ([]PublicKey, error) {
+	if _, _, err := s.current(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, public_key, created_at FROM compliance_keys ORDER BY created_at DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []PublicKey{}
+	for rows.Next() {
+		var k PublicKey
+		var pub []byte
+		if err := rows.Scan(&k.ID, &pub, &k.CreatedAt); err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKIXPublicKey(ed25519.PublicKey(pub))
+		if err != nil {
+			return nil, err
+		}
+		k.PEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
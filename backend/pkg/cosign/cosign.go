@@ -0,0 +1,355 @@
+// Package cosign verifies cosign signatures of images stored in the
+// registry, so policies can require signatures by specific keys or
+// identities rather than the mere presence of a signature tag.
+//
+// Signatures are found under the sha256-<hex>.sig tag cosign pushes by
+// default, and as OCI 1.1 referrers of the image (cosign's
+// --registry-referrers-mode=oci-1-1). Each simple-signing layer is checked:
+//
+//   - against the configured public keys, or
+//   - keyless: against the Fulcio certificate in its annotations, which must
+//     chain to a configured Fulcio root at the time the Rekor bundle says
+//     the signature was logged. With a Rekor public key configured, the
+//     bundle's signed entry timestamp is checked too.
+package cosign
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+const (
+	simpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	referrerArtifactType   = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
+	annotationSignature   = "dev.cosignproject.cosign/signature"
+	annotationCertificate = "dev.sigstore.cosign/certificate"
+	annotationChain       = "dev.sigstore.cosign/chain"
+	annotationBundle      = "dev.sigstore.cosign/bundle"
+
+	// maxObjectSize bounds signature manifests and payloads read.
+	maxObjectSize = 1024 * 1024
+
+	// cacheTTL is how long a verification result is reused. Pushing a
+	// signature through this instance forgets the result at once.
+	cacheTTL = time.Minute
+)
+
+// Identity is a signature that verified: the configured key that made it,
+// or for keyless signatures the OIDC issuer and the identity (email,
+// workflow URI) Fulcio issued the certificate to.
+type Identity struct {
+	Keyless bool   `json:"keyless"`
+	Key     string `json:"key,omitempty"`
+	Issuer  string `json:"issuer,omitempty"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// Result is the outcome of verifying an image's signatures.
+type Result struct {
+	// Present is set when the image has any cosign signature.
+	Present bool `json:"present"`
+	// Signed is set when a signature verified. Without keys or Fulcio
+	// roots configured nothing can be verified, and any signature counts.
+	Signed   bool       `json:"signed"`
+	Verified []Identity `json:"verified"`
+	// Errors say why the other signatures did not verify.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// publicKey is a configured signing key, named after its file.
+type publicKey struct {
+	name string
+	key  interface{}
+}
+
+// Verifier checks the signatures of images in Storage.
+type Verifier struct {
+	Storage  storage.Driver
+	Metadata *metadata.Service
+
+	keys  []publicKey
+	roots *x509.CertPool // Fulcio roots; nil disables keyless verification
+	rekor interface{}    // Rekor log key; nil trusts the bundle's integrated time
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	result  *Result
+	expires time.Time
+}
+
+// NewVerifier creates a verifier. keyFiles are PEM files of public keys
+// (a file may hold several), rootsFile a PEM bundle of Fulcio root and
+// intermediate certificates and rekorKeyFile the PEM public key of the
+// Rekor log. Each may be empty.
+func NewVerifier(store storage.Driver, meta *metadata.Service, keyFiles []string, rootsFile, rekorKeyFile string) (*Verifier, error) {
+	v := &Verifier{Storage: store, Metadata: meta, cache: map[string]cacheEntry{}}
+	for _, file := range keyFiles {
+		keys, err := readPublicKeys(file)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if rootsFile != "" {
+		data, err := os.ReadFile(rootsFile)
+		if err != nil {
+			return nil, fmt.Errorf("read Fulcio roots: %w", err)
+		}
+		v.roots = x509.NewCertPool()
+		if !v.roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", rootsFile)
+		}
+	}
+	if rekorKeyFile != "" {
+		keys, err := readPublicKeys(rekorKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.rekor = keys[0].key
+	}
+	return v, nil
+}
+
+// readPublicKeys parses the PEM public keys in file. The first is named
+// after the file without its extension, later ones get "#2", "#3", ...
+func readPublicKeys(file string) ([]publicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read public key: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	var keys []publicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		keyName := name
+		if len(keys) > 0 {
+			keyName = fmt.Sprintf("%s#%d", name, len(keys)+1)
+		}
+		keys = append(keys, publicKey{name: keyName, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found in %s", file)
+	}
+	return keys, nil
+}
+
+// Configured reports whether signatures can be verified at all.
+func (v *Verifier) Configured() bool {
+	return len(v.keys) > 0 || v.roots != nil
+}
+
+// Verify checks the cosign signatures of the manifest digest in repoName.
+func (v *Verifier) Verify(ctx context.Context, repoName, digest string) (*Result, error) {
+	cacheKey := repoName + "@" + digest
+	v.mu.Lock()
+	if e, ok := v.cache[cacheKey]; ok && time.Now().Before(e.expires) {
+		v.mu.Unlock()
+		return e.result, nil
+	}
+	v.mu.Unlock()
+
+	manifests, err := v.signatureManifests(ctx, repoName, digest)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Verified: []Identity{}}
+	seen := map[Identity]bool{}
+	for _, body := range manifests {
+		var m signatureManifest
+		if err := json.Unmarshal(body, &m); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("invalid signature manifest: %v", err))
+			continue
+		}
+		for _, layer := range m.Layers {
+			if layer.MediaType != simpleSigningMediaType {
+				continue
+			}
+			res.Present = true
+			id, err := v.verifyLayer(ctx, layer, digest)
+			if err != nil {
+				res.Errors = append(res.Errors, err.Error())
+				continue
+			}
+			if !seen[*id] {
+				seen[*id] = true
+				res.Verified = append(res.Verified, *id)
+			}
+		}
+	}
+	res.Signed = len(res.Verified) > 0 || (res.Present && !v.Configured())
+
+	v.mu.Lock()
+	v.cache[cacheKey] = cacheEntry{result: res, expires: time.Now().Add(cacheTTL)}
+	for k, e := range v.cache {
+		if time.Now().After(e.expires) {
+			delete(v.cache, k)
+		}
+	}
+	v.mu.Unlock()
+	return res, nil
+}
+
+// forget drops the cached result for digest.
+func (v *Verifier) forget(repoName, digest string) {
+	v.mu.Lock()
+	delete(v.cache, repoName+"@"+digest)
+	v.mu.Unlock()
+}
+
+// Pushed forgets the results a pushed manifest may change: that of its
+// subject, and for a signature tag that of the digest it signs.
+func (v *Verifier) Pushed(repoName, reference, subject string) {
+	if subject != "" {
+		v.forget(repoName, subject)
+	}
+	if name, ok := strings.CutSuffix(reference, ".sig"); ok {
+		if alg, hex, ok := strings.Cut(name, "-"); ok {
+			v.forget(repoName, alg+":"+hex)
+		}
+	}
+}
+
+// SignatureTag returns the tag cosign stores the signatures of digest
+// under, or "" for digests it can't name.
+func SignatureTag(digest string) string {
+	alg, hex, ok := strings.Cut(digest, ":")
+	if !ok {
+		return ""
+	}
+	return alg + "-" + hex + ".sig"
+}
+
+// signatureManifests returns the bodies of the signature tag's manifest and
+// of cosign signatures attached as referrers.
+func (v *Verifier) signatureManifests(ctx context.Context, repoName, digest string) ([][]byte, error) {
+	var bodies [][]byte
+	if tag := SignatureTag(digest); tag != "" {
+		exists, err := v.Metadata.TagExists(ctx, repoName, tag)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			body, err := v.read(ctx, path.Join("manifests", repoName, tag))
+			if err != nil {
+				return nil, fmt.Errorf("read signature %s: %w", tag, err)
+			}
+			bodies = append(bodies, body)
+		}
+	}
+	referrers, err := v.Metadata.ListReferrers(ctx, repoName, digest, referrerArtifactType)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range referrers {
+		body, err := v.read(ctx, path.Join("manifests", repoName, ref.Digest))
+		if err != nil {
+			return nil, fmt.Errorf("read signature %s: %w", ref.Digest, err)
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, nil
+}
+
+type layerDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type signatureManifest struct {
+	Layers []layerDescriptor `json:"layers"`
+}
+
+// simpleSigning is the payload cosign signs.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// verifyLayer checks one signature of digest and returns who made it.
+func (v *Verifier) verifyLayer(ctx context.Context, layer layerDescriptor, digest string) (*Identity, error) {
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[annotationSignature])
+	if err != nil || len(sig) == 0 {
+		return nil, fmt.Errorf("signature %s has no valid signature annotation", layer.Digest)
+	}
+	payload, err := v.read(ctx, path.Join("blobs", layer.Digest))
+	if err != nil {
+		return nil, fmt.Errorf("read signature payload %s: %w", layer.Digest, err)
+	}
+	sum := sha256.Sum256(payload)
+	if "sha256:"+hex.EncodeToString(sum[:]) != layer.Digest {
+		return nil, fmt.Errorf("signature payload %s does not match its digest", layer.Digest)
+	}
+	var p simpleSigning
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, fmt.Errorf("invalid signature payload %s: %w", layer.Digest, err)
+	}
+	if p.Critical.Image.DockerManifestDigest != digest {
+		return nil, fmt.Errorf("signature %s is for %s", layer.Digest, p.Critical.Image.DockerManifestDigest)
+	}
+
+	if certPEM := layer.Annotations[annotationCertificate]; certPEM != "" {
+		return v.verifyKeyless(layer, payload, sig)
+	}
+	for _, k := range v.keys {
+		if verifySignature(k.key, payload, sig) == nil {
+			return &Identity{Key: k.name}, nil
+		}
+	}
+	if len(v.keys) == 0 {
+		return nil, errors.New("signature made with a key, but no public keys are configured")
+	}
+	return nil, errors.New("signature not made by a configured key")
+}
+
+func (v *Verifier) read(ctx context.Context, objectPath string) ([]byte, error) {
+	reader, err := v.Storage.Reader(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, maxObjectSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxObjectSize {
+		return nil, errors.New("object too large")
+	}
+	return data, nil
+}
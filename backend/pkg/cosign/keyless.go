@@ -0,0 +1,196 @@
+package cosign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Fulcio certificate extensions naming the OIDC issuer, see
+// https://github.com/sigstore/fulcio/blob/main/docs/oid-info.md
+var (
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// rekorBundle is the transparency log entry cosign attaches to keyless
+// signatures.
+type rekorBundle struct {
+	SignedEntryTimestamp []byte       `json:"SignedEntryTimestamp"`
+	Payload              rekorPayload `json:"Payload"`
+}
+
+// rekorPayload is what the signed entry timestamp signs, its fields in the
+// canonical (sorted) order.
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// hashedRekord is the log entry of a signature.
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content []byte `json:"content"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// verifyKeyless checks a signature by the key of its Fulcio certificate,
+// and that the certificate chained to a Fulcio root when the signature was
+// logged.
+func (v *Verifier) verifyKeyless(layer layerDescriptor, payload, sig []byte) (*Identity, error) {
+	leaf, err := parseCertificate(layer.Annotations[annotationCertificate])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing certificate: %w", err)
+	}
+	if err := verifySignature(leaf.PublicKey, payload, sig); err != nil {
+		return nil, err
+	}
+	if v.roots == nil {
+		return nil, errors.New("keyless signature valid, but no Fulcio roots are configured to verify the certificate")
+	}
+
+	raw := layer.Annotations[annotationBundle]
+	if raw == "" {
+		return nil, errors.New("keyless signature has no transparency log entry")
+	}
+	var b rekorBundle
+	if err := json.Unmarshal([]byte(raw), &b); err != nil {
+		return nil, fmt.Errorf("invalid transparency log entry: %w", err)
+	}
+	if b.Payload.IntegratedTime <= 0 {
+		return nil, errors.New("transparency log entry has no integrated time")
+	}
+	if v.rekor != nil {
+		if err := v.verifyBundle(&b, payload, sig); err != nil {
+			return nil, err
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for rest := []byte(layer.Annotations[annotationChain]); ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			intermediates.AddCert(cert)
+		}
+	}
+	// Fulcio certificates live for minutes; check them at signing time.
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Unix(b.Payload.IntegratedTime, 0),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, fmt.Errorf("certificate not issued by a trusted Fulcio root: %w", err)
+	}
+
+	id := &Identity{Keyless: true, Issuer: issuer(leaf)}
+	switch {
+	case len(leaf.EmailAddresses) > 0:
+		id.Subject = leaf.EmailAddresses[0]
+	case len(leaf.URIs) > 0:
+		id.Subject = leaf.URIs[0].String()
+	}
+	return id, nil
+}
+
+// verifyBundle checks the signed entry timestamp of the Rekor log and that
+// the logged entry is this signature of payload.
+func (v *Verifier) verifyBundle(b *rekorBundle, payload, sig []byte) error {
+	canonical, err := json.Marshal(b.Payload)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(v.rekor, canonical, b.SignedEntryTimestamp); err != nil {
+		return errors.New("transparency log entry not signed by the configured Rekor key")
+	}
+
+	body, err := base64.StdEncoding.DecodeString(b.Payload.Body)
+	if err != nil {
+		return fmt.Errorf("invalid transparency log entry: %w", err)
+	}
+	var entry hashedRekord
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return fmt.Errorf("invalid transparency log entry: %w", err)
+	}
+	sum := sha256.Sum256(payload)
+	if entry.Kind != "hashedrekord" || entry.Spec.Data.Hash.Algorithm != "sha256" ||
+		entry.Spec.Data.Hash.Value != hex.EncodeToString(sum[:]) || string(entry.Spec.Signature.Content) != string(sig) {
+		return errors.New("transparency log entry is for another signature")
+	}
+	return nil
+}
+
+// verifySignature checks sig over message by key, the way cosign signs:
+// ECDSA and RSA (PKCS #1 v1.5) over SHA-256, Ed25519 over the message.
+func verifySignature(key interface{}, message, sig []byte) error {
+	sum := sha256.Sum256(message)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(k, sum[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, message, sig) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported signing key type %T", key)
+	}
+	return errors.New("invalid signature")
+}
+
+func parseCertificate(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// issuer returns the OIDC issuer of a Fulcio certificate. The newer
+// extension is a DER UTF8String; the original one is the raw string.
+func issuer(cert *x509.Certificate) string {
+	var v1 string
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidIssuerV2):
+			var s string
+			if _, err := asn1.UnmarshalWithParams(ext.Value, &s, "utf8"); err == nil {
+				return s
+			}
+		case ext.Id.Equal(oidIssuerV1):
+			v1 = string(ext.Value)
+		}
+	}
+	return v1
+}
@@ -0,0 +1,151 @@
+package costs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// DefaultStorageClassCosts are S3 us-east-1 prices per GB-month of the storage
+// classes lifecycle rules move blobs to. STANDARD always costs
+// StorageCostPerGBMonth.
+var DefaultStorageClassCosts = map[string]float64{
+	"STANDARD_IA":  0.0125,
+	"ONEZONE_IA":   0.01,
+	"GLACIER_IR":   0.004,
+	"GLACIER":      0.0036,
+	"DEEP_ARCHIVE": 0.00099,
+}
+
+// ParseStorageClassCosts parses "CLASS=usd,CLASS=usd" (STORAGE_CLASS_COSTS)
+// over the defaults.
+func ParseStorageClassCosts(s string) (map[string]float64, error) {
+	rates := make(map[string]float64, len(DefaultStorageClassCosts))
+	for class, rate := range DefaultStorageClassCosts {
+		rates[class] = rate
+	}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		class, price, ok := strings.Cut(entry, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(price), 64)
+		if !ok || err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid storage class price %q", entry)
+		}
+		rates[strings.ToUpper(strings.TrimSpace(class))] = rate
+	}
+	return rates, nil
+}
+
+// classRate returns the per-GB-month price of a storage class; unknown
+// classes cost as much as STANDARD.
+func (s *Service) classRate(class string) float64 {
+	if rate, ok := s.Config.StorageClassCostPerGBMonth[class]; ok && class != "STANDARD" {
+		return rate
+	}
+	return s.Config.StorageCostPerGBMonth
+}
+
+// CalculateImageCostByClass is CalculateImageCost for an image some of whose
+// layers are in other storage classes than STANDARD; classBytes holds their
+// bytes per class.
+func (s *Service) CalculateImageCostByClass(sizeBytes int64, classBytes map[string]int64, pullCount int) ImageCost {
+	cost := s.CalculateImageCost(sizeBytes, pullCount)
+	standard := sizeBytes
+	storageCost := 0.0
+	for class, bytes := range classBytes {
+		if bytes > standard {
+			bytes = standard
+		}
+		standard -= bytes
+		storageCost += float64(bytes) / 1e9 * s.classRate(class)
+	}
+	storageCost += float64(standard) / 1e9 * s.Config.StorageCostPerGBMonth
+
+	cost.StorageCostUSD = storageCost
+	cost.TotalCostUSD = storageCost + cost.BandwidthCostUSD
+	if pullCount > 0 {
+		cost.CostPerPull = cost.TotalCostUSD / float64(pullCount)
+	}
+	return cost
+}
+
+// classBytes returns the layer bytes of each manifest that are not in
+// STANDARD, per class; only of one repository's manifests if repoID is set.
+func (s *Service) classBytes(ctx context.Context, repoID uuid.NullUUID) (map[uuid.UUID]map[string]int64, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT ml.manifest_id, b.storage_class, SUM(b.size)
+		FROM manifest_layers ml
+		JOIN blobs b ON b.digest = ml.blob_digest
+		JOIN manifests m ON m.id = ml.manifest_id
+		WHERE b.storage_class <> 'STANDARD' AND ($1::uuid IS NULL OR m.repository_id = $1)
+		GROUP BY ml.manifest_id, b.storage_class`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[uuid.UUID]map[string]int64)
+	for rows.Next() {
+		var id uuid.UUID
+		var class string
+		var bytes int64
+		if err := rows.Scan(&id, &class, &bytes); err != nil {
+			return nil, err
+		}
+		if res[id] == nil {
+			res[id] = make(map[string]int64)
+		}
+		res[id][class] = bytes
+	}
+	return res, rows.Err()
+}
+
+// classSyncBatch bounds the blobs updated per statement.
+const classSyncBatch = 5000
+
+// SyncStorageClasses records the storage class of every blob in the bucket,
+// returning how many changed. It does nothing if the storage driver has no
+// storage classes.
+func (s *Service) SyncStorageClasses(ctx context.Context) (int, error) {
+	if s.Classes == nil {
+		return 0, nil
+	}
+	var digests, classes []string
+	changed := 0
+	flush := func() error {
+		if len(digests) == 0 {
+			return nil
+		}
+		res, err := s.DB.ExecContext(ctx, `
+			UPDATE blobs b SET storage_class = v.class
+			FROM unnest($1::text[], $2::text[]) AS v(digest, class)
+			WHERE b.digest = v.digest AND b.storage_class <> v.class`, pq.Array(digests), pq.Array(classes))
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		changed += int(n)
+		digests, classes = digests[:0], classes[:0]
+		return nil
+	}
+
+	err := s.Classes.ListClasses(ctx, "blobs/", func(path, class string) error {
+		digests = append(digests, strings.TrimPrefix(path, "blobs/"))
+		classes = append(classes, class)
+		if len(digests) == classSyncBatch {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return changed, err
+	}
+	return changed, flush()
+}
@@ -0,0 +1,145 @@
+package costs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/authz"
+)
+
+// DedupStats compares what images claim to use with what is actually stored.
+// Logical bytes sum every manifest's size; physical bytes count each blob
+// (layer or config) once, however many images share it.
+type DedupStats struct {
+	Namespace               string  `json:"namespace,omitempty"`
+	Manifests               int     `json:"manifests"`
+	LogicalBytes            int64   `json:"logical_bytes"`
+	PhysicalBytes           int64   `json:"physical_bytes"`
+	SavedBytes              int64   `json:"saved_bytes"`
+	SavingsRatio            float64 `json:"savings_ratio"` // saved / logical, 0..1
+	DedupFactor             float64 `json:"dedup_factor"`  // logical / physical, e.g. 3.2x
+	LogicalStorageCostUSD   float64 `json:"logical_storage_cost_usd"`
+	EffectiveStorageCostUSD float64 `json:"effective_storage_cost_usd"`
+}
+
+// DedupReport breaks deduplication down by namespace. Blobs shared between
+// namespaces count in each of them, so Total.PhysicalBytes can be lower than
+// the sum of the namespaces.
+type DedupReport struct {
+	Namespaces []DedupStats `json:"namespaces"`
+	Total      DedupStats   `json:"total"`
+}
+
+// GetDedupReport computes deduplication savings over the repositories the
+// user can read (all of them for admins).
+func (s *Service) GetDedupReport(ctx context.Context, userID uuid.UUID, role string) (*DedupReport, error) {
+	whereClause := "1=1"
+	args := []interface{}{}
+	if role != "admin" {
+		whereClause = authz.RepositoryFilter("$1", authz.RoleRead)
+		args = append(args, userID)
+	}
+
+	visible := fmt.Sprintf(`
+		WITH visible AS (
+			SELECT m.id, m.size, m.config_digest, n.name AS ns
+			FROM manifests m
+			JOIN repositories r ON m.repository_id = r.id
+			JOIN namespaces n ON r.namespace_id = n.id
+			WHERE %s
+		),
+		refs AS (
+			SELECT v.ns, ml.blob_digest AS digest FROM visible v JOIN manifest_layers ml ON ml.manifest_id = v.id
+			UNION
+			SELECT v.ns, v.config_digest FROM visible v WHERE v.config_digest IS NOT NULL AND v.config_digest <> ''
+		)`, whereClause)
+
+	byNamespace := make(map[string]*DedupStats)
+	get := func(ns string) *DedupStats {
+		st, ok := byNamespace[ns]
+		if !ok {
+			st = &DedupStats{Namespace: ns}
+			byNamespace[ns] = st
+		}
+		return st
+	}
+
+	rows, err := s.DB.QueryContext(ctx, visible+`
+		SELECT ns, COUNT(*), COALESCE(SUM(size), 0) FROM visible GROUP BY ns`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var ns string
+		var count int
+		var logical int64
+		if err := rows.Scan(&ns, &count, &logical); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		st := get(ns)
+		st.Manifests, st.LogicalBytes = count, logical
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.DB.QueryContext(ctx, visible+`
+		SELECT refs.ns, COALESCE(SUM(b.size), 0) FROM refs JOIN blobs b ON b.digest = refs.digest GROUP BY refs.ns`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var ns string
+		var physical int64
+		if err := rows.Scan(&ns, &physical); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		get(ns).PhysicalBytes = physical
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &DedupReport{Namespaces: []DedupStats{}}
+	err = s.DB.QueryRowContext(ctx, visible+`
+		SELECT COALESCE(SUM(b.size), 0) FROM (SELECT DISTINCT digest FROM refs) d JOIN blobs b ON b.digest = d.digest`, args...).
+		Scan(&report.Total.PhysicalBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, st := range byNamespace {
+		s.finishDedup(st)
+		report.Namespaces = append(report.Namespaces, *st)
+		report.Total.Manifests += st.Manifests
+		report.Total.LogicalBytes += st.LogicalBytes
+	}
+	s.finishDedup(&report.Total)
+	sort.Slice(report.Namespaces, func(i, j int) bool {
+		return report.Namespaces[i].SavedBytes > report.Namespaces[j].SavedBytes
+	})
+	return report, nil
+}
+
+// finishDedup fills in the derived fields from the byte counts.
+func (s *Service) finishDedup(st *DedupStats) {
+	st.SavedBytes = st.LogicalBytes - st.PhysicalBytes
+	if st.SavedBytes < 0 {
+		// Manifest sizes can undercount (e.g. indexes); never report a loss.
+		st.SavedBytes = 0
+	}
+	if st.LogicalBytes > 0 {
+		st.SavingsRatio = float64(st.SavedBytes) / float64(st.LogicalBytes)
+	}
+	if st.PhysicalBytes > 0 {
+		st.DedupFactor = float64(st.LogicalBytes) / float64(st.PhysicalBytes)
+	}
+	st.LogicalStorageCostUSD = float64(st.LogicalBytes) / 1e9 * s.Config.StorageCostPerGBMonth
+	st.EffectiveStorageCostUSD = float64(st.PhysicalBytes) / 1e9 * s.Config.StorageCostPerGBMonth
+}
@@ -0,0 +1,431 @@
+package costs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/authz"
+)
+
+// Kinds of cost recommendations.
+const (
+	RecommendZombieCleanup = "zombie_cleanup"   // delete tagged images nobody pulls
+	RecommendRetention     = "retention"        // prune old untagged manifests
+	RecommendSlimImages    = "oversized_images" // a repository's images are far larger than average
+	RecommendConsolidate   = "consolidate_tags" // many tags of nearly identical images
+)
+
+// Thresholds of the recommendation rules.
+const (
+	zombieDays            = 180  // tagged images not pulled for this long
+	untaggedDays          = 30   // untagged manifests older than this (and not pulled since)
+	oversizedFactor       = 3.0  // average image size vs. the average of all repositories
+	oversizedMinBytes     = 50e6 // smaller images are never called oversized
+	oversizedMinRepos     = 3    // repositories needed for a meaningful average
+	similarLayerRatio     = 0.95 // share of layer bytes images must have in common
+	consolidateMinTags    = 10   // tags of similar images before suggesting to consolidate
+	consolidateMaxImages  = 1000 // repositories with more manifests are skipped
+	maxRecommendedTags    = 50   // tags listed per recommendation
+	maxRecommendedRecords = 100  // recommendations returned
+)
+
+// Recommendation is an action that would lower a repository's costs.
+type Recommendation struct {
+	Type                 string      `json:"type"`
+	Repository           string      `json:"repository"`
+	Title                string      `json:"title"`
+	Detail               string      `json:"detail"`
+	Effort               string      `json:"effort"`                // low, medium or high
+	EstimatedSavingsUSD  float64     `json:"estimated_savings_usd"` // per month
+	EstimatedSavingBytes int64       `json:"estimated_saving_bytes"`
+	ManifestIDs          []uuid.UUID `json:"manifest_ids,omitempty"`
+	Tags                 []string    `json:"tags,omitempty"`
+}
+
+// RecommendationReport lists recommendations by estimated savings.
+type RecommendationReport struct {
+	TotalSavingsUSD float64          `json:"total_savings_usd"`
+	Recommendations []Recommendation `json:"recommendations"`
+}
+
+// GetRecommendations analyzes the repositories the user can read (all of them
+// for admins) and suggests what to clean up or change, with estimated monthly
+// savings. Storage savings only count blobs no other image uses, since shared
+// layers stay stored either way.
+func (s *Service) GetRecommendations(ctx context.Context, userID uuid.UUID, role string) (*RecommendationReport, error) {
+	report := &RecommendationReport{Recommendations: []Recommendation{}}
+	for _, rule := range []func(context.Context, uuid.UUID, string) ([]Recommendation, error){
+		s.recommendZombieCleanup,
+		s.recommendRetention,
+		s.recommendSlimImages,
+		s.recommendConsolidation,
+	} {
+		recs, err := rule(ctx, userID, role)
+		if err != nil {
+			return nil, err
+		}
+		report.Recommendations = append(report.Recommendations, recs...)
+	}
+
+	sort.SliceStable(report.Recommendations, func(i, j int) bool {
+		return report.Recommendations[i].EstimatedSavingsUSD > report.Recommendations[j].EstimatedSavingsUSD
+	})
+	if len(report.Recommendations) > maxRecommendedRecords {
+		report.Recommendations = report.Recommendations[:maxRecommendedRecords]
+	}
+	for _, rec := range report.Recommendations {
+		report.TotalSavingsUSD += rec.EstimatedSavingsUSD
+	}
+	return report, nil
+}
+
+// visibleRepos returns the repository filter of the user's query, using
+// parameter param for the user ID.
+func visibleRepos(userID uuid.UUID, role string, param int) (string, []interface{}) {
+	if role == "admin" {
+		return "1=1", nil
+	}
+	return authz.RepositoryFilter(fmt.Sprintf("$%d", param), authz.RoleRead), []interface{}{userID}
+}
+
+// staleManifests are the candidates of one cleanup recommendation.
+type staleManifests struct {
+	ids  []uuid.UUID
+	tags []string
+}
+
+// staleByRepository groups the manifests matching cond (with $1 the age in
+// days) by repository.
+func (s *Service) staleByRepository(ctx context.Context, userID uuid.UUID, role, cond string, days int) (map[string]*staleManifests, []string, error) {
+	where, args := visibleRepos(userID, role, 2)
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT n.name || '/' || r.name, m.id,
+		       COALESCE(array_agg(t.name ORDER BY t.name) FILTER (WHERE t.name IS NOT NULL), '{}')
+		FROM manifests m
+		JOIN repositories r ON m.repository_id = r.id
+		JOIN namespaces n ON r.namespace_id = n.id
+		LEFT JOIN tags t ON t.manifest_id = m.id
+		WHERE %s AND %s
+		GROUP BY n.name, r.name, m.id
+		ORDER BY 1`, cond, where), append([]interface{}{days}, args...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	byRepo := map[string]*staleManifests{}
+	var order []string
+	for rows.Next() {
+		var repo string
+		var id uuid.UUID
+		var tags []string
+		if err := rows.Scan(&repo, &id, pq.Array(&tags)); err != nil {
+			return nil, nil, err
+		}
+		sm := byRepo[repo]
+		if sm == nil {
+			sm = &staleManifests{}
+			byRepo[repo] = sm
+			order = append(order, repo)
+		}
+		sm.ids = append(sm.ids, id)
+		sm.tags = append(sm.tags, tags...)
+	}
+	return byRepo, order, rows.Err()
+}
+
+// freedStorage returns the bytes and monthly storage cost of the blobs only
+// the given manifests use, i.e. what deleting them would free.
+func (s *Service) freedStorage(ctx context.Context, ids []uuid.UUID) (int64, float64, error) {
+	strIDs := make([]string, len(ids))
+	for i, id := range ids {
+		strIDs[i] = id.String()
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT b.storage_class, COALESCE(SUM(b.size), 0)
+		FROM blobs b
+		WHERE b.digest IN (SELECT blob_digest FROM manifest_layers WHERE manifest_id = ANY($1::uuid[]))
+		  AND NOT EXISTS (SELECT 1 FROM manifest_layers o WHERE o.blob_digest = b.digest AND NOT (o.manifest_id = ANY($1::uuid[])))
+		GROUP BY b.storage_class`, pq.Array(strIDs))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	var bytes int64
+	var cost float64
+	for rows.Next() {
+		var class string
+		var size int64
+		if err := rows.Scan(&class, &size); err != nil {
+			return 0, 0, err
+		}
+		bytes += size
+		cost += float64(size) / 1e9 * s.classRate(class)
+	}
+	return bytes, cost, rows.Err()
+}
+
+func (s *Service) recommendZombieCleanup(ctx context.Context, userID uuid.UUID, role string) ([]Recommendation, error) {
+	byRepo, order, err := s.staleByRepository(ctx, userID, role, `
+		EXISTS (SELECT 1 FROM tags tt WHERE tt.manifest_id = m.id)
+		AND COALESCE(m.last_pulled_at, m.created_at) < NOW() - INTERVAL '1 day' * $1`, zombieDays)
+	if err != nil {
+		return nil, err
+	}
+	var recs []Recommendation
+	for _, repo := range order {
+		sm := byRepo[repo]
+		bytes, cost, err := s.freedStorage(ctx, sm.ids)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, Recommendation{
+			Type:                 RecommendZombieCleanup,
+			Repository:           repo,
+			Title:                fmt.Sprintf("Delete %d unused images in %s", len(sm.ids), repo),
+			Detail:               fmt.Sprintf("%d tags have not been pulled for %d days.", len(sm.tags), zombieDays),
+			Effort:               "low",
+			EstimatedSavingsUSD:  cost,
+			EstimatedSavingBytes: bytes,
+			ManifestIDs:          sm.ids,
+			Tags:                 limitTags(sm.tags),
+		})
+	}
+	return recs, nil
+}
+
+func (s *Service) recommendRetention(ctx context.Context, userID uuid.UUID, role string) ([]Recommendation, error) {
+	byRepo, order, err := s.staleByRepository(ctx, userID, role, `
+		NOT EXISTS (SELECT 1 FROM tags tt WHERE tt.manifest_id = m.id)
+		AND COALESCE(m.last_pulled_at, m.created_at) < NOW() - INTERVAL '1 day' * $1`, untaggedDays)
+	if err != nil {
+		return nil, err
+	}
+	var recs []Recommendation
+	for _, repo := range order {
+		sm := byRepo[repo]
+		bytes, cost, err := s.freedStorage(ctx, sm.ids)
+		if err != nil {
+			return nil, err
+		}
+		if bytes == 0 {
+			continue // only share layers with images that stay
+		}
+		recs = append(recs, Recommendation{
+			Type:                 RecommendRetention,
+			Repository:           repo,
+			Title:                fmt.Sprintf("Prune untagged manifests in %s", repo),
+			Detail:               fmt.Sprintf("%d untagged manifests are older than %d days and were not pulled since. Delete them and prune untagged manifests regularly.", len(sm.ids), untaggedDays),
+			Effort:               "low",
+			EstimatedSavingsUSD:  cost,
+			EstimatedSavingBytes: bytes,
+			ManifestIDs:          sm.ids,
+		})
+	}
+	return recs, nil
+}
+
+func (s *Service) recommendSlimImages(ctx context.Context, userID uuid.UUID, role string) ([]Recommendation, error) {
+	where, args := visibleRepos(userID, role, 1)
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT n.name || '/' || r.name, COUNT(*), COALESCE(SUM(m.size), 0), COALESCE(SUM(COALESCE(m.pull_count, 0)), 0)
+		FROM manifests m
+		JOIN repositories r ON m.repository_id = r.id
+		JOIN namespaces n ON r.namespace_id = n.id
+		WHERE EXISTS (SELECT 1 FROM tags t WHERE t.manifest_id = m.id) AND %s
+		GROUP BY n.name, r.name`, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type repoSize struct {
+		repo         string
+		images       int
+		bytes, pulls int64
+	}
+	var repos []repoSize
+	var totalImages int
+	var totalBytes int64
+	for rows.Next() {
+		var rs repoSize
+		if err := rows.Scan(&rs.repo, &rs.images, &rs.bytes, &rs.pulls); err != nil {
+			return nil, err
+		}
+		repos = append(repos, rs)
+		totalImages += rs.images
+		totalBytes += rs.bytes
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(repos) < oversizedMinRepos || totalImages == 0 {
+		return nil, nil
+	}
+
+	average := float64(totalBytes) / float64(totalImages)
+	var recs []Recommendation
+	for _, rs := range repos {
+		repoAverage := float64(rs.bytes) / float64(rs.images)
+		if repoAverage < oversizedMinBytes || repoAverage < oversizedFactor*average {
+			continue
+		}
+		// Savings if the images shrank to the average size
+		excess := (repoAverage - average) / repoAverage
+		savedBytes := int64(float64(rs.bytes) * excess)
+		cost := float64(savedBytes)/1e9*s.Config.StorageCostPerGBMonth +
+			(repoAverage-average)*float64(rs.pulls)/1e9*s.Config.BandwidthCostPerGB
+		recs = append(recs, Recommendation{
+			Type:       RecommendSlimImages,
+			Repository: rs.repo,
+			Title:      fmt.Sprintf("Slim down the images of %s", rs.repo),
+			Detail: fmt.Sprintf("Its images average %.0f MB, %.1fx the registry average of %.0f MB. Use a smaller base image or a multi-stage build.",
+				repoAverage/1e6, repoAverage/average, average/1e6),
+			Effort:               "high",
+			EstimatedSavingsUSD:  cost,
+			EstimatedSavingBytes: savedBytes,
+		})
+	}
+	return recs, nil
+}
+
+// similarImage is a tagged image and its layers.
+type similarImage struct {
+	id     uuid.UUID
+	tags   []string
+	layers map[string]int64
+	bytes  int64
+}
+
+// sharedBytes returns the layer bytes two images have in common.
+func (a *similarImage) sharedBytes(b *similarImage) int64 {
+	var shared int64
+	for digest, size := range a.layers {
+		if _, ok := b.layers[digest]; ok {
+			shared += size
+		}
+	}
+	return shared
+}
+
+func (s *Service) recommendConsolidation(ctx context.Context, userID uuid.UUID, role string) ([]Recommendation, error) {
+	where, args := visibleRepos(userID, role, 2)
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		WITH candidates AS (
+			SELECT m.id, m.repository_id, m.created_at, n.name || '/' || r.name AS repo
+			FROM manifests m
+			JOIN repositories r ON m.repository_id = r.id
+			JOIN namespaces n ON r.namespace_id = n.id
+			WHERE EXISTS (SELECT 1 FROM tags t WHERE t.manifest_id = m.id) AND %s
+			  AND (SELECT COUNT(*) FROM manifests o WHERE o.repository_id = m.repository_id) <= $1
+		)
+		SELECT c.repo, c.id,
+		       ARRAY(SELECT t.name FROM tags t WHERE t.manifest_id = c.id ORDER BY t.name),
+		       COALESCE(array_agg(b.digest) FILTER (WHERE b.digest IS NOT NULL), '{}'),
+		       COALESCE(array_agg(b.size) FILTER (WHERE b.digest IS NOT NULL), '{}')
+		FROM candidates c
+		LEFT JOIN manifest_layers ml ON ml.manifest_id = c.id
+		LEFT JOIN blobs b ON b.digest = ml.blob_digest
+		GROUP BY c.repo, c.id, c.created_at
+		ORDER BY c.repo, c.created_at DESC`, where), append([]interface{}{consolidateMaxImages}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byRepo := map[string][]*similarImage{}
+	var order []string
+	for rows.Next() {
+		var repo string
+		var digests []string
+		var sizes []int64
+		img := &similarImage{layers: map[string]int64{}}
+		if err := rows.Scan(&repo, &img.id, pq.Array(&img.tags), pq.Array(&digests), pq.Array(&sizes)); err != nil {
+			return nil, err
+		}
+		for i, digest := range digests {
+			if _, ok := img.layers[digest]; !ok && i < len(sizes) {
+				img.layers[digest] = sizes[i]
+				img.bytes += sizes[i]
+			}
+		}
+		if _, ok := byRepo[repo]; !ok {
+			order = append(order, repo)
+		}
+		byRepo[repo] = append(byRepo[repo], img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var recs []Recommendation
+	for _, repo := range order {
+		// Group each image with the newest earlier-seen image it nearly matches
+		var groups [][]*similarImage
+		for _, img := range byRepo[repo] {
+			placed := false
+			for i, g := range groups {
+				rep := g[0]
+				larger := rep.bytes
+				if img.bytes > larger {
+					larger = img.bytes
+				}
+				if larger > 0 && float64(img.sharedBytes(rep)) >= similarLayerRatio*float64(larger) {
+					groups[i] = append(g, img)
+					placed = true
+					break
+				}
+			}
+			if !placed {
+				groups = append(groups, []*similarImage{img})
+			}
+		}
+
+		for _, g := range groups {
+			var tags []string
+			var ids []uuid.UUID
+			var savedBytes int64
+			for i, img := range g {
+				tags = append(tags, img.tags...)
+				ids = append(ids, img.id)
+				if i > 0 {
+					savedBytes += img.bytes - img.sharedBytes(g[0])
+				}
+			}
+			if len(tags) < consolidateMinTags || len(g) < 2 {
+				continue
+			}
+			recs = append(recs, Recommendation{
+				Type:       RecommendConsolidate,
+				Repository: repo,
+				Title:      fmt.Sprintf("Consolidate %d nearly identical tags in %s", len(tags), repo),
+				Detail: fmt.Sprintf("%d images share at least %.0f%% of their layers with %s. Keep fewer tags, e.g. only the latest few builds.",
+					len(g), similarLayerRatio*100, firstTag(g[0].tags)),
+				Effort:               "medium",
+				EstimatedSavingsUSD:  float64(savedBytes) / 1e9 * s.Config.StorageCostPerGBMonth,
+				EstimatedSavingBytes: savedBytes,
+				ManifestIDs:          ids,
+				Tags:                 limitTags(tags),
+			})
+		}
+	}
+	return recs, nil
+}
+
+func limitTags(tags []string) []string {
+	if len(tags) > maxRecommendedTags {
+		return tags[:maxRecommendedTags]
+	}
+	return tags
+}
+
+func firstTag(tags []string) string {
+	if len(tags) == 0 {
+		return "the newest image"
+	}
+	return tags[0]
+}
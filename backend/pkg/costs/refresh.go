@@ -0,0 +1,146 @@
+package costs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// What started a cost refresh.
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// refreshRunsKept bounds the refresh history.
+const refreshRunsKept = 100
+
+// ErrRefreshRunning is returned when a refresh is started while another one
+// on this instance hasn't finished.
+var ErrRefreshRunning = errors.New("a cost refresh is already running")
+
+// RefreshRun is one recorded cost refresh.
+type RefreshRun struct {
+	ID         uuid.UUID  `json:"id"`
+	Trigger    string     `json:"trigger"`
+	Status     string     `json:"status"` // running, succeeded or failed
+	Images     int        `json:"images"`
+	Zombies    int        `json:"zombies"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Refresh recalculates every image's cost and detects zombie images,
+// recording the run in the refresh history.
+func (s *Service) Refresh(ctx context.Context, trigger string) (*RefreshRun, error) {
+	if !s.refreshing.TryLock() {
+		return nil, ErrRefreshRunning
+	}
+	defer s.refreshing.Unlock()
+
+	run := &RefreshRun{Trigger: trigger, Status: "running"}
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO cost_refresh_runs (trigger) VALUES ($1) RETURNING id, started_at`, trigger).Scan(&run.ID, &run.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	run.Images, err = s.RefreshAllCosts(ctx)
+	if err == nil {
+		var zombies []ZombieImage
+		zombies, err = s.DetectZombieImages(ctx, 90, uuid.Nil, "admin")
+		run.Zombies = len(zombies)
+	}
+	run.Status = "succeeded"
+	if err != nil {
+		run.Status, run.Error = "failed", err.Error()
+	}
+
+	// Record the outcome even if the refresh was cancelled
+	finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if _, dbErr := s.DB.ExecContext(finishCtx, `
+		UPDATE cost_refresh_runs SET status = $2, images = $3, zombies = $4, error = NULLIF($5, ''), finished_at = NOW()
+		WHERE id = $1`, run.ID, run.Status, run.Images, run.Zombies, run.Error); dbErr != nil {
+		fmt.Printf("[Costs] Failed to record refresh run %s: %v\n", run.ID, dbErr)
+	}
+	_, _ = s.DB.ExecContext(finishCtx, `
+		DELETE FROM cost_refresh_runs WHERE id NOT IN (
+			SELECT id FROM cost_refresh_runs ORDER BY started_at DESC LIMIT $1)`, refreshRunsKept)
+
+	now := time.Now()
+	run.FinishedAt = &now
+	return run, err
+}
+
+// Refreshing reports whether a refresh is running on this instance.
+func (s *Service) Refreshing() bool {
+	if s.refreshing.TryLock() {
+		s.refreshing.Unlock()
+		return false
+	}
+	return true
+}
+
+// ListRefreshRuns returns the most recent refreshes, newest first.
+func (s *Service) ListRefreshRuns(ctx context.Context, limit int) ([]RefreshRun, error) {
+	if limit <= 0 || limit > refreshRunsKept {
+		limit = refreshRunsKept
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, trigger, status, images, zombies, COALESCE(error, ''), started_at, finished_at
+		FROM cost_refresh_runs ORDER BY started_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []RefreshRun{}
+	for rows.Next() {
+		var run RefreshRun
+		if err := rows.Scan(&run.ID, &run.Trigger, &run.Status, &run.Images, &run.Zombies, &run.Error, &run.StartedAt, &run.FinishedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// lastRefreshed returns when the last successful refresh finished, or nil.
+func (s *Service) lastRefreshed(ctx context.Context) (*time.Time, error) {
+	var at *time.Time
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT MAX(finished_at) FROM cost_refresh_runs WHERE status = 'succeeded'`).Scan(&at)
+	return at, err
+}
+
+// StartScheduler refreshes costs every interval. Runs are counted from the
+// last successful refresh, whichever instance or trigger it had, so restarts
+// and several API instances don't refresh more often.
+func (s *Service) StartScheduler(ctx context.Context, interval time.Duration) {
+	check := interval
+	if check > 10*time.Minute {
+		check = 10 * time.Minute
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+	for {
+		last, err := s.lastRefreshed(ctx)
+		if err != nil {
+			fmt.Printf("[Costs] Failed to read refresh history: %v\n", err)
+		} else if last == nil || time.Since(*last) >= interval {
+			if _, err := s.Refresh(ctx, TriggerSchedule); err != nil && err != ErrRefreshRunning {
+				fmt.Printf("[Costs] Scheduled refresh failed: %v\n", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package costs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/authz"
+)
+
+// How many days of repository cost snapshots are kept and returned.
+const (
+	DefaultTrendDays = 30
+	MaxTrendDays     = 365
+)
+
+// ErrRepositoryNotFound is returned for repositories that don't exist.
+var ErrRepositoryNotFound = errors.New("repository not found")
+
+// CostPoint is one daily snapshot of a repository's costs.
+type CostPoint struct {
+	Day              string  `json:"day"` // YYYY-MM-DD
+	SizeBytes        int64   `json:"size_bytes"`
+	StorageCostUSD   float64 `json:"storage_cost_usd"`
+	BandwidthCostUSD float64 `json:"bandwidth_cost_usd"`
+	TotalCostUSD     float64 `json:"total_cost_usd"`
+}
+
+// RepositoryCosts breaks a repository's costs down by tag. Totals count each
+// manifest once, so tags pointing at the same manifest each show its full cost
+// but the repository pays for it once.
+type RepositoryCosts struct {
+	Repository       string      `json:"repository"`
+	Manifests        int         `json:"manifests"`
+	SizeBytes        int64       `json:"size_bytes"`
+	PullCount        int         `json:"pull_count"`
+	StorageCostUSD   float64     `json:"storage_cost_usd"`
+	BandwidthCostUSD float64     `json:"bandwidth_cost_usd"`
+	TotalCostUSD     float64     `json:"total_cost_usd"`
+	Tags             []ImageCost `json:"tags"`
+
+	// Manifests no tag points at (old pushes, pruned tags)
+	UntaggedManifests int     `json:"untagged_manifests"`
+	UntaggedCostUSD   float64 `json:"untagged_cost_usd"`
+
+	CostTrend string      `json:"cost_trend"` // increasing, decreasing or stable
+	Trend     []CostPoint `json:"trend"`
+}
+
+// GetRepositoryCosts computes a repository's current costs per tag, and its
+// trend from the daily snapshots of the last days (DefaultTrendDays if 0).
+func (s *Service) GetRepositoryCosts(ctx context.Context, repoName string, days int) (*RepositoryCosts, error) {
+	if days <= 0 {
+		days = DefaultTrendDays
+	}
+	if days > MaxTrendDays {
+		days = MaxTrendDays
+	}
+	nsName, rName := authz.SplitRepository(repoName)
+
+	var repoID uuid.UUID
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT r.id FROM repositories r JOIN namespaces n ON r.namespace_id = n.id
+		WHERE n.name = $1 AND r.name = $2`, nsName, rName).Scan(&repoID)
+	if err == sql.ErrNoRows {
+		return nil, ErrRepositoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	classes, err := s.classBytes(ctx, uuid.NullUUID{UUID: repoID, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT m.id, m.size, COALESCE(m.pull_count, 0), m.last_pulled_at,
+		       COALESCE(array_agg(t.name ORDER BY t.name) FILTER (WHERE t.name IS NOT NULL), '{}')
+		FROM manifests m
+		LEFT JOIN tags t ON t.manifest_id = m.id
+		WHERE m.repository_id = $1
+		GROUP BY m.id`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rc := &RepositoryCosts{Repository: nsName + "/" + rName, Tags: []ImageCost{}, Trend: []CostPoint{}}
+	for rows.Next() {
+		var id uuid.UUID
+		var size int64
+		var pulls int
+		var lastPulled sql.NullTime
+		var tags []string
+		if err := rows.Scan(&id, &size, &pulls, &lastPulled, pq.Array(&tags)); err != nil {
+			return nil, err
+		}
+
+		cost := s.CalculateImageCostByClass(size, classes[id], pulls)
+		cost.ManifestID = id
+		cost.Repository = rc.Repository
+		if lastPulled.Valid {
+			cost.LastPulledAt = &lastPulled.Time
+		}
+
+		rc.Manifests++
+		rc.SizeBytes += size
+		rc.PullCount += pulls
+		rc.StorageCostUSD += cost.StorageCostUSD
+		rc.BandwidthCostUSD += cost.BandwidthCostUSD
+		rc.TotalCostUSD += cost.TotalCostUSD
+		if len(tags) == 0 {
+			rc.UntaggedManifests++
+			rc.UntaggedCostUSD += cost.TotalCostUSD
+			continue
+		}
+		for _, tag := range tags {
+			cost.Tag = tag
+			rc.Tags = append(rc.Tags, cost)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rc.Tags, func(i, j int) bool { return rc.Tags[i].TotalCostUSD > rc.Tags[j].TotalCostUSD })
+
+	trend, err := s.DB.QueryContext(ctx, `
+		SELECT day, size_bytes, storage_cost_usd, bandwidth_cost_usd, total_cost_usd
+		FROM repository_cost_history
+		WHERE repository_id = $1 AND day > CURRENT_DATE - $2::int
+		ORDER BY day`, repoID, days)
+	if err != nil {
+		return nil, err
+	}
+	defer trend.Close()
+	for trend.Next() {
+		var p CostPoint
+		var day time.Time
+		if err := trend.Scan(&day, &p.SizeBytes, &p.StorageCostUSD, &p.BandwidthCostUSD, &p.TotalCostUSD); err != nil {
+			return nil, err
+		}
+		p.Day = day.Format("2006-01-02")
+		rc.Trend = append(rc.Trend, p)
+	}
+	rc.CostTrend = "stable"
+	if len(rc.Trend) > 0 {
+		rc.CostTrend = costTrend(rc.Trend[0].TotalCostUSD, rc.TotalCostUSD)
+	}
+	return rc, trend.Err()
+}
+
+// costTrend compares the current cost with an earlier one; changes within 5%
+// are stable.
+func costTrend(before, now float64) string {
+	switch {
+	case now > before*1.05:
+		return "increasing"
+	case now < before*0.95:
+		return "decreasing"
+	}
+	return "stable"
+}
+
+// SnapshotRepositoryCosts records today's costs of every repository for the
+// trend, replacing an earlier snapshot of the same day, and drops snapshots
+// older than MaxTrendDays.
+func (s *Service) SnapshotRepositoryCosts(ctx context.Context) error {
+	classes, err := s.classBytes(ctx, uuid.NullUUID{})
+	if err != nil {
+		return err
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT r.id, m.id, COALESCE(m.size, 0), COALESCE(m.pull_count, 0)
+		FROM repositories r
+		LEFT JOIN manifests m ON m.repository_id = r.id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type repoTotals struct {
+		size, pulls               int64
+		storage, bandwidth, total float64
+	}
+	totals := map[uuid.UUID]*repoTotals{}
+	for rows.Next() {
+		var repoID uuid.UUID
+		var manifestID uuid.NullUUID
+		var size int64
+		var pulls int
+		if err := rows.Scan(&repoID, &manifestID, &size, &pulls); err != nil {
+			return err
+		}
+		t := totals[repoID]
+		if t == nil {
+			t = &repoTotals{}
+			totals[repoID] = t
+		}
+		if !manifestID.Valid {
+			continue
+		}
+		cost := s.CalculateImageCostByClass(size, classes[manifestID.UUID], pulls)
+		t.size += size
+		t.pulls += int64(pulls)
+		t.storage += cost.StorageCostUSD
+		t.bandwidth += cost.BandwidthCostUSD
+		t.total += cost.TotalCostUSD
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var ids []string
+	var sizes, pulls []int64
+	var storage, bandwidth, total []float64
+	for id, t := range totals {
+		ids = append(ids, id.String())
+		sizes, pulls = append(sizes, t.size), append(pulls, t.pulls)
+		storage, bandwidth, total = append(storage, t.storage), append(bandwidth, t.bandwidth), append(total, t.total)
+	}
+	_, err = s.DB.ExecContext(ctx, `
+		INSERT INTO repository_cost_history (repository_id, day, size_bytes, pull_count, storage_cost_usd, bandwidth_cost_usd, total_cost_usd)
+		SELECT id, CURRENT_DATE, size, pulls, storage, bandwidth, total
+		FROM unnest($1::uuid[], $2::bigint[], $3::bigint[], $4::float8[], $5::float8[], $6::float8[])
+		     AS v(id, size, pulls, storage, bandwidth, total)
+		ON CONFLICT (repository_id, day) DO UPDATE SET
+			size_bytes = EXCLUDED.size_bytes,
+			pull_count = EXCLUDED.pull_count,
+			storage_cost_usd = EXCLUDED.storage_cost_usd,
+			bandwidth_cost_usd = EXCLUDED.bandwidth_cost_usd,
+			total_cost_usd = EXCLUDED.total_cost_usd`,
+		pq.Array(ids), pq.Array(sizes), pq.Array(pulls), pq.Array(storage), pq.Array(bandwidth), pq.Array(total))
+	if err != nil {
+		return err
+	}
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM repository_cost_history WHERE day < CURRENT_DATE - $1::int`, MaxTrendDays); err != nil {
+		return err
+	}
+	fmt.Printf("[Costs] Recorded cost snapshot of %d repositories\n", len(ids))
+	return nil
+}
+
+// StartSnapshots records a repository cost snapshot now and then every
+// interval.
+func (s *Service) StartSnapshots(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.SnapshotRepositoryCosts(ctx); err != nil {
+			fmt.Printf("[Costs] Cost snapshot failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
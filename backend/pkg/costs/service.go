@@ -4,21 +4,36 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/storage"
 )
 
 // Service handles cost calculation and optimization
 type Service struct {
 	DB     *sql.DB
 	Config *CostConfig
+
+	// Classes reports blob storage classes; nil prices everything as STANDARD
+	Classes storage.ClassLister
+
+	// Metadata and Storage remove the stored manifests of deleted zombie
+	// images; set by main
+	Metadata *metadata.Service
+	Storage  storage.Driver
+
+	refreshing sync.Mutex // one Refresh at a time
 }
 
 // CostConfig holds pricing configuration
 type CostConfig struct {
 	StorageCostPerGBMonth float64 // e.g., $0.023 for S3 Standard
 	BandwidthCostPerGB    float64 // e.g., $0.09 for S3 egress
+	StorageClassCostPerGBMonth map[string]float64 // e.g., $0.004 for GLACIER_IR; see DefaultStorageClassCosts
 	RegistryRegion        string
 }
 
@@ -56,6 +71,13 @@ type CostDashboard struct {
 	PotentialSavingsUSD   float64     `json:"potential_savings_usd"`
 	TopExpensiveImages    []ImageCost `json:"top_expensive_images"`
 	CostTrend             string      `json:"cost_trend"`
+
+	// Storage actually billed once shared layers are counted once
+	EffectiveStorageCostUSD float64 `json:"effective_storage_cost_usd"`
+	DedupSavingsRatio       float64 `json:"dedup_savings_ratio"`
+
+	// When the last successful refresh finished (nil before the first one)
+	LastRefreshedAt *time.Time `json:"last_refreshed_at,omitempty"`
 }
 
 // NewService creates a new cost service
@@ -65,6 +87,7 @@ func NewService(db *sql.DB, config *CostConfig) *Service {
 		config = &CostConfig{
 			StorageCostPerGBMonth: 0.023,
 			BandwidthCostPerGB:    0.09,
+			StorageClassCostPerGBMonth: DefaultStorageClassCosts,
 			RegistryRegion:        "us-east-1",
 		}
 	}
@@ -97,16 +120,27 @@ func (s *Service) CalculateImageCost(sizeBytes int64, pullCount int) ImageCost {
 	}
 }
 
-// RefreshAllCosts recalculates costs for all images
-func (s *Service) RefreshAllCosts(ctx context.Context) error {
+// RefreshAllCosts recalculates costs for all images, pricing layers by the
+// storage class they are in, and returns how many it refreshed
+func (s *Service) RefreshAllCosts(ctx context.Context) (int, error) {
 	fmt.Println("[Costs] Refreshing cost data for all images...")
+
+	if n, err := s.SyncStorageClasses(ctx); err != nil {
+		fmt.Printf("[Costs] Failed to sync storage classes: %v\n", err)
+	} else if n > 0 {
+		fmt.Printf("[Costs] %d blobs changed storage class\n", n)
+	}
+	classes, err := s.classBytes(ctx, uuid.NullUUID{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query storage classes: %w", err)
+	}
 	
 	rows, err := s.DB.QueryContext(ctx, `
 		SELECT m.id, m.size, COALESCE(m.pull_count, 0), m.last_pulled_at
 		FROM manifests m
 	`)
 	if err != nil {
-		return fmt.Errorf("failed to query manifests: %w", err)
+		return 0, fmt.Errorf("failed to query manifests: %w", err)
 	}
 	defer rows.Close()
 	
@@ -121,7 +155,7 @@ func (s *Service) RefreshAllCosts(ctx context.Context) error {
 			continue
 		}
 		
-		cost := s.CalculateImageCost(size, pullCount)
+		cost := s.CalculateImageCostByClass(size, classes[manifestID], pullCount)
 		
 		// Store in database
 		_, err := s.DB.ExecContext(ctx, `
@@ -149,7 +183,7 @@ func (s *Service) RefreshAllCosts(ctx context.Context) error {
 	}
 	
 	fmt.Printf("[Costs] Refreshed costs for %d images\n", count)
-	return nil
+	return count, s.SnapshotRepositoryCosts(ctx)
 }
 
 // GetDashboard returns the cost dashboard summary, filtered by user permission
@@ -161,7 +195,7 @@ func (s *Service) GetDashboard(ctx context.Context, userID uuid.UUID, role strin
 	whereClause := "1=1"
 	args := []interface{}{}
 	if role != "admin" {
-		whereClause = "r.owner_id = $1"
+		whereClause = authz.RepositoryFilter("$1", authz.RoleRead)
 		args = append(args, userID)
 	}
 
@@ -248,6 +282,19 @@ func (s *Service) GetDashboard(ctx context.Context, userID uuid.UUID, role strin
 	}
 	
 	dashboard.CostTrend = "stable"
+
+	// 4. Effective storage cost after layer deduplication
+	if dedup, err := s.GetDedupReport(ctx, userID, role); err == nil {
+		dashboard.EffectiveStorageCostUSD = dedup.Total.EffectiveStorageCostUSD
+		dashboard.DedupSavingsRatio = dedup.Total.SavingsRatio
+	} else {
+		fmt.Printf("[Costs] Failed to compute dedup savings: %v\n", err)
+	}
+
+	// 5. Freshness of the cost data
+	if at, err := s.lastRefreshed(ctx); err == nil {
+		dashboard.LastRefreshedAt = at
+	}
 	
 	return dashboard, nil
 }
@@ -262,7 +309,7 @@ func (s *Service) DetectZombieImages(ctx context.Context, daysThreshold int, use
 	whereClause := "1=1"
 	args := []interface{}{daysThreshold}
 	if role != "admin" {
-		whereClause = "r.owner_id = $2"
+		whereClause = authz.RepositoryFilter("$2", authz.RoleRead)
 		args = append(args, userID)
 	}
 
@@ -351,7 +398,7 @@ func (s *Service) CleanupZombies(ctx context.Context, daysThreshold int, dryRun
 	whereClause := "1=1"
 	args := []interface{}{daysThreshold}
 	if role != "admin" {
-		whereClause = "r.owner_id = $2"
+		whereClause = authz.RepositoryFilter("$2", authz.RoleWrite)
 		args = append(args, userID)
 	}
 
@@ -384,12 +431,26 @@ func (s *Service) CleanupZombies(ctx context.Context, daysThreshold int, dryRun
 		fmt.Printf("[Costs] Found zombie to delete: %s (days=%d)\n", manifestID, days)
 		
 		if !dryRun {
+			var paths []string
+			if s.Metadata != nil {
+				paths, err = s.Metadata.ManifestObjectPaths(ctx, manifestID)
+				if err != nil {
+					fmt.Printf("[Costs] Failed to look up stored objects of manifest %s: %v\n", manifestID, err)
+					continue
+				}
+			}
+
 			// Delete manifest
 			_, err := s.DB.ExecContext(ctx, `DELETE FROM manifests WHERE id = $1`, manifestID)
 			if err != nil {
 				fmt.Printf("[Costs] Failed to delete manifest %s: %v\n", manifestID, err)
 				continue
 			}
+			if s.Storage != nil {
+				for _, err := range storage.DeleteAll(ctx, s.Storage, paths) {
+					fmt.Printf("[Costs] Failed to delete stored manifest %v\n", err)
+				}
+			}
 			
 			// Also remove from zombie_images
 			s.DB.ExecContext(ctx, `DELETE FROM zombie_images WHERE manifest_id = $1`, manifestID)
@@ -4,12 +4,12 @@ import (
 	"database/sql"
 	"fmt"
 
-	_ "github.com/lib/pq"
 	"github.com/registryx/registryx/backend/pkg/config"
+	"github.com/registryx/registryx/backend/pkg/diagnostics"
 )
 
 func Connect(cfg *config.Config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.DBUrl)
+	db, err := sql.Open(diagnostics.DriverName, cfg.DBUrl)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open db: %w", err)
 	}
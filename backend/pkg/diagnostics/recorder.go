@@ -0,0 +1,256 @@
+package diagnostics
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Latency samples kept per route for percentile calculation.
+	samplesPerRoute = 1024
+	// Slow requests kept for the diagnostics endpoint.
+	maxSlowRequests = 50
+)
+
+// Recorder tracks per-route latency and error rates for the API.
+type Recorder struct {
+	slowThreshold time.Duration
+	startedAt     time.Time
+
+	mu     sync.Mutex
+	routes map[string]*routeStats
+	slow   []SlowRequest // ring buffer, oldest overwritten first
+	slowAt int
+}
+
+type routeStats struct {
+	method       string
+	route        string
+	count        int64
+	errors       int64 // 5xx responses
+	clientErrors int64 // 4xx responses
+	samples      []time.Duration
+	next         int
+	max          time.Duration
+}
+
+// RouteStats is a point-in-time summary of one route.
+type RouteStats struct {
+	Method       string  `json:"method"`
+	Route        string  `json:"route"`
+	Count        int64   `json:"count"`
+	Errors       int64   `json:"errors"`
+	ClientErrors int64   `json:"clientErrors"`
+	ErrorRate    float64 `json:"errorRate"`
+	P50Ms        float64 `json:"p50Ms"`
+	P90Ms        float64 `json:"p90Ms"`
+	P99Ms        float64 `json:"p99Ms"`
+	MaxMs        float64 `json:"maxMs"`
+}
+
+// SlowRequest is a request that exceeded the slow threshold, with the SQL
+// that ran while serving it.
+type SlowRequest struct {
+	Method     string        `json:"method"`
+	Route      string        `json:"route"`
+	Path       string        `json:"path"`
+	Status     int           `json:"status"`
+	DurationMs float64       `json:"durationMs"`
+	SQLCount   int           `json:"sqlCount"`
+	SQLMs      float64       `json:"sqlMs"`
+	Queries    []QueryTiming `json:"queries"`
+	At         time.Time     `json:"at"`
+}
+
+func NewRecorder(slowThreshold time.Duration) *Recorder {
+	return &Recorder{
+		slowThreshold: slowThreshold,
+		startedAt:     time.Now(),
+		routes:        make(map[string]*routeStats),
+	}
+}
+
+// Middleware records every request matched by the router. Register it with
+// Router.Use so the matched route template is available.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		trace := &requestTrace{}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(sw, r.WithContext(withTrace(r.Context(), trace)))
+		elapsed := time.Since(start)
+
+		// Event streams stay open for the life of the connection.
+		if strings.HasPrefix(sw.Header().Get("Content-Type"), "text/event-stream") {
+			return
+		}
+		rec.observe(r.Method, route, sw.status, elapsed)
+
+		if rec.slowThreshold > 0 && elapsed >= rec.slowThreshold {
+			rec.recordSlow(r, route, sw.status, elapsed, trace)
+		}
+	})
+}
+
+func (rec *Recorder) observe(method, route string, status int, d time.Duration) {
+	key := method + " " + route
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rs, ok := rec.routes[key]
+	if !ok {
+		rs = &routeStats{method: method, route: route}
+		rec.routes[key] = rs
+	}
+	rs.count++
+	if status >= 500 {
+		rs.errors++
+	} else if status >= 400 {
+		rs.clientErrors++
+	}
+	if d > rs.max {
+		rs.max = d
+	}
+	if len(rs.samples) < samplesPerRoute {
+		rs.samples = append(rs.samples, d)
+	} else {
+		rs.samples[rs.next] = d
+		rs.next = (rs.next + 1) % samplesPerRoute
+	}
+}
+
+func (rec *Recorder) recordSlow(r *http.Request, route string, status int, d time.Duration, trace *requestTrace) {
+	trace.mu.Lock()
+	queries := append([]QueryTiming(nil), trace.slowest...)
+	sr := SlowRequest{
+		Method:     r.Method,
+		Route:      route,
+		Path:       r.URL.Path,
+		Status:     status,
+		DurationMs: ms(d),
+		SQLCount:   trace.count,
+		SQLMs:      ms(trace.total),
+		At:         time.Now(),
+	}
+	trace.mu.Unlock()
+
+	sort.Slice(queries, func(i, j int) bool { return queries[i].DurationMs > queries[j].DurationMs })
+	sr.Queries = queries
+
+	log.Printf("[Diagnostics] Slow request: %s %s took %.0fms (status %d, %d queries, %.0fms in SQL)",
+		sr.Method, sr.Path, sr.DurationMs, sr.Status, sr.SQLCount, sr.SQLMs)
+	for i, q := range queries {
+		if i == 3 {
+			break
+		}
+		log.Printf("[Diagnostics]   %.1fms  %s", q.DurationMs, q.SQL)
+	}
+
+	rec.mu.Lock()
+	if len(rec.slow) < maxSlowRequests {
+		rec.slow = append(rec.slow, sr)
+	} else {
+		rec.slow[rec.slowAt] = sr
+		rec.slowAt = (rec.slowAt + 1) % maxSlowRequests
+	}
+	rec.mu.Unlock()
+}
+
+// Routes returns per-route statistics, slowest p99 first.
+func (rec *Recorder) Routes() []RouteStats {
+	rec.mu.Lock()
+	out := make([]RouteStats, 0, len(rec.routes))
+	for _, rs := range rec.routes {
+		sorted := append([]time.Duration(nil), rs.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		s := RouteStats{
+			Method:       rs.method,
+			Route:        rs.route,
+			Count:        rs.count,
+			Errors:       rs.errors,
+			ClientErrors: rs.clientErrors,
+			P50Ms:        ms(percentile(sorted, 0.50)),
+			P90Ms:        ms(percentile(sorted, 0.90)),
+			P99Ms:        ms(percentile(sorted, 0.99)),
+			MaxMs:        ms(rs.max),
+		}
+		if rs.count > 0 {
+			s.ErrorRate = float64(rs.errors) / float64(rs.count)
+		}
+		out = append(out, s)
+	}
+	rec.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].P99Ms > out[j].P99Ms })
+	return out
+}
+
+// SlowRequests returns the most recent slow requests, newest first.
+func (rec *Recorder) SlowRequests() []SlowRequest {
+	rec.mu.Lock()
+	out := append([]SlowRequest(nil), rec.slow...)
+	rec.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].At.After(out[j].At) })
+	return out
+}
+
+// Uptime reports how long the recorder has been collecting.
+func (rec *Recorder) Uptime() time.Duration {
+	return time.Since(rec.startedAt)
+}
+
+// SlowThreshold is the duration above which requests are logged.
+func (rec *Recorder) SlowThreshold() time.Duration {
+	return rec.slowThreshold
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// statusWriter captures the response status while keeping streaming working.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.status = code
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
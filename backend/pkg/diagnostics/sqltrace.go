@@ -0,0 +1,158 @@
+package diagnostics
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DriverName is the database/sql driver that wraps lib/pq and attributes
+// query timings to the request that issued them.
+const DriverName = "postgres-traced"
+
+// Only the slowest queries of a request are kept for the slow-request log.
+const maxTracedQueries = 10
+
+func init() {
+	sql.Register(DriverName, tracingDriver{&pq.Driver{}})
+}
+
+// QueryTiming is a single SQL statement executed while serving a request.
+type QueryTiming struct {
+	SQL        string  `json:"sql"`
+	DurationMs float64 `json:"durationMs"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// requestTrace accumulates the SQL work done on behalf of one request.
+type requestTrace struct {
+	mu      sync.Mutex
+	count   int
+	total   time.Duration
+	slowest []QueryTiming
+}
+
+type traceKey struct{}
+
+func withTrace(ctx context.Context, t *requestTrace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+func recordQuery(ctx context.Context, query string, d time.Duration, err error) {
+	t, ok := ctx.Value(traceKey{}).(*requestTrace)
+	if !ok {
+		return
+	}
+	qt := QueryTiming{SQL: compactSQL(query), DurationMs: ms(d)}
+	if err != nil && err != driver.ErrSkip {
+		qt.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count++
+	t.total += d
+	if len(t.slowest) < maxTracedQueries {
+		t.slowest = append(t.slowest, qt)
+		return
+	}
+	// Replace the fastest kept query if this one was slower.
+	min := 0
+	for i := range t.slowest {
+		if t.slowest[i].DurationMs < t.slowest[min].DurationMs {
+			min = i
+		}
+	}
+	if qt.DurationMs > t.slowest[min].DurationMs {
+		t.slowest[min] = qt
+	}
+}
+
+// compactSQL collapses whitespace so multi-line queries log on one line.
+func compactSQL(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > 300 {
+		query = query[:300] + "..."
+	}
+	return query
+}
+
+type tracingDriver struct {
+	driver.Driver
+}
+
+func (d tracingDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracingConn{c}, nil
+}
+
+// tracingConn times queries and executions and passes everything else
+// straight through to the pq connection.
+type tracingConn struct {
+	driver.Conn
+}
+
+func (c *tracingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	recordQuery(ctx, query, time.Since(start), err)
+	return rows, err
+}
+
+func (c *tracingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := e.ExecContext(ctx, query, args)
+	recordQuery(ctx, query, time.Since(start), err)
+	return res, err
+}
+
+func (c *tracingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *tracingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *tracingConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracingConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracingConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
@@ -25,12 +25,6 @@ func (s *Service) SendResetEmail(to, token string) error {
         return nil
     }
 
-    auth := smtp.PlainAuth("", s.Config.SMTPUser, s.Config.SMTPPass, s.Config.SMTPHost)
-    
-    // Construct message
-    subject := "Subject: Password Reset Request\n"
-    mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-    
     // Using localhost link for now
     // Ideally this should come from config.FrontendURL
     link := fmt.Sprintf("http://localhost:5173/reset-password?token=%s", token)
@@ -47,14 +41,27 @@ func (s *Service) SendResetEmail(to, token string) error {
     </html>
     `, link)
     
-    msg := []byte(subject + mime + body)
+    if err := s.Send(to, "Password Reset Request", body); err != nil {
+        return err
+    }
+    
+    fmt.Printf("[Email] Sent reset link to %s\n", to)
+    return nil
+}
+
+// Send delivers an HTML email. Callers check IsEnabled first.
+func (s *Service) Send(to, subject, htmlBody string) error {
+    auth := smtp.PlainAuth("", s.Config.SMTPUser, s.Config.SMTPPass, s.Config.SMTPHost)
+    
+    // Construct message
+    header := "Subject: " + subject + "\n"
+    mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+    msg := []byte(header + mime + htmlBody)
     
     addr := fmt.Sprintf("%s:%s", s.Config.SMTPHost, s.Config.SMTPPort)
     err := smtp.SendMail(addr, auth, s.Config.SMTPFrom, []string{to}, msg)
     if err != nil {
         return fmt.Errorf("failed to send email: %v", err)
     }
-    
-    fmt.Printf("[Email] Sent reset link to %s\n", to)
     return nil
 }
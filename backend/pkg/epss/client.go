@@ -7,12 +7,14 @@ import (
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/registryx/registryx/backend/pkg/httpclient"
 )
 
 // Client handles communication with the EPSS API
 type Client struct {
 	BaseURL    string
-	HTTPClient *http.Client
+	HTTPClient *httpclient.Client
 }
 
 // EPSSScore represents the EPSS data for a CVE
@@ -36,11 +38,13 @@ type EPSSResponse struct {
 
 // NewClient creates a new EPSS API client
 func NewClient() *Client {
+	// The API is rate limited and occasionally slow; retry rather than
+	// lose a batch of scores, and back off entirely while it is down.
+	hc := httpclient.New("EPSS API")
+	hc.Timeout = 30 * time.Second
 	return &Client{
-		BaseURL: "https://api.first.org/data/v1",
-		HTTPClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		BaseURL:    "https://api.first.org/data/v1",
+		HTTPClient: hc,
 	}
 }
 
@@ -0,0 +1,102 @@
+// Package errcode writes OCI distribution error responses:
+//
+//	{"errors": [{"code": "BLOB_UNKNOWN", "message": "...", "detail": ...}]}
+package errcode
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Code is an OCI distribution error code with its default message and status.
+type Code struct {
+	Value   string
+	Message string
+	Status  int
+}
+
+var (
+	BlobUnknown         = Code{"BLOB_UNKNOWN", "blob unknown to registry", http.StatusNotFound}
+	BlobUploadInvalid   = Code{"BLOB_UPLOAD_INVALID", "blob upload invalid", http.StatusBadRequest}
+	BlobUploadUnknown   = Code{"BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry", http.StatusNotFound}
+	DigestInvalid       = Code{"DIGEST_INVALID", "provided digest did not match uploaded content", http.StatusBadRequest}
+	ManifestBlobUnknown = Code{"MANIFEST_BLOB_UNKNOWN", "blob unknown to registry", http.StatusBadRequest}
+	ManifestInvalid     = Code{"MANIFEST_INVALID", "manifest invalid", http.StatusBadRequest}
+	ManifestUnknown     = Code{"MANIFEST_UNKNOWN", "manifest unknown", http.StatusNotFound}
+	RangeInvalid        = Code{"RANGE_INVALID", "invalid content range", http.StatusRequestedRangeNotSatisfiable}
+	NameInvalid         = Code{"NAME_INVALID", "invalid repository name", http.StatusBadRequest}
+	NameUnknown         = Code{"NAME_UNKNOWN", "repository name not known to registry", http.StatusNotFound}
+	SizeInvalid         = Code{"SIZE_INVALID", "provided length did not match content length", http.StatusBadRequest}
+	Unauthorized        = Code{"UNAUTHORIZED", "authentication required", http.StatusUnauthorized}
+	Denied              = Code{"DENIED", "requested access to the resource is denied", http.StatusForbidden}
+	Unsupported         = Code{"UNSUPPORTED", "the operation is unsupported", http.StatusMethodNotAllowed}
+	TooManyRequests     = Code{"TOOMANYREQUESTS", "too many requests", http.StatusTooManyRequests}
+	Unavailable         = Code{"UNAVAILABLE", "service unavailable", http.StatusServiceUnavailable}
+	// Unknown covers internal failures; details stay in the server log.
+	Unknown = Code{"UNKNOWN", "unknown error", http.StatusInternalServerError}
+)
+
+func (c Code) Error() string {
+	return c.Value + ": " + c.Message
+}
+
+// WithMessage returns an error for c with a more specific message.
+func (c Code) WithMessage(message string) Error {
+	return Error{Code: c, Message: message}
+}
+
+// WithDetail returns an error for c carrying structured detail.
+func (c Code) WithDetail(detail interface{}) Error {
+	return Error{Code: c, Message: c.Message, Detail: detail}
+}
+
+// Error is a single entry of the errors envelope.
+type Error struct {
+	Code    Code
+	Message string
+	Detail  interface{}
+}
+
+func (e Error) Error() string {
+	return e.Code.Value + ": " + e.Message
+}
+
+// WithDetail returns a copy of e carrying structured detail.
+func (e Error) WithDetail(detail interface{}) Error {
+	e.Detail = detail
+	return e
+}
+
+type jsonError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Detail  interface{} `json:"detail,omitempty"`
+}
+
+// ServeJSON writes err as an OCI error envelope. Errors that are not a Code or
+// Error are reported as UNKNOWN without exposing their text.
+func ServeJSON(w http.ResponseWriter, err error) {
+	var e Error
+	var c Code
+	switch {
+	case errors.As(err, &e):
+	case errors.As(err, &c):
+		e = Error{Code: c, Message: c.Message}
+	default:
+		e = Error{Code: Unknown, Message: Unknown.Message}
+	}
+
+	status := e.Code.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Errors []jsonError `json:"errors"`
+	}{
+		Errors: []jsonError{{Code: e.Code.Value, Message: e.Message, Detail: e.Detail}},
+	})
+}
@@ -0,0 +1,149 @@
+// Package events fans out registry activity (pushes, scan results, policy
+// denials, GC runs) to live dashboard clients.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Channel is the Redis pub/sub channel used to share events between instances.
+const Channel = "registryx:events"
+
+// Event types pushed to subscribers.
+const (
+	TypePush         = "push"
+	TypeScanStarted  = "scan.started"
+	TypeScanProgress = "scan.progress"
+	TypeScanComplete = "scan.completed"
+	TypeScanFailed   = "scan.failed"
+	TypePolicyDenied = "policy.denied"
+	TypeGC           = "gc.completed"
+	TypeMaintenance  = "maintenance.changed"
+	TypeTagExpired   = "tag.expired"
+	TypeRetention    = "retention.applied"
+	TypeQuota        = "quota.threshold"
+	TypeBlobCorrupt  = "blob.corrupt"
+)
+
+type Event struct {
+	Type       string                 `json:"type"`
+	Repository string                 `json:"repository,omitempty"`
+	Reference  string                 `json:"reference,omitempty"`
+	Digest     string                 `json:"digest,omitempty"`
+	User       string                 `json:"user,omitempty"` // ID of the user that caused the event
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Timestamp  time.Time              `json:"timestamp"`
+}
+
+// Broker delivers events to in-process subscribers. When a Redis client is
+// available, events go through Redis pub/sub so every instance sees them.
+// A nil *Broker is valid and drops everything.
+type Broker struct {
+	rdb *redis.Client
+
+	mu   sync.RWMutex
+	subs map[chan Event]struct{}
+}
+
+func NewBroker(rdb *redis.Client) *Broker {
+	b := &Broker{
+		rdb:  rdb,
+		subs: make(map[chan Event]struct{}),
+	}
+	if rdb != nil {
+		go b.relay()
+	}
+	return b
+}
+
+// Publish sends an event to all subscribers. It never blocks the caller.
+func (b *Broker) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+
+	if b.rdb != nil {
+		payload, err := json.Marshal(e)
+		if err == nil {
+			err = b.rdb.Publish(context.Background(), Channel, payload).Err()
+		}
+		if err == nil {
+			return
+		}
+		fmt.Printf("[Events] Redis publish failed, delivering locally: %v\n", err)
+	}
+	b.dispatch(e)
+}
+
+// Subscribe registers a new subscriber. The returned function must be called
+// to release it.
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	if b == nil {
+		return ch, func() {}
+	}
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+		})
+	}
+}
+
+func (b *Broker) dispatch(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// Slow client; drop rather than stall publishers.
+		}
+	}
+}
+
+// relay forwards events received from Redis to local subscribers.
+func (b *Broker) relay() {
+	for {
+		sub := b.rdb.Subscribe(context.Background(), Channel)
+		for msg := range sub.Channel() {
+			var e Event
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				continue
+			}
+			b.dispatch(e)
+		}
+		sub.Close()
+		fmt.Println("[Events] Redis subscription closed, reconnecting in 5s...")
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Visible reports whether a user may see the event. Admins see everything;
+// other users see events they caused and events for repositories canRead
+// allows.
+func Visible(e Event, userID, role string, canRead func(repository string) bool) bool {
+	if role == "admin" {
+		return true
+	}
+	if e.User != "" && e.User == userID {
+		return true
+	}
+	return e.Repository != "" && canRead != nil && canRead(e.Repository)
+}
@@ -0,0 +1,171 @@
+// Package gc records garbage collection runs, makes sure only one runs at a
+// time across instances, and starts them on a cron schedule.
+package gc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// What started a run.
+const (
+	TriggerManual    = "manual"
+	TriggerSchedule  = "schedule"
+	TriggerTagExpiry = "tag-expiry"
+)
+
+// Run statuses. A run is interrupted when its instance stopped before it
+// finished.
+const (
+	StatusRunning     = "running"
+	StatusSucceeded   = "succeeded"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+)
+
+// lockKey is the Postgres advisory lock held while a collection runs.
+const lockKey = 0x6763 // "gc"
+
+// historyRetention is how long finished runs are kept.
+const historyRetention = 90 * 24 * time.Hour
+
+var (
+	// ErrRunning is returned when another collection is running.
+	ErrRunning = errors.New("garbage collection is already running")
+	// ErrClaimed is returned when another instance ran a scheduled
+	// occurrence.
+	ErrClaimed = errors.New("scheduled garbage collection already ran")
+)
+
+// Trigger says what starts a run.
+type Trigger struct {
+	Kind string
+	User string // ID of the user who started a manual run
+	// Slot is the time a scheduled run was scheduled for.
+	Slot *time.Time
+}
+
+// Run is a recorded collection.
+type Run struct {
+	ID               uuid.UUID  `json:"id"`
+	Trigger          string     `json:"trigger"`
+	UserID           *uuid.UUID `json:"userId,omitempty"`
+	ScheduledFor     *time.Time `json:"scheduledFor,omitempty"`
+	Status           string     `json:"status"`
+	StartedAt        time.Time  `json:"startedAt"`
+	FinishedAt       *time.Time `json:"finishedAt,omitempty"`
+	BlobsDeleted     int64      `json:"blobsDeleted"`
+	ManifestsDeleted int64      `json:"manifestsDeleted"`
+	SpaceFreed       int64      `json:"spaceFreedBytes"`
+	Errors           []string   `json:"errors"`
+}
+
+// Service records runs in the gc_runs table.
+type Service struct {
+	DB *sql.DB
+}
+
+func NewService(db *sql.DB) *Service {
+	return &Service{DB: db}
+}
+
+// Begin takes the collection lock and records a run. Once the collection
+// is done, fill in what it deleted and call the returned function with its
+// error; that records the outcome and releases the lock. Begin fails with
+// ErrRunning while another collection runs, and for a scheduled run with
+// ErrClaimed once its occurrence ran elsewhere.
+func (s *Service) Begin(ctx context.Context, t Trigger) (*Run, func(err error), error) {
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, lockKey).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !locked {
+		conn.Close()
+		return nil, nil, ErrRunning
+	}
+	release := func() {
+		conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+		conn.Close()
+	}
+
+	// Holding the lock, nothing else runs: a run still marked running was
+	// cut short.
+	if _, err := conn.ExecContext(ctx, `
+		UPDATE gc_runs SET status = $1, finished_at = NOW() WHERE status = $2`, StatusInterrupted, StatusRunning); err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	run := &Run{Trigger: t.Kind, ScheduledFor: t.Slot, Status: StatusRunning, Errors: []string{}}
+	if uid, err := uuid.Parse(t.User); err == nil {
+		run.UserID = &uid
+	}
+	err = conn.QueryRowContext(ctx, `
+		INSERT INTO gc_runs (trigger, user_id, scheduled_for, status)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (scheduled_for) DO NOTHING
+		RETURNING id, started_at`, run.Trigger, run.UserID, run.ScheduledFor, run.Status).Scan(&run.ID, &run.StartedAt)
+	if err == sql.ErrNoRows {
+		release()
+		return nil, nil, ErrClaimed
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	finish := func(runErr error) {
+		defer release()
+		run.Status = StatusSucceeded
+		if runErr != nil {
+			run.Status = StatusFailed
+			run.Errors = append(run.Errors, runErr.Error())
+		}
+		_, err := conn.ExecContext(context.Background(), `
+			UPDATE gc_runs SET status = $2, finished_at = NOW(),
+				blobs_deleted = $3, manifests_deleted = $4, space_freed = $5, errors = $6
+			WHERE id = $1`, run.ID, run.Status, run.BlobsDeleted, run.ManifestsDeleted, run.SpaceFreed, pq.Array(run.Errors))
+		if err == nil {
+			_, err = conn.ExecContext(context.Background(), `
+				DELETE FROM gc_runs WHERE finished_at < NOW() - $1::float8 * INTERVAL '1 second'`, historyRetention.Seconds())
+		}
+		if err != nil {
+			fmt.Printf("[GC] Failed to record run %s: %v\n", run.ID, err)
+		}
+	}
+	return run, finish, nil
+}
+
+// History returns the most recent runs, newest first.
+func (s *Service) History(ctx context.Context, limit int) ([]Run, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, trigger, user_id, scheduled_for, status, started_at, finished_at,
+			blobs_deleted, manifests_deleted, space_freed, errors
+		FROM gc_runs ORDER BY started_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var r Run
+		if err := rows.Scan(&r.ID, &r.Trigger, &r.UserID, &r.ScheduledFor, &r.Status, &r.StartedAt, &r.FinishedAt,
+			&r.BlobsDeleted, &r.ManifestsDeleted, &r.SpaceFreed, pq.Array(&r.Errors)); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
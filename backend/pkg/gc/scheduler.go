@@ -0,0 +1,45 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ParseSchedule parses a standard five-field cron expression ("0 3 * * *"),
+// or a descriptor such as "@daily" or "@every 6h".
+func ParseSchedule(expr string) (cron.Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// StartScheduler calls collect at every time of schedule until ctx is done,
+// with the time it was scheduled for. collect is expected to begin its run
+// with that time as the Trigger's Slot, so each occurrence runs on one
+// instance; ErrRunning and ErrClaimed are not reported as failures.
+func StartScheduler(ctx context.Context, schedule cron.Schedule, collect func(ctx context.Context, slot time.Time) error) {
+	for {
+		slot := schedule.Next(time.Now())
+		if slot.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(slot))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		err := collect(ctx, slot)
+		switch {
+		case errors.Is(err, ErrClaimed):
+		case errors.Is(err, ErrRunning):
+			fmt.Printf("[GC] Skipped scheduled collection: %v\n", err)
+		case err != nil:
+			fmt.Printf("[GC] Scheduled collection failed: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package georeplica
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// blobPrefix is the part of the bucket that is replicated. Manifests are
+// small and always served from the primary.
+const blobPrefix = "blobs/"
+
+// SyncResult summarizes one pass over a replica.
+type SyncResult struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Copied     int       `json:"copied"`
+	Pruned     int       `json:"pruned"`
+	Bytes      int64     `json:"bytes"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Status describes one replica for the admin API.
+type Status struct {
+	Region   string      `json:"region"`
+	Endpoint string      `json:"endpoint"`
+	Bucket   string      `json:"bucket"`
+	LastSync *SyncResult `json:"lastSync,omitempty"`
+}
+
+// Syncer copies blobs from the primary to every replica and removes blobs the
+// primary no longer has (after garbage collection).
+type Syncer struct {
+	Router *Router
+
+	running sync.Mutex // one pass at a time
+	mu      sync.Mutex
+	last    map[string]*SyncResult
+}
+
+func NewSyncer(rt *Router) *Syncer {
+	return &Syncer{Router: rt, last: make(map[string]*SyncResult)}
+}
+
+// Run syncs every interval until ctx is cancelled.
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.SyncAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll runs one pass over every replica.
+func (s *Syncer) SyncAll(ctx context.Context) {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	primary, ok := s.Router.Primary.(storage.Lister)
+	if !ok {
+		fmt.Println("[GeoReplica] Primary storage cannot list objects; sync disabled")
+		return
+	}
+	want := make(map[string]int64)
+	err := primary.List(ctx, blobPrefix, func(path string, size int64) error {
+		want[path] = size
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("[GeoReplica] Failed to list primary blobs: %v\n", err)
+		return
+	}
+
+	for _, rep := range s.Router.Replicas {
+		res := s.syncReplica(ctx, rep, want)
+		if res.Error != "" {
+			fmt.Printf("[GeoReplica] Sync to %s failed: %s\n", rep.Region, res.Error)
+		} else if res.Copied > 0 || res.Pruned > 0 {
+			fmt.Printf("[GeoReplica] Synced %s: copied %d blobs (%d bytes), pruned %d\n", rep.Region, res.Copied, res.Bytes, res.Pruned)
+		}
+		s.mu.Lock()
+		s.last[rep.Region] = res
+		s.mu.Unlock()
+	}
+}
+
+func (s *Syncer) syncReplica(ctx context.Context, rep *Replica, want map[string]int64) *SyncResult {
+	res := &SyncResult{StartedAt: time.Now()}
+	defer func() { res.FinishedAt = time.Now() }()
+
+	lister, ok := rep.Driver.(storage.Lister)
+	if !ok {
+		res.Error = "replica storage cannot list objects"
+		return res
+	}
+	have := make(map[string]int64)
+	if err := lister.List(ctx, blobPrefix, func(path string, size int64) error {
+		have[path] = size
+		return nil
+	}); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	var errs []string
+	for path, size := range want {
+		if got, ok := have[path]; ok && got == size {
+			continue
+		}
+		if err := copyObject(ctx, s.Router.Primary, rep.Driver, path); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+		res.Copied++
+		res.Bytes += size
+	}
+	for path := range have {
+		if _, ok := want[path]; ok {
+			continue
+		}
+		if err := rep.Driver.Delete(ctx, path); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+		res.Pruned++
+	}
+
+	if len(errs) > 0 {
+		if len(errs) > 5 {
+			errs = append(errs[:5], fmt.Sprintf("and %d more", len(errs)-5))
+		}
+		res.Error = strings.Join(errs, "; ")
+	}
+	return res
+}
+
+func copyObject(ctx context.Context, src, dst storage.Driver, path string) error {
+	reader, err := src.Reader(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	wc, err := dst.Writer(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(wc, reader); err != nil {
+		if a, ok := wc.(storage.Aborter); ok {
+			a.Abort()
+		}
+		return err
+	}
+	return wc.Close()
+}
+
+// Status reports each replica and its most recent sync.
+func (s *Syncer) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Status, 0, len(s.Router.Replicas))
+	for _, rep := range s.Router.Replicas {
+		st := Status{Region: rep.Region, Endpoint: rep.Endpoint, Bucket: rep.Bucket}
+		if last, ok := s.last[rep.Region]; ok {
+			res := *last
+			st.LastSync = &res
+		}
+		out = append(out, st)
+	}
+	return out
+}
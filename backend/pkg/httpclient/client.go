@@ -0,0 +1,212 @@
+// Package httpclient makes outbound HTTP calls resilient: each attempt has
+// its own timeout, transient failures are retried with exponential backoff,
+// and a circuit breaker stops calling an upstream that keeps failing until it
+// has had time to recover.
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the upstream while its circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Defaults of New.
+const (
+	DefaultAttempts         = 3
+	DefaultTimeout          = 10 * time.Second
+	DefaultBaseDelay        = 500 * time.Millisecond
+	DefaultMaxDelay         = 10 * time.Second
+	DefaultFailureThreshold = 5
+	DefaultCooldown         = time.Minute
+)
+
+// Client sends requests to one upstream.
+type Client struct {
+	Name string // upstream, for errors and logs
+	HTTP *http.Client
+
+	Attempts  int           // tries per call, including the first
+	Timeout   time.Duration // per attempt; zero for none
+	BaseDelay time.Duration // backoff before the second attempt, doubled after each
+	MaxDelay  time.Duration
+
+	// FailureThreshold consecutive failed calls open the circuit for
+	// Cooldown. After it a call goes through again: success closes the
+	// circuit, failure opens it for another Cooldown.
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// New returns a client for the named upstream with the default policy.
+func New(name string) *Client {
+	return &Client{
+		Name:             name,
+		HTTP:             &http.Client{},
+		Attempts:         DefaultAttempts,
+		Timeout:          DefaultTimeout,
+		BaseDelay:        DefaultBaseDelay,
+		MaxDelay:         DefaultMaxDelay,
+		FailureThreshold: DefaultFailureThreshold,
+		Cooldown:         DefaultCooldown,
+	}
+}
+
+// Do sends req, retrying network errors, 429 and 5xx responses. A request
+// with a body must be replayable (http.NewRequest sets GetBody for byte and
+// string readers). The last response is returned as is, whatever its status,
+// so callers keep their own status handling; its body must be closed.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if err := c.allow(); err != nil {
+		return nil, err
+	}
+
+	attempts := c.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var resp *http.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		resp, err = c.attempt(req, attempt)
+		if !retryable(req.Context(), resp, err) {
+			break
+		}
+		if attempt == attempts || (req.Body != nil && req.GetBody == nil) {
+			break
+		}
+
+		wait := c.backoff(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		fmt.Printf("[HTTP] %s %s %s failed (attempt %d/%d), retrying in %s: %s\n", c.Name, req.Method, req.URL.Redacted(), attempt, attempts, wait, describe(resp, err))
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+
+	// A caller cancelling isn't the upstream's fault.
+	if req.Context().Err() == nil {
+		c.record(!retryable(req.Context(), resp, err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Name, err)
+	}
+	return resp, nil
+}
+
+// attempt sends one try of req under the per-attempt timeout.
+func (c *Client) attempt(req *http.Request, n int) (*http.Response, error) {
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+	try := req.Clone(ctx)
+	if n > 1 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		try.Body = body
+	}
+
+	resp, err := c.HTTP.Do(try)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The timeout covers reading the body too; it ends when the body is
+	// closed.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// backoff returns how long to wait after a failed attempt: exponential with
+// jitter, or what a Retry-After header asks for, capped at MaxDelay.
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	wait := c.BaseDelay << (attempt - 1)
+	if c.BaseDelay > 0 {
+		wait += time.Duration(rand.Int63n(int64(c.BaseDelay)))
+	}
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			wait = time.Duration(secs) * time.Second
+		}
+	}
+	if c.MaxDelay > 0 && (wait > c.MaxDelay || wait < 0) {
+		wait = c.MaxDelay
+	}
+	return wait
+}
+
+// allow refuses calls while the circuit is open.
+func (c *Client) allow() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.openUntil) {
+		return fmt.Errorf("%s: %w until %s", c.Name, ErrCircuitOpen, c.openUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// record counts the outcome of a call towards the circuit breaker.
+func (c *Client) record(ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ok {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.FailureThreshold > 0 && c.failures >= c.FailureThreshold {
+		c.openUntil = time.Now().Add(c.Cooldown)
+		fmt.Printf("[HTTP] %s failed %d times in a row; not calling it for %s\n", c.Name, c.failures, c.Cooldown)
+	}
+}
+
+// retryable reports whether an attempt failed in a way worth retrying.
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+func describe(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
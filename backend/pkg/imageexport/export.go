@@ -0,0 +1,319 @@
+// Package imageexport writes a stored image as a tar archive for air-gapped
+// transfer, assembled from the registry's own manifests and blobs. The
+// archive is an OCI image layout, which podman, skopeo, crane and
+// `docker load` (Docker 25+) read; the docker format adds the manifest.json
+// of `docker save` so older Docker releases load it too.
+package imageexport
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// Archive formats.
+const (
+	FormatOCI    = "oci"
+	FormatDocker = "docker"
+)
+
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+
+	maxManifestSize = 4 * 1024 * 1024
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported image")
+	ErrNoPlatform  = errors.New("no manifest for the requested platform")
+	ErrMissingBlob = errors.New("blob missing from storage")
+)
+
+// Image is the stored image to export.
+type Image struct {
+	Repository string
+	Name       string // reference the image is loaded as, e.g. "registry.example.com/team/app"
+	Tag        string // empty when exported by digest
+	Digest     string
+	MediaType  string
+	Manifest   []byte
+
+	Format   string // FormatOCI (default) or FormatDocker
+	Platform string // "os/arch[/variant]" to export from an index; the docker format defaults to linux/amd64
+}
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *platform         `json:"platform,omitempty"`
+}
+
+type platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p *platform) String() string {
+	if p == nil {
+		return ""
+	}
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    *descriptor  `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// Archive is an export ready to be written. Everything it contains was
+// looked up by Prepare, so failures are reported before anything is sent.
+type Archive struct {
+	store storage.Driver
+	files []file
+}
+
+// file is one archive entry: content held in memory, or a blob read from
+// storage while writing.
+type file struct {
+	name    string
+	content []byte
+	blob    string // storage path
+	size    int64
+}
+
+// epoch is the modification time of every entry, so exporting the same
+// image twice produces the same archive.
+var epoch = time.Unix(0, 0)
+
+// Prepare resolves what img consists of: manifests of an index's images,
+// configs and layers. Blobs are checked to be in storage; foreign layers,
+// which registries don't distribute, are left out.
+func Prepare(ctx context.Context, store storage.Driver, img Image) (*Archive, error) {
+	if img.Format == "" {
+		img.Format = FormatOCI
+	}
+	if img.Format != FormatOCI && img.Format != FormatDocker {
+		return nil, fmt.Errorf("%w: unknown format %q", ErrUnsupported, img.Format)
+	}
+
+	layout := []byte(`{"imageLayoutVersion":"1.0.0"}`)
+	a := &Archive{store: store, files: []file{{name: "oci-layout", content: layout, size: int64(len(layout))}}}
+	added := make(map[string]bool)
+	addManifest := func(digest string, body []byte) {
+		if !added[digest] {
+			added[digest] = true
+			a.files = append(a.files, file{name: blobName(digest), content: body, size: int64(len(body))})
+		}
+	}
+
+	root := descriptor{MediaType: img.MediaType, Digest: img.Digest, Size: int64(len(img.Manifest))}
+	body := img.Manifest
+	var m manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("%w: invalid manifest: %v", ErrUnsupported, err)
+	}
+	if root.MediaType == "" {
+		root.MediaType = m.MediaType
+	}
+
+	// The docker format holds a single image, picked from an index by
+	// platform. The OCI format keeps the index, narrowed to one platform
+	// when asked for.
+	var images []descriptor
+	switch root.MediaType {
+	case mediaTypeDockerManifest, mediaTypeOCIManifest:
+		images = []descriptor{root}
+	case mediaTypeDockerManifestList, mediaTypeOCIIndex:
+		want := img.Platform
+		if want == "" && img.Format == FormatDocker {
+			want = "linux/amd64"
+		}
+		for _, d := range m.Manifests {
+			if d.MediaType != mediaTypeDockerManifest && d.MediaType != mediaTypeOCIManifest {
+				continue // e.g. attestation manifests that aren't images
+			}
+			if d.Platform != nil && d.Platform.OS == "unknown" {
+				continue
+			}
+			if want == "" || matchPlatform(d.Platform, want) {
+				images = append(images, d)
+			}
+		}
+		if len(images) == 0 {
+			return nil, fmt.Errorf("%w %s", ErrNoPlatform, want)
+		}
+		if want != "" {
+			images = images[:1]
+			root = images[0]
+		} else {
+			addManifest(root.Digest, body)
+		}
+	default:
+		return nil, fmt.Errorf("%w: manifest type %q", ErrUnsupported, root.MediaType)
+	}
+
+	type saved struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	var dockerManifest []saved
+
+	for _, d := range images {
+		imgBody := body
+		if d.Digest != img.Digest {
+			var err error
+			if imgBody, err = readManifest(ctx, store, img.Repository, d.Digest); err != nil {
+				return nil, err
+			}
+		}
+		var im manifest
+		if err := json.Unmarshal(imgBody, &im); err != nil || im.Config == nil {
+			return nil, fmt.Errorf("%w: invalid image manifest %s", ErrUnsupported, d.Digest)
+		}
+		addManifest(d.Digest, imgBody)
+
+		entry := saved{Config: blobName(im.Config.Digest)}
+		if img.Tag != "" {
+			entry.RepoTags = []string{img.Name + ":" + img.Tag}
+		}
+		for i, b := range append([]descriptor{*im.Config}, im.Layers...) {
+			if len(b.URLs) > 0 || strings.Contains(b.MediaType, "foreign") || strings.Contains(b.MediaType, "nondistributable") {
+				continue
+			}
+			if i > 0 {
+				entry.Layers = append(entry.Layers, blobName(b.Digest))
+			}
+			if added[b.Digest] {
+				continue
+			}
+			blobPath := path.Join("blobs", b.Digest)
+			size, err := store.Stat(ctx, blobPath)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrMissingBlob, b.Digest)
+			}
+			if size != b.Size {
+				return nil, fmt.Errorf("%w: %s is %d bytes, manifest says %d", ErrMissingBlob, b.Digest, size, b.Size)
+			}
+			added[b.Digest] = true
+			a.files = append(a.files, file{name: blobName(b.Digest), blob: blobPath, size: size})
+		}
+		dockerManifest = append(dockerManifest, entry)
+	}
+
+	// index.json points at the image or index; the annotations name it for
+	// the tools loading it.
+	if img.Tag != "" {
+		root.Annotations = map[string]string{
+			"org.opencontainers.image.ref.name": img.Tag,
+			"io.containerd.image.name":          img.Name + ":" + img.Tag,
+		}
+	}
+	root.URLs, root.Platform = nil, nil
+	index, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeOCIIndex,
+		"manifests":     []descriptor{root},
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.files = append(a.files, file{name: "index.json", content: index, size: int64(len(index))})
+
+	if img.Format == FormatDocker {
+		mj, err := json.Marshal(dockerManifest)
+		if err != nil {
+			return nil, err
+		}
+		a.files = append(a.files, file{name: "manifest.json", content: mj, size: int64(len(mj))})
+	}
+	return a, nil
+}
+
+// matchPlatform reports whether p is want, given as "os/arch" or
+// "os/arch/variant".
+func matchPlatform(p *platform, want string) bool {
+	if p == nil {
+		return false
+	}
+	return p.String() == want || p.OS+"/"+p.Architecture == want
+}
+
+// Size returns the length of the archive Write produces, for Content-Length.
+func (a *Archive) Size() int64 {
+	var n int64
+	for _, f := range a.files {
+		n += 512 + (f.size+511)/512*512
+	}
+	return n + 1024 // end-of-archive marker
+}
+
+// Write streams the archive to w.
+func (a *Archive) Write(ctx context.Context, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, f := range a.files {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Size:     f.size,
+			Mode:     0644,
+			ModTime:  epoch,
+			Format:   tar.FormatUSTAR,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if f.blob == "" {
+			if _, err := tw.Write(f.content); err != nil {
+				return err
+			}
+			continue
+		}
+		reader, err := a.store.Reader(ctx, f.blob)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", f.blob, err)
+		}
+		_, err = io.Copy(tw, reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", f.blob, err)
+		}
+	}
+	return tw.Close()
+}
+
+func readManifest(ctx context.Context, store storage.Driver, repo, digest string) ([]byte, error) {
+	reader, err := store.Reader(ctx, path.Join("manifests", repo, digest))
+	if err != nil {
+		return nil, fmt.Errorf("%w: manifest %s", ErrMissingBlob, digest)
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, maxManifestSize))
+}
+
+// blobName is where the OCI layout keeps a blob.
+func blobName(digest string) string {
+	alg, hex, _ := strings.Cut(digest, ":")
+	return path.Join("blobs", alg, hex)
+}
@@ -0,0 +1,152 @@
+// Package imagehistory reconstructs the build steps of an image from the
+// history array in its config blob, roughly as they appeared in the
+// Dockerfile, and matches each step to the layer it produced.
+package imagehistory
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrIndex is returned for manifest lists, which have no config of their own.
+var ErrIndex = errors.New("manifest is an index; request a platform manifest")
+
+// Step is one entry of the image history.
+type Step struct {
+	Index       int        `json:"index"`
+	Instruction string     `json:"instruction"` // e.g. RUN, COPY, ENV
+	Command     string     `json:"command"`     // reconstructed Dockerfile line
+	CreatedBy   string     `json:"createdBy"`   // raw history entry
+	Created     *time.Time `json:"created,omitempty"`
+	Author      string     `json:"author,omitempty"`
+	Comment     string     `json:"comment,omitempty"`
+	EmptyLayer  bool       `json:"emptyLayer"`
+	LayerDigest string     `json:"layerDigest,omitempty"`
+	Size        int64      `json:"size"`
+}
+
+// Manifest is the part of an image manifest the history needs.
+type Manifest struct {
+	MediaType   string            `json:"mediaType"`
+	Config      *Descriptor       `json:"config"`
+	Layers      []Descriptor      `json:"layers"`
+	Manifests   []Descriptor      `json:"manifests"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+}
+
+type config struct {
+	History []struct {
+		Created    *time.Time `json:"created"`
+		CreatedBy  string     `json:"created_by"`
+		Author     string     `json:"author"`
+		Comment    string     `json:"comment"`
+		EmptyLayer bool       `json:"empty_layer"`
+	} `json:"history"`
+}
+
+// ParseManifest decodes an image manifest. Indexes return ErrIndex.
+func ParseManifest(body []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	if len(m.Manifests) > 0 {
+		return nil, ErrIndex
+	}
+	if m.Config == nil || m.Config.Digest == "" {
+		return nil, errors.New("manifest has no config")
+	}
+	return &m, nil
+}
+
+// Reconstruct builds the step list from a config blob. Steps that produced a
+// layer are matched to the manifest's layers in order; if the counts do not
+// line up (squashed or hand-built images) sizes are left at zero.
+func Reconstruct(m *Manifest, configBlob []byte) ([]Step, error) {
+	var cfg config
+	if err := json.Unmarshal(configBlob, &cfg); err != nil {
+		return nil, err
+	}
+
+	layered := 0
+	for _, h := range cfg.History {
+		if !h.EmptyLayer {
+			layered++
+		}
+	}
+	matchLayers := layered == len(m.Layers)
+
+	steps := make([]Step, 0, len(cfg.History))
+	layer := 0
+	for i, h := range cfg.History {
+		instruction, command := parseCreatedBy(h.CreatedBy)
+		step := Step{
+			Index:       i,
+			Instruction: instruction,
+			Command:     command,
+			CreatedBy:   h.CreatedBy,
+			Created:     h.Created,
+			Author:      h.Author,
+			Comment:     h.Comment,
+			EmptyLayer:  h.EmptyLayer,
+		}
+		if !h.EmptyLayer {
+			if matchLayers {
+				step.LayerDigest = m.Layers[layer].Digest
+				step.Size = m.Layers[layer].Size
+			}
+			layer++
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+var (
+	// Classic builder metadata steps: /bin/sh -c #(nop)  CMD ["sh"]
+	nopPrefix = regexp.MustCompile(`^/bin/sh -c #\(nop\)\s*`)
+	// Shell RUN steps from the classic builder: /bin/sh -c apt-get update
+	shellPrefix = regexp.MustCompile(`^/bin/(ba)?sh -c\s+`)
+	// Classic builder records build args as "|2 FOO=bar BAZ=qux /bin/sh -c ..."
+	argsPrefix = regexp.MustCompile(`^\|\d+(\s+\S+=\S*)*\s+`)
+	// BuildKit steps start with the instruction and end with "# buildkit".
+	instructionPrefix = regexp.MustCompile(`^([A-Z]+)\s`)
+)
+
+// parseCreatedBy turns a created_by string into a Dockerfile instruction.
+func parseCreatedBy(createdBy string) (instruction, command string) {
+	s := strings.TrimSpace(createdBy)
+	s = strings.TrimSpace(strings.TrimSuffix(s, "# buildkit"))
+	if s == "" {
+		return "", ""
+	}
+
+	if nopPrefix.MatchString(s) {
+		s = nopPrefix.ReplaceAllString(s, "")
+	} else if m := instructionPrefix.FindStringSubmatch(s); m != nil {
+		// BuildKit: "RUN /bin/sh -c apk add curl" -> "RUN apk add curl"
+		if m[1] == "RUN" {
+			rest := strings.TrimSpace(strings.TrimPrefix(s, "RUN"))
+			rest = argsPrefix.ReplaceAllString(rest, "")
+			s = "RUN " + shellPrefix.ReplaceAllString(rest, "")
+		}
+	} else {
+		s = argsPrefix.ReplaceAllString(s, "")
+		s = "RUN " + shellPrefix.ReplaceAllString(s, "")
+	}
+
+	instruction = s
+	if i := strings.IndexAny(s, " \t"); i > 0 {
+		instruction = s[:i]
+	}
+	return strings.ToUpper(instruction), s
+}
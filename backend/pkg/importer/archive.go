@@ -0,0 +1,445 @@
+package importer
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidArchive is returned for uploads that aren't an image tarball.
+var ErrInvalidArchive = errors.New("invalid image archive")
+
+const (
+	mediaTypeOCIConfig     = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer      = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeOCILayerGzip  = "application/vnd.oci.image.layer.v1.tar+gzip"
+	annotationRefName      = "org.opencontainers.image.ref.name"
+	annotationContainerdID = "io.containerd.image.name"
+)
+
+// ArchiveImage is one image loaded from an archive.
+type ArchiveImage struct {
+	Reference string `json:"reference"` // tag, or digest for untagged images
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+}
+
+// ArchiveResult describes what an archive import stored.
+type ArchiveResult struct {
+	Repository  string         `json:"repository"`
+	Images      []ArchiveImage `json:"images"`
+	BlobsStored int            `json:"blobsStored"`
+	BytesStored int64          `json:"bytesStored"`
+}
+
+// archive is an unpacked upload: entry names mapped to spooled files.
+type archive struct {
+	dir   string
+	files map[string]string
+}
+
+// ImportArchive loads the images of an OCI image layout or `docker save`
+// tarball (optionally gzip-compressed) into repo and pushes them as userID,
+// so they are scanned like pushed images. tag names the image when the
+// archive holds a single one; otherwise the archive's own tags are used,
+// keeping only the part after the last colon. Untagged images are stored by
+// digest.
+func (s *Service) ImportArchive(ctx context.Context, body io.Reader, repo, tag string, userID uuid.UUID) (*ArchiveResult, error) {
+	if !repoName.MatchString(repo) {
+		return nil, fmt.Errorf("%w: invalid repository name %q", ErrInvalid, repo)
+	}
+	a, err := unpack(body)
+	if a != nil {
+		defer os.RemoveAll(a.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var images []archiveEntry
+	if _, ok := a.files["index.json"]; ok {
+		images, err = a.ociImages()
+	} else if _, ok := a.files["manifest.json"]; ok {
+		images, err = a.dockerImages()
+	} else {
+		err = fmt.Errorf("%w: neither index.json nor manifest.json found", ErrInvalidArchive)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("%w: archive contains no images", ErrInvalidArchive)
+	}
+	if tag != "" {
+		if len(images) > 1 {
+			return nil, fmt.Errorf("%w: archive holds %d images; omit the tag to use theirs", ErrInvalid, len(images))
+		}
+		images[0].tag = tag
+	}
+
+	res := &ArchiveResult{Repository: repo, Images: []ArchiveImage{}}
+	for _, img := range images {
+		loaded, err := s.loadImage(ctx, a, repo, img, userID, res)
+		if err != nil {
+			return nil, err
+		}
+		res.Images = append(res.Images, *loaded)
+	}
+	return res, nil
+}
+
+// archiveEntry is an image or index found in an archive. Images of a
+// legacy `docker save` archive have no manifest yet; it is built from their
+// config and layer files.
+type archiveEntry struct {
+	tag       string
+	digest    string
+	mediaType string
+	manifest  []byte
+	legacy    *legacyImage
+}
+
+type legacyImage struct {
+	config string
+	layers []string
+}
+
+// unpack spools the regular files of a tar stream to a temporary directory.
+// Entry names are never used as paths.
+func unpack(body io.Reader) (*archive, error) {
+	br := bufio.NewReader(body)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	dir, err := os.MkdirTemp("", "image-import-")
+	if err != nil {
+		return nil, err
+	}
+	a := &archive{dir: dir, files: make(map[string]string)}
+	tr := tar.NewReader(r)
+	for n := 0; ; n++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return a, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := entryName(hdr.Name)
+		local := filepath.Join(dir, strconv.Itoa(n))
+		f, err := os.Create(local)
+		if err != nil {
+			return a, err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return a, fmt.Errorf("failed to unpack %s: %w", name, err)
+		}
+		a.files[name] = local
+	}
+	if len(a.files) == 0 {
+		return a, fmt.Errorf("%w: empty archive", ErrInvalidArchive)
+	}
+	return a, nil
+}
+
+// entryName normalizes a path inside the archive, e.g. "./index.json".
+func entryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (a *archive) open(name string) (*os.File, error) {
+	local, ok := a.files[entryName(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s missing", ErrInvalidArchive, name)
+	}
+	return os.Open(local)
+}
+
+func (a *archive) read(name string) ([]byte, error) {
+	f, err := a.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	body, err := io.ReadAll(io.LimitReader(f, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxManifestSize {
+		return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrInvalidArchive, name, maxManifestSize)
+	}
+	return body, nil
+}
+
+// readBlob reads a manifest from the layout's blobs, checking its digest.
+func (a *archive) readBlob(digest string) ([]byte, error) {
+	body, err := a.read(blobPath(digest))
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(body)
+	if got := "sha256:" + hex.EncodeToString(hash[:]); got != digest {
+		return nil, fmt.Errorf("%w: %s has digest %s", ErrInvalidArchive, digest, got)
+	}
+	return body, nil
+}
+
+// blobPath is where an OCI layout keeps a blob.
+func blobPath(digest string) string {
+	alg, hex, _ := strings.Cut(digest, ":")
+	return path.Join("blobs", alg, hex)
+}
+
+// ociImages lists the images of an OCI layout's index.json.
+func (a *archive) ociImages() ([]archiveEntry, error) {
+	body, err := a.read("index.json")
+	if err != nil {
+		return nil, err
+	}
+	var index struct {
+		Manifests []struct {
+			descriptor
+			Annotations map[string]string `json:"annotations"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("%w: invalid index.json: %v", ErrInvalidArchive, err)
+	}
+
+	var images []archiveEntry
+	for _, d := range index.Manifests {
+		manifest, err := a.readBlob(d.Digest)
+		if err != nil {
+			return nil, err
+		}
+		mediaType := d.MediaType
+		if mediaType == "" {
+			var probe struct {
+				MediaType string `json:"mediaType"`
+			}
+			json.Unmarshal(manifest, &probe)
+			mediaType = probe.MediaType
+		}
+		name := d.Annotations[annotationContainerdID]
+		if name == "" {
+			name = d.Annotations[annotationRefName]
+		}
+		images = append(images, archiveEntry{tag: tagOf(name), digest: d.Digest, mediaType: mediaType, manifest: manifest})
+	}
+	return images, nil
+}
+
+// dockerImages lists the images of a legacy `docker save` archive. Images
+// tagged more than once are listed once per tag.
+func (a *archive) dockerImages() ([]archiveEntry, error) {
+	body, err := a.read("manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	var saved []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	if err := json.Unmarshal(body, &saved); err != nil {
+		return nil, fmt.Errorf("%w: invalid manifest.json: %v", ErrInvalidArchive, err)
+	}
+
+	var images []archiveEntry
+	for _, img := range saved {
+		legacy := &legacyImage{config: img.Config, layers: img.Layers}
+		if len(img.RepoTags) == 0 {
+			images = append(images, archiveEntry{legacy: legacy})
+		}
+		for _, t := range img.RepoTags {
+			images = append(images, archiveEntry{tag: tagOf(t), legacy: legacy})
+		}
+	}
+	return images, nil
+}
+
+// tagOf returns the tag of "name:tag", or a bare tag as is.
+func tagOf(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[i+1:]
+	}
+	if strings.Contains(ref, "/") {
+		return ""
+	}
+	return ref
+}
+
+// loadImage stores an image's blobs and pushes its manifest, and for an
+// index each of its images first.
+func (s *Service) loadImage(ctx context.Context, a *archive, repo string, img archiveEntry, userID uuid.UUID, res *ArchiveResult) (*ArchiveImage, error) {
+	if img.legacy != nil {
+		manifest, err := s.legacyManifest(ctx, a, repo, img.legacy, res)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(manifest)
+		img.manifest, img.mediaType = manifest, mediaTypeOCIManifest
+		img.digest = "sha256:" + hex.EncodeToString(hash[:])
+	}
+
+	switch img.mediaType {
+	case mediaTypeDockerManifest, mediaTypeOCIManifest:
+		if err := s.storeBlobs(ctx, a, repo, img.manifest, res); err != nil {
+			return nil, err
+		}
+	case mediaTypeDockerManifestList, mediaTypeOCIIndex:
+		var index struct {
+			Manifests []descriptor `json:"manifests"`
+		}
+		if err := json.Unmarshal(img.manifest, &index); err != nil {
+			return nil, fmt.Errorf("%w: invalid index %s", ErrInvalidArchive, img.digest)
+		}
+		for _, m := range index.Manifests {
+			if m.MediaType != mediaTypeDockerManifest && m.MediaType != mediaTypeOCIManifest {
+				return nil, fmt.Errorf("%w: unsupported manifest type %q in index", ErrInvalidArchive, m.MediaType)
+			}
+			child, err := a.readBlob(m.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.storeBlobs(ctx, a, repo, child, res); err != nil {
+				return nil, err
+			}
+			if _, err := s.Pusher.ImportManifest(ctx, repo, m.Digest, m.MediaType, child, userID); err != nil {
+				return nil, fmt.Errorf("push of %s failed: %w", m.Digest, err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported manifest type %q", ErrInvalidArchive, img.mediaType)
+	}
+
+	ref := img.tag
+	if ref == "" {
+		ref = img.digest
+	}
+	if _, err := s.Pusher.ImportManifest(ctx, repo, ref, img.mediaType, img.manifest, userID); err != nil {
+		return nil, fmt.Errorf("push of %s failed: %w", ref, err)
+	}
+	return &ArchiveImage{Reference: ref, Digest: img.digest, MediaType: img.mediaType}, nil
+}
+
+// storeBlobs stores the config and layers of an image manifest that repo
+// doesn't have yet. Foreign layers are not in archives.
+func (s *Service) storeBlobs(ctx context.Context, a *archive, repo string, manifest []byte, res *ArchiveResult) error {
+	var m struct {
+		Config descriptor   `json:"config"`
+		Layers []descriptor `json:"layers"`
+	}
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return fmt.Errorf("%w: invalid manifest: %v", ErrInvalidArchive, err)
+	}
+	for _, d := range append([]descriptor{m.Config}, m.Layers...) {
+		if len(d.URLs) > 0 || strings.Contains(d.MediaType, "foreign") || s.Pusher.HasBlob(ctx, repo, d.Digest) {
+			continue
+		}
+		if err := s.storeBlob(ctx, a, repo, blobPath(d.Digest), d.Digest, d.MediaType, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) storeBlob(ctx context.Context, a *archive, repo, name, digest, mediaType string, res *ArchiveResult) error {
+	f, err := a.open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := s.Pusher.ImportBlob(ctx, repo, digest, mediaType, f)
+	if err != nil {
+		return fmt.Errorf("blob %s: %w", digest, err)
+	}
+	if n > 0 {
+		res.BlobsStored++
+		res.BytesStored += n
+	}
+	return nil
+}
+
+// legacyManifest stores the config and layer files of a legacy `docker
+// save` image and returns an OCI manifest for them. Layers keep their
+// compression.
+func (s *Service) legacyManifest(ctx context.Context, a *archive, repo string, img *legacyImage, res *ArchiveResult) ([]byte, error) {
+	blob := func(name, mediaType string) (descriptor, error) {
+		f, err := a.open(name)
+		if err != nil {
+			return descriptor{}, err
+		}
+		defer f.Close()
+		if mediaType == "" {
+			mediaType = mediaTypeOCILayer
+			magic := make([]byte, 2)
+			if _, err := io.ReadFull(f, magic); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+				mediaType = mediaTypeOCILayerGzip
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return descriptor{}, err
+			}
+		}
+		hash := sha256.New()
+		size, err := io.Copy(hash, f)
+		if err != nil {
+			return descriptor{}, err
+		}
+		d := descriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(hash.Sum(nil)), Size: size}
+		if !s.Pusher.HasBlob(ctx, repo, d.Digest) {
+			if err := s.storeBlob(ctx, a, repo, name, d.Digest, mediaType, res); err != nil {
+				return descriptor{}, err
+			}
+		}
+		return d, nil
+	}
+
+	config, err := blob(img.config, mediaTypeOCIConfig)
+	if err != nil {
+		return nil, err
+	}
+	m := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Config        descriptor   `json:"config"`
+		Layers        []descriptor `json:"layers"`
+	}{SchemaVersion: 2, MediaType: mediaTypeOCIManifest, Config: config, Layers: []descriptor{}}
+	for _, l := range img.layers {
+		d, err := blob(l, "")
+		if err != nil {
+			return nil, err
+		}
+		m.Layers = append(m.Layers, d)
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,283 @@
+package importer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Manifest media types an import copies.
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+var manifestAccept = strings.Join([]string{
+	mediaTypeOCIIndex, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeDockerManifest,
+}, ", ")
+
+// maxManifestSize matches what the registry accepts on push.
+const maxManifestSize = 4 * 1024 * 1024
+
+// challengeParam matches one key="value" pair of a WWW-Authenticate header.
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// remote pulls from a registry speaking the distribution API, signing in
+// with a username and password (or token, e.g. for ECR) through Basic or
+// Bearer token authentication, whichever the registry asks for.
+type remote struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string // bearer token per repository
+	basic  bool              // the registry asked for Basic auth
+}
+
+// NormalizeSourceURL returns the base URL of a registry given as a URL or a
+// host. Docker Hub's names map to its registry endpoint.
+func NormalizeSourceURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return "", fmt.Errorf("invalid registry URL %q", raw)
+	}
+	switch u.Host {
+	case "docker.io", "index.docker.io", "hub.docker.com":
+		u.Host = "registry-1.docker.io"
+	}
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/v2")
+	u.RawQuery, u.Fragment = "", ""
+	return u.String(), nil
+}
+
+func newRemote(sourceURL, username, password string) (*remote, error) {
+	base, err := url.Parse(sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	return &remote{
+		base:     base,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: 30 * time.Minute}, // bounds a single layer download
+		tokens:   make(map[string]string),
+	}, nil
+}
+
+// get sends a GET for a repository's endpoint, signing in when challenged.
+// Non-2xx responses are returned as errors.
+func (c *remote) get(ctx context.Context, repo, endpoint, accept string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base.String()+"/v2/"+repo+"/"+endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		c.authorize(req, repo)
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if err := c.signIn(ctx, repo, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+		}
+		return resp, nil
+	}
+}
+
+func (c *remote) authorize(req *http.Request, repo string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if token := c.tokens[repo]; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.basic {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// signIn answers an authentication challenge for repo.
+func (c *remote) signIn(ctx context.Context, repo, challenge string) error {
+	scheme, _, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.username == "" {
+			return fmt.Errorf("registry requires credentials")
+		}
+		c.mu.Lock()
+		c.basic = true
+		c.mu.Unlock()
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	if params["realm"] == "" {
+		return fmt.Errorf("authentication challenge without realm")
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return fmt.Errorf("invalid token realm: %w", err)
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	q.Set("scope", "repository:"+repo+":pull")
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("invalid token response: %w", err)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return fmt.Errorf("token response without token")
+	}
+
+	c.mu.Lock()
+	c.tokens[repo] = token
+	c.mu.Unlock()
+	return nil
+}
+
+// tags lists a repository's tags, following pagination.
+func (c *remote) tags(ctx context.Context, repo string) ([]string, error) {
+	var all []string
+	endpoint := "tags/list?n=1000"
+	for endpoint != "" {
+		resp, err := c.get(ctx, repo, endpoint, "")
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		link := resp.Header.Get("Link")
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag list: %w", err)
+		}
+		all = append(all, page.Tags...)
+		endpoint = nextPage(link, repo)
+	}
+	return all, nil
+}
+
+// nextPage returns the endpoint of the next page from a Link header, e.g.
+// </v2/<repo>/tags/list?last=x&n=1000>; rel="next".
+func nextPage(link, repo string) string {
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	u, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	prefix := "/v2/" + repo + "/"
+	if i := strings.Index(u.Path, prefix); i >= 0 {
+		return u.Path[i+len(prefix):] + "?" + u.RawQuery
+	}
+	return ""
+}
+
+// manifest fetches a manifest by tag or digest and returns it with its media
+// type and digest.
+func (c *remote) manifest(ctx context.Context, repo, reference string) ([]byte, string, string, error) {
+	resp, err := c.get(ctx, repo, "manifests/"+reference, manifestAccept)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if len(body) > maxManifestSize {
+		return nil, "", "", fmt.Errorf("manifest %s exceeds %d bytes", reference, maxManifestSize)
+	}
+
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if mediaType == "" || mediaType == "application/json" || mediaType == "text/plain" {
+		var probe struct {
+			MediaType string `json:"mediaType"`
+		}
+		json.Unmarshal(body, &probe)
+		mediaType = probe.MediaType
+	}
+	hash := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+	if strings.HasPrefix(reference, "sha256:") && reference != digest {
+		return nil, "", "", fmt.Errorf("manifest digest mismatch: expected %s, got %s", reference, digest)
+	}
+	return body, strings.TrimSpace(mediaType), digest, nil
+}
+
+// blob opens a blob for reading.
+func (c *remote) blob(ctx context.Context, repo, digest string) (io.ReadCloser, error) {
+	resp, err := c.get(ctx, repo, "blobs/"+digest, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
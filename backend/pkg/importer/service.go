@@ -0,0 +1,639 @@
+// Package importer copies repositories from another registry (Harbor, ECR,
+// Docker Hub, ...) into this one. An import lists source repositories; each
+// is copied tag by tag by background workers, pulling manifests and blobs
+// from the source and pushing them here. Progress is kept in the database, so
+// an import survives restarts and resumes at the first tag not yet copied.
+// Images can also be loaded from an uploaded OCI layout or `docker save`
+// tarball, for air-gapped installs.
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+)
+
+// Import and item statuses. Imports are running until every item finished.
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// MaxRepositories bounds the repositories of one import.
+const MaxRepositories = 500
+
+// A running item's heartbeat is refreshed every heartbeatInterval; one not
+// refreshed for staleAfter belongs to an instance that died and is taken over.
+const (
+	heartbeatInterval = time.Minute
+	staleAfter        = 5 * time.Minute
+	idleWait          = 5 * time.Second
+)
+
+var (
+	ErrNotFound = errors.New("import not found")
+	ErrInvalid  = errors.New("invalid import")
+)
+
+// repoName matches repository names this registry accepts.
+var repoName = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*$`)
+
+// Pusher stores pulled content the way a push does; *registry.Handler.
+type Pusher interface {
+	HasBlob(ctx context.Context, repoName, digest string) bool
+	ImportBlob(ctx context.Context, repoName, digest, mediaType string, body io.Reader) (int64, error)
+	ImportManifest(ctx context.Context, repoName, reference, contentType string, body []byte, userID uuid.UUID) (string, error)
+}
+
+// Import is a bulk import. The source password is never serialized.
+type Import struct {
+	ID          uuid.UUID  `json:"id"`
+	SourceURL   string     `json:"sourceUrl"`
+	Username    string     `json:"username,omitempty"`
+	Status      string     `json:"status"`
+	RequestedBy *uuid.UUID `json:"requestedBy,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+
+	Repositories int `json:"repositories"`
+	Succeeded    int `json:"succeeded"`
+	Failed       int `json:"failed"`
+
+	Items []Item `json:"items,omitempty"` // only from Get
+}
+
+// Item is one repository of an import.
+type Item struct {
+	ID               uuid.UUID `json:"id"`
+	SourceRepository string    `json:"sourceRepository"`
+	TargetRepository string    `json:"targetRepository"`
+	Tags             []string  `json:"tags,omitempty"` // empty copies every tag
+	Status           string    `json:"status"`
+	TagsDone         int       `json:"tagsDone"`
+	TagsTotal        int       `json:"tagsTotal"`
+	BlobsCopied      int       `json:"blobsCopied"`
+	BytesCopied      int64     `json:"bytesCopied"`
+	Error            string    `json:"error,omitempty"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// Request describes an import to start.
+type Request struct {
+	SourceURL string `json:"sourceUrl"`
+	Username  string `json:"username"`
+	Password  string `json:"password"` // or token, e.g. from `aws ecr get-login-password`
+
+	// TargetNamespace receives repositories without their own target,
+	// keeping their name: "bitnami/redis" becomes "<namespace>/redis".
+	// Without it they keep their full name.
+	TargetNamespace string              `json:"targetNamespace"`
+	Repositories    []RepositoryRequest `json:"repositories"`
+}
+
+// RepositoryRequest is one repository to import.
+type RepositoryRequest struct {
+	Source string   `json:"source"`
+	Target string   `json:"target,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+type Service struct {
+	DB       *sql.DB
+	Metadata *metadata.Service
+	Pusher   Pusher
+	Workers  int // items copied at once by this instance
+}
+
+func NewService(db *sql.DB, meta *metadata.Service, pusher Pusher) *Service {
+	return &Service{DB: db, Metadata: meta, Pusher: pusher, Workers: 1}
+}
+
+// Create validates and records an import; the workers pick it up.
+func (s *Service) Create(ctx context.Context, req Request, requestedBy uuid.UUID) (*Import, error) {
+	sourceURL, err := NormalizeSourceURL(req.SourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if len(req.Repositories) == 0 {
+		return nil, fmt.Errorf("%w: no repositories", ErrInvalid)
+	}
+	if len(req.Repositories) > MaxRepositories {
+		return nil, fmt.Errorf("%w: at most %d repositories", ErrInvalid, MaxRepositories)
+	}
+	ns := strings.Trim(req.TargetNamespace, "/ ")
+	dockerHub := strings.Contains(sourceURL, "registry-1.docker.io")
+
+	items := make([]RepositoryRequest, 0, len(req.Repositories))
+	for _, r := range req.Repositories {
+		r.Source = strings.Trim(r.Source, "/ ")
+		r.Target = strings.Trim(r.Target, "/ ")
+		if dockerHub && !strings.Contains(r.Source, "/") {
+			r.Source = "library/" + r.Source // official images
+		}
+		if r.Target == "" {
+			r.Target = r.Source
+			if ns != "" {
+				_, name, found := strings.Cut(r.Source, "/")
+				if !found {
+					name = r.Source
+				}
+				r.Target = ns + "/" + name
+			}
+		}
+		if !repoName.MatchString(r.Source) || !repoName.MatchString(r.Target) {
+			return nil, fmt.Errorf("%w: invalid repository name in %q -> %q", ErrInvalid, r.Source, r.Target)
+		}
+		if r.Tags == nil {
+			r.Tags = []string{}
+		}
+		items = append(items, r)
+	}
+
+	var by *uuid.UUID
+	if requestedBy != uuid.Nil {
+		by = &requestedBy
+	}
+	imp := &Import{SourceURL: sourceURL, Username: req.Username, Status: StatusRunning, RequestedBy: by, Repositories: len(items)}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO registry_imports (source_url, username, password, requested_by)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		sourceURL, req.Username, req.Password, by).Scan(&imp.ID, &imp.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range items {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO registry_import_items (import_id, source_repository, target_repository, tags)
+			VALUES ($1, $2, $3, $4)`, imp.ID, r.Source, r.Target, pq.Array(r.Tags))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return imp, nil
+}
+
+// List returns imports, newest first, without their items.
+func (s *Service) List(ctx context.Context, limit int) ([]Import, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT ri.id, ri.source_url, ri.username, ri.status, ri.requested_by, ri.created_at, ri.finished_at,
+		       COUNT(i.id), COUNT(i.id) FILTER (WHERE i.status = 'succeeded'), COUNT(i.id) FILTER (WHERE i.status = 'failed')
+		FROM registry_imports ri
+		LEFT JOIN registry_import_items i ON i.import_id = ri.id
+		GROUP BY ri.id
+		ORDER BY ri.created_at DESC
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imports := []Import{}
+	for rows.Next() {
+		var imp Import
+		if err := rows.Scan(&imp.ID, &imp.SourceURL, &imp.Username, &imp.Status, &imp.RequestedBy, &imp.CreatedAt, &imp.FinishedAt,
+			&imp.Repositories, &imp.Succeeded, &imp.Failed); err != nil {
+			return nil, err
+		}
+		imports = append(imports, imp)
+	}
+	return imports, rows.Err()
+}
+
+// Get returns an import with the progress of each repository.
+func (s *Service) Get(ctx context.Context, id uuid.UUID) (*Import, error) {
+	imp := &Import{ID: id}
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT source_url, username, status, requested_by, created_at, finished_at
+		FROM registry_imports WHERE id = $1`, id).Scan(
+		&imp.SourceURL, &imp.Username, &imp.Status, &imp.RequestedBy, &imp.CreatedAt, &imp.FinishedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, source_repository, target_repository, tags, status, COALESCE(array_length(done_tags, 1), 0),
+		       tags_total, blobs_copied, bytes_copied, error, updated_at
+		FROM registry_import_items WHERE import_id = $1
+		ORDER BY source_repository`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	imp.Items = []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.SourceRepository, &it.TargetRepository, pq.Array(&it.Tags), &it.Status, &it.TagsDone,
+			&it.TagsTotal, &it.BlobsCopied, &it.BytesCopied, &it.Error, &it.UpdatedAt); err != nil {
+			return nil, err
+		}
+		imp.Items = append(imp.Items, it)
+		imp.Repositories++
+		switch it.Status {
+		case StatusSucceeded:
+			imp.Succeeded++
+		case StatusFailed:
+			imp.Failed++
+		}
+	}
+	return imp, rows.Err()
+}
+
+// Cancel stops a running import. Repositories being copied stop at their
+// next heartbeat; what was copied stays.
+func (s *Service) Cancel(ctx context.Context, id uuid.UUID) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		UPDATE registry_imports SET status = 'cancelled', password = '', finished_at = NOW()
+		WHERE id = $1 AND status = 'running'`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return s.checkExists(ctx, id)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE registry_import_items SET status = 'cancelled', updated_at = NOW()
+		WHERE import_id = $1 AND status IN ('pending', 'running')`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Retry queues the failed repositories of a finished import again. They
+// resume after their last copied tag.
+func (s *Service) Retry(ctx context.Context, id uuid.UUID) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		UPDATE registry_import_items SET status = 'pending', error = '', updated_at = NOW()
+		WHERE import_id = $1 AND status = 'failed'`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if err := s.checkExists(ctx, id); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: no failed repositories to retry", ErrInvalid)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE registry_imports SET status = 'running', finished_at = NULL WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes a finished import and its history.
+func (s *Service) Delete(ctx context.Context, id uuid.UUID) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM registry_imports WHERE id = $1 AND status <> 'running'`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if err := s.checkExists(ctx, id); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: cancel the import before deleting it", ErrInvalid)
+	}
+	return nil
+}
+
+func (s *Service) checkExists(ctx context.Context, id uuid.UUID) error {
+	var status string
+	err := s.DB.QueryRowContext(ctx, `SELECT status FROM registry_imports WHERE id = $1`, id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: import is %s", ErrInvalid, status)
+}
+
+// Run copies queued repositories until ctx is done. Repositories interrupted
+// by shutdown go back to the queue and resume on the next start.
+func (s *Service) Run(ctx context.Context) {
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				j, err := s.claim(ctx)
+				if err != nil && ctx.Err() == nil {
+					fmt.Printf("[Import] Failed to claim a repository: %v\n", err)
+				}
+				if j == nil {
+					select {
+					case <-ctx.Done():
+					case <-time.After(idleWait):
+					}
+					continue
+				}
+				s.run(ctx, j)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// job is a claimed item with what is needed to copy it.
+type job struct {
+	Item
+	importID    uuid.UUID
+	sourceURL   string
+	username    string
+	password    string
+	requestedBy uuid.UUID
+	doneTags    []string
+}
+
+// claim takes the next pending item of a running import, or a running one
+// whose instance stopped sending heartbeats.
+func (s *Service) claim(ctx context.Context) (*job, error) {
+	j := &job{}
+	var requestedBy uuid.NullUUID
+	err := s.DB.QueryRowContext(ctx, `
+		WITH next AS (
+			SELECT i.id FROM registry_import_items i
+			JOIN registry_imports ri ON ri.id = i.import_id
+			WHERE ri.status = 'running'
+			  AND (i.status = 'pending' OR (i.status = 'running' AND i.updated_at < NOW() - $1::float8 * INTERVAL '1 second'))
+			ORDER BY ri.created_at, i.source_repository
+			LIMIT 1
+			FOR UPDATE OF i SKIP LOCKED
+		)
+		UPDATE registry_import_items i SET status = 'running', updated_at = NOW()
+		FROM next, registry_imports ri
+		WHERE i.id = next.id AND ri.id = i.import_id
+		RETURNING i.id, i.import_id, i.source_repository, i.target_repository, i.tags, i.done_tags,
+		          ri.source_url, ri.username, ri.password, ri.requested_by`,
+		staleAfter.Seconds()).Scan(&j.ID, &j.importID, &j.SourceRepository, &j.TargetRepository, pq.Array(&j.Tags), pq.Array(&j.doneTags),
+		&j.sourceURL, &j.username, &j.password, &requestedBy)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	j.requestedBy = requestedBy.UUID
+	return j, nil
+}
+
+// run copies a claimed item and records how it ended.
+func (s *Service) run(ctx context.Context, j *job) {
+	fmt.Printf("[Import] Copying %s from %s into %s\n", j.SourceRepository, j.sourceURL, j.TargetRepository)
+
+	// The heartbeat also notices a cancelled import and stops the copy.
+	copyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-copyCtx.Done():
+				return
+			case <-ticker.C:
+				if !s.touch(copyCtx, j.ID) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err := s.copyRepository(copyCtx, j)
+
+	// The update must land even though ctx may be done.
+	finishCtx, finish := context.WithTimeout(context.Background(), 10*time.Second)
+	defer finish()
+	switch {
+	case err == nil:
+		fmt.Printf("[Import] Copied %s into %s\n", j.SourceRepository, j.TargetRepository)
+		s.finishItem(finishCtx, j, StatusSucceeded, "")
+	case ctx.Err() != nil:
+		fmt.Printf("[Import] Copy of %s interrupted by shutdown; it resumes on the next start\n", j.SourceRepository)
+		s.finishItem(finishCtx, j, StatusPending, "")
+	case copyCtx.Err() != nil:
+		fmt.Printf("[Import] Copy of %s cancelled\n", j.SourceRepository)
+	default:
+		fmt.Printf("[Import] Copy of %s failed: %v\n", j.SourceRepository, err)
+		s.finishItem(finishCtx, j, StatusFailed, err.Error())
+	}
+}
+
+// touch refreshes a running item's heartbeat and reports whether it is still
+// running.
+func (s *Service) touch(ctx context.Context, itemID uuid.UUID) bool {
+	res, err := s.DB.ExecContext(ctx, `
+		UPDATE registry_import_items SET updated_at = NOW() WHERE id = $1 AND status = 'running'`, itemID)
+	if err != nil {
+		return true // try again at the next beat
+	}
+	n, _ := res.RowsAffected()
+	return n > 0
+}
+
+// finishItem records the end of an item and, when it was the last one, of
+// its import. A successful import forgets the source password; a failed one
+// keeps it for Retry.
+func (s *Service) finishItem(ctx context.Context, j *job, status, reason string) {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE registry_import_items SET status = $2, error = $3, updated_at = NOW()
+		WHERE id = $1 AND status = 'running'`, j.ID, status, reason)
+	if err == nil && status != StatusPending {
+		_, err = s.DB.ExecContext(ctx, `
+			UPDATE registry_imports ri
+			SET status = CASE WHEN failed THEN 'failed' ELSE 'succeeded' END,
+			    password = CASE WHEN failed THEN password ELSE '' END,
+			    finished_at = NOW()
+			FROM (SELECT EXISTS (SELECT 1 FROM registry_import_items WHERE import_id = $1 AND status = 'failed') AS failed) f
+			WHERE ri.id = $1 AND ri.status = 'running'
+			  AND NOT EXISTS (SELECT 1 FROM registry_import_items WHERE import_id = $1 AND status IN ('pending', 'running'))`,
+			j.importID)
+	}
+	if err != nil {
+		fmt.Printf("[Import] Failed to record status of %s: %v\n", j.SourceRepository, err)
+	}
+}
+
+// copyRepository copies the item's tags, skipping those copied before.
+func (s *Service) copyRepository(ctx context.Context, j *job) error {
+	src, err := newRemote(j.sourceURL, j.username, j.password)
+	if err != nil {
+		return err
+	}
+
+	tags := j.Tags
+	if len(tags) == 0 {
+		if tags, err = src.tags(ctx, j.SourceRepository); err != nil {
+			return fmt.Errorf("failed to list tags: %w", err)
+		}
+	}
+	if _, err := s.DB.ExecContext(ctx, `UPDATE registry_import_items SET tags_total = $2 WHERE id = $1`, j.ID, len(tags)); err != nil {
+		return err
+	}
+
+	done := make(map[string]bool, len(j.doneTags))
+	for _, t := range j.doneTags {
+		done[t] = true
+	}
+	for _, tag := range tags {
+		if done[tag] {
+			continue
+		}
+		if err := s.copyTag(ctx, j, src, tag); err != nil {
+			return fmt.Errorf("tag %s: %w", tag, err)
+		}
+		_, err := s.DB.ExecContext(ctx, `
+			UPDATE registry_import_items SET done_tags = array_append(done_tags, $2), updated_at = NOW()
+			WHERE id = $1`, j.ID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// descriptor is a reference to a blob or manifest.
+type descriptor struct {
+	MediaType string   `json:"mediaType"`
+	Digest    string   `json:"digest"`
+	Size      int64    `json:"size"`
+	URLs      []string `json:"urls,omitempty"`
+}
+
+// copyTag copies what a tag points to: an image, or an index with each of
+// its images, which are pushed by digest before the index.
+func (s *Service) copyTag(ctx context.Context, j *job, src *remote, tag string) error {
+	body, mediaType, digest, err := src.manifest(ctx, j.SourceRepository, tag)
+	if err != nil {
+		return err
+	}
+	if s.hasTag(ctx, j.TargetRepository, tag, digest) {
+		return nil
+	}
+
+	switch mediaType {
+	case mediaTypeDockerManifest, mediaTypeOCIManifest:
+		if err := s.copyBlobs(ctx, j, src, body); err != nil {
+			return err
+		}
+	case mediaTypeDockerManifestList, mediaTypeOCIIndex:
+		var index struct {
+			Manifests []descriptor `json:"manifests"`
+		}
+		if err := json.Unmarshal(body, &index); err != nil {
+			return fmt.Errorf("invalid index: %w", err)
+		}
+		for _, m := range index.Manifests {
+			if s.hasTag(ctx, j.TargetRepository, m.Digest, m.Digest) {
+				continue
+			}
+			child, childType, _, err := src.manifest(ctx, j.SourceRepository, m.Digest)
+			if err != nil {
+				return err
+			}
+			if childType != mediaTypeDockerManifest && childType != mediaTypeOCIManifest {
+				return fmt.Errorf("unsupported manifest type %q in index", childType)
+			}
+			if err := s.copyBlobs(ctx, j, src, child); err != nil {
+				return err
+			}
+			if _, err := s.Pusher.ImportManifest(ctx, j.TargetRepository, m.Digest, childType, child, j.requestedBy); err != nil {
+				return fmt.Errorf("push of %s failed: %w", m.Digest, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported manifest type %q", mediaType)
+	}
+
+	if _, err := s.Pusher.ImportManifest(ctx, j.TargetRepository, tag, mediaType, body, j.requestedBy); err != nil {
+		return fmt.Errorf("push failed: %w", err)
+	}
+	return nil
+}
+
+// copyBlobs copies the config and layers of an image manifest that the
+// target repository doesn't have yet. Foreign layers stay where they are.
+func (s *Service) copyBlobs(ctx context.Context, j *job, src *remote, manifest []byte) error {
+	var m struct {
+		Config descriptor   `json:"config"`
+		Layers []descriptor `json:"layers"`
+	}
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	for _, d := range append([]descriptor{m.Config}, m.Layers...) {
+		if len(d.URLs) > 0 || strings.Contains(d.MediaType, "foreign") || s.Pusher.HasBlob(ctx, j.TargetRepository, d.Digest) {
+			continue
+		}
+		body, err := src.blob(ctx, j.SourceRepository, d.Digest)
+		if err != nil {
+			return err
+		}
+		n, err := s.Pusher.ImportBlob(ctx, j.TargetRepository, d.Digest, d.MediaType, body)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("blob %s: %w", d.Digest, err)
+		}
+		_, err = s.DB.ExecContext(ctx, `
+			UPDATE registry_import_items
+			SET blobs_copied = blobs_copied + 1, bytes_copied = bytes_copied + $2, updated_at = NOW()
+			WHERE id = $1`, j.ID, n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasTag reports whether reference already points to digest in repo.
+func (s *Service) hasTag(ctx context.Context, repo, reference, digest string) bool {
+	id, err := s.Metadata.GetManifestID(ctx, repo, reference)
+	if err != nil {
+		return false
+	}
+	current, err := s.Metadata.GetDigest(ctx, id)
+	return err == nil && current == digest
+}
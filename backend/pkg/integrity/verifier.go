@@ -0,0 +1,437 @@
+// Package integrity re-reads stored blobs and checks them against their
+// digests, so bit rot is found before a pull trips over it.
+package integrity
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/registryx/registryx/backend/pkg/events"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// Blob statuses.
+const (
+	StatusOK      = "ok"
+	StatusCorrupt = "corrupt" // content doesn't hash to the digest, or has the wrong size
+	StatusMissing = "missing" // indexed in the database, absent from storage
+)
+
+// batchSize bounds the blobs loaded per query during a full sweep.
+const batchSize = 500
+
+const claimKeyPrefix = "integrity-check:"
+
+// ErrRunning is returned when a check is already running on this instance.
+var ErrRunning = errors.New("an integrity check is already running")
+
+// Corruption is a blob that failed its last check.
+type Corruption struct {
+	Digest       string    `json:"digest"`
+	Status       string    `json:"status"`
+	Size         int64     `json:"size"`
+	ActualDigest string    `json:"actualDigest,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+	DetectedAt   time.Time `json:"detectedAt"`
+	CheckedAt    time.Time `json:"checkedAt"`
+	Repositories []string  `json:"repositories"` // repositories with images using the blob
+}
+
+// Run summarizes a check.
+type Run struct {
+	Full       bool       `json:"full"`
+	Running    bool       `json:"running"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Checked    int        `json:"checked"`
+	Bytes      int64      `json:"bytes"`
+	Corrupt    int        `json:"corrupt"`
+	Missing    int        `json:"missing"`
+	Errors     []string   `json:"errors,omitempty"` // blobs that couldn't be read; checked again next run
+}
+
+// Verifier checks stored blobs against their digests.
+type Verifier struct {
+	DB      *sql.DB
+	Storage storage.Driver
+	Events  *events.Broker
+
+	// SampleSize is the number of blobs a scheduled run checks, least
+	// recently verified first, so successive runs cover every blob. 0
+	// checks them all every run.
+	SampleSize int
+
+	// Alert is called for each blob newly found corrupt or missing. Nil
+	// only records it.
+	Alert func(ctx context.Context, c Corruption)
+
+	rdb     *redis.Client
+	running sync.Mutex // one check at a time
+	mu      sync.Mutex
+	last    *Run
+}
+
+func NewVerifier(db *sql.DB, store storage.Driver, bus *events.Broker, rdb *redis.Client) *Verifier {
+	return &Verifier{DB: db, Storage: store, Events: bus, rdb: rdb}
+}
+
+// StartScheduler checks a sample every interval until ctx is done. With
+// several instances, one of them checks per interval.
+func (v *Verifier) StartScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !v.claim(ctx, now, interval) {
+				continue
+			}
+			if _, err := v.Run(ctx, v.SampleSize == 0); err != nil && !errors.Is(err, ErrRunning) {
+				fmt.Printf("[Integrity] Check failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// claim reports whether this instance runs the check of the interval now
+// falls in.
+func (v *Verifier) claim(ctx context.Context, now time.Time, interval time.Duration) bool {
+	if v.rdb == nil {
+		return true
+	}
+	window := now.Unix() / int64(interval.Seconds())
+	ok, err := v.rdb.SetNX(ctx, fmt.Sprintf("%s%d", claimKeyPrefix, window), now.Unix(), interval).Result()
+	if err != nil {
+		fmt.Printf("[Integrity] Failed to claim check in Redis, running anyway: %v\n", err)
+		return true
+	}
+	return ok
+}
+
+// Start runs a check in the background; full checks every blob, otherwise
+// a sample of SampleSize.
+func (v *Verifier) Start(full bool) error {
+	if !v.running.TryLock() {
+		return ErrRunning
+	}
+	go func() {
+		defer v.running.Unlock()
+		if _, err := v.run(context.Background(), full); err != nil {
+			fmt.Printf("[Integrity] Check failed: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// Run checks every blob (full) or a sample of SampleSize, and returns the
+// summary.
+func (v *Verifier) Run(ctx context.Context, full bool) (*Run, error) {
+	if !v.running.TryLock() {
+		return nil, ErrRunning
+	}
+	defer v.running.Unlock()
+	return v.run(ctx, full)
+}
+
+type blob struct {
+	digest string
+	size   int64
+}
+
+func (v *Verifier) run(ctx context.Context, full bool) (*Run, error) {
+	res := &Run{Full: full || v.SampleSize <= 0, Running: true, StartedAt: time.Now()}
+	v.setLast(res)
+
+	var err error
+	if res.Full {
+		after := ""
+		for {
+			var batch []blob
+			batch, err = v.blobs(ctx, `
+				SELECT digest, size FROM blobs WHERE digest > $1 ORDER BY digest LIMIT $2`, after, batchSize)
+			if err != nil || len(batch) == 0 {
+				break
+			}
+			v.checkAll(ctx, batch, res)
+			after = batch[len(batch)-1].digest
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				break
+			}
+		}
+	} else {
+		var batch []blob
+		batch, err = v.blobs(ctx, `
+			SELECT b.digest, b.size FROM blobs b
+			LEFT JOIN blob_integrity i ON i.digest = b.digest
+			ORDER BY i.checked_at NULLS FIRST, b.digest
+			LIMIT $1`, v.SampleSize)
+		if err == nil {
+			v.checkAll(ctx, batch, res)
+		}
+	}
+
+	now := time.Now()
+	res.Running, res.FinishedAt = false, &now
+	v.setLast(res)
+	fmt.Printf("[Integrity] Checked %d blobs (%d bytes): %d corrupt, %d missing, %d unreadable\n",
+		res.Checked, res.Bytes, res.Corrupt, res.Missing, len(res.Errors))
+	return res, err
+}
+
+func (v *Verifier) blobs(ctx context.Context, query string, args ...interface{}) ([]blob, error) {
+	rows, err := v.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []blob
+	for rows.Next() {
+		var b blob
+		if err := rows.Scan(&b.digest, &b.size); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+func (v *Verifier) checkAll(ctx context.Context, batch []blob, res *Run) {
+	for _, b := range batch {
+		if ctx.Err() != nil {
+			return
+		}
+		status, actual, detail, err := v.verify(ctx, b)
+		if err != nil {
+			if len(res.Errors) < 100 {
+				res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", b.digest, err))
+			}
+			continue
+		}
+		res.Checked++
+		switch status {
+		case StatusOK:
+			res.Bytes += b.size
+		case StatusCorrupt:
+			res.Corrupt++
+		case StatusMissing:
+			res.Missing++
+		}
+		if err := v.record(ctx, b, status, actual, detail); err != nil {
+			fmt.Printf("[Integrity] Failed to record %s: %v\n", b.digest, err)
+		}
+		if res.Checked%100 == 0 {
+			v.setLast(res)
+		}
+	}
+}
+
+// verify reads a blob and hashes it. Errors are failures to check it at all,
+// such as the store being unreachable.
+func (v *Verifier) verify(ctx context.Context, b blob) (status, actual, detail string, err error) {
+	alg, want, ok := strings.Cut(b.digest, ":")
+	var h hash.Hash
+	switch {
+	case ok && alg == "sha256":
+		h = sha256.New()
+	case ok && alg == "sha512":
+		h = sha512.New()
+	default:
+		return "", "", "", fmt.Errorf("unsupported digest algorithm %q", alg)
+	}
+
+	reader, err := v.Storage.Reader(ctx, "blobs/"+b.digest)
+	if storage.IsNotExist(err) {
+		return StatusMissing, "", "not found in storage", nil
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	defer reader.Close()
+
+	n, err := io.Copy(h, reader)
+	if errors.Is(err, storage.ErrCorrupt) {
+		return StatusCorrupt, "", err.Error(), nil
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	switch {
+	case got != want:
+		return StatusCorrupt, alg + ":" + got, fmt.Sprintf("content hashes to %s:%s", alg, got), nil
+	case n != b.size:
+		return StatusCorrupt, "", fmt.Sprintf("stored size %d, expected %d", n, b.size), nil
+	}
+	return StatusOK, "", "", nil
+}
+
+// record stores a check's result and alerts if the blob just went bad.
+func (v *Verifier) record(ctx context.Context, b blob, status, actual, detail string) error {
+	var previous sql.NullString
+	err := v.DB.QueryRowContext(ctx, `SELECT status FROM blob_integrity WHERE digest = $1`, b.digest).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	_, err = v.DB.ExecContext(ctx, `
+		INSERT INTO blob_integrity (digest, status, actual_digest, detail, checked_at, detected_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NOW(), CASE WHEN $2 = 'ok' THEN NULL ELSE NOW() END)
+		ON CONFLICT (digest) DO UPDATE SET
+			status = EXCLUDED.status, actual_digest = EXCLUDED.actual_digest, detail = EXCLUDED.detail, checked_at = NOW(),
+			detected_at = CASE WHEN EXCLUDED.status = 'ok' THEN NULL ELSE COALESCE(blob_integrity.detected_at, NOW()) END`,
+		b.digest, status, actual, detail)
+	if err != nil {
+		return err
+	}
+
+	if status == StatusOK {
+		if previous.Valid && previous.String != StatusOK {
+			fmt.Printf("[Integrity] %s reads correctly again\n", b.digest)
+		}
+		return nil
+	}
+	if previous.Valid && previous.String != StatusOK {
+		return nil // already reported
+	}
+
+	c := Corruption{Digest: b.digest, Status: status, Size: b.size, ActualDigest: actual, Detail: detail, DetectedAt: time.Now(), CheckedAt: time.Now()}
+	if c.Repositories, err = v.repositories(ctx, b.digest); err != nil {
+		fmt.Printf("[Integrity] Failed to list repositories using %s: %v\n", b.digest, err)
+	}
+	fmt.Printf("[Integrity] %s is %s: %s (used by %v)\n", b.digest, status, detail, c.Repositories)
+	v.Events.Publish(events.Event{Type: events.TypeBlobCorrupt, Digest: b.digest, Data: map[string]interface{}{"status": status, "detail": detail, "repositories": c.Repositories}})
+	if v.Alert != nil {
+		v.Alert(ctx, c)
+	}
+	return nil
+}
+
+// repositoriesQuery lists the repositories with manifests using a blob as
+// a layer or config.
+const repositoriesQuery = `
+	SELECT DISTINCT n.name || '/' || r.name
+	FROM manifests m
+	JOIN repositories r ON r.id = m.repository_id
+	JOIN namespaces n ON n.id = r.namespace_id
+	WHERE m.config_digest = $1
+	   OR EXISTS (SELECT 1 FROM manifest_layers ml WHERE ml.manifest_id = m.id AND ml.blob_digest = $1)
+	ORDER BY 1`
+
+func (v *Verifier) repositories(ctx context.Context, digest string) ([]string, error) {
+	rows, err := v.DB.QueryContext(ctx, repositoriesQuery, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	repos := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		repos = append(repos, name)
+	}
+	return repos, rows.Err()
+}
+
+// Corrupted lists the blobs that failed their last check, most recently
+// detected first.
+func (v *Verifier) Corrupted(ctx context.Context) ([]Corruption, error) {
+	rows, err := v.DB.QueryContext(ctx, `
+		SELECT i.digest, i.status, b.size, COALESCE(i.actual_digest, ''), COALESCE(i.detail, ''), i.detected_at, i.checked_at
+		FROM blob_integrity i
+		JOIN blobs b ON b.digest = i.digest
+		WHERE i.status <> 'ok'
+		ORDER BY i.detected_at DESC, i.digest`)
+	if err != nil {
+		return nil, err
+	}
+	out := []Corruption{}
+	for rows.Next() {
+		var c Corruption
+		if err := rows.Scan(&c.Digest, &c.Status, &c.Size, &c.ActualDigest, &c.Detail, &c.DetectedAt, &c.CheckedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range out {
+		if out[i].Repositories, err = v.repositories(ctx, out[i].Digest); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Summary is the state of integrity checking.
+type Summary struct {
+	SampleSize   int            `json:"sampleSize"`
+	Blobs        int            `json:"blobs"`
+	NeverChecked int            `json:"neverChecked"`
+	OldestCheck  *time.Time     `json:"oldestCheck,omitempty"` // every checked blob was verified since
+	Counts       map[string]int `json:"counts"`
+	LastRun      *Run           `json:"lastRun,omitempty"` // on this instance
+}
+
+// Summarize counts blobs by their last check.
+func (v *Verifier) Summarize(ctx context.Context) (*Summary, error) {
+	s := &Summary{SampleSize: v.SampleSize, Counts: map[string]int{}}
+	var oldest sql.NullTime
+	err := v.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE i.digest IS NULL), MIN(i.checked_at)
+		FROM blobs b LEFT JOIN blob_integrity i ON i.digest = b.digest`).Scan(&s.Blobs, &s.NeverChecked, &oldest)
+	if err != nil {
+		return nil, err
+	}
+	if oldest.Valid {
+		s.OldestCheck = &oldest.Time
+	}
+
+	rows, err := v.DB.QueryContext(ctx, `SELECT status, COUNT(*) FROM blob_integrity GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		s.Counts[status] = n
+	}
+
+	v.mu.Lock()
+	if v.last != nil {
+		last := *v.last
+		s.LastRun = &last
+	}
+	v.mu.Unlock()
+	return s, rows.Err()
+}
+
+func (v *Verifier) setLast(res *Run) {
+	snapshot := *res
+	snapshot.Errors = append([]string(nil), res.Errors...)
+	v.mu.Lock()
+	v.last = &snapshot
+	v.mu.Unlock()
+}
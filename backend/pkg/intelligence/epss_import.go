@@ -0,0 +1,176 @@
+package intelligence
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// epssImportBatch is the number of scores written per INSERT.
+const epssImportBatch = 5000
+
+// EPSSImport summarizes an imported EPSS bulk file.
+type EPSSImport struct {
+	ScoreDate    *time.Time `json:"scoreDate,omitempty"`
+	ModelVersion string     `json:"modelVersion,omitempty"`
+	Count        int        `json:"count"`
+	Source       string     `json:"source"`
+}
+
+// ImportEPSSFile imports the EPSS bulk file at path (see ImportEPSSCSV).
+func (s *Service) ImportEPSSFile(ctx context.Context, path string) (*EPSSImport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	res, err := s.ImportEPSSCSV(ctx, f)
+	if res != nil {
+		res.Source = "file:" + path
+	}
+	return res, err
+}
+
+// ImportEPSSCSV loads the daily EPSS bulk file published at
+// https://epss.cyentia.com/epss_scores-current.csv.gz into
+// vulnerability_intelligence, for installs that can't reach api.first.org.
+// The file may be gzipped or plain:
+//
+//	#model_version:v2023.03.01,score_date:2024-05-01T00:00:00+0000
+//	cve,epss,percentile
+//	CVE-1999-0001,0.01141,0.82958
+//
+// Every CVE in the file is stored, so images scanned later are scored too.
+func (s *Service) ImportEPSSCSV(ctx context.Context, r io.Reader) (*EPSSImport, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip data: %w", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	res := &EPSSImport{Source: "upload"}
+	// The comment line carries the model version and score date.
+	if first, _ := br.Peek(1); len(first) == 1 && first[0] == '#' {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		for _, field := range strings.Split(strings.TrimSpace(strings.TrimPrefix(line, "#")), ",") {
+			key, value, _ := strings.Cut(field, ":")
+			switch key {
+			case "model_version":
+				res.ModelVersion = value
+			case "score_date":
+				if t, err := time.Parse("2006-01-02T15:04:05-0700", value); err == nil {
+					res.ScoreDate = &t
+				}
+			}
+		}
+	}
+
+	cr := csv.NewReader(br)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing CSV header: %w", err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	cveCol, ok1 := col["cve"]
+	epssCol, ok2 := col["epss"]
+	pctCol, ok3 := col["percentile"]
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("CSV must have cve, epss and percentile columns")
+	}
+
+	updated := time.Now()
+	if res.ScoreDate != nil {
+		updated = *res.ScoreDate
+	}
+
+	// All or nothing: a truncated file must not leave half the scores updated.
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var cves []string
+	var scores, percentiles []float64
+	flush := func() error {
+		if len(cves) == 0 {
+			return nil
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO vulnerability_intelligence (cve_id, epss_score, epss_percentile, last_updated)
+			SELECT cve, score, pct, $4 FROM unnest($1::text[], $2::float8[], $3::float8[]) AS t(cve, score, pct)
+			ON CONFLICT (cve_id) DO UPDATE SET
+				epss_score = EXCLUDED.epss_score,
+				epss_percentile = EXCLUDED.epss_percentile,
+				last_updated = EXCLUDED.last_updated`,
+			pq.Array(cves), pq.Array(scores), pq.Array(percentiles), updated)
+		if err != nil {
+			return err
+		}
+		res.Count += len(cves)
+		cves, scores, percentiles = cves[:0], scores[:0], percentiles[:0]
+		return nil
+	}
+
+	line := 1
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(rec) <= cveCol || len(rec) <= epssCol || len(rec) <= pctCol {
+			return nil, fmt.Errorf("line %d: too few columns", line)
+		}
+		cve := strings.TrimSpace(rec[cveCol])
+		score, err1 := strconv.ParseFloat(strings.TrimSpace(rec[epssCol]), 64)
+		pct, err2 := strconv.ParseFloat(strings.TrimSpace(rec[pctCol]), 64)
+		if !strings.HasPrefix(cve, "CVE-") || err1 != nil || err2 != nil || score < 0 || score > 1 || pct < 0 || pct > 1 {
+			return nil, fmt.Errorf("line %d: invalid record %q", line, strings.Join(rec, ","))
+		}
+		cves = append(cves, cve)
+		scores = append(scores, score)
+		percentiles = append(percentiles, pct)
+		if len(cves) >= epssImportBatch {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if res.Count == 0 {
+		return nil, errors.New("no EPSS scores in file")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("[Intelligence] Imported %d EPSS scores (model %s)\n", res.Count, res.ModelVersion)
+	return res, nil
+}
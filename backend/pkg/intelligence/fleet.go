@@ -0,0 +1,136 @@
+package intelligence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/authz"
+)
+
+// Bounds of a fleet query.
+const (
+	MaxFleetManifests    = 1000
+	DefaultFleetCVELimit = 200
+	MaxFleetCVELimit     = 2000
+)
+
+// FleetScope selects the manifests of a fleet query: the listed manifests,
+// the tagged manifests of the listed repositories, or both.
+type FleetScope struct {
+	ManifestIDs  []uuid.UUID `json:"manifestIds"`
+	Repositories []string    `json:"repositories"`
+}
+
+// FleetVulnPriority is one CVE across a set of manifests. Scores are the
+// highest any manifest has; exposure is true if any manifest is exposed.
+type FleetVulnPriority struct {
+	CVEID             string   `json:"cveId"`
+	BaseSeverity      string   `json:"baseSeverity"`
+	EPSSScore         float64  `json:"epssScore"`
+	PriorityScore     int      `json:"priorityScore"`
+	RecommendedAction string   `json:"recommendedAction"`
+	RuntimeExposed    bool     `json:"runtimeExposed"`
+	InternetExposed   bool     `json:"internetExposed"`
+	ManifestCount     int      `json:"manifestCount"`
+	ManifestIDs       []string `json:"manifestIds"`
+	Repositories      []string `json:"repositories"`
+}
+
+// FleetPriorities is the result of a fleet query.
+type FleetPriorities struct {
+	Manifests       int                 `json:"manifests"` // manifests in scope the caller can read
+	TotalCVEs       int                 `json:"totalCves"`
+	Vulnerabilities []FleetVulnPriority `json:"vulnerabilities"`
+}
+
+// GetFleetPriorities aggregates the prioritized vulnerabilities of many
+// manifests, one entry per CVE, sorted by priority score and then by how many
+// manifests have it. Non-admins only get manifests in repositories they can
+// read; others in scope are skipped.
+func (s *Service) GetFleetPriorities(ctx context.Context, scope FleetScope, userID uuid.UUID, role string, limit int) (*FleetPriorities, error) {
+	if len(scope.ManifestIDs) == 0 && len(scope.Repositories) == 0 {
+		return nil, errors.New("manifestIds or repositories required")
+	}
+	if len(scope.ManifestIDs) > MaxFleetManifests {
+		return nil, fmt.Errorf("at most %d manifestIds", MaxFleetManifests)
+	}
+	if limit <= 0 {
+		limit = DefaultFleetCVELimit
+	}
+	if limit > MaxFleetCVELimit {
+		limit = MaxFleetCVELimit
+	}
+
+	ids := make([]string, len(scope.ManifestIDs))
+	for i, id := range scope.ManifestIDs {
+		ids[i] = id.String()
+	}
+	repos := make([]string, len(scope.Repositories))
+	for i, name := range scope.Repositories {
+		ns, repo := authz.SplitRepository(name)
+		repos[i] = ns + "/" + repo
+	}
+
+	whereClause := "1=1"
+	args := []interface{}{pq.Array(ids), pq.Array(repos)}
+	if role != "admin" {
+		whereClause = authz.RepositoryFilter("$3", authz.RoleRead)
+		args = append(args, userID)
+	}
+	scoped := fmt.Sprintf(`
+		SELECT m.id, n.name || '/' || r.name AS repo
+		FROM manifests m
+		JOIN repositories r ON m.repository_id = r.id
+		JOIN namespaces n ON r.namespace_id = n.id
+		WHERE (m.id = ANY($1::uuid[])
+		       OR (n.name || '/' || r.name = ANY($2) AND EXISTS (SELECT 1 FROM tags t WHERE t.manifest_id = m.id)))
+		  AND %s`, whereClause)
+
+	res := &FleetPriorities{Vulnerabilities: []FleetVulnPriority{}}
+	if err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM (`+scoped+`) s`, args...).Scan(&res.Manifests); err != nil {
+		return nil, err
+	}
+	if res.Manifests == 0 {
+		return res, nil
+	}
+
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		WITH scoped AS (%s),
+		agg AS (
+			SELECT p.cve_id,
+			       (array_agg(p.base_severity ORDER BY p.priority_score DESC NULLS LAST))[1] AS severity,
+			       MAX(COALESCE(p.epss_score, 0)) AS epss,
+			       MAX(COALESCE(p.priority_score, 0)) AS priority,
+			       (array_agg(p.recommended_action ORDER BY p.priority_score DESC NULLS LAST))[1] AS action,
+			       bool_or(COALESCE(p.runtime_exposed, false)) AS runtime,
+			       bool_or(COALESCE(p.internet_exposed, false)) AS internet,
+			       array_agg(DISTINCT p.manifest_id::text) AS manifests,
+			       array_agg(DISTINCT s.repo) AS repos
+			FROM manifest_vuln_priority p
+			JOIN scoped s ON s.id = p.manifest_id
+			GROUP BY p.cve_id
+		)
+		SELECT COUNT(*) OVER (), cve_id, COALESCE(severity, ''), epss, priority, COALESCE(action, ''),
+		       runtime, internet, manifests, repos
+		FROM agg
+		ORDER BY priority DESC, cardinality(manifests) DESC, epss DESC, cve_id
+		LIMIT $%d`, scoped, len(args)+1), append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v FleetVulnPriority
+		if err := rows.Scan(&res.TotalCVEs, &v.CVEID, &v.BaseSeverity, &v.EPSSScore, &v.PriorityScore,
+			&v.RecommendedAction, &v.RuntimeExposed, &v.InternetExposed, pq.Array(&v.ManifestIDs), pq.Array(&v.Repositories)); err != nil {
+			return nil, err
+		}
+		v.ManifestCount = len(v.ManifestIDs)
+		res.Vulnerabilities = append(res.Vulnerabilities, v)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,222 @@
+package intelligence
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// DefaultRuntimeTTL is how long a runtime report counts as current.
+const DefaultRuntimeTTL = time.Hour
+
+// Exposure describes where a manifest is deployed, as reported by cluster agents.
+type Exposure struct {
+	Running         bool // deployed in at least one reporting cluster
+	InternetExposed bool // reachable from outside the cluster (LoadBalancer, Ingress, ...)
+}
+
+// RuntimeImage is a container image in a reported workload.
+type RuntimeImage struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest"` // sha256:..., or a Kubernetes imageID containing one
+}
+
+// RuntimeWorkload is a workload reported by a cluster agent.
+type RuntimeWorkload struct {
+	Namespace       string         `json:"namespace"`
+	Kind            string         `json:"kind"`
+	Name            string         `json:"name"`
+	Images          []RuntimeImage `json:"images"`
+	InternetExposed bool           `json:"internetExposed"`
+}
+
+// RuntimeReport is a full snapshot of what runs in one cluster.
+type RuntimeReport struct {
+	Cluster   string            `json:"cluster"`
+	Workloads []RuntimeWorkload `json:"workloads"`
+}
+
+// RuntimeWorkloadRecord is a stored workload/image pair.
+type RuntimeWorkloadRecord struct {
+	Cluster         string    `json:"cluster"`
+	Namespace       string    `json:"namespace"`
+	Kind            string    `json:"kind"`
+	Name            string    `json:"name"`
+	Image           string    `json:"image"`
+	Digest          string    `json:"digest"`
+	InternetExposed bool      `json:"internetExposed"`
+	ReportedAt      time.Time `json:"reportedAt"`
+}
+
+// normalizeDigest extracts "sha256:<hex>" from a digest or Kubernetes imageID
+// such as "docker-pullable://registry/app@sha256:...".
+func normalizeDigest(d string) string {
+	if i := strings.Index(d, "sha256:"); i >= 0 {
+		return d[i:]
+	}
+	return ""
+}
+
+// IngestRuntimeReport replaces a cluster's snapshot and returns the digests
+// whose exposure changed, so their priorities can be recalculated.
+func (s *Service) IngestRuntimeReport(ctx context.Context, report RuntimeReport) ([]string, error) {
+	if report.Cluster == "" {
+		return nil, fmt.Errorf("cluster is required")
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Exposure per digest for this cluster before and after the report.
+	before := make(map[string]bool)
+	rows, err := tx.QueryContext(ctx, `
+		SELECT image_digest, bool_or(internet_exposed)
+		FROM runtime_workloads WHERE cluster = $1
+		GROUP BY image_digest`, report.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var digest string
+		var exposed bool
+		if err := rows.Scan(&digest, &exposed); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		before[digest] = exposed
+	}
+	rows.Close()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM runtime_workloads WHERE cluster = $1", report.Cluster); err != nil {
+		return nil, err
+	}
+
+	after := make(map[string]bool)
+	for _, wl := range report.Workloads {
+		kind := wl.Kind
+		if kind == "" {
+			kind = "Pod"
+		}
+		for _, img := range wl.Images {
+			digest := normalizeDigest(img.Digest)
+			if digest == "" {
+				continue // image not pinned to a digest yet (e.g. pod still pulling)
+			}
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO runtime_workloads (cluster, namespace, kind, name, image_ref, image_digest, internet_exposed)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (cluster, namespace, kind, name, image_digest)
+				DO UPDATE SET internet_exposed = runtime_workloads.internet_exposed OR EXCLUDED.internet_exposed`,
+				report.Cluster, wl.Namespace, kind, wl.Name, img.Image, digest, wl.InternetExposed)
+			if err != nil {
+				return nil, err
+			}
+			after[digest] = after[digest] || wl.InternetExposed
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for digest, exposed := range after {
+		if prev, ok := before[digest]; !ok || prev != exposed {
+			changed = append(changed, digest)
+		}
+	}
+	for digest := range before {
+		if _, ok := after[digest]; !ok {
+			changed = append(changed, digest)
+		}
+	}
+	return changed, nil
+}
+
+// GetManifestExposure reports whether a manifest is running in any cluster
+// that reported within the runtime TTL.
+func (s *Service) GetManifestExposure(ctx context.Context, manifestID uuid.UUID) (Exposure, error) {
+	var e Exposure
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) > 0, COALESCE(bool_or(rw.internet_exposed), false)
+		FROM runtime_workloads rw
+		JOIN manifests m ON m.digest = rw.image_digest
+		WHERE m.id = $1 AND rw.reported_at > $2`,
+		manifestID, time.Now().Add(-s.runtimeTTL())).Scan(&e.Running, &e.InternetExposed)
+	return e, err
+}
+
+// RecalculateForDigests refreshes vulnerability priorities of every scanned
+// manifest with one of the given digests.
+func (s *Service) RecalculateForDigests(ctx context.Context, digests []string) {
+	if len(digests) == 0 {
+		return
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT DISTINCT m.id FROM manifests m
+		JOIN vulnerability_reports vr ON vr.manifest_id = m.id AND vr.status = 'completed'
+		WHERE m.digest = ANY($1)`, pq.Array(digests))
+	if err != nil {
+		fmt.Printf("[Intelligence] Failed to find manifests for runtime update: %v\n", err)
+		return
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := s.CalculateManifestPriorities(ctx, id); err != nil {
+			fmt.Printf("[Intelligence] Failed to recalculate priorities for %s: %v\n", id, err)
+		}
+	}
+	fmt.Printf("[Intelligence] Runtime exposure changed for %d digests, recalculated %d manifests\n", len(digests), len(ids))
+}
+
+// ListRuntimeWorkloads returns current workloads, optionally only those running digest.
+func (s *Service) ListRuntimeWorkloads(ctx context.Context, digest string) ([]RuntimeWorkloadRecord, error) {
+	query := `
+		SELECT cluster, namespace, kind, name, COALESCE(image_ref, ''), image_digest, internet_exposed, reported_at
+		FROM runtime_workloads
+		WHERE reported_at > $1`
+	args := []interface{}{time.Now().Add(-s.runtimeTTL())}
+	if digest != "" {
+		query += " AND image_digest = $2"
+		args = append(args, digest)
+	}
+	query += " ORDER BY cluster, namespace, name"
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []RuntimeWorkloadRecord{}
+	for rows.Next() {
+		var rec RuntimeWorkloadRecord
+		if err := rows.Scan(&rec.Cluster, &rec.Namespace, &rec.Kind, &rec.Name, &rec.Image, &rec.Digest, &rec.InternetExposed, &rec.ReportedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func (s *Service) runtimeTTL() time.Duration {
+	if s.RuntimeTTL > 0 {
+		return s.RuntimeTTL
+	}
+	return DefaultRuntimeTTL
+}
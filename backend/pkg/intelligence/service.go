@@ -9,12 +9,19 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/registryx/registryx/backend/pkg/epss"
+	"github.com/registryx/registryx/backend/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Service handles vulnerability intelligence operations
 type Service struct {
 	DB         *sql.DB
 	EPSSClient *epss.Client
+	RuntimeTTL time.Duration // how long a cluster runtime report stays current
+
+	// EPSSImportPath, when set, is a mounted EPSS bulk file (epss_scores-current.csv.gz)
+	// read by RefreshEPSSData instead of calling api.first.org.
+	EPSSImportPath string
 }
 
 // VulnIntelligence represents enriched vulnerability data
@@ -37,6 +44,7 @@ type VulnPriority struct {
 	BaseSeverity       string
 	EPSSScore          float64
 	RuntimeExposed     bool
+	InternetExposed    bool
 	PriorityScore      int
 	RecommendedAction  string
 	Created            time.Time
@@ -52,6 +60,11 @@ func NewService(db *sql.DB) *Service {
 
 // RefreshEPSSData fetches and stores EPSS scores for all known CVEs
 func (s *Service) RefreshEPSSData(ctx context.Context) error {
+	if s.EPSSImportPath != "" {
+		_, err := s.ImportEPSSFile(ctx, s.EPSSImportPath)
+		return err
+	}
+
 	// Get all unique CVE IDs from vulnerability_reports
 	rows, err := s.DB.QueryContext(ctx, `
 		SELECT DISTINCT v->>'VulnerabilityID' as cve_id
@@ -141,7 +154,7 @@ func (s *Service) GetVulnIntelligence(ctx context.Context, cveID string) (*VulnI
 }
 
 // CalculatePriorityScore calculates a priority score for a vulnerability
-func (s *Service) CalculatePriorityScore(baseSeverity string, epssScore float64, runtimeExposed bool) int {
+func (s *Service) CalculatePriorityScore(baseSeverity string, epssScore float64, exposure Exposure) int {
 	score := 0
 
 	// Base severity (30%)
@@ -167,9 +180,11 @@ func (s *Service) CalculatePriorityScore(baseSeverity string, epssScore float64,
 		score += 10
 	}
 
-	// Runtime exposure (10%)
-	if runtimeExposed {
+	// Runtime exposure (10%) - full weight when internet-facing, half when only running
+	if exposure.InternetExposed {
 		score += 10
+	} else if exposure.Running {
+		score += 5
 	}
 
 	// Cap at 100
@@ -197,10 +212,12 @@ func (s *Service) GetRecommendedAction(priorityScore int) string {
 }
 
 // CalculateManifestPriorities calculates and stores priority scores for all vulnerabilities in a manifest
-func (s *Service) CalculateManifestPriorities(ctx context.Context, manifestID uuid.UUID) error {
+func (s *Service) CalculateManifestPriorities(ctx context.Context, manifestID uuid.UUID) (err error) {
+	ctx, span := tracing.Start(ctx, "intelligence.CalculateManifestPriorities", attribute.String("registry.manifest_id", manifestID.String()))
+	defer func() { tracing.End(span, err) }()
 	// 1. Get the latest completed report
 	var reportJSON []byte
-	err := s.DB.QueryRowContext(ctx, `
+	err = s.DB.QueryRowContext(ctx, `
 		SELECT report_json FROM vulnerability_reports 
 		WHERE manifest_id = $1 AND status = 'completed'
 		ORDER BY scanned_at DESC LIMIT 1
@@ -227,6 +244,11 @@ func (s *Service) CalculateManifestPriorities(ctx context.Context, manifestID uu
 	// 3. Clear existing priorities for this manifest
 	_, _ = s.DB.ExecContext(ctx, "DELETE FROM manifest_vuln_priority WHERE manifest_id = $1", manifestID)
 
+	exposure, err := s.GetManifestExposure(ctx, manifestID)
+	if err != nil {
+		fmt.Printf("[Intelligence] Failed to load runtime exposure for %s: %v\n", manifestID, err)
+	}
+
 	highPriorityCount := 0
 
 	// 4. Process each vuln
@@ -236,8 +258,7 @@ func (s *Service) CalculateManifestPriorities(ctx context.Context, manifestID uu
 			var epssScore float64
 			_ = s.DB.QueryRowContext(ctx, "SELECT COALESCE(epss_score, 0) FROM vulnerability_intelligence WHERE cve_id = $1", v.VulnerabilityID).Scan(&epssScore)
 
-			runtimeExposed := false // Future: Hook into K8s runtime data
-			priorityScore := s.CalculatePriorityScore(v.Severity, epssScore, runtimeExposed)
+			priorityScore := s.CalculatePriorityScore(v.Severity, epssScore, exposure)
 			recommendedAction := s.GetRecommendedAction(priorityScore)
 
 			if priorityScore >= 70 {
@@ -246,9 +267,9 @@ func (s *Service) CalculateManifestPriorities(ctx context.Context, manifestID uu
 
 			// Store Priority
 			_, err = s.DB.ExecContext(ctx, `
-				INSERT INTO manifest_vuln_priority (manifest_id, cve_id, base_severity, epss_score, runtime_exposed, priority_score, recommended_action)
-				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-				manifestID, v.VulnerabilityID, v.Severity, epssScore, runtimeExposed, priorityScore, recommendedAction)
+				INSERT INTO manifest_vuln_priority (manifest_id, cve_id, base_severity, epss_score, runtime_exposed, internet_exposed, priority_score, recommended_action)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				manifestID, v.VulnerabilityID, v.Severity, epssScore, exposure.Running, exposure.InternetExposed, priorityScore, recommendedAction)
 			
 			if err != nil {
 				fmt.Printf("[Intelligence] Failed to store priority for %s: %v\n", v.VulnerabilityID, err)
@@ -264,7 +285,7 @@ func (s *Service) CalculateManifestPriorities(ctx context.Context, manifestID uu
 func (s *Service) GetPrioritizedVulnerabilities(ctx context.Context, manifestID uuid.UUID) ([]VulnPriority, error) {
 	rows, err := s.DB.QueryContext(ctx, `
 		SELECT id, manifest_id, cve_id, base_severity, epss_score,
-		       runtime_exposed, priority_score, recommended_action, created_at
+		       runtime_exposed, COALESCE(internet_exposed, false), priority_score, recommended_action, created_at
 		FROM manifest_vuln_priority
 		WHERE manifest_id = $1
 		ORDER BY priority_score DESC
@@ -280,7 +301,7 @@ func (s *Service) GetPrioritizedVulnerabilities(ctx context.Context, manifestID
 		var p VulnPriority
 		err := rows.Scan(
 			&p.ID, &p.ManifestID, &p.CVEID, &p.BaseSeverity, &p.EPSSScore,
-			&p.RuntimeExposed, &p.PriorityScore, &p.RecommendedAction, &p.Created,
+			&p.RuntimeExposed, &p.InternetExposed, &p.PriorityScore, &p.RecommendedAction, &p.Created,
 		)
 		if err != nil {
 			continue
@@ -0,0 +1,252 @@
+// Package ipallow restricts pushes and pulls to allowed networks: per
+// namespace, and per service account so CI credentials only work from known
+// runner networks. Requests from elsewhere get DENIED.
+package ipallow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/clientip"
+	"github.com/registryx/registryx/backend/pkg/errcode"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// MaxCIDRs bounds an allowlist.
+const MaxCIDRs = 100
+
+// cacheTTL is how long allowlists are cached; changes made on another
+// instance take effect within it.
+const cacheTTL = 30 * time.Second
+
+// serviceAccountPrefix starts the token subject of service accounts.
+const serviceAccountPrefix = "serviceaccount:"
+
+// Normalize validates CIDRs (a bare address is a single host) and returns
+// them in canonical form without duplicates.
+func Normalize(cidrs []string) ([]string, error) {
+	if len(cidrs) > MaxCIDRs {
+		return nil, fmt.Errorf("at most %d networks", MaxCIDRs)
+	}
+	seen := map[string]bool{}
+	out := []string{}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q", c)
+			}
+			if ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", c)
+		}
+		if s := ipNet.String(); !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// Contains reports whether ip is in one of cidrs. An empty list allows any
+// address.
+func Contains(cidrs []string, ip string) bool {
+	if len(cidrs) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, c := range cidrs {
+		if _, ipNet, err := net.ParseCIDR(c); err == nil && ipNet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checker enforces the allowlists stored with namespaces and service
+// accounts.
+type Checker struct {
+	DB *sql.DB
+
+	// KeyFunc verifies the embedded scanner's tokens, which aren't held to
+	// allowlists; set by main.
+	KeyFunc jwt.Keyfunc
+
+	mu    sync.Mutex
+	cache map[string]cached // "ns:<name>" or "sa:<name>"
+}
+
+type cached struct {
+	cidrs   []string
+	expires time.Time
+}
+
+func NewChecker(db *sql.DB) *Checker {
+	return &Checker{DB: db, cache: make(map[string]cached)}
+}
+
+// Middleware denies requests to /v2/{name}/... routes from outside the
+// namespace's networks, and requests carrying a service account's token
+// from outside the account's networks. The embedded scanner is always
+// allowed.
+func (c *Checker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := mux.Vars(r)["name"]
+		if c == nil || !ok || c.scanner(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientip.FromRequest(r)
+
+		ns, _ := authz.SplitRepository(name)
+		cidrs, err := c.lookup(r.Context(), "ns:"+ns, `SELECT allowed_cidrs FROM namespaces WHERE name = $1`, ns)
+		if err != nil {
+			fmt.Printf("[IPAllow] Failed to load networks of namespace %s: %v\n", ns, err)
+			errcode.ServeJSON(w, errcode.Unavailable)
+			return
+		}
+		if !Contains(cidrs, ip) {
+			fmt.Printf("[IPAllow] Denied %s %s from %s: outside the networks of namespace %s\n", r.Method, r.URL.Path, ip, ns)
+			errcode.ServeJSON(w, errcode.Denied.WithMessage(fmt.Sprintf("namespace %s does not allow access from %s", ns, ip)))
+			return
+		}
+
+		// The token's signature is checked by the auth middleware; a forged
+		// token gets no further than this.
+		if account := serviceAccount(r); account != "" {
+			cidrs, err := c.lookup(r.Context(), "sa:"+account, `SELECT allowed_cidrs FROM service_accounts WHERE name = $1`, account)
+			if err != nil {
+				fmt.Printf("[IPAllow] Failed to load networks of service account %s: %v\n", account, err)
+				errcode.ServeJSON(w, errcode.Unavailable)
+				return
+			}
+			if !Contains(cidrs, ip) {
+				fmt.Printf("[IPAllow] Denied %s %s from %s: outside the networks of service account %s\n", r.Method, r.URL.Path, ip, account)
+				errcode.ServeJSON(w, errcode.Denied.WithMessage(fmt.Sprintf("service account %s may not be used from %s", account, ip)))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Permits reports whether the request comes from one of cidrs.
+func (c *Checker) Permits(r *http.Request, cidrs []string) bool {
+	return Contains(cidrs, clientip.FromRequest(r))
+}
+
+// lookup returns an allowlist, from the cache when fresh. Unknown rows allow
+// any address.
+func (c *Checker) lookup(ctx context.Context, key, query, name string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.cidrs, nil
+	}
+
+	var cidrs []string
+	err := c.DB.QueryRowContext(ctx, query, name).Scan(pq.Array(&cidrs))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.cache[key] = cached{cidrs: cidrs, expires: time.Now().Add(cacheTTL)}
+	c.mu.Unlock()
+	return cidrs, nil
+}
+
+// GetNamespace returns a namespace's networks; empty allows any address.
+func (c *Checker) GetNamespace(ctx context.Context, ns string) ([]string, error) {
+	var cidrs []string
+	err := c.DB.QueryRowContext(ctx, `SELECT allowed_cidrs FROM namespaces WHERE name = $1`, ns).Scan(pq.Array(&cidrs))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if cidrs == nil {
+		cidrs = []string{}
+	}
+	return cidrs, nil
+}
+
+// SetNamespace replaces a namespace's networks, creating the namespace if
+// needed. cidrs must be normalized; empty allows any address.
+func (c *Checker) SetNamespace(ctx context.Context, ns string, cidrs []string) error {
+	_, err := c.DB.ExecContext(ctx, `
+		INSERT INTO namespaces (name, allowed_cidrs) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET allowed_cidrs = EXCLUDED.allowed_cidrs, updated_at = CURRENT_TIMESTAMP`,
+		ns, pq.Array(cidrs))
+	if err == nil {
+		c.forget("ns:" + ns)
+	}
+	return err
+}
+
+// ForgetServiceAccount drops a service account's cached networks after they
+// change.
+func (c *Checker) ForgetServiceAccount(name string) {
+	c.forget("sa:" + name)
+}
+
+func (c *Checker) forget(key string) {
+	c.mu.Lock()
+	delete(c.cache, key)
+	c.mu.Unlock()
+}
+
+// scanner reports whether the request carries a valid token of the embedded
+// scanner.
+func (c *Checker) scanner(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if c.KeyFunc == nil || !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token, err := jwt.Parse(strings.TrimPrefix(auth, "Bearer "), c.KeyFunc)
+	if err != nil || !token.Valid {
+		return false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	return ok && claims["iss"] == middleware.RegistryTokenIssuer && claims["sub"] == middleware.ScannerSubject
+}
+
+// serviceAccount returns the service account a request's bearer token was
+// issued to, if any.
+func serviceAccount(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(strings.TrimPrefix(auth, "Bearer "), jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+	sub, _ := token.Claims.GetSubject()
+	if !strings.HasPrefix(sub, serviceAccountPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(sub, serviceAccountPrefix)
+}
@@ -0,0 +1,224 @@
+// Package ldapauth checks passwords against an LDAP directory or Active
+// Directory: it looks a user up with a service account, binds as the user
+// with their password, and maps the groups in the user's memberOf attribute
+// to a registry role.
+package ldapauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// timeout bounds connecting to and each request against the directory.
+const timeout = 10 * time.Second
+
+var (
+	// ErrUserNotFound is returned when the directory has no such user.
+	ErrUserNotFound = errors.New("user not found in directory")
+	// ErrInvalidCredentials is returned when the directory refuses the
+	// password.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrNoAccess is returned when group roles are configured and the user
+	// is in none of the groups.
+	ErrNoAccess = errors.New("user is not in a group with registry access")
+)
+
+// Registry roles groups map to.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Config locates users in the directory.
+type Config struct {
+	URL      string // ldap://host:389 or ldaps://host:636
+	StartTLS bool   // upgrade ldap:// connections with StartTLS
+	CAFile   string // PEM bundle the server certificate is verified against (empty = system roots)
+
+	// BindDN and BindPassword are the service account users are searched
+	// with; empty searches anonymously.
+	BindDN       string
+	BindPassword string
+
+	BaseDN string // subtree users are searched in
+	// UserFilter finds a user; %s is replaced by the escaped username,
+	// e.g. "(uid=%s)" or, for Active Directory, "(sAMAccountName=%s)".
+	UserFilter     string
+	EmailAttribute string // e.g. "mail"
+	GroupAttribute string // e.g. "memberOf"
+
+	// GroupRoles maps group DNs, compared case-insensitively, to RoleAdmin
+	// or RoleUser. When set, only members of these groups may sign in.
+	GroupRoles map[string]string
+}
+
+// User is an authenticated directory user.
+type User struct {
+	DN       string
+	Username string
+	Email    string
+	// Role is the highest role of the user's groups, or "" when no group
+	// roles are configured.
+	Role string
+}
+
+// Authenticator checks credentials against a directory.
+type Authenticator struct {
+	cfg Config
+	tls *tls.Config
+}
+
+// New creates an authenticator.
+func New(cfg Config) (*Authenticator, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("LDAP URL and base DN are required")
+	}
+	if strings.Count(cfg.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("user filter %q must contain %%s once", cfg.UserFilter)
+	}
+	a := &Authenticator{cfg: cfg, tls: &tls.Config{MinVersion: tls.VersionTLS12}}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read LDAP CA file: %w", err)
+		}
+		a.tls.RootCAs = x509.NewCertPool()
+		if !a.tls.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	for dn, role := range cfg.GroupRoles {
+		if role != RoleAdmin && role != RoleUser {
+			return nil, fmt.Errorf("group %s: role must be %s or %s, not %q", dn, RoleAdmin, RoleUser, role)
+		}
+	}
+	return a, nil
+}
+
+// ParseGroupRoles parses "<group DN>=<role>" pairs separated by semicolons,
+// e.g. "cn=registry-admins,ou=groups,dc=example,dc=com=admin". The role
+// follows the last "=".
+func ParseGroupRoles(s string) (map[string]string, error) {
+	roles := map[string]string{}
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid group role %q: use <group DN>=<role>", pair)
+		}
+		roles[normalizeDN(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return roles, nil
+}
+
+// Authenticate checks a username and password against the directory.
+func (a *Authenticator) Authenticate(username, password string) (*User, error) {
+	// An empty password would make an unauthenticated bind, which succeeds.
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP service bind failed: %w", err)
+		}
+	}
+	attrs := []string{"dn"}
+	for _, attr := range []string{a.cfg.EmailAttribute, a.cfg.GroupAttribute} {
+		if attr != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(timeout/time.Second), false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(username)), attrs, nil))
+	if err != nil {
+		return nil, fmt.Errorf("LDAP search failed: %w", err)
+	}
+	switch len(res.Entries) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+	default:
+		return nil, fmt.Errorf("LDAP filter matches several entries for %q", username)
+	}
+	entry := res.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP bind failed: %w", err)
+	}
+
+	u := &User{DN: entry.DN, Username: username}
+	if a.cfg.EmailAttribute != "" {
+		u.Email = entry.GetAttributeValue(a.cfg.EmailAttribute)
+	}
+	if len(a.cfg.GroupRoles) > 0 {
+		for _, group := range entry.GetAttributeValues(a.cfg.GroupAttribute) {
+			switch a.cfg.GroupRoles[normalizeDN(group)] {
+			case RoleAdmin:
+				u.Role = RoleAdmin
+			case RoleUser:
+				if u.Role == "" {
+					u.Role = RoleUser
+				}
+			}
+		}
+		if u.Role == "" {
+			return nil, ErrNoAccess
+		}
+	}
+	return u, nil
+}
+
+func (a *Authenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(a.tls))
+	if err != nil {
+		return nil, fmt.Errorf("connect to LDAP: %w", err)
+	}
+	conn.SetTimeout(timeout)
+	if u, _ := url.Parse(a.cfg.URL); a.cfg.StartTLS && u != nil && u.Scheme == "ldap" {
+		cfg := a.tls.Clone()
+		cfg.ServerName = u.Hostname()
+		if err := conn.StartTLS(cfg); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("LDAP StartTLS failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// normalizeDN lowercases a DN and drops the spaces around its separators,
+// so "CN=Admins, DC=example" matches "cn=admins,dc=example".
+func normalizeDN(dn string) string {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(dn)), ",")
+	for i, p := range parts {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			parts[i] = strings.TrimSpace(k) + "=" + strings.TrimSpace(v)
+		} else {
+			parts[i] = strings.TrimSpace(p)
+		}
+	}
+	return strings.Join(parts, ",")
+}
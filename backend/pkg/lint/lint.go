@@ -0,0 +1,238 @@
+// Package lint checks images against container best practices using only
+// the manifest and config blob: who the image runs as, whether it declares a
+// health check, how big its layers are, whether its base is pinned and
+// whether package manager caches were left behind.
+package lint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/registryx/registryx/backend/pkg/imagehistory"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// Rule identifiers. Policies match on these, so they must not change.
+const (
+	RuleRootUser           = "root-user"
+	RuleMissingUser        = "missing-user"
+	RuleMissingHealthcheck = "missing-healthcheck"
+	RuleLargeLayer         = "large-layer"
+	RuleLatestBase         = "latest-base-tag"
+	RulePackageCache       = "package-cache"
+)
+
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+// baseNameAnnotation names the base image in manifest annotations or config
+// labels. Image configs do not record FROM otherwise, so images built without
+// it are never flagged for an unpinned base.
+const baseNameAnnotation = "org.opencontainers.image.base.name"
+
+// maxConfigSize bounds the config blob read; real configs are a few kilobytes.
+const maxConfigSize = 16 * 1024 * 1024
+
+// Finding is one best-practice violation.
+type Finding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Step     *int   `json:"step,omitempty"` // index into the image history
+}
+
+// Linter lints images stored in Storage.
+type Linter struct {
+	Storage       storage.Driver
+	MaxLayerBytes int64 // layers above this are flagged; 0 disables the check
+}
+
+func NewLinter(store storage.Driver, maxLayerBytes int64) *Linter {
+	return &Linter{Storage: store, MaxLayerBytes: maxLayerBytes}
+}
+
+type imageConfig struct {
+	Config struct {
+		User        string            `json:"User"`
+		Labels      map[string]string `json:"Labels"`
+		Healthcheck *struct {
+			Test []string `json:"Test"`
+		} `json:"Healthcheck"`
+	} `json:"config"`
+}
+
+// Lint checks the image described by manifestBody. Indexes return
+// imagehistory.ErrIndex; lint each platform manifest instead.
+func (l *Linter) Lint(ctx context.Context, manifestBody []byte) ([]Finding, error) {
+	m, err := imagehistory.ParseManifest(manifestBody)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := l.Storage.Reader(ctx, path.Join("blobs", m.Config.Digest))
+	if err != nil {
+		return nil, fmt.Errorf("read config %s: %w", m.Config.Digest, err)
+	}
+	defer reader.Close()
+	configBlob, err := io.ReadAll(io.LimitReader(reader, maxConfigSize))
+	if err != nil {
+		return nil, fmt.Errorf("read config %s: %w", m.Config.Digest, err)
+	}
+	return l.Check(m, configBlob)
+}
+
+// Check lints a parsed manifest and its config blob. Artifacts such as
+// attestations have no findings.
+func (l *Linter) Check(m *imagehistory.Manifest, configBlob []byte) ([]Finding, error) {
+	if !hasFilesystem(m) {
+		return []Finding{}, nil
+	}
+
+	var cfg imageConfig
+	if err := json.Unmarshal(configBlob, &cfg); err != nil {
+		return nil, err
+	}
+	steps, err := imagehistory.Reconstruct(m, configBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []Finding{}
+
+	switch user := strings.TrimSpace(cfg.Config.User); {
+	case user == "":
+		findings = append(findings, Finding{
+			Rule: RuleMissingUser, Severity: SeverityMedium,
+			Message: "No USER instruction; the container runs as root",
+		})
+	case isRoot(user):
+		findings = append(findings, Finding{
+			Rule: RuleRootUser, Severity: SeverityHigh,
+			Message: fmt.Sprintf("Image runs as root (USER %s)", user),
+		})
+	}
+
+	if hc := cfg.Config.Healthcheck; hc == nil || len(hc.Test) == 0 || hc.Test[0] == "NONE" {
+		findings = append(findings, Finding{
+			Rule: RuleMissingHealthcheck, Severity: SeverityLow,
+			Message: "No HEALTHCHECK instruction",
+		})
+	}
+
+	base := m.Annotations[baseNameAnnotation]
+	if base == "" {
+		base = cfg.Config.Labels[baseNameAnnotation]
+	}
+	if base != "" && unpinnedLatest(base) {
+		findings = append(findings, Finding{
+			Rule: RuleLatestBase, Severity: SeverityMedium,
+			Message: fmt.Sprintf("Base image %s uses the latest tag; pin a version or digest", base),
+		})
+	}
+
+	if l.MaxLayerBytes > 0 {
+		stepFor := make(map[string]int)
+		for _, s := range steps {
+			if s.LayerDigest != "" {
+				stepFor[s.LayerDigest] = s.Index
+			}
+		}
+		for _, layer := range m.Layers {
+			if layer.Size <= l.MaxLayerBytes {
+				continue
+			}
+			f := Finding{
+				Rule: RuleLargeLayer, Severity: SeverityMedium,
+				Message: fmt.Sprintf("Layer %s is %d MB (limit %d MB)", shortDigest(layer.Digest), layer.Size>>20, l.MaxLayerBytes>>20),
+			}
+			if i, ok := stepFor[layer.Digest]; ok {
+				f.Step = &i
+				f.Message += ", created by: " + steps[i].Command
+			}
+			findings = append(findings, f)
+		}
+	}
+
+	for _, s := range steps {
+		if s.EmptyLayer || s.Instruction != "RUN" {
+			continue
+		}
+		if pm := leftoverCache(s.Command); pm != "" {
+			i := s.Index
+			findings = append(findings, Finding{
+				Rule: RulePackageCache, Severity: SeverityLow, Step: &i,
+				Message: fmt.Sprintf("%s cache left in the image; clean it in the same RUN step", pm),
+			})
+		}
+	}
+	return findings, nil
+}
+
+// hasFilesystem reports whether the manifest is a runnable image rather
+// than an artifact (attestations, signatures) stored as a manifest.
+func hasFilesystem(m *imagehistory.Manifest) bool {
+	for _, layer := range m.Layers {
+		if strings.Contains(layer.MediaType, "tar") {
+			return true
+		}
+	}
+	return false
+}
+
+func isRoot(user string) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == "root" || name == "0"
+}
+
+// unpinnedLatest reports whether an image reference resolves to :latest
+// without a digest pinning it.
+func unpinnedLatest(ref string) bool {
+	if strings.Contains(ref, "@") {
+		return false
+	}
+	i := strings.LastIndex(ref, ":")
+	if i <= strings.LastIndex(ref, "/") {
+		return true // no tag means latest
+	}
+	return ref[i+1:] == "latest"
+}
+
+func shortDigest(d string) string {
+	d = strings.TrimPrefix(d, "sha256:")
+	if len(d) > 12 {
+		d = d[:12]
+	}
+	return d
+}
+
+// packageCaches pairs each package manager's install command with the ways
+// its cache is usually avoided or removed in the same step.
+var packageCaches = []struct {
+	name    string
+	install *regexp.Regexp
+	clean   *regexp.Regexp
+}{
+	{"apt", regexp.MustCompile(`\bapt(-get)?\s+(\S+\s+)*install\b`), regexp.MustCompile(`rm\s+-[a-zA-Z]*\s+\S*/var/lib/apt/lists`)},
+	{"apk", regexp.MustCompile(`\bapk\s+(\S+\s+)*add\b`), regexp.MustCompile(`--no-cache|rm\s+-[a-zA-Z]*\s+\S*/var/cache/apk`)},
+	{"yum/dnf", regexp.MustCompile(`\b(yum|dnf|microdnf)\s+(\S+\s+)*install\b`), regexp.MustCompile(`\b(yum|dnf|microdnf)\s+clean\s+all\b|rm\s+-[a-zA-Z]*\s+\S*/var/cache/(yum|dnf)`)},
+	{"pip", regexp.MustCompile(`\bpip3?\s+(\S+\s+)*install\b`), regexp.MustCompile(`--no-cache-dir|PIP_NO_CACHE_DIR|pip3?\s+cache\s+purge|rm\s+-[a-zA-Z]*\s+\S*/\.cache/pip`)},
+	{"npm", regexp.MustCompile(`\bnpm\s+(install|ci|i)\b`), regexp.MustCompile(`npm\s+cache\s+clean|rm\s+-[a-zA-Z]*\s+\S*/\.npm`)},
+}
+
+// leftoverCache returns the package manager whose cache a RUN command leaves
+// behind, or "".
+func leftoverCache(command string) string {
+	for _, pc := range packageCaches {
+		if pc.install.MatchString(command) && !pc.clean.MatchString(command) {
+			return pc.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,183 @@
+// Package maintenance implements the registry-wide read-only mode. While it
+// is on, pushes, deletes and garbage collection are refused with 503 and a
+// Retry-After header; pulls and browsing keep working.
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/registryx/registryx/backend/pkg/errcode"
+)
+
+// Key is the Redis key holding the shared state, so every instance agrees.
+const Key = "registryx:maintenance"
+
+// DefaultRetryAfter is sent when the state does not set one.
+const DefaultRetryAfter = 300
+
+// cacheTTL bounds how long an instance may act on a stale state.
+const cacheTTL = 2 * time.Second
+
+// State is the maintenance mode setting.
+type State struct {
+	Enabled           bool      `json:"enabled"`
+	Reason            string    `json:"reason,omitempty"`
+	RetryAfterSeconds int       `json:"retryAfterSeconds,omitempty"`
+	Since             time.Time `json:"since,omitempty"`
+	EnabledBy         string    `json:"enabledBy,omitempty"`
+}
+
+// Service stores the state in Redis, or in memory when Redis is unavailable.
+// A nil *Service is valid and never in maintenance.
+type Service struct {
+	rdb *redis.Client
+
+	mu       sync.Mutex
+	state    State
+	loadedAt time.Time
+}
+
+func NewService(rdb *redis.Client) *Service {
+	return &Service{rdb: rdb}
+}
+
+// Get returns the current state, cached for a couple of seconds.
+func (s *Service) Get(ctx context.Context) State {
+	if s == nil {
+		return State{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rdb == nil || time.Since(s.loadedAt) < cacheTTL {
+		return s.state
+	}
+
+	data, err := s.rdb.Get(ctx, Key).Bytes()
+	switch {
+	case err == redis.Nil:
+		s.state = State{}
+	case err != nil:
+		// Keep the last known state rather than flapping on a Redis hiccup.
+		fmt.Printf("[Maintenance] Failed to read state: %v\n", err)
+	default:
+		var st State
+		if err := json.Unmarshal(data, &st); err == nil {
+			s.state = st
+		}
+	}
+	s.loadedAt = time.Now()
+	return s.state
+}
+
+// Set replaces the state for every instance.
+func (s *Service) Set(ctx context.Context, st State) error {
+	if !st.Enabled {
+		st = State{}
+	} else if st.Since.IsZero() {
+		st.Since = time.Now()
+	}
+	if s.rdb != nil {
+		var err error
+		if st.Enabled {
+			var data []byte
+			data, err = json.Marshal(st)
+			if err == nil {
+				err = s.rdb.Set(ctx, Key, data, 0).Err()
+			}
+		} else {
+			err = s.rdb.Del(ctx, Key).Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.state = st
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// repositorySettings matches the DELETE routes under /api/v1/repositories/
+// that remove grants or settings of a repository rather than its tags,
+// manifests or the repository itself.
+var repositorySettings = regexp.MustCompile(`/(permissions|alert-rules|tag-expiry-rules)/[^/]+$|/tags/[^/]+/expiry$|/retention$`)
+
+// blocked reports whether r writes or deletes registry data.
+func blocked(r *http.Request) bool {
+	p := r.URL.Path
+	if strings.HasPrefix(p, "/v2/") {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			return true
+		}
+		return false
+	}
+	if !strings.HasPrefix(p, "/api/v1/") {
+		return false
+	}
+	if r.Method == http.MethodDelete {
+		// Only registry data is protected: signing out and revoking
+		// credentials, tokens and grants must keep working.
+		switch {
+		case strings.HasPrefix(p, "/api/v1/imports/"):
+			return true
+		case strings.HasPrefix(p, "/api/v1/repositories/"):
+			return !repositorySettings.MatchString(p)
+		}
+		return false
+	}
+	if r.Method != http.MethodPost {
+		return false
+	}
+	switch p {
+	case "/api/v1/system/gc", "/api/v1/costs/cleanup-zombies", "/api/v1/system/backups/restore":
+		return true
+	case "/api/v1/system/fsck":
+		return r.URL.Query().Get("repair") == "true"
+	}
+	if strings.HasPrefix(p, "/api/v1/repositories/") && strings.HasSuffix(p, "/retention/run") {
+		return r.URL.Query().Get("dryRun") != "true"
+	}
+	// Accepting a transfer moves manifests in storage.
+	return strings.HasPrefix(p, "/api/v1/transfers/") && strings.HasSuffix(p, "/accept")
+}
+
+// Middleware rejects writes while maintenance mode is on.
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !blocked(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		st := s.Get(r.Context())
+		if !st.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := st.RetryAfterSeconds
+		if retryAfter <= 0 {
+			retryAfter = DefaultRetryAfter
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		msg := "registry is in read-only maintenance mode"
+		if st.Reason != "" {
+			msg += ": " + st.Reason
+		}
+		if strings.HasPrefix(r.URL.Path, "/v2/") {
+			errcode.ServeJSON(w, errcode.Unavailable.WithMessage(msg))
+			return
+		}
+		http.Error(w, msg, http.StatusServiceUnavailable)
+	})
+}
@@ -0,0 +1,108 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Sources of build metadata other than the API.
+const (
+	ProvenanceBuildKit = "buildkit" // unsigned BuildKit attestation
+	ProvenanceSigstore = "sigstore" // signed attestation bundle
+)
+
+// BuildMetadata describes the CI build that produced an image.
+type BuildMetadata struct {
+	PipelineURL      string `json:"pipelineUrl,omitempty"`
+	CommitSHA        string `json:"commitSha,omitempty"`
+	Branch           string `json:"branch,omitempty"`
+	Builder          string `json:"builder,omitempty"`
+	Workflow         string `json:"workflow,omitempty"`
+	SourceRepository string `json:"sourceRepository,omitempty"`
+
+	// Provenance is empty when the metadata was reported through the API.
+	Provenance        string    `json:"provenance,omitempty"`
+	Verified          bool      `json:"verified"`
+	VerificationError string    `json:"verificationError,omitempty"`
+	ReportedBy        string    `json:"reportedBy,omitempty"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// SetBuildMetadata records (or replaces) the build metadata of a digest in
+// repoName. The manifest does not have to be pushed yet, but the repository
+// must exist.
+func (s *Service) SetBuildMetadata(ctx context.Context, repoName, digest string, md *BuildMetadata) error {
+	nsName, rName := splitRepoName(repoName)
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO build_metadata (repository_id, digest, pipeline_url, commit_sha, branch, builder, workflow,
+			source_repository, provenance, verified, verification_error, reported_by, updated_at)
+		SELECT r.id, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NOW()
+		FROM repositories r JOIN namespaces n ON r.namespace_id = n.id
+		WHERE n.name = $1 AND r.name = $2
+		ON CONFLICT (repository_id, digest) DO UPDATE SET
+			pipeline_url = EXCLUDED.pipeline_url, commit_sha = EXCLUDED.commit_sha,
+			branch = EXCLUDED.branch, builder = EXCLUDED.builder, workflow = EXCLUDED.workflow,
+			source_repository = EXCLUDED.source_repository, provenance = EXCLUDED.provenance,
+			verified = EXCLUDED.verified, verification_error = EXCLUDED.verification_error,
+			reported_by = EXCLUDED.reported_by, updated_at = EXCLUDED.updated_at
+		RETURNING updated_at`,
+		nsName, rName, digest, md.PipelineURL, md.CommitSHA, md.Branch, md.Builder, md.Workflow,
+		md.SourceRepository, md.Provenance, md.Verified, md.VerificationError, md.ReportedBy).Scan(&md.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrRepositoryNotFound
+	}
+	return err
+}
+
+// GetBuildMetadata returns the build metadata of a digest in repoName, or
+// nil if none was recorded.
+func (s *Service) GetBuildMetadata(ctx context.Context, repoName, digest string) (*BuildMetadata, error) {
+	nsName, rName := splitRepoName(repoName)
+	var md BuildMetadata
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT b.pipeline_url, b.commit_sha, b.branch, b.builder, b.workflow, b.source_repository,
+		       b.provenance, b.verified, b.verification_error, b.reported_by, b.updated_at
+		FROM build_metadata b
+		JOIN repositories r ON b.repository_id = r.id
+		JOIN namespaces n ON r.namespace_id = n.id
+		WHERE n.name = $1 AND r.name = $2 AND b.digest = $3`,
+		nsName, rName, digest).Scan(&md.PipelineURL, &md.CommitSHA, &md.Branch, &md.Builder, &md.Workflow, &md.SourceRepository,
+		&md.Provenance, &md.Verified, &md.VerificationError, &md.ReportedBy, &md.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+// RecordProvenance stores build metadata recovered from an attestation.
+// Attestations take precedence over metadata reported through the API, whose
+// values only fill fields the attestation lacks, but an unverified
+// attestation never replaces a verified one.
+func (s *Service) RecordProvenance(ctx context.Context, repoName, digest string, md *BuildMetadata) error {
+	existing, err := s.GetBuildMetadata(ctx, repoName, digest)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if existing.Verified && !md.Verified {
+			return nil
+		}
+		if existing.Provenance == "" {
+			fill(&md.PipelineURL, existing.PipelineURL)
+			fill(&md.CommitSHA, existing.CommitSHA)
+			fill(&md.Branch, existing.Branch)
+			fill(&md.Builder, existing.Builder)
+		}
+	}
+	return s.SetBuildMetadata(ctx, repoName, digest, md)
+}
+
+func fill(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
@@ -0,0 +1,21 @@
+package metadata
+
+import "errors"
+
+// Errors callers can tell apart with errors.Is.
+var (
+	// ErrRepositoryNotFound is returned when a repository must already exist.
+	ErrRepositoryNotFound = errors.New("repository not found")
+	ErrManifestNotFound   = errors.New("manifest not found")
+	ErrTagNotFound        = errors.New("tag not found")
+	ErrRuleNotFound       = errors.New("expiry rule not found")
+	ErrNoRetentionPolicy  = errors.New("repository has no retention policy")
+	ErrBlobNotFound       = errors.New("blob not found")
+	// ErrBlobInUse is returned when deleting a blob a manifest references.
+	ErrBlobInUse = errors.New("blob is referenced by a manifest")
+	// ErrBlobRecent is returned when deleting a blob uploaded within the
+	// garbage collection grace period.
+	ErrBlobRecent = errors.New("blob was uploaded too recently to delete")
+	// ErrQuotaExceeded is wrapped with the namespace's usage and quota.
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
@@ -0,0 +1,230 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TagInfo is a tag with what it points to and when it expires.
+type TagInfo struct {
+	Name      string     `json:"name"`
+	Digest    string     `json:"digest"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// TagExpiryRule gives tags matching Pattern (a glob, e.g. "pr-*") an expiry
+// TTLSeconds after each push.
+type TagExpiryRule struct {
+	ID         uuid.UUID `json:"id"`
+	Pattern    string    `json:"pattern"`
+	TTLSeconds int64     `json:"ttlSeconds"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// ExpiredTag is a tag deleted because it expired.
+type ExpiredTag struct {
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+func (s *Service) repositoryID(ctx context.Context, repoName string) (uuid.UUID, error) {
+	nsName, rName := splitRepoName(repoName)
+	var id uuid.UUID
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT r.id FROM repositories r
+		JOIN namespaces n ON r.namespace_id = n.id
+		WHERE n.name = $1 AND r.name = $2`, nsName, rName).Scan(&id)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, ErrRepositoryNotFound
+	}
+	return id, err
+}
+
+// ListTagDetails returns a repository's tags with their digest and expiry,
+// most recently updated first.
+func (s *Service) ListTagDetails(ctx context.Context, repoName string) ([]TagInfo, error) {
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT t.name, m.digest, t.updated_at, t.expires_at
+		FROM tags t JOIN manifests m ON m.id = t.manifest_id
+		WHERE t.repository_id = $1
+		ORDER BY t.updated_at DESC, t.name`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TagInfo{}
+	for rows.Next() {
+		var t TagInfo
+		if err := rows.Scan(&t.Name, &t.Digest, &t.UpdatedAt, &t.ExpiresAt); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// SetTagExpiry sets when a tag expires; nil keeps it forever. The expiry
+// holds until it is changed or a rule sets a new one on the next push.
+func (s *Service) SetTagExpiry(ctx context.Context, repoName, tag string, expiresAt *time.Time) error {
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `
+		UPDATE tags SET expires_at = $3 WHERE repository_id = $1 AND name = $2`, repoID, tag, expiresAt)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// ExtendTagExpiry pushes a tag's expiry back by d, counting from now when
+// it has none or it already passed, and returns the new expiry.
+func (s *Service) ExtendTagExpiry(ctx context.Context, repoName, tag string, d time.Duration) (time.Time, error) {
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var expiresAt time.Time
+	err = s.DB.QueryRowContext(ctx, `
+		UPDATE tags SET expires_at = GREATEST(COALESCE(expires_at, NOW()), NOW()) + $3::float8 * INTERVAL '1 second'
+		WHERE repository_id = $1 AND name = $2
+		RETURNING expires_at`, repoID, tag, int64(d/time.Second)).Scan(&expiresAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, ErrTagNotFound
+	}
+	return expiresAt, err
+}
+
+// ListTagExpiryRules returns a repository's expiry rules.
+func (s *Service) ListTagExpiryRules(ctx context.Context, repoName string) ([]TagExpiryRule, error) {
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return nil, err
+	}
+	return tagExpiryRules(ctx, s.DB, repoID)
+}
+
+func tagExpiryRules(ctx context.Context, q querier, repoID uuid.UUID) ([]TagExpiryRule, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, pattern, ttl_seconds, created_at FROM tag_expiry_rules
+		WHERE repository_id = $1 ORDER BY created_at`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []TagExpiryRule{}
+	for rows.Next() {
+		var r TagExpiryRule
+		if err := rows.Scan(&r.ID, &r.Pattern, &r.TTLSeconds, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// SetTagExpiryRule creates the rule for pattern, or changes its TTL. It
+// applies from the next push of a matching tag.
+func (s *Service) SetTagExpiryRule(ctx context.Context, repoName, pattern string, ttl time.Duration) (*TagExpiryRule, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return nil, err
+	}
+	r := &TagExpiryRule{Pattern: pattern, TTLSeconds: int64(ttl / time.Second)}
+	err = s.DB.QueryRowContext(ctx, `
+		INSERT INTO tag_expiry_rules (repository_id, pattern, ttl_seconds) VALUES ($1, $2, $3)
+		ON CONFLICT (repository_id, pattern) DO UPDATE SET ttl_seconds = EXCLUDED.ttl_seconds
+		RETURNING id, created_at`, repoID, pattern, r.TTLSeconds).Scan(&r.ID, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// DeleteTagExpiryRule removes a rule. Expiries it already set stay.
+func (s *Service) DeleteTagExpiryRule(ctx context.Context, repoName string, id uuid.UUID) error {
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM tag_expiry_rules WHERE id = $1 AND repository_id = $2`, id, repoID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+// pushExpiry returns the expiry the first matching rule gives a tag pushed
+// now, or nil.
+func pushExpiry(ctx context.Context, q querier, repoID uuid.UUID, tag string) (*time.Time, error) {
+	rules, err := tagExpiryRules(ctx, q, repoID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if ok, _ := path.Match(r.Pattern, tag); ok {
+			t := time.Now().Add(time.Duration(r.TTLSeconds) * time.Second)
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+// DeleteExpiredTags deletes up to limit expired tags and returns them. The
+// manifests they pointed to are left for garbage collection.
+func (s *Service) DeleteExpiredTags(ctx context.Context, limit int) ([]ExpiredTag, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		WITH expired AS (
+			SELECT id FROM tags
+			WHERE expires_at IS NOT NULL AND expires_at <= NOW()
+			ORDER BY expires_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		DELETE FROM tags t USING expired, repositories r, namespaces n, manifests m
+		WHERE t.id = expired.id AND r.id = t.repository_id AND n.id = r.namespace_id AND m.id = t.manifest_id
+		RETURNING n.name || '/' || r.name, t.name, m.digest`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deleted []ExpiredTag
+	for rows.Next() {
+		var t ExpiredTag
+		if err := rows.Scan(&t.Repository, &t.Tag, &t.Digest); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(deleted) > 0 {
+		s.MarkStatsDirty()
+	}
+	return deleted, nil
+}
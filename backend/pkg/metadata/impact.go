@@ -0,0 +1,125 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/authz"
+)
+
+// maxImpactDepth bounds the walk down image_dependencies.
+const maxImpactDepth = 20
+
+// ImpactedImage is an image affected by a vulnerable base.
+type ImpactedImage struct {
+	ManifestID string   `json:"manifestId"`
+	Repository string   `json:"repository"`
+	Digest     string   `json:"digest"`
+	Tags       []string `json:"tags"`
+	Depth      int      `json:"depth"`         // 0 for the vulnerable image itself, 1 for its children, ...
+	Via        string   `json:"via,omitempty"` // digest of the vulnerable image it builds on
+	Scanned    bool     `json:"scanned"`       // its own latest scan reports the CVE (CVE queries only)
+}
+
+// ImpactOwner groups impacted images by the owner of their namespace.
+type ImpactOwner struct {
+	Owner  string          `json:"owner"`
+	Images []ImpactedImage `json:"images"`
+}
+
+// ImpactReport is the blast radius of a CVE or base image.
+type ImpactReport struct {
+	CVE    string        `json:"cve,omitempty"`
+	Digest string        `json:"digest,omitempty"`
+	Total  int           `json:"total"`
+	Owners []ImpactOwner `json:"owners"`
+}
+
+// GetImpact finds the images affected by a CVE, or by the base image with
+// the given digest, and everything built on top of them. Affected images come
+// from scan results (manifest_vuln_priority); descendants are found by
+// walking image_dependencies. Non-admins only see images they can read.
+func (s *Service) GetImpact(ctx context.Context, cve, digest string, userID uuid.UUID, role string) (*ImpactReport, error) {
+	rootClause := "m.id IN (SELECT manifest_id FROM manifest_vuln_priority WHERE cve_id = $1)"
+	key := cve
+	if cve == "" {
+		rootClause = "m.digest = $1"
+		key = digest
+	}
+	args := []interface{}{key, maxImpactDepth}
+	whereClause := "1=1"
+	if role != "admin" {
+		whereClause = authz.RepositoryFilter("$3", authz.RoleRead)
+		args = append(args, userID)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		WITH RECURSIVE impact(id, depth, root) AS (
+			SELECT m.id, 0, m.id FROM manifests m WHERE %s
+			UNION
+			SELECT d.manifest_id, i.depth + 1, i.root
+			FROM image_dependencies d JOIN impact i ON d.parent_manifest_id = i.id
+			WHERE i.depth < $2
+		),
+		closest AS (
+			SELECT DISTINCT ON (id) id, depth, root FROM impact ORDER BY id, depth
+		)
+		SELECT m.id, n.name || '/' || r.name, m.digest,
+		       ARRAY(SELECT t.name FROM tags t WHERE t.manifest_id = m.id ORDER BY t.name),
+		       c.depth, rm.digest,
+		       EXISTS (SELECT 1 FROM manifest_vuln_priority v WHERE v.manifest_id = m.id AND v.cve_id = $1),
+		       COALESCE(u.username, n.name)
+		FROM closest c
+		JOIN manifests m ON m.id = c.id
+		JOIN manifests rm ON rm.id = c.root
+		JOIN repositories r ON m.repository_id = r.id
+		JOIN namespaces n ON r.namespace_id = n.id
+		LEFT JOIN users u ON n.owner_id = u.id
+		WHERE %s
+		ORDER BY c.depth, 2, m.digest`, rootClause, whereClause), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &ImpactReport{CVE: cve, Digest: digest, Owners: []ImpactOwner{}}
+	byOwner := make(map[string]*ImpactOwner)
+	for rows.Next() {
+		var img ImpactedImage
+		var owner string
+		if err := rows.Scan(&img.ManifestID, &img.Repository, &img.Digest, pq.Array(&img.Tags),
+			&img.Depth, &img.Via, &img.Scanned, &owner); err != nil {
+			return nil, err
+		}
+		if img.Via == img.Digest {
+			img.Via = ""
+		}
+		if img.Tags == nil {
+			img.Tags = []string{}
+		}
+		group, ok := byOwner[owner]
+		if !ok {
+			group = &ImpactOwner{Owner: owner, Images: []ImpactedImage{}}
+			byOwner[owner] = group
+		}
+		group.Images = append(group.Images, img)
+		report.Total++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, group := range byOwner {
+		report.Owners = append(report.Owners, *group)
+	}
+	sort.Slice(report.Owners, func(i, j int) bool {
+		if len(report.Owners[i].Images) != len(report.Owners[j].Images) {
+			return len(report.Owners[i].Images) > len(report.Owners[j].Images)
+		}
+		return report.Owners[i].Owner < report.Owners[j].Owner
+	})
+	return report, nil
+}
@@ -0,0 +1,226 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ResourceLimits are the global defaults for object counts. Zero means
+// unlimited. Users and namespaces may override them (see migration 011).
+type ResourceLimits struct {
+	RepositoriesPerUser    int
+	TagsPerRepository      int
+	ManifestsPerRepository int
+}
+
+// LimitError is returned when creating something would exceed a resource limit.
+type LimitError struct {
+	Resource string // "repositories", "tags", "manifests" or "bytes"
+	Scope    string // what the limit applies to, e.g. "user" or the repository name
+	Limit    int64
+}
+
+func (e *LimitError) Error() string {
+	switch e.Resource {
+	case "repositories":
+		return fmt.Sprintf("repository limit reached: a user may own at most %d repositories", e.Limit)
+	case "bytes":
+		return fmt.Sprintf("size limit reached: repository %s may hold at most %d bytes", e.Scope, e.Limit)
+	}
+	return fmt.Sprintf("%s limit reached: repository %s may hold at most %d %s", strings.TrimSuffix(e.Resource, "s"), e.Scope, e.Limit, e.Resource)
+}
+
+// repositoryRows selects the rows of repository $1/$2, one per user who
+// pushed to it.
+const repositoryRows = `SELECT r.id FROM repositories r JOIN namespaces n ON r.namespace_id = n.id WHERE n.name = $1 AND r.name = $2`
+
+func splitRepoName(repoName string) (string, string) {
+	parts := strings.SplitN(repoName, "/", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "library", repoName
+}
+
+// CheckRepositoryLimit returns a *LimitError if creating repoName would take
+// the user past their repository limit. Repositories count against the user
+// they belong to, so ones the user already has always pass.
+func (s *Service) CheckRepositoryLimit(ctx context.Context, repoName string, userID uuid.UUID) error {
+	return s.checkRepositoryLimit(ctx, s.DB, repoName, userID)
+}
+
+func (s *Service) checkRepositoryLimit(ctx context.Context, q querier, repoName string, userID uuid.UUID) error {
+	if userID == uuid.Nil {
+		return nil
+	}
+	nsName, rName := splitRepoName(repoName)
+
+	var exists bool
+	var count, limit int
+	err := q.QueryRowContext(ctx, `
+		SELECT
+			EXISTS(
+				SELECT 1 FROM repositories r JOIN namespaces n ON r.namespace_id = n.id
+				WHERE n.name = $1 AND r.name = $2 AND r.owner_id = $3),
+			(SELECT COUNT(*) FROM repositories WHERE owner_id = $3),
+			COALESCE((SELECT max_repositories FROM users WHERE id = $3), $4)`,
+		nsName, rName, userID, s.Limits.RepositoriesPerUser).Scan(&exists, &count, &limit)
+	if err != nil {
+		return err
+	}
+	if !exists && limit > 0 && count >= limit {
+		return &LimitError{Resource: "repositories", Scope: "user", Limit: int64(limit)}
+	}
+	return nil
+}
+
+// checkPushLimits returns a *LimitError if pushing digest (size bytes) as
+// reference would exceed the repository, tag, manifest or size limits.
+// Re-pushing an existing tag or manifest never counts against a limit.
+// Tags, manifests and size are counted over the whole repository, whoever
+// pushed them; only the repository limit is the pushing user's. Each of
+// the repository's rows may carry its own limits, and the lowest applies.
+// RegisterPush runs it in the push's transaction, under lockPush.
+func (s *Service) checkPushLimits(ctx context.Context, q querier, repoName, reference, digest string, size int64, userID uuid.UUID) error {
+	// Pushing creates the user's own row of the repository if they have none.
+	if err := s.checkRepositoryLimit(ctx, q, repoName, userID); err != nil {
+		return err
+	}
+	nsName, rName := splitRepoName(repoName)
+
+	var rows, tagLimit, manifestLimit int
+	var sizeLimit int64
+	err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+			COALESCE(MIN(r.max_tags), MIN(n.max_tags_per_repository), $3),
+			COALESCE(MIN(n.max_manifests_per_repository), $4),
+			COALESCE(MIN(r.max_size_bytes), 0)
+		FROM repositories r
+		JOIN namespaces n ON r.namespace_id = n.id
+		WHERE n.name = $1 AND r.name = $2`,
+		nsName, rName, s.Limits.TagsPerRepository, s.Limits.ManifestsPerRepository).Scan(&rows, &tagLimit, &manifestLimit, &sizeLimit)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		// First push creates the repository; it will hold one tag and one manifest.
+		return nil
+	}
+
+	if sizeLimit > 0 {
+		var exists bool
+		var used int64
+		err := q.QueryRowContext(ctx, `
+			SELECT EXISTS(SELECT 1 FROM manifests WHERE repository_id IN (`+repositoryRows+`) AND digest = $3),
+				(SELECT COALESCE(SUM(size), 0) FROM manifests WHERE repository_id IN (`+repositoryRows+`))`,
+			nsName, rName, digest).Scan(&exists, &used)
+		if err != nil {
+			return err
+		}
+		if !exists && used+size > sizeLimit {
+			return &LimitError{Resource: "bytes", Scope: repoName, Limit: sizeLimit}
+		}
+	}
+
+	if manifestLimit > 0 {
+		var exists bool
+		var count int
+		err := q.QueryRowContext(ctx, `
+			SELECT EXISTS(SELECT 1 FROM manifests WHERE repository_id IN (`+repositoryRows+`) AND digest = $3),
+				(SELECT COUNT(DISTINCT digest) FROM manifests WHERE repository_id IN (`+repositoryRows+`))`,
+			nsName, rName, digest).Scan(&exists, &count)
+		if err != nil {
+			return err
+		}
+		if !exists && count >= manifestLimit {
+			return &LimitError{Resource: "manifests", Scope: repoName, Limit: int64(manifestLimit)}
+		}
+	}
+
+	if tagLimit > 0 && !strings.HasPrefix(reference, "sha256:") {
+		var exists bool
+		var count int
+		err := q.QueryRowContext(ctx, `
+			SELECT EXISTS(SELECT 1 FROM tags WHERE repository_id IN (`+repositoryRows+`) AND name = $3),
+				(SELECT COUNT(DISTINCT name) FROM tags WHERE repository_id IN (`+repositoryRows+`))`,
+			nsName, rName, reference).Scan(&exists, &count)
+		if err != nil {
+			return err
+		}
+		if !exists && count >= tagLimit {
+			return &LimitError{Resource: "tags", Scope: repoName, Limit: int64(tagLimit)}
+		}
+	}
+	return nil
+}
+
+// RepositoryLimits are the size and tag limits in effect for a repository,
+// where they come from, and its current usage.
+type RepositoryLimits struct {
+	Repository   string `json:"repository"`
+	MaxSizeBytes int64  `json:"maxSizeBytes"` // 0 = unlimited
+	MaxTags      int    `json:"maxTags"`      // 0 = unlimited
+	SizeBytes    int64  `json:"sizeBytes"`
+	Tags         int    `json:"tags"`
+	// Overrides set on the repository itself; nil inherits the namespace or
+	// global default.
+	SizeOverride *int64 `json:"sizeOverride"`
+	TagsOverride *int   `json:"tagsOverride"`
+}
+
+// GetRepositoryLimits returns the limits and usage of a repository, taken
+// over all its rows the same way pushes are checked.
+func (s *Service) GetRepositoryLimits(ctx context.Context, repoName string) (*RepositoryLimits, error) {
+	nsName, rName := splitRepoName(repoName)
+	l := &RepositoryLimits{Repository: nsName + "/" + rName}
+	var rows int
+	var sizeOverride sql.NullInt64
+	var tagsOverride sql.NullInt32
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*), MIN(r.max_size_bytes), MIN(r.max_tags),
+			COALESCE(MIN(r.max_size_bytes), 0),
+			COALESCE(MIN(r.max_tags), MIN(n.max_tags_per_repository), $3),
+			(SELECT COALESCE(SUM(size), 0) FROM manifests WHERE repository_id IN (`+repositoryRows+`)),
+			(SELECT COUNT(DISTINCT name) FROM tags WHERE repository_id IN (`+repositoryRows+`))
+		FROM repositories r
+		JOIN namespaces n ON r.namespace_id = n.id
+		WHERE n.name = $1 AND r.name = $2`,
+		nsName, rName, s.Limits.TagsPerRepository).Scan(&rows, &sizeOverride, &tagsOverride, &l.MaxSizeBytes, &l.MaxTags, &l.SizeBytes, &l.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, ErrRepositoryNotFound
+	}
+	if sizeOverride.Valid {
+		l.SizeOverride = &sizeOverride.Int64
+	}
+	if tagsOverride.Valid {
+		v := int(tagsOverride.Int32)
+		l.TagsOverride = &v
+	}
+	return l, nil
+}
+
+// SetRepositoryLimits sets or, with nil, clears a repository's own size and
+// tag limits. Existing content above a new limit is kept; only further
+// pushes are refused.
+func (s *Service) SetRepositoryLimits(ctx context.Context, repoName string, maxSizeBytes *int64, maxTags *int) error {
+	nsName, rName := splitRepoName(repoName)
+	res, err := s.DB.ExecContext(ctx, `
+		UPDATE repositories r SET max_size_bytes = $3, max_tags = $4
+		FROM namespaces n
+		WHERE r.namespace_id = n.id AND n.name = $1 AND r.name = $2`,
+		nsName, rName, maxSizeBytes, maxTags)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRepositoryNotFound
+	}
+	return nil
+}
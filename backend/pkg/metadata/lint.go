@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/lint"
+)
+
+// StoreLintFindings records the lint result for a manifest.
+func (s *Service) StoreLintFindings(ctx context.Context, manifestID uuid.UUID, findings []lint.Finding) error {
+	if findings == nil {
+		findings = []lint.Finding{}
+	}
+	data, err := json.Marshal(findings)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx,
+		"UPDATE manifests SET lint_findings = $1, last_lint_check = NOW() WHERE id = $2",
+		data, manifestID)
+	return err
+}
+
+// GetLintFindings returns the stored lint findings for a manifest. ok is
+// false when the manifest has not been linted yet.
+func (s *Service) GetLintFindings(ctx context.Context, manifestID uuid.UUID) (findings []lint.Finding, ok bool, err error) {
+	var data []byte
+	var checked sql.NullTime
+	err = s.DB.QueryRowContext(ctx,
+		"SELECT lint_findings, last_lint_check FROM manifests WHERE id = $1",
+		manifestID).Scan(&data, &checked)
+	if err != nil {
+		return nil, false, err
+	}
+	if !checked.Valid {
+		return nil, false, nil
+	}
+	if err := json.Unmarshal(data, &findings); err != nil {
+		return nil, false, err
+	}
+	return findings, true, nil
+}
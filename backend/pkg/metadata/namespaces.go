@@ -0,0 +1,37 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+)
+
+// GetNamespaceEnvironment returns the policy environment configured for a
+// namespace, or "" if it has none (or does not exist yet).
+func (s *Service) GetNamespaceEnvironment(ctx context.Context, nsName string) (string, error) {
+	var env sql.NullString
+	err := s.DB.QueryRowContext(ctx, "SELECT environment FROM namespaces WHERE name = $1", nsName).Scan(&env)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return env.String, nil
+}
+
+// GetRepositoryEnvironment returns the policy environment of the namespace
+// repoName belongs to, or "" if it has none.
+func (s *Service) GetRepositoryEnvironment(ctx context.Context, repoName string) (string, error) {
+	nsName, _ := splitRepoName(repoName)
+	return s.GetNamespaceEnvironment(ctx, nsName)
+}
+
+// SetNamespaceEnvironment sets a namespace's policy environment, creating the
+// namespace if needed. An empty env reverts it to the global default.
+func (s *Service) SetNamespaceEnvironment(ctx context.Context, nsName, env string) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO namespaces (name, environment) VALUES ($1, NULLIF($2, ''))
+		ON CONFLICT (name) DO UPDATE SET environment = EXCLUDED.environment, updated_at = CURRENT_TIMESTAMP`,
+		nsName, env)
+	return err
+}
@@ -0,0 +1,66 @@
+package metadata
+
+import (
+	"context"
+	"path"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/authz"
+)
+
+// A manifest is stored under manifests/<repo>/<digest> and, for each tag it
+// was pushed as, manifests/<repo>/<tag>. Repositories in the library
+// namespace may have been pushed with or without the "library/" prefix.
+
+// ObjectPaths returns the storage paths of a manifest reference (tag or
+// digest) in a repository.
+func ObjectPaths(namespace, repo, reference string) []string {
+	paths := []string{path.Join("manifests", namespace, repo, reference)}
+	if namespace == "library" {
+		paths = append(paths, path.Join("manifests", repo, reference))
+	}
+	return paths
+}
+
+// ManifestObjectPaths returns the storage paths of a manifest and its tags.
+// Call it before deleting the manifest.
+func (s *Service) ManifestObjectPaths(ctx context.Context, id uuid.UUID) ([]string, error) {
+	return s.objectPaths(ctx, `m.id = $1`, id)
+}
+
+// RepositoryObjectPaths returns the storage paths of every manifest and tag
+// in a repository. Call it before deleting the repository.
+func (s *Service) RepositoryObjectPaths(ctx context.Context, repoName string) ([]string, error) {
+	ns, name := authz.SplitRepository(repoName)
+	return s.objectPaths(ctx, `n.name = $1 AND r.name = $2`, ns, name)
+}
+
+func (s *Service) objectPaths(ctx context.Context, where string, args ...interface{}) ([]string, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT n.name, r.name, m.digest AS reference
+		FROM manifests m
+		JOIN repositories r ON r.id = m.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		WHERE `+where+`
+		UNION
+		SELECT n.name, r.name, t.name
+		FROM tags t
+		JOIN manifests m ON m.id = t.manifest_id
+		JOIN repositories r ON r.id = m.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var ns, repo, reference string
+		if err := rows.Scan(&ns, &repo, &reference); err != nil {
+			return nil, err
+		}
+		paths = append(paths, ObjectPaths(ns, repo, reference)...)
+	}
+	return paths, rows.Err()
+}
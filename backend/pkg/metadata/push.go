@@ -0,0 +1,153 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// querier is what the registration steps need, so they can run on the
+// database or inside a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// pushAttempts bounds the retries of a push that lost a race with another
+// transaction.
+const pushAttempts = 5
+
+// Push is the metadata a manifest push records.
+type Push struct {
+	Repository string
+	Reference  string // tag or digest
+	Digest     string
+	Size       int64
+	MediaType  string
+	Owner      uuid.UUID // owner of a new repository; uuid.Nil for none
+
+	// Blobs referenced by the manifest (config and layers), registered if
+	// they aren't yet.
+	Blobs []BlobInfo
+	// Layers are the layer digests in order. Only image manifests have
+	// them; dependency detection runs when there are any.
+	Layers []string
+	// Subject is the digest of the manifest this one is an artifact of
+	// (its "subject" field), with the artifact's type and annotations.
+	// Empty for images.
+	Subject      string
+	ArtifactType string
+	Annotations  map[string]string
+}
+
+// RegisterPush records a pushed manifest in one transaction: its blobs, the
+// repository, the manifest and tag, its layers and its parent images. A
+// push that would exceed a resource limit fails with a *LimitError; the
+// limits are checked in the same transaction, so concurrent pushes can't
+// both slip under one. A failure part-way leaves nothing behind. Deadlocks and serialization
+// failures between concurrent pushes are retried.
+func (s *Service) RegisterPush(ctx context.Context, p Push) (_ uuid.UUID, err error) {
+	ctx, span := tracing.Start(ctx, "metadata.RegisterPush", attribute.String("registry.repository", p.Repository), attribute.String("registry.reference", p.Reference), attribute.String("registry.digest", p.Digest))
+	defer func() { tracing.End(span, err) }()
+	defer s.MarkStatsDirty()
+
+	// The same order in every push keeps concurrent pushes sharing layers
+	// from deadlocking on the blobs table.
+	blobs := append([]BlobInfo(nil), p.Blobs...)
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Digest < blobs[j].Digest })
+
+	var manifestID uuid.UUID
+	for attempt := 1; attempt <= pushAttempts; attempt++ {
+		manifestID, err = s.registerPush(ctx, p, blobs)
+		if err == nil || !retryable(err) || attempt == pushAttempts {
+			break
+		}
+		fmt.Printf("[Metadata] Push of %s:%s conflicted with another transaction (attempt %d): %v\n", p.Repository, p.Reference, attempt, err)
+		select {
+		case <-ctx.Done():
+			return uuid.Nil, ctx.Err()
+		case <-time.After(time.Duration(attempt*attempt) * 20 * time.Millisecond):
+		}
+	}
+	return manifestID, err
+}
+
+func (s *Service) registerPush(ctx context.Context, p Push, blobs []BlobInfo) (uuid.UUID, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockPush(ctx, tx, p); err != nil {
+		return uuid.Nil, err
+	}
+	if err := s.checkPushLimits(ctx, tx, p.Repository, p.Reference, p.Digest, p.Size, p.Owner); err != nil {
+		return uuid.Nil, err
+	}
+
+	for _, b := range blobs {
+		if b.Digest == "" {
+			continue
+		}
+		if err := registerBlob(ctx, tx, b.Digest, b.Size, b.MediaType); err != nil {
+			return uuid.Nil, fmt.Errorf("failed to register blob %s: %w", b.Digest, err)
+		}
+	}
+
+	manifestID, err := registerManifest(ctx, tx, p.Repository, p.Reference, p.Digest, p.Size, p.MediaType, p.Owner)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if len(p.Layers) > 0 {
+		if err := registerManifestLayers(ctx, tx, manifestID, p.Layers); err != nil {
+			return uuid.Nil, fmt.Errorf("failed to register layers: %w", err)
+		}
+		if err := s.detectDependencies(ctx, tx, manifestID); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	if p.Subject != "" {
+		if err := registerSubject(ctx, tx, manifestID, p.Subject, p.ArtifactType, p.Annotations); err != nil {
+			return uuid.Nil, fmt.Errorf("failed to register subject: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
+	return manifestID, nil
+}
+
+// lockPush serializes the pushes that count against the same limits: those
+// by the pushing user, for the repository limit, and those to the same
+// repository. The locks are held until the transaction ends and are taken
+// before any row lock, always in this order.
+func lockPush(ctx context.Context, q querier, p Push) error {
+	if p.Owner != uuid.Nil {
+		if _, err := q.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "push:user:"+p.Owner.String()); err != nil {
+			return err
+		}
+	}
+	nsName, rName := splitRepoName(p.Repository)
+	_, err := q.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "push:repository:"+nsName+"/"+rName)
+	return err
+}
+
+// retryable reports whether err is a serialization failure or deadlock,
+// after which the transaction can simply be run again.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01")
+}
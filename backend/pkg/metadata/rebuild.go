@@ -0,0 +1,122 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/authz"
+)
+
+// maxRecommendationCVEs caps the CVE IDs listed per recommendation; FixedCount
+// always has the full number.
+const maxRecommendationCVEs = 10
+
+// RebuildRecommendation is a tagged image whose base has a newer, scanned
+// release that fixes vulnerabilities the image still carries.
+type RebuildRecommendation struct {
+	ManifestID   string     `json:"manifestId"`
+	Repository   string     `json:"repository"`
+	Digest       string     `json:"digest"`
+	Tags         []string   `json:"tags"`
+	Environment  string     `json:"environment"`
+	PullCount    int        `json:"pullCount"`
+	LastPulledAt *time.Time `json:"lastPulledAt,omitempty"`
+	Owner        string     `json:"owner"`
+	OwnerID      uuid.UUID  `json:"-"`
+
+	BaseRepository string   `json:"baseRepository"`
+	BaseDigest     string   `json:"baseDigest"`    // the base the image was built on
+	PatchedDigest  string   `json:"patchedDigest"` // the newer release to rebuild on
+	PatchedTags    []string `json:"patchedTags"`
+
+	PriorityScore int      `json:"priorityScore"` // highest priority among the fixed CVEs
+	FixedCount    int      `json:"fixedCount"`
+	FixedCVEs     []string `json:"fixedCves"`
+}
+
+// GetRebuildRecommendations lists images that should be rebuilt because their
+// base image has a patched release: a newer tagged, scanned manifest in the
+// base repository that no longer has CVEs the image inherited from its base.
+// Results are sorted by priority score, then pull activity, then environment
+// (production first). Namespaces without an environment use defaultEnv.
+// Non-admins see images they can read whose base is readable or public.
+func (s *Service) GetRebuildRecommendations(ctx context.Context, userID uuid.UUID, role, defaultEnv string) ([]RebuildRecommendation, error) {
+	whereClause := "1=1"
+	args := []interface{}{defaultEnv}
+	if role != "admin" {
+		whereClause = fmt.Sprintf("%s AND (pn.name = ANY($3) OR %s)",
+			authz.RepositoryFilter("$2", authz.RoleRead), authz.RepositoryFilterAs("pr", "$2", authz.RoleRead))
+		args = append(args, userID, pq.Array(s.PublicNamespaces))
+	}
+
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		WITH stale AS (
+			SELECT d.manifest_id AS child_id, d.parent_manifest_id AS parent_id, latest.id AS patched_id
+			FROM image_dependencies d
+			JOIN manifests p ON p.id = d.parent_manifest_id
+			JOIN LATERAL (
+				SELECT nm.id FROM manifests nm
+				WHERE nm.repository_id = p.repository_id AND nm.created_at > p.created_at
+				  AND EXISTS (SELECT 1 FROM tags t WHERE t.manifest_id = nm.id)
+				  AND EXISTS (SELECT 1 FROM vulnerability_reports vr WHERE vr.manifest_id = nm.id AND vr.status = 'completed')
+				ORDER BY nm.created_at DESC LIMIT 1
+			) latest ON true
+			WHERE EXISTS (SELECT 1 FROM tags t WHERE t.manifest_id = d.manifest_id)
+		),
+		fixed AS (
+			SELECT s.child_id, s.parent_id, s.patched_id,
+			       array_agg(DISTINCT cv.cve_id ORDER BY cv.cve_id) AS cves, MAX(COALESCE(cv.priority_score, 0)) AS priority
+			FROM stale s
+			JOIN manifest_vuln_priority cv ON cv.manifest_id = s.child_id
+			WHERE EXISTS (SELECT 1 FROM manifest_vuln_priority pv WHERE pv.manifest_id = s.parent_id AND pv.cve_id = cv.cve_id)
+			  AND NOT EXISTS (SELECT 1 FROM manifest_vuln_priority nv WHERE nv.manifest_id = s.patched_id AND nv.cve_id = cv.cve_id)
+			GROUP BY s.child_id, s.parent_id, s.patched_id
+		)
+		SELECT m.id, n.name || '/' || r.name, m.digest,
+		       ARRAY(SELECT t.name FROM tags t WHERE t.manifest_id = m.id ORDER BY t.name),
+		       COALESCE(n.environment, $1), COALESCE(m.pull_count, 0), m.last_pulled_at,
+		       COALESCE(u.username, n.name), n.owner_id,
+		       pn.name || '/' || pr.name, p.digest, nm.digest,
+		       ARRAY(SELECT t.name FROM tags t WHERE t.manifest_id = nm.id ORDER BY t.name),
+		       f.priority, f.cves
+		FROM fixed f
+		JOIN manifests m ON m.id = f.child_id
+		JOIN repositories r ON m.repository_id = r.id
+		JOIN namespaces n ON r.namespace_id = n.id
+		LEFT JOIN users u ON n.owner_id = u.id
+		JOIN manifests p ON p.id = f.parent_id
+		JOIN repositories pr ON p.repository_id = pr.id
+		JOIN namespaces pn ON pr.namespace_id = pn.id
+		JOIN manifests nm ON nm.id = f.patched_id
+		WHERE %s
+		ORDER BY f.priority DESC, COALESCE(m.pull_count, 0) DESC,
+		         CASE COALESCE(n.environment, $1) WHEN 'prod' THEN 0 WHEN 'production' THEN 0
+		              WHEN 'staging' THEN 1 WHEN 'stage' THEN 1 ELSE 2 END,
+		         2, m.digest`, whereClause), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recs := []RebuildRecommendation{}
+	for rows.Next() {
+		var rec RebuildRecommendation
+		var ownerID uuid.NullUUID
+		if err := rows.Scan(&rec.ManifestID, &rec.Repository, &rec.Digest, pq.Array(&rec.Tags),
+			&rec.Environment, &rec.PullCount, &rec.LastPulledAt, &rec.Owner, &ownerID,
+			&rec.BaseRepository, &rec.BaseDigest, &rec.PatchedDigest, pq.Array(&rec.PatchedTags),
+			&rec.PriorityScore, pq.Array(&rec.FixedCVEs)); err != nil {
+			return nil, err
+		}
+		rec.OwnerID = ownerID.UUID
+		rec.FixedCount = len(rec.FixedCVEs)
+		if len(rec.FixedCVEs) > maxRecommendationCVEs {
+			rec.FixedCVEs = rec.FixedCVEs[:maxRecommendationCVEs]
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
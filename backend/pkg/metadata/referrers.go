@@ -0,0 +1,118 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Referrer is an artifact (signature, SBOM, attestation) pushed with the
+// "subject" field naming another manifest of its repository.
+type Referrer struct {
+	Digest       string            `json:"digest"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	MediaType    string            `json:"mediaType"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+}
+
+// Subject is the manifest an artifact was pushed for. Stored is false while
+// the subject has not been pushed (or has been deleted).
+type Subject struct {
+	Digest string `json:"digest"`
+	Stored bool   `json:"stored"`
+}
+
+// registerSubject records the subject of an artifact manifest, replacing
+// the one recorded by an earlier push of the same manifest.
+func registerSubject(ctx context.Context, q querier, manifestID uuid.UUID, subject, artifactType string, annotations map[string]string) error {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	data, err := json.Marshal(annotations)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO manifest_subjects (manifest_id, subject_digest, artifact_type, annotations)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (manifest_id) DO UPDATE
+		SET subject_digest = EXCLUDED.subject_digest, artifact_type = EXCLUDED.artifact_type,
+			annotations = EXCLUDED.annotations`,
+		manifestID, subject, artifactType, data)
+	return err
+}
+
+// GetSubject returns the subject of an artifact manifest, or nil if the
+// manifest has none.
+func (s *Service) GetSubject(ctx context.Context, manifestID uuid.UUID) (*Subject, error) {
+	var sub Subject
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT ms.subject_digest, EXISTS (
+			SELECT 1 FROM manifests m2
+			WHERE m2.repository_id = m.repository_id AND m2.digest = ms.subject_digest)
+		FROM manifest_subjects ms JOIN manifests m ON m.id = ms.manifest_id
+		WHERE ms.manifest_id = $1`, manifestID).Scan(&sub.Digest, &sub.Stored)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// GetReferrers returns the artifacts pushed for a manifest, newest first.
+func (s *Service) GetReferrers(ctx context.Context, manifestID uuid.UUID) ([]Referrer, error) {
+	return s.queryReferrers(ctx, `
+		SELECT a.digest, ms.artifact_type, a.media_type, a.size, ms.annotations, a.created_at
+		FROM manifests m
+		JOIN manifest_subjects ms ON ms.subject_digest = m.digest
+		JOIN manifests a ON a.id = ms.manifest_id AND a.repository_id = m.repository_id
+		WHERE m.id = $1
+		ORDER BY a.created_at DESC, a.digest`, manifestID)
+}
+
+// ListReferrers returns the artifacts of repoName whose subject is digest,
+// newest first, keeping only those of artifactType unless it is empty. The
+// subject itself need not be stored: artifacts may be pushed before it.
+func (s *Service) ListReferrers(ctx context.Context, repoName, digest, artifactType string) ([]Referrer, error) {
+	nsName, rName := splitRepoName(repoName)
+	return s.queryReferrers(ctx, `
+		SELECT a.digest, ms.artifact_type, a.media_type, a.size, ms.annotations, a.created_at
+		FROM manifest_subjects ms
+		JOIN manifests a ON a.id = ms.manifest_id
+		JOIN repositories r ON r.id = a.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		WHERE n.name = $1 AND r.name = $2 AND ms.subject_digest = $3 AND ($4 = '' OR ms.artifact_type = $4)
+		ORDER BY a.created_at DESC, a.digest`, nsName, rName, digest, artifactType)
+}
+
+func (s *Service) queryReferrers(ctx context.Context, query string, args ...interface{}) ([]Referrer, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referrers := []Referrer{}
+	for rows.Next() {
+		var ref Referrer
+		var annotations []byte
+		if err := rows.Scan(&ref.Digest, &ref.ArtifactType, &ref.MediaType, &ref.Size, &annotations, &ref.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(annotations, &ref.Annotations); err != nil {
+			return nil, err
+		}
+		if len(ref.Annotations) == 0 {
+			ref.Annotations = nil
+		}
+		referrers = append(referrers, ref)
+	}
+	return referrers, rows.Err()
+}
@@ -0,0 +1,210 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// RetentionPolicy prunes a repository: it keeps the KeepLast most recently
+// pushed tags and those matching KeepPattern (a regular expression) and
+// deletes the other tags, and deletes manifests that are untagged and were
+// neither pushed nor pulled for UntaggedDays. Zero or empty disables a rule.
+type RetentionPolicy struct {
+	Repository   string    `json:"repository"`
+	KeepLast     int       `json:"keepLast"`
+	KeepPattern  string    `json:"keepPattern"`
+	UntaggedDays int       `json:"untaggedDays"`
+	Enabled      bool      `json:"enabled"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+
+	LastRunAt            *time.Time `json:"lastRunAt,omitempty"`
+	LastDeletedTags      int        `json:"lastDeletedTags"`
+	LastDeletedManifests int        `json:"lastDeletedManifests"`
+}
+
+// PrunesTags reports whether the policy deletes tags at all.
+func (p *RetentionPolicy) PrunesTags() bool {
+	return p.KeepLast > 0 || p.KeepPattern != ""
+}
+
+const retentionColumns = `n.name || '/' || r.name, p.keep_last, p.keep_pattern, p.untagged_days, p.enabled,
+		p.updated_at, p.last_run_at, p.last_deleted_tags, p.last_deleted_manifests`
+
+func scanRetentionPolicy(row interface{ Scan(...interface{}) error }) (*RetentionPolicy, error) {
+	p := &RetentionPolicy{}
+	err := row.Scan(&p.Repository, &p.KeepLast, &p.KeepPattern, &p.UntaggedDays, &p.Enabled,
+		&p.UpdatedAt, &p.LastRunAt, &p.LastDeletedTags, &p.LastDeletedManifests)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetRetentionPolicy returns a repository's retention policy.
+func (s *Service) GetRetentionPolicy(ctx context.Context, repoName string) (*RetentionPolicy, error) {
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return nil, err
+	}
+	p, err := scanRetentionPolicy(s.DB.QueryRowContext(ctx, `
+		SELECT `+retentionColumns+`
+		FROM retention_policies p
+		JOIN repositories r ON r.id = p.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		WHERE p.repository_id = $1`, repoID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoRetentionPolicy
+	}
+	return p, err
+}
+
+// SetRetentionPolicy creates or replaces a repository's retention policy.
+// It applies from the next enforcement run.
+func (s *Service) SetRetentionPolicy(ctx context.Context, repoName string, p RetentionPolicy) (*RetentionPolicy, error) {
+	if p.KeepLast < 0 || p.UntaggedDays < 0 {
+		return nil, fmt.Errorf("keepLast and untaggedDays must not be negative")
+	}
+	if _, err := regexp.Compile(p.KeepPattern); err != nil {
+		return nil, fmt.Errorf("invalid keepPattern %q: %w", p.KeepPattern, err)
+	}
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return nil, err
+	}
+	return scanRetentionPolicy(s.DB.QueryRowContext(ctx, `
+		WITH p AS (
+			INSERT INTO retention_policies (repository_id, keep_last, keep_pattern, untagged_days, enabled)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (repository_id) DO UPDATE SET
+				keep_last = EXCLUDED.keep_last, keep_pattern = EXCLUDED.keep_pattern,
+				untagged_days = EXCLUDED.untagged_days, enabled = EXCLUDED.enabled, updated_at = NOW()
+			RETURNING *
+		)
+		SELECT `+retentionColumns+`
+		FROM p
+		JOIN repositories r ON r.id = p.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id`, repoID, p.KeepLast, p.KeepPattern, p.UntaggedDays, p.Enabled))
+}
+
+// DeleteRetentionPolicy removes a repository's retention policy.
+func (s *Service) DeleteRetentionPolicy(ctx context.Context, repoName string) error {
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM retention_policies WHERE repository_id = $1`, repoID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNoRetentionPolicy
+	}
+	return nil
+}
+
+// ClaimDueRetentionPolicies returns the enabled policies that last ran at
+// least interval ago, marking them as run now. Several instances may claim
+// at once; each policy goes to one of them.
+func (s *Service) ClaimDueRetentionPolicies(ctx context.Context, interval time.Duration) ([]RetentionPolicy, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		UPDATE retention_policies p SET last_run_at = NOW()
+		FROM repositories r, namespaces n
+		WHERE r.id = p.repository_id AND n.id = r.namespace_id AND p.enabled
+			AND (p.last_run_at IS NULL OR p.last_run_at <= NOW() - $1::float8 * INTERVAL '1 second')
+		RETURNING `+retentionColumns, interval.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []RetentionPolicy
+	for rows.Next() {
+		p, err := scanRetentionPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *p)
+	}
+	return policies, rows.Err()
+}
+
+// RecordRetentionRun stores what enforcing a repository's policy deleted.
+func (s *Service) RecordRetentionRun(ctx context.Context, repoName string, tags, manifests int) error {
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, `
+		UPDATE retention_policies SET last_run_at = NOW(), last_deleted_tags = $2, last_deleted_manifests = $3
+		WHERE repository_id = $1`, repoID, tags, manifests)
+	return err
+}
+
+// DeleteTagAt deletes a tag if it still points to digest, so a tag pushed
+// again since it was chosen for deletion stays. It reports whether the tag
+// was deleted.
+func (s *Service) DeleteTagAt(ctx context.Context, repoName, tag, digest string) (bool, error) {
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.DB.ExecContext(ctx, `
+		DELETE FROM tags t USING manifests m
+		WHERE t.repository_id = $1 AND t.name = $2 AND m.id = t.manifest_id AND m.digest = $3`, repoID, tag, digest)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		s.MarkStatsDirty()
+	}
+	return n > 0, nil
+}
+
+// DeleteStaleUntaggedManifests deletes the manifests of a repository that
+// DeleteUntaggedManifests would delete and that were neither pushed nor
+// pulled since before. It returns their digests and the storage paths they
+// leave behind; with dryRun it only returns the digests.
+func (s *Service) DeleteStaleUntaggedManifests(ctx context.Context, repoName string, before time.Time, dryRun bool) ([]string, []string, error) {
+	repoID, err := s.repositoryID(ctx, repoName)
+	if err != nil {
+		return nil, nil, err
+	}
+	action := `DELETE FROM manifests m USING stale WHERE m.id = stale.id RETURNING m.digest`
+	if dryRun {
+		action = `SELECT m.digest FROM manifests m JOIN stale ON stale.id = m.id`
+	}
+	rows, err := s.DB.QueryContext(ctx, keptManifests+`, stale AS (
+			SELECT id FROM manifests
+			WHERE repository_id = $1 AND GREATEST(last_pulled_at, created_at) < $2
+				AND id NOT IN (SELECT id FROM kept WHERE id IS NOT NULL)
+		)
+		`+action, repoID, before)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	ns, repo := splitRepoName(repoName)
+	var digests, paths []string
+	for rows.Next() {
+		var digest string
+		if err := rows.Scan(&digest); err != nil {
+			return nil, nil, err
+		}
+		digests = append(digests, digest)
+		if !dryRun {
+			paths = append(paths, ObjectPaths(ns, repo, digest)...)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if !dryRun && len(digests) > 0 {
+		s.MarkStatsDirty()
+	}
+	return digests, paths, nil
+}
@@ -3,16 +3,32 @@ package metadata
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 	
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/authz"
 	"github.com/registryx/registryx/backend/pkg/health"
+	"github.com/registryx/registryx/backend/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Service struct {
-	DB *sql.DB
+	DB     *sql.DB
+	Limits ResourceLimits // set by main; zero values mean unlimited
+
+	// PublicNamespaces hold base images every user may see as the parent
+	// of their images in the dependency graph.
+	PublicNamespaces []string
+
+	// GCGracePeriod protects blobs registered more recently than this from
+	// being collected or deleted while the push uploading them finishes.
+	GCGracePeriod time.Duration
+
+	stats statsState // materialized dashboard aggregates, see stats.go
 }
 
 type DependencyNode struct {
@@ -42,6 +58,10 @@ func NewService(db *sql.DB) *Service {
 // repoName is "namespace/repo" or just "repo" (library).
 // userID is the owner of the namespace (for isolation).
 func (s *Service) EnsureRepository(ctx context.Context, repoName string, userID uuid.UUID) (uuid.UUID, error) {
+	return ensureRepository(ctx, s.DB, repoName, userID)
+}
+
+func ensureRepository(ctx context.Context, q querier, repoName string, userID uuid.UUID) (uuid.UUID, error) {
 	parts := strings.SplitN(repoName, "/", 2)
 	nsName := "library"
 	rName := repoName
@@ -52,7 +72,7 @@ func (s *Service) EnsureRepository(ctx context.Context, repoName string, userID
 
 	// 1. Ensure Namespace
 	var nsID uuid.UUID
-	err := s.DB.QueryRowContext(ctx, `
+	err := q.QueryRowContext(ctx, `
 		INSERT INTO namespaces (name) VALUES ($1) 
 		ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
 		RETURNING id`, nsName).Scan(&nsID)
@@ -62,7 +82,7 @@ func (s *Service) EnsureRepository(ctx context.Context, repoName string, userID
 
 	// 2. Ensure Repository with Owner
 	var repoID uuid.UUID
-	err = s.DB.QueryRowContext(ctx, `
+	err = q.QueryRowContext(ctx, `
 		INSERT INTO repositories (namespace_id, name, owner_id) VALUES ($1, $2, $3)
 		ON CONFLICT (namespace_id, name, owner_id) DO UPDATE SET updated_at = CURRENT_TIMESTAMP
 		RETURNING id`, nsID, rName, userID).Scan(&repoID)
@@ -70,19 +90,35 @@ func (s *Service) EnsureRepository(ctx context.Context, repoName string, userID
 		return uuid.Nil, fmt.Errorf("failed to ensure repository: %w", err)
 	}
 
+	// 3. The creator administers the repository
+	if userID != uuid.Nil {
+		_, err = q.ExecContext(ctx, `
+			INSERT INTO repository_permissions (repository_id, principal_type, principal_id, role)
+			VALUES ($1, 'user', $2, 'admin')
+			ON CONFLICT (repository_id, principal_type, principal_id) DO NOTHING`, repoID, userID)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("failed to grant repository owner: %w", err)
+		}
+	}
+
 	return repoID, nil
 }
 
 // RegisterManifest records the manifest and tag in the DB.
 func (s *Service) RegisterManifest(ctx context.Context, repoName, reference, digest string, size int64, mediaType string, userID uuid.UUID) (uuid.UUID, error) {
-	repoID, err := s.EnsureRepository(ctx, repoName, userID)
+	defer s.MarkStatsDirty()
+	return registerManifest(ctx, s.DB, repoName, reference, digest, size, mediaType, userID)
+}
+
+func registerManifest(ctx context.Context, q querier, repoName, reference, digest string, size int64, mediaType string, userID uuid.UUID) (uuid.UUID, error) {
+	repoID, err := ensureRepository(ctx, q, repoName, userID)
 	if err != nil {
 		return uuid.Nil, err
 	}
 
 	// 1. Insert Manifest
 	var manifestID uuid.UUID
-	err = s.DB.QueryRowContext(ctx, `
+	err = q.QueryRowContext(ctx, `
 		INSERT INTO manifests (repository_id, digest, size, media_type)
 		VALUES ($1, $2, $3, $4)
 		ON CONFLICT (repository_id, digest) DO UPDATE SET digest = EXCLUDED.digest
@@ -93,11 +129,18 @@ func (s *Service) RegisterManifest(ctx context.Context, repoName, reference, dig
 
 	// 2. If 'reference' is a tag (not a digest), update the Tag table
 	if !strings.HasPrefix(reference, "sha256:") {
-		_, err = s.DB.ExecContext(ctx, `
-			INSERT INTO tags (repository_id, manifest_id, name)
-			VALUES ($1, $2, $3)
-			ON CONFLICT (repository_id, name) DO UPDATE SET manifest_id = EXCLUDED.manifest_id, updated_at = CURRENT_TIMESTAMP`,
-			repoID, manifestID, reference)
+		// A matching expiry rule restarts the tag's expiry; otherwise an
+		// expiry set on it before stays.
+		expiresAt, err := pushExpiry(ctx, q, repoID, reference)
+		if err != nil {
+			return manifestID, fmt.Errorf("failed to load tag expiry rules: %w", err)
+		}
+		_, err = q.ExecContext(ctx, `
+			INSERT INTO tags (repository_id, manifest_id, name, expires_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (repository_id, name) DO UPDATE SET manifest_id = EXCLUDED.manifest_id, updated_at = CURRENT_TIMESTAMP,
+				expires_at = COALESCE(EXCLUDED.expires_at, tags.expires_at)`,
+			repoID, manifestID, reference, expiresAt)
 		if err != nil {
 			return manifestID, fmt.Errorf("failed to update tag: %w", err)
 		}
@@ -106,13 +149,25 @@ func (s *Service) RegisterManifest(ctx context.Context, repoName, reference, dig
 	return manifestID, nil
 }
 
-// TrackPull updates the pull count and last pulled time for a manifest
-func (s *Service) TrackPull(ctx context.Context, manifestID uuid.UUID) error {
+// TrackPull updates the pull count and last pulled time for a manifest,
+// and counts the pull in today's pulls of the reference's tag (digests
+// count with no tag)
+func (s *Service) TrackPull(ctx context.Context, manifestID uuid.UUID, reference string) error {
+	tag := reference
+	if strings.Contains(reference, ":") {
+		tag = ""
+	}
 	_, err := s.DB.ExecContext(ctx, `
-		UPDATE manifests 
-		SET pull_count = COALESCE(pull_count, 0) + 1, 
-		    last_pulled_at = CURRENT_TIMESTAMP 
-		WHERE id = $1`, manifestID)
+		WITH m AS (
+			UPDATE manifests 
+			SET pull_count = COALESCE(pull_count, 0) + 1, 
+			    last_pulled_at = CURRENT_TIMESTAMP 
+			WHERE id = $1
+			RETURNING repository_id
+		)
+		INSERT INTO repository_pulls (repository_id, tag, day, pulls)
+		SELECT repository_id, $2, CURRENT_DATE, 1 FROM m
+		ON CONFLICT (repository_id, tag, day) DO UPDATE SET pulls = repository_pulls.pulls + 1`, manifestID, tag)
 	return err
 }
 
@@ -132,8 +187,11 @@ func (s *Service) GetManifestID(ctx context.Context, repoName, reference string)
 		SELECT r.id FROM repositories r
 		JOIN namespaces n ON r.namespace_id = n.id
 		WHERE n.name = $1 AND r.name = $2`, nsName, rName).Scan(&repoID)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, ErrRepositoryNotFound
+	}
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("repository not found")
+		return uuid.Nil, err
 	}
 
 	// 2. Get Manifest ID
@@ -151,8 +209,11 @@ func (s *Service) GetManifestID(ctx context.Context, repoName, reference string)
 			repoID, reference).Scan(&manifestID)
 	}
 
+	if err == sql.ErrNoRows {
+		return uuid.Nil, ErrManifestNotFound
+	}
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("manifest not found")
+		return uuid.Nil, err
 	}
 
 	return manifestID, nil
@@ -163,7 +224,7 @@ func (s *Service) GetRepositories(ctx context.Context, userID uuid.UUID, role st
     whereClause := "1=1"
     args := []interface{}{}
     if role != "admin" {
-        whereClause = "r.owner_id = $1"
+        whereClause = authz.RepositoryFilter("$1", authz.RoleRead)
         args = append(args, userID)
     }
 
@@ -190,6 +251,126 @@ func (s *Service) GetRepositories(ctx context.Context, userID uuid.UUID, role st
 	return repos, nil
 }
 
+// RepositorySummary is one row of the repository listing.
+type RepositorySummary struct {
+	Name          string            `json:"name"`
+	TagCount      int               `json:"tagCount"`
+	LatestTag     string            `json:"latestTag,omitempty"`
+	TotalSize     int64             `json:"totalSize"`
+	LastPush      *time.Time        `json:"lastPush,omitempty"`
+	WorstSeverity string            `json:"worstSeverity"` // critical, high, medium, low, none or unscanned
+	Severity      SeverityBreakdown `json:"vulnerabilities"`
+	HealthGrade   string            `json:"healthGrade,omitempty"`
+	HealthScore   *int              `json:"healthScore,omitempty"`
+	MaxSizeBytes  int64             `json:"maxSizeBytes,omitempty"` // limits in effect, omitted when unlimited
+	MaxTags       int               `json:"maxTags,omitempty"`
+}
+
+// ListRepositorySummaries returns every visible repository with tag count,
+// size, last push, vulnerability totals, the health of its newest manifest
+// and its size and tag limits in a single query. Cosign signature tags (*.sig)
+// are not counted as tags.
+func (s *Service) ListRepositorySummaries(ctx context.Context, userID uuid.UUID, role string) ([]RepositorySummary, error) {
+	whereClause := "1=1"
+	args := []interface{}{}
+	if role != "admin" {
+		whereClause = authz.RepositoryFilter("$1", authz.RoleRead)
+		args = append(args, userID)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT n.name || '/' || r.name,
+			COALESCE(t.tag_count, 0),
+			COALESCE(lt.name, ''),
+			COALESCE(m.total_size, 0),
+			GREATEST(m.last_push, t.last_tag),
+			COALESCE(v.critical, 0), COALESCE(v.high, 0), COALESCE(v.medium, 0), COALESCE(v.low, 0),
+			COALESCE(v.scanned, 0),
+			hs.health_grade, hs.health_score,
+			COALESCE(r.max_size_bytes, 0), COALESCE(r.max_tags, n.max_tags_per_repository, %d)
+		FROM repositories r
+		JOIN namespaces n ON r.namespace_id = n.id
+		LEFT JOIN (
+			SELECT repository_id, COUNT(*) FILTER (WHERE name NOT LIKE '%%.sig') AS tag_count, MAX(updated_at) AS last_tag
+			FROM tags GROUP BY repository_id
+		) t ON t.repository_id = r.id
+		LEFT JOIN (
+			SELECT repository_id, SUM(size) AS total_size, MAX(created_at) AS last_push
+			FROM manifests GROUP BY repository_id
+		) m ON m.repository_id = r.id
+		LEFT JOIN (
+			SELECT lm.repository_id,
+				SUM(lr.critical_count) AS critical, SUM(lr.high_count) AS high,
+				SUM(lr.medium_count) AS medium, SUM(lr.low_count) AS low,
+				COUNT(*) AS scanned
+			FROM (
+				SELECT DISTINCT ON (manifest_id) manifest_id, critical_count, high_count, medium_count, low_count
+				FROM vulnerability_reports
+				WHERE status = 'completed'
+				ORDER BY manifest_id, scanned_at DESC
+			) lr
+			JOIN manifests lm ON lm.id = lr.manifest_id
+			GROUP BY lm.repository_id
+		) v ON v.repository_id = r.id
+		LEFT JOIN LATERAL (
+			SELECT name FROM tags WHERE repository_id = r.id AND name NOT LIKE '%%.sig'
+			ORDER BY updated_at DESC LIMIT 1
+		) lt ON true
+		LEFT JOIN LATERAL (
+			SELECT health_grade, health_score FROM manifests
+			WHERE repository_id = r.id
+			ORDER BY created_at DESC LIMIT 1
+		) hs ON true
+		WHERE %s
+		ORDER BY 1`, s.Limits.TagsPerRepository, whereClause)
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	repos := []RepositorySummary{}
+	for rows.Next() {
+		var rs RepositorySummary
+		var lastPush sql.NullTime
+		var scanned int
+		var grade sql.NullString
+		var score sql.NullInt64
+		if err := rows.Scan(&rs.Name, &rs.TagCount, &rs.LatestTag, &rs.TotalSize, &lastPush,
+			&rs.Severity.Critical, &rs.Severity.High, &rs.Severity.Medium, &rs.Severity.Low,
+			&scanned, &grade, &score, &rs.MaxSizeBytes, &rs.MaxTags); err != nil {
+			return nil, err
+		}
+		if lastPush.Valid {
+			rs.LastPush = &lastPush.Time
+		}
+		if grade.Valid {
+			rs.HealthGrade = grade.String
+		}
+		if score.Valid {
+			v := int(score.Int64)
+			rs.HealthScore = &v
+		}
+		switch {
+		case scanned == 0:
+			rs.WorstSeverity = "unscanned"
+		case rs.Severity.Critical > 0:
+			rs.WorstSeverity = "critical"
+		case rs.Severity.High > 0:
+			rs.WorstSeverity = "high"
+		case rs.Severity.Medium > 0:
+			rs.WorstSeverity = "medium"
+		case rs.Severity.Low > 0:
+			rs.WorstSeverity = "low"
+		default:
+			rs.WorstSeverity = "none"
+		}
+		repos = append(repos, rs)
+	}
+	return repos, rows.Err()
+}
+
 // GetDigest retrieves the digest for a manifest UUID.
 func (s *Service) GetDigest(ctx context.Context, manifestID uuid.UUID) (string, error) {
 	var digest string
@@ -224,8 +405,7 @@ func (s *Service) TagExists(ctx context.Context, repoName, tagName string) (bool
 	if err == nil {
 		return true, nil
 	}
-	// Simple error string checking for now. Ideally should use custom error types.
-	if strings.Contains(err.Error(), "not found") {
+	if errors.Is(err, ErrRepositoryNotFound) || errors.Is(err, ErrManifestNotFound) {
 		return false, nil
 	}
 	return false, err
@@ -247,8 +427,11 @@ func (s *Service) GetTags(ctx context.Context, repoName string) ([]string, error
 		SELECT r.id FROM repositories r
 		JOIN namespaces n ON r.namespace_id = n.id
 		WHERE n.name = $1 AND r.name = $2`, nsName, rName).Scan(&repoID)
+	if err == sql.ErrNoRows {
+		return nil, ErrRepositoryNotFound
+	}
 	if err != nil {
-		return nil, fmt.Errorf("repository not found")
+		return nil, err
 	}
 
 	// 2. Get Tags
@@ -272,6 +455,7 @@ func (s *Service) GetTags(ctx context.Context, repoName string) ([]string, error
 
 // DeleteRepository deletes a repository and all associated tags and manifests
 func (s *Service) DeleteRepository(ctx context.Context, repoName string) error {
+	defer s.MarkStatsDirty()
 	// Parse namespace and repo name
 	parts := strings.SplitN(repoName, "/", 2)
 	nsName := "library"
@@ -287,9 +471,12 @@ func (s *Service) DeleteRepository(ctx context.Context, repoName string) error {
 		SELECT r.id FROM repositories r
 		JOIN namespaces n ON r.namespace_id = n.id
 		WHERE n.name = $1 AND r.name = $2`, nsName, rName).Scan(&repoID)
-	if err != nil {
+	if err == sql.ErrNoRows {
 		fmt.Printf("DeleteRepository: Repo not found for %s/%s\n", nsName, rName)
-		return fmt.Errorf("repository not found")
+		return ErrRepositoryNotFound
+	}
+	if err != nil {
+		return err
 	}
 
 	fmt.Printf("DeleteRepository: Found ID %s for %s/%s. Deleting...\n", repoID, nsName, rName)
@@ -311,6 +498,18 @@ func (s *Service) DeleteRepository(ctx context.Context, repoName string) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete repository: %w", err)
 	}
+
+	// Blobs uploaded into it must not carry over to a repository created
+	// later under the same name. Links are kept while another owner's row
+	// still holds the name.
+	_, err = s.DB.ExecContext(ctx, `
+		DELETE FROM blob_links WHERE repository = $1 || '/' || $2
+		AND NOT EXISTS (
+			SELECT 1 FROM repositories r JOIN namespaces n ON r.namespace_id = n.id
+			WHERE n.name = $1 AND r.name = $2)`, nsName, rName)
+	if err != nil {
+		return fmt.Errorf("failed to delete blob links: %w", err)
+	}
 	
 	rows, _ := res.RowsAffected()
 	fmt.Printf("DeleteRepository: Deleted ID %s. Rows affected: %d\n", repoID, rows)
@@ -320,6 +519,7 @@ func (s *Service) DeleteRepository(ctx context.Context, repoName string) error {
 
 // DeleteTag deletes a specific tag from a repository
 func (s *Service) DeleteTag(ctx context.Context, repoName, tagName string) error {
+	defer s.MarkStatsDirty()
 	// Parse namespace and repo name
 	parts := strings.SplitN(repoName, "/", 2)
 	nsName := "library"
@@ -335,8 +535,11 @@ func (s *Service) DeleteTag(ctx context.Context, repoName, tagName string) error
 		SELECT r.id FROM repositories r
 		JOIN namespaces n ON r.namespace_id = n.id
 		WHERE n.name = $1 AND r.name = $2`, nsName, rName).Scan(&repoID)
+	if err == sql.ErrNoRows {
+		return ErrRepositoryNotFound
+	}
 	if err != nil {
-		return fmt.Errorf("repository not found")
+		return err
 	}
 
 	// Delete the tag
@@ -347,7 +550,7 @@ func (s *Service) DeleteTag(ctx context.Context, repoName, tagName string) error
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		return fmt.Errorf("tag not found")
+		return ErrTagNotFound
 	}
 
 	return nil
@@ -355,20 +558,25 @@ func (s *Service) DeleteTag(ctx context.Context, repoName, tagName string) error
 
 // DeleteManifest deletes a manifest by ID
 func (s *Service) DeleteManifest(ctx context.Context, id uuid.UUID) error {
+	defer s.MarkStatsDirty()
 	res, err := s.DB.ExecContext(ctx, "DELETE FROM manifests WHERE id = $1", id)
 	if err != nil {
 		return fmt.Errorf("failed to delete manifest: %w", err)
 	}
 	rows, _ := res.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("manifest not found")
+		return ErrManifestNotFound
 	}
 	return nil
 }
 
 // RegisterBlob records a blob in the DB
 func (s *Service) RegisterBlob(ctx context.Context, digest string, size int64, mediaType string) error {
-    _, err := s.DB.ExecContext(ctx, `
+	return registerBlob(ctx, s.DB, digest, size, mediaType)
+}
+
+func registerBlob(ctx context.Context, q querier, digest string, size int64, mediaType string) error {
+    _, err := q.ExecContext(ctx, `
         INSERT INTO blobs (digest, size, media_type)
         VALUES ($1, $2, $3)
         ON CONFLICT (digest) DO NOTHING`,
@@ -385,6 +593,75 @@ func (s *Service) BlobExists(ctx context.Context, digest string) (bool, error) {
     return exists, err
 }
 
+// BlobInfo is the registered metadata for a stored blob.
+type BlobInfo struct {
+	Digest    string
+	Size      int64
+	MediaType string
+}
+
+// GetBlobInfo returns the registered metadata for a blob, or sql.ErrNoRows if
+// it is not registered.
+func (s *Service) GetBlobInfo(ctx context.Context, digest string) (*BlobInfo, error) {
+	info := &BlobInfo{Digest: digest}
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT size, media_type FROM blobs WHERE digest = $1`,
+		digest).Scan(&info.Size, &info.MediaType)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// BlobInRepository reports whether the blob belongs to repoName: a manifest
+// of the repository references it as a layer or config, or it was uploaded
+// or mounted into the repository (see LinkBlob). Blobs are stored once for
+// every repository, so this is what decides whether a repository's readers
+// may fetch one and its manifests may use one.
+func (s *Service) BlobInRepository(ctx context.Context, repoName, digest string) (bool, error) {
+	nsName, rName := splitRepoName(repoName)
+	var ok bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM blob_links WHERE repository = $1 || '/' || $2 AND digest = $3)
+		OR EXISTS(
+			SELECT 1 FROM manifests m
+			JOIN repositories r ON m.repository_id = r.id
+			JOIN namespaces n ON r.namespace_id = n.id
+			WHERE n.name = $1 AND r.name = $2
+			AND (m.config_digest = $3 OR EXISTS (
+				SELECT 1 FROM manifest_layers ml WHERE ml.manifest_id = m.id AND ml.blob_digest = $3)))`,
+		nsName, rName, digest).Scan(&ok)
+	return ok, err
+}
+
+// LinkBlob records that a stored blob was uploaded or mounted into
+// repoName, so its manifests may reference it before any does. Links are
+// keyed by name because a first push uploads its blobs before the
+// repository exists; DeleteRepository and transfers drop or move them, and
+// DeleteStaleBlobLinks drops those of pushes that never created one.
+func (s *Service) LinkBlob(ctx context.Context, repoName, digest string) error {
+	nsName, rName := splitRepoName(repoName)
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO blob_links (repository, digest) VALUES ($1, $2)
+		ON CONFLICT (repository, digest) DO UPDATE SET created_at = NOW()`, nsName+"/"+rName, digest)
+	return err
+}
+
+// DeleteStaleBlobLinks deletes the links of names no repository holds that
+// are older than GCGracePeriod: the blobs of pushes abandoned before their
+// manifest, which a repository created later under the name must not get.
+func (s *Service) DeleteStaleBlobLinks(ctx context.Context) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, `
+		DELETE FROM blob_links bl
+		WHERE bl.created_at < NOW() - make_interval(secs => $1)
+		AND NOT EXISTS (
+			SELECT 1 FROM repositories r JOIN namespaces n ON r.namespace_id = n.id
+			WHERE n.name || '/' || r.name = bl.repository)`, s.GCGracePeriod.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
 
 type DashboardStats struct {
     Repositories    int
@@ -415,24 +692,32 @@ type OrphanBlob struct {
 	Size   int64
 }
 
-// GetOrphanedBlobs returns a list of blobs that are not referenced by any manifest or manifest_layers.
-// This is used for Garbage Collection.
-func (s *Service) GetOrphanedBlobs(ctx context.Context) ([]OrphanBlob, error) {
-	// Find blobs that are NOT in manifest_layers AND NOT a config digest in manifests
-	query := `
+// DefaultOrphanBatchSize is used when a non-positive batch size is requested.
+const DefaultOrphanBatchSize = 1000
+
+// GetOrphanedBlobsPage returns up to limit blobs that are not referenced by any
+// manifest layer or manifest config, ordered by digest and starting after the
+// given digest (empty for the first page). Blobs within GCGracePeriod of
+// their upload are left out.
+func (s *Service) GetOrphanedBlobsPage(ctx context.Context, after string, limit int) ([]OrphanBlob, error) {
+	if limit <= 0 {
+		limit = DefaultOrphanBatchSize
+	}
+	rows, err := s.DB.QueryContext(ctx, `
 		SELECT b.digest, b.size
 		FROM blobs b
-		LEFT JOIN manifest_layers ml ON b.digest = ml.blob_digest
-		LEFT JOIN manifests m ON (m.config_digest = b.digest)
-		WHERE ml.blob_digest IS NULL AND m.config_digest IS NULL
-	`
-	rows, err := s.DB.QueryContext(ctx, query)
+		WHERE b.digest > $1
+		AND b.created_at < NOW() - make_interval(secs => $3)
+		AND NOT EXISTS (SELECT 1 FROM manifest_layers ml WHERE ml.blob_digest = b.digest)
+		AND NOT EXISTS (SELECT 1 FROM manifests m WHERE m.config_digest = b.digest)
+		ORDER BY b.digest
+		LIMIT $2`, after, limit, s.GCGracePeriod.Seconds())
 	if err != nil {
 		return nil, fmt.Errorf("failed to query orphaned blobs: %w", err)
 	}
 	defer rows.Close()
 
-	var orphans []OrphanBlob
+	orphans := make([]OrphanBlob, 0, limit)
 	for rows.Next() {
 		var o OrphanBlob
 		if err := rows.Scan(&o.Digest, &o.Size); err != nil {
@@ -440,17 +725,72 @@ func (s *Service) GetOrphanedBlobs(ctx context.Context) ([]OrphanBlob, error) {
 		}
 		orphans = append(orphans, o)
 	}
-	return orphans, nil
+	return orphans, rows.Err()
+}
+
+// ForEachOrphanedBlobBatch walks all orphaned blobs in keyset-paginated batches
+// of batchSize, so garbage collection holds at most one batch in memory.
+// Iteration stops at the first error returned by fn.
+func (s *Service) ForEachOrphanedBlobBatch(ctx context.Context, batchSize int, fn func([]OrphanBlob) error) error {
+	after := ""
+	for {
+		batch, err := s.GetOrphanedBlobsPage(ctx, after, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		after = batch[len(batch)-1].Digest
+	}
 }
 
 // DeleteBlob removes a blob from the database.
 func (s *Service) DeleteBlob(ctx context.Context, digest string) error {
+	defer s.MarkStatsDirty()
 	_, err := s.DB.ExecContext(ctx, "DELETE FROM blobs WHERE digest = $1", digest)
 	return err
 }
 
+// DeleteUnreferencedBlob removes a blob from the database unless a manifest
+// layer or config references it; blobs are shared by every repository, so
+// one in use is never deleted, nor one within GCGracePeriod of its upload.
+// Its storage object is left to the caller.
+func (s *Service) DeleteUnreferencedBlob(ctx context.Context, digest string) error {
+	res, err := s.DB.ExecContext(ctx, `
+		DELETE FROM blobs b WHERE b.digest = $1
+		AND b.created_at < NOW() - make_interval(secs => $2)
+		AND NOT EXISTS (SELECT 1 FROM manifest_layers ml WHERE ml.blob_digest = b.digest)
+		AND NOT EXISTS (SELECT 1 FROM manifests m WHERE m.config_digest = b.digest)`, digest, s.GCGracePeriod.Seconds())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		s.MarkStatsDirty()
+		return nil
+	}
+	var recent bool
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT created_at >= NOW() - make_interval(secs => $2) FROM blobs WHERE digest = $1`,
+		digest, s.GCGracePeriod.Seconds()).Scan(&recent)
+	switch {
+	case err == sql.ErrNoRows:
+		return ErrBlobNotFound
+	case err != nil:
+		return err
+	case recent:
+		return ErrBlobRecent
+	}
+	return ErrBlobInUse
+}
+
 // CalculateAndStoreHealthScore calculates the health score for a manifest and stores it
-func (s *Service) CalculateAndStoreHealthScore(ctx context.Context, manifestID uuid.UUID) (*health.HealthScore, error) {
+func (s *Service) CalculateAndStoreHealthScore(ctx context.Context, manifestID uuid.UUID) (_ *health.HealthScore, err error) {
+	ctx, span := tracing.Start(ctx, "metadata.CalculateAndStoreHealthScore", attribute.String("registry.manifest_id", manifestID.String()))
+	defer func() { tracing.End(span, err) }()
 	fmt.Printf("[Health] Calculating score for manifest %s\n", manifestID)
 	// Gather metrics needed for health calculation
 	metrics, err := s.getImageMetrics(ctx, manifestID)
@@ -626,71 +966,21 @@ func (s *Service) GetPreviousHealthScore(ctx context.Context, manifestID uuid.UU
 	return &score, nil
 }
 
-// GetDashboardStats calculates real-time stats, filtered by user
+// GetDashboardStats returns dashboard stats filtered by user. Aggregates are
+// served from the materialized dashboard_stats table; recent pushes are live.
 func (s *Service) GetDashboardStats(ctx context.Context, userID uuid.UUID, role string) (*DashboardStats, error) {
-    stats := &DashboardStats{}
-
     // Isolation Clause
     whereNamespace := "1=1"
     args := []interface{}{}
     
     if role != "admin" {
-        whereNamespace = "r.owner_id = $1"
+        whereNamespace = authz.RepositoryFilter("$1", authz.RoleRead)
         args = append(args, userID)
     }
 
-    // 1. Count Repositories
-    repoQuery := fmt.Sprintf("SELECT COUNT(*) FROM repositories r JOIN namespaces n ON r.namespace_id = n.id WHERE %s", whereNamespace)
-    err := s.DB.QueryRowContext(ctx, repoQuery, args...).Scan(&stats.Repositories)
-    if err != nil { return nil, err }
-
-    // 2. Count Images (Manifests)
-    manifestQuery := fmt.Sprintf("SELECT COUNT(*) FROM manifests JOIN repositories r ON manifests.repository_id = r.id JOIN namespaces n ON r.namespace_id = n.id WHERE %s", whereNamespace)
-    err = s.DB.QueryRowContext(ctx, manifestQuery, args...).Scan(&stats.Images)
-    if err != nil { return nil, err }
-
-    // 3. Sum Vulnerabilities & Severity (Only counting latest report per manifest)
-    // Filter by manifest ownership
-    vulnQuery := fmt.Sprintf(`
-        SELECT 
-            COALESCE(SUM(critical_count + high_count + medium_count + low_count), 0),
-            COALESCE(SUM(critical_count), 0),
-            COALESCE(SUM(high_count), 0),
-            COALESCE(SUM(medium_count), 0),
-            COALESCE(SUM(low_count), 0)
-        FROM (
-            SELECT DISTINCT ON (vr.manifest_id) 
-                vr.critical_count, vr.high_count, vr.medium_count, vr.low_count
-            FROM vulnerability_reports vr
-            JOIN manifests m ON vr.manifest_id = m.id
-            JOIN repositories r ON m.repository_id = r.id
-            JOIN namespaces n ON r.namespace_id = n.id
-            WHERE vr.status = 'completed' AND %s
-            ORDER BY vr.manifest_id, vr.scanned_at DESC
-        ) latest_reports`, whereNamespace)
-
-    err = s.DB.QueryRowContext(ctx, vulnQuery, args...).Scan(
-            &stats.Vulnerabilities,
-            &stats.Severity.Critical,
-            &stats.Severity.High,
-            &stats.Severity.Medium,
-            &stats.Severity.Low,
-        )
-    if err != nil { return nil, err }
-
-    // 4. Sum Storage (Blobs) - Calculate total image size (Layers)
-    // We sum the size of all blobs (layers) associated with the user's manifests.
-    // Note: This counts shared blobs multiple times (once per manifest), which is 
-    // correct for "Usage" perspective (User A uses 50MB, User B uses 50MB).
-    storageQuery := fmt.Sprintf(`
-        SELECT COALESCE(SUM(b.size), 0)
-        FROM manifests m
-        JOIN repositories r ON m.repository_id = r.id
-        JOIN namespaces n ON r.namespace_id = n.id
-        JOIN manifest_layers ml ON m.id = ml.manifest_id
-        JOIN blobs b ON ml.blob_digest = b.digest
-        WHERE %s`, whereNamespace)
-    err = s.DB.QueryRowContext(ctx, storageQuery, args...).Scan(&stats.StorageBytes)
+    // 1-4. Repository/image counts, vulnerabilities and storage come from the
+    // materialized dashboard_stats table (see stats.go).
+    stats, err := s.loadAggregateStats(ctx, userID, role)
     if err != nil { return nil, err }
 
     // 5. Recent Pushes (Last 5 manifests)
@@ -727,15 +1017,20 @@ func (s *Service) GetDashboardStats(ctx context.Context, userID uuid.UUID, role
 
 // RegisterManifestLayers links blobs as layers to a manifest
 func (s *Service) RegisterManifestLayers(ctx context.Context, manifestID uuid.UUID, layers []string) error {
+	defer s.MarkStatsDirty()
+	return registerManifestLayers(ctx, s.DB, manifestID, layers)
+}
+
+func registerManifestLayers(ctx context.Context, q querier, manifestID uuid.UUID, layers []string) error {
 	// 1. Delete existing layers if any (to handle re-upload)
-	_, err := s.DB.ExecContext(ctx, "DELETE FROM manifest_layers WHERE manifest_id = $1", manifestID)
+	_, err := q.ExecContext(ctx, "DELETE FROM manifest_layers WHERE manifest_id = $1", manifestID)
 	if err != nil {
 		return err
 	}
 
 	// 2. Insert new layers
 	for i, digest := range layers {
-		_, err := s.DB.ExecContext(ctx, `
+		_, err := q.ExecContext(ctx, `
 			INSERT INTO manifest_layers (manifest_id, blob_digest, position)
 			VALUES ($1, $2, $3)
 			ON CONFLICT DO NOTHING`, manifestID, digest, i)
@@ -746,21 +1041,72 @@ func (s *Service) RegisterManifestLayers(ctx context.Context, manifestID uuid.UU
 	return nil
 }
 
-// DetectAndStoreDependencies finds the parent manifest based on shared layer prefix
+// DetectAndStoreDependencies finds the parent manifest based on shared layer
+// prefix. Parents are searched across all namespaces; the most specific one
+// wins, preferring the child's own namespace and then public namespaces on
+// ties. When that parent is private to another owner, the closest public
+// parent is stored as well so users who cannot see the first still find
+// their base image.
 func (s *Service) DetectAndStoreDependencies(ctx context.Context, manifestID uuid.UUID) error {
+	return s.detectDependencies(ctx, s.DB, manifestID)
+}
+
+func (s *Service) detectDependencies(ctx context.Context, q querier, manifestID uuid.UUID) error {
 	fmt.Printf("[Dep] Detecting dependencies for manifest %s\n", manifestID)
-	// 1. Find parent using the prefix query
+	parentID, private, err := s.findParent(ctx, q, manifestID, false)
+	if err == sql.ErrNoRows {
+		fmt.Printf("[Dep] No parent found for %s\n", manifestID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to detect parent manifest: %w", err)
+	}
+	parents := []uuid.UUID{parentID}
+
+	if private {
+		publicID, _, err := s.findParent(ctx, q, manifestID, true)
+		if err == nil {
+			parents = append(parents, publicID)
+		} else if err != sql.ErrNoRows {
+			return fmt.Errorf("failed to detect public parent manifest: %w", err)
+		}
+	}
+
+	for _, p := range parents {
+		fmt.Printf("[Dep] Found parent %s for CHILD %s\n", p, manifestID)
+		_, err = q.ExecContext(ctx, `
+        INSERT INTO image_dependencies (manifest_id, parent_manifest_id)
+        VALUES ($1, $2)
+        ON CONFLICT (manifest_id, parent_manifest_id) DO NOTHING`,
+			manifestID, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findParent returns the manifest whose layers are the longest prefix of
+// manifestID's layers, optionally only among public namespaces. private
+// reports whether it is in another, non-public namespace.
+func (s *Service) findParent(ctx context.Context, q querier, manifestID uuid.UUID, publicOnly bool) (parentID uuid.UUID, private bool, err error) {
 	// Potential parent is a manifest that has a subset of this manifest's layers at the exact same positions
-	var parentID uuid.UUID
-	err := s.DB.QueryRowContext(ctx, `
-        SELECT p.id
+	err = q.QueryRowContext(ctx, `
+        WITH child AS (
+            SELECT r.namespace_id FROM manifests m JOIN repositories r ON m.repository_id = r.id WHERE m.id = $1
+        )
+        SELECT p.id,
+            pr.namespace_id != (SELECT namespace_id FROM child) AND NOT pn.name = ANY($2)
         FROM manifests p
+        JOIN repositories pr ON p.repository_id = pr.id
+        JOIN namespaces pn ON pr.namespace_id = pn.id
         JOIN (
             SELECT manifest_id, count(*) as layer_count
             FROM manifest_layers
             GROUP BY manifest_id
         ) p_counts ON p.id = p_counts.manifest_id
         WHERE p.id != $1
+        AND (NOT $3 OR pn.name = ANY($2))
         AND p_counts.layer_count < (SELECT count(*) FROM manifest_layers WHERE manifest_id = $1)
         AND NOT EXISTS (
             -- All layers of parent P must exist in child M1 at the same position
@@ -775,27 +1121,11 @@ func (s *Service) DetectAndStoreDependencies(ctx context.Context, manifestID uui
                 AND cl.position = pl.position
             )
         )
-        ORDER BY p_counts.layer_count DESC
-        LIMIT 1`, manifestID).Scan(&parentID)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			fmt.Printf("[Dep] No parent found for %s\n", manifestID)
-			return nil 
-		}
-		return fmt.Errorf("failed to detect parent manifest: %w", err)
-	}
-
-	fmt.Printf("[Dep] Found parent %s for CHILD %s\n", parentID, manifestID)
-
-	// 2. Store relationship
-	_, err = s.DB.ExecContext(ctx, `
-        INSERT INTO image_dependencies (manifest_id, parent_manifest_id)
-        VALUES ($1, $2)
-        ON CONFLICT (manifest_id, parent_manifest_id) DO NOTHING`,
-		manifestID, parentID)
-
-	return err
+        ORDER BY p_counts.layer_count DESC,
+            pr.namespace_id = (SELECT namespace_id FROM child) DESC,
+            pn.name = ANY($2) DESC
+        LIMIT 1`, manifestID, pq.Array(s.PublicNamespaces), publicOnly).Scan(&parentID, &private)
+	return parentID, private, err
 }
 
 // GetDependencyGraph returns a graph representation of image relationships
@@ -809,12 +1139,13 @@ func (s *Service) GetDependencyGraph(ctx context.Context, repoName string, userI
     whereClause := "1=1"
     args := []interface{}{}
     
-    // User Isolation: Users can only see dependencies where THEY own the Child image.
-    // They can see parents (base images) even if public, as long as it links to their child.
-    // (Or we can restrict entirely, but usually you want to see "My App depends on Alpine")
+    // User Isolation: Users can only see dependencies where they can read the Child image.
+    // Parents must be readable too, or live in a public namespace ("My App depends on Alpine");
+    // edges to other owners' private images are dropped.
     if role != "admin" {
-        whereClause = "r.owner_id = $1"
-        args = append(args, userID)
+        whereClause = fmt.Sprintf("%s AND (pn.name = ANY($2) OR %s)",
+            authz.RepositoryFilter("$1", authz.RoleRead), authz.RepositoryFilterAs("pr", "$1", authz.RoleRead))
+        args = append(args, userID, pq.Array(s.PublicNamespaces))
     }
 
 	// For now, get all dependencies to build a global map
@@ -831,6 +1162,7 @@ func (s *Service) GetDependencyGraph(ctx context.Context, repoName string, userI
         LEFT JOIN tags t ON t.manifest_id = m.id
         JOIN manifests pm ON id.parent_manifest_id = pm.id
         JOIN repositories pr ON pm.repository_id = pr.id
+        JOIN namespaces pn ON pr.namespace_id = pn.id
         LEFT JOIN tags pt ON pt.manifest_id = pm.id
         WHERE %s
     `, whereClause)
@@ -926,22 +1258,66 @@ func (s *Service) CheckQuota(ctx context.Context, nsName string, newBytes int64)
 	}
 	
 	if (usage + newBytes) > quota {
-		return fmt.Errorf("storage quota exceeded: used %d/%d bytes", usage, quota)
+		return fmt.Errorf("%w: used %d/%d bytes", ErrQuotaExceeded, usage, quota)
 	}
 	return nil
 }
 
-// DeleteUntaggedManifests deletes manifests that have no tags pointing to them.
-func (s *Service) DeleteUntaggedManifests(ctx context.Context) (int64, error) {
-	// Delete manifests that are NOT tagged and NOT used as a parent by another image
-	query := `
-		DELETE FROM manifests 
-		WHERE id NOT IN (SELECT manifest_id FROM tags)
-		AND id NOT IN (SELECT parent_manifest_id FROM image_dependencies)
+// keptManifests starts a query with the CTE "kept": the IDs of manifests a
+// tag points to, directly or through the artifacts and subjects they are
+// linked with, and of base images other images are built on.
+const keptManifests = `
+		WITH RECURSIVE edges AS (
+			SELECT ms.manifest_id AS artifact, m.id AS subject
+			FROM manifest_subjects ms
+			JOIN manifests a ON a.id = ms.manifest_id
+			JOIN manifests m ON m.repository_id = a.repository_id AND m.digest = ms.subject_digest
+		), links AS (
+			SELECT artifact AS a, subject AS b FROM edges
+			UNION ALL
+			SELECT subject, artifact FROM edges
+		), kept(id) AS (
+			SELECT manifest_id FROM tags
+			UNION
+			SELECT parent_manifest_id FROM image_dependencies
+			UNION
+			SELECT l.b FROM kept k JOIN links l ON l.a = k.id
+		)`
+
+// DeleteUntaggedManifests deletes manifests that have no tags pointing to them
+// and returns how many were deleted and the storage paths they leave behind.
+// Untagged artifacts of a kept manifest, and untagged subjects of a kept
+// artifact, are not deleted.
+func (s *Service) DeleteUntaggedManifests(ctx context.Context) (int64, []string, error) {
+	defer s.MarkStatsDirty()
+	// Delete manifests that are NOT tagged and NOT used as a parent by another
+	// image. Artifacts and their subjects go together: the subject of a kept
+	// artifact is kept, and so are the artifacts of a kept manifest.
+	query := keptManifests + `, deleted AS (
+			DELETE FROM manifests 
+			WHERE id NOT IN (SELECT id FROM kept WHERE id IS NOT NULL)
+			RETURNING repository_id, digest
+		)
+		SELECT n.name, r.name, d.digest
+		FROM deleted d
+		JOIN repositories r ON r.id = d.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
 	`
-	res, err := s.DB.ExecContext(ctx, query)
+	rows, err := s.DB.QueryContext(ctx, query)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
-	return res.RowsAffected()
+	defer rows.Close()
+
+	var count int64
+	var paths []string
+	for rows.Next() {
+		var ns, repo, digest string
+		if err := rows.Scan(&ns, &repo, &digest); err != nil {
+			return count, paths, err
+		}
+		count++
+		paths = append(paths, ObjectPaths(ns, repo, digest)...)
+	}
+	return count, paths, rows.Err()
 }
@@ -0,0 +1,241 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// How long a served DashboardStats may be reused before dashboard_stats is read again.
+const statsCacheTTL = 10 * time.Second
+
+// StatsChannel is the Redis pub/sub channel MarkStatsDirty is shared on, so
+// writes on one instance refresh the aggregates and caches of every one.
+const StatsChannel = "registryx:stats-dirty"
+
+// statsState tracks whether the materialized dashboard_stats table is stale
+// and caches the aggregates served from it.
+type statsState struct {
+	mu          sync.Mutex
+	dirty       bool
+	refreshedAt time.Time
+	cache       map[string]cachedStats
+	rdb         *redis.Client // shares the dirty flag; nil keeps it local
+}
+
+type cachedStats struct {
+	stats     DashboardStats
+	expiresAt time.Time
+}
+
+// MarkStatsDirty flags the dashboard aggregates for recomputation on the next
+// refresh tick and drops cached responses, on every instance once
+// ShareStatsDirty was called. Called after pushes, deletes and scans.
+func (s *Service) MarkStatsDirty() {
+	if !s.markStatsDirty() || s.stats.rdb == nil {
+		return
+	}
+	if err := s.stats.rdb.Publish(context.Background(), StatsChannel, "dirty").Err(); err != nil {
+		fmt.Printf("[Stats] Failed to share dirty flag: %v\n", err)
+	}
+}
+
+// markStatsDirty flags this instance's aggregates and reports whether they
+// were clean, i.e. other instances haven't been told yet.
+func (s *Service) markStatsDirty() bool {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	wasClean := !s.stats.dirty
+	s.stats.dirty = true
+	s.stats.cache = nil
+	return wasClean
+}
+
+// ShareStatsDirty shares MarkStatsDirty between instances through Redis
+// pub/sub. Call it once at startup, before serving requests.
+func (s *Service) ShareStatsDirty(rdb *redis.Client) {
+	s.stats.rdb = rdb
+	go s.relayStatsDirty()
+}
+
+// relayStatsDirty marks the aggregates dirty when another instance did.
+func (s *Service) relayStatsDirty() {
+	for {
+		sub := s.stats.rdb.Subscribe(context.Background(), StatsChannel)
+		for range sub.Channel() {
+			s.markStatsDirty()
+		}
+		sub.Close()
+		fmt.Println("[Stats] Redis subscription closed, reconnecting in 5s...")
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// StartStatsRefresher recomputes dashboard_stats every interval when something
+// changed, and at least every ten intervals regardless so other instances'
+// writes are picked up. It blocks, so run it in a goroutine.
+func (s *Service) StartStatsRefresher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.stats.mu.Lock()
+		due := s.stats.dirty || time.Since(s.stats.refreshedAt) >= 10*interval
+		s.stats.mu.Unlock()
+		if !due {
+			continue
+		}
+		if err := s.RefreshDashboardStats(ctx); err != nil {
+			fmt.Printf("[Stats] Refresh failed: %v\n", err)
+		}
+	}
+}
+
+// RefreshDashboardStats rebuilds the per-owner aggregates in a single pass.
+func (s *Service) RefreshDashboardStats(ctx context.Context) error {
+	s.stats.mu.Lock()
+	s.stats.dirty = false
+	s.stats.mu.Unlock()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.markStatsDirty()
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM dashboard_stats"); err != nil {
+		s.markStatsDirty()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        WITH repo_counts AS (
+            SELECT COALESCE(r.owner_id, $1) AS owner_id, COUNT(*) AS repositories
+            FROM repositories r
+            GROUP BY 1
+        ), image_counts AS (
+            SELECT COALESCE(r.owner_id, $1) AS owner_id, COUNT(*) AS images
+            FROM manifests m
+            JOIN repositories r ON m.repository_id = r.id
+            GROUP BY 1
+        ), vuln_counts AS (
+            SELECT owner_id,
+                SUM(critical_count) AS critical, SUM(high_count) AS high,
+                SUM(medium_count) AS medium, SUM(low_count) AS low
+            FROM (
+                SELECT DISTINCT ON (vr.manifest_id)
+                    COALESCE(r.owner_id, $1) AS owner_id,
+                    vr.critical_count, vr.high_count, vr.medium_count, vr.low_count
+                FROM vulnerability_reports vr
+                JOIN manifests m ON vr.manifest_id = m.id
+                JOIN repositories r ON m.repository_id = r.id
+                WHERE vr.status = 'completed'
+                ORDER BY vr.manifest_id, vr.scanned_at DESC
+            ) latest_reports
+            GROUP BY owner_id
+        ), storage AS (
+            SELECT COALESCE(r.owner_id, $1) AS owner_id, SUM(b.size) AS storage_bytes
+            FROM manifests m
+            JOIN repositories r ON m.repository_id = r.id
+            JOIN manifest_layers ml ON m.id = ml.manifest_id
+            JOIN blobs b ON ml.blob_digest = b.digest
+            GROUP BY 1
+        )
+        INSERT INTO dashboard_stats
+            (owner_id, repositories, images, critical_count, high_count, medium_count, low_count, storage_bytes, refreshed_at)
+        SELECT rc.owner_id, rc.repositories, COALESCE(ic.images, 0),
+            COALESCE(vc.critical, 0), COALESCE(vc.high, 0), COALESCE(vc.medium, 0), COALESCE(vc.low, 0),
+            COALESCE(st.storage_bytes, 0), CURRENT_TIMESTAMP
+        FROM repo_counts rc
+        LEFT JOIN image_counts ic ON ic.owner_id = rc.owner_id
+        LEFT JOIN vuln_counts vc ON vc.owner_id = rc.owner_id
+        LEFT JOIN storage st ON st.owner_id = rc.owner_id`, uuid.Nil)
+	if err != nil {
+		s.markStatsDirty()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.markStatsDirty()
+		return err
+	}
+
+	s.stats.mu.Lock()
+	s.stats.refreshedAt = time.Now()
+	s.stats.cache = nil
+	s.stats.mu.Unlock()
+	return nil
+}
+
+// loadAggregateStats reads the materialized aggregates for a user (or all
+// owners for admins).
+func (s *Service) loadAggregateStats(ctx context.Context, userID uuid.UUID, role string) (*DashboardStats, error) {
+	key := userID.String()
+	if role == "admin" {
+		key = "admin"
+	}
+
+	// Refresh inline if the table was never built, or if it is stale and the
+	// background refresher has not caught up (or is disabled).
+	s.stats.mu.Lock()
+	needsRefresh := s.stats.refreshedAt.IsZero() ||
+		(s.stats.dirty && time.Since(s.stats.refreshedAt) > statsCacheTTL)
+	if c, ok := s.stats.cache[key]; ok && time.Now().Before(c.expiresAt) && !needsRefresh {
+		s.stats.mu.Unlock()
+		stats := c.stats
+		return &stats, nil
+	}
+	s.stats.mu.Unlock()
+
+	if needsRefresh {
+		if err := s.RefreshDashboardStats(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+        SELECT COALESCE(SUM(repositories), 0), COALESCE(SUM(images), 0),
+            COALESCE(SUM(critical_count), 0), COALESCE(SUM(high_count), 0),
+            COALESCE(SUM(medium_count), 0), COALESCE(SUM(low_count), 0),
+            COALESCE(SUM(storage_bytes), 0)
+        FROM dashboard_stats`
+	args := []interface{}{}
+	if role != "admin" {
+		query += " WHERE owner_id = $1"
+		args = append(args, userID)
+	}
+
+	stats := DashboardStats{}
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(
+		&stats.Repositories,
+		&stats.Images,
+		&stats.Severity.Critical,
+		&stats.Severity.High,
+		&stats.Severity.Medium,
+		&stats.Severity.Low,
+		&stats.StorageBytes,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stats.Vulnerabilities = stats.Severity.Critical + stats.Severity.High + stats.Severity.Medium + stats.Severity.Low
+
+	s.stats.mu.Lock()
+	if s.stats.cache == nil {
+		s.stats.cache = make(map[string]cachedStats)
+	}
+	s.stats.cache[key] = cachedStats{stats: stats, expiresAt: time.Now().Add(statsCacheTTL)}
+	s.stats.mu.Unlock()
+
+	return &stats, nil
+}
@@ -2,14 +2,16 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
+	"github.com/registryx/registryx/backend/pkg/errcode"
 )
 
 // ContextKey is a custom type for context keys to avoid collisions
@@ -23,25 +25,37 @@ const (
 	SessionIDKey ContextKey = "session_id"
 )
 
-// AuthMiddleware handles Docker Registry authentication challenges.
-func AuthMiddleware(jwtSecret string, rdb *redis.Client) func(http.Handler) http.Handler {
+// RegistryTokenIssuer is the "iss" of tokens issued by /auth/token. Their jti
+// is checked against the revocation blocklist rather than the session store.
+const RegistryTokenIssuer = "registryx-auth"
+
+// ScopedClaim marks registry tokens of service accounts limited to some
+// repositories. They are only accepted by the registry API, where their
+// access claim applies.
+const ScopedClaim = "scoped"
+
+// ScannerSubject is the subject of the short-lived registry tokens the
+// embedded scanner pulls images with.
+const ScannerSubject = "internal:scanner"
+
+// RevokedTokenPrefix keys the blocklist entry of a revoked registry token.
+const RevokedTokenPrefix = "revoked-token:"
+
+// InvalidTokenFunc is told about requests whose bearer token is forged,
+// malformed or revoked (not merely expired).
+type InvalidTokenFunc func(r *http.Request, reason string)
+
+// AuthMiddleware handles Docker Registry authentication challenges. keyFunc
+// resolves the key a token was signed with (see signing.Keyring). Active
+// dashboard sessions are kept alive in Redis for sessionTTL after each request.
+// onInvalid may be nil.
+func AuthMiddleware(keyFunc jwt.Keyfunc, rdb *redis.Client, sessionTTL time.Duration, onInvalid InvalidTokenFunc) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Debug Log
-		log.Printf("[AuthMiddleware] Intercepting: %s\n", r.URL.Path)
-
 		// 1. Skip auth for /v2/ base check if we want to allow anonymous discovery,
 		// but typically we want to challenge everything except the auth endpoint itself.
 		// The /auth/token endpoint is NOT wrapped by this middleware in main.go.
 
-		// Bypass for internal scanner (localhost)
-		// RemoteAddr examples: "127.0.0.1:12345", "[::1]:12345"
-		if strings.HasPrefix(r.RemoteAddr, "127.0.0.1:") || strings.HasPrefix(r.RemoteAddr, "[::1]:") {
-			fmt.Printf("[AuthMiddleware] Allowing internal request from %s\n", r.RemoteAddr)
-			next.ServeHTTP(w, r)
-			return
-		}
-
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
 			sendChallenge(w, r)
@@ -51,25 +65,52 @@ func AuthMiddleware(jwtSecret string, rdb *redis.Client) func(http.Handler) http
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		
 		// 2. Parse and Validate Token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate algo
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			// Use the provided secret
-			return []byte(jwtSecret), nil
-		})
+		token, err := jwt.Parse(tokenString, keyFunc)
 
 		if err != nil || !token.Valid {
 			fmt.Printf("Invalid token: %v\n", err)
+			if onInvalid != nil && !errors.Is(err, jwt.ErrTokenExpired) {
+				reason := "invalid token"
+				if err != nil {
+					reason = err.Error()
+				}
+				onInvalid(r, reason)
+			}
 			sendChallenge(w, r)
 			return
 		}
 
 		// 3. Extract Claims
 		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			registryToken := claims["iss"] == RegistryTokenIssuer
+
+			// --- Registry Token Revocation ---
+			if rdb != nil && registryToken {
+				if jti, _ := claims["jti"].(string); jti != "" {
+					revoked, err := rdb.Exists(r.Context(), RevokedTokenPrefix+jti).Result()
+					if err != nil {
+						// Don't take pulls down with Redis; the token still expires.
+						fmt.Printf("[Auth] Revocation check failed for token %s: %v\n", jti, err)
+					} else if revoked > 0 {
+						fmt.Printf("[Auth] Registry token %s has been revoked\n", jti)
+						if onInvalid != nil {
+							onInvalid(r, "revoked registry token")
+						}
+						sendChallenge(w, r)
+						return
+					}
+				}
+			}
+
+			// --- Scoped Service Accounts ---
+			if registryToken && claims[ScopedClaim] == true && !strings.HasPrefix(r.URL.Path, "/v2/") {
+				fmt.Printf("[Auth] Scoped registry token of %v refused outside the registry API: %s\n", claims["sub"], r.URL.Path)
+				errcode.ServeJSON(w, errcode.Denied.WithMessage("token of a scoped service account is limited to the registry API"))
+				return
+			}
+
 			// --- Session Verification ---
-			if rdb != nil {
+			if rdb != nil && !registryToken {
 				// We expect a 'jti' (JWT ID) in the claims for session tracking
 				sid, _ := claims["jti"].(string)
 				
@@ -84,7 +125,7 @@ func AuthMiddleware(jwtSecret string, rdb *redis.Client) func(http.Handler) http
 						return
 					}
 					// Update last active
-					rdb.Expire(r.Context(), "session:"+sid, 24*time.Hour)
+					rdb.Expire(r.Context(), "session:"+sid, sessionTTL)
 				}
 			}
 
@@ -93,9 +134,12 @@ func AuthMiddleware(jwtSecret string, rdb *redis.Client) func(http.Handler) http
 			ctx = context.WithValue(ctx, UsernameKey, claims["username"])
 			ctx = context.WithValue(ctx, RoleKey, claims["role"])
 			
-			if sid, ok := claims["jti"].(string); ok {
+			if sid, ok := claims["jti"].(string); ok && !registryToken {
 				ctx = context.WithValue(ctx, SessionIDKey, sid)
 			}
+			if registryToken {
+				ctx = context.WithValue(ctx, AccessKey, accessClaims(claims))
+			}
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		} else {
@@ -105,6 +149,47 @@ func AuthMiddleware(jwtSecret string, rdb *redis.Client) func(http.Handler) http
 	}
 }
 
+// Access is a grant in the "access" claim of a registry token.
+type Access struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// accessClaims returns the grants of a registry token. Malformed claims
+// grant nothing.
+func accessClaims(claims jwt.MapClaims) []Access {
+	grants := []Access{}
+	raw, err := json.Marshal(claims["access"])
+	if err == nil {
+		if err := json.Unmarshal(raw, &grants); err != nil || grants == nil {
+			grants = []Access{}
+		}
+	}
+	return grants
+}
+
+// Challenge asks the client for a token with scope (e.g.
+// "repository:my-app:pull"), as when the token it sent does not grant it.
+func Challenge(w http.ResponseWriter, scope string) {
+	w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="http://localhost:5000/auth/token",service="registryx",scope="%s",error="insufficient_scope"`, scope))
+	w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+	errcode.ServeJSON(w, errcode.Unauthorized.WithMessage("token does not grant "+scope))
+}
+
+// QueryToken lets clients that cannot set headers (e.g. browser EventSource)
+// pass their bearer token as ?access_token=. Only wrap routes that need it.
+func QueryToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			if token := r.URL.Query().Get("access_token"); token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // sendChallenge returns the 401 header that tells Docker where to get a token.
 func sendChallenge(w http.ResponseWriter, r *http.Request) {
 	// Construct the realm URL (assuming localhost:5000 for now)
@@ -141,6 +226,5 @@ func sendChallenge(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Www-Authenticate", authHeader)
 	w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
-	w.WriteHeader(http.StatusUnauthorized)
-	w.Write([]byte(`{"errors": [{"code": "UNAUTHORIZED", "message": "authentication required"}]}`))
+	errcode.ServeJSON(w, errcode.Unauthorized)
 }
@@ -0,0 +1,324 @@
+package policy
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// ErrNotFound is returned for namespaces, or namespace fragments, that do
+// not exist.
+var ErrNotFound = errors.New("not found")
+
+var (
+	pathPattern        = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*$`)
+	environmentPattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+	packageLine        = regexp.MustCompile(`(?m)^\s*package\s`)
+)
+
+// maxCompiledFragments bounds the cache of compiled fragment sets.
+const maxCompiledFragments = 256
+
+// Rules are the common checks a fragment can require without writing Rego.
+// They apply in the listed environments, or in all of them if none is.
+type Rules struct {
+	RequireSignature bool     `json:"requireSignature,omitempty"`
+	MaxCritical      *int     `json:"maxCritical,omitempty"`
+	MaxHigh          *int     `json:"maxHigh,omitempty"`
+	MinHealthScore   *int     `json:"minHealthScore,omitempty"` // images not scored yet pass
+	Environments     []string `json:"environments,omitempty"`
+}
+
+// Fragment is a namespace's default policy: it applies to the repositories
+// under Namespace/Path, on top of the global policy. Fragments only add
+// violations, so a namespace can tighten the global policy but never relax
+// it. Rego holds extra rules defining violations[msg], without a package line.
+type Fragment struct {
+	Namespace string `json:"namespace"`
+	Path      string `json:"path"` // "" for the whole namespace
+	Rules
+	Rego      string    `json:"rego,omitempty"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Scope is the repository prefix the fragment applies to.
+func (f *Fragment) Scope() string {
+	if f.Path == "" {
+		return f.Namespace + "/"
+	}
+	return f.Namespace + "/" + f.Path + "/"
+}
+
+// appliesTo reports whether the fragment covers repository.
+func (f *Fragment) appliesTo(repository string) bool {
+	return strings.HasPrefix(repository+"/", f.Scope())
+}
+
+// Validate checks the fragment's path and rules and that its Rego compiles.
+func (f *Fragment) Validate() error {
+	if f.Path != "" && !pathPattern.MatchString(f.Path) {
+		return fmt.Errorf("invalid path %q", f.Path)
+	}
+	for _, n := range []*int{f.MaxCritical, f.MaxHigh} {
+		if n != nil && *n < 0 {
+			return errors.New("vulnerability limits cannot be negative")
+		}
+	}
+	if f.MinHealthScore != nil && (*f.MinHealthScore < 0 || *f.MinHealthScore > 100) {
+		return errors.New("minHealthScore must be between 0 and 100")
+	}
+	for _, env := range f.Environments {
+		if !environmentPattern.MatchString(env) {
+			return fmt.Errorf("invalid environment %q", env)
+		}
+	}
+	if packageLine.MatchString(f.Rego) {
+		return errors.New("omit the package line from fragment Rego; it is added for you")
+	}
+	if !f.RequireSignature && f.MaxCritical == nil && f.MaxHigh == nil && f.MinHealthScore == nil && strings.TrimSpace(f.Rego) == "" {
+		return errors.New("fragment has no rules")
+	}
+	if _, err := prepareFragments([]Fragment{*f}); err != nil {
+		return fmt.Errorf("invalid policy syntax: %w", err)
+	}
+	return nil
+}
+
+// module returns the Rego the fragment compiles to, in package pkg.
+func (f *Fragment) module(pkg string) string {
+	scope := strconv.Quote(f.Scope())
+	envs, _ := json.Marshal(f.Environments)
+	if f.Environments == nil {
+		envs = []byte("[]")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "fragment_environments := %s\n\n", envs)
+	b.WriteString("fragment_applies { count(fragment_environments) == 0 }\n")
+	b.WriteString("fragment_applies { fragment_environments[_] == input.environment }\n")
+	if f.RequireSignature {
+		fmt.Fprintf(&b, "\nviolations[msg] {\n\tfragment_applies\n\tinput.is_signed == false\n\tmsg := sprintf(\"Image is not signed; %%s requires signatures.\", [%s])\n}\n", scope)
+	}
+	if f.MaxCritical != nil {
+		fmt.Fprintf(&b, "\nviolations[msg] {\n\tfragment_applies\n\tinput.vulnerabilities.critical > %d\n\tmsg := sprintf(\"Image has %%d critical vulnerabilities; %%s allows at most %%d.\", [input.vulnerabilities.critical, %s, %d])\n}\n", *f.MaxCritical, scope, *f.MaxCritical)
+	}
+	if f.MaxHigh != nil {
+		fmt.Fprintf(&b, "\nviolations[msg] {\n\tfragment_applies\n\tinput.vulnerabilities.high > %d\n\tmsg := sprintf(\"Image has %%d high vulnerabilities; %%s allows at most %%d.\", [input.vulnerabilities.high, %s, %d])\n}\n", *f.MaxHigh, scope, *f.MaxHigh)
+	}
+	if f.MinHealthScore != nil {
+		fmt.Fprintf(&b, "\nviolations[msg] {\n\tfragment_applies\n\tinput.health_grade != \"\"\n\tinput.health_score < %d\n\tmsg := sprintf(\"Image health score is %%d; %%s requires at least %%d.\", [input.health_score, %s, %d])\n}\n", *f.MinHealthScore, scope, *f.MinHealthScore)
+	}
+	if rules := strings.TrimSpace(f.Rego); rules != "" {
+		b.WriteString("\n" + rules + "\n")
+	}
+	return b.String()
+}
+
+// fragmentModules returns the modules of fragments, each in its own package.
+func fragmentModules(frags []Fragment) []string {
+	modules := make([]string, len(frags))
+	for i := range frags {
+		modules[i] = frags[i].module(fmt.Sprintf("registryx.namespaces.f%d", i))
+	}
+	return modules
+}
+
+// prepareFragments compiles the query for the violations of frags.
+func prepareFragments(frags []Fragment) (rego.PreparedEvalQuery, error) {
+	opts := []func(*rego.Rego){rego.Query("data.registryx.namespaces[_].violations[msg]")}
+	for i, m := range fragmentModules(frags) {
+		opts = append(opts, rego.Module(fmt.Sprintf("fragment%d.rego", i), m))
+	}
+	return rego.New(opts...).PrepareForEval(context.Background())
+}
+
+// Fragments returns a namespace's fragments, the whole namespace's first.
+func (s *Service) Fragments(ctx context.Context, namespace string) ([]Fragment, error) {
+	frags := []Fragment{}
+	if s.DB == nil {
+		return frags, nil
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT p.path, p.rules, p.rego, COALESCE(p.updated_by::text, ''), p.updated_at
+		FROM namespace_policies p
+		JOIN namespaces n ON n.id = p.namespace_id
+		WHERE n.name = $1
+		ORDER BY p.path`, namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		f := Fragment{Namespace: namespace}
+		var rules []byte
+		if err := rows.Scan(&f.Path, &rules, &f.Rego, &f.UpdatedBy, &f.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(rules, &f.Rules); err != nil {
+			return nil, fmt.Errorf("namespace policy %s: %w", f.Scope(), err)
+		}
+		frags = append(frags, f)
+	}
+	return frags, rows.Err()
+}
+
+// applicable returns the fragments covering repository, least specific first.
+func (s *Service) applicable(ctx context.Context, repository string) ([]Fragment, error) {
+	namespace, _, ok := strings.Cut(repository, "/")
+	if !ok {
+		namespace, repository = "library", "library/"+repository
+	}
+	all, err := s.Fragments(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var frags []Fragment
+	for _, f := range all {
+		if f.appliesTo(repository) {
+			frags = append(frags, f)
+		}
+	}
+	sort.SliceStable(frags, func(i, j int) bool { return len(frags[i].Path) < len(frags[j].Path) })
+	return frags, nil
+}
+
+// SetFragment creates or replaces the fragment of f.Namespace at f.Path.
+func (s *Service) SetFragment(ctx context.Context, f *Fragment, userID string) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	rules, err := json.Marshal(f.Rules)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `
+		INSERT INTO namespace_policies (namespace_id, path, rules, rego, updated_by, updated_at)
+		SELECT id, $2, $3, $4, NULLIF($5, '')::uuid, NOW() FROM namespaces WHERE name = $1
+		ON CONFLICT (namespace_id, path) DO UPDATE
+		SET rules = EXCLUDED.rules, rego = EXCLUDED.rego, updated_by = EXCLUDED.updated_by, updated_at = NOW()`,
+		f.Namespace, f.Path, rules, strings.TrimSpace(f.Rego), userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteFragment removes the fragment of namespace at path.
+func (s *Service) DeleteFragment(ctx context.Context, namespace, path string) error {
+	res, err := s.DB.ExecContext(ctx, `
+		DELETE FROM namespace_policies p USING namespaces n
+		WHERE n.id = p.namespace_id AND n.name = $1 AND p.path = $2`, namespace, path)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// IsOwner reports whether userID owns the namespace.
+func (s *Service) IsOwner(ctx context.Context, namespace, userID string) (bool, error) {
+	var owner sql.NullString
+	err := s.DB.QueryRowContext(ctx, `SELECT owner_id::text FROM namespaces WHERE name = $1`, namespace).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return false, ErrNotFound
+	}
+	return owner.Valid && owner.String == userID, err
+}
+
+// fragmentViolations evaluates the fragments covering input.Repository.
+func (s *Service) fragmentViolations(ctx context.Context, input EvaluationInput) ([]string, error) {
+	frags, err := s.applicable(ctx, input.Repository)
+	if err != nil || len(frags) == 0 {
+		return nil, err
+	}
+	query, err := s.fragmentQuery(frags)
+	if err != nil {
+		return nil, err
+	}
+	results, err := query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, fmt.Errorf("failed to eval rego: %w", err)
+	}
+	seen := make(map[string]bool)
+	var msgs []string
+	for _, r := range results {
+		msg := fmt.Sprint(r.Bindings["msg"])
+		if !seen[msg] {
+			seen[msg] = true
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Strings(msgs)
+	return msgs, nil
+}
+
+// fragmentQuery returns the compiled query for frags, compiling it on the
+// first evaluation of that set of fragments.
+func (s *Service) fragmentQuery(frags []Fragment) (rego.PreparedEvalQuery, error) {
+	sum := sha256.Sum256([]byte(strings.Join(fragmentModules(frags), "\x00")))
+	key := hex.EncodeToString(sum[:])
+
+	s.fragMu.Lock()
+	query, ok := s.compiled[key]
+	s.fragMu.Unlock()
+	if ok {
+		return query, nil
+	}
+
+	query, err := prepareFragments(frags)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+	s.fragMu.Lock()
+	if s.compiled == nil || len(s.compiled) >= maxCompiledFragments {
+		s.compiled = make(map[string]rego.PreparedEvalQuery)
+	}
+	s.compiled[key] = query
+	s.fragMu.Unlock()
+	return query, nil
+}
+
+// EffectivePolicy is the policy a repository is evaluated against: the
+// global policy, then the fragments of its namespace from the least to the
+// most specific.
+type EffectivePolicy struct {
+	Repository string     `json:"repository"`
+	Global     string     `json:"global"`
+	Fragments  []Fragment `json:"fragments"`
+	Modules    []string   `json:"modules"` // the Rego each fragment compiles to
+}
+
+// Effective returns the effective policy of repository.
+func (s *Service) Effective(ctx context.Context, repository string) (*EffectivePolicy, error) {
+	frags, err := s.applicable(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	if frags == nil {
+		frags = []Fragment{}
+	}
+	return &EffectivePolicy{
+		Repository: repository,
+		Global:     s.GetPolicy(),
+		Fragments:  frags,
+		Modules:    fragmentModules(frags),
+	}, nil
+}
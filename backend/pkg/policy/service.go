@@ -2,15 +2,29 @@ package policy
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"sync"
 
 	"github.com/open-policy-agent/opa/rego"
+	"github.com/registryx/registryx/backend/pkg/cosign"
+	"github.com/registryx/registryx/backend/pkg/lint"
 )
 
 type Service struct {
 	mu            sync.RWMutex
 	CurrentPolicy string
+
+	// Compiled once per policy change and shared by every evaluation.
+	allowQuery      rego.PreparedEvalQuery
+	violationsQuery rego.PreparedEvalQuery
+
+	// DB holds the namespace policy fragments; without it only the global
+	// policy applies.
+	DB *sql.DB
+
+	fragMu   sync.Mutex
+	compiled map[string]rego.PreparedEvalQuery // by fragment set
 }
 
 func NewService() *Service {
@@ -36,11 +50,37 @@ func NewService() *Service {
 			count(violations) > 0
 		}
 	`
+	allowQuery, violationsQuery, err := prepare(defaultPolicy)
+	if err != nil {
+		panic(fmt.Sprintf("default policy does not compile: %v", err))
+	}
 	return &Service{
-		CurrentPolicy: defaultPolicy,
+		CurrentPolicy:   defaultPolicy,
+		allowQuery:      allowQuery,
+		violationsQuery: violationsQuery,
 	}
 }
 
+// prepare compiles the allow and violations queries for a policy.
+func prepare(policy string) (rego.PreparedEvalQuery, rego.PreparedEvalQuery, error) {
+	ctx := context.Background()
+	allowQuery, err := rego.New(
+		rego.Query("data.registryx.policy.allow"),
+		rego.Module("policy.rego", policy),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, rego.PreparedEvalQuery{}, err
+	}
+	violationsQuery, err := rego.New(
+		rego.Query("data.registryx.policy.violations"),
+		rego.Module("policy.rego", policy),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, rego.PreparedEvalQuery{}, err
+	}
+	return allowQuery, violationsQuery, nil
+}
+
 // GetPolicy returns the current Rego policy.
 func (s *Service) GetPolicy() string {
 	s.mu.RLock()
@@ -50,19 +90,17 @@ func (s *Service) GetPolicy() string {
 
 // UpdatePolicy updates the current Rego policy.
 func (s *Service) UpdatePolicy(policy string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	
-	// Validate syntax (Simple compile check)
-	_, err := rego.New(
-		rego.Query("data.registryx.policy.allow"),
-		rego.Module("policy.rego", policy),
-	).PrepareForEval(context.Background())
+	// Compile outside the lock so evaluations keep running meanwhile.
+	allowQuery, violationsQuery, err := prepare(policy)
 	if err != nil {
 		return fmt.Errorf("invalid policy syntax: %w", err)
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.CurrentPolicy = policy
+	s.allowQuery = allowQuery
+	s.violationsQuery = violationsQuery
 	return nil
 }
 
@@ -74,6 +112,10 @@ type EvaluationInput struct {
 	User            string                 `json:"user"`
 	Environment     string                 `json:"environment"`
 	IsSigned        bool                   `json:"is_signed"`
+	Signatures      []cosign.Identity      `json:"signatures"` // cosign signatures that verified: key, or keyless issuer and subject
+	HealthScore     int                    `json:"health_score"` // 0-100, 0 when not yet calculated
+	HealthGrade     string                 `json:"health_grade"`
+	Lint            []lint.Finding         `json:"lint"` // best-practice findings; null until the image is linted
 }
 
 type VulnerabilitySummary struct {
@@ -81,22 +123,19 @@ type VulnerabilitySummary struct {
 	High     int `json:"high"`
 }
 
-// Evaluate checks if the action is allowed.
+// Evaluate checks if the action is allowed by the global policy and the
+// fragments of the repository's namespace.
 // Returns allowed (bool) and a list of violation messages.
 func (s *Service) Evaluate(ctx context.Context, input EvaluationInput) (bool, []string, error) {
 	s.mu.RLock()
-	policyStr := s.CurrentPolicy
+	query := s.allowQuery
+	vQuery := s.violationsQuery
 	s.mu.RUnlock()
+	return s.evaluate(ctx, query, vQuery, input)
+}
 
-	query, err := rego.New(
-		rego.Query("data.registryx.policy.allow"),
-		rego.Module("policy.rego", policyStr),
-	).PrepareForEval(ctx)
-
-	if err != nil {
-		return false, nil, fmt.Errorf("failed to prepare rego: %w", err)
-	}
-
+// evaluate is Evaluate with the given global policy queries.
+func (s *Service) evaluate(ctx context.Context, query, vQuery rego.PreparedEvalQuery, input EvaluationInput) (bool, []string, error) {
 	results, err := query.Eval(ctx, rego.EvalInput(input))
 	if err != nil {
 		return false, nil, fmt.Errorf("failed to eval rego: %w", err)
@@ -114,12 +153,6 @@ func (s *Service) Evaluate(ctx context.Context, input EvaluationInput) (bool, []
 	// Retrieve violations if denied
 	var violationMsgs []string
 	if !allowed {
-		// New query for violations
-		vQuery, _ := rego.New(
-			rego.Query("data.registryx.policy.violations"),
-			rego.Module("policy.rego", policyStr),
-		).PrepareForEval(ctx)
-		
 		vRes, _ := vQuery.Eval(ctx, rego.EvalInput(input))
 		if len(vRes) > 0 {
 			if msgs, ok := vRes[0].Expressions[0].Value.([]interface{}); ok {
@@ -130,5 +163,15 @@ func (s *Service) Evaluate(ctx context.Context, input EvaluationInput) (bool, []
 		}
 	}
 
+	// Fragments can only add violations. If they cannot be evaluated, the
+	// global policy still applies.
+	extra, err := s.fragmentViolations(ctx, input)
+	if err != nil {
+		fmt.Printf("[Policy] Namespace policies for %s not evaluated: %v\n", input.Repository, err)
+	} else if len(extra) > 0 {
+		allowed = false
+		violationMsgs = append(violationMsgs, extra...)
+	}
+
 	return allowed, violationMsgs, nil
 }
@@ -0,0 +1,177 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxTestCaseName bounds test case names, as the column does.
+const maxTestCaseName = 100
+
+// TestCase is an evaluation input and the verdict a policy must reach on it.
+// Each of ExpectViolations must be part of some violation message.
+type TestCase struct {
+	ID               string          `json:"id,omitempty"`
+	Name             string          `json:"name"`
+	Input            EvaluationInput `json:"input"`
+	ExpectAllow      bool            `json:"expectAllow"`
+	ExpectViolations []string        `json:"expectViolations,omitempty"`
+	UpdatedAt        time.Time       `json:"updatedAt,omitempty"`
+}
+
+// TestResult is the outcome of one test case.
+type TestResult struct {
+	Name       string   `json:"name"`
+	Passed     bool     `json:"passed"`
+	Allowed    bool     `json:"allowed"`
+	Violations []string `json:"violations"`
+	Failure    string   `json:"failure,omitempty"` // why it failed
+}
+
+// TestReport is the outcome of a test run.
+type TestReport struct {
+	Passed  bool         `json:"passed"`
+	Total   int          `json:"total"`
+	Failed  int          `json:"failed"`
+	Results []TestResult `json:"results"`
+}
+
+// TestCases returns the saved test cases, by name.
+func (s *Service) TestCases(ctx context.Context) ([]TestCase, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, name, input, expect_allow, expect_violations, updated_at
+		FROM policy_test_cases ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cases := []TestCase{}
+	for rows.Next() {
+		var tc TestCase
+		var input, violations []byte
+		if err := rows.Scan(&tc.ID, &tc.Name, &input, &tc.ExpectAllow, &violations, &tc.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(input, &tc.Input); err != nil {
+			return nil, fmt.Errorf("test case %q: %w", tc.Name, err)
+		}
+		if err := json.Unmarshal(violations, &tc.ExpectViolations); err != nil {
+			return nil, fmt.Errorf("test case %q: %w", tc.Name, err)
+		}
+		cases = append(cases, tc)
+	}
+	return cases, rows.Err()
+}
+
+// SaveTestCase creates a test case, or replaces the one with the same name.
+func (s *Service) SaveTestCase(ctx context.Context, tc *TestCase, userID string) error {
+	tc.Name = strings.TrimSpace(tc.Name)
+	if tc.Name == "" || len(tc.Name) > maxTestCaseName {
+		return fmt.Errorf("test case name must be 1 to %d characters", maxTestCaseName)
+	}
+	if tc.ExpectAllow && len(tc.ExpectViolations) > 0 {
+		return errors.New("a test case expecting allow cannot expect violations")
+	}
+	input, err := json.Marshal(tc.Input)
+	if err != nil {
+		return err
+	}
+	if tc.ExpectViolations == nil {
+		tc.ExpectViolations = []string{}
+	}
+	violations, err := json.Marshal(tc.ExpectViolations)
+	if err != nil {
+		return err
+	}
+	return s.DB.QueryRowContext(ctx, `
+		INSERT INTO policy_test_cases (name, input, expect_allow, expect_violations, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid)
+		ON CONFLICT (name) DO UPDATE
+		SET input = EXCLUDED.input, expect_allow = EXCLUDED.expect_allow,
+		    expect_violations = EXCLUDED.expect_violations, updated_at = NOW()
+		RETURNING id, updated_at`,
+		tc.Name, input, tc.ExpectAllow, violations, userID).Scan(&tc.ID, &tc.UpdatedAt)
+}
+
+// DeleteTestCase removes a saved test case.
+func (s *Service) DeleteTestCase(ctx context.Context, id string) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM policy_test_cases WHERE id::text = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RunTests evaluates cases against a candidate global policy, or against
+// the current one if candidate is empty, without applying it. Namespace
+// fragments apply as they would after the change. A candidate that does
+// not compile is an error.
+func (s *Service) RunTests(ctx context.Context, candidate string, cases []TestCase) (*TestReport, error) {
+	s.mu.RLock()
+	query, vQuery := s.allowQuery, s.violationsQuery
+	s.mu.RUnlock()
+	if strings.TrimSpace(candidate) != "" {
+		var err error
+		if query, vQuery, err = prepare(candidate); err != nil {
+			return nil, fmt.Errorf("invalid policy syntax: %w", err)
+		}
+	}
+
+	report := &TestReport{Passed: true, Total: len(cases), Results: []TestResult{}}
+	for _, tc := range cases {
+		res := TestResult{Name: tc.Name, Violations: []string{}}
+		allowed, violations, err := s.evaluate(ctx, query, vQuery, tc.Input)
+		switch {
+		case err != nil:
+			res.Failure = err.Error()
+		case allowed != tc.ExpectAllow:
+			res.Failure = fmt.Sprintf("expected %s, got %s", verdict(tc.ExpectAllow), verdict(allowed))
+		default:
+			res.Failure = missingViolation(tc.ExpectViolations, violations)
+		}
+		res.Allowed = allowed
+		if violations != nil {
+			res.Violations = violations
+		}
+		res.Passed = res.Failure == ""
+		if !res.Passed {
+			report.Passed = false
+			report.Failed++
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report, nil
+}
+
+func verdict(allowed bool) string {
+	if allowed {
+		return "allow"
+	}
+	return "deny"
+}
+
+// missingViolation describes the first expected violation no message
+// contains, or returns "" if there is none.
+func missingViolation(expected, violations []string) string {
+	for _, want := range expected {
+		found := false
+		for _, v := range violations {
+			if strings.Contains(v, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("expected a violation containing %q", want)
+		}
+	}
+	return ""
+}
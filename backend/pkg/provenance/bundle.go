@@ -0,0 +1,223 @@
+package provenance
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/metadata"
+)
+
+const (
+	inTotoPayloadType = "application/vnd.in-toto+json"
+
+	// githubIssuer is the OIDC issuer of GitHub Actions workload tokens.
+	githubIssuer = "https://token.actions.githubusercontent.com"
+)
+
+// Fulcio certificate extensions, see
+// https://github.com/sigstore/fulcio/blob/main/docs/oid-info.md
+var (
+	oidIssuerV1         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+	oidSourceRepository = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 12}
+	oidSourceDigest     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 13}
+)
+
+// bundle is a Sigstore bundle (v0.1 to v0.3) holding a DSSE envelope.
+type bundle struct {
+	MediaType            string `json:"mediaType"`
+	VerificationMaterial struct {
+		Certificate *struct {
+			RawBytes []byte `json:"rawBytes"`
+		} `json:"certificate"`
+		X509CertificateChain *struct {
+			Certificates []struct {
+				RawBytes []byte `json:"rawBytes"`
+			} `json:"certificates"`
+		} `json:"x509CertificateChain"`
+		TlogEntries []struct {
+			IntegratedTime json.RawMessage `json:"integratedTime"` // int64, usually as a string
+		} `json:"tlogEntries"`
+	} `json:"verificationMaterial"`
+	DSSEEnvelope *struct {
+		Payload     []byte `json:"payload"`
+		PayloadType string `json:"payloadType"`
+		Signatures  []struct {
+			Sig []byte `json:"sig"`
+		} `json:"signatures"`
+	} `json:"dsseEnvelope"`
+}
+
+func isSigstoreBundle(m *manifest) bool {
+	if strings.HasPrefix(m.ArtifactType, "application/vnd.dev.sigstore.bundle") {
+		return true
+	}
+	for _, l := range m.Layers {
+		if strings.HasPrefix(l.MediaType, "application/vnd.dev.sigstore.bundle") {
+			return true
+		}
+	}
+	return false
+}
+
+// fromBundle reads the provenance in a Sigstore bundle about subject. It
+// returns nil for bundles holding other predicates (e.g. SBOMs). A bundle
+// that fails verification still yields its metadata, with the reason in
+// VerificationError.
+func (r *Reader) fromBundle(data []byte, subject string) (*metadata.BuildMetadata, error) {
+	var b bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	env := b.DSSEEnvelope
+	if env == nil || env.PayloadType != inTotoPayloadType {
+		return nil, nil
+	}
+	var st statement
+	if err := json.Unmarshal(env.Payload, &st); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(st.PredicateType, slsaPredicatePrefix) {
+		return nil, nil
+	}
+	if !st.covers(subject) {
+		return nil, fmt.Errorf("provenance does not name %s as its subject", subject)
+	}
+
+	build := st.buildMetadata()
+	build.Provenance = metadata.ProvenanceSigstore
+	if err := r.verify(&b, build); err != nil {
+		build.VerificationError = err.Error()
+	} else {
+		build.Verified = true
+	}
+	return build, nil
+}
+
+// verify checks the envelope signature and that the signing certificate was
+// issued by Fulcio to a GitHub Actions workflow of the provenance's source
+// repository and commit.
+func (r *Reader) verify(b *bundle, build *metadata.BuildMetadata) error {
+	var chain [][]byte
+	if c := b.VerificationMaterial.Certificate; c != nil {
+		chain = append(chain, c.RawBytes)
+	} else if c := b.VerificationMaterial.X509CertificateChain; c != nil {
+		for _, cert := range c.Certificates {
+			chain = append(chain, cert.RawBytes)
+		}
+	}
+	if len(chain) == 0 {
+		return errors.New("bundle has no signing certificate")
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return fmt.Errorf("invalid signing certificate: %w", err)
+	}
+
+	if err := verifyEnvelope(leaf, b); err != nil {
+		return err
+	}
+
+	if r.Roots == nil {
+		return errors.New("signature valid, but no Fulcio roots are configured to verify the certificate")
+	}
+	if len(b.VerificationMaterial.TlogEntries) == 0 {
+		return errors.New("bundle has no transparency log entry")
+	}
+	signedAt, err := parseIntegratedTime(b.VerificationMaterial.TlogEntries[0].IntegratedTime)
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, raw := range chain[1:] {
+		if cert, err := x509.ParseCertificate(raw); err == nil {
+			intermediates.AddCert(cert)
+		}
+	}
+	// Fulcio certificates live for minutes; check them at signing time. The
+	// time comes from the bundle; Rekor's signed timestamp is not checked.
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         r.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("certificate not issued by a trusted Fulcio root: %w", err)
+	}
+
+	if issuer := extension(leaf, oidIssuerV2, true, extension(leaf, oidIssuerV1, false, "")); issuer != githubIssuer {
+		return fmt.Errorf("certificate issued for %q, not GitHub Actions", issuer)
+	}
+	repo := extension(leaf, oidSourceRepository, true, "")
+	if repo == "" || !strings.EqualFold(repo, build.SourceRepository) {
+		return fmt.Errorf("certificate is for repository %q, provenance claims %q", repo, build.SourceRepository)
+	}
+	if commit := extension(leaf, oidSourceDigest, true, ""); commit != "" && build.CommitSHA != "" && !strings.EqualFold(commit, build.CommitSHA) {
+		return fmt.Errorf("certificate is for commit %s, provenance claims %s", commit, build.CommitSHA)
+	}
+	return nil
+}
+
+// verifyEnvelope checks that one of the DSSE signatures is by leaf's key.
+func verifyEnvelope(leaf *x509.Certificate, b *bundle) error {
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported signing key type %T", leaf.PublicKey)
+	}
+	hash := crypto.SHA256
+	if pub.Curve == elliptic.P384() {
+		hash = crypto.SHA384
+	}
+	env := b.DSSEEnvelope
+	h := hash.New()
+	h.Write(pae(env.PayloadType, env.Payload))
+	sum := h.Sum(nil)
+	for _, sig := range env.Signatures {
+		if ecdsa.VerifyASN1(pub, sum, sig.Sig) {
+			return nil
+		}
+	}
+	return errors.New("no valid signature on the attestation")
+}
+
+// pae is the DSSE pre-authentication encoding.
+func pae(payloadType string, payload []byte) []byte {
+	header := fmt.Sprintf("DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	return append([]byte(header), payload...)
+}
+
+// extension returns a Fulcio extension value. Newer extensions are DER
+// UTF8Strings; the original issuer extension is the raw string.
+func extension(cert *x509.Certificate, oid asn1.ObjectIdentifier, der bool, fallback string) string {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oid) {
+			continue
+		}
+		if !der {
+			return string(ext.Value)
+		}
+		var s string
+		if _, err := asn1.UnmarshalWithParams(ext.Value, &s, "utf8"); err == nil {
+			return s
+		}
+	}
+	return fallback
+}
+
+func parseIntegratedTime(raw json.RawMessage) (time.Time, error) {
+	s := strings.Trim(string(raw), `"`)
+	secs, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || secs <= 0 {
+		return time.Time{}, errors.New("transparency log entry has no integrated time")
+	}
+	return time.Unix(secs, 0), nil
+}
@@ -0,0 +1,185 @@
+// Package provenance reads SLSA provenance attestations pushed alongside
+// images and turns them into build metadata.
+//
+// Two formats are understood:
+//
+//   - BuildKit attestation manifests (docker/build-push-action's default).
+//     They sit in the image index next to the platform manifests and are not
+//     signed, so the result is recorded as unverified.
+//   - Sigstore bundles from actions/attest-build-provenance, pushed as an
+//     artifact manifest whose subject is the image. The DSSE signature is
+//     checked against the bundle's Fulcio certificate, which must be issued
+//     for a GitHub Actions workflow of the repository named in the
+//     provenance and chain to a configured Fulcio root.
+package provenance
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+const (
+	annotationReferenceType   = "vnd.docker.reference.type"
+	annotationReferenceDigest = "vnd.docker.reference.digest"
+	annotationPredicateType   = "in-toto.io/predicate-type"
+
+	attestationManifestType = "attestation-manifest"
+	slsaPredicatePrefix     = "https://slsa.dev/provenance/"
+
+	// maxAttestationSize bounds blobs read while looking for provenance.
+	maxAttestationSize = 4 * 1024 * 1024
+)
+
+// Result is the build metadata recovered for one image.
+type Result struct {
+	Digest string // the attested image
+	Build  metadata.BuildMetadata
+}
+
+// Reader extracts provenance from manifests in Storage.
+type Reader struct {
+	Storage storage.Driver
+	Roots   *x509.CertPool // Fulcio roots; nil leaves Sigstore bundles unverified
+}
+
+// NewReader creates a reader. rootsFile is a PEM bundle of Fulcio root and
+// intermediate certificates; empty disables signature chain verification.
+func NewReader(store storage.Driver, rootsFile string) (*Reader, error) {
+	r := &Reader{Storage: store}
+	if rootsFile == "" {
+		return r, nil
+	}
+	pem, err := os.ReadFile(rootsFile)
+	if err != nil {
+		return nil, fmt.Errorf("read Fulcio roots: %w", err)
+	}
+	r.Roots = x509.NewCertPool()
+	if !r.Roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", rootsFile)
+	}
+	return r, nil
+}
+
+type descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType"`
+	Annotations  map[string]string `json:"annotations"`
+}
+
+type manifest struct {
+	MediaType    string       `json:"mediaType"`
+	ArtifactType string       `json:"artifactType"`
+	Config       *descriptor  `json:"config"`
+	Layers       []descriptor `json:"layers"`
+	Manifests    []descriptor `json:"manifests"`
+	Subject      *descriptor  `json:"subject"`
+}
+
+// FromManifest returns the provenance carried by a pushed manifest: one
+// result per attested platform for an index, or one for a Sigstore bundle
+// artifact. Manifests without provenance return nothing.
+func (r *Reader) FromManifest(ctx context.Context, repoName string, body []byte) ([]Result, error) {
+	var m manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+
+	if m.Subject != nil {
+		if !isSigstoreBundle(&m) {
+			return nil, nil
+		}
+		for _, layer := range m.Layers {
+			blob, err := r.read(ctx, path.Join("blobs", layer.Digest))
+			if err != nil {
+				return nil, err
+			}
+			build, err := r.fromBundle(blob, m.Subject.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if build != nil {
+				return []Result{{Digest: m.Subject.Digest, Build: *build}}, nil
+			}
+		}
+		return nil, nil
+	}
+
+	var results []Result
+	for _, d := range m.Manifests {
+		if d.Annotations[annotationReferenceType] != attestationManifestType {
+			continue
+		}
+		subject := d.Annotations[annotationReferenceDigest]
+		if subject == "" {
+			continue
+		}
+		build, err := r.fromAttestationManifest(ctx, repoName, d.Digest, subject)
+		if err != nil {
+			return results, fmt.Errorf("attestation %s: %w", d.Digest, err)
+		}
+		if build != nil {
+			results = append(results, Result{Digest: subject, Build: *build})
+		}
+	}
+	return results, nil
+}
+
+func (r *Reader) fromAttestationManifest(ctx context.Context, repoName, digest, subject string) (*metadata.BuildMetadata, error) {
+	body, err := r.read(ctx, path.Join("manifests", repoName, digest))
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	for _, layer := range m.Layers {
+		if !strings.HasPrefix(layer.Annotations[annotationPredicateType], slsaPredicatePrefix) {
+			continue
+		}
+		blob, err := r.read(ctx, path.Join("blobs", layer.Digest))
+		if err != nil {
+			return nil, err
+		}
+		var st statement
+		if err := json.Unmarshal(blob, &st); err != nil {
+			return nil, err
+		}
+		if !st.covers(subject) {
+			return nil, fmt.Errorf("provenance does not name %s as its subject", subject)
+		}
+		build := st.buildMetadata()
+		build.Provenance = metadata.ProvenanceBuildKit
+		build.VerificationError = "BuildKit attestations are not signed"
+		return build, nil
+	}
+	return nil, nil
+}
+
+func (r *Reader) read(ctx context.Context, objectPath string) ([]byte, error) {
+	reader, err := r.Storage.Reader(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, maxAttestationSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAttestationSize {
+		return nil, errors.New("attestation too large")
+	}
+	return data, nil
+}
@@ -0,0 +1,184 @@
+package provenance
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/registryx/registryx/backend/pkg/metadata"
+)
+
+// statement is an in-toto v0.1/v1 statement.
+type statement struct {
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	Predicate json.RawMessage `json:"predicate"`
+}
+
+// covers reports whether the statement is about digest ("sha256:...").
+func (s *statement) covers(digest string) bool {
+	algo, hex, ok := strings.Cut(digest, ":")
+	if !ok {
+		return false
+	}
+	for _, subj := range s.Subject {
+		if strings.EqualFold(subj.Digest[algo], hex) {
+			return true
+		}
+	}
+	return false
+}
+
+// slsaV02 is the part of a SLSA v0.2 predicate (BuildKit's default) that
+// describes the source and builder.
+type slsaV02 struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	Invocation struct {
+		ConfigSource struct {
+			URI        string            `json:"uri"`
+			Digest     map[string]string `json:"digest"`
+			EntryPoint string            `json:"entryPoint"`
+		} `json:"configSource"`
+		Environment map[string]interface{} `json:"environment"`
+	} `json:"invocation"`
+	Metadata struct {
+		BuildKit struct {
+			VCS struct {
+				Source   string `json:"source"`
+				Revision string `json:"revision"`
+			} `json:"vcs"`
+		} `json:"https://mobyproject.org/buildkit@v1#metadata"`
+	} `json:"metadata"`
+}
+
+// slsaV1 covers both GitHub's workflow build type and BuildKit's v1 output.
+type slsaV1 struct {
+	BuildDefinition struct {
+		BuildType          string `json:"buildType"`
+		ExternalParameters struct {
+			Workflow *struct {
+				Ref        string `json:"ref"`
+				Repository string `json:"repository"`
+				Path       string `json:"path"`
+			} `json:"workflow"`
+			ConfigSource *struct {
+				URI    string            `json:"uri"`
+				Digest map[string]string `json:"digest"`
+			} `json:"configSource"`
+		} `json:"externalParameters"`
+		ResolvedDependencies []struct {
+			URI    string            `json:"uri"`
+			Digest map[string]string `json:"digest"`
+		} `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID string `json:"invocationId"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// buildMetadata maps the predicate onto build metadata. Fields the
+// provenance does not carry are left empty.
+func (s *statement) buildMetadata() *metadata.BuildMetadata {
+	md := &metadata.BuildMetadata{}
+	if strings.HasSuffix(s.PredicateType, "/v0.2") {
+		var p slsaV02
+		if json.Unmarshal(s.Predicate, &p) != nil {
+			return md
+		}
+		src := p.Invocation.ConfigSource
+		repo, ref := splitSource(src.URI)
+		if repo == "" {
+			repo, _ = splitSource(p.Metadata.BuildKit.VCS.Source)
+		}
+		md.SourceRepository = repo
+		md.Branch = branch(ref)
+		md.CommitSHA = firstOf(src.Digest["sha1"], src.Digest["gitCommit"], p.Metadata.BuildKit.VCS.Revision)
+		md.Builder = p.Builder.ID
+		if wf, ok := p.Invocation.Environment["github_workflow_ref"].(string); ok {
+			md.Workflow = wf
+		}
+		if isRunURL(p.Builder.ID) {
+			md.PipelineURL = p.Builder.ID
+			md.Builder = "github-actions"
+		}
+		return md
+	}
+
+	var p slsaV1
+	if json.Unmarshal(s.Predicate, &p) != nil {
+		return md
+	}
+	def := p.BuildDefinition
+	if wf := def.ExternalParameters.Workflow; wf != nil {
+		md.SourceRepository, _ = splitSource(wf.Repository)
+		md.Branch = branch(wf.Ref)
+		md.Workflow = wf.Path
+	} else if cs := def.ExternalParameters.ConfigSource; cs != nil {
+		var ref string
+		md.SourceRepository, ref = splitSource(cs.URI)
+		md.Branch = branch(ref)
+		md.CommitSHA = firstOf(cs.Digest["sha1"], cs.Digest["gitCommit"])
+	}
+	for _, dep := range def.ResolvedDependencies {
+		if commit := firstOf(dep.Digest["gitCommit"], dep.Digest["sha1"]); commit != "" && md.CommitSHA == "" {
+			md.CommitSHA = commit
+			if md.SourceRepository == "" {
+				var ref string
+				md.SourceRepository, ref = splitSource(dep.URI)
+				md.Branch = branch(ref)
+			}
+		}
+	}
+	md.Builder = p.RunDetails.Builder.ID
+	if strings.Contains(def.BuildType, "actions.github.io") {
+		md.Builder = "github-actions"
+	}
+	if isRunURL(p.RunDetails.Metadata.InvocationID) {
+		md.PipelineURL = p.RunDetails.Metadata.InvocationID
+	} else if isRunURL(p.RunDetails.Builder.ID) {
+		md.PipelineURL = p.RunDetails.Builder.ID
+		md.Builder = "github-actions"
+	}
+	return md
+}
+
+// splitSource splits a source URI such as
+// "git+https://github.com/org/repo@refs/heads/main" or
+// "https://github.com/org/repo.git#refs/heads/main" into the repository URL
+// and the ref.
+func splitSource(uri string) (repo, ref string) {
+	uri = strings.TrimPrefix(uri, "git+")
+	if i := strings.Index(uri, "#"); i >= 0 {
+		uri, ref = uri[:i], uri[i+1:]
+	} else if i := strings.LastIndex(uri, "@"); i >= 0 && strings.HasPrefix(uri[i+1:], "refs/") {
+		uri, ref = uri[:i], uri[i+1:]
+	}
+	return strings.TrimSuffix(uri, ".git"), ref
+}
+
+func branch(ref string) string {
+	return strings.TrimPrefix(ref, "refs/heads/")
+}
+
+// isRunURL reports whether u points at a GitHub Actions run.
+func isRunURL(u string) bool {
+	return strings.HasPrefix(u, "https://") && strings.Contains(u, "/actions/runs/")
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
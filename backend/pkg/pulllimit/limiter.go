@@ -0,0 +1,170 @@
+// Package pulllimit caps anonymous image pulls per client IP, Docker Hub
+// style: each manifest GET counts as one pull, responses carry RateLimit-Limit
+// and RateLimit-Remaining headers, and clients over the limit get 429
+// TOOMANYREQUESTS until the window resets. Authenticated pulls are not limited.
+package pulllimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/registryx/registryx/backend/pkg/clientip"
+	"github.com/registryx/registryx/backend/pkg/errcode"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// keyPrefix namespaces the per-IP counters in Redis.
+const keyPrefix = "pull-limit:"
+
+// DefaultWindow matches Docker Hub's six-hour window.
+const DefaultWindow = 6 * time.Hour
+
+// Limiter counts pulls in fixed windows, in Redis when available so every
+// instance shares the count. A nil *Limiter, or one with Limit 0, allows
+// everything.
+type Limiter struct {
+	Limit  int
+	Window time.Duration
+
+	rdb     *redis.Client
+	keyFunc jwt.Keyfunc
+
+	mu     sync.Mutex
+	counts map[string]*bucket
+}
+
+type bucket struct {
+	start time.Time
+	count int
+}
+
+// NewLimiter creates a limiter. keyFunc verifies bearer tokens, so callers
+// holding a real (non-anonymous) registry token are recognized.
+func NewLimiter(rdb *redis.Client, keyFunc jwt.Keyfunc, limit int, window time.Duration) *Limiter {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Limiter{
+		Limit:   limit,
+		Window:  window,
+		rdb:     rdb,
+		keyFunc: keyFunc,
+		counts:  make(map[string]*bucket),
+	}
+}
+
+// Middleware limits the manifest route it wraps. GET counts as a pull; HEAD
+// only reports the remaining quota, as on Docker Hub.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l == nil || l.Limit <= 0 || l.authenticated(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientip.FromRequest(r)
+		used, reset, err := l.take(r.Context(), ip, r.Method == http.MethodGet)
+		if err != nil {
+			// Never fail pulls because the counter is unavailable.
+			fmt.Printf("[PullLimit] Failed to count pull for %s: %v\n", ip, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		windowSecs := int(l.Window / time.Second)
+		remaining := l.Limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		w.Header().Set("RateLimit-Limit", fmt.Sprintf("%d;w=%d", l.Limit, windowSecs))
+		w.Header().Set("RateLimit-Remaining", fmt.Sprintf("%d;w=%d", remaining, windowSecs))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(reset/time.Second)))
+
+		if used > l.Limit {
+			w.Header().Set("Retry-After", strconv.Itoa(int(reset/time.Second)+1))
+			errcode.ServeJSON(w, errcode.TooManyRequests.WithMessage(fmt.Sprintf(
+				"anonymous pull rate limit of %d per %s reached; log in or retry later", l.Limit, l.Window)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// take counts a pull for ip when count is set and returns the pulls used in
+// the current window (including this one) and the time until it resets.
+func (l *Limiter) take(ctx context.Context, ip string, count bool) (int, time.Duration, error) {
+	now := time.Now()
+	start := now.Truncate(l.Window)
+	reset := start.Add(l.Window).Sub(now)
+
+	if l.rdb != nil {
+		key := keyPrefix + ip + ":" + strconv.FormatInt(start.Unix(), 10)
+		var n int64
+		var err error
+		if count {
+			n, err = l.rdb.Incr(ctx, key).Result()
+			if err == nil && n == 1 {
+				l.rdb.Expire(ctx, key, l.Window)
+			}
+		} else {
+			n, err = l.rdb.Get(ctx, key).Int64()
+			if err == redis.Nil {
+				n, err = 0, nil
+			}
+		}
+		return int(n), reset, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	win, ok := l.counts[ip]
+	if !ok || !win.start.Equal(start) {
+		win = &bucket{start: start}
+		l.counts[ip] = win
+	}
+	if count {
+		win.count++
+	}
+
+	// Drop finished windows now and then so the map doesn't grow without bound.
+	if len(l.counts) > 4096 {
+		for k, c := range l.counts {
+			if c.start.Before(start) {
+				delete(l.counts, k)
+			}
+		}
+	}
+	return win.count, reset, nil
+}
+
+// authenticated reports whether the request carries a valid token issued to
+// a real user or service account.
+func (l *Limiter) authenticated(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if l.keyFunc == nil || !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token, err := jwt.Parse(strings.TrimPrefix(auth, "Bearer "), l.keyFunc)
+	if err != nil || !token.Valid {
+		return false
+	}
+	sub, _ := token.Claims.GetSubject()
+	if sub == "" || sub == "anonymous" {
+		return false
+	}
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && l.rdb != nil {
+		if jti, _ := claims["jti"].(string); jti != "" {
+			if n, err := l.rdb.Exists(r.Context(), middleware.RevokedTokenPrefix+jti).Result(); err == nil && n > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -3,20 +3,36 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"github.com/registryx/registryx/backend/pkg/config"
+	"github.com/registryx/registryx/backend/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const ScanQueueKey = "registryx:scan_queue"
 
+// Keys used to track jobs leased by external workers.
+const (
+	ScanLeaseJobsKey   = "registryx:scan_lease_jobs"   // hash: lease id -> job JSON
+	ScanLeaseExpiryKey = "registryx:scan_lease_expiry" // zset: lease id scored by expiry (unix)
+)
+
+// ErrLeaseNotFound is returned when a lease has expired or was already completed.
+var ErrLeaseNotFound = errors.New("lease not found")
+
 type Job struct {
 	ManifestID uuid.UUID `json:"manifest_id"`
 	Repository string    `json:"repository"`
 	Reference  string    `json:"reference"`
+	// Trace is the trace context of the push that queued the job; pass it to
+	// tracing.Extract so the scan joins that trace.
+	Trace map[string]string `json:"trace,omitempty"`
 }
 
 type Service struct {
@@ -36,10 +52,13 @@ func NewService(cfg *config.Config) (*Service, error) {
 }
 
 func (s *Service) EnqueueScan(ctx context.Context, manifestID uuid.UUID, repoName, reference string) error {
-	job := Job{ManifestID: manifestID, Repository: repoName, Reference: reference}
+	ctx, span := tracing.Start(ctx, "queue.EnqueueScan", attribute.String("registry.repository", repoName), attribute.String("registry.reference", reference))
+	job := Job{ManifestID: manifestID, Repository: repoName, Reference: reference, Trace: tracing.Inject(ctx)}
 	bytes, _ := json.Marshal(job)
 	
-	return s.Client.RPush(ctx, ScanQueueKey, bytes).Err()
+	err := s.Client.RPush(ctx, ScanQueueKey, bytes).Err()
+	tracing.End(span, err)
+	return err
 }
 
 func (s *Service) DequeueScan(ctx context.Context) (*Job, error) {
@@ -57,3 +76,138 @@ func (s *Service) DequeueScan(ctx context.Context) (*Job, error) {
 
 	return &job, nil
 }
+
+// RequeueScan puts a job that was dequeued but not finished back at the
+// front of the queue, so it runs next.
+func (s *Service) RequeueScan(ctx context.Context, job Job) error {
+	bytes, _ := json.Marshal(job)
+	return s.Client.LPush(ctx, ScanQueueKey, bytes).Err()
+}
+
+// Lease is a job handed out to an external worker.
+type Lease struct {
+	ID        string    `json:"id"`
+	Job       Job       `json:"job"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LeaseScan pops the next scan job and records it as leased until ttl elapses.
+// It blocks for at most wait; a nil lease means the queue was empty.
+func (s *Service) LeaseScan(ctx context.Context, ttl, wait time.Duration) (*Lease, error) {
+	result, err := s.Client.BLPop(ctx, wait, ScanQueueKey).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal([]byte(result[1]), &job); err != nil {
+		return nil, err
+	}
+
+	lease := &Lease{ID: uuid.New().String(), Job: job, ExpiresAt: time.Now().Add(ttl)}
+
+	pipe := s.Client.TxPipeline()
+	pipe.HSet(ctx, ScanLeaseJobsKey, lease.ID, result[1])
+	pipe.ZAdd(ctx, ScanLeaseExpiryKey, redis.Z{Score: float64(lease.ExpiresAt.Unix()), Member: lease.ID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		// Put the job back so it isn't lost.
+		s.Client.LPush(ctx, ScanQueueKey, result[1])
+		return nil, fmt.Errorf("failed to record lease: %w", err)
+	}
+
+	return lease, nil
+}
+
+// GetLease returns the job held by an active lease.
+func (s *Service) GetLease(ctx context.Context, leaseID string) (*Job, error) {
+	raw, err := s.Client.HGet(ctx, ScanLeaseJobsKey, leaseID).Result()
+	if err == redis.Nil {
+		return nil, ErrLeaseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RenewLease pushes the expiry of an active lease ttl into the future.
+func (s *Service) RenewLease(ctx context.Context, leaseID string, ttl time.Duration) (time.Time, error) {
+	if _, err := s.GetLease(ctx, leaseID); err != nil {
+		return time.Time{}, err
+	}
+	expiresAt := time.Now().Add(ttl)
+	err := s.Client.ZAdd(ctx, ScanLeaseExpiryKey, redis.Z{Score: float64(expiresAt.Unix()), Member: leaseID}).Err()
+	return expiresAt, err
+}
+
+// CompleteLease removes a lease once its job has been handled.
+func (s *Service) CompleteLease(ctx context.Context, leaseID string) error {
+	pipe := s.Client.TxPipeline()
+	hdel := pipe.HDel(ctx, ScanLeaseJobsKey, leaseID)
+	pipe.ZRem(ctx, ScanLeaseExpiryKey, leaseID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if hdel.Val() == 0 {
+		return ErrLeaseNotFound
+	}
+	return nil
+}
+
+// RequeueExpiredLeases puts jobs whose lease ran out back on the scan queue.
+// Returns the number of jobs requeued.
+func (s *Service) RequeueExpiredLeases(ctx context.Context) (int, error) {
+	expired, err := s.Client.ZRangeByScore(ctx, ScanLeaseExpiryKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, leaseID := range expired {
+		raw, err := s.Client.HGet(ctx, ScanLeaseJobsKey, leaseID).Result()
+		// ZRem first so two reapers never requeue the same lease twice.
+		removed, remErr := s.Client.ZRem(ctx, ScanLeaseExpiryKey, leaseID).Result()
+		if remErr != nil || removed == 0 {
+			continue
+		}
+		s.Client.HDel(ctx, ScanLeaseJobsKey, leaseID)
+		if err != nil {
+			continue
+		}
+		if err := s.Client.RPush(ctx, ScanQueueKey, raw).Err(); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ScanPending reports whether a scan of the manifest is queued or leased to
+// an external worker.
+func (s *Service) ScanPending(ctx context.Context, manifestID uuid.UUID) (bool, error) {
+	queued, err := s.Client.LRange(ctx, ScanQueueKey, 0, -1).Result()
+	if err != nil {
+		return false, err
+	}
+	leased, err := s.Client.HVals(ctx, ScanLeaseJobsKey).Result()
+	if err != nil {
+		return false, err
+	}
+	for _, raw := range append(queued, leased...) {
+		var job Job
+		if json.Unmarshal([]byte(raw), &job) == nil && job.ManifestID == manifestID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
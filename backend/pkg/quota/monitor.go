@@ -0,0 +1,218 @@
+// Package quota alerts namespace owners as their storage use approaches the
+// namespace quota.
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/email"
+	"github.com/registryx/registryx/backend/pkg/events"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/webhook"
+)
+
+// Thresholds are the percentages of the quota that alert when crossed.
+var Thresholds = []int{80, 90, 100}
+
+// hysteresis is how many points usage must fall below a threshold before
+// crossing it again alerts again, so usage hovering at a threshold alerts once.
+const hysteresis = 5
+
+// ErrNotFound is returned for namespaces that do not exist.
+var ErrNotFound = errors.New("namespace not found")
+
+// Usage is a namespace's storage use against its quota.
+type Usage struct {
+	Namespace  string  `json:"namespace"`
+	UsedBytes  int64   `json:"usedBytes"`
+	QuotaBytes int64   `json:"quotaBytes"`
+	Percent    float64 `json:"percent"`
+	AlertLevel int     `json:"alertLevel"` // highest threshold alerted on and not cleared yet, 0 for none
+
+	ownerID    string
+	ownerEmail string
+}
+
+// Monitor alerts namespace owners by webhook, email and event when usage
+// crosses a threshold upwards.
+type Monitor struct {
+	DB       *sql.DB
+	Metadata *metadata.Service
+	Webhook  *webhook.Service
+	Email    *email.Service
+	Events   *events.Broker
+}
+
+func NewMonitor(db *sql.DB, meta *metadata.Service, hook *webhook.Service, mail *email.Service, bus *events.Broker) *Monitor {
+	return &Monitor{DB: db, Metadata: meta, Webhook: hook, Email: mail, Events: bus}
+}
+
+// StartScheduler checks every namespace every interval until ctx is done.
+func (m *Monitor) StartScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.CheckAll(ctx); err != nil {
+				fmt.Printf("[Quota] Check failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// Usage returns the storage use of a namespace.
+func (m *Monitor) Usage(ctx context.Context, namespace string) (*Usage, error) {
+	u := &Usage{Namespace: namespace}
+	var ownerID, ownerEmail sql.NullString
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT n.quota_alert_level, n.owner_id::text, u.email
+		FROM namespaces n
+		LEFT JOIN users u ON u.id = n.owner_id
+		WHERE n.name = $1`, namespace).Scan(&u.AlertLevel, &ownerID, &ownerEmail)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.ownerID, u.ownerEmail = ownerID.String, ownerEmail.String
+
+	if u.UsedBytes, u.QuotaBytes, err = m.Metadata.GetNamespaceUsage(ctx, namespace); err != nil {
+		return nil, err
+	}
+	if u.QuotaBytes > 0 {
+		u.Percent = float64(u.UsedBytes) * 100 / float64(u.QuotaBytes)
+	}
+	return u, nil
+}
+
+// IsOwner reports whether userID owns the namespace.
+func (m *Monitor) IsOwner(ctx context.Context, namespace, userID string) (bool, error) {
+	var owner sql.NullString
+	err := m.DB.QueryRowContext(ctx, `SELECT owner_id::text FROM namespaces WHERE name = $1`, namespace).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return false, ErrNotFound
+	}
+	return owner.Valid && owner.String == userID, err
+}
+
+// level is the alert level for usage at percent when the last level was
+// current: the highest threshold reached, or one still within hysteresis
+// of a threshold already alerted on.
+func level(percent float64, current int) int {
+	next := 0
+	for _, t := range Thresholds {
+		if percent >= float64(t) || (t <= current && percent >= float64(t-hysteresis)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// CheckAll checks every namespace.
+func (m *Monitor) CheckAll(ctx context.Context) error {
+	rows, err := m.DB.QueryContext(ctx, `SELECT name FROM namespaces WHERE quota_bytes > 0 ORDER BY name`)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := m.Check(ctx, name); err != nil && !errors.Is(err, ErrNotFound) {
+			fmt.Printf("[Quota] Failed to check %s: %v\n", name, err)
+		}
+	}
+	return nil
+}
+
+// Check updates the alert level of a namespace and alerts its owner if usage
+// crossed a threshold upwards. When several instances check at once, one of
+// them alerts.
+func (m *Monitor) Check(ctx context.Context, namespace string) error {
+	u, err := m.Usage(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	next := level(u.Percent, u.AlertLevel)
+	if next == u.AlertLevel {
+		return nil
+	}
+
+	res, err := m.DB.ExecContext(ctx, `UPDATE namespaces SET quota_alert_level = $1 WHERE name = $2 AND quota_alert_level = $3`,
+		next, namespace, u.AlertLevel)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 || next < u.AlertLevel {
+		return nil
+	}
+
+	fmt.Printf("[Quota] %s is at %.1f%% of its quota (threshold %d%%)\n", namespace, u.Percent, next)
+	m.alert(ctx, u, next)
+	return nil
+}
+
+func (m *Monitor) alert(ctx context.Context, u *Usage, threshold int) {
+	m.Events.Publish(events.Event{
+		Type: events.TypeQuota, User: u.ownerID,
+		Data: map[string]interface{}{"namespace": u.Namespace, "threshold": threshold, "percent": u.Percent, "usedBytes": u.UsedBytes, "quotaBytes": u.QuotaBytes},
+	})
+
+	if m.Webhook != nil {
+		if err := m.Webhook.Notify(ctx, webhook.Event{
+			Action: events.TypeQuota, Timestamp: time.Now(), User: u.ownerID,
+			Quota: &webhook.QuotaUsage{Namespace: u.Namespace, Threshold: threshold, Percent: u.Percent, UsedBytes: u.UsedBytes, QuotaBytes: u.QuotaBytes},
+		}); err != nil {
+			fmt.Printf("[Quota] Webhook for %s failed: %v\n", u.Namespace, err)
+		}
+	}
+
+	if m.Email != nil && m.Email.IsEnabled() && u.ownerEmail != "" {
+		subject := fmt.Sprintf("[RegistryX] Namespace %s is at %d%% of its storage quota", u.Namespace, threshold)
+		body := fmt.Sprintf(`<html><body><p>Namespace <b>%s</b> uses %s of its %s storage quota (%.1f%%).</p>%s</body></html>`,
+			html.EscapeString(u.Namespace), formatSize(u.UsedBytes), formatSize(u.QuotaBytes), u.Percent, advice(threshold))
+		if err := m.Email.Send(u.ownerEmail, subject, body); err != nil {
+			fmt.Printf("[Quota] Email for %s failed: %v\n", u.Namespace, err)
+		}
+	}
+}
+
+func advice(threshold int) string {
+	if threshold >= 100 {
+		return "<p>Pushes to the namespace are rejected until images are deleted or the quota is raised.</p>"
+	}
+	return "<p>Pushes will be rejected once the quota is reached. Delete unused images or ask an administrator to raise the quota.</p>"
+}
+
+func formatSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,360 @@
+package recovery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// Finding kinds reported by the consistency check.
+const (
+	BlobMissing       = "blob_missing"       // indexed in the database, absent from storage
+	BlobSizeMismatch  = "blob_size_mismatch" // database size differs from the stored object
+	BlobUnindexed     = "blob_unindexed"     // stored but not in the database
+	ManifestMissing   = "manifest_missing"   // manifest row without its manifests/<repo>/<digest> object
+	ManifestUnindexed = "manifest_unindexed" // stored manifest unknown to the database
+	TagMissing        = "tag_missing"        // tag row without its manifests/<repo>/<tag> object
+	LayerMissing      = "layer_missing"      // manifest references a layer blob absent from storage
+)
+
+const maxFindings = 500
+
+// Finding is one inconsistency between the database and storage.
+type Finding struct {
+	Kind     string `json:"kind"`
+	Object   string `json:"object"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired"`
+}
+
+// FsckReport is the result of a consistency check.
+type FsckReport struct {
+	BlobsChecked     int            `json:"blobsChecked"`
+	ManifestsChecked int            `json:"manifestsChecked"`
+	TagsChecked      int            `json:"tagsChecked"`
+	Counts           map[string]int `json:"counts"`
+	Repaired         int            `json:"repaired"`
+	Findings         []Finding      `json:"findings"`
+	Truncated        bool           `json:"truncated,omitempty"` // more findings than listed
+	Duration         string         `json:"duration"`
+}
+
+func (r *FsckReport) add(f Finding) {
+	r.Counts[f.Kind]++
+	if f.Repaired {
+		r.Repaired++
+	}
+	if len(r.Findings) < maxFindings {
+		r.Findings = append(r.Findings, f)
+	} else {
+		r.Truncated = true
+	}
+}
+
+// Checker cross-checks the blob and manifest index against storage.
+type Checker struct {
+	Metadata *metadata.Service
+	Storage  storage.Driver
+}
+
+// Run checks everything and, when repair is set, fixes what can be fixed
+// without losing data:
+//   - unindexed blobs are registered (GC then removes them if unreferenced)
+//   - size mismatches take the stored object's size
+//   - missing blobs no manifest references are dropped from the index
+//   - missing manifest or tag objects are rewritten from a sibling object with
+//     the same verified content
+//
+// Blobs missing from storage that manifests still use, and stored manifests
+// the database does not know, are only reported; the latter are recovered
+// with -rebuild-metadata.
+func (c *Checker) Run(ctx context.Context, repair bool) (*FsckReport, error) {
+	start := time.Now()
+	lister, ok := c.Storage.(storage.Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage driver cannot list objects")
+	}
+	report := &FsckReport{Counts: make(map[string]int), Findings: []Finding{}}
+
+	stored := make(map[string]int64) // blob digest -> size
+	if err := lister.List(ctx, "blobs/", func(p string, size int64) error {
+		if digest := strings.TrimPrefix(p, "blobs/"); digestPattern.MatchString(digest) {
+			stored[digest] = size
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	manifestObjects := make(map[string]bool) // storage path
+	if err := lister.List(ctx, "manifests/", func(p string, size int64) error {
+		manifestObjects[p] = true
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkBlobs(ctx, stored, report, repair); err != nil {
+		return nil, err
+	}
+	if err := c.checkLayers(ctx, stored, report); err != nil {
+		return nil, err
+	}
+	if err := c.checkManifests(ctx, manifestObjects, report, repair); err != nil {
+		return nil, err
+	}
+	if err := c.checkTags(ctx, manifestObjects, report, repair); err != nil {
+		return nil, err
+	}
+
+	report.Duration = time.Since(start).String()
+	fmt.Printf("[Fsck] Checked %d blobs, %d manifests, %d tags: %v (repaired %d)\n",
+		report.BlobsChecked, report.ManifestsChecked, report.TagsChecked, report.Counts, report.Repaired)
+	return report, nil
+}
+
+func (c *Checker) checkBlobs(ctx context.Context, stored map[string]int64, report *FsckReport, repair bool) error {
+	type blobRow struct {
+		digest     string
+		size       int64
+		referenced bool
+	}
+	rows, err := c.Metadata.DB.QueryContext(ctx, `
+		SELECT b.digest, b.size,
+		       EXISTS (SELECT 1 FROM manifest_layers ml WHERE ml.blob_digest = b.digest)
+		    OR EXISTS (SELECT 1 FROM manifests m WHERE m.config_digest = b.digest)
+		FROM blobs b`)
+	if err != nil {
+		return err
+	}
+	var indexed []blobRow
+	for rows.Next() {
+		var b blobRow
+		if err := rows.Scan(&b.digest, &b.size, &b.referenced); err != nil {
+			rows.Close()
+			return err
+		}
+		indexed = append(indexed, b)
+	}
+	rows.Close()
+
+	seen := make(map[string]bool, len(indexed))
+	for _, b := range indexed {
+		report.BlobsChecked++
+		seen[b.digest] = true
+		size, ok := stored[b.digest]
+		switch {
+		case !ok:
+			f := Finding{Kind: BlobMissing, Object: b.digest}
+			if b.referenced {
+				f.Detail = "still referenced by manifests; re-push the affected images"
+			} else if repair {
+				f.Repaired = c.Metadata.DeleteBlob(ctx, b.digest) == nil
+			}
+			report.add(f)
+		case size != b.size:
+			f := Finding{Kind: BlobSizeMismatch, Object: b.digest, Detail: fmt.Sprintf("database %d, storage %d", b.size, size)}
+			if repair {
+				_, err := c.Metadata.DB.ExecContext(ctx, "UPDATE blobs SET size = $2 WHERE digest = $1", b.digest, size)
+				f.Repaired = err == nil
+			}
+			report.add(f)
+		}
+	}
+
+	for digest, size := range stored {
+		if seen[digest] {
+			continue
+		}
+		f := Finding{Kind: BlobUnindexed, Object: digest, Detail: fmt.Sprintf("%d bytes", size)}
+		if repair {
+			f.Repaired = c.Metadata.RegisterBlob(ctx, digest, size, defaultBlobMediaType) == nil
+		}
+		report.add(f)
+	}
+	return nil
+}
+
+func (c *Checker) checkLayers(ctx context.Context, stored map[string]int64, report *FsckReport) error {
+	rows, err := c.Metadata.DB.QueryContext(ctx, `
+		SELECT n.name || '/' || r.name, m.digest, ml.blob_digest
+		FROM manifest_layers ml
+		JOIN manifests m ON m.id = ml.manifest_id
+		JOIN repositories r ON r.id = m.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var repo, digest, layer string
+		if err := rows.Scan(&repo, &digest, &layer); err != nil {
+			return err
+		}
+		if _, ok := stored[layer]; !ok {
+			report.add(Finding{Kind: LayerMissing, Object: repo + "@" + digest, Detail: "layer " + layer})
+		}
+	}
+	return rows.Err()
+}
+
+// candidatePaths lists where repo/reference may be stored: library/
+// repositories can be pushed with or without the prefix.
+func candidatePaths(ns, name, reference string) []string {
+	candidates := []string{path.Join("manifests", ns, name, reference)}
+	if ns == "library" {
+		candidates = append(candidates, path.Join("manifests", name, reference))
+	}
+	return candidates
+}
+
+// storedPath returns the first candidate path present in storage, or the
+// canonical path and false.
+func storedPath(objects map[string]bool, ns, name, reference string) (string, bool) {
+	candidates := candidatePaths(ns, name, reference)
+	for _, p := range candidates {
+		if objects[p] {
+			return p, true
+		}
+	}
+	return candidates[0], false
+}
+
+func (c *Checker) checkManifests(ctx context.Context, objects map[string]bool, report *FsckReport, repair bool) error {
+	type manifestRow struct {
+		ns, name, digest string
+		tags             []string
+	}
+	rows, err := c.Metadata.DB.QueryContext(ctx, `
+		SELECT n.name, r.name, m.digest, COALESCE(array_to_string(array_agg(t.name) FILTER (WHERE t.name IS NOT NULL), ','), '')
+		FROM manifests m
+		JOIN repositories r ON r.id = m.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		LEFT JOIN tags t ON t.manifest_id = m.id
+		GROUP BY n.name, r.name, m.digest`)
+	if err != nil {
+		return err
+	}
+	var manifests []manifestRow
+	for rows.Next() {
+		var m manifestRow
+		var tags string
+		if err := rows.Scan(&m.ns, &m.name, &m.digest, &tags); err != nil {
+			rows.Close()
+			return err
+		}
+		if tags != "" {
+			m.tags = strings.Split(tags, ",")
+		}
+		manifests = append(manifests, m)
+	}
+	rows.Close()
+
+	known := make(map[string]bool)
+	for _, m := range manifests {
+		report.ManifestsChecked++
+		for _, p := range candidatePaths(m.ns, m.name, m.digest) {
+			known[p] = true
+		}
+		p, ok := storedPath(objects, m.ns, m.name, m.digest)
+		if ok {
+			continue
+		}
+		f := Finding{Kind: ManifestMissing, Object: m.ns + "/" + m.name + "@" + m.digest}
+		if repair {
+			for _, tag := range m.tags {
+				if src, ok := storedPath(objects, m.ns, m.name, tag); ok && c.copyVerified(ctx, src, p, m.digest) {
+					f.Repaired = true
+					f.Detail = "restored from tag " + tag
+					objects[p] = true
+					break
+				}
+			}
+		}
+		report.add(f)
+	}
+
+	for p := range objects {
+		ref := path.Base(p)
+		if strings.HasPrefix(ref, "sha256:") && !known[p] {
+			report.add(Finding{Kind: ManifestUnindexed, Object: strings.TrimPrefix(p, "manifests/"), Detail: "recover with -rebuild-metadata"})
+		}
+	}
+	return nil
+}
+
+func (c *Checker) checkTags(ctx context.Context, objects map[string]bool, report *FsckReport, repair bool) error {
+	rows, err := c.Metadata.DB.QueryContext(ctx, `
+		SELECT n.name, r.name, t.name, m.digest
+		FROM tags t
+		JOIN manifests m ON m.id = t.manifest_id
+		JOIN repositories r ON r.id = t.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id`)
+	if err != nil {
+		return err
+	}
+	type tagRow struct{ ns, name, tag, digest string }
+	var tags []tagRow
+	for rows.Next() {
+		var t tagRow
+		if err := rows.Scan(&t.ns, &t.name, &t.tag, &t.digest); err != nil {
+			rows.Close()
+			return err
+		}
+		tags = append(tags, t)
+	}
+	rows.Close()
+
+	for _, t := range tags {
+		report.TagsChecked++
+		p, ok := storedPath(objects, t.ns, t.name, t.tag)
+		if ok {
+			continue
+		}
+		f := Finding{Kind: TagMissing, Object: t.ns + "/" + t.name + ":" + t.tag}
+		if repair {
+			if src, ok := storedPath(objects, t.ns, t.name, t.digest); ok && c.copyVerified(ctx, src, p, t.digest) {
+				f.Repaired = true
+				objects[p] = true
+			}
+		}
+		report.add(f)
+	}
+	return nil
+}
+
+// copyVerified copies src to dst if src's content hashes to digest.
+func (c *Checker) copyVerified(ctx context.Context, src, dst, digest string) bool {
+	reader, err := c.Storage.Reader(ctx, src)
+	if err != nil {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(reader, maxManifestSize+1))
+	reader.Close()
+	if err != nil || len(body) > maxManifestSize {
+		return false
+	}
+	sum := sha256.Sum256(body)
+	if "sha256:"+hex.EncodeToString(sum[:]) != digest {
+		return false
+	}
+
+	writer, err := c.Storage.Writer(ctx, dst)
+	if err != nil {
+		return false
+	}
+	if _, err := writer.Write(body); err != nil {
+		if a, ok := writer.(storage.Aborter); ok {
+			a.Abort()
+		}
+		return false
+	}
+	return writer.Close() == nil
+}
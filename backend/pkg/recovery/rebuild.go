@@ -0,0 +1,277 @@
+// Package recovery rebuilds the metadata database from what is left in object
+// storage, for when Postgres is lost but the bucket survived.
+package recovery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+const (
+	defaultBlobMediaType = "application/octet-stream"
+	maxManifestSize      = 4 << 20
+	maxReportedProblems  = 100
+)
+
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Report summarizes a rebuild.
+type Report struct {
+	Blobs        int        `json:"blobs"`
+	Repositories int        `json:"repositories"`
+	Manifests    int        `json:"manifests"`
+	Tags         int        `json:"tags"`
+	Problems     []string   `json:"problems,omitempty"`
+	Rebuilt      []Manifest `json:"-"` // e.g. to queue rescans
+}
+
+// Manifest identifies a rebuilt manifest.
+type Manifest struct {
+	ID         uuid.UUID
+	Repository string
+	Digest     string
+}
+
+func (r *Report) problem(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Printf("[Recovery] %s\n", msg)
+	if len(r.Problems) < maxReportedProblems {
+		r.Problems = append(r.Problems, msg)
+	}
+}
+
+// Rebuilder repopulates namespaces, repositories, manifests, tags, blobs and
+// layer links by walking manifests/ and blobs/ in storage. It only inserts,
+// so it is safe to run against a partially restored database.
+type Rebuilder struct {
+	Metadata *metadata.Service
+	Storage  storage.Driver
+	Owner    uuid.UUID // owner of recreated repositories
+}
+
+// descriptor is the part of an OCI/Docker descriptor the rebuild needs.
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type parsedManifest struct {
+	Digest    string
+	MediaType string
+	Size      int64 // config + layers, as recorded on push
+	Config    *descriptor
+	Layers    []descriptor
+}
+
+// Run performs the rebuild.
+func (rb *Rebuilder) Run(ctx context.Context) (*Report, error) {
+	lister, ok := rb.Storage.(storage.Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage driver cannot list objects")
+	}
+	report := &Report{}
+
+	// 1. Blob index. Media types are refined from manifest descriptors below.
+	blobs := make(map[string]bool)
+	err := lister.List(ctx, "blobs/", func(p string, size int64) error {
+		digest := strings.TrimPrefix(p, "blobs/")
+		if !digestPattern.MatchString(digest) {
+			return nil
+		}
+		if err := rb.Metadata.RegisterBlob(ctx, digest, size, defaultBlobMediaType); err != nil {
+			return fmt.Errorf("failed to register blob %s: %w", digest, err)
+		}
+		blobs[digest] = true
+		report.Blobs++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	fmt.Printf("[Recovery] Indexed %d blobs\n", report.Blobs)
+
+	// 2. Manifests are stored as manifests/<repository>/<tag or digest>.
+	refs := make(map[string][]string)
+	err = lister.List(ctx, "manifests/", func(p string, size int64) error {
+		repo, ref := path.Split(strings.TrimPrefix(p, "manifests/"))
+		repo = strings.TrimSuffix(repo, "/")
+		if repo == "" || ref == "" {
+			return nil
+		}
+		refs[repo] = append(refs[repo], ref)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	repos := make([]string, 0, len(refs))
+	for repo := range refs {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	for _, repo := range repos {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if err := rb.rebuildRepository(ctx, repo, refs[repo], blobs, report); err != nil {
+			return report, err
+		}
+		report.Repositories++
+	}
+
+	// 3. Parent/child relationships need every manifest's layers in place.
+	for _, m := range report.Rebuilt {
+		if err := rb.Metadata.DetectAndStoreDependencies(ctx, m.ID); err != nil {
+			report.problem("dependency detection failed for %s@%s: %v", m.Repository, m.Digest, err)
+		}
+	}
+
+	fmt.Printf("[Recovery] Rebuilt %d repositories, %d manifests, %d tags\n", report.Repositories, report.Manifests, report.Tags)
+	return report, nil
+}
+
+// rebuildRepository registers digest references first so tags always point
+// at a manifest whose content was verified against its name.
+func (rb *Rebuilder) rebuildRepository(ctx context.Context, repo string, refs []string, blobs map[string]bool, report *Report) error {
+	sort.Slice(refs, func(i, j int) bool {
+		di, dj := strings.HasPrefix(refs[i], "sha256:"), strings.HasPrefix(refs[j], "sha256:")
+		if di != dj {
+			return di
+		}
+		return refs[i] < refs[j]
+	})
+
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		m, err := rb.readManifest(ctx, path.Join("manifests", repo, ref))
+		if err != nil {
+			report.problem("%s:%s: %v", repo, ref, err)
+			continue
+		}
+		isDigest := strings.HasPrefix(ref, "sha256:")
+		if isDigest && ref != m.Digest {
+			report.problem("%s@%s: content digest is %s, skipping", repo, ref, m.Digest)
+			continue
+		}
+
+		manifestID, err := rb.Metadata.RegisterManifest(ctx, repo, ref, m.Digest, m.Size, m.MediaType, rb.Owner)
+		if err != nil {
+			return fmt.Errorf("failed to register %s:%s: %w", repo, ref, err)
+		}
+		if !isDigest {
+			report.Tags++
+		}
+		if seen[m.Digest] {
+			continue
+		}
+		seen[m.Digest] = true
+		report.Manifests++
+		report.Rebuilt = append(report.Rebuilt, Manifest{ID: manifestID, Repository: repo, Digest: m.Digest})
+
+		descs := m.Layers
+		if m.Config != nil {
+			descs = append([]descriptor{*m.Config}, descs...)
+		}
+		for _, d := range descs {
+			if blobs[d.Digest] && d.MediaType != "" {
+				rb.setBlobMediaType(ctx, d.Digest, d.MediaType)
+			}
+		}
+
+		var layers []string
+		missing := 0
+		for _, l := range m.Layers {
+			if blobs[l.Digest] {
+				layers = append(layers, l.Digest)
+			} else {
+				missing++
+			}
+		}
+		if missing > 0 {
+			report.problem("%s@%s: %d layer blobs missing from storage", repo, m.Digest, missing)
+		}
+		if len(layers) > 0 {
+			if err := rb.Metadata.RegisterManifestLayers(ctx, manifestID, layers); err != nil {
+				report.problem("%s@%s: failed to link layers: %v", repo, m.Digest, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (rb *Rebuilder) readManifest(ctx context.Context, p string) (*parsedManifest, error) {
+	reader, err := rb.Storage.Reader(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	body, err := io.ReadAll(io.LimitReader(reader, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxManifestSize {
+		return nil, fmt.Errorf("manifest larger than %d bytes", maxManifestSize)
+	}
+
+	var raw struct {
+		MediaType string          `json:"mediaType"`
+		Config    *descriptor     `json:"config"`
+		Layers    []descriptor    `json:"layers"`
+		Manifests json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("not a JSON manifest: %v", err)
+	}
+
+	sum := sha256.Sum256(body)
+	m := &parsedManifest{
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		MediaType: raw.MediaType,
+		Config:    raw.Config,
+		Layers:    raw.Layers,
+	}
+	if m.MediaType == "" {
+		// OCI allows omitting mediaType; infer it from the shape.
+		if raw.Manifests != nil {
+			m.MediaType = "application/vnd.oci.image.index.v1+json"
+		} else {
+			m.MediaType = "application/vnd.oci.image.manifest.v1+json"
+		}
+	}
+	if m.Config != nil {
+		m.Size += m.Config.Size
+	}
+	for _, l := range m.Layers {
+		m.Size += l.Size
+	}
+	if m.Size == 0 {
+		m.Size = int64(len(body))
+	}
+	return m, nil
+}
+
+// setBlobMediaType replaces the placeholder media type of a blob indexed
+// from storage with the one its manifest declares.
+func (rb *Rebuilder) setBlobMediaType(ctx context.Context, digest, mediaType string) {
+	_, err := rb.Metadata.DB.ExecContext(ctx,
+		"UPDATE blobs SET media_type = $2 WHERE digest = $1 AND media_type = $3",
+		digest, mediaType, defaultBlobMediaType)
+	if err != nil {
+		fmt.Printf("[Recovery] Failed to set media type of %s: %v\n", digest, err)
+	}
+}
@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/errcode"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// Registry actions, as named in token scopes.
+const (
+	actionPull   = "pull"
+	actionPush   = "push"
+	actionDelete = "delete"
+)
+
+// sameRepository compares repository names, bare names being in library.
+func sameRepository(a, b string) bool {
+	if !strings.Contains(a, "/") {
+		a = "library/" + a
+	}
+	if !strings.Contains(b, "/") {
+		b = "library/" + b
+	}
+	return a == b
+}
+
+// access reports whether the caller may perform action on repoName.
+// Registry tokens carry the access /auth/token granted them; dashboard
+// tokens are checked against the caller's role on the repository, as
+// /auth/token would have. unnamed is set for registry tokens granting
+// nothing on the repository, whose client may get one that does.
+func (h *Handler) access(r *http.Request, repoName, action string) (ok, unnamed bool, err error) {
+	if grants, isToken := r.Context().Value(middleware.AccessKey).([]middleware.Access); isToken {
+		unnamed = true
+		for _, g := range grants {
+			if g.Type != "repository" || !sameRepository(g.Name, repoName) {
+				continue
+			}
+			unnamed = false
+			for _, a := range g.Actions {
+				if a == action || a == "*" {
+					return true, false, nil
+				}
+			}
+		}
+		return false, unnamed, nil
+	}
+
+	caller := authz.SubjectFromContext(r.Context())
+	namespace, _ := authz.SplitRepository(repoName)
+	if caller.Admin || (namespace == "library" && action != actionDelete) {
+		return true, false, nil
+	}
+	if h.Authz == nil {
+		return false, false, nil
+	}
+	role, err := h.Authz.RoleFor(r.Context(), caller, repoName)
+	if err != nil {
+		return false, false, err
+	}
+	need := authz.RoleRead
+	if action == actionPush || action == actionDelete {
+		need = authz.RoleWrite
+	}
+	return role.Includes(need), false, nil
+}
+
+// authorize is access writing the OCI error response when it is denied.
+// Handlers return when it is false.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, repoName, action string) bool {
+	ok, unnamed, err := h.access(r, repoName, action)
+	scope := fmt.Sprintf("repository:%s:%s", repoName, action)
+	switch {
+	case ok:
+		return true
+	case err != nil:
+		fmt.Printf("Authorization lookup failed for %s: %v\n", repoName, err)
+		errcode.ServeJSON(w, errcode.Unavailable)
+	case unnamed:
+		middleware.Challenge(w, scope)
+	default:
+		errcode.ServeJSON(w, errcode.Denied.WithDetail(scope))
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"context"
+	"sync"
+)
+
+// digestLocks serializes work on a single blob digest within this process, so
+// concurrent pushes of the same layer write it to storage once and the
+// followers find it already present.
+type digestLocks struct {
+	mu    sync.Mutex
+	locks map[string]*digestLock
+}
+
+type digestLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+func newDigestLocks() *digestLocks {
+	return &digestLocks{locks: make(map[string]*digestLock)}
+}
+
+// Lock blocks until the digest is free or ctx is done. The returned func
+// releases it.
+func (d *digestLocks) Lock(ctx context.Context, digest string) (func(), error) {
+	d.mu.Lock()
+	l, ok := d.locks[digest]
+	if !ok {
+		l = &digestLock{ch: make(chan struct{}, 1)}
+		d.locks[digest] = l
+	}
+	l.refs++
+	d.mu.Unlock()
+
+	select {
+	case l.ch <- struct{}{}:
+	case <-ctx.Done():
+		d.release(digest, l)
+		return nil, ctx.Err()
+	}
+
+	return func() {
+		<-l.ch
+		d.release(digest, l)
+	}, nil
+}
+
+func (d *digestLocks) release(digest string, l *digestLock) {
+	d.mu.Lock()
+	l.refs--
+	if l.refs == 0 {
+		delete(d.locks, digest)
+	}
+	d.mu.Unlock()
+}
@@ -0,0 +1,144 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/errcode"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// DeleteManifest implements DELETE /v2/<name>/manifests/<reference>. A
+// digest deletes the manifest and every tag pointing at it; a tag deletes
+// only the tag. Blobs the manifest leaves unreferenced are removed by the
+// next garbage collection.
+func (h *Handler) DeleteManifest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repoName := vars["name"]
+	reference := vars["reference"]
+	if !h.authorize(w, r, repoName, actionDelete) {
+		return
+	}
+
+	if !strings.Contains(reference, ":") {
+		if err := h.Metadata.DeleteTag(r.Context(), repoName, reference); err != nil {
+			serveDeleteError(w, err, reference)
+			return
+		}
+		h.auditDeletion(r, "DELETE_TAG", repoName, reference)
+		ns, repo := authz.SplitRepository(repoName)
+		h.deleteObjects(r, metadata.ObjectPaths(ns, repo, reference))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if !digestPattern.MatchString(reference) {
+		errcode.ServeJSON(w, errcode.DigestInvalid.WithMessage("invalid digest").WithDetail(reference))
+		return
+	}
+	manifestID, err := h.Metadata.GetManifestID(r.Context(), repoName, reference)
+	if err != nil {
+		serveDeleteError(w, err, reference)
+		return
+	}
+	paths, err := h.Metadata.ManifestObjectPaths(r.Context(), manifestID)
+	if err != nil {
+		fmt.Printf("Failed to list objects of manifest %s: %v\n", reference, err)
+		errcode.ServeJSON(w, err)
+		return
+	}
+	if err := h.Metadata.DeleteManifest(r.Context(), manifestID); err != nil {
+		serveDeleteError(w, err, reference)
+		return
+	}
+	h.auditDeletion(r, "DELETE_MANIFEST", repoName, reference)
+	h.deleteObjects(r, paths)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// DeleteBlob implements DELETE /v2/<name>/blobs/<digest>. Only blobs of the
+// repository (see BlobInRepository) can be deleted through it. Blobs are
+// shared by every repository, so a blob a manifest still references is not
+// deleted: delete the manifests first. Like garbage collection, it leaves
+// blobs uploaded within GC_GRACE_PERIOD alone.
+func (h *Handler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repoName := vars["name"]
+	digest := vars["digest"]
+	if !h.authorize(w, r, repoName, actionDelete) {
+		return
+	}
+	if !digestPattern.MatchString(digest) {
+		errcode.ServeJSON(w, errcode.DigestInvalid.WithMessage("invalid digest").WithDetail(digest))
+		return
+	}
+
+	inRepo, err := h.Metadata.BlobInRepository(r.Context(), repoName, digest)
+	if err != nil {
+		serveDeleteError(w, err, digest)
+		return
+	}
+	if !inRepo {
+		errcode.ServeJSON(w, errcode.BlobUnknown.WithDetail(digest))
+		return
+	}
+
+	blobPath := path.Join("blobs", digest)
+	err = h.Metadata.DeleteUnreferencedBlob(r.Context(), digest)
+	switch {
+	case errors.Is(err, metadata.ErrBlobInUse):
+		errcode.ServeJSON(w, errcode.Denied.WithMessage("blob is referenced by a manifest; delete the manifest first").WithDetail(digest))
+		return
+	case errors.Is(err, metadata.ErrBlobRecent):
+		errcode.ServeJSON(w, errcode.Denied.WithMessage("blob was uploaded too recently; a push may still reference it").WithDetail(digest))
+		return
+	case err != nil:
+		serveDeleteError(w, err, digest)
+		return
+	}
+
+	h.auditDeletion(r, "DELETE_BLOB", repoName, digest)
+	if err := h.Storage.Delete(r.Context(), blobPath); err != nil {
+		// The database no longer references it; fsck reports the leftover.
+		fmt.Printf("Failed to delete blob %s from storage: %v\n", digest, err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveDeleteError writes the OCI error for a failed deletion of reference.
+func serveDeleteError(w http.ResponseWriter, err error, reference string) {
+	switch {
+	case errors.Is(err, metadata.ErrRepositoryNotFound):
+		errcode.ServeJSON(w, errcode.NameUnknown)
+	case errors.Is(err, metadata.ErrManifestNotFound), errors.Is(err, metadata.ErrTagNotFound):
+		errcode.ServeJSON(w, errcode.ManifestUnknown.WithDetail(reference))
+	case errors.Is(err, metadata.ErrBlobNotFound):
+		errcode.ServeJSON(w, errcode.BlobUnknown.WithDetail(reference))
+	default:
+		fmt.Printf("Failed to delete %s: %v\n", reference, err)
+		errcode.ServeJSON(w, err)
+	}
+}
+
+// deleteObjects removes the storage objects of a deleted manifest or tag,
+// logging the ones that remain; the database no longer references them.
+func (h *Handler) deleteObjects(r *http.Request, paths []string) {
+	for _, err := range storage.DeleteAll(r.Context(), h.Storage, paths) {
+		fmt.Printf("[Delete] Failed to delete manifest object %v\n", err)
+	}
+}
+
+// auditDeletion records a deletion in the audit log, where the anomaly
+// detector counts it, as the dashboard's deletions are.
+func (h *Handler) auditDeletion(r *http.Request, action, repository, reference string) {
+	if uid, err := uuid.Parse(getUserFromContext(r)); err == nil && h.Audit != nil {
+		_ = h.Audit.Log(r.Context(), uid, action, nil, map[string]interface{}{"repository": repository, "reference": reference, "source": "registry"})
+	}
+}
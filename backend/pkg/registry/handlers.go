@@ -2,42 +2,67 @@ package registry
 
 import (
 	"context"
+	"database/sql"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
 	"github.com/registryx/registryx/backend/pkg/audit"
+	"github.com/registryx/registryx/backend/pkg/authz"
 	"github.com/registryx/registryx/backend/pkg/config"
+	"github.com/registryx/registryx/backend/pkg/cosign"
+	"github.com/registryx/registryx/backend/pkg/errcode"
+	"github.com/registryx/registryx/backend/pkg/events"
+	"github.com/registryx/registryx/backend/pkg/georeplica"
+	"github.com/registryx/registryx/backend/pkg/lint"
 	"github.com/registryx/registryx/backend/pkg/metadata"
 	"github.com/registryx/registryx/backend/pkg/middleware"
 	"github.com/registryx/registryx/backend/pkg/policy"
+	"github.com/registryx/registryx/backend/pkg/provenance"
 	"github.com/registryx/registryx/backend/pkg/queue"
+	"github.com/registryx/registryx/backend/pkg/quota"
+	"github.com/registryx/registryx/backend/pkg/replication"
 	"github.com/registryx/registryx/backend/pkg/scanner"
 	"github.com/registryx/registryx/backend/pkg/storage"
 	"github.com/registryx/registryx/backend/pkg/webhook"
 )
 
+
 type Handler struct {
-	Config   *config.Config
-	Storage  storage.Driver
-	Metadata *metadata.Service
-	Scanner  *scanner.Service
-	Policy   *policy.Service
-	Queue    *queue.Service
-	Webhook  *webhook.Service
-	Audit    *audit.Service
+	Config      *config.Config
+	Storage     storage.Driver
+	Metadata    *metadata.Service
+	Scanner     *scanner.Service
+	Policy      *policy.Service
+	Queue       *queue.Service
+	Webhook     *webhook.Service
+	Audit       *audit.Service
+	Events      *events.Broker
+	Replicas    *georeplica.Router   // regional blob serving; nil serves everything from Storage
+	Linter      *lint.Linter         // best-practice checks at push time; nil skips them
+	Provenance  *provenance.Reader   // build metadata from pushed attestations; nil skips them
+	Signatures  *cosign.Verifier     // verifies cosign signatures for policies; nil only looks for a signature tag
+	Quota       *quota.Monitor       // quota threshold alerts after pushes; nil leaves them to the periodic check
+	Authz       *authz.Authorizer    // repository roles of dashboard tokens; nil limits them to library
+	Replication *replication.Service // pushes to other registries; nil disables replication
+
+	uploads   *uploadStore
+	blobLocks *digestLocks
 }
 
-func NewHandler(cfg *config.Config, store storage.Driver, meta *metadata.Service, scan *scanner.Service, pol *policy.Service, q *queue.Service, hook *webhook.Service, aud *audit.Service) *Handler {
+func NewHandler(cfg *config.Config, store storage.Driver, meta *metadata.Service, scan *scanner.Service, pol *policy.Service, q *queue.Service, hook *webhook.Service, aud *audit.Service, bus *events.Broker) *Handler {
 	return &Handler{
 		Config:   cfg,
 		Storage:  store,
@@ -47,7 +72,18 @@ func NewHandler(cfg *config.Config, store storage.Driver, meta *metadata.Service
 		Queue:    q,
 		Webhook:  hook,
 		Audit:    aud,
+		Events:   bus,
+		uploads:   newUploadStore(redisClient(q)),
+		blobLocks: newDigestLocks(),
+	}
+}
+
+// redisClient returns the queue's Redis connection, if there is one.
+func redisClient(q *queue.Service) *redis.Client {
+	if q == nil {
+		return nil
 	}
+	return q.Client
 }
 
 // getUserFromContext extracts the authenticated user ID from the request context.
@@ -59,6 +95,16 @@ func getUserFromContext(r *http.Request) string {
 	return "anonymous"
 }
 
+// abortWrite discards a partially written object so a failed upload does not
+// leave a truncated blob (or an open multipart upload) behind.
+func abortWrite(wc io.WriteCloser) {
+	if a, ok := wc.(storage.Aborter); ok {
+		if err := a.Abort(); err != nil {
+			fmt.Printf("Failed to abort storage write: %v\n", err)
+		}
+	}
+}
+
 // BaseCheck implements GET /v2/
 func (h *Handler) BaseCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
@@ -83,7 +129,7 @@ func (h *Handler) Catalog(w http.ResponseWriter, r *http.Request) {
     
 	repos, err := h.Metadata.GetRepositories(r.Context(), userID, userRole)
 	if err != nil {
-		http.Error(w, "Failed to list repositories", http.StatusInternalServerError)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("failed to list repositories"))
 		return
 	}
 	
@@ -103,41 +149,210 @@ func (h *Handler) StartBlobUpload(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	repoName := vars["name"]
 	uploadID := uuid.New().String()
+	if !h.authorize(w, r, repoName, actionPush) {
+		return
+	}
 
 	fmt.Printf("Starting upload for repo: %s (UUID: %s)\n", repoName, uploadID)
 
+	// Cross-repository mounts and monolithic POSTs name the digest up front.
+	// There is nothing to upload when the repository already has the blob,
+	// or when it is mounted from a repository the caller can pull and that
+	// holds it. Anything else, including a mount without from, gets a
+	// regular upload: a blob stored for someone else is only linked once
+	// the client has uploaded it in full.
+	query := r.URL.Query()
+	digest := query.Get("mount")
+	if digest == "" {
+		digest = query.Get("digest")
+	}
+	if digest != "" {
+		linked := h.repositoryBlob(r.Context(), repoName, digest) != nil
+		if from := query.Get("from"); !linked && from != "" && query.Get("mount") != "" && h.canMount(r, from, digest) {
+			if err := h.Metadata.LinkBlob(r.Context(), repoName, digest); err != nil {
+				fmt.Printf("Failed to link blob %s to %s: %v\n", digest, repoName, err)
+			} else {
+				linked = true
+			}
+		}
+		if linked {
+			fmt.Printf("Blob %s already in %s, skipping upload\n", digest, repoName)
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repoName, digest))
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+	}
+
+	// ?direct=true asks for a presigned URL to upload the blob to storage
+	// directly. Without one (disabled, or storage can't presign) the client
+	// gets a regular upload and PATCHes as usual.
+	session, err := newUploadSession(uploadID, repoName)
+	var directURL string
+	if err == nil && r.URL.Query().Get("direct") == "true" {
+		directURL = h.directUploadURL(r.Context(), session)
+		session.Direct = directURL != ""
+	}
+	if err == nil {
+		err = h.uploads.Save(r.Context(), session)
+	}
+	if err != nil {
+		fmt.Printf("Failed to create upload session: %v\n", err)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("failed to start upload"))
+		return
+	}
+
 	// location: /v2/<name>/blobs/uploads/<uuid>
 	location := fmt.Sprintf("/v2/%s/blobs/uploads/%s", repoName, uploadID)
 
+	if directURL != "" {
+		expires := time.Now().Add(time.Duration(h.Config.DirectUploadMinutes) * time.Minute)
+		w.Header().Set("X-Registry-Upload-URL", directURL)
+		w.Header().Set("X-Registry-Upload-Expires", expires.UTC().Format(time.RFC3339))
+	}
 	w.Header().Set("Docker-Upload-UUID", uploadID)
 	w.Header().Set("Location", location)
 	w.Header().Set("Range", "0-0")
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// directUploadURL presigns a PUT of a direct session's blob to storage, or
+// returns "" if direct uploads are disabled or storage can't presign one.
+func (h *Handler) directUploadURL(ctx context.Context, session *uploadSession) string {
+	if h.Config.DirectUploadMinutes <= 0 {
+		return ""
+	}
+	expiry := time.Duration(h.Config.DirectUploadMinutes) * time.Minute
+	u, err := h.Storage.URLFor(ctx, session.stagingPath(), http.MethodPut, expiry)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotSupported) {
+			fmt.Printf("[Upload] Failed to presign direct upload %s: %v\n", session.ID, err)
+		}
+		return ""
+	}
+	return u
+}
+
+// loadUpload fetches the session for an upload URL, writing the OCI error
+// response itself when it cannot be used.
+func (h *Handler) loadUpload(w http.ResponseWriter, r *http.Request, repoName, uploadID string) (*uploadSession, bool) {
+	session, err := h.uploads.Get(r.Context(), uploadID)
+	if err == nil && session.Repository != repoName {
+		err = errUploadUnknown
+	}
+	if err == errUploadUnknown {
+		errcode.ServeJSON(w, errcode.BlobUploadUnknown.WithDetail(uploadID))
+		return nil, false
+	}
+	if err != nil {
+		fmt.Printf("Failed to load upload session %s: %v\n", uploadID, err)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("failed to load upload session"))
+		return nil, false
+	}
+	return session, true
+}
+
+// canMount reports whether a blob may be mounted from another repository:
+// the caller can pull there and the blob belongs to it.
+func (h *Handler) canMount(r *http.Request, from, digest string) bool {
+	if ok, _, _ := h.access(r, from, actionPull); !ok {
+		return false
+	}
+	return h.repositoryBlob(r.Context(), from, digest) != nil
+}
+
+// blobExists reports whether the blob is already stored.
+func (h *Handler) blobExists(ctx context.Context, digest string) bool {
+	return h.lookupBlob(ctx, digest) != nil
+}
+
+// uploadRange formats the Range header for the bytes received so far.
+func uploadRange(offset int64) string {
+	if offset == 0 {
+		return "0-0"
+	}
+	return fmt.Sprintf("0-%d", offset-1)
+}
+
+// GetUploadStatus implements GET /v2/<name>/blobs/uploads/<uuid>
+func (h *Handler) GetUploadStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.authorize(w, r, vars["name"], actionPush) {
+		return
+	}
+	session, ok := h.loadUpload(w, r, vars["name"], vars["uuid"])
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", session.Repository, session.ID))
+	w.Header().Set("Docker-Upload-UUID", session.ID)
+	w.Header().Set("Range", uploadRange(session.Offset))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CancelBlobUpload implements DELETE /v2/<name>/blobs/uploads/<uuid>. The
+// chunks received so far are deleted and the upload can't be resumed.
+func (h *Handler) CancelBlobUpload(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.authorize(w, r, vars["name"], actionPush) {
+		return
+	}
+	session, ok := h.loadUpload(w, r, vars["name"], vars["uuid"])
+	if !ok {
+		return
+	}
+
+	fmt.Printf("Cancelling upload for %s (UUID: %s, %d bytes received)\n", session.Repository, session.ID, session.Offset)
+	h.uploads.Delete(r.Context(), session.ID)
+	session.cleanup(r.Context(), h.Storage)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // PatchBlobData implements PATCH /v2/<name>/blobs/uploads/<uuid>
 func (h *Handler) PatchBlobData(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	repoName := vars["name"]
 	uploadID := vars["uuid"]
+	if !h.authorize(w, r, repoName, actionPush) {
+		return
+	}
 	
 	fmt.Printf("Patching blob for %s (UUID: %s)\n", repoName, uploadID)
-	
-	// Stream request body to temporary storage in MinIO
-	// Path: uploads/<uuid>
-	tempPath := path.Join("uploads", uploadID)
-	
-	writer, err := h.Storage.Writer(r.Context(), tempPath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	session, ok := h.loadUpload(w, r, repoName, uploadID)
+	if !ok {
 		return
 	}
-	defer writer.Close()
-	
-	// Copy data
-	n, err := io.Copy(writer, r.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	// The blob of a direct upload goes to its presigned URL, not through here.
+	if session.Direct {
+		errcode.ServeJSON(w, errcode.BlobUploadInvalid.WithMessage("direct upload: PUT the blob to its presigned URL"))
+		return
+	}
+
+	// Chunks must arrive in order; a client resuming from the wrong offset
+	// gets the current range back and can retry from there.
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		var start, end int64
+		if _, err := fmt.Sscanf(cr, "%d-%d", &start, &end); err != nil || start != session.Offset {
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repoName, uploadID))
+			w.Header().Set("Range", uploadRange(session.Offset))
+			errcode.ServeJSON(w, errcode.RangeInvalid.WithDetail(cr))
+			return
+		}
+	}
+
+	// Each PATCH is stored as its own object under uploads/<uuid>/ and folded
+	// into the running digest as it streams in.
+	if _, err := session.appendChunk(r.Context(), h.Storage, r.Body); err != nil {
+		fmt.Printf("Chunk write failed for %s: %v\n", uploadID, err)
+		errcode.ServeJSON(w, errcode.BlobUploadInvalid.WithMessage("failed to write upload chunk"))
+		return
+	}
+	if err := h.uploads.Save(r.Context(), session); err != nil {
+		fmt.Printf("Failed to save upload session %s: %v\n", uploadID, err)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("failed to save upload session"))
 		return
 	}
 	
@@ -145,7 +360,7 @@ func (h *Handler) PatchBlobData(w http.ResponseWriter, r *http.Request) {
 	location := fmt.Sprintf("/v2/%s/blobs/uploads/%s", repoName, uploadID)
 	w.Header().Set("Location", location)
 	w.Header().Set("Docker-Upload-UUID", uploadID)
-	w.Header().Set("Range", fmt.Sprintf("0-%d", n-1))
+	w.Header().Set("Range", uploadRange(session.Offset))
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -154,99 +369,188 @@ func (h *Handler) PutBlobUpload(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	repoName := vars["name"]
 	uploadID := vars["uuid"]
+	if !h.authorize(w, r, repoName, actionPush) {
+		return
+	}
 	digest := r.URL.Query().Get("digest")
 	
 	fmt.Printf("Finishing upload for %s (UUID: %s, Digest: %s)\n", repoName, uploadID, digest)
 	
 	if digest == "" {
-		http.Error(w, "Digest required", http.StatusBadRequest)
+		errcode.ServeJSON(w, errcode.DigestInvalid.WithMessage("digest query parameter required"))
 		return
 	}
-	
-	// In a real registry, we would concatenate chunks. 
-	// For this MVP, we support Monolithic Upload (PUT with data) by writing directly to final path.
-	// If it was a chunked upload, the data is in uploads/<uuid>, and we should move it.
-	// We'll implementing a hybrid: Try to read body.
-	
-	blobPath := path.Join("blobs", digest)
-	writer, err := h.Storage.Writer(r.Context(), blobPath)
-	if err != nil {
-		fmt.Printf("Storage writer failed: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	session, ok := h.loadUpload(w, r, repoName, uploadID)
+	if !ok {
 		return
 	}
-	defer writer.Close()
 
-	n, err := io.Copy(writer, r.Body)
+	// Only one upload of a digest is finalized at a time. Whoever waited
+	// behind it for the same repository finds the blob there and answers
+	// without reading the body. A blob stored only for other repositories
+	// is still received and verified, so the client proves it holds it.
+	unlock, err := h.blobLocks.Lock(r.Context(), digest)
 	if err != nil {
+		return // client went away while waiting
+	}
+	defer unlock()
+
+	if h.repositoryBlob(r.Context(), repoName, digest) != nil {
+		fmt.Printf("Blob %s already in %s, discarding upload %s\n", digest, repoName, uploadID)
+		session.cleanup(r.Context(), h.Storage)
+		h.uploads.Delete(r.Context(), uploadID)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repoName, digest))
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	// A monolithic upload (or the final chunk) arrives as the PUT body. A
+	// direct upload is already in storage and is hashed from there.
+	if session.Direct {
+		if err := session.receiveDirect(r.Context(), h.Storage); err != nil {
+			fmt.Printf("Direct upload %s not readable: %v\n", uploadID, err)
+			if storage.IsNotExist(err) {
+				errcode.ServeJSON(w, errcode.BlobUploadInvalid.WithMessage("nothing was uploaded to the presigned URL"))
+				return
+			}
+			errcode.ServeJSON(w, errcode.Unknown.WithMessage("failed to read direct upload"))
+			return
+		}
+	} else if _, err := session.appendChunk(r.Context(), h.Storage, r.Body); err != nil {
 		fmt.Printf("Blob write failed: %v\n", err)
-		http.Error(w, "failed to write blob", http.StatusInternalServerError)
+		errcode.ServeJSON(w, errcode.BlobUploadInvalid.WithMessage("failed to write blob"))
+		return
+	}
+
+	// The digest was accumulated while the chunks streamed in, so there is
+	// nothing to re-read here (direct uploads were hashed just above).
+	computed, err := session.Digest()
+	if err != nil {
+		fmt.Printf("Failed to compute digest for upload %s: %v\n", uploadID, err)
+		errcode.ServeJSON(w, err)
+		return
+	}
+	defer func() {
+		session.cleanup(context.Background(), h.Storage)
+		h.uploads.Delete(context.Background(), uploadID)
+	}()
+
+	if computed != digest {
+		fmt.Printf("Digest mismatch for upload %s: expected %s, got %s\n", uploadID, digest, computed)
+		errcode.ServeJSON(w, errcode.DigestInvalid.WithDetail(map[string]string{"expected": digest, "actual": computed}))
+		return
+	}
+
+	// The upload matched a blob stored for other repositories; the stored
+	// copy is the same content, so it is only linked.
+	if !h.blobExists(r.Context(), digest) {
+		blobPath := path.Join("blobs", digest)
+		if err := session.assemble(r.Context(), h.Storage, blobPath); err != nil {
+			fmt.Printf("Blob assembly failed: %v\n", err)
+			errcode.ServeJSON(w, errcode.Unknown.WithMessage("failed to write blob"))
+			return
+		}
+
+		fmt.Printf("Wrote blob %s (%d bytes)\n", digest, session.Offset)
+
+		// Register Blob in DB
+		// We don't know the exact media type at this stage (it's verified at manifest time), so generic.
+		if err := h.Metadata.RegisterBlob(r.Context(), digest, session.Offset, "application/octet-stream"); err != nil {
+			fmt.Printf("Failed to register blob metadata: %v\n", err)
+			// Non-fatal, just stats will be off
+		}
+	}
+	if err := h.Metadata.LinkBlob(r.Context(), repoName, digest); err != nil {
+		fmt.Printf("Failed to link blob %s to %s: %v\n", digest, repoName, err)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("failed to record blob"))
 		return
 	}
-	
-	fmt.Printf("Wrote blob %s (%d bytes)\n", digest, n)
-	
-    // Register Blob in DB
-    // We don't know the exact media type at this stage (it's verified at manifest time), so generic.
-    if err := h.Metadata.RegisterBlob(r.Context(), digest, n, "application/octet-stream"); err != nil {
-        fmt.Printf("Failed to register blob metadata: %v\n", err)
-        // Non-fatal, just stats will be off
-    }
 
 	w.Header().Set("Docker-Content-Digest", digest)
 	w.WriteHeader(http.StatusCreated)
 }
 
+// lookupBlob returns a blob's size and media type from the blobs table,
+// falling back to a storage Stat (and registering the blob) when the database
+// has no record of it. It returns nil if the blob is not stored at all.
+func (h *Handler) lookupBlob(ctx context.Context, digest string) *metadata.BlobInfo {
+	info, err := h.Metadata.GetBlobInfo(ctx, digest)
+	if err == nil {
+		// Blobs registered from a manifest that didn't state their size
+		// have none recorded; HEAD must still report it.
+		if info.Size <= 0 {
+			if size, statErr := h.Storage.Stat(ctx, path.Join("blobs", digest)); statErr == nil {
+				info.Size = size
+			}
+		}
+		return info
+	}
+	if err != sql.ErrNoRows {
+		fmt.Printf("Failed to look up blob %s in DB: %v\n", digest, err)
+	}
+
+	size, statErr := h.Storage.Stat(ctx, path.Join("blobs", digest))
+	if statErr != nil {
+		return nil
+	}
+
+	// SELF-HEALING: Blob exists in storage but not in DB - auto-register it.
+	// This prevents scan failures when DB and storage are out of sync.
+	if err == sql.ErrNoRows {
+		fmt.Printf("[SELF-HEAL] Registering orphaned blob %s (size: %d)\n", digest, size)
+		if err := h.Metadata.RegisterBlob(ctx, digest, size, "application/octet-stream"); err != nil {
+			fmt.Printf("[SELF-HEAL] Failed to register blob %s: %v\n", digest, err)
+		}
+	}
+	return &metadata.BlobInfo{Digest: digest, Size: size, MediaType: "application/octet-stream"}
+}
+
+// repositoryBlob is lookupBlob for a blob of repoName: blobs no manifest of
+// the repository references are unknown to it, even when stored for others.
+func (h *Handler) repositoryBlob(ctx context.Context, repoName, digest string) *metadata.BlobInfo {
+	ok, err := h.Metadata.BlobInRepository(ctx, repoName, digest)
+	if err != nil {
+		fmt.Printf("Failed to look up blob %s in %s: %v\n", digest, repoName, err)
+	}
+	if !ok {
+		return nil
+	}
+	return h.lookupBlob(ctx, digest)
+}
+
+// setBlobHeaders writes the response headers shared by HEAD and GET on a blob.
+func setBlobHeaders(w http.ResponseWriter, info *metadata.BlobInfo) {
+	mediaType := info.MediaType
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	w.Header().Set("Docker-Content-Digest", info.Digest)
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+}
+
 // CheckBlob implements HEAD /v2/<name>/blobs/<digest>
 func (h *Handler) CheckBlob(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	repoName := vars["name"]
 	digest := vars["digest"]
-	
-	blobPath := path.Join("blobs", digest)
-	
-	// Check if blob exists in storage
-	reader, err := h.Storage.Reader(r.Context(), blobPath)
-	if err != nil {
-		fmt.Printf("Blob %s not found in storage for %s\n", digest, repoName)
-		w.WriteHeader(http.StatusNotFound)
+	if !h.authorize(w, r, repoName, actionPull) {
 		return
 	}
-	
-	// Get blob size by reading to the end (or use Stat if available)
-	// For now, we'll close the reader and trust the blob exists
-	var blobSize int64
-	if seeker, ok := reader.(io.ReadSeeker); ok {
-		// If it's seekable, get size efficiently
-		size, err := seeker.Seek(0, io.SeekEnd)
-		if err == nil {
-			blobSize = size
-		}
-		seeker.Seek(0, io.SeekStart)
-	}
-	reader.Close()
-	
-	// SELF-HEALING: Ensure blob is registered in database
-	// This prevents scan failures when DB and storage are out of sync
-	// Check if blob exists in DB, if not, register it
-	exists, err := h.Metadata.BlobExists(r.Context(), digest)
-	if err != nil {
-		fmt.Printf("Failed to check blob existence in DB: %v\n", err)
-	} else if !exists {
-		// Blob exists in storage but not in DB - auto-register it
-		fmt.Printf("[SELF-HEAL] Registering orphaned blob %s (size: %d)\n", digest, blobSize)
-		if err := h.Metadata.RegisterBlob(r.Context(), digest, blobSize, "application/octet-stream"); err != nil {
-			fmt.Printf("[SELF-HEAL] Failed to register blob %s: %v\n", digest, err)
-		} else {
-			fmt.Printf("[SELF-HEAL] Successfully registered blob %s\n", digest)
-		}
-	}
-	
-	// Return 200 OK with Content-Length if we have it
-	if blobSize > 0 {
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", blobSize))
+
+	// Answered from the database so layer existence checks during a push
+	// don't touch object storage.
+	info := h.repositoryBlob(r.Context(), repoName, digest)
+	if info == nil {
+		fmt.Printf("Blob %s not found in storage for %s\n", digest, repoName)
+		errcode.ServeJSON(w, errcode.BlobUnknown.WithDetail(digest))
+		return
 	}
-	w.Header().Set("Docker-Content-Digest", digest)
+
+	setBlobHeaders(w, info)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -254,46 +558,40 @@ func (h *Handler) CheckBlob(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) GetBlob(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	digest := vars["digest"]
+	if !h.authorize(w, r, vars["name"], actionPull) {
+		return
+	}
+
+	info := h.repositoryBlob(r.Context(), vars["name"], digest)
+	if info == nil {
+		errcode.ServeJSON(w, errcode.BlobUnknown.WithDetail(digest))
+		return
+	}
 	
 	// Blob path: blobs/<digest>
 	blobPath := path.Join("blobs", digest)
-	
-	reader, err := h.Storage.Reader(r.Context(), blobPath)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+
+	// Serve from the client's region (or redirect) when configured.
+	setHeaders := func(w http.ResponseWriter) { setBlobHeaders(w, info) }
+	if h.Replicas.ServeBlob(w, r, blobPath, setHeaders) {
 		return
 	}
 	
-	// SELF-HEALING: Ensure blob is registered in database before serving
-	// This prevents scan failures when DB and storage are out of sync
-	exists, err := h.Metadata.BlobExists(r.Context(), digest)
+	reader, err := h.Storage.Reader(r.Context(), blobPath)
 	if err != nil {
-		fmt.Printf("Failed to check blob existence in DB: %v\n", err)
-	} else if !exists {
-		// Get blob size for registration
-		var blobSize int64
-		if seeker, ok := reader.(io.ReadSeeker); ok {
-			size, err := seeker.Seek(0, io.SeekEnd)
-			if err == nil {
-				blobSize = size
-			}
-			seeker.Seek(0, io.SeekStart)
-		}
-		
-		// Blob exists in storage but not in DB - auto-register it
-		fmt.Printf("[SELF-HEAL] Registering orphaned blob %s (size: %d) during GET\n", digest, blobSize)
-		if err := h.Metadata.RegisterBlob(r.Context(), digest, blobSize, "application/octet-stream"); err != nil {
-			fmt.Printf("[SELF-HEAL] Failed to register blob %s: %v\n", digest, err)
-		} else {
-			fmt.Printf("[SELF-HEAL] Successfully registered blob %s\n", digest)
-		}
+		errcode.ServeJSON(w, errcode.BlobUnknown.WithDetail(digest))
+		return
 	}
-	
 	defer reader.Close()
-	
-	w.Header().Set("Docker-Content-Digest", digest)
-	// We should set Content-Type if known, usually application/octet-stream
-	w.Header().Set("Content-Type", "application/octet-stream")
+
+	setBlobHeaders(w, info)
+
+	// Seekable readers get Range support for resumed pulls.
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		w.Header().Del("Content-Length") // ServeContent sets it per range
+		http.ServeContent(w, r, "", time.Time{}, seeker)
+		return
+	}
 	
 	if _, err := io.Copy(w, reader); err != nil {
 		fmt.Printf("Failed to write blob %s: %v\n", digest, err)
@@ -305,12 +603,24 @@ func (h *Handler) PutManifest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	repoName := vars["name"]
 	reference := vars["reference"]
+	if !h.authorize(w, r, repoName, actionPush) {
+		return
+	}
 	
 	fmt.Printf("Put Manifest: %s:%s\n", repoName, reference)
 	
-	body, err := io.ReadAll(r.Body)
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		errcode.ServeJSON(w, errcode.ManifestInvalid.WithMessage("failed to read manifest body"))
+		return
+	}
+
+	// --- Validation ---
+	// Reject malformed manifests before anything is stored or registered.
+	mediaType, err := h.validateManifest(r.Context(), repoName, reference, body, r.Header.Get("Content-Type"))
 	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusInternalServerError)
+		fmt.Printf("Rejected manifest %s:%s: %v\n", repoName, reference, err)
+		errcode.ServeJSON(w, err)
 		return
 	}
 	
@@ -318,12 +628,11 @@ func (h *Handler) PutManifest(w http.ResponseWriter, r *http.Request) {
 		exists, err := h.Metadata.TagExists(r.Context(), repoName, reference)
 		if err != nil {
 			fmt.Printf("Tag check error: %v\n", err)
-			http.Error(w, "internal check error", http.StatusInternalServerError)
+			errcode.ServeJSON(w, err)
 			return
 		}
 		if exists {
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte(`{"errors": [{"code": "TAG_INVALID", "message": "tag is immutable"}]}`))
+			errcode.ServeJSON(w, errcode.Denied.WithMessage("tag is immutable").WithDetail(reference))
 			return
 		}
 	}
@@ -331,7 +640,7 @@ func (h *Handler) PutManifest(w http.ResponseWriter, r *http.Request) {
 	manifestPath := path.Join("manifests", repoName, reference)
 	writer, err := h.Storage.Writer(r.Context(), manifestPath)
 	if err != nil {
-		http.Error(w, "storage error", http.StatusInternalServerError)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("storage error"))
 		return
 	}
 	
@@ -339,19 +648,19 @@ func (h *Handler) PutManifest(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		writer.Close()
 		fmt.Printf("Failed to write manifest to storage: %v\n", err)
-		http.Error(w, "storage write error", http.StatusInternalServerError)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("storage write error"))
 		return
 	}
 	if n != len(body) {
 		writer.Close()
 		fmt.Printf("Incomplete write: wrote %d bytes, expected %d\n", n, len(body))
-		http.Error(w, "storage write incomplete", http.StatusInternalServerError)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("storage write incomplete"))
 		return
 	}
 	
 	if err := writer.Close(); err != nil {
 		fmt.Printf("Failed to close writer: %v\n", err)
-		http.Error(w, "storage close error", http.StatusInternalServerError)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("storage close error"))
 		return
 	}
 	
@@ -367,18 +676,6 @@ func (h *Handler) PutManifest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// --- Media Type Detection ---
-	var manifestMap map[string]interface{}
-	mediaType := "application/vnd.docker.distribution.manifest.v2+json" // Default
-	
-	if err := json.Unmarshal(body, &manifestMap); err == nil {
-		if mt, ok := manifestMap["mediaType"].(string); ok && mt != "" {
-			mediaType = mt
-		} else if schemaVer, ok := manifestMap["schemaVersion"].(float64); ok && schemaVer == 1 {
-			mediaType = "application/vnd.docker.distribution.manifest.v1+json"
-		}
-	}
-
 	// --- Parsing for Stats ---
 	var totalSize int64 = 0
 	type Descriptor struct {
@@ -392,23 +689,23 @@ func (h *Handler) PutManifest(w http.ResponseWriter, r *http.Request) {
 		Layers []Descriptor `json:"layers"`
 	}
 	
-	isV2OrOCI := (mediaType == "application/vnd.docker.distribution.manifest.v2+json" || mediaType == "application/vnd.oci.image.manifest.v1+json")
+	isV2OrOCI := (mediaType == mediaTypeDockerManifest || mediaType == mediaTypeOCIManifest)
 
+	// Recorded together with the manifest below.
+	var blobs []metadata.BlobInfo
+	var layerDigests []string
 	if isV2OrOCI {
 		var m ManifestV2
 		if err := json.Unmarshal(body, &m); err == nil {
-			fmt.Printf("[DEBUG] PutManifest V2/OCI: Config Size=%d, Layers=%d\n", m.Config.Size, len(m.Layers))
-			h.Metadata.RegisterBlob(r.Context(), m.Config.Digest, m.Config.Size, m.Config.MediaType)
+			blobs = append(blobs, metadata.BlobInfo{Digest: m.Config.Digest, Size: m.Config.Size, MediaType: m.Config.MediaType})
 			totalSize += m.Config.Size
 			for _, layer := range m.Layers {
-				h.Metadata.RegisterBlob(r.Context(), layer.Digest, layer.Size, layer.MediaType)
+				blobs = append(blobs, metadata.BlobInfo{Digest: layer.Digest, Size: layer.Size, MediaType: layer.MediaType})
+				layerDigests = append(layerDigests, layer.Digest)
 				totalSize += layer.Size
 			}
-		} else {
-			fmt.Printf("[DEBUG] PutManifest V2/OCI Unmarshal Failed: %v\n", err)
 		}
 	} else {
-		fmt.Printf("[DEBUG] PutManifest Media Type Mismatch: %s\n", mediaType)
 		// V1 or Other - Fallback
 		totalSize = int64(len(body)) 
 	}
@@ -423,57 +720,124 @@ func (h *Handler) PutManifest(w http.ResponseWriter, r *http.Request) {
 	if len(parts) == 2 {
 		nsName = parts[0]
 	}
-	if err := h.Metadata.CheckQuota(r.Context(), nsName, totalSize); err != nil {
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte(fmt.Sprintf(`{"errors": [{"code": "DENIED", "message": "quota exceeded: %v"}]}`, err)))
+	if err := h.Metadata.CheckQuota(r.Context(), nsName, totalSize); errors.Is(err, metadata.ErrQuotaExceeded) {
+		errcode.ServeJSON(w, errcode.Denied.WithMessage(err.Error()))
+		return
+	} else if err != nil {
+		fmt.Printf("[ERROR] CheckQuota failed for %s: %v\n", nsName, err)
+		errcode.ServeJSON(w, errcode.Unavailable)
 		return
 	}
 
 	// Extract User ID from context for namespace ownership
 	var userID uuid.UUID
 	userIDStr := getUserFromContext(r)
-	fmt.Printf("[DEBUG] PutManifest: userIDStr=%s\n", userIDStr)
 	if userIDStr != "anonymous" {
 		if uid, err := uuid.Parse(userIDStr); err == nil {
 			userID = uid
-			fmt.Printf("[DEBUG] PutManifest: Parsed userID=%s\n", userID)
-		} else {
-			fmt.Printf("[DEBUG] PutManifest: Failed to parse userID: %v\n", err)
 		}
 	}
 
-	manifestID, err := h.Metadata.RegisterManifest(r.Context(), repoName, reference, digest, totalSize, mediaType, userID)
+	// Signatures, SBOMs and attestations name the manifest they belong to.
+	subject, artifactType, annotations := manifestSubject(body)
+
+	// Blobs, manifest, tag, layers and dependencies (V2/OCI only) are
+	// recorded in one transaction, so a failure leaves no half-registered
+	// manifest or dangling tag. The resource limits (repositories, tags,
+	// manifests, repository size) are checked in it too.
+	manifestID, err := h.Metadata.RegisterPush(r.Context(), metadata.Push{
+		Repository:   repoName,
+		Reference:    reference,
+		Digest:       digest,
+		Size:         totalSize,
+		MediaType:    mediaType,
+		Owner:        userID,
+		Blobs:        blobs,
+		Layers:       layerDigests,
+		Subject:      subject,
+		ArtifactType: artifactType,
+		Annotations:  annotations,
+	})
+	var limitErr *metadata.LimitError
+	if errors.As(err, &limitErr) {
+		errcode.ServeJSON(w, errcode.Denied.WithMessage(limitErr.Error()))
+		return
+	}
 	if err != nil {
-		fmt.Printf("[ERROR] RegisterManifest failed: %v\n", err)
-		http.Error(w, fmt.Sprintf("Metadata registration failed: %v", err), http.StatusInternalServerError)
+		fmt.Printf("[ERROR] RegisterPush failed: %v\n", err)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("metadata registration failed"))
 		return
 	}
 
-	// --- Dependency Detection (V2/OCI Only) ---
-	if isV2OrOCI {
-		var m ManifestV2
-		if err := json.Unmarshal(body, &m); err == nil && len(m.Layers) > 0 {
-			layerDigests := make([]string, len(m.Layers))
-			for i, l := range m.Layers {
-				layerDigests[i] = l.Digest
-			}
-			h.Metadata.RegisterManifestLayers(r.Context(), manifestID, layerDigests)
-			h.Metadata.DetectAndStoreDependencies(r.Context(), manifestID)
+	// --- Best-Practice Lint (V2/OCI Only) ---
+	if isV2OrOCI && h.Linter != nil {
+		if findings, err := h.Linter.Lint(r.Context(), body); err != nil {
+			fmt.Printf("[Lint] Failed to lint %s:%s: %v\n", repoName, reference, err)
+		} else if err := h.Metadata.StoreLintFindings(r.Context(), manifestID, findings); err != nil {
+			fmt.Printf("[Lint] Failed to store findings for %s: %v\n", manifestID, err)
 		}
-	} else {
-		fmt.Printf("Skipping dependency detection for %s (MediaType: %s)\n", manifestID, mediaType)
 	}
 	
 	if h.Queue != nil {
 		h.Queue.EnqueueScan(r.Context(), manifestID, repoName, reference)
 	}
 
+	// --- Provenance Attestations ---
+	if h.Provenance != nil {
+		results, err := h.Provenance.FromManifest(r.Context(), repoName, body)
+		if err != nil {
+			fmt.Printf("[Provenance] Failed to read attestations in %s@%s: %v\n", repoName, digest, err)
+		}
+		reporter, _ := r.Context().Value(middleware.UsernameKey).(string)
+		for i, res := range results {
+			build := res.Build
+			build.ReportedBy = reporter
+			if err := h.Metadata.RecordProvenance(r.Context(), repoName, res.Digest, &build); err != nil {
+				fmt.Printf("[Provenance] Failed to record build for %s: %v\n", res.Digest, err)
+			}
+			// Every platform in an index comes from the same build, so the
+			// index (what the tag points at) gets the first one's metadata.
+			if i == 0 && res.Digest != digest && mediaType != mediaTypeDockerManifest && mediaType != mediaTypeOCIManifest {
+				if err := h.Metadata.RecordProvenance(r.Context(), repoName, digest, &build); err != nil {
+					fmt.Printf("[Provenance] Failed to record build for %s: %v\n", digest, err)
+				}
+			}
+		}
+	}
+
 	if h.Webhook != nil {
+		build, err := h.Metadata.GetBuildMetadata(r.Context(), repoName, digest)
+		if err != nil {
+			fmt.Printf("Failed to load build metadata for %s: %v\n", digest, err)
+		}
 		go h.Webhook.Notify(context.Background(), webhook.Event{
-			Action: "push", Repository: repoName, Tag: reference, Digest: digest, Timestamp: time.Now(), User: getUserFromContext(r),
+			Action: "push", Repository: repoName, Tag: reference, Digest: digest, Timestamp: time.Now(), User: getUserFromContext(r), Build: build,
 		})
 	}
 
+	h.Events.Publish(events.Event{
+		Type: events.TypePush, Repository: repoName, Reference: reference, Digest: digest, User: getUserFromContext(r),
+		Data: map[string]interface{}{"size": totalSize, "mediaType": mediaType},
+	})
+
+	if h.Signatures != nil {
+		h.Signatures.Pushed(repoName, reference, subject)
+	}
+
+	if h.Replication != nil {
+		if err := h.Replication.Enqueue(r.Context(), repoName, reference, digest); err != nil {
+			fmt.Printf("[Replication] Failed to queue %s:%s: %v\n", repoName, reference, err)
+		}
+	}
+
+	if h.Quota != nil {
+		go func() {
+			if err := h.Quota.Check(context.Background(), nsName); err != nil {
+				fmt.Printf("[Quota] Failed to check %s: %v\n", nsName, err)
+			}
+		}()
+	}
+
 	if h.Audit != nil {
 		userIDStr := getUserFromContext(r)
 		if userIDStr != "anonymous" {
@@ -484,20 +848,38 @@ func (h *Handler) PutManifest(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	w.Header().Set("Docker-Content-Digest", digest)
+	if subject != "" {
+		// Tells the client this registry serves the referrers API, so it
+		// need not maintain a referrers tag itself.
+		w.Header().Set("OCI-Subject", subject)
+	}
 	w.WriteHeader(http.StatusCreated)
 }
 
-// GetManifest implements GET /v2/<name>/manifests/<reference>
+// GetManifest implements GET and HEAD /v2/<name>/manifests/<reference>. HEAD
+// answers with the same headers, including Content-Length, and no body.
 func (h *Handler) GetManifest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	repoName := vars["name"]
 	reference := vars["reference"]
+	if !h.authorize(w, r, repoName, actionPull) {
+		return
+	}
 	
 	// 1. Resolve Manifest ID & Details to get correct Content-Type
 	// We do this FIRST to set headers properly.
 	manifestID, err := h.Metadata.GetManifestID(r.Context(), repoName, reference)
-	if err != nil || manifestID == uuid.Nil {
-		w.WriteHeader(http.StatusNotFound)
+	if errors.Is(err, metadata.ErrRepositoryNotFound) {
+		errcode.ServeJSON(w, errcode.NameUnknown.WithDetail(repoName))
+		return
+	}
+	if errors.Is(err, metadata.ErrManifestNotFound) || (err == nil && manifestID == uuid.Nil) {
+		errcode.ServeJSON(w, errcode.ManifestUnknown.WithDetail(reference))
+		return
+	}
+	if err != nil {
+		fmt.Printf("[ERROR] GetManifest: failed to resolve %s:%s: %v\n", repoName, reference, err)
+		errcode.ServeJSON(w, errcode.Unavailable)
 		return
 	}
 	
@@ -511,29 +893,37 @@ func (h *Handler) GetManifest(w http.ResponseWriter, r *http.Request) {
 	// Fetch from storage
 	manifestPath := path.Join("manifests", repoName, reference)
 	// Try resolve path if needed (SmartResolve)
-	_, errStat := h.Storage.Stat(r.Context(), manifestPath)
+	size, errStat := h.Storage.Stat(r.Context(), manifestPath)
 	if errStat != nil {
 		// Try alternate
 		altName := ""
 		if strings.HasPrefix(repoName, "library/") { altName = strings.TrimPrefix(repoName, "library/") } else { altName = "library/" + repoName }
 		altPath := path.Join("manifests", altName, reference)
-		if _, errAlt := h.Storage.Stat(r.Context(), altPath); errAlt == nil {
+		if altSize, errAlt := h.Storage.Stat(r.Context(), altPath); errAlt == nil {
 			repoName = altName
 			manifestPath = altPath
+			size, errStat = altSize, nil
 		}
 	}
-	
-	reader, err := h.Storage.Reader(r.Context(), manifestPath)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+
+	// HEAD is answered from the Stat unless the digest has to be computed
+	// from the manifest itself.
+	var reader io.ReadCloser
+	if r.Method == http.MethodHead && digest != "" {
+		if errStat != nil {
+			errcode.ServeJSON(w, errcode.ManifestUnknown.WithDetail(reference))
+			return
+		}
+	} else {
+		reader, err = h.Storage.Reader(r.Context(), manifestPath)
+		if err != nil {
+			errcode.ServeJSON(w, errcode.ManifestUnknown.WithDetail(reference))
+			return
+		}
+		defer reader.Close()
 	}
-	defer reader.Close()
 	
 	w.Header().Set("Content-Type", mediaType)
-	if digest != "" {
-		w.Header().Set("Docker-Content-Digest", digest)
-	}
 	
 	// --- Policy Enforcement ---
 	// 1. Resolve Manifest UUID (Already done above for Content-Type)
@@ -545,10 +935,9 @@ func (h *Handler) GetManifest(w http.ResponseWriter, r *http.Request) {
 		if err == nil {
 			// 3. Check Signature (Cosign)
 			var isSigned bool
+			var signatures []cosign.Identity
 			if digest, err := h.Metadata.GetDigest(r.Context(), manifestID); err == nil {
-				// We have a digest, let's look for the .sig tag
-				signed, _ := h.Metadata.HasSignature(r.Context(), repoName, digest)
-				isSigned = signed
+				isSigned, signatures = h.verifySignatures(r.Context(), repoName, digest)
 			}
 
 			// 4. Evaluate Policy
@@ -559,12 +948,20 @@ func (h *Handler) GetManifest(w http.ResponseWriter, r *http.Request) {
 				Repository: repoName,
 				Tag:        reference,
 				User:       user,
-				Environment: h.Config.PolicyEnvironment,
+				Environment: h.policyEnvironment(r, repoName),
 				Vulnerabilities: policy.VulnerabilitySummary{
 					Critical: summary.Critical,
 					High:     summary.High,
 				},
 				IsSigned: isSigned,
+				Signatures: signatures,
+			}
+			if score, err := h.Metadata.GetHealthScore(r.Context(), manifestID); err == nil {
+				input.HealthScore = score.Overall
+				input.HealthGrade = score.Grade
+			}
+			if findings, ok, err := h.Metadata.GetLintFindings(r.Context(), manifestID); err == nil && ok {
+				input.Lint = findings
 			}
 			
 			allowed, violations, err := h.Policy.Evaluate(r.Context(), input)
@@ -573,45 +970,83 @@ func (h *Handler) GetManifest(w http.ResponseWriter, r *http.Request) {
 				// Open fail? or Fail closed? Let's fail open for errors to avoid blocking prod on bug.
 			} else if !allowed {
 				log.Printf("Policy DENIED pull for %s:%s. Violations: %v\n", repoName, reference, violations)
+				h.Events.Publish(events.Event{
+					Type: events.TypePolicyDenied, Repository: repoName, Reference: reference, Digest: digest, User: user,
+					Data: map[string]interface{}{"violations": violations},
+				})
+				if h.Audit != nil {
+					// Kept as compliance evidence of enforcement; anonymous pulls have no user
+					uid, _ := uuid.Parse(user)
+					h.Audit.Log(r.Context(), uid, "POLICY_DENIED", nil, map[string]interface{}{"repository": repoName, "reference": reference, "digest": digest, "violations": violations, "source": "pull"})
+				}
 				
 				// Return 403 Forbidden with OCI Error
-				w.WriteHeader(http.StatusForbidden)
-				jsonErrors := fmt.Sprintf(`{"errors": [{"code": "DENIED", "message": "policy violation: %s"}]}`, strings.Join(violations, "; "))
-				w.Write([]byte(jsonErrors))
+				errcode.ServeJSON(w, errcode.Denied.WithMessage("policy violation: "+strings.Join(violations, "; ")).WithDetail(violations))
 				return
 			}
 			
 			// Policy passed (or fail-open on error) - Track Pull (Only on GET/Download)
 			if r.Method == http.MethodGet {
-				if err := h.Metadata.TrackPull(r.Context(), manifestID); err != nil {
+				if err := h.Metadata.TrackPull(r.Context(), manifestID, reference); err != nil {
 					fmt.Printf("Failed to track pull for %s: %v\n", manifestID, err)
 				}
 			}
 		}
 	}
 
+	if reader == nil {
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	manifestBytes, err := io.ReadAll(reader)
 	if err != nil {
-		http.Error(w, "Failed to read manifest", http.StatusInternalServerError)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("failed to read manifest"))
+		return
+	}
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(manifestBytes))
+	}
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(manifestBytes)))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 	w.Write(manifestBytes)
 }
 
+// policyEnvironment returns the environment policies see for repoName: its
+// namespace's setting if it has one, otherwise the global default.
+func (h *Handler) policyEnvironment(r *http.Request, repoName string) string {
+	env, err := h.Metadata.GetRepositoryEnvironment(r.Context(), repoName)
+	if err != nil {
+		log.Printf("Failed to load namespace environment for %s: %v\n", repoName, err)
+	}
+	if env == "" {
+		return h.Config.PolicyEnvironment
+	}
+	return env
+}
+
 // Tags implements GET /v2/<name>/tags/list
 func (h *Handler) Tags(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	repoName := vars["name"]
+	if !h.authorize(w, r, repoName, actionPull) {
+		return
+	}
 
 	tags, err := h.Metadata.GetTags(r.Context(), repoName)
 	if err != nil {
 		// If repo not found, return 404
-		if strings.Contains(err.Error(), "repository not found") {
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(`{"errors": [{"code": "NAME_UNKNOWN", "message": "repository name not known to registry"}]}`))
+		if errors.Is(err, metadata.ErrRepositoryNotFound) {
+			errcode.ServeJSON(w, errcode.NameUnknown.WithDetail(repoName))
 			return
 		}
-		http.Error(w, "Failed to list tags", http.StatusInternalServerError)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("failed to list tags"))
 		return
 	}
 
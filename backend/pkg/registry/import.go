@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/errcode"
+	"github.com/registryx/registryx/backend/pkg/middleware"
+)
+
+// Content pulled from another registry by an import is stored through the
+// handler, so it goes through the same checks and side effects (validation,
+// quotas and limits, scans, webhooks, audit) as a push.
+
+// HasBlob reports whether repoName already has the blob.
+func (h *Handler) HasBlob(ctx context.Context, repoName, digest string) bool {
+	return h.repositoryBlob(ctx, repoName, digest) != nil
+}
+
+// ImportBlob stores a blob of repoName read from body, verifying it against
+// digest. A blob the repository got meanwhile from a push is kept and body
+// is left unread; one stored only for other repositories is verified
+// against body and linked, and reported as 0 bytes stored.
+func (h *Handler) ImportBlob(ctx context.Context, repoName, digest, mediaType string, body io.Reader) (int64, error) {
+	alg, want, ok := strings.Cut(digest, ":")
+	if !ok || alg != "sha256" {
+		return 0, fmt.Errorf("unsupported digest %q", digest)
+	}
+
+	unlock, err := h.blobLocks.Lock(ctx, digest)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if h.HasBlob(ctx, repoName, digest) {
+		return 0, nil
+	}
+
+	stored := h.blobExists(ctx, digest)
+	var writer io.WriteCloser
+	dst := io.Discard
+	if !stored {
+		if writer, err = h.Storage.Writer(ctx, path.Join("blobs", digest)); err != nil {
+			return 0, err
+		}
+		dst = writer
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, hash), body)
+	if err == nil {
+		if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+			err = fmt.Errorf("digest mismatch: expected %s, got sha256:%s", digest, got)
+		}
+	}
+	if err != nil {
+		if writer != nil {
+			abortWrite(writer)
+		}
+		return 0, err
+	}
+	if !stored {
+		if err := writer.Close(); err != nil {
+			return 0, err
+		}
+		if err := h.Metadata.RegisterBlob(ctx, digest, n, mediaType); err != nil {
+			fmt.Printf("Failed to register blob metadata: %v\n", err)
+		}
+	}
+	if err := h.Metadata.LinkBlob(ctx, repoName, digest); err != nil {
+		return 0, fmt.Errorf("link blob: %w", err)
+	}
+	if stored {
+		return 0, nil
+	}
+	return n, nil
+}
+
+// ImportManifest pushes a manifest as userID and returns its digest. The
+// blobs and child manifests it references must be stored first.
+func (h *Handler) ImportManifest(ctx context.Context, repoName, reference, contentType string, body []byte, userID uuid.UUID) (string, error) {
+	ctx = context.WithValue(ctx, middleware.UserKey, userID.String())
+	r, err := http.NewRequestWithContext(ctx, http.MethodPut, "/v2/"+repoName+"/manifests/"+reference, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	r.Header.Set("Content-Type", contentType)
+	r = mux.SetURLVars(r, map[string]string{"name": repoName, "reference": reference})
+
+	rec := httptest.NewRecorder()
+	h.PutManifest(rec, r)
+	if rec.Code == http.StatusCreated {
+		return rec.Header().Get("Docker-Content-Digest"), nil
+	}
+
+	var envelope struct {
+		Errors []struct {
+			Code    string      `json:"code"`
+			Message string      `json:"message"`
+			Detail  interface{} `json:"detail"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(rec.Body.Bytes(), &envelope) == nil && len(envelope.Errors) > 0 {
+		e := envelope.Errors[0]
+		if e.Detail != nil {
+			return "", fmt.Errorf("%s: %s (%v)", e.Code, e.Message, e.Detail)
+		}
+		return "", fmt.Errorf("%s: %s", e.Code, e.Message)
+	}
+	return "", fmt.Errorf("%s: push failed with status %d", errcode.Unknown.Value, rec.Code)
+}
@@ -0,0 +1,186 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/registryx/registryx/backend/pkg/errcode"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+)
+
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// Maximum manifest body accepted, matching the distribution reference implementation.
+const maxManifestSize = 4 * 1024 * 1024
+
+var digestPattern = regexp.MustCompile(`^(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+
+func invalidManifest(format string, args ...interface{}) error {
+	return errcode.ManifestInvalid.WithDetail(fmt.Sprintf(format, args...))
+}
+
+type manifestDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+}
+
+type manifestBody struct {
+	SchemaVersion *int                 `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType"`
+	ArtifactType  string               `json:"artifactType"`
+	Config        *manifestDescriptor  `json:"config"`
+	Layers        []manifestDescriptor `json:"layers"`
+	Manifests     []manifestDescriptor `json:"manifests"`
+	Subject       *manifestDescriptor  `json:"subject"`
+	Annotations   map[string]string    `json:"annotations"`
+}
+
+// manifestSubject returns the digest of the manifest a pushed artifact names
+// as its subject, the artifact's type (artifactType, or the config media
+// type of manifests without one) and its annotations. All are empty for
+// manifests without a subject.
+func manifestSubject(body []byte) (subject, artifactType string, annotations map[string]string) {
+	var m manifestBody
+	if err := json.Unmarshal(body, &m); err != nil || m.Subject == nil {
+		return "", "", nil
+	}
+	artifactType = m.ArtifactType
+	if artifactType == "" && m.Config != nil {
+		artifactType = m.Config.MediaType
+	}
+	return m.Subject.Digest, artifactType, m.Annotations
+}
+
+// validateManifest checks a pushed manifest's structure and that everything it
+// references is already in the registry. It returns the effective media type.
+func (h *Handler) validateManifest(ctx context.Context, repoName, reference string, body []byte, contentType string) (string, error) {
+	if len(body) == 0 {
+		return "", invalidManifest("empty manifest body")
+	}
+	if len(body) > maxManifestSize {
+		return "", invalidManifest("manifest exceeds %d bytes", maxManifestSize)
+	}
+
+	// A push by digest must match what was sent.
+	if strings.HasPrefix(reference, "sha256:") {
+		sum := sha256.Sum256(body)
+		if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != reference {
+			return "", errcode.DigestInvalid.WithDetail(map[string]string{"expected": reference, "actual": actual})
+		}
+	}
+
+	var m manifestBody
+	if err := json.Unmarshal(body, &m); err != nil {
+		return "", invalidManifest("manifest is not valid JSON: %v", err)
+	}
+	if m.SchemaVersion == nil {
+		return "", invalidManifest("missing schemaVersion")
+	}
+	if *m.SchemaVersion != 2 {
+		return "", invalidManifest("unsupported schemaVersion %d", *m.SchemaVersion)
+	}
+	// The subject need not be pushed yet, but must be a manifest digest.
+	if m.Subject != nil && !digestPattern.MatchString(m.Subject.Digest) {
+		return "", invalidManifest("subject has invalid digest %q", m.Subject.Digest)
+	}
+
+	// The body's mediaType wins; OCI manifests may omit it and rely on Content-Type.
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(contentType)
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = contentType
+	} else if contentType != "" && contentType != mediaType && contentType != "application/json" {
+		return "", invalidManifest("mediaType %q does not match Content-Type %q", mediaType, contentType)
+	}
+	if mediaType == "" {
+		if m.Manifests != nil {
+			mediaType = mediaTypeOCIIndex
+		} else {
+			mediaType = mediaTypeOCIManifest
+		}
+	}
+
+	switch mediaType {
+	case mediaTypeDockerManifest, mediaTypeOCIManifest:
+		return mediaType, h.validateImageManifest(ctx, repoName, &m)
+	case mediaTypeDockerManifestList, mediaTypeOCIIndex:
+		return mediaType, h.validateIndex(ctx, repoName, &m)
+	default:
+		return "", invalidManifest("unsupported manifest media type %q", mediaType)
+	}
+}
+
+func (h *Handler) validateImageManifest(ctx context.Context, repoName string, m *manifestBody) error {
+	if m.Config == nil {
+		return invalidManifest("image manifest has no config descriptor")
+	}
+	if m.Manifests != nil {
+		return invalidManifest("image manifest must not contain a manifests list")
+	}
+
+	descriptors := append([]manifestDescriptor{*m.Config}, m.Layers...)
+	for i, d := range descriptors {
+		field := "config"
+		if i > 0 {
+			field = fmt.Sprintf("layers[%d]", i-1)
+		}
+		if !digestPattern.MatchString(d.Digest) {
+			return invalidManifest("%s has invalid digest %q", field, d.Digest)
+		}
+		if d.Size < 0 {
+			return invalidManifest("%s has negative size", field)
+		}
+	}
+
+	for _, d := range descriptors {
+		// Foreign layers live outside the registry (e.g. Windows base layers).
+		if strings.Contains(d.MediaType, "foreign") {
+			continue
+		}
+		// Only blobs of this repository: a digest alone doesn't give access
+		// to a blob uploaded for another.
+		if h.repositoryBlob(ctx, repoName, d.Digest) == nil {
+			return errcode.ManifestBlobUnknown.WithDetail(d.Digest)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) validateIndex(ctx context.Context, repoName string, m *manifestBody) error {
+	if m.Config != nil || m.Layers != nil {
+		return invalidManifest("manifest index must not contain config or layers")
+	}
+	for i, d := range m.Manifests {
+		if !digestPattern.MatchString(d.Digest) {
+			return invalidManifest("manifests[%d] has invalid digest %q", i, d.Digest)
+		}
+		if d.Size < 0 {
+			return invalidManifest("manifests[%d] has negative size", i)
+		}
+	}
+	for _, d := range m.Manifests {
+		_, err := h.Metadata.GetManifestID(ctx, repoName, d.Digest)
+		if errors.Is(err, metadata.ErrRepositoryNotFound) || errors.Is(err, metadata.ErrManifestNotFound) {
+			return errcode.ManifestBlobUnknown.WithMessage("referenced manifest unknown to repository").WithDetail(d.Digest)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
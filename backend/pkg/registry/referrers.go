@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/registryx/registryx/backend/pkg/errcode"
+)
+
+// referrerDescriptor describes an artifact in a referrers index.
+type referrerDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// referrersIndex is the image index the referrers API answers with.
+type referrersIndex struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType"`
+	Manifests     []referrerDescriptor `json:"manifests"`
+}
+
+// Referrers implements GET /v2/<name>/referrers/<digest>: an image index of
+// the artifacts (signatures, SBOMs, attestations) pushed with digest as
+// their subject, newest first. ?artifactType= keeps only artifacts of that
+// type. A subject nothing refers to, or that was never pushed, gets an
+// empty index.
+func (h *Handler) Referrers(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repoName := vars["name"]
+	digest := vars["digest"]
+	if !h.authorize(w, r, repoName, actionPull) {
+		return
+	}
+	if !digestPattern.MatchString(digest) {
+		errcode.ServeJSON(w, errcode.DigestInvalid.WithMessage("invalid digest").WithDetail(digest))
+		return
+	}
+
+	artifactType := r.URL.Query().Get("artifactType")
+	referrers, err := h.Metadata.ListReferrers(r.Context(), repoName, digest, artifactType)
+	if err != nil {
+		fmt.Printf("Failed to list referrers of %s@%s: %v\n", repoName, digest, err)
+		errcode.ServeJSON(w, errcode.Unknown.WithMessage("failed to list referrers"))
+		return
+	}
+
+	index := referrersIndex{SchemaVersion: 2, MediaType: mediaTypeOCIIndex, Manifests: []referrerDescriptor{}}
+	for _, ref := range referrers {
+		index.Manifests = append(index.Manifests, referrerDescriptor{
+			MediaType:    ref.MediaType,
+			Digest:       ref.Digest,
+			Size:         ref.Size,
+			ArtifactType: ref.ArtifactType,
+			Annotations:  ref.Annotations,
+		})
+	}
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", mediaTypeOCIIndex)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	json.NewEncoder(w).Encode(index)
+}
@@ -0,0 +1,27 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/registryx/registryx/backend/pkg/cosign"
+)
+
+// verifySignatures reports whether digest is signed, with the identities of
+// the cosign signatures that verified. Without a verifier only the
+// signature tag is looked for.
+func (h *Handler) verifySignatures(ctx context.Context, repoName, digest string) (bool, []cosign.Identity) {
+	if h.Signatures == nil {
+		signed, _ := h.Metadata.HasSignature(ctx, repoName, digest)
+		return signed, nil
+	}
+	res, err := h.Signatures.Verify(ctx, repoName, digest)
+	if err != nil {
+		fmt.Printf("[Cosign] Failed to verify signatures of %s@%s: %v\n", repoName, digest, err)
+		return false, nil
+	}
+	for _, e := range res.Errors {
+		fmt.Printf("[Cosign] Signature of %s@%s not verified: %s\n", repoName, digest, e)
+	}
+	return res.Signed, res.Verified
+}
@@ -0,0 +1,255 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+const (
+	uploadKeyPrefix = "registryx:upload:"
+	uploadTTL       = 24 * time.Hour
+)
+
+var errUploadUnknown = errors.New("blob upload unknown")
+
+// uploadSession is the persisted state of a chunked blob upload. HashState is
+// the marshaled sha256 of all bytes received so far, so the final PUT can
+// verify the digest without reading the chunks back from storage.
+//
+// A direct session is uploaded by the client straight to object storage at a
+// presigned URL for stagingPath; the registry only sees it on completion.
+type uploadSession struct {
+	ID         string    `json:"id"`
+	Repository string    `json:"repository"`
+	Offset     int64     `json:"offset"`
+	HashState  []byte    `json:"hash_state"`
+	Chunks     []string  `json:"chunks"` // storage paths, in upload order
+	Direct     bool      `json:"direct,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+func newUploadSession(id, repoName string) (*uploadSession, error) {
+	state, err := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &uploadSession{ID: id, Repository: repoName, HashState: state, StartedAt: time.Now()}, nil
+}
+
+func (u *uploadSession) hasher() (hash.Hash, error) {
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(u.HashState); err != nil {
+		return nil, fmt.Errorf("corrupt upload hash state: %w", err)
+	}
+	return h, nil
+}
+
+// Digest returns the sha256 digest of everything uploaded so far.
+func (u *uploadSession) Digest() (string, error) {
+	h, err := u.hasher()
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// appendChunk streams r into a new chunk object while hashing it, and
+// advances the session. The session is only modified if the write succeeds.
+func (u *uploadSession) appendChunk(ctx context.Context, store storage.Driver, r io.Reader) (int64, error) {
+	h, err := u.hasher()
+	if err != nil {
+		return 0, err
+	}
+
+	chunkPath := path.Join("uploads", u.ID, fmt.Sprintf("%06d", len(u.Chunks)))
+	writer, err := store.Writer(ctx, chunkPath)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(writer, io.TeeReader(r, h))
+	if err != nil {
+		abortWrite(writer)
+		return n, err
+	}
+	if err := writer.Close(); err != nil {
+		return n, err
+	}
+	if n == 0 {
+		// Nothing arrived; don't keep an empty object around.
+		store.Delete(ctx, chunkPath)
+		return 0, nil
+	}
+
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return n, err
+	}
+	u.HashState = state
+	u.Offset += n
+	u.Chunks = append(u.Chunks, chunkPath)
+	return n, nil
+}
+
+// stagingPath is where the client of a direct session uploads the blob.
+func (u *uploadSession) stagingPath() string {
+	return path.Join("uploads", u.ID, "direct")
+}
+
+// receiveDirect hashes the object a direct session's client uploaded and
+// makes it the session's only chunk. The bytes never passed through the
+// registry, so this reads them back from storage once.
+func (u *uploadSession) receiveDirect(ctx context.Context, store storage.Driver) error {
+	staged := u.stagingPath()
+	if _, err := store.Stat(ctx, staged); err != nil {
+		return err
+	}
+	reader, err := store.Reader(ctx, staged)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, reader)
+	if err != nil {
+		return err
+	}
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	u.HashState = state
+	u.Offset = n
+	u.Chunks = []string{staged}
+	return nil
+}
+
+// assemble writes the uploaded chunks to dst, server-side when the driver
+// supports it and the chunks qualify, otherwise by streaming them through.
+func (u *uploadSession) assemble(ctx context.Context, store storage.Driver, dst string) error {
+	if len(u.Chunks) == 0 {
+		writer, err := store.Writer(ctx, dst)
+		if err != nil {
+			return err
+		}
+		return writer.Close()
+	}
+
+	if c, ok := store.(storage.Composer); ok {
+		if err := c.Compose(ctx, dst, u.Chunks); err == nil {
+			return nil
+		} else if !errors.Is(err, storage.ErrNotSupported) {
+			fmt.Printf("[Upload] Server-side compose failed for %s, streaming instead: %v\n", u.ID, err)
+		}
+	}
+
+	writer, err := store.Writer(ctx, dst)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range u.Chunks {
+		reader, err := store.Reader(ctx, chunk)
+		if err != nil {
+			abortWrite(writer)
+			return err
+		}
+		_, err = io.Copy(writer, reader)
+		reader.Close()
+		if err != nil {
+			abortWrite(writer)
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// cleanup removes the temporary chunk objects, including whatever the client
+// of a direct session uploaded.
+func (u *uploadSession) cleanup(ctx context.Context, store storage.Driver) {
+	chunks := u.Chunks
+	if u.Direct && len(chunks) == 0 {
+		chunks = []string{u.stagingPath()}
+	}
+	for _, chunk := range chunks {
+		if err := store.Delete(ctx, chunk); err != nil {
+			fmt.Printf("[Upload] Failed to delete chunk %s: %v\n", chunk, err)
+		}
+	}
+}
+
+// uploadStore persists upload sessions in Redis so any API instance can
+// continue an upload, falling back to memory when Redis is unavailable.
+type uploadStore struct {
+	rdb *redis.Client
+
+	mu  sync.Mutex
+	mem map[string]*uploadSession
+}
+
+func newUploadStore(rdb *redis.Client) *uploadStore {
+	return &uploadStore{rdb: rdb, mem: make(map[string]*uploadSession)}
+}
+
+func (s *uploadStore) Get(ctx context.Context, id string) (*uploadSession, error) {
+	if s.rdb == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		u, ok := s.mem[id]
+		if !ok {
+			return nil, errUploadUnknown
+		}
+		cp := *u
+		cp.Chunks = append([]string(nil), u.Chunks...)
+		return &cp, nil
+	}
+
+	data, err := s.rdb.Get(ctx, uploadKeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, errUploadUnknown
+	}
+	if err != nil {
+		return nil, err
+	}
+	var u uploadSession
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *uploadStore) Save(ctx context.Context, u *uploadSession) error {
+	if s.rdb == nil {
+		s.mu.Lock()
+		s.mem[u.ID] = u
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, uploadKeyPrefix+u.ID, data, uploadTTL).Err()
+}
+
+func (s *uploadStore) Delete(ctx context.Context, id string) {
+	if s.rdb == nil {
+		s.mu.Lock()
+		delete(s.mem, id)
+		s.mu.Unlock()
+		return
+	}
+	s.rdb.Del(ctx, uploadKeyPrefix+id)
+}
@@ -0,0 +1,232 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// challengeParam matches one key="value" pair of a WWW-Authenticate header.
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// remote pushes to a registry speaking the distribution API, signing in
+// with the target's username and password through Basic or Bearer token
+// authentication, whichever the registry asks for.
+type remote struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string // bearer token per repository
+	basic  bool              // the registry asked for Basic auth
+}
+
+func newRemote(targetURL, username, password string) (*remote, error) {
+	base, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, err
+	}
+	return &remote{
+		base:     base,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: 30 * time.Minute}, // bounds a single layer upload
+		tokens:   make(map[string]string),
+	}, nil
+}
+
+// do sends a request for a repository, signing in when challenged. A
+// request is only sent again after signing in if its body can be replayed
+// (as bytes and strings readers can), so callers stream blobs after a
+// request without one. Unless ok accepts its status, the response is
+// returned as an error.
+func (c *remote) do(ctx context.Context, method, repo, target string, header http.Header, body io.Reader, size int64, ok func(int) bool) (*http.Response, error) {
+	u, err := c.base.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.ContentLength = size
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		c.authorize(req, repo)
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && (body == nil || req.GetBody != nil) {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if err := c.signIn(ctx, repo, challenge); err != nil {
+				return nil, err
+			}
+			if body != nil {
+				if body, err = req.GetBody(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if !ok(resp.StatusCode) {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+		}
+		return resp, nil
+	}
+}
+
+func (c *remote) authorize(req *http.Request, repo string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if token := c.tokens[repo]; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.basic {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// signIn answers an authentication challenge for pushing to repo.
+func (c *remote) signIn(ctx context.Context, repo, challenge string) error {
+	scheme, _, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.username == "" {
+			return fmt.Errorf("registry requires credentials")
+		}
+		c.mu.Lock()
+		c.basic = true
+		c.mu.Unlock()
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	if params["realm"] == "" {
+		return fmt.Errorf("authentication challenge without realm")
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return fmt.Errorf("invalid token realm: %w", err)
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	q.Set("scope", "repository:"+repo+":pull,push")
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("invalid token response: %w", err)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return fmt.Errorf("token response without token")
+	}
+
+	c.mu.Lock()
+	c.tokens[repo] = token
+	c.mu.Unlock()
+	return nil
+}
+
+func status(codes ...int) func(int) bool {
+	return func(code int) bool {
+		for _, c := range codes {
+			if code == c {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// hasBlob reports whether the repository already has a blob.
+func (c *remote) hasBlob(ctx context.Context, repo, digest string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, repo, "/v2/"+repo+"/blobs/"+digest, nil, nil, 0, status(http.StatusOK, http.StatusNotFound))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// pushBlob uploads a blob of size bytes in one request.
+func (c *remote) pushBlob(ctx context.Context, repo, digest string, size int64, body io.Reader) error {
+	resp, err := c.do(ctx, http.MethodPost, repo, "/v2/"+repo+"/blobs/uploads/", nil, nil, 0, status(http.StatusAccepted))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return fmt.Errorf("upload of %s: no upload location", digest)
+	}
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err = c.do(ctx, http.MethodPut, repo, location.String(), header, body, size, status(http.StatusCreated))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// pushManifest puts a manifest under a tag or its digest.
+func (c *remote) pushManifest(ctx context.Context, repo, reference, mediaType string, body []byte) error {
+	header := http.Header{"Content-Type": {mediaType}}
+	resp, err := c.do(ctx, http.MethodPut, repo, "/v2/"+repo+"/manifests/"+reference, header, bytes.NewReader(body), int64(len(body)), status(http.StatusCreated))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
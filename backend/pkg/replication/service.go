@@ -0,0 +1,494 @@
+// Package replication pushes images to other registries for geo-redundancy.
+// Targets are remote registries; rules pick the repositories and tags each
+// one receives. A push of a matching tag records a task per target and
+// queues it in Redis, where workers on every instance pick it up and copy
+// the manifest, its child manifests and missing blobs from storage. Failed
+// tasks are retried with backoff through a Redis sorted set.
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/registryx/registryx/backend/pkg/importer"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// Task statuses. A pending task is queued or waiting for its next attempt.
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Redis keys of the task queue.
+const (
+	QueueKey = "registryx:replication_queue" // list of task IDs ready to run
+	RetryKey = "registryx:replication_retry" // zset of task IDs scored by next attempt (unix)
+)
+
+// MaxAttempts bounds the attempts of a task before it is failed for good.
+// Retries back off from retryDelay, doubling up to maxRetryDelay.
+const (
+	MaxAttempts   = 10
+	retryDelay    = 30 * time.Second
+	maxRetryDelay = time.Hour
+)
+
+// A running task's heartbeat is refreshed every heartbeatInterval. One not
+// refreshed for staleAfter, or pending for longer than any retry waits, was
+// lost by an instance that died or by Redis and is queued again.
+const (
+	heartbeatInterval = time.Minute
+	staleAfter        = 2 * maxRetryDelay
+	taskRetention     = 30 * 24 * time.Hour
+	recentTasks       = 20
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrInvalid  = errors.New("invalid replication request")
+)
+
+// Target is a remote registry. The password is never serialized.
+type Target struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Username  string    `json:"username,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	Rules     []Rule    `json:"rules"`
+}
+
+// Rule replicates tags matching TagPattern of repositories matching
+// RepositoryPattern, both globs ("*" stops at "/": "team/*" but not
+// "team/a/b"). On the target, repositories are named RemotePrefix/<name>, or
+// keep their name without a prefix.
+type Rule struct {
+	ID                uuid.UUID `json:"id"`
+	TargetID          uuid.UUID `json:"targetId"`
+	RepositoryPattern string    `json:"repository"`
+	TagPattern        string    `json:"tags"`
+	RemotePrefix      string    `json:"remotePrefix,omitempty"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// TargetRequest describes a target to add, with its first rules.
+type TargetRequest struct {
+	Name     string        `json:"name"`
+	URL      string        `json:"url"`
+	Username string        `json:"username"`
+	Password string        `json:"password"` // or token
+	Rules    []RuleRequest `json:"rules"`
+}
+
+// RuleRequest describes a rule to add; TagPattern defaults to "*".
+type RuleRequest struct {
+	RepositoryPattern string `json:"repository"`
+	TagPattern        string `json:"tags"`
+	RemotePrefix      string `json:"remotePrefix"`
+}
+
+// Task is the replication of one pushed tag to one target.
+type Task struct {
+	ID               uuid.UUID `json:"id"`
+	TargetID         uuid.UUID `json:"targetId"`
+	Target           string    `json:"target"`
+	Repository       string    `json:"repository"`
+	RemoteRepository string    `json:"remoteRepository"`
+	Tag              string    `json:"tag"`
+	Digest           string    `json:"digest"`
+	Status           string    `json:"status"`
+	Attempts         int       `json:"attempts"`
+	BlobsCopied      int       `json:"blobsCopied"`
+	BytesCopied      int64     `json:"bytesCopied"`
+	Error            string    `json:"error,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// TargetStatus is a target with the state of its tasks.
+type TargetStatus struct {
+	Target
+	Pending       int        `json:"pending"`
+	Running       int        `json:"running"`
+	Succeeded     int        `json:"succeeded"` // in the last 24 hours
+	Failed        int        `json:"failed"`    // in the last 24 hours
+	LastSucceeded *time.Time `json:"lastSucceededAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+}
+
+// Status is the replication overview of GET /api/v1/replication.
+type Status struct {
+	Queued   int64          `json:"queued"`   // tasks ready to run
+	Retrying int64          `json:"retrying"` // tasks waiting for their next attempt
+	Targets  []TargetStatus `json:"targets"`
+	Recent   []Task         `json:"recent"`
+}
+
+type Service struct {
+	DB      *sql.DB
+	Redis   *redis.Client
+	Storage storage.Driver
+	Workers int // tasks run at once by this instance
+}
+
+func NewService(db *sql.DB, rdb *redis.Client, store storage.Driver) *Service {
+	return &Service{DB: db, Redis: rdb, Storage: store, Workers: 1}
+}
+
+// CreateTarget validates and records a target with its rules.
+func (s *Service) CreateTarget(ctx context.Context, req TargetRequest) (*Target, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return nil, fmt.Errorf("%w: name is required (at most 100 characters)", ErrInvalid)
+	}
+	targetURL, err := importer.NormalizeSourceURL(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	for _, rr := range req.Rules {
+		if err := validateRule(&rr); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t := &Target{Name: name, URL: targetURL, Username: req.Username, Enabled: true, Rules: []Rule{}}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO replication_targets (name, url, username, password) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`, t.Name, t.URL, t.Username, req.Password).Scan(&t.ID, &t.CreatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return nil, fmt.Errorf("%w: a target named %q exists", ErrInvalid, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range req.Rules {
+		rule, err := insertRule(ctx, tx, t.ID, rr)
+		if err != nil {
+			return nil, err
+		}
+		t.Rules = append(t.Rules, *rule)
+	}
+	return t, tx.Commit()
+}
+
+// SetTargetEnabled pauses or resumes a target. No tasks are created for a
+// paused target, and its pending ones are queued again when it resumes.
+func (s *Service) SetTargetEnabled(ctx context.Context, id uuid.UUID, enabled bool) error {
+	res, err := s.DB.ExecContext(ctx, `UPDATE replication_targets SET enabled = $2 WHERE id = $1`, id, enabled)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if !enabled {
+		return nil
+	}
+	rows, err := s.DB.QueryContext(ctx, `SELECT id FROM replication_tasks WHERE target_id = $1 AND status = 'pending'`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var taskID string
+		if err := rows.Scan(&taskID); err != nil {
+			return err
+		}
+		if err := s.Redis.RPush(ctx, QueueKey, taskID).Err(); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteTarget removes a target with its rules and tasks.
+func (s *Service) DeleteTarget(ctx context.Context, id uuid.UUID) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM replication_targets WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AddRule adds a rule to a target. It applies from the next push.
+func (s *Service) AddRule(ctx context.Context, targetID uuid.UUID, rr RuleRequest) (*Rule, error) {
+	if err := validateRule(&rr); err != nil {
+		return nil, err
+	}
+	rule, err := insertRule(ctx, s.DB, targetID, rr)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+		return nil, ErrNotFound
+	}
+	return rule, err
+}
+
+// DeleteRule removes a rule. Tasks it created carry on.
+func (s *Service) DeleteRule(ctx context.Context, id uuid.UUID) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM replication_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func validateRule(rr *RuleRequest) error {
+	rr.RepositoryPattern = strings.TrimSpace(rr.RepositoryPattern)
+	rr.TagPattern = strings.TrimSpace(rr.TagPattern)
+	rr.RemotePrefix = strings.Trim(rr.RemotePrefix, "/ ")
+	if rr.TagPattern == "" {
+		rr.TagPattern = "*"
+	}
+	if rr.RepositoryPattern == "" {
+		return fmt.Errorf("%w: rule without repository pattern", ErrInvalid)
+	}
+	for _, p := range []string{rr.RepositoryPattern, rr.TagPattern} {
+		if _, err := path.Match(p, ""); err != nil || len(p) > 255 {
+			return fmt.Errorf("%w: invalid pattern %q", ErrInvalid, p)
+		}
+	}
+	if len(rr.RemotePrefix) > 255 {
+		return fmt.Errorf("%w: remote prefix too long", ErrInvalid)
+	}
+	return nil
+}
+
+type execer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func insertRule(ctx context.Context, q execer, targetID uuid.UUID, rr RuleRequest) (*Rule, error) {
+	r := &Rule{TargetID: targetID, RepositoryPattern: rr.RepositoryPattern, TagPattern: rr.TagPattern, RemotePrefix: rr.RemotePrefix}
+	err := q.QueryRowContext(ctx, `
+		INSERT INTO replication_rules (target_id, repository_pattern, tag_pattern, remote_prefix) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (target_id, repository_pattern, tag_pattern) DO UPDATE SET remote_prefix = EXCLUDED.remote_prefix
+		RETURNING id, created_at`, targetID, r.RepositoryPattern, r.TagPattern, r.RemotePrefix).Scan(&r.ID, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Targets lists every target with its rules.
+func (s *Service) Targets(ctx context.Context) ([]Target, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, name, url, username, enabled, created_at FROM replication_targets ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	targets := []Target{}
+	index := map[uuid.UUID]int{}
+	for rows.Next() {
+		t := Target{Rules: []Rule{}}
+		if err := rows.Scan(&t.ID, &t.Name, &t.URL, &t.Username, &t.Enabled, &t.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[t.ID] = len(targets)
+		targets = append(targets, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rules, err := s.rules(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if i, ok := index[r.TargetID]; ok {
+			targets[i].Rules = append(targets[i].Rules, r)
+		}
+	}
+	return targets, nil
+}
+
+// rules lists the rules, only of enabled targets if enabledOnly.
+func (s *Service) rules(ctx context.Context, enabledOnly bool) ([]Rule, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT r.id, r.target_id, r.repository_pattern, r.tag_pattern, r.remote_prefix, r.created_at
+		FROM replication_rules r JOIN replication_targets t ON t.id = r.target_id
+		WHERE t.enabled OR NOT $1
+		ORDER BY r.created_at`, enabledOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []Rule{}
+	for rows.Next() {
+		var r Rule
+		if err := rows.Scan(&r.ID, &r.TargetID, &r.RepositoryPattern, &r.TagPattern, &r.RemotePrefix, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// Enqueue records and queues a task for every target with a rule matching
+// a pushed tag; the first matching rule of a target names the remote
+// repository. Manifests pushed by digest travel with the tags that
+// reference them and are not replicated on their own.
+func (s *Service) Enqueue(ctx context.Context, repository, reference, digest string) error {
+	if strings.Contains(reference, ":") {
+		return nil
+	}
+	rules, err := s.rules(ctx, true)
+	if err != nil {
+		return err
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, r := range rules {
+		if seen[r.TargetID] {
+			continue
+		}
+		repoOK, _ := path.Match(r.RepositoryPattern, repository)
+		tagOK, _ := path.Match(r.TagPattern, reference)
+		if !repoOK || !tagOK {
+			continue
+		}
+		seen[r.TargetID] = true
+
+		remoteRepo := repository
+		if r.RemotePrefix != "" {
+			remoteRepo = r.RemotePrefix + "/" + repository
+		}
+		var id uuid.UUID
+		err := s.DB.QueryRowContext(ctx, `
+			INSERT INTO replication_tasks (target_id, repository, remote_repository, tag, digest)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id`, r.TargetID, repository, remoteRepo, reference, digest).Scan(&id)
+		if err != nil {
+			return err
+		}
+		if err := s.Redis.RPush(ctx, QueueKey, id.String()).Err(); err != nil {
+			// The task stays pending and is queued again once found stale.
+			return fmt.Errorf("failed to queue replication task %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Tasks lists recent tasks, newest first, optionally of one target or status.
+func (s *Service) Tasks(ctx context.Context, targetID *uuid.UUID, status string, limit int) ([]Task, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT k.id, k.target_id, t.name, k.repository, k.remote_repository, k.tag, k.digest, k.status,
+		       k.attempts, k.blobs_copied, k.bytes_copied, k.error, k.created_at, k.updated_at
+		FROM replication_tasks k JOIN replication_targets t ON t.id = k.target_id
+		WHERE ($1::uuid IS NULL OR k.target_id = $1) AND ($2 = '' OR k.status = $2)
+		ORDER BY k.created_at DESC LIMIT $3`, targetID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tasks := []Task{}
+	for rows.Next() {
+		var k Task
+		if err := rows.Scan(&k.ID, &k.TargetID, &k.Target, &k.Repository, &k.RemoteRepository, &k.Tag, &k.Digest, &k.Status,
+			&k.Attempts, &k.BlobsCopied, &k.BytesCopied, &k.Error, &k.CreatedAt, &k.UpdatedAt); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, k)
+	}
+	return tasks, rows.Err()
+}
+
+// Status reports the queue, each target with the state of its tasks, and
+// the most recent tasks.
+func (s *Service) Status(ctx context.Context) (*Status, error) {
+	st := &Status{}
+	var err error
+	if st.Queued, err = s.Redis.LLen(ctx, QueueKey).Result(); err != nil {
+		return nil, err
+	}
+	if st.Retrying, err = s.Redis.ZCard(ctx, RetryKey).Result(); err != nil {
+		return nil, err
+	}
+
+	targets, err := s.Targets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index := map[uuid.UUID]int{}
+	st.Targets = make([]TargetStatus, len(targets))
+	for i, t := range targets {
+		st.Targets[i].Target = t
+		index[t.ID] = i
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT target_id,
+		       COUNT(*) FILTER (WHERE status = 'pending'),
+		       COUNT(*) FILTER (WHERE status = 'running'),
+		       COUNT(*) FILTER (WHERE status = 'succeeded' AND updated_at > NOW() - INTERVAL '24 hours'),
+		       COUNT(*) FILTER (WHERE status = 'failed' AND updated_at > NOW() - INTERVAL '24 hours'),
+		       MAX(updated_at) FILTER (WHERE status = 'succeeded'),
+		       (ARRAY_AGG(error ORDER BY updated_at DESC) FILTER (WHERE error <> ''))[1]
+		FROM replication_tasks GROUP BY target_id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var ts TargetStatus
+		var lastError sql.NullString
+		if err := rows.Scan(&id, &ts.Pending, &ts.Running, &ts.Succeeded, &ts.Failed, &ts.LastSucceeded, &lastError); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			ts.Target = st.Targets[i].Target
+			ts.LastError = lastError.String
+			st.Targets[i] = ts
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	st.Recent, err = s.Tasks(ctx, nil, "", recentTasks)
+	return st, err
+}
+
+// Retry queues a failed task again with a fresh set of attempts.
+func (s *Service) Retry(ctx context.Context, id uuid.UUID) error {
+	var status string
+	err := s.DB.QueryRowContext(ctx, `
+		UPDATE replication_tasks SET status = 'pending', attempts = 0, error = '', updated_at = NOW()
+		WHERE id = $1 AND status = 'failed' RETURNING status`, id).Scan(&status)
+	if err == sql.ErrNoRows {
+		if err := s.DB.QueryRowContext(ctx, `SELECT status FROM replication_tasks WHERE id = $1`, id).Scan(&status); err == sql.ErrNoRows {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: task is %s", ErrInvalid, status)
+	}
+	if err != nil {
+		return err
+	}
+	return s.Redis.RPush(ctx, QueueKey, id.String()).Err()
+}
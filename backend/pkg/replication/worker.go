@@ -0,0 +1,351 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// Manifest media types, for manifests that don't name their own.
+const (
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+)
+
+// Run replicates queued tasks until ctx is done, with Workers goroutines
+// and one that moves due retries to the queue and finds lost tasks. Tasks
+// interrupted by shutdown go back to the queue.
+func (s *Service) Run(ctx context.Context) {
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.schedule(ctx)
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				result, err := s.Redis.BLPop(ctx, 5*time.Second, QueueKey).Result()
+				if err == redis.Nil || ctx.Err() != nil {
+					continue
+				}
+				if err != nil {
+					fmt.Printf("[Replication] Failed to read the queue: %v\n", err)
+					select {
+					case <-ctx.Done():
+					case <-time.After(5 * time.Second):
+					}
+					continue
+				}
+				id, err := uuid.Parse(result[1])
+				if err != nil {
+					continue
+				}
+				s.run(ctx, id)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// schedule queues retries that are due every few seconds, and every
+// heartbeat lost tasks and pruning.
+func (s *Service) schedule(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var lastSweep time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.queueDueRetries(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("[Replication] Failed to queue retries: %v\n", err)
+		}
+		if time.Since(lastSweep) < heartbeatInterval {
+			continue
+		}
+		lastSweep = time.Now()
+		if n, err := s.requeueLost(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("[Replication] Failed to requeue lost tasks: %v\n", err)
+		} else if n > 0 {
+			fmt.Printf("[Replication] Requeued %d lost tasks\n", n)
+		}
+		if _, err := s.DB.ExecContext(ctx, `
+			DELETE FROM replication_tasks WHERE status IN ('succeeded', 'failed') AND updated_at < NOW() - $1::float8 * INTERVAL '1 second'`,
+			taskRetention.Seconds()); err != nil && ctx.Err() == nil {
+			fmt.Printf("[Replication] Failed to prune tasks: %v\n", err)
+		}
+	}
+}
+
+// queueDueRetries moves tasks whose retry is due from the retry set to the
+// queue.
+func (s *Service) queueDueRetries(ctx context.Context) error {
+	due, err := s.Redis.ZRangeByScore(ctx, RetryKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range due {
+		// ZRem first so two instances never queue the same retry twice.
+		removed, err := s.Redis.ZRem(ctx, RetryKey, id).Result()
+		if err != nil || removed == 0 {
+			continue
+		}
+		if err := s.Redis.RPush(ctx, QueueKey, id).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requeueLost queues tasks of enabled targets running without a heartbeat,
+// or pending for longer than any retry waits: their instance died, or Redis
+// lost them.
+func (s *Service) requeueLost(ctx context.Context) (int, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		UPDATE replication_tasks k SET status = 'pending', updated_at = NOW()
+		FROM replication_targets t
+		WHERE t.id = k.target_id AND t.enabled
+		  AND k.status IN ('pending', 'running') AND k.updated_at < NOW() - $1::float8 * INTERVAL '1 second'
+		RETURNING k.id`, staleAfter.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return n, err
+		}
+		if err := s.Redis.RPush(ctx, QueueKey, id).Err(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// job is a claimed task with what is needed to run it.
+type job struct {
+	Task
+	url      string
+	username string
+	password string
+	remote   *remote
+}
+
+// claim marks a pending task of an enabled target as running. It returns
+// nil for tasks already taken, finished, or of a paused target.
+func (s *Service) claim(ctx context.Context, id uuid.UUID) (*job, error) {
+	j := &job{}
+	err := s.DB.QueryRowContext(ctx, `
+		UPDATE replication_tasks k SET status = 'running', attempts = k.attempts + 1, updated_at = NOW()
+		FROM replication_targets t
+		WHERE k.id = $1 AND t.id = k.target_id AND k.status = 'pending' AND t.enabled
+		RETURNING k.id, k.target_id, t.name, k.repository, k.remote_repository, k.tag, k.digest, k.attempts,
+		          t.url, t.username, t.password`,
+		id).Scan(&j.ID, &j.TargetID, &j.Target, &j.Repository, &j.RemoteRepository, &j.Tag, &j.Digest, &j.Attempts,
+		&j.url, &j.username, &j.password)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// run replicates a queued task and records how it ended: failures are
+// retried with backoff until MaxAttempts.
+func (s *Service) run(ctx context.Context, id uuid.UUID) {
+	j, err := s.claim(ctx, id)
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Printf("[Replication] Failed to claim task %s: %v\n", id, err)
+			// Put it back rather than wait for it to be found stale.
+			s.Redis.RPush(context.Background(), QueueKey, id.String())
+		}
+		return
+	}
+	if j == nil {
+		return
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+				s.DB.ExecContext(runCtx, `UPDATE replication_tasks SET updated_at = NOW() WHERE id = $1`, j.ID)
+			}
+		}
+	}()
+
+	err = s.replicate(runCtx, j)
+	cancel()
+
+	// The update must land even though ctx may be done.
+	finishCtx, finish := context.WithTimeout(context.Background(), 10*time.Second)
+	defer finish()
+	target := fmt.Sprintf("%s:%s to %s/%s", j.Repository, j.Tag, j.Target, j.RemoteRepository)
+	switch {
+	case err == nil:
+		fmt.Printf("[Replication] Replicated %s (%d blobs, %d bytes)\n", target, j.BlobsCopied, j.BytesCopied)
+		s.finish(finishCtx, j, StatusSucceeded, "")
+	case ctx.Err() != nil:
+		fmt.Printf("[Replication] Replication of %s interrupted by shutdown; it resumes on the next start\n", target)
+		j.Attempts--
+		s.finish(finishCtx, j, StatusPending, "")
+		s.Redis.LPush(finishCtx, QueueKey, j.ID.String())
+	case j.Attempts >= MaxAttempts:
+		fmt.Printf("[Replication] Replication of %s failed for good after %d attempts: %v\n", target, j.Attempts, err)
+		s.finish(finishCtx, j, StatusFailed, err.Error())
+	default:
+		delay := retryDelay << (j.Attempts - 1)
+		if delay > maxRetryDelay || delay <= 0 {
+			delay = maxRetryDelay
+		}
+		fmt.Printf("[Replication] Replication of %s failed (attempt %d/%d), retrying in %s: %v\n", target, j.Attempts, MaxAttempts, delay, err)
+		s.finish(finishCtx, j, StatusPending, err.Error())
+		s.Redis.ZAdd(finishCtx, RetryKey, redis.Z{Score: float64(time.Now().Add(delay).Unix()), Member: j.ID.String()})
+	}
+}
+
+func (s *Service) finish(ctx context.Context, j *job, status, errMsg string) {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE replication_tasks SET status = $2, attempts = $3, error = $4, blobs_copied = $5, bytes_copied = $6, updated_at = NOW()
+		WHERE id = $1`, j.ID, status, j.Attempts, errMsg, j.BlobsCopied, j.BytesCopied)
+	if err != nil {
+		fmt.Printf("[Replication] Failed to record task %s as %s: %v\n", j.ID, status, err)
+	}
+}
+
+// replicate pushes the tagged manifest as it was pushed here: child
+// manifests and missing blobs first, then the manifest under its tag.
+func (s *Service) replicate(ctx context.Context, j *job) error {
+	r, err := newRemote(j.url, j.username, j.password)
+	if err != nil {
+		return err
+	}
+	j.remote = r
+	return s.pushManifest(ctx, j, j.Digest, j.Tag)
+}
+
+// pushManifest pushes the stored manifest with a digest under reference.
+func (s *Service) pushManifest(ctx context.Context, j *job, digest, reference string) error {
+	body, err := s.readManifest(ctx, j.Repository, digest)
+	if err != nil {
+		return err
+	}
+	var m struct {
+		MediaType string `json:"mediaType"`
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+		Config *descriptor  `json:"config"`
+		Layers []descriptor `json:"layers"`
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return fmt.Errorf("invalid manifest %s: %w", digest, err)
+	}
+
+	for _, child := range m.Manifests {
+		if err := s.pushManifest(ctx, j, child.Digest, child.Digest); err != nil {
+			return err
+		}
+	}
+	blobs := m.Layers
+	if m.Config != nil {
+		blobs = append([]descriptor{*m.Config}, blobs...)
+	}
+	for _, b := range blobs {
+		if err := s.pushBlob(ctx, j, b); err != nil {
+			return err
+		}
+	}
+
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = mediaTypeOCIManifest
+		if m.Manifests != nil {
+			mediaType = mediaTypeOCIIndex
+		}
+	}
+	if err := j.remote.pushManifest(ctx, j.RemoteRepository, reference, mediaType, body); err != nil {
+		return fmt.Errorf("manifest %s: %w", reference, err)
+	}
+	return nil
+}
+
+type descriptor struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// pushBlob uploads a blob the target's repository does not have yet.
+func (s *Service) pushBlob(ctx context.Context, j *job, b descriptor) error {
+	has, err := j.remote.hasBlob(ctx, j.RemoteRepository, b.Digest)
+	if err != nil {
+		return fmt.Errorf("blob %s: %w", b.Digest, err)
+	}
+	if has {
+		return nil
+	}
+
+	blobPath := path.Join("blobs", b.Digest)
+	size, err := s.Storage.Stat(ctx, blobPath)
+	if err != nil {
+		return fmt.Errorf("blob %s: %w", b.Digest, err)
+	}
+	reader, err := s.Storage.Reader(ctx, blobPath)
+	if err != nil {
+		return fmt.Errorf("blob %s: %w", b.Digest, err)
+	}
+	defer reader.Close()
+	if err := j.remote.pushBlob(ctx, j.RemoteRepository, b.Digest, size, reader); err != nil {
+		return fmt.Errorf("blob %s: %w", b.Digest, err)
+	}
+	j.BlobsCopied++
+	j.BytesCopied += size
+	return nil
+}
+
+// readManifest reads a manifest of a repository from storage.
+func (s *Service) readManifest(ctx context.Context, repository, digest string) ([]byte, error) {
+	reader, err := s.Storage.Reader(ctx, path.Join("manifests", repository, digest))
+	if storage.IsNotExist(err) {
+		return nil, fmt.Errorf("manifest %s is no longer stored", digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, 4*1024*1024))
+}
@@ -0,0 +1,544 @@
+// Package reports emails namespace owners a weekly summary of their
+// repositories: new vulnerabilities, health grade changes, storage and cost
+// changes, and images nobody pulls any more.
+package reports
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/registryx/registryx/backend/pkg/email"
+)
+
+// sentKeyPrefix marks a week's report as sent in Redis, so only one instance
+// sends it and restarts don't send it twice.
+const sentKeyPrefix = "weekly-report:"
+
+const (
+	maxRows    = 15 // rows listed per section of one email
+	zombieDays = 90 // tagged images not pulled for this long are zombie candidates
+)
+
+// Weekly sends the weekly summary email.
+type Weekly struct {
+	DB        *sql.DB
+	Email     *email.Service
+	Weekday   time.Weekday
+	PublicURL string // base URL of the unsubscribe link
+
+	rdb    *redis.Client
+	secret []byte
+
+	mu       sync.Mutex
+	lastWeek string // in-memory marker when Redis is unavailable
+}
+
+// NewWeekly creates the report; Run sends it on Weekday. secret signs
+// unsubscribe links.
+func NewWeekly(db *sql.DB, mail *email.Service, rdb *redis.Client, secret string) *Weekly {
+	return &Weekly{DB: db, Email: mail, Weekday: time.Monday, rdb: rdb, secret: []byte(secret)}
+}
+
+// ParseWeekday parses a day such as "monday" or "tue".
+func ParseWeekday(day string) (time.Weekday, error) {
+	day = strings.ToLower(strings.TrimSpace(day))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if day == name || day == name[:3] {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", day)
+}
+
+// Report is one owner's summary of the past week.
+type Report struct {
+	Vulnerabilities []NewVulnerabilities `json:"vulnerabilities"`
+	Grades          []GradeChange        `json:"grades"`
+	Storage         []StorageChange      `json:"storage"`
+	Zombies         []ZombieCandidate    `json:"zombies"`
+
+	SizeBytes      int64   `json:"sizeBytes"` // all the owner's repositories, per the latest cost snapshot
+	SizeDeltaBytes int64   `json:"sizeDeltaBytes"`
+	CostUSD        float64 `json:"costUsd"`
+	CostDeltaUSD   float64 `json:"costDeltaUsd"`
+}
+
+// Empty reports whether there is nothing worth sending.
+func (r *Report) Empty() bool {
+	return len(r.Vulnerabilities) == 0 && len(r.Grades) == 0 && len(r.Storage) == 0 && len(r.Zombies) == 0
+}
+
+// NewVulnerabilities counts the findings scans of a repository reported as
+// new during the week.
+type NewVulnerabilities struct {
+	Repository string `json:"repository"`
+	New        int    `json:"new"`
+	Critical   int    `json:"critical"`
+	High       int    `json:"high"`
+}
+
+// GradeChange is a repository whose health grade changed since the last
+// report.
+type GradeChange struct {
+	Repository string `json:"repository"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	Improved   bool   `json:"improved"`
+}
+
+// StorageChange is how much a repository grew or shrank over the week.
+type StorageChange struct {
+	Repository     string  `json:"repository"`
+	SizeBytes      int64   `json:"sizeBytes"`
+	SizeDeltaBytes int64   `json:"sizeDeltaBytes"`
+	CostUSD        float64 `json:"costUsd"`
+	CostDeltaUSD   float64 `json:"costDeltaUsd"`
+}
+
+// ZombieCandidate is a tagged image nobody pulled for zombieDays.
+type ZombieCandidate struct {
+	Repository        string  `json:"repository"`
+	Tag               string  `json:"tag"`
+	DaysSinceLastPull int     `json:"daysSinceLastPull"`
+	SizeBytes         int64   `json:"sizeBytes"`
+	StorageCostUSD    float64 `json:"storageCostUsd"`
+}
+
+// Run checks every hour whether this week's report is due and sends it once,
+// until ctx is cancelled.
+func (w *Weekly) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		if now.Weekday() == w.Weekday && w.claim(ctx, now) {
+			if sent, err := w.SendAll(ctx); err != nil {
+				fmt.Printf("[Reports] Weekly report failed: %v\n", err)
+			} else {
+				fmt.Printf("[Reports] Sent weekly report to %d owners\n", sent)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim marks this week's report as taken and reports whether this caller
+// should send it.
+func (w *Weekly) claim(ctx context.Context, now time.Time) bool {
+	year, week := now.ISOWeek()
+	key := fmt.Sprintf("%d-W%02d", year, week)
+	if w.rdb != nil {
+		ok, err := w.rdb.SetNX(ctx, sentKeyPrefix+key, now.Unix(), 8*24*time.Hour).Result()
+		if err == nil {
+			return ok
+		}
+		fmt.Printf("[Reports] Failed to claim weekly report in Redis, using local state: %v\n", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lastWeek == key {
+		return false
+	}
+	w.lastWeek = key
+	return true
+}
+
+// SendAll emails every subscribed namespace owner their report and returns
+// the number of emails sent. Owners with nothing to report get no email.
+// Health grades are then recorded, so next week's report shows what changed
+// since this one.
+func (w *Weekly) SendAll(ctx context.Context) (int, error) {
+	if !w.Email.IsEnabled() {
+		return 0, nil
+	}
+
+	rows, err := w.DB.QueryContext(ctx, `
+		SELECT DISTINCT u.id, u.username, u.email
+		FROM users u JOIN namespaces n ON n.owner_id = u.id
+		WHERE u.email <> '' AND u.weekly_report`)
+	if err != nil {
+		return 0, err
+	}
+	type owner struct {
+		id              uuid.UUID
+		username, email string
+	}
+	var owners []owner
+	for rows.Next() {
+		var o owner
+		if err := rows.Scan(&o.id, &o.username, &o.email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		owners = append(owners, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, o := range owners {
+		report, err := w.Build(ctx, o.id)
+		if err != nil {
+			return sent, err
+		}
+		if report.Empty() {
+			continue
+		}
+		body, err := w.render(o.username, o.id, report)
+		if err != nil {
+			return sent, err
+		}
+		if err := w.Email.Send(o.email, "Your weekly registry report", body); err != nil {
+			fmt.Printf("[Reports] Failed to send weekly report to %s: %v\n", o.username, err)
+			continue
+		}
+		sent++
+	}
+	return sent, w.recordGrades(ctx)
+}
+
+// Build assembles the report on the namespaces ownerID owns.
+func (w *Weekly) Build(ctx context.Context, ownerID uuid.UUID) (*Report, error) {
+	r := &Report{}
+	steps := []func(context.Context, uuid.UUID, *Report) error{
+		w.newVulnerabilities,
+		w.gradeChanges,
+		w.storageChanges,
+		w.zombieCandidates,
+	}
+	for _, step := range steps {
+		if err := step(ctx, ownerID, r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (w *Weekly) newVulnerabilities(ctx context.Context, ownerID uuid.UUID, r *Report) error {
+	rows, err := w.DB.QueryContext(ctx, `
+		SELECT n.name || '/' || rp.name, SUM((vr.delta->>'newCount')::int), SUM(f.critical), SUM(f.high)
+		FROM vulnerability_reports vr
+		JOIN manifests m ON m.id = vr.manifest_id
+		JOIN repositories rp ON rp.id = m.repository_id
+		JOIN namespaces n ON n.id = rp.namespace_id
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) FILTER (WHERE e->>'severity' = 'CRITICAL') AS critical,
+			       COUNT(*) FILTER (WHERE e->>'severity' = 'HIGH') AS high
+			FROM jsonb_array_elements(COALESCE(vr.delta->'new', '[]'::jsonb)) e
+		) f
+		WHERE n.owner_id = $1 AND vr.status = 'completed' AND vr.delta IS NOT NULL
+		  AND vr.scanned_at > NOW() - INTERVAL '7 days'
+		GROUP BY 1
+		HAVING SUM((vr.delta->>'newCount')::int) > 0
+		ORDER BY 3 DESC, 4 DESC, 2 DESC, 1`, ownerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v NewVulnerabilities
+		if err := rows.Scan(&v.Repository, &v.New, &v.Critical, &v.High); err != nil {
+			return err
+		}
+		r.Vulnerabilities = append(r.Vulnerabilities, v)
+	}
+	return rows.Err()
+}
+
+// currentGrades is the health grade of each repository's newest image.
+const currentGrades = `
+	SELECT r.id, n.name || '/' || r.name AS repository, n.owner_id, hs.health_grade AS grade
+	FROM repositories r
+	JOIN namespaces n ON n.id = r.namespace_id
+	JOIN LATERAL (
+		SELECT health_grade FROM manifests
+		WHERE repository_id = r.id
+		ORDER BY created_at DESC LIMIT 1
+	) hs ON true
+	WHERE hs.health_grade IS NOT NULL`
+
+func (w *Weekly) gradeChanges(ctx context.Context, ownerID uuid.UUID, r *Report) error {
+	rows, err := w.DB.QueryContext(ctx, `
+		SELECT c.repository, g.grade, c.grade
+		FROM (`+currentGrades+`) c
+		JOIN weekly_report_grades g ON g.repository_id = c.id
+		WHERE c.owner_id = $1 AND g.grade <> c.grade
+		ORDER BY c.repository`, ownerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g GradeChange
+		if err := rows.Scan(&g.Repository, &g.Before, &g.After); err != nil {
+			return err
+		}
+		g.Improved = gradeRank(g.After) < gradeRank(g.Before)
+		r.Grades = append(r.Grades, g)
+	}
+	return rows.Err()
+}
+
+// recordGrades keeps every repository's current grade for next week's
+// comparison.
+func (w *Weekly) recordGrades(ctx context.Context) error {
+	_, err := w.DB.ExecContext(ctx, `
+		INSERT INTO weekly_report_grades (repository_id, grade)
+		SELECT id, grade FROM (`+currentGrades+`) c
+		ON CONFLICT (repository_id) DO UPDATE SET grade = EXCLUDED.grade, recorded_at = NOW()`)
+	return err
+}
+
+// gradeRank orders health grades from best (0) to worst.
+func gradeRank(grade string) int {
+	for i, g := range []string{"A+", "A", "A-", "B+", "B", "B-", "C+", "C", "C-", "D"} {
+		if g == grade {
+			return i
+		}
+	}
+	return 10 // F
+}
+
+// storageChanges compares the latest daily cost snapshot of each repository
+// with the one a week before it. Repositories created since count from zero.
+func (w *Weekly) storageChanges(ctx context.Context, ownerID uuid.UUID, r *Report) error {
+	rows, err := w.DB.QueryContext(ctx, `
+		SELECT n.name || '/' || rp.name, cur.size_bytes, cur.total_cost_usd,
+			COALESCE(prev.size_bytes, 0), COALESCE(prev.total_cost_usd, 0)
+		FROM repositories rp
+		JOIN namespaces n ON n.id = rp.namespace_id
+		JOIN LATERAL (
+			SELECT day, size_bytes, total_cost_usd FROM repository_cost_history
+			WHERE repository_id = rp.id ORDER BY day DESC LIMIT 1
+		) cur ON true
+		LEFT JOIN LATERAL (
+			SELECT size_bytes, total_cost_usd FROM repository_cost_history
+			WHERE repository_id = rp.id AND day <= cur.day - 7 ORDER BY day DESC LIMIT 1
+		) prev ON true
+		WHERE n.owner_id = $1
+		ORDER BY ABS(cur.size_bytes - COALESCE(prev.size_bytes, 0)) DESC, 1`, ownerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s StorageChange
+		var prevSize int64
+		var prevCost float64
+		if err := rows.Scan(&s.Repository, &s.SizeBytes, &s.CostUSD, &prevSize, &prevCost); err != nil {
+			return err
+		}
+		s.SizeDeltaBytes, s.CostDeltaUSD = s.SizeBytes-prevSize, s.CostUSD-prevCost
+		r.SizeBytes += s.SizeBytes
+		r.SizeDeltaBytes += s.SizeDeltaBytes
+		r.CostUSD += s.CostUSD
+		r.CostDeltaUSD += s.CostDeltaUSD
+		if s.SizeDeltaBytes != 0 {
+			r.Storage = append(r.Storage, s)
+		}
+	}
+	return rows.Err()
+}
+
+func (w *Weekly) zombieCandidates(ctx context.Context, ownerID uuid.UUID, r *Report) error {
+	rows, err := w.DB.QueryContext(ctx, `
+		SELECT n.name || '/' || rp.name, MIN(t.name),
+			EXTRACT(DAY FROM NOW() - COALESCE(m.last_pulled_at, m.created_at))::int,
+			COALESCE(m.size, 0),
+			COALESCE((SELECT MAX(storage_cost_usd) FROM storage_costs WHERE manifest_id = m.id), 0)
+		FROM manifests m
+		JOIN tags t ON t.manifest_id = m.id
+		JOIN repositories rp ON rp.id = m.repository_id
+		JOIN namespaces n ON n.id = rp.namespace_id
+		WHERE n.owner_id = $1
+		  AND COALESCE(m.last_pulled_at, m.created_at) < NOW() - INTERVAL '1 day' * $2
+		GROUP BY m.id, n.name, rp.name
+		ORDER BY 4 DESC, 1`, ownerID, zombieDays)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var z ZombieCandidate
+		if err := rows.Scan(&z.Repository, &z.Tag, &z.DaysSinceLastPull, &z.SizeBytes, &z.StorageCostUSD); err != nil {
+			return err
+		}
+		r.Zombies = append(r.Zombies, z)
+	}
+	return rows.Err()
+}
+
+// UnsubscribeToken signs userID for the unsubscribe link, so it works
+// without logging in.
+func (w *Weekly) UnsubscribeToken(userID uuid.UUID) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte("weekly-report-unsubscribe:" + userID.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidUnsubscribeToken reports whether token was issued for userID.
+func (w *Weekly) ValidUnsubscribeToken(userID uuid.UUID, token string) bool {
+	return hmac.Equal([]byte(token), []byte(w.UnsubscribeToken(userID)))
+}
+
+// Subscribed reports whether userID receives the weekly report.
+func (w *Weekly) Subscribed(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var on bool
+	err := w.DB.QueryRowContext(ctx, `SELECT weekly_report FROM users WHERE id = $1`, userID).Scan(&on)
+	return on, err
+}
+
+// SetSubscribed turns the weekly report on or off for userID.
+func (w *Weekly) SetSubscribed(ctx context.Context, userID uuid.UUID, on bool) error {
+	res, err := w.DB.ExecContext(ctx, `UPDATE users SET weekly_report = $2, updated_at = NOW() WHERE id = $1`, userID, on)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+var reportTemplate = template.Must(template.New("weekly").Funcs(template.FuncMap{
+	"size":       formatSize,
+	"signedSize": func(b int64) string { return signed(b >= 0) + formatSize(abs(b)) },
+	"signedCost": func(c float64) string { return fmt.Sprintf("%s$%.2f", signed(c >= 0), abs(c)) },
+	"cost":       func(c float64) string { return fmt.Sprintf("$%.2f", c) },
+}).Parse(`
+<html>
+<body>
+    <h2>Your weekly registry report</h2>
+    <p>Hi {{.Username}}, here is what changed in your repositories this week.</p>
+    {{with .Vulnerabilities}}
+    <h3>New vulnerabilities</h3>
+    <table cellpadding="6" style="border-collapse: collapse">
+        <tr><th align="left">Repository</th><th align="right">New</th><th align="right">Critical</th><th align="right">High</th></tr>
+        {{range .Rows}}
+        <tr><td>{{.Repository}}</td><td align="right">{{.New}}</td><td align="right">{{.Critical}}</td><td align="right">{{.High}}</td></tr>
+        {{end}}
+    </table>
+    {{if .More}}<p>... and {{.More}} more repositories.</p>{{end}}
+    {{end}}
+    {{with .Grades}}
+    <h3>Health grade changes</h3>
+    <table cellpadding="6" style="border-collapse: collapse">
+        <tr><th align="left">Repository</th><th align="left">Grade</th><th align="left"></th></tr>
+        {{range .Rows}}
+        <tr><td>{{.Repository}}</td><td>{{.Before}} &rarr; {{.After}}</td><td>{{if .Improved}}improved{{else}}dropped{{end}}</td></tr>
+        {{end}}
+    </table>
+    {{if .More}}<p>... and {{.More}} more repositories.</p>{{end}}
+    {{end}}
+    {{with .Storage}}
+    <h3>Storage and cost</h3>
+    <p>Your repositories hold {{size $.Report.SizeBytes}} ({{signedSize $.Report.SizeDeltaBytes}} this week), costing {{cost $.Report.CostUSD}} ({{signedCost $.Report.CostDeltaUSD}}).</p>
+    <table cellpadding="6" style="border-collapse: collapse">
+        <tr><th align="left">Repository</th><th align="right">Size</th><th align="right">Change</th><th align="right">Cost</th><th align="right">Change</th></tr>
+        {{range .Rows}}
+        <tr><td>{{.Repository}}</td><td align="right">{{size .SizeBytes}}</td><td align="right">{{signedSize .SizeDeltaBytes}}</td><td align="right">{{cost .CostUSD}}</td><td align="right">{{signedCost .CostDeltaUSD}}</td></tr>
+        {{end}}
+    </table>
+    {{if .More}}<p>... and {{.More}} more repositories.</p>{{end}}
+    {{end}}
+    {{with .Zombies}}
+    <h3>Unused images</h3>
+    <p>Nobody pulled these images for {{$.ZombieDays}} days or more. Deleting them saves their storage.</p>
+    <table cellpadding="6" style="border-collapse: collapse">
+        <tr><th align="left">Image</th><th align="right">Last pull</th><th align="right">Size</th><th align="right">Cost</th></tr>
+        {{range .Rows}}
+        <tr><td>{{.Repository}}:{{.Tag}}</td><td align="right">{{.DaysSinceLastPull}} days ago</td><td align="right">{{size .SizeBytes}}</td><td align="right">{{cost .StorageCostUSD}}</td></tr>
+        {{end}}
+    </table>
+    {{if .More}}<p>... and {{.More}} more images.</p>{{end}}
+    {{end}}
+    <p style="color: #888"><a href="{{.Unsubscribe}}">Unsubscribe</a> from the weekly report.</p>
+</body>
+</html>
+`))
+
+// section is a table of at most maxRows rows; More counts the rest.
+type section struct {
+	Rows interface{}
+	More int
+}
+
+func newSection[T any](rows []T) *section {
+	if len(rows) == 0 {
+		return nil
+	}
+	if len(rows) > maxRows {
+		return &section{Rows: rows[:maxRows], More: len(rows) - maxRows}
+	}
+	return &section{Rows: rows}
+}
+
+func (w *Weekly) render(username string, userID uuid.UUID, r *Report) (string, error) {
+	data := struct {
+		Username                                  string
+		Report                                    *Report
+		Vulnerabilities, Grades, Storage, Zombies *section
+		ZombieDays                                int
+		Unsubscribe                               string
+	}{
+		Username:        username,
+		Report:          r,
+		Vulnerabilities: newSection(r.Vulnerabilities),
+		Grades:          newSection(r.Grades),
+		Storage:         newSection(r.Storage),
+		Zombies:         newSection(r.Zombies),
+		ZombieDays:      zombieDays,
+		Unsubscribe: fmt.Sprintf("%s/api/v1/reports/unsubscribe?user=%s&token=%s",
+			strings.TrimRight(w.PublicURL, "/"), userID, w.UnsubscribeToken(userID)),
+	}
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func formatSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+func signed(nonNegative bool) string {
+	if nonNegative {
+		return "+"
+	}
+	return "-"
+}
+
+func abs[T int64 | float64](v T) T {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
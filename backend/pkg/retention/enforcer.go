@@ -0,0 +1,187 @@
+// Package retention enforces the retention policies of repositories: it
+// deletes the tags a policy doesn't keep and the untagged manifests it lets
+// go, on a schedule or on demand.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/events"
+	"github.com/registryx/registryx/backend/pkg/maintenance"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// checkEvery is how often the scheduler looks for policies due to run.
+const checkEvery = time.Minute
+
+// attachedTag matches the tags cosign and similar tools store signatures,
+// attestations and SBOMs of an image under: sha256-<hex>.sig and so on.
+var attachedTag = regexp.MustCompile(`^(sha256)-([0-9a-f]{64})\.[a-z]+$`)
+
+// DeletedTag is a tag a policy deleted, or would delete.
+type DeletedTag struct {
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+}
+
+// Result is what enforcing a policy deleted, or with DryRun would delete.
+type Result struct {
+	Repository string       `json:"repository"`
+	DryRun     bool         `json:"dryRun"`
+	Tags       []DeletedTag `json:"tags"`
+	Manifests  []string     `json:"manifests"`
+	// Errors are the storage objects that couldn't be removed. The database
+	// no longer references them.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Enforcer applies retention policies.
+type Enforcer struct {
+	Metadata    *metadata.Service
+	Storage     storage.Driver
+	Events      *events.Broker
+	Maintenance *maintenance.Service // scheduled runs are skipped while it is on
+}
+
+func NewEnforcer(meta *metadata.Service, store storage.Driver, bus *events.Broker) *Enforcer {
+	return &Enforcer{Metadata: meta, Storage: store, Events: bus}
+}
+
+// StartScheduler enforces each enabled policy every interval until ctx is
+// done. Several instances may run it; each run of a policy goes to one.
+// Nothing runs in maintenance mode; due policies run once it is off.
+func (e *Enforcer) StartScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(checkEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if e.Maintenance.Get(ctx).Enabled {
+				continue
+			}
+			policies, err := e.Metadata.ClaimDueRetentionPolicies(ctx, interval)
+			if err != nil {
+				fmt.Printf("[Retention] Failed to load policies: %v\n", err)
+				continue
+			}
+			for i := range policies {
+				if _, err := e.Enforce(ctx, &policies[i], false); err != nil {
+					fmt.Printf("[Retention] %s: %v\n", policies[i].Repository, err)
+				}
+			}
+		}
+	}
+}
+
+// Enforce applies a policy to its repository. Unless dryRun, it records the
+// outcome on the policy and announces it with a retention.applied event.
+func (e *Enforcer) Enforce(ctx context.Context, p *metadata.RetentionPolicy, dryRun bool) (*Result, error) {
+	res := &Result{Repository: p.Repository, DryRun: dryRun, Tags: []DeletedTag{}, Manifests: []string{}}
+	ns, repo := authz.SplitRepository(p.Repository)
+
+	if p.PrunesTags() {
+		tags, err := e.Metadata.ListTagDetails(ctx, p.Repository)
+		if err != nil {
+			return nil, err
+		}
+		doomed, err := selectTags(p, tags)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range doomed {
+			if !dryRun {
+				deleted, err := e.Metadata.DeleteTagAt(ctx, p.Repository, t.Tag, t.Digest)
+				if err != nil {
+					return res, err
+				}
+				if !deleted {
+					continue // pushed again or deleted meanwhile
+				}
+				for _, err := range storage.DeleteAll(ctx, e.Storage, metadata.ObjectPaths(ns, repo, t.Tag)) {
+					res.Errors = append(res.Errors, err.Error())
+				}
+			}
+			res.Tags = append(res.Tags, t)
+		}
+	}
+
+	if p.UntaggedDays > 0 {
+		before := time.Now().AddDate(0, 0, -p.UntaggedDays)
+		digests, paths, err := e.Metadata.DeleteStaleUntaggedManifests(ctx, p.Repository, before, dryRun)
+		if err != nil {
+			return res, err
+		}
+		res.Manifests = append(res.Manifests, digests...)
+		for _, err := range storage.DeleteAll(ctx, e.Storage, paths) {
+			res.Errors = append(res.Errors, err.Error())
+		}
+	}
+
+	if dryRun {
+		return res, nil
+	}
+	for _, msg := range res.Errors {
+		fmt.Printf("[Retention] Failed to delete manifest object %s\n", msg)
+	}
+	if err := e.Metadata.RecordRetentionRun(ctx, p.Repository, len(res.Tags), len(res.Manifests)); err != nil {
+		return res, err
+	}
+	if len(res.Tags) == 0 && len(res.Manifests) == 0 {
+		return res, nil
+	}
+	fmt.Printf("[Retention] %s: deleted %d tags and %d untagged manifests\n", p.Repository, len(res.Tags), len(res.Manifests))
+	if e.Events != nil {
+		e.Events.Publish(events.Event{Type: events.TypeRetention, Repository: p.Repository, Data: map[string]interface{}{
+			"deletedTags":      len(res.Tags),
+			"deletedManifests": len(res.Manifests),
+		}})
+	}
+	return res, nil
+}
+
+// selectTags returns the tags a policy deletes, given a repository's tags
+// most recently pushed first. Signature and attestation tags follow the
+// image they belong to: they go only when every tag of that image goes.
+func selectTags(p *metadata.RetentionPolicy, tags []metadata.TagInfo) ([]DeletedTag, error) {
+	var keep *regexp.Regexp
+	if p.KeepPattern != "" {
+		re, err := regexp.Compile(p.KeepPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keepPattern: %w", err)
+		}
+		keep = re
+	}
+
+	var doomed, attached []DeletedTag
+	kept := map[string]bool{}
+	dropped := map[string]bool{}
+	n := 0
+	for _, t := range tags {
+		if attachedTag.MatchString(t.Name) {
+			attached = append(attached, DeletedTag{Tag: t.Name, Digest: t.Digest})
+			continue
+		}
+		if n < p.KeepLast || (keep != nil && keep.MatchString(t.Name)) {
+			kept[t.Digest] = true
+		} else {
+			dropped[t.Digest] = true
+			doomed = append(doomed, DeletedTag{Tag: t.Name, Digest: t.Digest})
+		}
+		n++
+	}
+	for _, t := range attached {
+		m := attachedTag.FindStringSubmatch(t.Tag)
+		subject := m[1] + ":" + m[2]
+		if dropped[subject] && !kept[subject] {
+			doomed = append(doomed, t)
+		}
+	}
+	return doomed, nil
+}
@@ -0,0 +1,348 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const cdxSpecVersion = "1.5"
+
+// trivyInventory is the part of Trivy's JSON report CycloneDX needs.
+// Packages are only listed when Trivy ran with --list-all-pkgs; older reports
+// fall back to the vulnerable packages.
+type trivyInventory struct {
+	Results []struct {
+		Target   string `json:"Target"`
+		Class    string `json:"Class"`
+		Type     string `json:"Type"`
+		Packages []struct {
+			Name       string        `json:"Name"`
+			Version    string        `json:"Version"`
+			Identifier pkgIdentifier `json:"Identifier"`
+			Licenses   []string      `json:"Licenses"`
+		} `json:"Packages"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string        `json:"VulnerabilityID"`
+			PkgName          string        `json:"PkgName"`
+			PkgIdentifier    pkgIdentifier `json:"PkgIdentifier"`
+			InstalledVersion string        `json:"InstalledVersion"`
+			FixedVersion     string        `json:"FixedVersion"`
+			Severity         string        `json:"Severity"`
+			SeveritySource   string        `json:"SeveritySource"`
+			Title            string        `json:"Title"`
+			Description      string        `json:"Description"`
+			PrimaryURL       string        `json:"PrimaryURL"`
+			References       []string      `json:"References"`
+			PublishedDate    *time.Time    `json:"PublishedDate"`
+			LastModifiedDate *time.Time    `json:"LastModifiedDate"`
+			DataSource       *struct {
+				Name string `json:"Name"`
+				URL  string `json:"URL"`
+			} `json:"DataSource"`
+			CVSS map[string]struct {
+				V2Vector string  `json:"V2Vector"`
+				V3Vector string  `json:"V3Vector"`
+				V2Score  float64 `json:"V2Score"`
+				V3Score  float64 `json:"V3Score"`
+			} `json:"CVSS"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+type pkgIdentifier struct {
+	PURL string `json:"PURL"`
+}
+
+type cdxBOM struct {
+	BOMFormat          string             `json:"bomFormat"`
+	SpecVersion        string             `json:"specVersion"`
+	SerialNumber       string             `json:"serialNumber"`
+	Version            int                `json:"version"`
+	Metadata           cdxMetadata        `json:"metadata"`
+	Components         []cdxComponent     `json:"components,omitempty"`
+	ExternalReferences []cdxExternalRef   `json:"externalReferences,omitempty"`
+	Vulnerabilities    []cdxVulnerability `json:"vulnerabilities,omitempty"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	BOMRef   string       `json:"bom-ref,omitempty"`
+	Type     string       `json:"type"`
+	Group    string       `json:"group,omitempty"`
+	Name     string       `json:"name"`
+	Version  string       `json:"version,omitempty"`
+	PURL     string       `json:"purl,omitempty"`
+	Licenses []cdxLicense `json:"licenses,omitempty"`
+}
+
+type cdxLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+type cdxExternalRef struct {
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type cdxVulnerability struct {
+	BOMRef         string        `json:"bom-ref"`
+	ID             string        `json:"id"`
+	Source         *cdxSource    `json:"source,omitempty"`
+	Ratings        []cdxRating   `json:"ratings,omitempty"`
+	Description    string        `json:"description,omitempty"`
+	Detail         string        `json:"detail,omitempty"`
+	Recommendation string        `json:"recommendation,omitempty"`
+	Advisories     []cdxAdvisory `json:"advisories,omitempty"`
+	Published      *time.Time    `json:"published,omitempty"`
+	Updated        *time.Time    `json:"updated,omitempty"`
+	Affects        []cdxAffects  `json:"affects"`
+}
+
+type cdxSource struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+type cdxRating struct {
+	Source   *cdxSource `json:"source,omitempty"`
+	Score    float64    `json:"score,omitempty"`
+	Severity string     `json:"severity"`
+	Method   string     `json:"method,omitempty"`
+	Vector   string     `json:"vector,omitempty"`
+}
+
+type cdxAdvisory struct {
+	URL string `json:"url"`
+}
+
+type cdxAffects struct {
+	Ref      string       `json:"ref"`
+	Versions []cdxVersion `json:"versions,omitempty"`
+}
+
+type cdxVersion struct {
+	Version string `json:"version"`
+	Status  string `json:"status"`
+}
+
+// ImageRef names the scanned image in exported BOMs.
+type ImageRef struct {
+	Repository string // namespace/name
+	Digest     string
+}
+
+// sbomSerial is the image SBOM's serial number. An image's contents never
+// change, so it is derived from the digest: every export of the SBOM, and
+// every VDR pointing at it, agree on it.
+func sbomSerial(digest string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("registryx:sbom:"+digest)).String()
+}
+
+// imageComponent describes the image itself, as metadata.component.
+func imageComponent(img ImageRef) cdxComponent {
+	return cdxComponent{
+		BOMRef:  img.Digest,
+		Type:    "container",
+		Name:    img.Repository,
+		Version: img.Digest,
+		PURL:    fmt.Sprintf("pkg:oci/%s@%s", path.Base(img.Repository), strings.Replace(img.Digest, ":", "%3A", 1)),
+	}
+}
+
+func cdxMeta(img ImageRef) cdxMetadata {
+	return cdxMetadata{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Tools: cdxTools{Components: []cdxComponent{
+			{Type: "application", Group: "aquasecurity", Name: "trivy"},
+			{Type: "application", Name: "registryx"},
+		}},
+		Component: imageComponent(img),
+	}
+}
+
+// packageRef is a package's bom-ref: its purl, or target/name@version for
+// packages Trivy has no purl for.
+func packageRef(purl, target, name, version string) string {
+	if purl != "" {
+		return purl
+	}
+	return fmt.Sprintf("%s/%s@%s", target, name, version)
+}
+
+// ToCycloneDXSBOM converts a stored Trivy JSON report into a CycloneDX SBOM
+// listing the image's packages.
+func ToCycloneDXSBOM(report []byte, img ImageRef) ([]byte, error) {
+	var inv trivyInventory
+	if err := json.Unmarshal(report, &inv); err != nil {
+		return nil, fmt.Errorf("invalid Trivy report: %w", err)
+	}
+
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  cdxSpecVersion,
+		SerialNumber: "urn:uuid:" + sbomSerial(img.Digest),
+		Version:      1,
+		Metadata:     cdxMeta(img),
+		Components:   []cdxComponent{},
+	}
+	seen := map[string]bool{}
+	add := func(c cdxComponent) {
+		if !seen[c.BOMRef] {
+			seen[c.BOMRef] = true
+			bom.Components = append(bom.Components, c)
+		}
+	}
+	for _, res := range inv.Results {
+		for _, p := range res.Packages {
+			c := cdxComponent{
+				BOMRef:  packageRef(p.Identifier.PURL, res.Target, p.Name, p.Version),
+				Type:    "library",
+				Name:    p.Name,
+				Version: p.Version,
+				PURL:    p.Identifier.PURL,
+			}
+			for _, l := range p.Licenses {
+				var lic cdxLicense
+				lic.License.Name = l
+				c.Licenses = append(c.Licenses, lic)
+			}
+			add(c)
+		}
+		for _, v := range res.Vulnerabilities {
+			add(cdxComponent{
+				BOMRef:  packageRef(v.PkgIdentifier.PURL, res.Target, v.PkgName, v.InstalledVersion),
+				Type:    "library",
+				Name:    v.PkgName,
+				Version: v.InstalledVersion,
+				PURL:    v.PkgIdentifier.PURL,
+			})
+		}
+	}
+	return json.MarshalIndent(bom, "", "  ")
+}
+
+// ToCycloneDXVDR converts a stored Trivy JSON report into a CycloneDX
+// Vulnerability Disclosure Report. It has no components of its own: each
+// vulnerability's affects are BOM-Links (urn:cdx:serial/version#bom-ref) into
+// the image's SBOM, as exported by ToCycloneDXSBOM.
+func ToCycloneDXVDR(report []byte, img ImageRef) ([]byte, error) {
+	var inv trivyInventory
+	if err := json.Unmarshal(report, &inv); err != nil {
+		return nil, fmt.Errorf("invalid Trivy report: %w", err)
+	}
+
+	sbom := fmt.Sprintf("urn:cdx:%s/1", sbomSerial(img.Digest))
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  cdxSpecVersion,
+		SerialNumber: "urn:uuid:" + uuid.NewString(),
+		Version:      1,
+		Metadata:     cdxMeta(img),
+		ExternalReferences: []cdxExternalRef{
+			{Type: "bom", URL: sbom, Comment: "SBOM of " + img.Repository + "@" + img.Digest},
+		},
+		Vulnerabilities: []cdxVulnerability{},
+	}
+
+	index := map[string]int{}
+	for _, res := range inv.Results {
+		for _, v := range res.Vulnerabilities {
+			if v.VulnerabilityID == "" {
+				continue
+			}
+			i, ok := index[v.VulnerabilityID]
+			if !ok {
+				vuln := cdxVulnerability{
+					BOMRef:      v.VulnerabilityID,
+					ID:          v.VulnerabilityID,
+					Description: v.Title,
+					Detail:      v.Description,
+					Published:   v.PublishedDate,
+					Updated:     v.LastModifiedDate,
+				}
+				if v.DataSource != nil {
+					vuln.Source = &cdxSource{Name: v.DataSource.Name, URL: v.DataSource.URL}
+				} else if v.PrimaryURL != "" {
+					vuln.Source = &cdxSource{URL: v.PrimaryURL}
+				}
+				for src, c := range v.CVSS {
+					if c.V3Score > 0 {
+						method := "CVSSv3"
+						if strings.HasPrefix(c.V3Vector, "CVSS:3.1/") {
+							method = "CVSSv31"
+						}
+						vuln.Ratings = append(vuln.Ratings, cdxRating{Source: &cdxSource{Name: src}, Score: c.V3Score,
+							Severity: cvssSeverity(c.V3Score), Method: method, Vector: c.V3Vector})
+					}
+				}
+				if v.Severity != "" {
+					rating := cdxRating{Severity: strings.ToLower(v.Severity)}
+					if v.SeveritySource != "" {
+						rating.Source = &cdxSource{Name: v.SeveritySource}
+					}
+					if rating.Severity != "critical" && rating.Severity != "high" && rating.Severity != "medium" && rating.Severity != "low" {
+						rating.Severity = "unknown"
+					}
+					vuln.Ratings = append(vuln.Ratings, rating)
+				}
+				for _, ref := range v.References {
+					vuln.Advisories = append(vuln.Advisories, cdxAdvisory{URL: ref})
+				}
+				i = len(bom.Vulnerabilities)
+				index[v.VulnerabilityID] = i
+				bom.Vulnerabilities = append(bom.Vulnerabilities, vuln)
+			}
+
+			vuln := &bom.Vulnerabilities[i]
+			if v.FixedVersion != "" {
+				fix := fmt.Sprintf("Upgrade %s to %s.", v.PkgName, v.FixedVersion)
+				if !strings.Contains(vuln.Recommendation, fix) {
+					vuln.Recommendation = strings.TrimSpace(vuln.Recommendation + " " + fix)
+				}
+			}
+			ref := sbom + "#" + url.PathEscape(packageRef(v.PkgIdentifier.PURL, res.Target, v.PkgName, v.InstalledVersion))
+			dup := false
+			for _, a := range vuln.Affects {
+				dup = dup || a.Ref == ref
+			}
+			if !dup {
+				vuln.Affects = append(vuln.Affects, cdxAffects{Ref: ref, Versions: []cdxVersion{{Version: v.InstalledVersion, Status: "affected"}}})
+			}
+		}
+	}
+	return json.MarshalIndent(bom, "", "  ")
+}
+
+// cvssSeverity is the qualitative severity of a CVSS v3 score.
+func cvssSeverity(score float64) string {
+	switch {
+	case score >= 9:
+		return "critical"
+	case score >= 7:
+		return "high"
+	case score >= 4:
+		return "medium"
+	case score > 0:
+		return "low"
+	default:
+		return "none"
+	}
+}
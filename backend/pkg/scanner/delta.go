@@ -0,0 +1,151 @@
+package scanner
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// What a scan's delta was computed against.
+const (
+	BaselinePreviousScan  = "previous_scan"  // the manifest's previous completed scan
+	BaselinePreviousImage = "previous_image" // first scan: the newest other scanned image in the repository
+	BaselineNone          = "none"           // nothing to compare with; every finding is new
+)
+
+// DeltaFinding is a vulnerable package in a scan delta.
+type DeltaFinding struct {
+	CVE              string `json:"cve"`
+	Package          string `json:"package"`
+	InstalledVersion string `json:"installedVersion,omitempty"`
+	Severity         string `json:"severity"`
+}
+
+// Key identifies a finding across scans: the same CVE in the same package,
+// whatever its installed version.
+func (f DeltaFinding) Key() string { return f.CVE + "|" + f.Package }
+
+// ScanDelta classifies a completed scan's findings against the scan before it.
+type ScanDelta struct {
+	Baseline         string         `json:"baseline"`
+	BaselineReportID *uuid.UUID     `json:"baselineReportId,omitempty"`
+	NewCount         int            `json:"newCount"`
+	FixedCount       int            `json:"fixedCount"`
+	ExistingCount    int            `json:"existingCount"`
+	New              []DeltaFinding `json:"new"`
+	Fixed            []DeltaFinding `json:"fixed"`
+}
+
+// deltaFindings lists a report's findings, one per CVE and package.
+func deltaFindings(report []byte) ([]DeltaFinding, error) {
+	var tr trivyVulnReport
+	if err := json.Unmarshal(report, &tr); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var findings []DeltaFinding
+	for _, res := range tr.Results {
+		for _, v := range res.Vulnerabilities {
+			f := DeltaFinding{CVE: v.VulnerabilityID, Package: v.PkgName, InstalledVersion: v.InstalledVersion, Severity: strings.ToUpper(v.Severity)}
+			if f.CVE == "" || seen[f.Key()] {
+				continue
+			}
+			seen[f.Key()] = true
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+// diffFindings classifies current against previous.
+func diffFindings(current, previous []DeltaFinding) ScanDelta {
+	prev := make(map[string]bool, len(previous))
+	for _, f := range previous {
+		prev[f.Key()] = true
+	}
+	cur := make(map[string]bool, len(current))
+	d := ScanDelta{New: []DeltaFinding{}, Fixed: []DeltaFinding{}}
+	for _, f := range current {
+		cur[f.Key()] = true
+		if prev[f.Key()] {
+			d.ExistingCount++
+		} else {
+			d.New = append(d.New, f)
+		}
+	}
+	for _, f := range previous {
+		if !cur[f.Key()] {
+			d.Fixed = append(d.Fixed, f)
+		}
+	}
+	bySeverity := func(fs []DeltaFinding) {
+		sort.SliceStable(fs, func(i, j int) bool { return severityOrder(fs[i].Severity) > severityOrder(fs[j].Severity) })
+	}
+	bySeverity(d.New)
+	bySeverity(d.Fixed)
+	d.NewCount, d.FixedCount = len(d.New), len(d.Fixed)
+	return d
+}
+
+func severityOrder(s string) int {
+	switch s {
+	case "CRITICAL":
+		return 4
+	case "HIGH":
+		return 3
+	case "MEDIUM":
+		return 2
+	case "LOW":
+		return 1
+	}
+	return 0
+}
+
+// computeDelta compares a new report for the manifest with its previous
+// completed scan. A manifest's first scan is compared with the newest other
+// scanned image in its repository, so findings a push introduces count as
+// new and ones it inherited don't.
+func (s *Service) computeDelta(ctx context.Context, manifestID uuid.UUID, report []byte) (*ScanDelta, error) {
+	current, err := deltaFindings(report)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := BaselinePreviousScan
+	var baseID uuid.UUID
+	var baseReport []byte
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT id, report_json FROM vulnerability_reports
+		WHERE manifest_id = $1 AND status = 'completed' AND report_json IS NOT NULL
+		ORDER BY scanned_at DESC LIMIT 1`, manifestID).Scan(&baseID, &baseReport)
+	if err == sql.ErrNoRows {
+		baseline = BaselinePreviousImage
+		err = s.DB.QueryRowContext(ctx, `
+			SELECT vr.id, vr.report_json FROM vulnerability_reports vr
+			JOIN manifests m ON vr.manifest_id = m.id
+			JOIN manifests cur ON cur.id = $1
+			WHERE m.repository_id = cur.repository_id AND m.id <> cur.id AND m.created_at <= cur.created_at
+			  AND vr.status = 'completed' AND vr.report_json IS NOT NULL
+			ORDER BY m.created_at DESC, vr.scanned_at DESC LIMIT 1`, manifestID).Scan(&baseID, &baseReport)
+	}
+	if err == sql.ErrNoRows {
+		d := diffFindings(current, nil)
+		d.Baseline = BaselineNone
+		return &d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := deltaFindings(baseReport)
+	if err != nil {
+		return nil, err
+	}
+	d := diffFindings(current, previous)
+	d.Baseline, d.BaselineReportID = baseline, &baseID
+	return &d, nil
+}
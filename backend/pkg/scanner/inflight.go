@@ -0,0 +1,44 @@
+package scanner
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// inflightScans tracks manifests currently being scanned by this process.
+type inflightScans struct {
+	mu  sync.Mutex
+	ids map[uuid.UUID]bool
+}
+
+// TryBeginScan marks a manifest as being scanned. It returns false if a scan
+// for it is already running in this process; otherwise the caller must call
+// EndScan when done.
+func (s *Service) TryBeginScan(manifestID uuid.UUID) bool {
+	s.inflight.mu.Lock()
+	defer s.inflight.mu.Unlock()
+	if s.inflight.ids[manifestID] {
+		return false
+	}
+	if s.inflight.ids == nil {
+		s.inflight.ids = make(map[uuid.UUID]bool)
+	}
+	s.inflight.ids[manifestID] = true
+	return true
+}
+
+// EndScan releases a manifest claimed with TryBeginScan.
+func (s *Service) EndScan(manifestID uuid.UUID) {
+	s.inflight.mu.Lock()
+	delete(s.inflight.ids, manifestID)
+	s.inflight.mu.Unlock()
+}
+
+// IsScanning reports whether this process is currently scanning the manifest.
+// Unlike the stored status, it cannot be left stuck by a crashed scan.
+func (s *Service) IsScanning(manifestID uuid.UUID) bool {
+	s.inflight.mu.Lock()
+	defer s.inflight.mu.Unlock()
+	return s.inflight.ids[manifestID]
+}
@@ -0,0 +1,112 @@
+package scanner
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// PackageQuery selects packages across the registry's scanned images.
+// Name matches case-insensitively. Version matches exactly, or as a prefix
+// when it ends in "*". Vulnerability keeps packages a CVE was reported
+// against. At least one of Name and Vulnerability is set.
+type PackageQuery struct {
+	Name          string
+	Version       string
+	Vulnerability string
+}
+
+// Package is a package found in an image by its latest scan.
+type Package struct {
+	Name            string   `json:"name"`
+	Version         string   `json:"version"`
+	Type            string   `json:"type,omitempty"`   // e.g. debian, alpine, gobinary, npm
+	Target          string   `json:"target,omitempty"` // where trivy found it: OS or file path
+	PURL            string   `json:"purl,omitempty"`
+	Vulnerabilities []string `json:"vulnerabilities"`
+}
+
+// PackageMatch is an image holding packages that matched a search.
+type PackageMatch struct {
+	ManifestID uuid.UUID `json:"manifestId"`
+	Repository string    `json:"repository"`
+	Digest     string    `json:"digest"`
+	Tags       []string  `json:"tags"`
+	ScannedAt  time.Time `json:"scannedAt"`
+	Packages   []Package `json:"packages"`
+}
+
+// indexPackages records the packages of a manifest's latest report.
+func (s *Service) indexPackages(ctx context.Context, manifestID uuid.UUID, report []byte) error {
+	_, err := s.DB.ExecContext(ctx, "SELECT index_image_packages($1, $2)", manifestID, report)
+	return err
+}
+
+// SearchPackages returns the images whose latest scan found packages
+// matching q, by repository and digest. include filters repositories (e.g.
+// to those the caller may read); the search stops once limit images are
+// found.
+func (s *Service) SearchPackages(ctx context.Context, q PackageQuery, include func(repo string) bool, limit int) ([]PackageMatch, error) {
+	exact, prefix := q.Version, ""
+	if strings.HasSuffix(q.Version, "*") {
+		exact = ""
+		prefix = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.TrimSuffix(q.Version, "*")) + "%"
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT m.id, n.name || '/' || r.name, m.digest,
+		       COALESCE((SELECT array_agg(t.name ORDER BY t.name) FROM tags t WHERE t.manifest_id = m.id), '{}'),
+		       COALESCE((SELECT MAX(vr.scanned_at) FROM vulnerability_reports vr
+		                 WHERE vr.manifest_id = m.id AND vr.status = 'completed'), m.created_at),
+		       p.name, p.version, p.pkg_type, p.target, p.purl, p.vulnerabilities
+		FROM image_packages p
+		JOIN manifests m ON m.id = p.manifest_id
+		JOIN repositories r ON r.id = m.repository_id
+		JOIN namespaces n ON n.id = r.namespace_id
+		WHERE ($1 = '' OR LOWER(p.name) = LOWER($1))
+		  AND ($2 = '' OR p.version = $2)
+		  AND ($3 = '' OR p.version LIKE $3)
+		  AND ($4 = '' OR $4 = ANY(p.vulnerabilities))
+		ORDER BY 2, m.digest, p.name, p.version, p.target`,
+		q.Name, exact, prefix, q.Vulnerability)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []PackageMatch{}
+	allowed := map[string]bool{}
+	for rows.Next() {
+		var m PackageMatch
+		var p Package
+		var tags, vulns pq.StringArray
+		if err := rows.Scan(&m.ManifestID, &m.Repository, &m.Digest, &tags, &m.ScannedAt,
+			&p.Name, &p.Version, &p.Type, &p.Target, &p.PURL, &vulns); err != nil {
+			return nil, err
+		}
+		ok, seen := allowed[m.Repository]
+		if !seen {
+			ok = include == nil || include(m.Repository)
+			allowed[m.Repository] = ok
+		}
+		if !ok {
+			continue
+		}
+		p.Vulnerabilities = []string(vulns)
+
+		if n := len(matches); n > 0 && matches[n-1].ManifestID == m.ManifestID {
+			matches[n-1].Packages = append(matches[n-1].Packages, p)
+			continue
+		}
+		if len(matches) == limit {
+			break
+		}
+		m.Tags = []string(tags)
+		m.Packages = []Package{p}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
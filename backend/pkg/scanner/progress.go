@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/events"
+)
+
+// Scan stages, in the order a Trivy scan normally goes through them.
+const (
+	StageStarting   = "starting"    // Trivy launched
+	StageDBDownload = "db_download" // vulnerability DB being downloaded or updated
+	StageAnalyzing  = "analyzing"   // image pulled and its layers analyzed
+	StageDetecting  = "detecting"   // matching packages of one target against the DB
+	StageSaving     = "saving"      // Trivy finished, report being stored
+	StageCompleted  = "completed"
+)
+
+// StageRequeued marks a scan interrupted by shutdown whose job was put back
+// on the queue.
+const StageRequeued = "requeued"
+
+// StageReused marks a scan completed with a copy of a recent report of the
+// same digest; the detail names the repository it was copied from.
+const StageReused = "reused"
+
+// stallTimeout is how long a scan may go without reaching a new stage before
+// it is reported as stuck.
+const stallTimeout = 5 * time.Minute
+
+// ScanStage is one step of a scan's progress.
+type ScanStage struct {
+	Stage  string    `json:"stage"`
+	Detail string    `json:"detail,omitempty"` // e.g. the OS or language target being checked
+	At     time.Time `json:"at"`
+}
+
+var (
+	// "Detected OS family=\"alpine\" version=\"3.19.1\"" or "Detected OS: alpine"
+	detectedOS = regexp.MustCompile(`Detected OS(?::\s*|\s+family="?)([\w.-]+)`)
+	// "[alpine] Detecting vulnerabilities..." or "Detecting Alpine vulnerabilities..."
+	detecting = regexp.MustCompile(`(?:\[([\w-]+)\]\s+Detecting vulnerabilities|Detecting ([\w-]+) vulnerabilities)`)
+)
+
+// stageFor maps a line of Trivy's log output to a stage. Lines that don't
+// mark progress return ok=false.
+func stageFor(line string) (stage, detail string, ok bool) {
+	switch {
+	case strings.Contains(line, "Need to update DB"),
+		strings.Contains(line, "Downloading DB"),
+		strings.Contains(line, "Downloading vulnerability DB"):
+		return StageDBDownload, "", true
+	case strings.Contains(line, "Vulnerability scanning is enabled"):
+		return StageAnalyzing, "", true
+	}
+	if m := detectedOS.FindStringSubmatch(line); m != nil {
+		return StageAnalyzing, strings.ToLower(m[1]), true
+	}
+	if m := detecting.FindStringSubmatch(line); m != nil {
+		return StageDetecting, strings.ToLower(m[1] + m[2]), true
+	}
+	return "", "", false
+}
+
+// watchProgress reads Trivy's log output, records each new stage and returns
+// the last lines read, for error messages.
+func (s *Service) watchProgress(ctx context.Context, r io.Reader, manifestID uuid.UUID, repoName, reference string) string {
+	var tail []string
+	var lastStage, lastDetail string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if tail = append(tail, line); len(tail) > 20 {
+			tail = tail[1:]
+		}
+		stage, detail, ok := stageFor(line)
+		if !ok || (stage == lastStage && detail == lastDetail) {
+			continue
+		}
+		lastStage, lastDetail = stage, detail
+		s.setStage(ctx, manifestID, repoName, reference, stage, detail)
+	}
+	return strings.Join(tail, "\n")
+}
+
+// setStage records a stage of the manifest's running scan and publishes it
+// to live clients.
+func (s *Service) setStage(ctx context.Context, manifestID uuid.UUID, repoName, reference, stage, detail string) {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE vulnerability_reports
+		SET stage = $2::text, stage_detail = $3::text, stage_updated_at = CURRENT_TIMESTAMP,
+		    stages = stages || jsonb_build_array(jsonb_build_object('stage', $2::text, 'detail', $3::text, 'at', CURRENT_TIMESTAMP))
+		WHERE id = (
+			SELECT id FROM vulnerability_reports
+			WHERE manifest_id = $1 AND status = 'scanning'
+			ORDER BY scanned_at DESC LIMIT 1
+		)`, manifestID, stage, detail)
+	if err != nil {
+		fmt.Printf("[Scanner] Failed to record stage %s for %s: %v\n", stage, manifestID, err)
+	}
+
+	data := map[string]interface{}{"stage": stage}
+	if detail != "" {
+		data["detail"] = detail
+	}
+	s.Events.Publish(events.Event{Type: events.TypeScanProgress, Repository: repoName, Reference: reference, Data: data})
+}
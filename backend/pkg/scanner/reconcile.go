@@ -0,0 +1,154 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/queue"
+)
+
+// abandonedError starts the error of scans the reconciler failed, so
+// repeated abandonments of a manifest can be counted.
+const abandonedError = "Scan abandoned"
+
+// maxAbandonedRequeues bounds how often a manifest whose scans keep being
+// abandoned is queued again; after that its scan stays failed until it is
+// triggered by hand.
+const maxAbandonedRequeues = 2
+
+// Reconciliation summarizes a reconciler pass.
+type Reconciliation struct {
+	Failed   int `json:"failed"`
+	Requeued int `json:"requeued"`
+}
+
+// ReconcileStale marks scans left in 'scanning' by a crashed worker or
+// instance as failed, by the rule GetScanStatus reports them stuck with:
+// past the scan timeout, or without reaching a new stage for stallTimeout
+// when scans have none. Each is queued again unless a job for the manifest
+// is already waiting or it was abandoned too often. A nil q only fails them.
+// Scans running in this process are left alone.
+func (s *Service) ReconcileStale(ctx context.Context, q *queue.Service) (*Reconciliation, error) {
+	since := "r.scanned_at"
+	limit := stallTimeout
+	if timeout := time.Duration(s.Config.ScanTimeoutMinutes) * time.Minute; timeout > 0 {
+		limit = timeout + time.Minute
+	} else {
+		since = "GREATEST(r.scanned_at, COALESCE(r.stage_updated_at, r.scanned_at))"
+	}
+
+	type stale struct {
+		id, manifestID uuid.UUID
+		repo, digest   string
+		stage          string
+	}
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT r.id, r.manifest_id, n.name || '/' || rp.name, m.digest, COALESCE(r.stage, '')
+		FROM vulnerability_reports r
+		JOIN manifests m ON m.id = r.manifest_id
+		JOIN repositories rp ON rp.id = m.repository_id
+		JOIN namespaces n ON n.id = rp.namespace_id
+		WHERE r.status = 'scanning' AND %s < NOW() - $1 * INTERVAL '1 second'`, since), limit.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	var found []stale
+	for rows.Next() {
+		var st stale
+		if err := rows.Scan(&st.id, &st.manifestID, &st.repo, &st.digest, &st.stage); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		found = append(found, st)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	res := &Reconciliation{}
+	for _, st := range found {
+		if s.IsScanning(st.manifestID) {
+			continue
+		}
+		reason := fmt.Sprintf("%s: no progress for %s", abandonedError, limit)
+		if st.stage != "" {
+			reason += " during " + st.stage
+		}
+		// Only the instance whose update lands requeues, so instances
+		// reconciling at once don't queue the scan twice.
+		done, err := s.DB.ExecContext(ctx, `
+			UPDATE vulnerability_reports SET status = 'failed', error = $2
+			WHERE id = $1 AND status = 'scanning'`, st.id, reason)
+		if err != nil {
+			return res, err
+		}
+		if n, _ := done.RowsAffected(); n == 0 {
+			continue
+		}
+		res.Failed++
+		fmt.Printf("[Scanner] Marked abandoned scan of %s@%s failed\n", st.repo, st.digest)
+		s.publishFailed(st.repo, st.digest, reason)
+
+		if q == nil {
+			continue
+		}
+		requeue, err := s.shouldRequeue(ctx, q, st.manifestID)
+		if err != nil {
+			fmt.Printf("[Scanner] Not requeuing abandoned scan of %s@%s: %v\n", st.repo, st.digest, err)
+			continue
+		}
+		if !requeue {
+			continue
+		}
+		if err := q.EnqueueScan(ctx, st.manifestID, st.repo, st.digest); err != nil {
+			fmt.Printf("[Scanner] Failed to requeue abandoned scan of %s@%s: %v\n", st.repo, st.digest, err)
+			continue
+		}
+		res.Requeued++
+	}
+	return res, nil
+}
+
+// shouldRequeue reports whether an abandoned scan of the manifest should be
+// queued again: no job for it is waiting, and its scans haven't been
+// abandoned more than maxAbandonedRequeues times since the last completed one.
+func (s *Service) shouldRequeue(ctx context.Context, q *queue.Service, manifestID uuid.UUID) (bool, error) {
+	pending, err := q.ScanPending(ctx, manifestID)
+	if err != nil || pending {
+		return false, err
+	}
+	var abandoned int
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM vulnerability_reports
+		WHERE manifest_id = $1 AND status = 'failed' AND error LIKE $2 || '%'
+		  AND scanned_at > COALESCE((
+			SELECT MAX(scanned_at) FROM vulnerability_reports
+			WHERE manifest_id = $1 AND status = 'completed'), '-infinity')`,
+		manifestID, abandonedError).Scan(&abandoned)
+	return abandoned <= maxAbandonedRequeues, err
+}
+
+// StartReconciler fails and requeues abandoned scans every interval until
+// ctx is done.
+func (s *Service) StartReconciler(ctx context.Context, q *queue.Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		res, err := s.ReconcileStale(ctx, q)
+		if err != nil {
+			fmt.Printf("[Scanner] Reconciling abandoned scans failed: %v\n", err)
+			continue
+		}
+		if res.Failed > 0 {
+			fmt.Printf("[Scanner] Failed %d abandoned scans, requeued %d\n", res.Failed, res.Requeued)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package scanner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReuseRecent completes a queued scan of the manifest with a recent report
+// of the same digest, from any repository, instead of running Trivy again.
+// Reports older than SCAN_REUSE_HOURS, or made before the vulnerability DB
+// was last installed, are not reused. It reports whether a report was
+// reused; when it was not, the caller scans as usual.
+func (s *Service) ReuseRecent(ctx context.Context, manifestID uuid.UUID, repoName, reference string) (bool, error) {
+	ttl := time.Duration(s.Config.ScanReuseHours) * time.Hour
+	if ttl <= 0 {
+		return false, nil
+	}
+	since := time.Now().Add(-ttl)
+	if s.TrivyDB != nil {
+		st := s.TrivyDB.Status()
+		installed := st.ImportedAt
+		if installed == nil {
+			installed = st.UpdatedAt
+		}
+		if installed != nil && installed.After(since) {
+			since = *installed
+		}
+	}
+
+	var sourceID, sourceManifest uuid.UUID
+	var sourceRepo string
+	var report []byte
+	var summary ScanSummary
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT r.id, m.id, n.name || '/' || rp.name, r.report_json,
+		       r.critical_count, r.high_count, r.medium_count, r.low_count
+		FROM manifests self
+		JOIN manifests m ON m.digest = self.digest
+		JOIN vulnerability_reports r ON r.manifest_id = m.id
+		JOIN repositories rp ON rp.id = m.repository_id
+		JOIN namespaces n ON n.id = rp.namespace_id
+		WHERE self.id = $1 AND r.status = 'completed' AND r.report_json IS NOT NULL
+		  AND r.scanned_at > $2
+		ORDER BY r.scanned_at DESC LIMIT 1`, manifestID, since).Scan(
+		&sourceID, &sourceManifest, &sourceRepo, &report,
+		&summary.Critical, &summary.High, &summary.Medium, &summary.Low)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Re-pushed or re-tagged: the manifest's own report is still current,
+	// so only a scan left pending by an interrupted run is dropped.
+	if sourceManifest == manifestID {
+		if _, err := s.DB.ExecContext(ctx, `
+			DELETE FROM vulnerability_reports
+			WHERE manifest_id = $1 AND status IN ('pending', 'scanning')`, manifestID); err != nil {
+			return false, err
+		}
+		fmt.Printf("[Scanner] %s:%s was scanned recently, not scanning again\n", repoName, reference)
+		return true, nil
+	}
+
+	s.updateStatus(ctx, manifestID, "scanning")
+	s.setStage(ctx, manifestID, repoName, reference, StageReused, sourceRepo)
+	if err := s.saveReport(ctx, manifestID, report, summary); err != nil {
+		s.MarkFailed(ctx, manifestID, repoName, reference, err.Error())
+		return false, err
+	}
+	fmt.Printf("[Scanner] Reused scan of %s (report %s) for %s:%s\n", sourceRepo, sourceID, repoName, reference)
+	s.publishCompleted(repoName, reference, summary)
+	return true, nil
+}
@@ -0,0 +1,237 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// trivyVulnReport is the part of Trivy's JSON report SARIF needs.
+type trivyVulnReport struct {
+	ArtifactName string `json:"ArtifactName"`
+	Results      []struct {
+		Target          string `json:"Target"`
+		Class           string `json:"Class"`
+		Type            string `json:"Type"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			PkgPath          string `json:"PkgPath"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+			Title            string `json:"Title"`
+			Description      string `json:"Description"`
+			PrimaryURL       string `json:"PrimaryURL"`
+			CVSS             map[string]struct {
+				V3Score float64 `json:"V3Score"`
+			} `json:"CVSS"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	FullName       string      `json:"fullName"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifText struct {
+	Text     string `json:"text"`
+	Markdown string `json:"markdown,omitempty"`
+}
+
+type sarifRule struct {
+	ID                   string                 `json:"id"`
+	Name                 string                 `json:"name"`
+	ShortDescription     sarifText              `json:"shortDescription"`
+	FullDescription      sarifText              `json:"fullDescription"`
+	HelpURI              string                 `json:"helpUri,omitempty"`
+	Help                 sarifText              `json:"help"`
+	DefaultConfiguration map[string]string      `json:"defaultConfiguration"`
+	Properties           map[string]interface{} `json:"properties"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifText       `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI       string `json:"uri"`
+			URIBaseID string `json:"uriBaseId"`
+		} `json:"artifactLocation"`
+		Region struct {
+			StartLine   int `json:"startLine"`
+			StartColumn int `json:"startColumn"`
+			EndLine     int `json:"endLine"`
+			EndColumn   int `json:"endColumn"`
+		} `json:"region"`
+	} `json:"physicalLocation"`
+	Message sarifText `json:"message"`
+}
+
+// sarifLevel maps a Trivy severity to a SARIF result level.
+func sarifLevel(severity string) string {
+	switch strings.ToUpper(severity) {
+	case "CRITICAL", "HIGH":
+		return "error"
+	case "MEDIUM":
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// securitySeverity is the 0-10 score GitHub code scanning ranks alerts by:
+// the highest CVSS v3 score Trivy found, else one typical of the severity.
+func securitySeverity(severity string, cvss map[string]struct {
+	V3Score float64 `json:"V3Score"`
+}) string {
+	var score float64
+	for _, c := range cvss {
+		if c.V3Score > score {
+			score = c.V3Score
+		}
+	}
+	if score == 0 {
+		switch strings.ToUpper(severity) {
+		case "CRITICAL":
+			score = 9.5
+		case "HIGH":
+			score = 8.0
+		case "MEDIUM":
+			score = 5.5
+		case "LOW":
+			score = 2.0
+		}
+	}
+	return fmt.Sprintf("%.1f", score)
+}
+
+// ToSARIF converts a stored Trivy JSON report into a SARIF 2.1.0 log, as
+// accepted by GitHub code scanning. There is one rule per vulnerability ID and
+// one result per affected package. OS packages are located at artifact (the
+// image name, as Trivy's own SARIF output does); language packages at the
+// file they were found in.
+func ToSARIF(report []byte, artifact string) ([]byte, error) {
+	var tr trivyVulnReport
+	if err := json.Unmarshal(report, &tr); err != nil {
+		return nil, fmt.Errorf("invalid Trivy report: %w", err)
+	}
+	if artifact == "" {
+		artifact = tr.ArtifactName
+	}
+
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "Trivy",
+			FullName:       "Trivy Vulnerability Scanner",
+			InformationURI: "https://github.com/aquasecurity/trivy",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	ruleIndex := map[string]int{}
+
+	for _, res := range tr.Results {
+		for _, v := range res.Vulnerabilities {
+			if v.VulnerabilityID == "" {
+				continue
+			}
+			idx, ok := ruleIndex[v.VulnerabilityID]
+			if !ok {
+				title := v.Title
+				if title == "" {
+					title = v.VulnerabilityID
+				}
+				desc := v.Description
+				if desc == "" {
+					desc = title
+				}
+				idx = len(run.Tool.Driver.Rules)
+				ruleIndex[v.VulnerabilityID] = idx
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+					ID:               v.VulnerabilityID,
+					Name:             "OsPackageVulnerability",
+					ShortDescription: sarifText{Text: title},
+					FullDescription:  sarifText{Text: desc},
+					HelpURI:          v.PrimaryURL,
+					Help: sarifText{
+						Text: fmt.Sprintf("Vulnerability %s\nSeverity: %s\nPackage: %s\nFixed Version: %s\nLink: %s\n%s",
+							v.VulnerabilityID, v.Severity, v.PkgName, v.FixedVersion, v.PrimaryURL, desc),
+						Markdown: fmt.Sprintf("**Vulnerability %s**\n| Severity | Package | Fixed Version | Link |\n| --- | --- | --- | --- |\n|%s|%s|%s|[%s](%s)|\n\n%s",
+							v.VulnerabilityID, v.Severity, v.PkgName, v.FixedVersion, v.VulnerabilityID, v.PrimaryURL, desc),
+					},
+					DefaultConfiguration: map[string]string{"level": sarifLevel(v.Severity)},
+					Properties: map[string]interface{}{
+						"precision":         "very-high",
+						"security-severity": securitySeverity(v.Severity, v.CVSS),
+						"tags":              []string{"vulnerability", "security", strings.ToUpper(v.Severity)},
+					},
+				})
+				if res.Class != "os-pkgs" {
+					run.Tool.Driver.Rules[idx].Name = "LanguageSpecificPackageVulnerability"
+				}
+			}
+
+			uri := artifact
+			if res.Class != "os-pkgs" {
+				uri = res.Target
+				if v.PkgPath != "" {
+					uri = v.PkgPath
+				}
+			}
+			fixed := v.FixedVersion
+			if fixed == "" {
+				fixed = "none"
+			}
+
+			var loc sarifLocation
+			loc.PhysicalLocation.ArtifactLocation.URI = strings.TrimPrefix(uri, "/")
+			loc.PhysicalLocation.ArtifactLocation.URIBaseID = "ROOTPATH"
+			loc.PhysicalLocation.Region.StartLine = 1
+			loc.PhysicalLocation.Region.StartColumn = 1
+			loc.PhysicalLocation.Region.EndLine = 1
+			loc.PhysicalLocation.Region.EndColumn = 1
+			loc.Message = sarifText{Text: fmt.Sprintf("%s: %s@%s", res.Target, v.PkgName, v.InstalledVersion)}
+
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    v.VulnerabilityID,
+				RuleIndex: idx,
+				Level:     sarifLevel(v.Severity),
+				Message: sarifText{Text: fmt.Sprintf("Package: %s\nInstalled Version: %s\nVulnerability %s\nSeverity: %s\nFixed Version: %s\nLink: [%s](%s)",
+					v.PkgName, v.InstalledVersion, v.VulnerabilityID, v.Severity, fixed, v.VulnerabilityID, v.PrimaryURL)},
+				Locations: []sarifLocation{loc},
+			})
+		}
+	}
+
+	return json.MarshalIndent(sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}}, "", "  ")
+}
@@ -1,21 +1,42 @@
 package scanner
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/registryx/registryx/backend/pkg/config"
+	"github.com/registryx/registryx/backend/pkg/events"
+	"github.com/registryx/registryx/backend/pkg/trivydb"
+	"github.com/registryx/registryx/backend/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// ErrScanTimeout fails a scan that ran longer than SCAN_TIMEOUT_MINUTES.
+var ErrScanTimeout = errors.New("scan timed out")
+
 type Service struct {
 	DB     *sql.DB
 	Config *config.Config
+	Events *events.Broker // optional; set by main when live updates are enabled
+
+	// TrivyDB, when set, refuses scans while the vulnerability DB is too old
+	// and adds the offline flags to the trivy command.
+	TrivyDB *trivydb.Manager
+
+	// PullToken mints the short-lived token trivy pulls the image with; set
+	// by main.
+	PullToken func(ctx context.Context, repoName string) (string, error)
+
+	inflight inflightScans
 }
 
 func NewService(db *sql.DB, cfg *config.Config) *Service {
@@ -28,14 +49,45 @@ func NewService(db *sql.DB, cfg *config.Config) *Service {
 // ScanManifest triggers a Trivy scan for the given manifest.
 // For MVP, this runs 'trivy' as a subprocess.
 // In prod, this would likely enqueue a job to a worker pool.
-// ScanManifest triggers a Trivy scan for the given manifest.
-// For MVP, this runs 'trivy' as a subprocess.
-// In prod, this would likely enqueue a job to a worker pool.
-func (s *Service) ScanManifest(ctx context.Context, manifestID uuid.UUID, repoName, reference string) {
+//
+// A failed scan is recorded and returned. A scan running longer than
+// SCAN_TIMEOUT_MINUTES is killed and fails with a timeout; it can simply be
+// triggered again. When ctx is cancelled mid-scan, trivy is killed, the
+// scan's record goes back to pending and ctx.Err() is returned; the caller is
+// expected to requeue the job.
+func (s *Service) ScanManifest(ctx context.Context, manifestID uuid.UUID, repoName, reference string) (err error) {
+	ctx, span := tracing.Start(ctx, "scanner.ScanManifest", attribute.String("registry.repository", repoName), attribute.String("registry.reference", reference), attribute.String("registry.manifest_id", manifestID.String()))
+	defer func() { tracing.End(span, err) }()
 	fmt.Printf("Scanning manifest %s (repo: %s, ref: %s)...\n", manifestID, repoName, reference)
 
+	timeout := time.Duration(s.Config.ScanTimeoutMinutes) * time.Minute
+	scanCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		scanCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	fail := func(err error) error {
+		if ctx.Err() != nil {
+			s.MarkRequeued(manifestID, repoName, reference)
+			return ctx.Err()
+		}
+		if scanCtx.Err() != nil {
+			err = fmt.Errorf("%w after %s", ErrScanTimeout, timeout)
+		}
+		s.MarkFailed(ctx, manifestID, repoName, reference, err.Error())
+		return err
+	}
+
+	// An outdated DB would report old images as clean; refuse instead.
+	if err := s.TrivyDB.Check(); err != nil {
+		fmt.Printf("[Scanner] Refusing scan of %s: %v\n", manifestID, err)
+		return fail(err)
+	}
+
 	// Update status to 'scanning'
 	s.updateStatus(ctx, manifestID, "scanning")
+	s.Events.Publish(events.Event{Type: events.TypeScanStarted, Repository: repoName, Reference: reference})
 
 	// Run Trivy
 	// Point trivy to the registry URL.
@@ -49,52 +101,97 @@ func (s *Service) ScanManifest(ctx context.Context, manifestID uuid.UUID, repoNa
 		imageURI = fmt.Sprintf("localhost:%s/%s:%s", port, repoName, reference)
 	}
 	
-	// Command: trivy image --format json --output - <imageURI>
+	// Command: trivy image --format json <imageURI>
 	// Note: We might need --insecure if using http/self-signed.
-	cmd := exec.CommandContext(ctx, "trivy", "image", "--format", "json", "-q", "--insecure", imageURI)
-	
-	// Environment for auth if needed
-	// cmd.Env = append(os.Environ(), "TRIVY_USERNAME=admin", "TRIVY_PASSWORD=...")
+	// The report goes to stdout; the log on stderr tells us how far the scan got.
+	// --list-all-pkgs keeps the full package inventory for SBOM exports.
+	args := append([]string{"image", "--format", "json", "--list-all-pkgs", "--no-progress", "--insecure"}, s.TrivyDB.ScanArgs()...)
+	cmd := exec.CommandContext(scanCtx, "trivy", append(args, imageURI)...)
+
+	// The registry has no unauthenticated local access; trivy presents a
+	// token scoped to this repository (in the environment, not the args,
+	// so it doesn't show in the process list).
+	if s.PullToken != nil {
+		token, err := s.PullToken(scanCtx, repoName)
+		if err != nil {
+			fmt.Printf("[Scanner] Failed to mint pull token for manifest %s: %v\n", manifestID, err)
+			return fail(err)
+		}
+		cmd.Env = append(os.Environ(), "TRIVY_REGISTRY_TOKEN="+token)
+	}
 
-	output, err := cmd.CombinedOutput()
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	stderr, err := cmd.StderrPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
 	if err != nil {
+		fmt.Printf("[Scanner] Failed to start trivy for manifest %s: %v\n", manifestID, err)
+		return fail(err)
+	}
+	s.setStage(scanCtx, manifestID, repoName, reference, StageStarting, "")
+	logTail := s.watchProgress(scanCtx, stderr, manifestID, repoName, reference)
+
+	output := stdout.Bytes()
+	if err := cmd.Wait(); err != nil {
 		fmt.Printf("[Scanner] Scan failed for manifest %s (repo: %s, ref: %s): %v. Output: %s\n", 
-			manifestID, repoName, reference, err, string(output))
-		s.updateStatus(ctx, manifestID, "failed")
-		return
+			manifestID, repoName, reference, err, logTail)
+		return fail(err)
 	}
+	s.setStage(scanCtx, manifestID, repoName, reference, StageSaving, "")
 
 	// Parse Logic
 	_, summary, err := parseTrivyOutput(output)
 	if err != nil {
 		fmt.Printf("Parse failed: %v\n", err)
-		s.updateStatus(ctx, manifestID, "failed")
-		return
+		return fail(err)
 	}
 
 	// Store Report
-	err = s.saveReport(ctx, manifestID, output, summary)
+	err = s.saveReport(scanCtx, manifestID, output, summary)
 	if err != nil {
 		fmt.Printf("Save report failed: %v\n", err)
-	} else {
-		fmt.Printf("Scan completed for %s\n", reference)
+		return fail(err)
+	}
+	fmt.Printf("Scan completed for %s\n", reference)
+	s.publishCompleted(repoName, reference, summary)
+	return nil
+}
+
+func (s *Service) publishCompleted(repoName, reference string, summary ScanSummary) {
+	s.Events.Publish(events.Event{
+		Type:       events.TypeScanComplete,
+		Repository: repoName,
+		Reference:  reference,
+		Data: map[string]interface{}{
+			"critical": summary.Critical,
+			"high":     summary.High,
+			"medium":   summary.Medium,
+			"low":      summary.Low,
+		},
+	})
+}
+
+func (s *Service) publishFailed(repoName, reference, reason string) {
+	e := events.Event{Type: events.TypeScanFailed, Repository: repoName, Reference: reference}
+	if reason != "" {
+		e.Data = map[string]interface{}{"error": reason}
 	}
+	s.Events.Publish(e)
 }
 
+// updateStatus moves the manifest's scan in progress to status, or adds a
+// record when there is none. A manifest has at most one scan in progress
+// (pending or scanning): a scan requeued at shutdown carries on in its
+// record, and one restarted after its worker died takes over the stale one.
 func (s *Service) updateStatus(ctx context.Context, manifestID uuid.UUID, status string) {
-	// Upsert initial record if not exists?
-	// The table `vulnerability_reports` should ideally be 1:1 or 1:Many with manifest.
-	// Schema: id, manifest_id, status...
-	
 	_, err := s.DB.ExecContext(ctx, `
 		INSERT INTO vulnerability_reports (manifest_id, scanner, status)
 		VALUES ($1, 'trivy', $2)
-		ON CONFLICT (id) DO UPDATE SET status = EXCLUDED.status`, 
-		// Wait, id constraint? We need to find by manifest_id.
-		// Let's assume we insert on creation or allow duplicate reports (history).
-		// For MVP, let's just insert a new report or update the latest one.
+		ON CONFLICT (manifest_id) WHERE status IN ('pending', 'scanning')
+		DO UPDATE SET status = EXCLUDED.status, error = '', scanned_at = CURRENT_TIMESTAMP`,
 		manifestID, status)
-	
 	if err != nil {
 		// Just log
 		fmt.Println("Error updating scan status:", err)
@@ -102,22 +199,109 @@ func (s *Service) updateStatus(ctx context.Context, manifestID uuid.UUID, status
 }
 
 func (s *Service) saveReport(ctx context.Context, manifestID uuid.UUID, rawJSON []byte, summary ScanSummary) error {
+	// Compared before saving, while the previous completed scan is still the latest.
+	var delta []byte
+	if d, err := s.computeDelta(ctx, manifestID, rawJSON); err != nil {
+		fmt.Printf("[Scanner] Failed to compare scan of %s with the previous one: %v\n", manifestID, err)
+	} else {
+		delta, _ = json.Marshal(d)
+	}
+
 	_, err := s.DB.ExecContext(ctx, `
 		UPDATE vulnerability_reports 
 		SET status = 'completed', 
 		    report_json = $2,
+		    stage = 'completed', stage_updated_at = CURRENT_TIMESTAMP,
+		    stages = stages || jsonb_build_array(jsonb_build_object('stage', 'completed', 'detail', '', 'at', CURRENT_TIMESTAMP)),
 			critical_count = $3,
 			high_count = $4,
 			medium_count = $5,
 			low_count = $6,
+			delta = $7,
 			scanned_at = CURRENT_TIMESTAMP
 		WHERE id = (
 			SELECT id FROM vulnerability_reports 
 			WHERE manifest_id = $1 AND status = 'scanning'
 			ORDER BY scanned_at DESC LIMIT 1
 		)`,
-		manifestID, rawJSON, summary.Critical, summary.High, summary.Medium, summary.Low)
-	return err
+		manifestID, rawJSON, summary.Critical, summary.High, summary.Medium, summary.Low, delta)
+	if err != nil {
+		return err
+	}
+
+	// The package search reads an index of the report, not the report.
+	if err := s.indexPackages(ctx, manifestID, rawJSON); err != nil {
+		fmt.Printf("[Scanner] Failed to index packages of %s: %v\n", manifestID, err)
+	}
+	return nil
+}
+
+// MarkScanning records that a scan for the manifest has started elsewhere
+// (e.g. on an external worker).
+func (s *Service) MarkScanning(ctx context.Context, manifestID uuid.UUID, repoName, reference string) {
+	s.updateStatus(ctx, manifestID, "scanning")
+	s.Events.Publish(events.Event{Type: events.TypeScanStarted, Repository: repoName, Reference: reference})
+}
+
+// MarkFailed records a failed scan attempt for the manifest, with the reason
+// shown in its scan status. The running scan's record is marked failed, or a
+// new one is added when there is none (e.g. the scan was refused).
+func (s *Service) MarkFailed(ctx context.Context, manifestID uuid.UUID, repoName, reference, reason string) {
+	res, err := s.DB.ExecContext(ctx, `
+		UPDATE vulnerability_reports SET status = 'failed', error = $2
+		WHERE id = (
+			SELECT id FROM vulnerability_reports
+			WHERE manifest_id = $1 AND status = 'scanning'
+			ORDER BY scanned_at DESC LIMIT 1
+		)`, manifestID, reason)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			_, err = s.DB.ExecContext(ctx, `
+				INSERT INTO vulnerability_reports (manifest_id, scanner, status, error)
+				VALUES ($1, 'trivy', 'failed', $2)`, manifestID, reason)
+		}
+	}
+	if err != nil {
+		fmt.Println("Error updating scan status:", err)
+	}
+	s.publishFailed(repoName, reference, reason)
+}
+
+// MarkRequeued puts the manifest's running scan back to pending after it was
+// interrupted by shutdown and its job requeued; the next scan of the manifest
+// carries on in the same record. The caller's context is already cancelled,
+// so the update gets its own.
+func (s *Service) MarkRequeued(manifestID uuid.UUID, repoName, reference string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE vulnerability_reports
+		SET status = 'pending', error = 'Interrupted by shutdown; queued to run again',
+		    stage = $2::text, stage_detail = '', stage_updated_at = CURRENT_TIMESTAMP,
+		    stages = stages || jsonb_build_array(jsonb_build_object('stage', $2::text, 'detail', '', 'at', CURRENT_TIMESTAMP))
+		WHERE id = (
+			SELECT id FROM vulnerability_reports
+			WHERE manifest_id = $1 AND status = 'scanning'
+			ORDER BY scanned_at DESC LIMIT 1
+		)`, manifestID, StageRequeued)
+	if err != nil {
+		fmt.Println("Error updating scan status:", err)
+	}
+	s.Events.Publish(events.Event{Type: events.TypeScanProgress, Repository: repoName, Reference: reference, Data: map[string]interface{}{"stage": StageRequeued}})
+}
+
+// SubmitReport parses and stores a Trivy JSON report produced outside this process.
+func (s *Service) SubmitReport(ctx context.Context, manifestID uuid.UUID, repoName, reference string, rawJSON []byte) (*ScanSummary, error) {
+	_, summary, err := parseTrivyOutput(rawJSON)
+	if err != nil {
+		s.MarkFailed(ctx, manifestID, repoName, reference, err.Error())
+		return nil, fmt.Errorf("invalid trivy report: %w", err)
+	}
+	if err := s.saveReport(ctx, manifestID, rawJSON, summary); err != nil {
+		return nil, err
+	}
+	s.publishCompleted(repoName, reference, summary)
+	return &summary, nil
 }
 
 type ScanSummary struct {
@@ -195,20 +379,31 @@ type ScanStatus struct {
 	ScannedAt   *string      `json:"scanned_at,omitempty"`
 	Summary     *ScanSummary `json:"summary,omitempty"`
 	Error       string       `json:"error,omitempty"`
+
+	// Progress of the latest scan; scans run by external workers only report
+	// start and completion.
+	Stage          string      `json:"stage,omitempty"`
+	StageDetail    string      `json:"stage_detail,omitempty"`
+	StageUpdatedAt *string     `json:"stage_updated_at,omitempty"`
+	Stages         []ScanStage `json:"stages,omitempty"`
 }
 
 // GetScanStatus returns the current scan status for a manifest
 func (s *Service) GetScanStatus(ctx context.Context, manifestID uuid.UUID) (*ScanStatus, error) {
 	var status ScanStatus
-	var scannedAt sql.NullTime
+	var scannedAt, stageUpdatedAt sql.NullTime
 	var critical, high, medium, low sql.NullInt64
+	var stage sql.NullString
+	var stagesJSON []byte
 	
 	err := s.DB.QueryRowContext(ctx, `
-		SELECT status, scanned_at, critical_count, high_count, medium_count, low_count
+		SELECT status, scanned_at, critical_count, high_count, medium_count, low_count,
+		       stage, stage_detail, stage_updated_at, stages, error
 		FROM vulnerability_reports
 		WHERE manifest_id = $1
 		ORDER BY scanned_at DESC LIMIT 1`, manifestID).Scan(
-		&status.Status, &scannedAt, &critical, &high, &medium, &low)
+		&status.Status, &scannedAt, &critical, &high, &medium, &low,
+		&stage, &status.StageDetail, &stageUpdatedAt, &stagesJSON, &status.Error)
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -222,12 +417,35 @@ func (s *Service) GetScanStatus(ctx context.Context, manifestID uuid.UUID) (*Sca
 		timeStr := scannedAt.Time.Format("2006-01-02T15:04:05Z")
 		status.ScannedAt = &timeStr
 	}
+	status.Stage = stage.String
+	if stageUpdatedAt.Valid {
+		timeStr := stageUpdatedAt.Time.UTC().Format(time.RFC3339)
+		status.StageUpdatedAt = &timeStr
+	}
+	if len(stagesJSON) > 0 {
+		_ = json.Unmarshal(stagesJSON, &status.Stages)
+	}
 	
-	if status.Status == "scanning" && scannedAt.Valid {
-		// If it's been scanning for more than 5 minutes, consider it failed/stuck
-		if time.Since(scannedAt.Time) > 5*time.Minute {
+	if timeout := time.Duration(s.Config.ScanTimeoutMinutes) * time.Minute; status.Status == "scanning" && scannedAt.Valid && timeout > 0 {
+		// The scan would have been killed by now; whatever ran it is gone.
+		if time.Since(scannedAt.Time) > timeout+time.Minute {
 			status.Status = "failed"
-			status.Error = "Scan timed out (started > 5m ago)"
+			status.Error = fmt.Sprintf("Scan timed out (started > %s ago)", timeout)
+		}
+	} else if status.Status == "scanning" && scannedAt.Valid {
+		// A slow scan keeps reaching new stages; one that hasn't moved for
+		// stallTimeout is considered stuck.
+		lastProgress := scannedAt.Time
+		if stageUpdatedAt.Valid && stageUpdatedAt.Time.After(lastProgress) {
+			lastProgress = stageUpdatedAt.Time
+		}
+		if time.Since(lastProgress) > stallTimeout {
+			status.Status = "failed"
+			if status.Stage != "" {
+				status.Error = fmt.Sprintf("Scan stalled (no progress for 5m during %s)", status.Stage)
+			} else {
+				status.Error = "Scan timed out (started > 5m ago)"
+			}
 		}
 	}
 
@@ -270,12 +488,13 @@ type ScanHistoryEntry struct {
 	Status    string       `json:"status"`
 	ScannedAt *string      `json:"scanned_at,omitempty"`
 	Summary   *ScanSummary `json:"summary,omitempty"`
+	Delta     *ScanDelta   `json:"delta,omitempty"` // findings new, fixed and unchanged since the scan before
 }
 
 // GetScanHistory returns all scan attempts for a manifest
 func (s *Service) GetScanHistory(ctx context.Context, manifestID uuid.UUID) ([]ScanHistoryEntry, error) {
 	rows, err := s.DB.QueryContext(ctx, `
-		SELECT id, status, scanned_at, critical_count, high_count, medium_count, low_count
+		SELECT id, status, scanned_at, critical_count, high_count, medium_count, low_count, delta
 		FROM vulnerability_reports
 		WHERE manifest_id = $1
 		ORDER BY scanned_at DESC`, manifestID)
@@ -290,8 +509,9 @@ func (s *Service) GetScanHistory(ctx context.Context, manifestID uuid.UUID) ([]S
 		var entry ScanHistoryEntry
 		var scannedAt sql.NullTime
 		var critical, high, medium, low sql.NullInt64
+		var delta []byte
 		
-		err := rows.Scan(&entry.ID, &entry.Status, &scannedAt, &critical, &high, &medium, &low)
+		err := rows.Scan(&entry.ID, &entry.Status, &scannedAt, &critical, &high, &medium, &low, &delta)
 		if err != nil {
 			return nil, err
 		}
@@ -308,6 +528,12 @@ func (s *Service) GetScanHistory(ctx context.Context, manifestID uuid.UUID) ([]S
 				Medium:   int(medium.Int64),
 				Low:      int(low.Int64),
 			}
+			if delta != nil {
+				var d ScanDelta
+				if json.Unmarshal(delta, &d) == nil {
+					entry.Delta = &d
+				}
+			}
 		}
 		
 		history = append(history, entry)
@@ -0,0 +1,292 @@
+// Package signing holds the HMAC keys that sign session and registry tokens.
+// Tokens carry the ID of their key in the "kid" header, so the primary key can
+// be rotated while tokens signed by earlier keys stay valid until they expire.
+package signing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key sources.
+const (
+	SourceConfig    = "config"    // JWT_SECRET
+	SourceGenerated = "generated" // created by Rotate
+)
+
+// refreshInterval limits reloads triggered by tokens with an unknown kid.
+const refreshInterval = 10 * time.Second
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Key describes a signing key. The secret itself is never serialized.
+type Key struct {
+	ID        string     `json:"kid"`
+	Source    string     `json:"source"`
+	Primary   bool       `json:"primary"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // when a retired key stops validating
+
+	secret []byte
+}
+
+// Keyring keeps the signing keys shared through the signing_keys table. The
+// key without retired_at signs new tokens; retired keys validate tokens for
+// RetainFor after retirement, which must cover the longest token lifetime.
+type Keyring struct {
+	DB        *sql.DB
+	RetainFor time.Duration
+
+	config []byte // JWT_SECRET
+
+	mu       sync.RWMutex
+	keys     map[string]*Key
+	primary  *Key
+	loadedAt time.Time
+}
+
+// NewKeyring starts with the configured secret as the only, primary key.
+// Call Init to pick up keys shared by other instances.
+func NewKeyring(db *sql.DB, secret string, retainFor time.Duration) *Keyring {
+	k := &Key{ID: ConfigKeyID(secret), Source: SourceConfig, Primary: true, CreatedAt: time.Now(), secret: []byte(secret)}
+	return &Keyring{
+		DB:        db,
+		RetainFor: retainFor,
+		config:    []byte(secret),
+		keys:      map[string]*Key{k.ID: k},
+		primary:   k,
+	}
+}
+
+// ConfigKeyID derives a stable key ID from a configured secret, so every
+// instance started with the same JWT_SECRET agrees on it.
+func ConfigKeyID(secret string) string {
+	sum := sha256.Sum256([]byte("registryx-kid:" + secret))
+	return "cfg-" + hex.EncodeToString(sum[:6])
+}
+
+// Init records the configured secret. A JWT_SECRET not seen before becomes
+// the primary key and retires the previous one, so changing the setting and
+// restarting rotates the key.
+func (k *Keyring) Init(ctx context.Context) error {
+	tx, err := k.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize with other instances starting at the same time.
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE signing_keys IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+	id := ConfigKeyID(string(k.config))
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM signing_keys WHERE kid = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		if _, err := tx.ExecContext(ctx, `UPDATE signing_keys SET retired_at = NOW() WHERE retired_at IS NULL`); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO signing_keys (kid, secret, source) VALUES ($1, $2, $3)`,
+			id, base64.StdEncoding.EncodeToString(k.config), SourceConfig); err != nil {
+			return err
+		}
+		fmt.Printf("[Signing] JWT_SECRET changed; key %s is now primary\n", id)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return k.Reload(ctx)
+}
+
+// Rotate generates a new primary key and retires the current one.
+func (k *Keyring) Rotate(ctx context.Context) (*Key, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	idBytes := make([]byte, 6)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	id := "gen-" + hex.EncodeToString(idBytes)
+
+	tx, err := k.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `UPDATE signing_keys SET retired_at = NOW() WHERE retired_at IS NULL`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO signing_keys (kid, secret, source) VALUES ($1, $2, $3)`,
+		id, base64.StdEncoding.EncodeToString(buf), SourceGenerated); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if err := k.Reload(ctx); err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	out := *key
+	return &out, nil
+}
+
+// Reload reads the keys that can still validate tokens.
+func (k *Keyring) Reload(ctx context.Context) error {
+	rows, err := k.DB.QueryContext(ctx, `
+		SELECT kid, secret, source, created_at, retired_at FROM signing_keys
+		WHERE retired_at IS NULL OR retired_at > $1
+		ORDER BY created_at`, time.Now().Add(-k.RetainFor))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]*Key)
+	var primary *Key
+	for rows.Next() {
+		var key Key
+		var secret string
+		var retired sql.NullTime
+		if err := rows.Scan(&key.ID, &secret, &key.Source, &key.CreatedAt, &retired); err != nil {
+			return err
+		}
+		if secret == "" {
+			continue
+		}
+		if key.secret, err = base64.StdEncoding.DecodeString(secret); err != nil {
+			return fmt.Errorf("key %s: %w", key.ID, err)
+		}
+		if retired.Valid {
+			expires := retired.Time.Add(k.RetainFor)
+			key.RetiredAt, key.ExpiresAt = &retired.Time, &expires
+		} else {
+			key.Primary = true
+			primary = &key
+		}
+		keys[key.ID] = &key
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.loadedAt = time.Now()
+	if primary == nil {
+		// Nothing stored yet (Init failed or never ran); keep what we have.
+		return nil
+	}
+	k.keys, k.primary = keys, primary
+	return nil
+}
+
+// Run reloads the keys periodically so rotations on other instances are
+// picked up, and wipes the secrets of keys that can no longer validate
+// anything. The rows are kept so Init still recognizes an old JWT_SECRET.
+func (k *Keyring) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := k.DB.ExecContext(ctx, `UPDATE signing_keys SET secret = '' WHERE retired_at < $1 AND secret <> ''`, time.Now().Add(-k.RetainFor)); err != nil {
+				fmt.Printf("[Signing] Failed to prune expired keys: %v\n", err)
+			}
+			if err := k.Reload(ctx); err != nil {
+				fmt.Printf("[Signing] Failed to reload keys: %v\n", err)
+			}
+		}
+	}
+}
+
+// Sign signs a token with the primary key and records its kid.
+func (k *Keyring) Sign(token *jwt.Token) (string, error) {
+	k.mu.RLock()
+	primary := k.primary
+	k.mu.RUnlock()
+
+	token.Header["kid"] = primary.ID
+	return token.SignedString(primary.secret)
+}
+
+// Keyfunc resolves the verification key for jwt.Parse. Tokens issued before
+// kids were added are checked against JWT_SECRET, for as long as that key is
+// still valid.
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = ConfigKeyID(string(k.config))
+	}
+	if key := k.lookup(kid); key != nil {
+		return key.secret, nil
+	}
+
+	// Possibly rotated on another instance since we last looked.
+	k.mu.RLock()
+	stale := time.Since(k.loadedAt) > refreshInterval
+	k.mu.RUnlock()
+	if stale && k.DB != nil {
+		if err := k.Reload(context.Background()); err != nil {
+			fmt.Printf("[Signing] Failed to reload keys: %v\n", err)
+		}
+		if key := k.lookup(kid); key != nil {
+			return key.secret, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+func (k *Keyring) lookup(kid string) *Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key := k.keys[kid]
+	if key != nil && key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil
+	}
+	return key
+}
+
+// Keys lists the keys that can still validate tokens, primary first.
+func (k *Keyring) Keys() []Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	list := make([]Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		list = append(list, *key)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Primary != list[j].Primary {
+			return list[i].Primary
+		}
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
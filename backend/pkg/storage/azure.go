@@ -0,0 +1,464 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/config"
+)
+
+// azureVersion is the Blob service REST API version requests and SAS
+// tokens are made for.
+const azureVersion = "2021-08-06"
+
+// AzureDriver stores objects as block blobs in an Azure Blob Storage
+// container, through the REST API with Shared Key authorization. Large
+// objects are uploaded in blocks of S3_PART_SIZE_MB, committed on Close.
+// Downloads can be presigned as SAS URLs; uploads can't, as a plain PUT
+// lacks the x-ms-blob-type header Azure requires.
+type AzureDriver struct {
+	account   string
+	key       []byte
+	endpoint  *url.URL // blob service, https://<account>.blob.core.windows.net
+	container string
+	blockSize int
+	retries   int
+	client    *http.Client
+}
+
+func NewAzureDriver(cfg *config.Config) (*AzureDriver, error) {
+	if cfg.AzureStorageAccount == "" || cfg.AzureStorageKey == "" {
+		return nil, errors.New("STORAGE_DRIVER=azure needs AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY")
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.AzureStorageKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AZURE_STORAGE_KEY: %w", err)
+	}
+	endpoint := cfg.AzureEndpoint
+	if endpoint == "" {
+		endpoint = "https://" + cfg.AzureStorageAccount + ".blob.core.windows.net"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid AZURE_ENDPOINT %q", endpoint)
+	}
+
+	blockSize := cfg.S3PartSizeMB * 1024 * 1024
+	if blockSize < minPartSize {
+		blockSize = minPartSize
+	}
+	d := &AzureDriver{
+		account:   cfg.AzureStorageAccount,
+		key:       key,
+		endpoint:  u,
+		container: cfg.AzureContainer,
+		blockSize: blockSize,
+		retries:   cfg.S3PartRetries,
+		client:    &http.Client{},
+	}
+
+	// Ensure the container exists
+	resp, err := d.do(context.Background(), http.MethodPut, "", url.Values{"restype": {"container"}}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
+		return nil, fmt.Errorf("failed to create container %s: %s", d.container, resp.Status)
+	}
+	return d, nil
+}
+
+// do sends a signed request for the stored key (the container itself when
+// key is empty).
+func (d *AzureDriver) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *d.endpoint
+	u.Path = d.endpoint.Path + "/" + d.container
+	u.RawPath = d.endpoint.EscapedPath() + "/" + url.PathEscape(d.container)
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath += "/" + escapeKey(key)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureVersion)
+	req.Header.Set("Authorization", "SharedKey "+d.account+":"+d.sign(req, query))
+	return d.client.Do(req)
+}
+
+// sign computes the Shared Key signature of a request.
+func (d *AzureDriver) sign(req *http.Request, query url.Values) string {
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	var ms []string
+	for k := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-") {
+			ms = append(ms, lk+":"+strings.TrimSpace(req.Header.Get(k)))
+		}
+	}
+	sort.Strings(ms)
+
+	resource := "/" + d.account + req.URL.EscapedPath()
+	names := make([]string, 0, len(query))
+	for k := range query {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(values, ",")
+	}
+
+	toSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date: x-ms-date is used
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + strings.Join(ms, "\n") + "\n" + resource
+	return d.hmac(toSign)
+}
+
+func (d *AzureDriver) hmac(s string) string {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// escapeKey escapes each segment of a blob name for a URL path.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// azureError turns an unexpected response into an error, closing it. A
+// missing blob satisfies IsNotExist.
+func azureError(resp *http.Response, key string) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("azure %s: %s %s", key, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// Writer uploads the object in blocks of blockSize, committed on Close;
+// call Abort (see Aborter) to discard a failed upload instead.
+func (d *AzureDriver) Writer(ctx context.Context, path string) (io.WriteCloser, error) {
+	return &blockWriter{ctx: ctx, d: d, key: objectKey(path)}, nil
+}
+
+// statKey returns the key path is stored under, with its size, falling back
+// to the flat key of a blob as the S3 driver does.
+func (d *AzureDriver) statKey(ctx context.Context, path string) (string, int64, error) {
+	key := objectKey(path)
+	size, err := d.head(ctx, key)
+	if err != nil && key != path && IsNotExist(err) {
+		if legacy, legacyErr := d.head(ctx, path); legacyErr == nil {
+			return path, legacy, nil
+		}
+	}
+	return key, size, err
+}
+
+func (d *AzureDriver) head(ctx context.Context, key string) (int64, error) {
+	resp, err := d.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, azureError(resp, key)
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (d *AzureDriver) Reader(ctx context.Context, path string) (io.ReadCloser, error) {
+	key, _, err := d.statKey(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, azureError(resp, key)
+	}
+	return resp.Body, nil
+}
+
+func (d *AzureDriver) Stat(ctx context.Context, path string) (int64, error) {
+	_, size, err := d.statKey(ctx, path)
+	return size, err
+}
+
+// URLFor presigns downloads with a service SAS. Uploads are not supported.
+func (d *AzureDriver) URLFor(ctx context.Context, path string, method string, expiry time.Duration) (string, error) {
+	if method != http.MethodGet {
+		return "", ErrNotSupported
+	}
+	key := objectKey(path)
+	if found, _, err := d.statKey(ctx, path); err == nil {
+		key = found
+	}
+
+	protocol := ""
+	if d.endpoint.Scheme == "https" {
+		protocol = "https"
+	}
+	se := time.Now().UTC().Add(expiry).Format("2006-01-02T15:04:05Z")
+	toSign := strings.Join([]string{
+		"r", "", se,
+		"/blob/" + d.account + "/" + d.container + "/" + key,
+		"", "", protocol, azureVersion, "b",
+		"", "", "", "", "", "", "",
+	}, "\n")
+
+	q := url.Values{"sv": {azureVersion}, "sr": {"b"}, "sp": {"r"}, "se": {se}, "sig": {d.hmac(toSign)}}
+	if protocol != "" {
+		q.Set("spr", protocol)
+	}
+	u := *d.endpoint
+	u.Path = d.endpoint.Path + "/" + d.container + "/" + key
+	u.RawPath = d.endpoint.EscapedPath() + "/" + url.PathEscape(d.container) + "/" + escapeKey(key)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Delete removes path, including the flat copy of a blob stored before
+// sharding. A missing blob is not an error.
+func (d *AzureDriver) Delete(ctx context.Context, path string) error {
+	keys := []string{path}
+	if key := objectKey(path); key != path {
+		keys = append([]string{key}, keys...)
+	}
+	for _, key := range keys {
+		resp, err := d.do(ctx, http.MethodDelete, key, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+			return azureError(resp, key)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// List walks every blob under prefix, reporting blobs as blobs/<digest>
+// like the S3 driver does.
+func (d *AzureDriver) List(ctx context.Context, prefix string, fn func(path string, size int64) error) error {
+	var page struct {
+		Blobs []struct {
+			Name string `xml:"Name"`
+			Size int64  `xml:"Properties>Content-Length"`
+		} `xml:"Blobs>Blob"`
+		NextMarker string `xml:"NextMarker"`
+	}
+	marker := ""
+	for {
+		q := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+		resp, err := d.do(ctx, http.MethodGet, "", q, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return azureError(resp, d.container)
+		}
+		page.Blobs, page.NextMarker = nil, ""
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		for _, b := range page.Blobs {
+			if err := fn(logicalPath(b.Name), b.Size); err != nil {
+				return err
+			}
+		}
+		if page.NextMarker == "" {
+			return nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+// blockWriter buffers one block at a time and stages it; Close commits the
+// block list. Objects smaller than a block are sent with one Put Blob.
+type blockWriter struct {
+	ctx    context.Context
+	d      *AzureDriver
+	key    string
+	buf    []byte
+	blocks []string
+	err    error
+	done   bool
+	// committed is set once the blob is visible in the container.
+	committed bool
+}
+
+func (bw *blockWriter) Write(p []byte) (int, error) {
+	if bw.done {
+		return 0, errors.New("write to closed writer")
+	}
+	if bw.err != nil {
+		return 0, bw.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := bw.d.blockSize - len(bw.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		bw.buf = append(bw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(bw.buf) == bw.d.blockSize {
+			if err := bw.flushBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flushBlock stages the buffered bytes as the next block, retrying on
+// failure. Block IDs must all have the same length.
+func (bw *blockWriter) flushBlock() error {
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(bw.blocks))))
+	q := url.Values{"comp": {"block"}, "blockid": {id}}
+	var err error
+	for attempt := 0; attempt <= bw.d.retries; attempt++ {
+		if attempt > 0 {
+			fmt.Printf("[Storage] Retrying block %d of %s (attempt %d): %v\n", len(bw.blocks)+1, bw.key, attempt+1, err)
+			time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+		}
+		var resp *http.Response
+		resp, err = bw.d.do(bw.ctx, http.MethodPut, bw.key, q, nil, bw.buf)
+		if err == nil {
+			if resp.StatusCode == http.StatusCreated {
+				resp.Body.Close()
+				break
+			}
+			err = azureError(resp, bw.key)
+		}
+		if bw.ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		bw.err = fmt.Errorf("failed to upload block %d: %w", len(bw.blocks)+1, err)
+		return bw.err
+	}
+	bw.blocks = append(bw.blocks, id)
+	bw.buf = bw.buf[:0]
+	return nil
+}
+
+// Close commits the blob.
+func (bw *blockWriter) Close() error {
+	if bw.done {
+		return bw.err
+	}
+	bw.done = true
+	if bw.err != nil {
+		return bw.err
+	}
+
+	var q url.Values
+	header := http.Header{}
+	var body []byte
+	if len(bw.blocks) == 0 {
+		// Small object: a single Put Blob.
+		header.Set("x-ms-blob-type", "BlockBlob")
+		body = bw.buf
+	} else {
+		if len(bw.buf) > 0 {
+			if err := bw.flushBlock(); err != nil {
+				return err
+			}
+		}
+		var list bytes.Buffer
+		list.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+		for _, id := range bw.blocks {
+			list.WriteString("<Latest>" + id + "</Latest>")
+		}
+		list.WriteString("</BlockList>")
+		q = url.Values{"comp": {"blocklist"}}
+		header.Set("Content-Type", "application/xml")
+		body = list.Bytes()
+	}
+	bw.buf = nil
+
+	resp, err := bw.d.do(bw.ctx, http.MethodPut, bw.key, q, header, body)
+	if err == nil && resp.StatusCode != http.StatusCreated {
+		err = azureError(resp, bw.key)
+	} else if err == nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		bw.err = fmt.Errorf("failed to commit %s: %w", bw.key, err)
+		return bw.err
+	}
+	bw.committed = true
+	return nil
+}
+
+// Abort discards everything written so far; a later Close is a no-op.
+// Staged blocks are never committed, and Azure drops them after a week.
+func (bw *blockWriter) Abort() error {
+	if bw.committed {
+		return nil
+	}
+	bw.done = true
+	if bw.err == nil {
+		bw.err = errors.New("upload aborted")
+	}
+	bw.buf = nil
+	return nil
+}
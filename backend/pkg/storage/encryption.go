@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Application-level encryption, for stores without server-side encryption.
+// An encrypted object is a header followed by the plaintext in AES-256-GCM
+// sealed chunks:
+//
+//	"RXE1" | len(keyID) | keyID | 7-byte nonce prefix | chunk...
+//
+// Each chunk's nonce is the prefix, a 4-byte chunk counter and a byte that
+// is 1 on the last chunk only, so chunks cannot be reordered, dropped or
+// truncated without failing authentication. The object's user metadata
+// names the key too, so its plaintext size is known without reading it.
+
+const (
+	encMagic       = "RXE1"
+	encChunkSize   = 64 << 10
+	encOverhead    = 16 // GCM tag per chunk
+	encNoncePrefix = 7
+
+	// encryptionMeta is the user metadata naming the key an object is
+	// encrypted with.
+	encryptionMeta = "Registryx-Encryption-Key"
+)
+
+// ErrCorrupt is returned when reading an encrypted object that fails
+// authentication.
+var ErrCorrupt = errors.New("object is corrupt")
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Keyring holds the AES-256 keys objects are encrypted with. The first key
+// encrypts new objects; the others only decrypt objects written before a
+// rotation, until Reencrypt has rewritten them.
+type Keyring struct {
+	Current string
+	IDs     []string
+
+	aeads map[string]cipher.AEAD
+}
+
+// ParseKeyring reads STORAGE_ENCRYPTION_KEYS: "id=base64key,id=base64key",
+// current key first. Keys are 32 random bytes.
+func ParseKeyring(s string) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, "=")
+		if !ok || !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid encryption key %q: want id=base64key, id of letters, digits, '.', '_' or '-'", id)
+		}
+		if _, dup := k.aeads[id]; dup {
+			return nil, fmt.Errorf("duplicate encryption key %q", id)
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes, base64 encoded", id)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[id] = aead
+		k.IDs = append(k.IDs, id)
+	}
+	if len(k.IDs) == 0 {
+		return nil, errors.New("no encryption keys given")
+	}
+	k.Current = k.IDs[0]
+	return k, nil
+}
+
+func headerLen(keyID string) int64 {
+	return int64(len(encMagic) + 1 + len(keyID) + encNoncePrefix)
+}
+
+// plainSize returns the plaintext size of an object of size bytes
+// encrypted with keyID.
+func plainSize(size int64, keyID string) int64 {
+	body := size - headerLen(keyID)
+	chunks := (body + encChunkSize + encOverhead - 1) / (encChunkSize + encOverhead)
+	if chunks < 1 {
+		chunks = 1
+	}
+	return body - chunks*encOverhead
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encNoncePrefix:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts what is written to it with the keyring's current
+// key. It holds back a full chunk until more data arrives, so the last
+// chunk is known when it is sealed.
+type encryptWriter struct {
+	w       io.WriteCloser
+	aead    cipher.AEAD
+	prefix  []byte
+	header  []byte // written before the first chunk
+	counter uint32
+	buf     []byte
+	err     error
+}
+
+func newEncryptWriter(w io.WriteCloser, k *Keyring) (*encryptWriter, error) {
+	prefix := make([]byte, encNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	header := append([]byte(encMagic), byte(len(k.Current)))
+	header = append(append(header, k.Current...), prefix...)
+	return &encryptWriter{w: w, aead: k.aeads[k.Current], prefix: prefix, header: header, buf: make([]byte, 0, encChunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == encChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if e.counter == ^uint32(0) {
+		e.err = errors.New("object too large to encrypt")
+		return e.err
+	}
+	out := e.aead.Seal(e.header, chunkNonce(e.prefix, e.counter, last), e.buf, nil)
+	e.header = nil
+	e.counter++
+	e.buf = e.buf[:0]
+	if _, err := e.w.Write(out); err != nil {
+		e.err = err
+	}
+	return e.err
+}
+
+// Close seals the last chunk and commits the object.
+func (e *encryptWriter) Close() error {
+	if e.err == nil {
+		e.seal(true)
+	}
+	if e.err != nil {
+		e.Abort()
+		return e.err
+	}
+	return e.w.Close()
+}
+
+// Abort discards the object, like the writer it wraps.
+func (e *encryptWriter) Abort() error {
+	if e.err == nil {
+		e.err = errors.New("upload aborted")
+	}
+	if a, ok := e.w.(Aborter); ok {
+		return a.Abort()
+	}
+	return nil
+}
+
+// decryptReader decrypts an object written by encryptWriter with any key of
+// the keyring.
+type decryptReader struct {
+	r       io.ReadCloser
+	in      *bufio.Reader
+	keys    *Keyring
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	out     []byte
+	last    bool
+	err     error
+}
+
+func newDecryptReader(r io.ReadCloser, k *Keyring) *decryptReader {
+	return &decryptReader{r: r, in: bufio.NewReader(r), keys: k, chunk: make([]byte, encChunkSize+encOverhead)}
+}
+
+func (d *decryptReader) readHeader() error {
+	fixed := make([]byte, len(encMagic)+1)
+	if _, err := io.ReadFull(d.in, fixed); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	} else if err != nil || string(fixed[:len(encMagic)]) != encMagic {
+		return fmt.Errorf("%w: not an encrypted object", ErrCorrupt)
+	}
+	rest := make([]byte, int(fixed[len(encMagic)])+encNoncePrefix)
+	if _, err := io.ReadFull(d.in, rest); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("%w: truncated header", ErrCorrupt)
+	}
+	id := string(rest[:len(rest)-encNoncePrefix])
+	aead, ok := d.keys.aeads[id]
+	if !ok {
+		return fmt.Errorf("object is encrypted with key %q, which is not in STORAGE_ENCRYPTION_KEYS", id)
+	}
+	d.aead, d.prefix = aead, rest[len(rest)-encNoncePrefix:]
+	return nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.last {
+			return 0, io.EOF
+		}
+		d.err = d.next()
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk into d.out.
+func (d *decryptReader) next() error {
+	if d.aead == nil {
+		if err := d.readHeader(); err != nil {
+			return err
+		}
+	}
+	n, err := io.ReadFull(d.in, d.chunk)
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		d.last = true
+	case err != nil:
+		return err
+	default:
+		if _, peekErr := d.in.Peek(1); peekErr == io.EOF {
+			d.last = true
+		} else if peekErr != nil {
+			return peekErr
+		}
+	}
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.prefix, d.counter, d.last), d.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: decryption failed, the object was altered or truncated", ErrCorrupt)
+	}
+	d.counter++
+	d.out = plain
+	return nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.r.Close()
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tempPrefix marks files being written; they are renamed into place on
+// Close and never listed.
+const tempPrefix = ".tmp-"
+
+// FilesystemDriver stores objects as files under a root directory, for
+// small deployments without an object store. Blobs use the same sharded
+// layout as in a bucket. Writes go to a temporary file renamed into place
+// on Close, so readers never see a partial object.
+type FilesystemDriver struct {
+	root string
+}
+
+func NewFilesystemDriver(root string) (*FilesystemDriver, error) {
+	if root == "" {
+		return nil, errors.New("STORAGE_DRIVER=filesystem needs STORAGE_ROOT")
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o750); err != nil {
+		return nil, err
+	}
+	d := &FilesystemDriver{root: abs}
+	go d.removeStaleTemp(staleTempAge)
+	return d, nil
+}
+
+// staleTempAge is how old a temporary file must be to be taken for the
+// leftover of a write interrupted by a crash.
+const staleTempAge = 24 * time.Hour
+
+// removeStaleTemp deletes temporary files older than age.
+func (d *FilesystemDriver) removeStaleTemp(age time.Duration) {
+	cutoff := time.Now().Add(-age)
+	filepath.WalkDir(d.root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasPrefix(entry.Name(), tempPrefix) {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+			if err := os.Remove(name); err == nil {
+				fmt.Printf("[Storage] Removed interrupted write %s\n", name)
+			}
+		}
+		return nil
+	})
+}
+
+// file returns the file path is stored in, refusing paths that would leave
+// the root.
+func (d *FilesystemDriver) file(path string) (string, error) {
+	name := filepath.Join(d.root, filepath.FromSlash(objectKey(path)))
+	if rel, err := filepath.Rel(d.root, name); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid storage path %q", path)
+	}
+	return name, nil
+}
+
+// Writer writes to a temporary file beside the object, renamed into place
+// on Close; call Abort (see Aborter) to discard it instead.
+func (d *FilesystemDriver) Writer(ctx context.Context, path string) (io.WriteCloser, error) {
+	name, err := d.file(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), tempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{File: tmp, name: name}, nil
+}
+
+// Reader opens the object. The file is seekable, so blob downloads support
+// ranges.
+func (d *FilesystemDriver) Reader(ctx context.Context, path string) (io.ReadCloser, error) {
+	name, err := d.file(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+func (d *FilesystemDriver) Stat(ctx context.Context, path string) (int64, error) {
+	name, err := d.file(path)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// URLFor is not supported: files can't be reached without the registry, so
+// direct uploads and blob redirects are off.
+func (d *FilesystemDriver) URLFor(ctx context.Context, path string, method string, expiry time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+// Delete removes path; a missing object is not an error, as in a bucket.
+func (d *FilesystemDriver) Delete(ctx context.Context, path string) error {
+	name, err := d.file(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List walks every object under prefix, reporting blobs as blobs/<digest>
+// like the S3 driver does.
+func (d *FilesystemDriver) List(ctx context.Context, prefix string, fn func(path string, size int64) error) error {
+	// Walk from the deepest directory the prefix names; the rest filters.
+	start := filepath.Join(d.root, filepath.FromSlash(prefix[:strings.LastIndex(prefix, "/")+1]))
+	err := filepath.WalkDir(start, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // deleted since it was listed
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(d.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		return fn(logicalPath(key), info.Size())
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// fileWriter is a temporary file renamed to name on Close.
+type fileWriter struct {
+	*os.File
+	name string
+	done bool
+}
+
+func (w *fileWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if err := w.File.Sync(); err != nil {
+		w.File.Close()
+		os.Remove(w.File.Name())
+		return err
+	}
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	if err := os.Rename(w.File.Name(), w.name); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	return nil
+}
+
+// Abort discards the temporary file.
+func (w *fileWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Blobs are stored sharded by the first two hex digits of their digest,
+// blobs/sha256/ab/abcdef..., rather than flat under blobs/sha256:abcdef...,
+// which slows listings (and some backends) down once there are millions of
+// them. Callers keep using blobs/<digest>; the driver maps it to the stored
+// key and back. Blobs written before sharding are still found under their
+// flat key until MigrateLayout has moved them.
+
+const blobPrefix = "blobs/"
+
+// objectKey returns the key path is stored under.
+func objectKey(path string) string {
+	digest, ok := strings.CutPrefix(path, blobPrefix)
+	if !ok {
+		return path
+	}
+	alg, hex, ok := strings.Cut(digest, ":")
+	if !ok || alg == "" || len(hex) < 2 || strings.Contains(hex, "/") {
+		return path
+	}
+	return blobPrefix + alg + "/" + hex[:2] + "/" + hex
+}
+
+// logicalPath maps a stored key back to the path callers use.
+func logicalPath(key string) string {
+	rest, ok := strings.CutPrefix(key, blobPrefix)
+	if !ok {
+		return key
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || len(parts[1]) != 2 || !strings.HasPrefix(parts[2], parts[1]) {
+		return key
+	}
+	return blobPrefix + parts[0] + ":" + parts[2]
+}
+
+// statKey returns the key path is actually stored under, falling back to the
+// flat key of a blob that hasn't been migrated yet.
+func (d *S3Driver) statKey(ctx context.Context, path string) (string, minio.ObjectInfo, error) {
+	key := objectKey(path)
+	info, err := d.client.StatObject(ctx, d.bucketName, key, minio.StatObjectOptions{})
+	if err != nil && key != path {
+		if legacy, legacyErr := d.client.StatObject(ctx, d.bucketName, path, minio.StatObjectOptions{}); legacyErr == nil {
+			return path, legacy, nil
+		}
+	}
+	return key, info, err
+}
+
+// LayoutMigrator is implemented by drivers that can move blobs written
+// before sharding to the sharded layout.
+type LayoutMigrator interface {
+	MigrateLayout(ctx context.Context) (*LayoutMigration, error)
+}
+
+// LayoutMigration summarizes a MigrateLayout run.
+type LayoutMigration struct {
+	Moved  int      `json:"moved"`
+	Bytes  int64    `json:"bytes"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// MigrateLayout moves blobs stored under their flat key to the sharded
+// layout: each is copied server-side, then the flat copy is removed, so a
+// blob is readable throughout. It is safe to run on several instances at
+// once and is a cheap no-op once nothing is left to move.
+func (d *S3Driver) MigrateLayout(ctx context.Context) (*LayoutMigration, error) {
+	res := &LayoutMigration{}
+	// Not recursive: flat blobs sit directly under blobs/, sharded ones
+	// come back as a single blobs/<alg>/ prefix.
+	for obj := range d.client.ListObjects(ctx, d.bucketName, minio.ListObjectsOptions{Prefix: blobPrefix}) {
+		if obj.Err != nil {
+			return res, obj.Err
+		}
+		key := objectKey(obj.Key)
+		if key == obj.Key {
+			continue
+		}
+		_, err := d.client.ComposeObject(ctx,
+			minio.CopyDestOptions{Bucket: d.bucketName, Object: key, Encryption: d.sse},
+			minio.CopySrcOptions{Bucket: d.bucketName, Object: obj.Key})
+		if err == nil {
+			err = d.client.RemoveObject(ctx, d.bucketName, obj.Key, minio.RemoveObjectOptions{})
+		}
+		if err != nil {
+			if _, statErr := d.client.StatObject(ctx, d.bucketName, obj.Key, minio.StatObjectOptions{}); statErr != nil {
+				continue // another instance moved it first
+			}
+			if len(res.Errors) < 100 {
+				res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", obj.Key, err))
+			}
+			continue
+		}
+		res.Moved++
+		res.Bytes += obj.Size
+	}
+	return res, nil
+}
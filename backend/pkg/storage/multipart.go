@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// S3 requires every part except the last to be at least 5 MiB.
+const minPartSize = 5 * 1024 * 1024
+
+// multipartWriter buffers one part at a time and uploads it with S3 multipart
+// upload. Objects smaller than a single part are sent with one PutObject.
+type multipartWriter struct {
+	ctx    context.Context
+	d      *S3Driver
+	path   string
+	buf    []byte                 // grows up to d.partSize, so small objects stay small
+	opts   minio.PutObjectOptions // encryption and metadata of the object
+	upload string
+	parts  []minio.CompletePart
+	err    error
+	done   bool
+	// committed is set once the object is visible in the bucket.
+	committed bool
+}
+
+func newMultipartWriter(ctx context.Context, d *S3Driver, path string) *multipartWriter {
+	return &multipartWriter{
+		ctx:  ctx,
+		d:    d,
+		path: path,
+		opts: minio.PutObjectOptions{ServerSideEncryption: d.sse},
+	}
+}
+
+func (mw *multipartWriter) Write(p []byte) (int, error) {
+	if mw.done {
+		return 0, errors.New("write to closed writer")
+	}
+	if mw.err != nil {
+		return 0, mw.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := mw.d.partSize - len(mw.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		mw.buf = append(mw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(mw.buf) == mw.d.partSize {
+			if err := mw.flushPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flushPart uploads the buffered bytes as the next part, retrying on failure.
+func (mw *multipartWriter) flushPart() error {
+	if mw.upload == "" {
+		id, err := mw.d.core.NewMultipartUpload(mw.ctx, mw.d.bucketName, mw.path, mw.opts)
+		if err != nil {
+			mw.err = fmt.Errorf("failed to start multipart upload: %w", err)
+			return mw.err
+		}
+		mw.upload = id
+	}
+
+	partNumber := len(mw.parts) + 1
+	var part minio.ObjectPart
+	var err error
+	for attempt := 0; attempt <= mw.d.partRetries; attempt++ {
+		if attempt > 0 {
+			fmt.Printf("[Storage] Retrying part %d of %s (attempt %d): %v\n", partNumber, mw.path, attempt+1, err)
+			time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+		}
+		part, err = mw.d.core.PutObjectPart(mw.ctx, mw.d.bucketName, mw.path, mw.upload, partNumber,
+			bytes.NewReader(mw.buf), int64(len(mw.buf)), minio.PutObjectPartOptions{})
+		if err == nil || mw.ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		mw.err = fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+		mw.abort()
+		return mw.err
+	}
+
+	mw.parts = append(mw.parts, minio.CompletePart{PartNumber: partNumber, ETag: part.ETag})
+	mw.buf = mw.buf[:0]
+	return nil
+}
+
+// Close commits the object.
+func (mw *multipartWriter) Close() error {
+	if mw.done {
+		return mw.err
+	}
+	mw.done = true
+	if mw.err != nil {
+		return mw.err
+	}
+
+	// Small object: a single PUT is cheaper than a multipart round trip.
+	if mw.upload == "" {
+		_, err := mw.d.client.PutObject(mw.ctx, mw.d.bucketName, mw.path, bytes.NewReader(mw.buf), int64(len(mw.buf)), mw.opts)
+		mw.buf = nil
+		if err != nil {
+			mw.err = err
+			return err
+		}
+		mw.committed = true
+		return nil
+	}
+
+	if len(mw.buf) > 0 {
+		if err := mw.flushPart(); err != nil {
+			return err
+		}
+	}
+	mw.buf = nil
+
+	_, err := mw.d.core.CompleteMultipartUpload(mw.ctx, mw.d.bucketName, mw.path, mw.upload, mw.parts, mw.opts)
+	if err != nil {
+		mw.err = fmt.Errorf("failed to complete multipart upload: %w", err)
+		mw.abort()
+		return mw.err
+	}
+	mw.committed = true
+	return nil
+}
+
+// Abort discards everything written so far; a later Close is a no-op.
+// It cannot undo an object that was already committed.
+func (mw *multipartWriter) Abort() error {
+	if mw.committed {
+		return nil
+	}
+	mw.done = true
+	if mw.err == nil {
+		mw.err = errors.New("upload aborted")
+	}
+	mw.buf = nil
+	return mw.abort()
+}
+
+func (mw *multipartWriter) abort() error {
+	if mw.upload == "" {
+		return nil
+	}
+	// Use a fresh context: the request context is often what just failed.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := mw.d.core.AbortMultipartUpload(ctx, mw.d.bucketName, mw.path, mw.upload)
+	if err != nil {
+		fmt.Printf("[Storage] Failed to abort multipart upload %s for %s: %v\n", mw.upload, mw.path, err)
+	}
+	mw.upload = ""
+	return err
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Encryption modes (STORAGE_ENCRYPTION).
+const (
+	EncryptionNone   = "none"
+	EncryptionSSES3  = "sse-s3"  // the store encrypts with its own keys
+	EncryptionSSEKMS = "sse-kms" // the store encrypts with a KMS key
+	EncryptionAESGCM = "aes-gcm" // the registry encrypts before upload
+)
+
+// Encrypter is implemented by drivers that encrypt objects at rest.
+type Encrypter interface {
+	Encryption() EncryptionStatus
+	Reencrypt(ctx context.Context) (*Reencryption, error)
+}
+
+// EncryptionStatus describes how a bucket is encrypted.
+type EncryptionStatus struct {
+	Mode       string        `json:"mode"`
+	KMSKeyID   string        `json:"kmsKeyId,omitempty"`
+	CurrentKey string        `json:"currentKey,omitempty"` // application-level key new objects get
+	Keys       []string      `json:"keys,omitempty"`       // application-level keys objects can be read with
+	Last       *Reencryption `json:"lastReencryption,omitempty"`
+}
+
+// Reencryption summarizes a Reencrypt run.
+type Reencryption struct {
+	Running    bool       `json:"running"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Checked    int        `json:"checked"`
+	Rewritten  int        `json:"rewritten"`
+	Bytes      int64      `json:"bytes"`
+	Errors     []string   `json:"errors,omitempty"`
+}
+
+type reencryptState struct {
+	running sync.Mutex // one run at a time
+	mu      sync.Mutex
+	last    *Reencryption
+}
+
+// Encryption reports the bucket's encryption and the last Reencrypt run.
+func (d *S3Driver) Encryption() EncryptionStatus {
+	st := EncryptionStatus{Mode: d.mode, KMSKeyID: d.kmsKeyID}
+	if d.keys != nil {
+		st.Keys = d.keys.IDs
+		if d.encryptWrites {
+			st.CurrentKey = d.keys.Current
+		}
+	}
+	d.reencryption.mu.Lock()
+	if d.reencryption.last != nil {
+		last := *d.reencryption.last
+		st.Last = &last
+	}
+	d.reencryption.mu.Unlock()
+	return st
+}
+
+// Reencrypt rewrites every object not encrypted the way new objects are:
+// after a key rotation, objects under an older key get the current one;
+// after switching to aes-gcm, unencrypted objects are encrypted; after
+// switching away from it, objects are decrypted. Objects are rewritten in
+// place, so they stay readable throughout. Only then can an old key be
+// removed from STORAGE_ENCRYPTION_KEYS. SSE-S3 and SSE-KMS objects are
+// left alone; the store rotates their keys. An object garbage collection
+// deletes while it is being rewritten comes back, for fsck to report.
+func (d *S3Driver) Reencrypt(ctx context.Context) (*Reencryption, error) {
+	if d.keys == nil {
+		return nil, fmt.Errorf("%w: no STORAGE_ENCRYPTION_KEYS to re-encrypt with", ErrNotSupported)
+	}
+	d.reencryption.running.Lock()
+	defer d.reencryption.running.Unlock()
+
+	res := &Reencryption{Running: true, StartedAt: time.Now()}
+	d.setReencryption(res)
+	want := ""
+	if d.encryptWrites {
+		want = d.keys.Current
+	}
+
+	err := d.listInfo(ctx, "", func(obj minio.ObjectInfo) error {
+		res.Checked++
+		if encryptionKey(obj.UserMetadata) == want {
+			return nil
+		}
+		n, err := d.rewrite(ctx, obj)
+		if err != nil {
+			if len(res.Errors) < 100 {
+				res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", obj.Key, err))
+			}
+		} else {
+			res.Rewritten++
+			res.Bytes += n
+		}
+		if res.Checked%1000 == 0 {
+			d.setReencryption(res)
+		}
+		return nil
+	})
+
+	now := time.Now()
+	res.Running, res.FinishedAt = false, &now
+	d.setReencryption(res)
+	if res.Rewritten > 0 || len(res.Errors) > 0 {
+		fmt.Printf("[Storage] Re-encrypted %d of %d objects in %s, %d failed\n", res.Rewritten, res.Checked, d.bucketName, len(res.Errors))
+	}
+	return res, err
+}
+
+func (d *S3Driver) setReencryption(res *Reencryption) {
+	snapshot := *res
+	snapshot.Errors = append([]string(nil), res.Errors...)
+	d.reencryption.mu.Lock()
+	d.reencryption.last = &snapshot
+	d.reencryption.mu.Unlock()
+}
+
+// rewrite reads obj and writes it back under the same key, encrypted the
+// way new objects are. It returns the content size.
+func (d *S3Driver) rewrite(ctx context.Context, obj minio.ObjectInfo) (int64, error) {
+	reader, err := d.open(ctx, obj.Key, obj)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	writer, err := d.writerKey(ctx, obj.Key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(writer, reader)
+	if err != nil {
+		if a, ok := writer.(Aborter); ok {
+			a.Abort()
+		}
+		return 0, err
+	}
+	return n, writer.Close()
+}
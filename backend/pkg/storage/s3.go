@@ -2,16 +2,20 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 	"github.com/registryx/registryx/backend/pkg/config"
 )
 
 // Driver interface abstracts the underlying storage backend.
-// In the future, we could add 'Filesystem' or 'GCS' drivers.
+// S3Driver and FilesystemDriver implement it; New picks one.
 type Driver interface {
 	// Writer returns a writer to upload a blob.
 	Writer(ctx context.Context, path string) (io.WriteCloser, error)
@@ -25,9 +29,93 @@ type Driver interface {
 	Delete(ctx context.Context, path string) error
 }
 
+// Aborter is implemented by writers that can discard a partially written
+// object instead of committing it on Close.
+type Aborter interface {
+	Abort() error
+}
+
+// Composer is implemented by drivers that can concatenate stored objects
+// server-side.
+type Composer interface {
+	Compose(ctx context.Context, dst string, srcs []string) error
+}
+
+// Lister is implemented by drivers that can enumerate stored objects. fn is
+// called for every object under prefix; returning an error stops the walk.
+type Lister interface {
+	List(ctx context.Context, prefix string, fn func(path string, size int64) error) error
+}
+
+// ClassLister is implemented by drivers whose objects have a storage class
+// (S3 STANDARD, STANDARD_IA, GLACIER, ...), which lifecycle rules may change
+// behind the registry's back. fn is called for every object under prefix.
+type ClassLister interface {
+	ListClasses(ctx context.Context, prefix string, fn func(path, class string) error) error
+}
+
+// ErrNotSupported is returned for operations the storage configuration
+// rules out, such as presigned URLs for application-encrypted objects.
+var ErrNotSupported = errors.New("not supported by this storage configuration")
+
+// IsNotExist reports whether err says the object does not exist.
+func IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+// New returns the driver STORAGE_DRIVER selects: "s3" (S3 or MinIO, the
+// default), "filesystem", "azure" (Azure Blob Storage) or "gcs" (Google
+// Cloud Storage).
+func New(cfg *config.Config) (Driver, error) {
+	switch cfg.StorageDriver {
+	case "", "s3":
+		return NewS3Driver(cfg)
+	case "filesystem":
+		if cfg.StorageEncryption != "" && cfg.StorageEncryption != EncryptionNone {
+			return nil, errors.New("STORAGE_ENCRYPTION needs STORAGE_DRIVER=s3 or gcs; encrypt the filesystem's volume instead")
+		}
+		return NewFilesystemDriver(cfg.StorageRoot)
+	case "azure":
+		if cfg.StorageEncryption != "" && cfg.StorageEncryption != EncryptionNone {
+			return nil, errors.New("STORAGE_ENCRYPTION needs STORAGE_DRIVER=s3 or gcs; Azure Storage encrypts every blob at rest itself")
+		}
+		return NewAzureDriver(cfg)
+	case "gcs":
+		return NewGCSDriver(cfg)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_DRIVER %q: want s3, filesystem, azure or gcs", cfg.StorageDriver)
+	}
+}
+
+// DeleteAll deletes every path, carrying on past failures, and returns an
+// error for each path that couldn't be deleted.
+func DeleteAll(ctx context.Context, d Driver, paths []string) []error {
+	var errs []error
+	for _, p := range paths {
+		if err := d.Delete(ctx, p); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+	}
+	return errs
+}
+
 type S3Driver struct {
-	client     *minio.Client
-	bucketName string
+	client      *minio.Client
+	core        *minio.Core
+	bucketName  string
+	partSize    int
+	partRetries int
+
+	// Encryption at rest (STORAGE_ENCRYPTION): sse is requested on every
+	// write; with encryptWrites, objects are encrypted with keys.Current
+	// before upload. keys is kept whatever the mode, so objects encrypted
+	// earlier stay readable.
+	mode          string
+	kmsKeyID      string
+	sse           encrypt.ServerSide
+	keys          *Keyring
+	encryptWrites bool
+	reencryption  reencryptState
 }
 
 func NewS3Driver(cfg *config.Config) (*S3Driver, error) {
@@ -54,78 +142,122 @@ func NewS3Driver(cfg *config.Config) (*S3Driver, error) {
 		}
 	}
 
-	return &S3Driver{
-		client:     minioClient,
-		bucketName: bucketName,
-	}, nil
-}
+	partSize := cfg.S3PartSizeMB * 1024 * 1024
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	partRetries := cfg.S3PartRetries
+	if partRetries < 0 {
+		partRetries = 0
+	}
 
-func (d *S3Driver) Writer(ctx context.Context, path string) (io.WriteCloser, error) {
-	// Create a pipe for streaming to MinIO
-	r, w := io.Pipe()
-	
-	// Create a channel to signal when upload is complete
-	done := make(chan error, 1)
-	
-	// Launch goroutine to upload to MinIO
-	go func() {
-		_, err := d.client.PutObject(ctx, d.bucketName, path, r, -1, minio.PutObjectOptions{})
-		if err != nil {
-			r.CloseWithError(err)
-			done <- err
-		} else {
-			r.Close()
-			done <- nil
+	d := &S3Driver{
+		client:      minioClient,
+		core:        &minio.Core{Client: minioClient},
+		bucketName:  bucketName,
+		partSize:    partSize,
+		partRetries: partRetries,
+		mode:        cfg.StorageEncryption,
+	}
+	if cfg.StorageEncryptionKeys != "" {
+		if d.keys, err = ParseKeyring(cfg.StorageEncryptionKeys); err != nil {
+			return nil, err
 		}
-	}()
-	
-	// Return a wrapper that waits for upload to complete on Close()
-	return &syncWriter{
-		writer: w,
-		done:   done,
-	}, nil
-}
-
-// syncWriter wraps a pipe writer and waits for upload completion on Close()
-type syncWriter struct {
-	writer *io.PipeWriter
-	done   chan error
+	}
+	switch cfg.StorageEncryption {
+	case "", EncryptionNone:
+		d.mode = EncryptionNone
+	case EncryptionSSES3:
+		d.sse = encrypt.NewSSE()
+	case EncryptionSSEKMS:
+		if cfg.StorageKMSKeyID == "" {
+			return nil, errors.New("STORAGE_ENCRYPTION=sse-kms needs STORAGE_KMS_KEY_ID")
+		}
+		if d.sse, err = encrypt.NewSSEKMS(cfg.StorageKMSKeyID, nil); err != nil {
+			return nil, err
+		}
+		d.kmsKeyID = cfg.StorageKMSKeyID
+	case EncryptionAESGCM:
+		if d.keys == nil {
+			return nil, errors.New("STORAGE_ENCRYPTION=aes-gcm needs STORAGE_ENCRYPTION_KEYS")
+		}
+		d.encryptWrites = true
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_ENCRYPTION %q: want none, sse-s3, sse-kms or aes-gcm", cfg.StorageEncryption)
+	}
+	return d, nil
 }
 
-func (sw *syncWriter) Write(p []byte) (n int, err error) {
-	return sw.writer.Write(p)
+// Writer uploads the object in parts of partSize bytes using S3 multipart
+// upload. The object is committed on Close; call Abort (see Aborter) to
+// discard a failed upload instead.
+func (d *S3Driver) Writer(ctx context.Context, path string) (io.WriteCloser, error) {
+	return d.writerKey(ctx, objectKey(path))
 }
 
-func (sw *syncWriter) Close() error {
-	// Close the writer side of the pipe
-	if err := sw.writer.Close(); err != nil {
-		return err
+func (d *S3Driver) writerKey(ctx context.Context, key string) (io.WriteCloser, error) {
+	mw := newMultipartWriter(ctx, d, key)
+	if !d.encryptWrites {
+		return mw, nil
 	}
-	
-	// Wait for the upload goroutine to complete
-	return <-sw.done
+	mw.opts.UserMetadata = map[string]string{encryptionMeta: d.keys.Current}
+	return newEncryptWriter(mw, d.keys)
 }
 
 func (d *S3Driver) Reader(ctx context.Context, path string) (io.ReadCloser, error) {
 	// Check existence first so we can return an error if missing
-	_, err := d.client.StatObject(ctx, d.bucketName, path, minio.StatObjectOptions{})
+	key, info, err := d.statKey(ctx, path)
 	if err != nil {
 		return nil, err
 	}
+	return d.open(ctx, key, info)
+}
 
-	obj, err := d.client.GetObject(ctx, d.bucketName, path, minio.GetObjectOptions{})
+// open reads the object key was stat'ed as, decrypting it if needed. The
+// read is pinned to the stat'ed version, since Reencrypt may replace an
+// unencrypted object with an encrypted one in between.
+func (d *S3Driver) open(ctx context.Context, key string, info minio.ObjectInfo) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if info.ETag != "" {
+		opts.SetMatchETag(info.ETag)
+	}
+	obj, err := d.client.GetObject(ctx, d.bucketName, key, opts)
 	if err != nil {
 		return nil, err
 	}
-	return obj, nil
+	if encryptionKey(info.UserMetadata) == "" {
+		return obj, nil
+	}
+	if d.keys == nil {
+		obj.Close()
+		return nil, fmt.Errorf("%s is encrypted but STORAGE_ENCRYPTION_KEYS is not set", key)
+	}
+	return newDecryptReader(obj, d.keys), nil
 }
 
 func (d *S3Driver) Stat(ctx context.Context, path string) (int64, error) {
-	info, err := d.client.StatObject(ctx, d.bucketName, path, minio.StatObjectOptions{})
+	_, info, err := d.statKey(ctx, path)
 	if err != nil {
 		return 0, err
 	}
-	return info.Size, nil
+	return objectSize(info), nil
+}
+
+// encryptionKey returns the key an object is encrypted with, "" if it is
+// not. Listings return user metadata with its header prefix.
+func encryptionKey(meta map[string]string) string {
+	if id := meta[encryptionMeta]; id != "" {
+		return id
+	}
+	return meta["X-Amz-Meta-"+encryptionMeta]
+}
+
+// objectSize returns the size of an object's content, before encryption.
+func objectSize(info minio.ObjectInfo) int64 {
+	if id := encryptionKey(info.UserMetadata); id != "" {
+		return plainSize(info.Size, id)
+	}
+	return info.Size
 }
 
 func (d *S3Driver) URLFor(ctx context.Context, path string, method string, expiry time.Duration) (string, error) {
@@ -137,7 +269,10 @@ func (d *S3Driver) URLFor(ctx context.Context, path string, method string, expir
 	// PresignedGetObject (for download)
 	
 	if method == "PUT" {
-		u, err := d.client.PresignedPutObject(ctx, d.bucketName, path, expiry)
+		if d.encryptWrites {
+			return "", ErrNotSupported
+		}
+		u, err := d.client.PresignedPutObject(ctx, d.bucketName, objectKey(path), expiry)
 		if err != nil {
 			return "", err
 		}
@@ -145,13 +280,98 @@ func (d *S3Driver) URLFor(ctx context.Context, path string, method string, expir
 	}
 	
 	// Default to GET
-	u, err := d.client.PresignedGetObject(ctx, d.bucketName, path, expiry, nil)
+	key := objectKey(path)
+	if found, info, err := d.statKey(ctx, path); err == nil {
+		if encryptionKey(info.UserMetadata) != "" {
+			return "", ErrNotSupported // the URL would serve the ciphertext
+		}
+		key = found
+	}
+	u, err := d.client.PresignedGetObject(ctx, d.bucketName, key, expiry, nil)
 	if err != nil {
 		return "", err
 	}
 	return u.String(), nil
 }
 
+// Compose concatenates srcs into dst server-side, without streaming the bytes
+// through this process. S3 requires every source but the last to be >= 5 MiB.
+func (d *S3Driver) Compose(ctx context.Context, dst string, srcs []string) error {
+	if d.keys != nil {
+		return ErrNotSupported // encrypted objects don't concatenate
+	}
+	sources := make([]minio.CopySrcOptions, len(srcs))
+	for i, src := range srcs {
+		sources[i] = minio.CopySrcOptions{Bucket: d.bucketName, Object: objectKey(src)}
+	}
+	_, err := d.client.ComposeObject(ctx, minio.CopyDestOptions{Bucket: d.bucketName, Object: objectKey(dst), Encryption: d.sse}, sources...)
+	return err
+}
+
+// List walks every object under prefix. Blobs are reported as blobs/<digest>
+// whichever layout they are stored in, so a listing of blobs/ is not in
+// digest order. Sizes are content sizes, before encryption.
+func (d *S3Driver) List(ctx context.Context, prefix string, fn func(path string, size int64) error) error {
+	return d.listInfo(ctx, prefix, func(obj minio.ObjectInfo) error {
+		return fn(logicalPath(obj.Key), objectSize(obj))
+	})
+}
+
+// listInfo walks every object under prefix. With encryption keys set, each
+// object's user metadata is needed: it comes with the listing on MinIO, and
+// is fetched per object from stores that don't list it.
+func (d *S3Driver) listInfo(ctx context.Context, prefix string, fn func(obj minio.ObjectInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops the listing goroutine if fn bails out early
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithMetadata: d.keys != nil}
+	for obj := range d.client.ListObjects(ctx, d.bucketName, opts) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if d.keys != nil && obj.UserMetadata == nil {
+			info, err := d.client.StatObject(ctx, d.bucketName, obj.Key, minio.StatObjectOptions{})
+			if err != nil {
+				continue // deleted since it was listed
+			}
+			obj.UserMetadata = info.UserMetadata
+			if obj.UserMetadata == nil {
+				obj.UserMetadata = map[string]string{}
+			}
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListClasses walks every object under prefix with its storage class. Objects
+// without one (MinIO, some S3-compatible stores) are STANDARD.
+func (d *S3Driver) ListClasses(ctx context.Context, prefix string, fn func(path, class string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for obj := range d.client.ListObjects(ctx, d.bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		class := obj.StorageClass
+		if class == "" {
+			class = "STANDARD"
+		}
+		if err := fn(logicalPath(obj.Key), class); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes path, including the flat copy of a blob stored before
+// sharding.
 func (d *S3Driver) Delete(ctx context.Context, path string) error {
+	if key := objectKey(path); key != path {
+		if err := d.client.RemoveObject(ctx, d.bucketName, key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
 	return d.client.RemoveObject(ctx, d.bucketName, path, minio.RemoveObjectOptions{})
 }
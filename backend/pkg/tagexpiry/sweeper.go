@@ -0,0 +1,84 @@
+// Package tagexpiry deletes tags whose expiry has passed and collects the
+// garbage they leave behind.
+package tagexpiry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/registryx/registryx/backend/pkg/authz"
+	"github.com/registryx/registryx/backend/pkg/events"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// batchSize bounds the tags deleted per query.
+const batchSize = 500
+
+// Sweeper deletes expired tags.
+type Sweeper struct {
+	Metadata *metadata.Service
+	Storage  storage.Driver
+	Events   *events.Broker
+
+	// CollectGarbage runs after a sweep deleted tags, so their manifests and
+	// blobs go too. Nil leaves them for the next manual GC.
+	CollectGarbage func(ctx context.Context) error
+}
+
+func NewSweeper(meta *metadata.Service, store storage.Driver, bus *events.Broker) *Sweeper {
+	return &Sweeper{Metadata: meta, Storage: store, Events: bus}
+}
+
+// StartScheduler sweeps every interval until ctx is done.
+func (s *Sweeper) StartScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Sweep(ctx); err != nil {
+				fmt.Printf("[TagExpiry] Sweep failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// Sweep deletes every expired tag and returns how many it deleted. Several
+// instances may sweep at once; each tag is deleted by one of them.
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		expired, err := s.Metadata.DeleteExpiredTags(ctx, batchSize)
+		if err != nil {
+			return total, err
+		}
+		for _, t := range expired {
+			ns, repo := authz.SplitRepository(t.Repository)
+			for _, err := range storage.DeleteAll(ctx, s.Storage, metadata.ObjectPaths(ns, repo, t.Tag)) {
+				fmt.Printf("[TagExpiry] Failed to delete manifest object %v\n", err)
+			}
+			if s.Events != nil {
+				s.Events.Publish(events.Event{Type: events.TypeTagExpired, Repository: t.Repository, Reference: t.Tag, Digest: t.Digest})
+			}
+		}
+		total += len(expired)
+		if len(expired) < batchSize {
+			break
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+
+	fmt.Printf("[TagExpiry] Deleted %d expired tags\n", total)
+	if s.CollectGarbage != nil {
+		if err := s.CollectGarbage(ctx); err != nil {
+			return total, fmt.Errorf("garbage collection failed: %w", err)
+		}
+	}
+	return total, nil
+}
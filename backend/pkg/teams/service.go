@@ -0,0 +1,307 @@
+// Package teams groups the users of a namespace into teams that hold
+// repository roles together. Team roles are repository grants to the team,
+// or a role on the whole namespace; authz honours both wherever it checks
+// access.
+package teams
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/registryx/registryx/backend/pkg/authz"
+)
+
+var (
+	ErrNotFound           = errors.New("team not found")
+	ErrNamespaceNotFound  = errors.New("namespace not found")
+	ErrRepositoryNotFound = errors.New("repository not found")
+	ErrMemberNotFound     = errors.New("user is not a member of the team")
+	ErrBindingNotFound    = errors.New("team has no role on the repository")
+	ErrNoNamespaceRole    = errors.New("team has no role on the namespace")
+	ErrExists             = errors.New("team already exists")
+	ErrInvalidName        = errors.New("invalid team name")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// maxName bounds team names, as the column does.
+const maxName = 100
+
+// Team is a group of users in a namespace.
+type Team struct {
+	ID            uuid.UUID  `json:"id"`
+	Namespace     string     `json:"namespace"`
+	Name          string     `json:"name"`
+	Description   string     `json:"description"`
+	NamespaceRole authz.Role `json:"namespaceRole,omitempty"` // on every repository of the namespace
+	MemberCount   int        `json:"memberCount"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	Members       []Member   `json:"members,omitempty"`
+	Repositories  []Binding  `json:"repositories,omitempty"`
+}
+
+// Member is a user in a team.
+type Member struct {
+	UserID   uuid.UUID `json:"userId"`
+	Username string    `json:"username"`
+	AddedAt  time.Time `json:"addedAt"`
+}
+
+// Binding is a team's role on a repository of its namespace.
+type Binding struct {
+	Repository string     `json:"repository"` // name within the namespace
+	Role       authz.Role `json:"role"`
+}
+
+type Service struct {
+	DB    *sql.DB
+	Authz *authz.Authorizer
+}
+
+func NewService(db *sql.DB, az *authz.Authorizer) *Service {
+	return &Service{DB: db, Authz: az}
+}
+
+// IsOwner reports whether userID owns the namespace.
+func (s *Service) IsOwner(ctx context.Context, namespace string, userID uuid.UUID) (bool, error) {
+	var owner uuid.NullUUID
+	err := s.DB.QueryRowContext(ctx, `SELECT owner_id FROM namespaces WHERE name = $1`, namespace).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return false, ErrNamespaceNotFound
+	}
+	return owner.Valid && owner.UUID == userID, err
+}
+
+// IsMember reports whether userID is in any team of the namespace.
+func (s *Service) IsMember(ctx context.Context, namespace string, userID uuid.UUID) (bool, error) {
+	var member bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM team_members m
+			JOIN teams t ON t.id = m.team_id
+			JOIN namespaces n ON n.id = t.namespace_id
+			WHERE n.name = $1 AND m.user_id = $2)`, namespace, userID).Scan(&member)
+	return member, err
+}
+
+// List returns the teams of a namespace, by name.
+func (s *Service) List(ctx context.Context, namespace string) ([]Team, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT t.id, t.name, t.description, COALESCE(t.namespace_role, ''), t.created_at,
+		       (SELECT COUNT(*) FROM team_members m WHERE m.team_id = t.id)
+		FROM teams t JOIN namespaces n ON n.id = t.namespace_id
+		WHERE n.name = $1
+		ORDER BY t.name`, namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []Team{}
+	for rows.Next() {
+		t := Team{Namespace: namespace}
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description, &t.NamespaceRole, &t.CreatedAt, &t.MemberCount); err != nil {
+			return nil, err
+		}
+		teams = append(teams, t)
+	}
+	return teams, rows.Err()
+}
+
+// Get returns a team with its members and roles.
+func (s *Service) Get(ctx context.Context, namespace, name string) (*Team, error) {
+	t := &Team{Namespace: namespace, Name: name, Members: []Member{}, Repositories: []Binding{}}
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT t.id, t.description, COALESCE(t.namespace_role, ''), t.created_at
+		FROM teams t JOIN namespaces n ON n.id = t.namespace_id
+		WHERE n.name = $1 AND t.name = $2`, namespace, name).Scan(&t.ID, &t.Description, &t.NamespaceRole, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT u.id, u.username, m.added_at
+		FROM team_members m JOIN users u ON u.id = m.user_id
+		WHERE m.team_id = $1 ORDER BY u.username`, t.ID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.Username, &m.AddedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.Members = append(t.Members, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	t.MemberCount = len(t.Members)
+
+	rows, err = s.DB.QueryContext(ctx, `
+		SELECT r.name, p.role
+		FROM repository_permissions p JOIN repositories r ON r.id = p.repository_id
+		WHERE p.principal_type = $2 AND p.principal_id = $1 ORDER BY r.name`, t.ID, authz.PrincipalTeam)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b Binding
+		if err := rows.Scan(&b.Repository, &b.Role); err != nil {
+			return nil, err
+		}
+		t.Repositories = append(t.Repositories, b)
+	}
+	return t, rows.Err()
+}
+
+// Create adds a team to a namespace.
+func (s *Service) Create(ctx context.Context, namespace, name, description string, createdBy uuid.UUID) (*Team, error) {
+	if len(name) > maxName || !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w %q: use lowercase letters, digits and '.', '_' or '-' separators", ErrInvalidName, name)
+	}
+	t := &Team{Namespace: namespace, Name: name, Description: description}
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO teams (namespace_id, name, description, created_by)
+		SELECT id, $2, $3, $4 FROM namespaces WHERE name = $1
+		RETURNING id, created_at`,
+		namespace, name, description, uuid.NullUUID{UUID: createdBy, Valid: createdBy != uuid.Nil}).Scan(&t.ID, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNamespaceNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// id resolves a team of a namespace.
+func (s *Service) id(ctx context.Context, namespace, name string) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT t.id FROM teams t JOIN namespaces n ON n.id = t.namespace_id
+		WHERE n.name = $1 AND t.name = $2`, namespace, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, ErrNotFound
+	}
+	return id, err
+}
+
+// Delete removes a team and its repository roles.
+func (s *Service) Delete(ctx context.Context, namespace, name string) error {
+	id, err := s.id(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM repository_permissions WHERE principal_type = $2 AND principal_id = $1`, id, authz.PrincipalTeam); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM teams WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddMember adds a user to a team; adding a member again does nothing.
+func (s *Service) AddMember(ctx context.Context, namespace, name string, userID uuid.UUID) error {
+	id, err := s.id(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, `
+		INSERT INTO team_members (team_id, user_id) VALUES ($1, $2)
+		ON CONFLICT (team_id, user_id) DO NOTHING`, id, userID)
+	return err
+}
+
+// RemoveMember removes a user from a team.
+func (s *Service) RemoveMember(ctx context.Context, namespace, name string, userID uuid.UUID) error {
+	id, err := s.id(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM team_members WHERE team_id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+// Bind gives a team a role on a repository of its namespace, replacing the
+// role it had.
+func (s *Service) Bind(ctx context.Context, namespace, name, repository string, role authz.Role, grantedBy uuid.UUID) error {
+	id, err := s.id(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	err = s.Authz.SetGrant(ctx, namespace+"/"+repository, authz.PrincipalTeam, id, role, grantedBy)
+	if err == sql.ErrNoRows {
+		return ErrRepositoryNotFound
+	}
+	return err
+}
+
+// Unbind removes a team's role on a repository.
+func (s *Service) Unbind(ctx context.Context, namespace, name, repository string) error {
+	id, err := s.id(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	err = s.Authz.RemoveGrant(ctx, namespace+"/"+repository, authz.PrincipalTeam, id)
+	if err == sql.ErrNoRows {
+		return ErrBindingNotFound
+	}
+	return err
+}
+
+// SetNamespaceRole gives a team a role on every repository of its
+// namespace, replacing the one it had.
+func (s *Service) SetNamespaceRole(ctx context.Context, namespace, name string, role authz.Role) error {
+	id, err := s.id(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, `UPDATE teams SET namespace_role = $2 WHERE id = $1`, id, string(role))
+	return err
+}
+
+// ClearNamespaceRole removes a team's role on its namespace. Its roles on
+// single repositories stay.
+func (s *Service) ClearNamespaceRole(ctx context.Context, namespace, name string) error {
+	id, err := s.id(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `UPDATE teams SET namespace_role = NULL WHERE id = $1 AND namespace_role IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNoNamespaceRole
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+// Package transfer moves repositories between namespaces. The current owner
+// requests a transfer; it takes effect once the owner of the receiving
+// namespace accepts it.
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/storage"
+)
+
+// Transfer statuses.
+const (
+	StatusPending   = "pending"
+	StatusAccepted  = "accepted"
+	StatusDeclined  = "declined"
+	StatusCancelled = "cancelled"
+)
+
+var (
+	ErrNotFound  = errors.New("transfer not found")
+	ErrForbidden = errors.New("only the receiving namespace owner can accept this transfer")
+	ErrConflict  = errors.New("conflict")
+)
+
+// Transfer is a request to move a repository into another namespace.
+type Transfer struct {
+	ID              uuid.UUID  `json:"id"`
+	Repository      string     `json:"repository"` // full name when requested
+	TargetNamespace string     `json:"targetNamespace"`
+	NewName         string     `json:"newName"`
+	Status          string     `json:"status"`
+	RequestedBy     string     `json:"requestedBy,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	ResolvedAt      *time.Time `json:"resolvedAt,omitempty"`
+}
+
+type Service struct {
+	Metadata *metadata.Service
+	Storage  storage.Driver
+}
+
+func NewService(meta *metadata.Service, store storage.Driver) *Service {
+	return &Service{Metadata: meta, Storage: store}
+}
+
+func splitName(repoName string) (string, string) {
+	if parts := strings.SplitN(repoName, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "library", repoName
+}
+
+// Request opens a transfer of repoName into targetNamespace. Callers must
+// have checked that the requester administers the repository.
+func (s *Service) Request(ctx context.Context, repoName, targetNamespace string, requestedBy uuid.UUID) (*Transfer, error) {
+	db := s.Metadata.DB
+	ns, name := splitName(repoName)
+	if ns == targetNamespace {
+		return nil, fmt.Errorf("%w: repository is already in namespace %s", ErrConflict, ns)
+	}
+
+	var repoID uuid.UUID
+	err := db.QueryRowContext(ctx, `
+		SELECT r.id FROM repositories r JOIN namespaces n ON n.id = r.namespace_id
+		WHERE n.name = $1 AND r.name = $2 LIMIT 1`, ns, name).Scan(&repoID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("repository %s not found", repoName)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var targetID uuid.UUID
+	err = db.QueryRowContext(ctx, "SELECT id FROM namespaces WHERE name = $1", targetNamespace).Scan(&targetID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("namespace %s not found", targetNamespace)
+	} else if err != nil {
+		return nil, err
+	}
+	if err := s.checkNameFree(ctx, db, targetID, targetNamespace, name); err != nil {
+		return nil, err
+	}
+
+	t := &Transfer{
+		Repository:      ns + "/" + name,
+		TargetNamespace: targetNamespace,
+		NewName:         targetNamespace + "/" + name,
+		Status:          StatusPending,
+	}
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO repository_transfers (repository_id, source_name, target_namespace_id, requested_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`, repoID, t.Repository, targetID, requestedBy).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "idx_repository_transfers_pending") {
+			return nil, fmt.Errorf("%w: a transfer of %s is already pending", ErrConflict, t.Repository)
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s *Service) checkNameFree(ctx context.Context, q queryer, namespaceID uuid.UUID, namespace, name string) error {
+	var taken bool
+	err := q.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM repositories WHERE namespace_id = $1 AND name = $2)",
+		namespaceID, name).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("%w: %s/%s already exists", ErrConflict, namespace, name)
+	}
+	return nil
+}
+
+// List returns pending transfers the user sent or may accept; admins see
+// every pending transfer.
+func (s *Service) List(ctx context.Context, userID uuid.UUID, admin bool) ([]Transfer, error) {
+	rows, err := s.Metadata.DB.QueryContext(ctx, `
+		SELECT t.id, t.source_name, n.name, r.name, t.status, COALESCE(u.username, ''), t.created_at, t.resolved_at
+		FROM repository_transfers t
+		JOIN namespaces n ON n.id = t.target_namespace_id
+		JOIN repositories r ON r.id = t.repository_id
+		LEFT JOIN users u ON u.id = t.requested_by
+		WHERE t.status = 'pending' AND ($2 OR t.requested_by = $1 OR n.owner_id = $1)
+		ORDER BY t.created_at DESC`, userID, admin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []Transfer{}
+	for rows.Next() {
+		var t Transfer
+		var name string
+		var resolved sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Repository, &t.TargetNamespace, &name, &t.Status, &t.RequestedBy, &t.CreatedAt, &resolved); err != nil {
+			return nil, err
+		}
+		t.NewName = t.TargetNamespace + "/" + name
+		if resolved.Valid {
+			t.ResolvedAt = &resolved.Time
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+// pending is a locked, open transfer with what accepting it needs.
+type pending struct {
+	Transfer
+	repoID      uuid.UUID
+	sourceNS    string
+	name        string
+	targetID    uuid.UUID
+	targetOwner uuid.NullUUID
+	oldOwner    uuid.NullUUID
+	requestedBy uuid.NullUUID
+}
+
+func (s *Service) lockPending(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*pending, error) {
+	var p pending
+	err := tx.QueryRowContext(ctx, `
+		SELECT t.id, t.source_name, t.created_at, t.requested_by, r.id, sn.name, r.name, r.owner_id, tn.id, tn.name, tn.owner_id
+		FROM repository_transfers t
+		JOIN repositories r ON r.id = t.repository_id
+		JOIN namespaces sn ON sn.id = r.namespace_id
+		JOIN namespaces tn ON tn.id = t.target_namespace_id
+		WHERE t.id = $1 AND t.status = 'pending'
+		FOR UPDATE OF t`, id).Scan(
+		&p.ID, &p.Repository, &p.CreatedAt, &p.requestedBy, &p.repoID, &p.sourceNS, &p.name, &p.oldOwner,
+		&p.targetID, &p.TargetNamespace, &p.targetOwner)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.NewName = p.TargetNamespace + "/" + p.name
+	p.Status = StatusPending
+	return &p, nil
+}
+
+// Accept moves the repository: its stored manifests are copied to the new
+// path, the repository row is re-parented and handed to the target
+// namespace owner, and the old objects are removed. Manifests and tags keep
+// their IDs, so scan results and history follow the repository.
+func (s *Service) Accept(ctx context.Context, id, userID uuid.UUID, admin bool) (*Transfer, error) {
+	tx, err := s.Metadata.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := s.lockPending(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !admin && !(p.targetOwner.Valid && p.targetOwner.UUID == userID) {
+		return nil, ErrForbidden
+	}
+	if err := s.checkNameFree(ctx, tx, p.targetID, p.TargetNamespace, p.name); err != nil {
+		return nil, err
+	}
+
+	oldPaths, err := s.copyManifests(ctx, p.sourceNS, p.name, p.TargetNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy manifests: %w", err)
+	}
+
+	newOwner := p.oldOwner
+	if p.targetOwner.Valid {
+		newOwner = p.targetOwner
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE repositories SET namespace_id = $2, owner_id = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $1`,
+		p.repoID, p.targetID, newOwner); err != nil {
+		s.removeCopies(ctx, p.TargetNamespace, p.name, oldPaths)
+		return nil, err
+	}
+	// Blobs uploaded but not yet referenced move with the repository name;
+	// those left at the new name by an abandoned push are dropped first.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM blob_links WHERE repository = $1`, p.NewName); err != nil {
+		s.removeCopies(ctx, p.TargetNamespace, p.name, oldPaths)
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		WITH moved AS (DELETE FROM blob_links WHERE repository = $1 RETURNING digest, created_at)
+		INSERT INTO blob_links (repository, digest, created_at) SELECT $2, digest, created_at FROM moved
+		ON CONFLICT (repository, digest) DO NOTHING`,
+		p.sourceNS+"/"+p.name, p.NewName); err != nil {
+		s.removeCopies(ctx, p.TargetNamespace, p.name, oldPaths)
+		return nil, err
+	}
+	// Teams belong to a namespace; theirs do not move with the repository.
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM repository_permissions WHERE repository_id = $1 AND principal_type = 'team'`, p.repoID); err != nil {
+		s.removeCopies(ctx, p.TargetNamespace, p.name, oldPaths)
+		return nil, err
+	}
+	if newOwner != p.oldOwner {
+		// The previous owner's implicit admin grant goes with the ownership.
+		if p.oldOwner.Valid {
+			if _, err := tx.ExecContext(ctx, `
+				DELETE FROM repository_permissions
+				WHERE repository_id = $1 AND principal_type = 'user' AND principal_id = $2 AND role = 'admin'`,
+				p.repoID, p.oldOwner.UUID); err != nil {
+				s.removeCopies(ctx, p.TargetNamespace, p.name, oldPaths)
+				return nil, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO repository_permissions (repository_id, principal_type, principal_id, role, granted_by)
+			VALUES ($1, 'user', $2, 'admin', $3)
+			ON CONFLICT (repository_id, principal_type, principal_id) DO UPDATE SET role = 'admin'`,
+			p.repoID, newOwner.UUID, userID); err != nil {
+			s.removeCopies(ctx, p.TargetNamespace, p.name, oldPaths)
+			return nil, err
+		}
+	}
+	if err := s.resolve(ctx, tx, p, StatusAccepted, userID); err != nil {
+		s.removeCopies(ctx, p.TargetNamespace, p.name, oldPaths)
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		s.removeCopies(ctx, p.TargetNamespace, p.name, oldPaths)
+		return nil, err
+	}
+
+	for _, old := range oldPaths {
+		if err := s.Storage.Delete(ctx, old); err != nil {
+			fmt.Printf("[Transfer] Failed to delete old manifest object %s: %v\n", old, err)
+		}
+	}
+	s.Metadata.MarkStatsDirty()
+	fmt.Printf("[Transfer] Moved %s to %s (%d manifest objects)\n", p.Repository, p.NewName, len(oldPaths))
+	return &p.Transfer, nil
+}
+
+// Decline closes a pending transfer. The receiving owner declines it; the
+// requester cancels it. Admins may do either.
+func (s *Service) Decline(ctx context.Context, id, userID uuid.UUID, admin bool) (*Transfer, error) {
+	tx, err := s.Metadata.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := s.lockPending(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	var status string
+	switch {
+	case p.targetOwner.Valid && p.targetOwner.UUID == userID:
+		status = StatusDeclined
+	case p.requestedBy.Valid && p.requestedBy.UUID == userID:
+		status = StatusCancelled
+	case admin:
+		status = StatusDeclined
+	default:
+		return nil, ErrNotFound
+	}
+	if err := s.resolve(ctx, tx, p, status, userID); err != nil {
+		return nil, err
+	}
+	return &p.Transfer, tx.Commit()
+}
+
+func (s *Service) resolve(ctx context.Context, tx *sql.Tx, p *pending, status string, userID uuid.UUID) error {
+	now := time.Now()
+	_, err := tx.ExecContext(ctx, `
+		UPDATE repository_transfers SET status = $2, resolved_by = $3, resolved_at = $4 WHERE id = $1`,
+		p.ID, status, uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil}, now)
+	if err == nil {
+		p.Status = status
+		p.ResolvedAt = &now
+	}
+	return err
+}
+
+// manifestPrefixes lists where a repository's manifests may be stored:
+// library repositories can be pushed with or without the prefix.
+func manifestPrefixes(ns, name string) []string {
+	prefixes := []string{path.Join("manifests", ns, name) + "/"}
+	if ns == "library" {
+		prefixes = append(prefixes, path.Join("manifests", name)+"/")
+	}
+	return prefixes
+}
+
+// copyManifests copies every stored manifest of ns/name under the target
+// namespace and returns the source paths. On failure the copies made so far
+// are removed.
+func (s *Service) copyManifests(ctx context.Context, ns, name, targetNS string) ([]string, error) {
+	lister, ok := s.Storage.(storage.Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage driver cannot list objects")
+	}
+	var sources []string
+	for _, prefix := range manifestPrefixes(ns, name) {
+		err := lister.List(ctx, prefix, func(p string, size int64) error {
+			// Deeper keys belong to other repositories (e.g. manifests/foo/bar/...).
+			if !strings.Contains(strings.TrimPrefix(p, prefix), "/") {
+				sources = append(sources, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var copied []string
+	for _, src := range sources {
+		if err := s.copyObject(ctx, src, path.Join("manifests", targetNS, name, path.Base(src))); err != nil {
+			s.removeCopies(ctx, targetNS, name, copied)
+			return nil, fmt.Errorf("%s: %w", src, err)
+		}
+		copied = append(copied, src)
+	}
+	return sources, nil
+}
+
+func (s *Service) copyObject(ctx context.Context, src, dst string) error {
+	reader, err := s.Storage.Reader(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	writer, err := s.Storage.Writer(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		if a, ok := writer.(storage.Aborter); ok {
+			a.Abort()
+		}
+		return err
+	}
+	return writer.Close()
+}
+
+// removeCopies deletes the target-side copies of sources after a failed move.
+func (s *Service) removeCopies(ctx context.Context, targetNS, name string, sources []string) {
+	for _, src := range sources {
+		dst := path.Join("manifests", targetNS, name, path.Base(src))
+		if err := s.Storage.Delete(ctx, dst); err != nil {
+			fmt.Printf("[Transfer] Failed to remove copied manifest %s: %v\n", dst, err)
+		}
+	}
+}
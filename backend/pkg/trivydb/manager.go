@@ -0,0 +1,360 @@
+// Package trivydb manages the Trivy vulnerability database for deployments
+// that cannot reach ghcr.io. Bundles (the db.tar.gz layer of
+// ghcr.io/aquasecurity/trivy-db) are imported from an upload, a local path or
+// an internal mirror, and scans are refused once the database is too old to
+// be trusted.
+package trivydb
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	dbFile       = "trivy.db"
+	metadataFile = "metadata.json"
+
+	// schemaVersion is the DB schema the bundled Trivy reads.
+	schemaVersion = 2
+
+	// maxEntrySize bounds each extracted file (trivy.db is well under 1GB).
+	maxEntrySize = 4 << 30
+)
+
+var (
+	// ErrStale is returned by Check when scans must be refused.
+	ErrStale = errors.New("vulnerability database is out of date")
+	// ErrNotNewer is returned when a bundle is not newer than the installed DB.
+	ErrNotNewer = errors.New("bundle is not newer than the installed database")
+)
+
+// Metadata is Trivy's db/metadata.json.
+type Metadata struct {
+	Version      int       `json:"Version"`
+	NextUpdate   time.Time `json:"NextUpdate"`
+	UpdatedAt    time.Time `json:"UpdatedAt"`
+	DownloadedAt time.Time `json:"DownloadedAt"`
+}
+
+// ImportResult describes the last import attempt.
+type ImportResult struct {
+	At     time.Time `json:"at"`
+	Source string    `json:"source"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Status is the state of the local database.
+type Status struct {
+	Present     bool          `json:"present"`
+	Version     int           `json:"version,omitempty"`
+	UpdatedAt   *time.Time    `json:"updatedAt,omitempty"` // when the DB was built upstream
+	ImportedAt  *time.Time    `json:"importedAt,omitempty"`
+	AgeHours    float64       `json:"ageHours"`
+	MaxAgeHours int           `json:"maxAgeHours"` // 0 = no limit
+	Stale       bool          `json:"stale"`
+	Offline     bool          `json:"offline"`
+	Mirror      string        `json:"mirror,omitempty"`
+	CacheDir    string        `json:"cacheDir"`
+	LastImport  *ImportResult `json:"lastImport,omitempty"`
+}
+
+// Manager owns the database under CacheDir/db, where Trivy reads it.
+type Manager struct {
+	CacheDir string
+	Mirror   string        // URL of a db.tar.gz on an internal mirror
+	MaxAge   time.Duration // scans are refused when the DB is older (0 = never)
+	Offline  bool          // Trivy must not try to download the DB itself
+
+	Client *http.Client
+
+	importing  sync.Mutex // one import at a time
+	mu         sync.Mutex
+	lastImport *ImportResult
+}
+
+// NewManager creates a manager. An empty cacheDir means Trivy's default,
+// $XDG_CACHE_HOME/trivy or ~/.cache/trivy.
+func NewManager(cacheDir, mirror string, maxAge time.Duration, offline bool) *Manager {
+	if cacheDir == "" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			cacheDir = filepath.Join(dir, "trivy")
+		}
+	}
+	return &Manager{
+		CacheDir: cacheDir,
+		Mirror:   mirror,
+		MaxAge:   maxAge,
+		Offline:  offline,
+		Client:   &http.Client{Timeout: 30 * time.Minute},
+	}
+}
+
+func (m *Manager) dbDir() string { return filepath.Join(m.CacheDir, "db") }
+
+// ScanArgs are the extra trivy flags the configuration calls for.
+func (m *Manager) ScanArgs() []string {
+	if m == nil || !m.Offline {
+		return nil
+	}
+	return []string{"--skip-db-update", "--skip-java-db-update", "--offline-scan"}
+}
+
+func (m *Manager) readMetadata() (*Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(m.dbDir(), metadataFile))
+	if err != nil {
+		return nil, err
+	}
+	var md Metadata
+	if err := json.Unmarshal(data, &md); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", metadataFile, err)
+	}
+	return &md, nil
+}
+
+// Status reports the version and age of the local database.
+func (m *Manager) Status() *Status {
+	st := &Status{
+		MaxAgeHours: int(m.MaxAge / time.Hour),
+		Offline:     m.Offline,
+		Mirror:      m.Mirror,
+		CacheDir:    m.CacheDir,
+	}
+	m.mu.Lock()
+	if m.lastImport != nil {
+		last := *m.lastImport
+		st.LastImport = &last
+	}
+	m.mu.Unlock()
+
+	md, err := m.readMetadata()
+	if err == nil {
+		_, err = os.Stat(filepath.Join(m.dbDir(), dbFile))
+	}
+	if err != nil {
+		// Missing DB: only a problem when Trivy can't download one itself.
+		st.Stale = m.Offline
+		return st
+	}
+	st.Present = true
+	st.Version = md.Version
+	if !md.UpdatedAt.IsZero() {
+		updated := md.UpdatedAt
+		st.UpdatedAt = &updated
+		st.AgeHours = time.Since(updated).Hours()
+	}
+	if !md.DownloadedAt.IsZero() {
+		imported := md.DownloadedAt
+		st.ImportedAt = &imported
+	}
+	st.Stale = m.MaxAge > 0 && (md.UpdatedAt.IsZero() || time.Since(md.UpdatedAt) > m.MaxAge)
+	return st
+}
+
+// Check returns an error wrapping ErrStale, with a message fit for users,
+// when scans should be refused. A nil *Manager allows everything.
+func (m *Manager) Check() error {
+	if m == nil {
+		return nil
+	}
+	st := m.Status()
+	if !st.Stale {
+		return nil
+	}
+	if !st.Present {
+		return fmt.Errorf("%w: no vulnerability database installed; an admin must import one", ErrStale)
+	}
+	return fmt.Errorf("%w: built %.0f days ago, limit is %d days; an admin must import a newer bundle",
+		ErrStale, st.AgeHours/24, st.MaxAgeHours/24)
+}
+
+// ImportFile imports a bundle from a local path.
+func (m *Manager) ImportFile(ctx context.Context, p string) (*Status, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, m.record(p, err)
+	}
+	defer f.Close()
+	return m.Import(ctx, "file:"+p, f)
+}
+
+// ImportURL downloads and imports a bundle; an empty url uses the mirror.
+func (m *Manager) ImportURL(ctx context.Context, url string) (*Status, error) {
+	if url == "" {
+		url = m.Mirror
+	}
+	if url == "" {
+		return nil, errors.New("no URL given and no mirror configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, m.record(url, err)
+	}
+	if md, err := m.readMetadata(); err == nil && !md.DownloadedAt.IsZero() {
+		req.Header.Set("If-Modified-Since", md.DownloadedAt.UTC().Format(http.TimeFormat))
+	}
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return nil, m.record(url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, ErrNotNewer
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, m.record(url, fmt.Errorf("mirror returned %s", resp.Status))
+	}
+	return m.Import(ctx, url, resp.Body)
+}
+
+// Import extracts a db.tar.gz bundle and swaps it in. The bundle must hold a
+// trivy.db and a metadata.json of the schema version Trivy expects; the
+// current database is left untouched if anything is wrong.
+func (m *Manager) Import(ctx context.Context, source string, r io.Reader) (*Status, error) {
+	m.importing.Lock()
+	err := m.extractAndSwap(ctx, r)
+	m.importing.Unlock()
+	if err != nil {
+		return nil, m.record(source, err)
+	}
+
+	m.mu.Lock()
+	m.lastImport = &ImportResult{At: time.Now(), Source: source}
+	m.mu.Unlock()
+	fmt.Printf("[TrivyDB] Imported vulnerability database from %s\n", source)
+	return m.Status(), nil
+}
+
+func (m *Manager) record(source string, err error) error {
+	m.mu.Lock()
+	m.lastImport = &ImportResult{At: time.Now(), Source: source, Error: err.Error()}
+	m.mu.Unlock()
+	return err
+}
+
+func (m *Manager) extractAndSwap(ctx context.Context, r io.Reader) error {
+	if err := os.MkdirAll(m.CacheDir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(m.CacheDir, "db-import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("bundle is not gzip-compressed: %w", err)
+	}
+	defer gz.Close()
+
+	found := map[string]bool{}
+	tr := tar.NewReader(gz)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid bundle: %w", err)
+		}
+		// Only the two known files are taken, whatever directory they sit in.
+		name := path.Base(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || (name != dbFile && name != metadataFile) {
+			continue
+		}
+		if hdr.Size > maxEntrySize {
+			return fmt.Errorf("%s is too large (%d bytes)", name, hdr.Size)
+		}
+		if err := writeFile(filepath.Join(tmp, name), tr); err != nil {
+			return err
+		}
+		found[name] = true
+	}
+	if !found[dbFile] || !found[metadataFile] {
+		return fmt.Errorf("bundle must contain %s and %s", dbFile, metadataFile)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmp, metadataFile))
+	if err != nil {
+		return err
+	}
+	var md Metadata
+	if err := json.Unmarshal(data, &md); err != nil {
+		return fmt.Errorf("invalid %s: %w", metadataFile, err)
+	}
+	if md.Version != schemaVersion {
+		return fmt.Errorf("bundle has DB schema version %d, Trivy needs %d", md.Version, schemaVersion)
+	}
+	if md.UpdatedAt.IsZero() {
+		return fmt.Errorf("%s has no UpdatedAt", metadataFile)
+	}
+	if cur, err := m.readMetadata(); err == nil && !md.UpdatedAt.After(cur.UpdatedAt) {
+		return fmt.Errorf("%w (bundle built %s, installed %s)", ErrNotNewer,
+			md.UpdatedAt.Format(time.RFC3339), cur.UpdatedAt.Format(time.RFC3339))
+	}
+
+	// Record the import time the way Trivy does after a download.
+	md.DownloadedAt = time.Now().UTC()
+	if data, err = json.Marshal(md); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, metadataFile), data, 0o644); err != nil {
+		return err
+	}
+
+	// Swap directories so Trivy never sees a half-written database.
+	old := m.dbDir() + ".old"
+	os.RemoveAll(old)
+	if err := os.Rename(m.dbDir(), old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp, m.dbDir()); err != nil {
+		os.Rename(old, m.dbDir())
+		return err
+	}
+	os.RemoveAll(old)
+	return nil
+}
+
+func writeFile(p string, r io.Reader) error {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, io.LimitReader(r, maxEntrySize)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Run imports from the mirror every interval until ctx is cancelled. Mirrors
+// that honor If-Modified-Since are only downloaded from when they change.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.ImportURL(ctx, ""); err != nil && !errors.Is(err, ErrNotNewer) {
+			fmt.Printf("[TrivyDB] Mirror sync failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
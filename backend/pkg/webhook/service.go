@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/registryx/registryx/backend/pkg/httpclient"
+	"github.com/registryx/registryx/backend/pkg/metadata"
 )
 
 type Event struct {
@@ -16,14 +19,32 @@ type Event struct {
 	Digest     string    `json:"digest"`
 	Timestamp  time.Time `json:"timestamp"`
 	User       string    `json:"user"`
+
+	Build *metadata.BuildMetadata `json:"build,omitempty"` // CI build the image came from, if reported
+	Quota *QuotaUsage             `json:"quota,omitempty"` // set on quota.threshold events
+}
+
+// QuotaUsage is the storage use of a namespace that crossed a quota threshold.
+type QuotaUsage struct {
+	Namespace  string  `json:"namespace"`
+	Threshold  int     `json:"threshold"` // percent of the quota
+	Percent    float64 `json:"percent"`
+	UsedBytes  int64   `json:"usedBytes"`
+	QuotaBytes int64   `json:"quotaBytes"`
 }
 
 type Service struct {
 	WebhookURL string
+
+	// Deliveries are retried on network errors, 429 and 5xx, so a receiver
+	// may see an event twice.
+	client *httpclient.Client
 }
 
 func NewService(url string) *Service {
-	return &Service{WebhookURL: url}
+	client := httpclient.New("webhook")
+	client.Timeout = 5 * time.Second
+	return &Service{WebhookURL: url, client: client}
 }
 
 func (s *Service) Notify(ctx context.Context, event Event) error {
@@ -43,8 +64,7 @@ func (s *Service) Notify(ctx context.Context, event Event) error {
 	req.Header.Set("Content-Type", "application/json")
 
 	// Fire and forget-ish, but check status
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send webhook: %w", err)
 	}
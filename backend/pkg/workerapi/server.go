@@ -0,0 +1,315 @@
+// Package workerapi exposes the internal gRPC API used by external scan workers.
+package workerapi
+
+//go:generate protoc -I ../../proto --go_out=. --go_opt=module=github.com/registryx/registryx/backend/pkg/workerapi --go-grpc_out=. --go-grpc_opt=module=github.com/registryx/registryx/backend/pkg/workerapi worker/v1/worker.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/registryx/registryx/backend/pkg/alerts"
+	"github.com/registryx/registryx/backend/pkg/intelligence"
+	"github.com/registryx/registryx/backend/pkg/metadata"
+	"github.com/registryx/registryx/backend/pkg/queue"
+	"github.com/registryx/registryx/backend/pkg/scanner"
+	"github.com/registryx/registryx/backend/pkg/tracing"
+	"github.com/registryx/registryx/backend/pkg/workerapi/workerpb"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpcmeta "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultLeaseTTL = 10 * time.Minute
+	maxLeaseTTL     = time.Hour
+	maxLeaseWait    = 30 * time.Second
+)
+
+// Server implements workerpb.WorkerServiceServer on top of the queue, scanner
+// and metadata services.
+type Server struct {
+	workerpb.UnimplementedWorkerServiceServer
+
+	Queue        *queue.Service
+	Scanner      *scanner.Service
+	Metadata     *metadata.Service
+	Intelligence *intelligence.Service
+	Alerts       *alerts.Service // optional; evaluated after each submitted report
+	Token        string
+
+	// CertFile and KeyFile serve the API over TLS, so the token isn't
+	// sent in the clear. Without them ListenAndServe refuses to start
+	// unless Insecure is set.
+	CertFile, KeyFile string
+	Insecure          bool
+
+	// PullToken mints the short-lived token a worker pulls the leased
+	// image with; sent with each lease and renewal.
+	PullToken func(ctx context.Context, repoName string) (string, error)
+}
+
+func NewServer(q *queue.Service, scan *scanner.Service, meta *metadata.Service, intel *intelligence.Service, token string) *Server {
+	return &Server{
+		Queue:        q,
+		Scanner:      scan,
+		Metadata:     meta,
+		Intelligence: intel,
+		Token:        token,
+	}
+}
+
+// ListenAndServe starts the gRPC server on addr and blocks until it stops.
+func (s *Server) ListenAndServe(addr string) error {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(s.authInterceptor)}
+	switch {
+	case s.CertFile != "":
+		creds, err := credentials.NewServerTLSFromFile(s.CertFile, s.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	case !s.Insecure:
+		return errors.New("no TLS certificate configured")
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	srv := grpc.NewServer(opts...)
+	workerpb.RegisterWorkerServiceServer(srv, s)
+
+	// Reap leases of workers that died mid-scan.
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			n, err := s.Queue.RequeueExpiredLeases(context.Background())
+			if err != nil {
+				fmt.Printf("[WorkerAPI] Lease reaper error: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("[WorkerAPI] Requeued %d expired scan leases\n", n)
+			}
+		}
+	}()
+
+	return srv.Serve(lis)
+}
+
+// authInterceptor requires "authorization: Bearer <WORKER_API_TOKEN>" on every call.
+func (s *Server) authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authenticate(ctx context.Context) error {
+	md, ok := grpcmeta.FromIncomingContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing credentials")
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	token := strings.TrimPrefix(values[0], "Bearer ")
+	if s.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid worker token")
+	}
+	return nil
+}
+
+func clampSeconds(v int32, def, max time.Duration) time.Duration {
+	if v <= 0 {
+		return def
+	}
+	d := time.Duration(v) * time.Second
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// LeaseScanJob implements workerpb.WorkerServiceServer. Jobs a recent
+// report of the same digest can complete are finished here without a
+// worker, and the next job is leased instead.
+func (s *Server) LeaseScanJob(ctx context.Context, req *workerpb.LeaseScanJobRequest) (*workerpb.LeaseScanJobResponse, error) {
+	ttl := clampSeconds(req.LeaseSeconds, defaultLeaseTTL, maxLeaseTTL)
+	wait := clampSeconds(req.WaitSeconds, time.Second, maxLeaseWait)
+	deadline := time.Now().Add(wait)
+
+	for {
+		lease, err := s.Queue.LeaseScan(ctx, ttl, wait)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "queue error: %v", err)
+		}
+		if lease == nil {
+			return &workerpb.LeaseScanJobResponse{Found: false}, nil
+		}
+
+		reused, err := s.Scanner.ReuseRecent(ctx, lease.Job.ManifestID, lease.Job.Repository, lease.Job.Reference)
+		if err != nil {
+			fmt.Printf("[WorkerAPI] Could not reuse an earlier scan of %s:%s: %v\n", lease.Job.Repository, lease.Job.Reference, err)
+		}
+		if reused {
+			if err := s.Queue.CompleteLease(ctx, lease.ID); err != nil {
+				fmt.Printf("[WorkerAPI] Failed to complete lease %s: %v\n", lease.ID, err)
+			}
+			s.afterScan(ctx, lease.Job)
+			// BLPOP blocks forever on a zero timeout, so stop short of it.
+			if wait = time.Until(deadline).Truncate(time.Second); wait < time.Second {
+				return &workerpb.LeaseScanJobResponse{Found: false}, nil
+			}
+			continue
+		}
+
+		token, err := s.pullToken(ctx, lease.Job.Repository)
+		if err != nil {
+			// The lease reaper puts the job back once the lease expires.
+			return nil, status.Errorf(codes.Unavailable, "could not mint registry token: %v", err)
+		}
+
+		s.Scanner.MarkScanning(ctx, lease.Job.ManifestID, lease.Job.Repository, lease.Job.Reference)
+		fmt.Printf("[WorkerAPI] Leased scan of %s:%s to worker %s (lease %s)\n", lease.Job.Repository, lease.Job.Reference, req.WorkerId, lease.ID)
+
+		return &workerpb.LeaseScanJobResponse{
+			Found: true,
+			Job: &workerpb.ScanJob{
+				LeaseId:        lease.ID,
+				ManifestId:     lease.Job.ManifestID.String(),
+				Repository:     lease.Job.Repository,
+				Reference:      lease.Job.Reference,
+				LeaseExpiresAt: lease.ExpiresAt.Unix(),
+				RegistryToken:  token,
+			},
+		}, nil
+	}
+}
+
+// pullToken mints a registry token that can pull repoName, or returns ""
+// without a PullToken func.
+func (s *Server) pullToken(ctx context.Context, repoName string) (string, error) {
+	if s.PullToken == nil {
+		return "", nil
+	}
+	return s.PullToken(ctx, repoName)
+}
+
+// RenewLease implements workerpb.WorkerServiceServer. The lease's registry
+// token may expire before it, so a fresh one is sent along.
+func (s *Server) RenewLease(ctx context.Context, req *workerpb.RenewLeaseRequest) (*workerpb.RenewLeaseResponse, error) {
+	ttl := clampSeconds(req.LeaseSeconds, defaultLeaseTTL, maxLeaseTTL)
+	expiresAt, err := s.Queue.RenewLease(ctx, req.LeaseId, ttl)
+	if errors.Is(err, queue.ErrLeaseNotFound) {
+		return nil, status.Error(codes.NotFound, "lease expired or unknown")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "queue error: %v", err)
+	}
+	job, err := s.Queue.GetLease(ctx, req.LeaseId)
+	if errors.Is(err, queue.ErrLeaseNotFound) {
+		return nil, status.Error(codes.NotFound, "lease expired or unknown")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "queue error: %v", err)
+	}
+	token, err := s.pullToken(ctx, job.Repository)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not mint registry token: %v", err)
+	}
+	return &workerpb.RenewLeaseResponse{LeaseExpiresAt: expiresAt.Unix(), RegistryToken: token}, nil
+}
+
+// SubmitScanResult implements workerpb.WorkerServiceServer.
+func (s *Server) SubmitScanResult(ctx context.Context, req *workerpb.SubmitScanResultRequest) (*workerpb.SubmitScanResultResponse, error) {
+	job, err := s.Queue.GetLease(ctx, req.LeaseId)
+	if errors.Is(err, queue.ErrLeaseNotFound) {
+		return nil, status.Error(codes.NotFound, "lease expired or unknown")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "queue error: %v", err)
+	}
+	if req.ManifestId != "" && req.ManifestId != job.ManifestID.String() {
+		return nil, status.Error(codes.InvalidArgument, "manifest_id does not match lease")
+	}
+
+	if req.Failed {
+		fmt.Printf("[WorkerAPI] Worker reported failed scan for %s: %s\n", job.ManifestID, req.Error)
+		s.Scanner.MarkFailed(ctx, job.ManifestID, job.Repository, job.Reference, req.Error)
+		s.Queue.CompleteLease(ctx, req.LeaseId)
+		return &workerpb.SubmitScanResultResponse{}, nil
+	}
+
+	summary, err := s.Scanner.SubmitReport(ctx, job.ManifestID, job.Repository, job.Reference, req.ReportJson)
+	if err != nil {
+		s.Queue.CompleteLease(ctx, req.LeaseId)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err := s.Queue.CompleteLease(ctx, req.LeaseId); err != nil {
+		fmt.Printf("[WorkerAPI] Failed to complete lease %s: %v\n", req.LeaseId, err)
+	}
+
+	s.afterScan(ctx, *job)
+
+	return &workerpb.SubmitScanResultResponse{
+		Critical: int32(summary.Critical),
+		High:     int32(summary.High),
+		Medium:   int32(summary.Medium),
+		Low:      int32(summary.Low),
+	}, nil
+}
+
+// afterScan runs the same post-processing as the embedded worker once a
+// job's report is saved, in the trace of the push that queued the job.
+func (s *Server) afterScan(ctx context.Context, job queue.Job) {
+	ctx, span := tracing.Start(tracing.Extract(ctx, job.Trace), "scan.complete", attribute.String("registry.repository", job.Repository), attribute.String("registry.reference", job.Reference))
+	defer span.End()
+	if s.Intelligence != nil {
+		_ = s.Intelligence.CalculateManifestPriorities(ctx, job.ManifestID)
+	}
+	s.Metadata.CalculateAndStoreHealthScore(ctx, job.ManifestID)
+	if s.Alerts != nil {
+		if err := s.Alerts.Evaluate(ctx, job.ManifestID, job.Repository, job.Reference); err != nil {
+			fmt.Printf("[WorkerAPI] Alert rules for %s failed: %v\n", job.ManifestID, err)
+		}
+	}
+}
+
+// GetManifest implements workerpb.WorkerServiceServer.
+func (s *Server) GetManifest(ctx context.Context, req *workerpb.GetManifestRequest) (*workerpb.GetManifestResponse, error) {
+	var manifestID uuid.UUID
+	if id, err := uuid.Parse(req.Reference); err == nil && req.Repository == "" {
+		manifestID = id
+	} else {
+		id, err := s.Metadata.GetManifestID(ctx, req.Repository, req.Reference)
+		if errors.Is(err, metadata.ErrRepositoryNotFound) || errors.Is(err, metadata.ErrManifestNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		manifestID = id
+	}
+
+	digest, size, mediaType, err := s.Metadata.GetManifestDetails(ctx, manifestID)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "manifest not found")
+	}
+
+	return &workerpb.GetManifestResponse{
+		ManifestId: manifestID.String(),
+		Digest:     digest,
+		MediaType:  mediaType,
+		Size:       size,
+	}, nil
+}
@@ -0,0 +1,232 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: worker/v1/worker.proto
+
+package workerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	WorkerService_LeaseScanJob_FullMethodName     = "/registryx.worker.v1.WorkerService/LeaseScanJob"
+	WorkerService_RenewLease_FullMethodName       = "/registryx.worker.v1.WorkerService/RenewLease"
+	WorkerService_SubmitScanResult_FullMethodName = "/registryx.worker.v1.WorkerService/SubmitScanResult"
+	WorkerService_GetManifest_FullMethodName      = "/registryx.worker.v1.WorkerService/GetManifest"
+)
+
+// WorkerServiceClient is the client API for WorkerService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WorkerServiceClient interface {
+	// LeaseScanJob hands out the next queued scan job. The lease must be
+	// completed with SubmitScanResult (or renewed) before it expires, otherwise
+	// the job is put back on the queue.
+	LeaseScanJob(ctx context.Context, in *LeaseScanJobRequest, opts ...grpc.CallOption) (*LeaseScanJobResponse, error)
+	// RenewLease extends a lease for long running scans.
+	RenewLease(ctx context.Context, in *RenewLeaseRequest, opts ...grpc.CallOption) (*RenewLeaseResponse, error)
+	// SubmitScanResult stores a Trivy JSON report (or a failure) for a leased job.
+	SubmitScanResult(ctx context.Context, in *SubmitScanResultRequest, opts ...grpc.CallOption) (*SubmitScanResultResponse, error)
+	// GetManifest resolves a repository reference to its manifest metadata.
+	GetManifest(ctx context.Context, in *GetManifestRequest, opts ...grpc.CallOption) (*GetManifestResponse, error)
+}
+
+type workerServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWorkerServiceClient(cc grpc.ClientConnInterface) WorkerServiceClient {
+	return &workerServiceClient{cc}
+}
+
+func (c *workerServiceClient) LeaseScanJob(ctx context.Context, in *LeaseScanJobRequest, opts ...grpc.CallOption) (*LeaseScanJobResponse, error) {
+	out := new(LeaseScanJobResponse)
+	err := c.cc.Invoke(ctx, WorkerService_LeaseScanJob_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerServiceClient) RenewLease(ctx context.Context, in *RenewLeaseRequest, opts ...grpc.CallOption) (*RenewLeaseResponse, error) {
+	out := new(RenewLeaseResponse)
+	err := c.cc.Invoke(ctx, WorkerService_RenewLease_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerServiceClient) SubmitScanResult(ctx context.Context, in *SubmitScanResultRequest, opts ...grpc.CallOption) (*SubmitScanResultResponse, error) {
+	out := new(SubmitScanResultResponse)
+	err := c.cc.Invoke(ctx, WorkerService_SubmitScanResult_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerServiceClient) GetManifest(ctx context.Context, in *GetManifestRequest, opts ...grpc.CallOption) (*GetManifestResponse, error) {
+	out := new(GetManifestResponse)
+	err := c.cc.Invoke(ctx, WorkerService_GetManifest_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WorkerServiceServer is the server API for WorkerService service.
+// All implementations must embed UnimplementedWorkerServiceServer
+// for forward compatibility
+type WorkerServiceServer interface {
+	// LeaseScanJob hands out the next queued scan job. The lease must be
+	// completed with SubmitScanResult (or renewed) before it expires, otherwise
+	// the job is put back on the queue.
+	LeaseScanJob(context.Context, *LeaseScanJobRequest) (*LeaseScanJobResponse, error)
+	// RenewLease extends a lease for long running scans.
+	RenewLease(context.Context, *RenewLeaseRequest) (*RenewLeaseResponse, error)
+	// SubmitScanResult stores a Trivy JSON report (or a failure) for a leased job.
+	SubmitScanResult(context.Context, *SubmitScanResultRequest) (*SubmitScanResultResponse, error)
+	// GetManifest resolves a repository reference to its manifest metadata.
+	GetManifest(context.Context, *GetManifestRequest) (*GetManifestResponse, error)
+	mustEmbedUnimplementedWorkerServiceServer()
+}
+
+// UnimplementedWorkerServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedWorkerServiceServer struct {
+}
+
+func (UnimplementedWorkerServiceServer) LeaseScanJob(context.Context, *LeaseScanJobRequest) (*LeaseScanJobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LeaseScanJob not implemented")
+}
+func (UnimplementedWorkerServiceServer) RenewLease(context.Context, *RenewLeaseRequest) (*RenewLeaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewLease not implemented")
+}
+func (UnimplementedWorkerServiceServer) SubmitScanResult(context.Context, *SubmitScanResultRequest) (*SubmitScanResultResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitScanResult not implemented")
+}
+func (UnimplementedWorkerServiceServer) GetManifest(context.Context, *GetManifestRequest) (*GetManifestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetManifest not implemented")
+}
+func (UnimplementedWorkerServiceServer) mustEmbedUnimplementedWorkerServiceServer() {}
+
+// UnsafeWorkerServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WorkerServiceServer will
+// result in compilation errors.
+type UnsafeWorkerServiceServer interface {
+	mustEmbedUnimplementedWorkerServiceServer()
+}
+
+func RegisterWorkerServiceServer(s grpc.ServiceRegistrar, srv WorkerServiceServer) {
+	s.RegisterService(&WorkerService_ServiceDesc, srv)
+}
+
+func _WorkerService_LeaseScanJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseScanJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).LeaseScanJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkerService_LeaseScanJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).LeaseScanJob(ctx, req.(*LeaseScanJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerService_RenewLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewLeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).RenewLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkerService_RenewLease_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).RenewLease(ctx, req.(*RenewLeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerService_SubmitScanResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitScanResultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).SubmitScanResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkerService_SubmitScanResult_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).SubmitScanResult(ctx, req.(*SubmitScanResultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerService_GetManifest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetManifestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).GetManifest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkerService_GetManifest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).GetManifest(ctx, req.(*GetManifestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// WorkerService_ServiceDesc is the grpc.ServiceDesc for WorkerService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var WorkerService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "registryx.worker.v1.WorkerService",
+	HandlerType: (*WorkerServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "LeaseScanJob",
+			Handler:    _WorkerService_LeaseScanJob_Handler,
+		},
+		{
+			MethodName: "RenewLease",
+			Handler:    _WorkerService_RenewLease_Handler,
+		},
+		{
+			MethodName: "SubmitScanResult",
+			Handler:    _WorkerService_SubmitScanResult_Handler,
+		},
+		{
+			MethodName: "GetManifest",
+			Handler:    _WorkerService_GetManifest_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "worker/v1/worker.proto",
+}
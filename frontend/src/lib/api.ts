@@ -44,6 +44,35 @@ export interface ManifestDetails {
     vulnerabilities?: VulnerabilitySummary;
     isSigned?: boolean;
     healthScore?: HealthScore;
+    lint?: LintFinding[];
+    build?: BuildMetadata;
+}
+
+export interface BuildMetadata {
+    pipelineUrl?: string;
+    commitSha?: string;
+    branch?: string;
+    builder?: string;
+    workflow?: string;
+    sourceRepository?: string;
+    provenance?: 'buildkit' | 'sigstore'; // absent when reported through the API
+    verified: boolean;
+    verificationError?: string;
+    reportedBy?: string;
+    updatedAt: string;
+}
+
+export interface LintFinding {
+    rule: 'root-user' | 'missing-user' | 'missing-healthcheck' | 'large-layer' | 'latest-base-tag' | 'package-cache';
+    severity: 'high' | 'medium' | 'low';
+    message: string;
+    step?: number; // index into the image history
+}
+
+export interface ScanStage {
+    stage: 'starting' | 'db_download' | 'analyzing' | 'detecting' | 'saving' | 'completed';
+    detail?: string; // e.g. the OS or language target being checked
+    at: string;
 }
 
 export interface ScanStatus {
@@ -52,6 +81,28 @@ export interface ScanStatus {
     summary?: VulnerabilitySummary;
     error?: string;
     progress_message?: string;
+    stage?: ScanStage['stage'];
+    stage_detail?: string;
+    stage_updated_at?: string;
+    stages?: ScanStage[];
+}
+
+export interface DeltaFinding {
+    cve: string;
+    package: string;
+    installedVersion?: string;
+    severity: string;
+}
+
+// A completed scan's findings compared with the scan before it
+export interface ScanDelta {
+    baseline: 'previous_scan' | 'previous_image' | 'none';
+    baselineReportId?: string;
+    newCount: number;
+    fixedCount: number;
+    existingCount: number;
+    new: DeltaFinding[];
+    fixed: DeltaFinding[];
 }
 
 export interface ScanHistoryEntry {
@@ -59,6 +110,37 @@ export interface ScanHistoryEntry {
     status: string;
     scanned_at?: string;
     summary?: VulnerabilitySummary;
+    delta?: ScanDelta;
+}
+
+export interface RepositorySummary {
+    name: string;
+    tagCount: number;
+    latestTag?: string;
+    totalSize: number;
+    lastPush?: string;
+    worstSeverity: 'critical' | 'high' | 'medium' | 'low' | 'none' | 'unscanned';
+    vulnerabilities: { critical: number; high: number; medium: number; low: number };
+    healthGrade?: string;
+    healthScore?: number;
+    maxSizeBytes?: number; // limits in effect, absent when unlimited
+    maxTags?: number;
+}
+
+export interface RepositoryLimits {
+    repository: string;
+    maxSizeBytes: number; // 0 = unlimited
+    maxTags: number;
+    sizeBytes: number;
+    tags: number;
+    sizeOverride: number | null; // null inherits the namespace/global default
+    tagsOverride: number | null;
+}
+
+export interface NamespaceEnvironment {
+    namespace: string;
+    environment: string;
+    inherited: boolean; // true when the global default applies
 }
 
 export interface ServiceAccount {
@@ -67,6 +149,269 @@ export interface ServiceAccount {
     created: string;
     lastUsed: string;
     status: 'active' | 'revoked';
+    tokenTtlSeconds?: number; // registry token lifetime override
+    allowedCidrs: string[]; // networks it may be used from; empty allows any
+}
+
+export interface ClientCertificate {
+    id: string;
+    subject: string; // "CN=ci-runner" or a full subject DN
+    userId?: string;
+    username?: string;
+    serviceAccount?: string;
+    createdAt: string;
+    lastUsedAt?: string;
+}
+
+export interface DedupStats {
+    namespace?: string;
+    manifests: number;
+    logical_bytes: number;
+    physical_bytes: number;
+    saved_bytes: number;
+    savings_ratio: number; // saved / logical
+    dedup_factor: number; // logical / physical
+    logical_storage_cost_usd: number;
+    effective_storage_cost_usd: number;
+}
+
+export interface TagCost {
+    manifest_id: string;
+    repository: string;
+    tag: string;
+    size_bytes: number;
+    storage_cost_usd: number;
+    bandwidth_cost_usd: number;
+    total_cost_usd: number;
+    pull_count_30d: number;
+    last_pulled_at?: string;
+    cost_per_pull: number;
+}
+
+export interface RepositoryCosts {
+    repository: string;
+    manifests: number;
+    size_bytes: number;
+    pull_count: number;
+    storage_cost_usd: number;
+    bandwidth_cost_usd: number;
+    total_cost_usd: number;
+    tags: TagCost[];
+    untagged_manifests: number;
+    untagged_cost_usd: number;
+    cost_trend: 'increasing' | 'decreasing' | 'stable';
+    trend: { day: string; size_bytes: number; storage_cost_usd: number; bandwidth_cost_usd: number; total_cost_usd: number }[];
+}
+
+export interface CostRecommendation {
+    type: 'zombie_cleanup' | 'retention' | 'oversized_images' | 'consolidate_tags';
+    repository: string;
+    title: string;
+    detail: string;
+    effort: 'low' | 'medium' | 'high';
+    estimated_savings_usd: number; // per month
+    estimated_saving_bytes: number;
+    manifest_ids?: string[];
+    tags?: string[];
+}
+
+export interface CostRefreshRun {
+    id: string;
+    trigger: 'schedule' | 'manual';
+    status: 'running' | 'succeeded' | 'failed';
+    images: number;
+    zombies: number;
+    error?: string;
+    started_at: string;
+    finished_at?: string;
+}
+
+export interface ReplicaStatus {
+    region: string;
+    endpoint: string;
+    bucket: string;
+    lastSync?: {
+        startedAt: string;
+        finishedAt: string;
+        copied: number;
+        pruned: number;
+        bytes: number;
+        error?: string;
+    };
+}
+
+export interface TrivyDBStatus {
+    present: boolean;
+    version?: number;
+    updatedAt?: string; // when the DB was built upstream
+    importedAt?: string;
+    ageHours: number;
+    maxAgeHours: number; // 0 = no limit
+    stale: boolean; // scans are refused
+    offline: boolean;
+    mirror?: string;
+    cacheDir: string;
+    lastImport?: { at: string, source: string, error?: string };
+}
+
+export interface AlertRule {
+    id: string;
+    repository: string;
+    name: string;
+    minSeverity: 'CRITICAL' | 'HIGH' | 'MEDIUM' | 'LOW' | 'UNKNOWN';
+    minEpss: number; // 0 = any
+    newOnly: boolean; // only findings the previous scan didn't have
+    channel: 'webhook' | 'slack' | 'email';
+    target: string; // URL, or email address
+    enabled: boolean;
+    createdAt: string;
+    lastTriggeredAt?: string;
+}
+
+export type AlertRuleInput = Omit<AlertRule, 'id' | 'repository' | 'createdAt' | 'lastTriggeredAt'>;
+
+export interface SecurityAlert {
+    id: string;
+    kind: 'mass_deletion' | 'failed_logins' | 'new_location' | 'off_hours_service_account';
+    severity: 'low' | 'medium' | 'high';
+    subject: string; // user ID, "serviceaccount:<name>", "username:<name>" or "ip:<address>"
+    summary: string;
+    details?: Record<string, unknown>;
+    createdAt: string;
+    acknowledgedAt?: string;
+}
+
+export interface SecurityEvent {
+    id: string;
+    kind: 'login_failed' | 'invalid_token';
+    username?: string; // target of a failed sign-in
+    method: 'password' | 'registry' | 'bearer';
+    reason?: string;
+    ip: string;
+    userAgent?: string;
+    path: string;
+    createdAt: string;
+}
+
+// json: Trivy's report; cyclonedx: the image SBOM; vdr: CycloneDX VDR linked to it
+export type ScanReportFormat = 'json' | 'sarif' | 'cyclonedx' | 'vdr';
+
+export interface EPSSImport {
+    scoreDate?: string;
+    modelVersion?: string;
+    count: number;
+    source: string;
+}
+
+export interface FleetVulnPriority {
+    cveId: string;
+    baseSeverity: string;
+    epssScore: number;
+    priorityScore: number;
+    recommendedAction: string;
+    runtimeExposed: boolean;
+    internetExposed: boolean;
+    manifestCount: number;
+    manifestIds: string[];
+    repositories: string[];
+}
+
+export interface FleetPriorities {
+    manifests: number;
+    totalCves: number;
+    vulnerabilities: FleetVulnPriority[];
+}
+
+export interface RegistryToken {
+    id: string; // jti
+    subject: string; // user ID or "serviceaccount:<name>"
+    username: string;
+    scope?: string;
+    issued_at: string;
+    expires_at: string;
+}
+
+export interface SigningKey {
+    kid: string;
+    source: 'config' | 'generated';
+    primary: boolean;
+    createdAt: string;
+    retiredAt?: string;
+    expiresAt?: string; // when a retired key stops validating tokens
+}
+
+export interface HistoryStep {
+    index: number;
+    instruction: string; // e.g. RUN, COPY, ENV
+    command: string; // reconstructed Dockerfile line
+    createdBy: string;
+    created?: string;
+    author?: string;
+    comment?: string;
+    emptyLayer: boolean;
+    layerDigest?: string;
+    size: number;
+}
+
+export interface ImpactedImage {
+    manifestId: string;
+    repository: string;
+    digest: string;
+    tags: string[];
+    depth: number; // 0 = the vulnerable image itself
+    via?: string; // digest of the vulnerable image it builds on
+    scanned: boolean; // its own scan reports the CVE
+}
+
+export interface ImpactReport {
+    cve?: string;
+    digest?: string;
+    total: number;
+    owners: { owner: string; images: ImpactedImage[] }[];
+}
+
+export interface RebuildRecommendation {
+    manifestId: string;
+    repository: string;
+    digest: string;
+    tags: string[];
+    environment: string;
+    pullCount: number;
+    lastPulledAt?: string;
+    owner: string;
+    baseRepository: string;
+    baseDigest: string; // the base the image was built on
+    patchedDigest: string; // the newer release to rebuild on
+    patchedTags: string[];
+    priorityScore: number;
+    fixedCount: number;
+    fixedCves: string[]; // first few of fixedCount
+}
+
+export interface RepositoryPermission {
+    principalType: 'user' | 'team';
+    principalId: string;
+    principal: string; // username for user grants
+    role: 'read' | 'write' | 'admin';
+}
+
+export interface RepositoryTransfer {
+    id: string;
+    repository: string;
+    targetNamespace: string;
+    newName: string;
+    status: 'pending' | 'accepted' | 'declined' | 'cancelled';
+    requestedBy?: string;
+    createdAt: string;
+    resolvedAt?: string;
+}
+
+export interface MaintenanceState {
+    enabled: boolean;
+    reason?: string;
+    retryAfterSeconds?: number;
+    since?: string;
+    enabledBy?: string;
 }
 
 // OCI Registry Auth Helpers (For internal use if needed)
@@ -82,6 +427,10 @@ export const registry = {
         return axiosInstance.get(`/v2/${repoName}/tags/list`);
     },
 
+    listRepositories: async () => {
+        return axiosInstance.get<{ repositories: RepositorySummary[] }>('/api/v1/repositories');
+    },
+
     getManifest: async (repoName: string, reference: string) => {
         return axiosInstance.get(`/v2/${repoName}/manifests/${reference}`);
     },
@@ -157,13 +506,112 @@ const customApi = {
         return axiosInstance.put('/api/v1/policy', rego, { headers: { 'Content-Type': 'text/plain' } });
     },
 
+    // Per-namespace policy environment ('' reverts to the global default)
+    getNamespaceEnvironment: async (namespace: string) => {
+        return axiosInstance.get<NamespaceEnvironment>(`/api/v1/namespaces/${encodeURIComponent(namespace)}/environment`);
+    },
+
+    updateNamespaceEnvironment: async (namespace: string, environment: string) => {
+        return axiosInstance.put<NamespaceEnvironment>(`/api/v1/namespaces/${encodeURIComponent(namespace)}/environment`, { environment });
+    },
+
+    // Networks pushes and pulls of a namespace may come from (empty = any)
+    getNamespaceNetworks: async (namespace: string) => {
+        return axiosInstance.get<{ namespace: string, cidrs: string[] }>(`/api/v1/namespaces/${encodeURIComponent(namespace)}/allowed-networks`);
+    },
+
+    updateNamespaceNetworks: async (namespace: string, cidrs: string[]) => {
+        return axiosInstance.put<{ namespace: string, cidrs: string[] }>(`/api/v1/namespaces/${encodeURIComponent(namespace)}/allowed-networks`, { cidrs });
+    },
+
+    // Repository access grants (repository admins only)
+    getRepositoryPermissions: async (repo: string) => {
+        return axiosInstance.get<{ repository: string, permissions: RepositoryPermission[] }>(`/api/v1/repositories/${encodeURIComponent(repo)}/permissions`);
+    },
+
+    setRepositoryPermission: async (repo: string, user: string, role: RepositoryPermission['role']) => {
+        return axiosInstance.put<{ repository: string, permissions: RepositoryPermission[] }>(`/api/v1/repositories/${encodeURIComponent(repo)}/permissions`, { user, role });
+    },
+
+    removeRepositoryPermission: async (repo: string, user: string) => {
+        return axiosInstance.delete(`/api/v1/repositories/${encodeURIComponent(repo)}/permissions/${encodeURIComponent(user)}`);
+    },
+
+    // Repository limits (updates are admin only)
+    getRepositoryLimits: async (repo: string) => {
+        return axiosInstance.get<RepositoryLimits>(`/api/v1/repositories/${encodeURIComponent(repo)}/limits`);
+    },
+
+    updateRepositoryLimits: async (repo: string, maxSizeBytes: number | null, maxTags: number | null) => {
+        return axiosInstance.put<RepositoryLimits>(`/api/v1/repositories/${encodeURIComponent(repo)}/limits`, { maxSizeBytes, maxTags });
+    },
+
+    // Alert rules (repository admins)
+    getAlertRules: async (repo: string) => {
+        return axiosInstance.get<{ repository: string, rules: AlertRule[] }>(`/api/v1/repositories/${encodeURIComponent(repo)}/alert-rules`);
+    },
+    createAlertRule: async (repo: string, rule: Partial<AlertRuleInput>) => {
+        return axiosInstance.post<AlertRule>(`/api/v1/repositories/${encodeURIComponent(repo)}/alert-rules`, rule);
+    },
+    updateAlertRule: async (repo: string, id: string, rule: AlertRuleInput) => {
+        return axiosInstance.put<AlertRule>(`/api/v1/repositories/${encodeURIComponent(repo)}/alert-rules/${id}`, rule);
+    },
+    deleteAlertRule: async (repo: string, id: string) => {
+        return axiosInstance.delete(`/api/v1/repositories/${encodeURIComponent(repo)}/alert-rules/${id}`);
+    },
+
+    // Security alerts (admin)
+    getSecurityAlerts: async (unacknowledgedOnly = false, limit?: number) => {
+        return axiosInstance.get<{ alerts: SecurityAlert[] }>('/api/v1/security/alerts', { params: { unacknowledged: unacknowledgedOnly || undefined, limit } });
+    },
+    acknowledgeSecurityAlert: async (id: string) => {
+        return axiosInstance.post(`/api/v1/security/alerts/${id}/acknowledge`);
+    },
+    getSecurityEvents: async (filter: { kind?: SecurityEvent['kind'], username?: string, ip?: string, since?: string, limit?: number } = {}) => {
+        return axiosInstance.get<{ events: SecurityEvent[] }>('/api/v1/security/events', { params: filter });
+    },
+
+    // Repository transfers
+    requestRepositoryTransfer: async (repo: string, namespace: string) => {
+        return axiosInstance.post<RepositoryTransfer>(`/api/v1/repositories/${encodeURIComponent(repo)}/transfer`, { namespace });
+    },
+
+    getTransfers: async () => {
+        return axiosInstance.get<{ transfers: RepositoryTransfer[] }>('/api/v1/transfers');
+    },
+
+    acceptTransfer: async (id: string) => {
+        return axiosInstance.post<RepositoryTransfer>(`/api/v1/transfers/${id}/accept`);
+    },
+
+    declineTransfer: async (id: string) => {
+        return axiosInstance.post<RepositoryTransfer>(`/api/v1/transfers/${id}/decline`);
+    },
+
     // Service Accounts
     getServiceAccounts: async () => {
         return axiosInstance.get<{ data: ServiceAccount[] }>('/api/v1/service-accounts');
     },
 
-    createServiceAccount: async (name: string, description: string) => {
-        return axiosInstance.post<{ account: ServiceAccount, apiKey: string }>('/api/v1/service-accounts', { name, description });
+    createServiceAccount: async (name: string, description: string, tokenTtlSeconds?: number, allowedCidrs?: string[]) => {
+        return axiosInstance.post<{ account: ServiceAccount, apiKey: string }>('/api/v1/service-accounts', { name, description, tokenTtlSeconds, allowedCidrs });
+    },
+
+    updateServiceAccountNetworks: async (id: string, cidrs: string[]) => {
+        return axiosInstance.put<ServiceAccount>(`/api/v1/service-accounts/${id}/allowed-networks`, { cidrs });
+    },
+
+    // Client certificate (mTLS) subjects and who they sign in as
+    getClientCertificates: async () => {
+        return axiosInstance.get<{ certificates: ClientCertificate[] }>('/api/v1/system/client-certificates');
+    },
+
+    createClientCertificate: async (mapping: { subject: string, username?: string, serviceAccount?: string }) => {
+        return axiosInstance.post<ClientCertificate>('/api/v1/system/client-certificates', mapping);
+    },
+
+    deleteClientCertificate: async (id: string) => {
+        return axiosInstance.delete(`/api/v1/system/client-certificates/${id}`);
     },
 
     revokeServiceAccount: async (id: string) => {
@@ -200,20 +648,31 @@ const customApi = {
         return axiosInstance.get<{ nodes: any[], edges: any[] }>(url);
     },
 
+    getDependencyImpact: async (query: { cve?: string, digest?: string }) => {
+        const params = query.cve ? `cve=${encodeURIComponent(query.cve)}` : `digest=${encodeURIComponent(query.digest || '')}`;
+        return axiosInstance.get<ImpactReport>(`/api/v1/dependencies/impact?${params}`);
+    },
+
+    getRebuildRecommendations: async () => {
+        return axiosInstance.get<RebuildRecommendation[]>('/api/v1/rebuild-recommendations');
+    },
+
     // Scan Features
     getScanStatus: async (repo: string, reference: string) => {
         return axiosInstance.get<ScanStatus>(`/api/v1/repositories/${encodeURIComponent(repo)}/manifests/${reference}/scan/status`);
     },
 
-    downloadScanReport: async (repo: string, reference: string) => {
+    downloadScanReport: async (repo: string, reference: string, format: ScanReportFormat = 'json') => {
         const response = await axiosInstance.get(`/api/v1/repositories/${encodeURIComponent(repo)}/manifests/${reference}/scan/report`, {
+            params: { format },
             responseType: 'blob'
         });
         // Create download link
         const url = window.URL.createObjectURL(new Blob([response.data]));
         const link = document.createElement('a');
         link.href = url;
-        link.setAttribute('download', `trivy-report-${repo}-${reference}.json`);
+        const ext = { json: 'json', sarif: 'sarif', cyclonedx: 'cdx.json', vdr: 'vdr.json' }[format];
+        link.setAttribute('download', `trivy-report-${repo.replace(/\//g, '_')}-${reference}.${ext}`);
         document.body.appendChild(link);
         link.click();
         link.remove();
@@ -238,6 +697,94 @@ const customApi = {
         return axiosInstance.get<{ enableCostIntelligence: boolean }>('/api/v1/system/config');
     },
 
+    // Maintenance Mode (Admin)
+    getMaintenance: async () => {
+        return axiosInstance.get<MaintenanceState>('/api/v1/system/maintenance');
+    },
+    setMaintenance: async (enabled: boolean, reason?: string, retryAfterSeconds?: number) => {
+        return axiosInstance.put<MaintenanceState>('/api/v1/system/maintenance', { enabled, reason, retryAfterSeconds });
+    },
+
+    // Layer deduplication savings
+    getDedupReport: async () => {
+        return axiosInstance.get<{ namespaces: DedupStats[], total: DedupStats }>('/api/v1/costs/dedup');
+    },
+
+    getCostRecommendations: async () => {
+        return axiosInstance.get<{ total_savings_usd: number, recommendations: CostRecommendation[] }>('/api/v1/costs/recommendations');
+    },
+    getCostRefreshHistory: async (limit?: number) => {
+        return axiosInstance.get<{ data: CostRefreshRun[] }>('/api/v1/costs/refresh/history', { params: { limit } });
+    },
+    getRepositoryCosts: async (repo: string, days?: number) => {
+        return axiosInstance.get<RepositoryCosts>(`/api/v1/costs/repositories/${encodeURIComponent(repo)}`, { params: { days } });
+    },
+
+    // Regional storage replicas (admin)
+    getReplicas: async () => {
+        return axiosInstance.get<{ primaryRegion: string, redirect: boolean, data: ReplicaStatus[] }>('/api/v1/system/replicas');
+    },
+    syncReplicas: async () => {
+        return axiosInstance.post('/api/v1/system/replicas/sync');
+    },
+
+    // Vulnerability DB for offline installs (admin)
+    getTrivyDB: async () => {
+        return axiosInstance.get<TrivyDBStatus>('/api/v1/system/trivy-db');
+    },
+    importTrivyDB: async (source: { path?: string, url?: string } | Blob) => {
+        if (source instanceof Blob) {
+            return axiosInstance.post<TrivyDBStatus>('/api/v1/system/trivy-db/import', source, {
+                headers: { 'Content-Type': 'application/gzip' }
+            });
+        }
+        return axiosInstance.post<TrivyDBStatus>('/api/v1/system/trivy-db/import', source);
+    },
+    // Prioritized vulnerabilities across many manifests, one entry per CVE
+    getFleetPriorities: async (scope: { manifestIds?: string[], repositories?: string[] }, limit?: number) => {
+        return axiosInstance.post<FleetPriorities>('/api/v1/vulnerabilities/prioritized/bulk', { ...scope, limit });
+    },
+    importEPSS: async (source: { path?: string } | Blob) => {
+        if (source instanceof Blob) {
+            return axiosInstance.post<EPSSImport>('/api/v1/vulnerabilities/epss/import', source, {
+                headers: { 'Content-Type': source.type || 'application/gzip' }
+            });
+        }
+        return axiosInstance.post<EPSSImport>('/api/v1/vulnerabilities/epss/import', source);
+    },
+
+    // Issued registry tokens (admin)
+    getRegistryTokens: async (subject?: string) => {
+        return axiosInstance.get<{ data: RegistryToken[] }>('/api/v1/system/registry-tokens', { params: { subject } });
+    },
+    revokeRegistryToken: async (id: string) => {
+        return axiosInstance.delete(`/api/v1/system/registry-tokens/${id}`);
+    },
+    revokeRegistryTokensFor: async (subject: string) => {
+        return axiosInstance.post<{ revoked: number }>('/api/v1/system/registry-tokens/revoke', { subject });
+    },
+
+    // Token signing keys (admin)
+    getSigningKeys: async () => {
+        return axiosInstance.get<{ data: SigningKey[] }>('/api/v1/system/signing-keys');
+    },
+    rotateSigningKey: async () => {
+        return axiosInstance.post<SigningKey>('/api/v1/system/signing-keys/rotate');
+    },
+
+    // CI build metadata
+    getBuildMetadata: async (repo: string, reference: string) => {
+        return axiosInstance.get<BuildMetadata>(`/api/v1/repositories/${encodeURIComponent(repo)}/manifests/${reference}/build`);
+    },
+    setBuildMetadata: async (repo: string, reference: string, build: Pick<BuildMetadata, 'pipelineUrl' | 'commitSha' | 'branch' | 'builder'>) => {
+        return axiosInstance.put<BuildMetadata>(`/api/v1/repositories/${encodeURIComponent(repo)}/manifests/${reference}/build`, build);
+    },
+
+    // Image build history
+    getManifestHistory: async (repo: string, reference: string) => {
+        return axiosInstance.get<{ digest: string; configDigest: string; totalSize: number; steps: HistoryStep[] }>(`/api/v1/repositories/${encodeURIComponent(repo)}/manifests/${reference}/history`);
+    },
+
     // Sessions (Admin)
     getActiveSessions: async () => {
         return axiosInstance.get<any[]>('/api/v1/system/sessions');
@@ -0,0 +1,59 @@
+import { useEffect, useRef, useState } from 'react';
+
+export type RegistryEventType =
+    | 'push'
+    | 'scan.started'
+    | 'scan.progress'
+    | 'scan.completed'
+    | 'scan.failed'
+    | 'policy.denied'
+    | 'gc.completed';
+
+export interface RegistryEvent {
+    type: RegistryEventType;
+    repository?: string;
+    reference?: string;
+    digest?: string;
+    user?: string;
+    data?: Record<string, unknown>;
+    timestamp: string;
+}
+
+const EVENT_TYPES: RegistryEventType[] = ['push', 'scan.started', 'scan.progress', 'scan.completed', 'scan.failed', 'policy.denied', 'gc.completed'];
+
+// Subscribes to GET /api/v1/events/stream. Returns whether the stream is
+// currently connected so callers can fall back to polling when it is not.
+export function useRegistryEvents(onEvent: (event: RegistryEvent) => void, types?: RegistryEventType[]): boolean {
+    const [connected, setConnected] = useState(false);
+    const handlerRef = useRef(onEvent);
+    handlerRef.current = onEvent;
+
+    const typesKey = (types || EVENT_TYPES).join(',');
+
+    useEffect(() => {
+        const token = sessionStorage.getItem('registryx_token') || sessionStorage.getItem('token');
+        if (!token) return;
+
+        const params = new URLSearchParams({ access_token: token, types: typesKey });
+        const source = new EventSource(`/api/v1/events/stream?${params.toString()}`);
+
+        const listener = (e: MessageEvent) => {
+            try {
+                handlerRef.current(JSON.parse(e.data));
+            } catch (err) {
+                console.error('Invalid registry event', err);
+            }
+        };
+
+        source.onopen = () => setConnected(true);
+        source.onerror = () => setConnected(false);
+        typesKey.split(',').forEach(t => source.addEventListener(t, listener as EventListener));
+
+        return () => {
+            source.close();
+            setConnected(false);
+        };
+    }, [typesKey]);
+
+    return connected;
+}
@@ -13,6 +13,9 @@ interface CostDashboard {
     potential_savings_usd: number;
     top_expensive_images: ImageCost[];
     cost_trend: string;
+    effective_storage_cost_usd: number;
+    dedup_savings_ratio: number;
+    last_refreshed_at?: string;
 }
 
 interface ImageCost {
@@ -76,7 +79,9 @@ export default function CostIntelligence() {
                 zombie_images: 0,
                 potential_savings_usd: 0,
                 top_expensive_images: [],
-                cost_trend: 'stable'
+                cost_trend: 'stable',
+                effective_storage_cost_usd: 0,
+                dedup_savings_ratio: 0
             });
             setZombies([]);
         } finally {
@@ -175,6 +180,9 @@ export default function CostIntelligence() {
                 <div>
                     <h1 className="text-4xl font-black uppercase tracking-tighter text-white">Finance Core</h1>
                     <p className="text-blue-400 font-mono text-sm tracking-[0.2em] uppercase opacity-70">Infrastructure Cost Analysis & Yield Optimization</p>
+                    <p className="text-gray-500 font-mono text-xs tracking-widest uppercase mt-1">
+                        Last Refresh: {dashboard.last_refreshed_at ? new Date(dashboard.last_refreshed_at).toLocaleString() : 'Never'}
+                    </p>
                 </div>
 
                 <button
@@ -205,6 +213,7 @@ export default function CostIntelligence() {
                     label="ALLOCATED ASSETS"
                     value={dashboard.total_images.toString()}
                     sub="Active Metadata Entities"
+                    sub2={`Dedup: ${((dashboard.dedup_savings_ratio || 0) * 100).toFixed(1)}% -> ${formatCurrency(dashboard.effective_storage_cost_usd || 0)}`}
                     accent="green"
                 />
                 <StatCard
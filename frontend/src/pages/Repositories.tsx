@@ -1,7 +1,7 @@
 import React, { useState, useEffect } from 'react';
 import { Search, Filter, Box, Tag, Plus, Terminal, Copy, Check, Trash2, ArrowRight, ShieldCheck, Database, LayoutGrid, List as ListIcon } from 'lucide-react';
 import { useQuery, useQueryClient } from '@tanstack/react-query';
-import { registry } from '../lib/api';
+import { registry, RepositorySummary } from '../lib/api';
 import { Link, useSearchParams } from 'react-router-dom';
 import clsx from 'clsx';
 
@@ -44,10 +44,12 @@ const Repositories = () => {
 
     const { data: catalogData, isLoading: isCatalogLoading } = useQuery({
         queryKey: ['catalog'],
-        queryFn: registry.getCatalog
+        queryFn: registry.listRepositories
     });
 
-    const repoList = catalogData?.data?.repositories || [];
+    const summaries: RepositorySummary[] = catalogData?.data?.repositories || [];
+    const summaryByName = new Map(summaries.map(s => [s.name, s]));
+    const repoList = summaries.map(s => s.name);
     const filteredRepos = repoList.filter((name: string) => name.toLowerCase().includes(searchTerm.toLowerCase()));
 
     const handleCopy = (text: string) => {
@@ -178,6 +180,7 @@ const Repositories = () => {
                         <RepositoryComponent
                             key={name}
                             name={name}
+                            summary={summaryByName.get(name)}
                             onCopy={handleCopy}
                             viewMode={viewMode}
                             onRequestDelete={setRepoToDelete}
@@ -296,16 +299,10 @@ const Repositories = () => {
 };
 
 // ... RepositoryComponent changes ....
-const RepositoryComponent = ({ name, onCopy, viewMode, onRequestDelete }: { name: string, onCopy: (txt: string) => void, viewMode: 'grid' | 'list', onRequestDelete: (name: string) => void }) => {
-    // ... hooks same ...
-    const { data: tagData } = useQuery({
-        queryKey: ['tags', name],
-        queryFn: () => registry.getTags(name)
-    });
-
-    const tags = tagData?.data?.tags || [];
-    const displayTags = tags.filter((t: string) => !t.endsWith('.sig'));
-    const latestTag = displayTags.length > 0 ? displayTags[0] : 'latest';
+const RepositoryComponent = ({ name, summary, onCopy, viewMode, onRequestDelete }: { name: string, summary?: RepositorySummary, onCopy: (txt: string) => void, viewMode: 'grid' | 'list', onRequestDelete: (name: string) => void }) => {
+    // Tag count and latest tag come from the repository listing; no per-repo requests.
+    const tagCount = summary?.tagCount || 0;
+    const latestTag = summary?.latestTag || 'latest';
     const pullCommand = `docker pull localhost:5000/${name}:${latestTag}`;
     const [copied, setCopied] = useState(false);
 
@@ -335,7 +332,7 @@ const RepositoryComponent = ({ name, onCopy, viewMode, onRequestDelete }: { name
                     <div className="flex-1">
                         <h3 className="text-lg font-black uppercase tracking-tight text-white mb-1 group-hover:text-blue-400 transition-colors">{name}</h3>
                         <div className="flex items-center gap-4 text-[10px] font-mono uppercase text-gray-500">
-                            <span className="flex items-center gap-1"><Tag size={10} /> {displayTags.length} TAGS</span>
+                            <span className="flex items-center gap-1"><Tag size={10} /> {tagCount} TAGS</span>
                             <span className="flex items-center gap-1 text-green-500"><ShieldCheck size={10} /> VERIFIED</span>
                         </div>
                     </div>
@@ -380,7 +377,7 @@ const RepositoryComponent = ({ name, onCopy, viewMode, onRequestDelete }: { name
                             <span className="w-1.5 h-1.5 rounded-full bg-green-500" /> ACTIVE
                         </span>
                         <div className="flex items-center gap-1 px-2 py-1 bg-white/5 rounded-lg text-[10px] font-mono text-gray-500">
-                            <Tag size={10} /> {displayTags.length}
+                            <Tag size={10} /> {tagCount}
                         </div>
                     </div>
                 </div>
@@ -1,7 +1,8 @@
 import React, { useState, useEffect } from 'react';
 import { useParams, Link } from 'react-router-dom';
 import { useQuery, useQueryClient } from '@tanstack/react-query';
-import { api, registry, ScanStatus, ScanHistoryEntry } from '../lib/api';
+import { api, registry, ScanStatus, ScanStage, ScanHistoryEntry, LintFinding, ScanReportFormat } from '../lib/api';
+import { useRegistryEvents } from '../lib/events';
 import { Shield, ShieldAlert, CheckCircle, XCircle, Trash2, ArrowLeft, Download, Clock, RefreshCw, History, Eye, X, Activity, Database, Fingerprint, Zap } from 'lucide-react';
 import clsx from 'clsx';
 import { HealthBadge } from '../components/HealthBadge';
@@ -68,10 +69,10 @@ export default function RepositoryDetails() {
         }
     };
 
-    const handleDownloadReport = async () => {
+    const handleDownloadReport = async (format: ScanReportFormat = 'json') => {
         if (!name || !selectedTag) return;
         try {
-            await api.downloadScanReport(name, selectedTag);
+            await api.downloadScanReport(name, selectedTag, format);
         } catch (e) {
             console.error("Failed to download report", e);
             setAlertMessage("DOWNLOAD_FAILURE");
@@ -89,7 +90,9 @@ export default function RepositoryDetails() {
                     ...old.data,
                     status: 'scanning',
                     progress_message: 'Calibrating neural scanners...',
-                    scanned_at: new Date().toISOString()
+                    scanned_at: new Date().toISOString(),
+                    stage: undefined,
+                    stages: []
                 }
             };
         });
@@ -99,7 +102,7 @@ export default function RepositoryDetails() {
         } catch (e: any) {
             console.error("Failed to trigger scan", e);
             queryClient.invalidateQueries({ queryKey: ['scanStatus', name, selectedTag] });
-            setAlertMessage("SCAN_INIT_FAILURE");
+            setAlertMessage(e?.response?.status === 429 ? "SCAN_RATE_LIMITED" : "SCAN_INIT_FAILURE");
         }
     };
 
@@ -121,11 +124,47 @@ export default function RepositoryDetails() {
         enabled: !!selectedTag,
     });
 
+    // Live updates: refresh scan state and tags as soon as the backend reports them.
+    const streamConnected = useRegistryEvents((event) => {
+        if (event.repository !== name) return;
+        if (event.type === 'push') {
+            queryClient.invalidateQueries({ queryKey: ['tags', name] });
+        }
+        if (event.type === 'scan.progress') {
+            // Stage updates carry everything the panel needs; skip the refetch.
+            if (event.reference !== selectedTag) return;
+            const stage = event.data?.stage as ScanStage['stage'];
+            const detail = event.data?.detail as string | undefined;
+            queryClient.setQueryData(['scanStatus', name, selectedTag], (old: { data: ScanStatus } | undefined) => {
+                if (!old) return old;
+                return {
+                    ...old,
+                    data: {
+                        ...old.data,
+                        status: 'scanning',
+                        stage,
+                        stage_detail: detail,
+                        stage_updated_at: event.timestamp,
+                        stages: [...(old.data.stages || []), { stage, detail, at: event.timestamp }]
+                    }
+                };
+            });
+            return;
+        }
+        if (event.reference === selectedTag) {
+            queryClient.invalidateQueries({ queryKey: ['scanStatus', name, selectedTag] });
+            queryClient.invalidateQueries({ queryKey: ['manifest', name, selectedTag] });
+            queryClient.invalidateQueries({ queryKey: ['scanHistory', name, selectedTag] });
+        }
+    }, ['push', 'scan.started', 'scan.progress', 'scan.completed', 'scan.failed']);
+
     const { data: scanStatusData, refetch: refetchScanStatus } = useQuery({
         queryKey: ['scanStatus', name, selectedTag],
         queryFn: () => api.getScanStatus(name!, selectedTag!),
         enabled: !!selectedTag && !!name,
         refetchInterval: (query: any) => {
+            // Only poll when the event stream is unavailable.
+            if (streamConnected) return false;
             const status = query.state.data?.data?.status;
             return status === 'scanning' ? 2000 : false;
         },
@@ -255,12 +294,23 @@ export default function RepositoryDetails() {
                                                 >
                                                     <Eye size={16} /> Evaluate Remedies
                                                 </button>
-                                                <button
-                                                    onClick={handleDownloadReport}
-                                                    className="flex items-center justify-center gap-3 px-6 py-4 bg-white/5 text-gray-400 rounded-2xl font-black uppercase text-[10px] tracking-widest hover:bg-white/10 transition-all border border-white/5"
-                                                >
-                                                    <Download size={16} /> Export JSON
-                                                </button>
+                                                <div className="grid grid-cols-2 gap-2">
+                                                    {([
+                                                        ['json', 'JSON', "Trivy's full report"],
+                                                        ['sarif', 'SARIF', 'SARIF 2.1.0, for GitHub code scanning'],
+                                                        ['cyclonedx', 'SBOM', 'CycloneDX SBOM of the image'],
+                                                        ['vdr', 'VDR', 'CycloneDX vulnerability disclosure report, linked to the SBOM'],
+                                                    ] as [ScanReportFormat, string, string][]).map(([format, label, title]) => (
+                                                        <button
+                                                            key={format}
+                                                            onClick={() => handleDownloadReport(format)}
+                                                            title={title}
+                                                            className="flex items-center justify-center gap-2 px-3 py-2 bg-white/5 text-gray-400 rounded-xl font-black uppercase text-[10px] tracking-widest hover:bg-white/10 transition-all border border-white/5"
+                                                        >
+                                                            <Download size={12} /> {label}
+                                                        </button>
+                                                    ))}
+                                                </div>
                                             </div>
 
                                             {scanStatus.status !== 'scanning' && (
@@ -288,10 +338,90 @@ export default function RepositoryDetails() {
                                             <div className="h-24 flex items-center justify-center border border-dashed border-white/5 rounded-2xl text-[10px] font-mono text-gray-600 uppercase">NO_DATA_AVAILABLE</div>
                                         )}
                                     </div>
+
+                                    {/* Best-Practice Lint */}
+                                    {details.lint && (
+                                        <div className="cyber-card p-8">
+                                            <h3 className="text-[10px] font-black text-gray-500 uppercase tracking-widest mb-6">Build Hygiene</h3>
+                                            {details.lint.length > 0 ? (
+                                                <div className="space-y-3">
+                                                    {details.lint.map((f: LintFinding, idx: number) => (
+                                                        <div key={idx} className="flex items-start gap-3 p-4 bg-black/40 rounded-xl border border-white/5">
+                                                            <span className={clsx(
+                                                                "text-[8px] font-black uppercase tracking-widest px-2 py-1 rounded border shrink-0",
+                                                                f.severity === 'high' ? "bg-red-600/10 text-red-500 border-red-500/20" :
+                                                                f.severity === 'medium' ? "bg-orange-600/10 text-orange-500 border-orange-500/20" :
+                                                                "bg-blue-600/10 text-blue-500 border-blue-500/20"
+                                                            )}>{f.severity}</span>
+                                                            <div className="space-y-1 min-w-0">
+                                                                <div className="text-xs text-white break-words">{f.message}</div>
+                                                                <div className="text-[8px] font-mono text-gray-600 uppercase">{f.rule}</div>
+                                                            </div>
+                                                        </div>
+                                                    ))}
+                                                </div>
+                                            ) : (
+                                                <div className="flex items-center gap-3 text-[10px] font-mono text-green-500 uppercase"><CheckCircle size={14} /> No issues found</div>
+                                            )}
+                                        </div>
+                                    )}
                                 </div>
 
                                 {/* Sidebar Diagnostics */}
                                 <div className="lg:col-span-2 space-y-8">
+                                    {/* Build Provenance */}
+                                    {details.build && (
+                                        <div className="cyber-card p-8 space-y-4">
+                                            <div className="flex items-center justify-between">
+                                                <h3 className="text-[10px] font-black text-gray-500 uppercase tracking-widest">Build Origin</h3>
+                                                <span
+                                                    title={details.build.verificationError}
+                                                    className={clsx(
+                                                        "text-[8px] font-black uppercase tracking-widest px-2 py-1 rounded border",
+                                                        details.build.verified ? "bg-green-600/10 text-green-500 border-green-500/20" : "bg-white/5 text-gray-500 border-white/10"
+                                                    )}
+                                                >
+                                                    {details.build.verified ? 'ATTESTED' : details.build.provenance ? 'UNVERIFIED' : 'REPORTED'}
+                                                </span>
+                                            </div>
+                                            {details.build.sourceRepository && (
+                                                <div className="space-y-1">
+                                                    <div className="text-[8px] text-gray-600 font-black uppercase">Source</div>
+                                                    <div className="text-xs text-white font-mono break-all">{details.build.sourceRepository.replace(/^https:\/\//, '')}</div>
+                                                </div>
+                                            )}
+                                            {details.build.workflow && (
+                                                <div className="space-y-1">
+                                                    <div className="text-[8px] text-gray-600 font-black uppercase">Workflow</div>
+                                                    <div className="text-xs text-white font-mono break-all">{details.build.workflow}</div>
+                                                </div>
+                                            )}
+                                            {details.build.commitSha && (
+                                                <div className="space-y-1">
+                                                    <div className="text-[8px] text-gray-600 font-black uppercase">Commit</div>
+                                                    <div className="text-xs text-white font-mono" title={details.build.commitSha}>{details.build.commitSha.substring(0, 12)}{details.build.branch && <span className="text-gray-500"> @ {details.build.branch}</span>}</div>
+                                                </div>
+                                            )}
+                                            {!details.build.commitSha && details.build.branch && (
+                                                <div className="space-y-1">
+                                                    <div className="text-[8px] text-gray-600 font-black uppercase">Branch</div>
+                                                    <div className="text-xs text-white font-mono">{details.build.branch}</div>
+                                                </div>
+                                            )}
+                                            {details.build.builder && (
+                                                <div className="space-y-1">
+                                                    <div className="text-[8px] text-gray-600 font-black uppercase">Builder</div>
+                                                    <div className="text-xs text-white font-mono">{details.build.builder}</div>
+                                                </div>
+                                            )}
+                                            {details.build.pipelineUrl && (
+                                                <a href={details.build.pipelineUrl} target="_blank" rel="noopener noreferrer" className="block text-[9px] font-black text-blue-500 hover:text-blue-400 uppercase tracking-widest">
+                                                    [OPEN PIPELINE]
+                                                </a>
+                                            )}
+                                        </div>
+                                    )}
+
                                     {/* Health Gauge */}
                                     <div className="cyber-card p-8 relative overflow-hidden">
                                         <div className="absolute top-0 right-0 p-4 opacity-5 text-white/20"><Activity size={120} /></div>
@@ -344,6 +474,19 @@ export default function RepositoryDetails() {
                                                                 <span className="text-yellow-500">M:{scan.summary.medium}</span>
                                                             </div>
                                                         )}
+                                                        {scan.delta && (
+                                                            <div
+                                                                className="flex gap-4 text-[9px] font-mono"
+                                                                title={[
+                                                                    ...scan.delta.new.slice(0, 10).map(f => `+ ${f.cve} ${f.package} (${f.severity})`),
+                                                                    ...scan.delta.fixed.slice(0, 10).map(f => `- ${f.cve} ${f.package} (${f.severity})`),
+                                                                ].join('\n')}
+                                                            >
+                                                                <span className={scan.delta.newCount > 0 ? "text-red-400" : "text-gray-600"}>+{scan.delta.newCount} NEW</span>
+                                                                <span className={scan.delta.fixedCount > 0 ? "text-green-400" : "text-gray-600"}>-{scan.delta.fixedCount} FIXED</span>
+                                                                <span className="text-gray-600">={scan.delta.existingCount}</span>
+                                                            </div>
+                                                        )}
                                                     </div>
                                                 )) : <div className="text-center font-mono text-[10px] text-gray-700 py-10 uppercase">NO_HISTORY_LOGGED</div>}
                                             </div>
@@ -480,7 +623,9 @@ function ScanStatusPanel({ status }: { status: ScanStatus }) {
             </div>
 
             {status.status === 'scanning' && (
-                <ScanningProgressUI message={status.progress_message} />
+                status.stage
+                    ? <ScanStagesUI status={status} />
+                    : <ScanningProgressUI message={status.progress_message} />
             )}
 
             {status.error && (
@@ -492,6 +637,50 @@ function ScanStatusPanel({ status }: { status: ScanStatus }) {
     );
 }
 
+const SCAN_STAGE_LABELS: Record<ScanStage['stage'], string> = {
+    starting: 'LAUNCHING_SCANNER',
+    db_download: 'SYNCING_VULN_DATABASE',
+    analyzing: 'ANALYZING_LAYERS',
+    detecting: 'MATCHING_PACKAGES',
+    saving: 'STORING_REPORT',
+    completed: 'PROTOCOL_COMPLETE',
+};
+
+// Real stages reported by the scanner, with how long each one took. The
+// current stage's timer keeps running so a slow scan can be told from a stuck one.
+function ScanStagesUI({ status }: { status: ScanStatus }) {
+    const [now, setNow] = useState(Date.now());
+
+    useEffect(() => {
+        const interval = setInterval(() => setNow(Date.now()), 1000);
+        return () => clearInterval(interval);
+    }, []);
+
+    const stages = status.stages || [];
+    const elapsed = (from: string, to?: string) => {
+        const secs = Math.max(0, Math.round(((to ? new Date(to).getTime() : now) - new Date(from).getTime()) / 1000));
+        return secs >= 60 ? `${Math.floor(secs / 60)}M ${secs % 60}S` : `${secs}S`;
+    };
+
+    return (
+        <div className="space-y-2 mt-2 animate-in fade-in duration-300">
+            {stages.map((s, i) => {
+                const current = i === stages.length - 1;
+                return (
+                    <div key={i} className="flex justify-between text-[10px] font-mono font-black uppercase tracking-widest">
+                        <span className={current ? 'text-blue-400 animate-pulse' : 'text-gray-500'}>
+                            {SCAN_STAGE_LABELS[s.stage] || s.stage}{s.detail ? ` [${s.detail}]` : ''}
+                        </span>
+                        <span className={current ? 'text-white' : 'text-gray-500'}>
+                            {elapsed(s.at, current ? undefined : stages[i + 1].at)}
+                        </span>
+                    </div>
+                );
+            })}
+        </div>
+    );
+}
+
 function ScanningProgressUI({ message }: { message?: string }) {
     const [progress, setProgress] = useState(10);
     const [displayMsg, setDisplayMsg] = useState(message || 'Initializing Protocol...');